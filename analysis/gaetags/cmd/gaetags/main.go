@@ -0,0 +1,16 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Command gaetags checks the `gae` struct tags of datastore models. It can be
+// run standalone (`gaetags ./...`), or via `go vet -vettool=$(which gaetags)`.
+package main
+
+import (
+	"github.com/tetrafolium/gae/analysis/gaetags"
+	"golang.org/x/tools/go/analysis/singlechecker"
+)
+
+func main() {
+	singlechecker.Main(gaetags.Analyzer)
+}
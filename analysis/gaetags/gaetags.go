@@ -0,0 +1,420 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package gaetags implements a static analyzer which checks the `gae:"..."`
+// struct tags of datastore models.
+//
+// It reports the same problems that datastore.GetPLS reports (by panicking)
+// at runtime, such as repeated property names, invalid property names,
+// unsupported field types (e.g. uint64), recursively defined structs and bad
+// meta fields, but at build time.
+//
+// Any struct type which has at least one field with a `gae` tag is checked.
+package gaetags
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/tools/go/analysis"
+)
+
+const dsPkgPath = "github.com/tetrafolium/gae/service/datastore"
+
+// Analyzer checks the `gae` struct tags of datastore models.
+var Analyzer = &analysis.Analyzer{
+	Name: "gaetags",
+	Doc:  "check gae struct tags of datastore models for problems that GetPLS would report at runtime",
+	Run:  run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	for _, f := range pass.Files {
+		ast.Inspect(f, func(n ast.Node) bool {
+			ts, ok := n.(*ast.TypeSpec)
+			if !ok {
+				return true
+			}
+			astStruct, ok := ts.Type.(*ast.StructType)
+			if !ok || !hasGAETag(astStruct) {
+				return true
+			}
+			obj := pass.TypesInfo.Defs[ts.Name]
+			if obj == nil {
+				return true
+			}
+			st, ok := obj.Type().Underlying().(*types.Struct)
+			if !ok {
+				return true
+			}
+
+			c := &checker{visiting: map[types.Type]bool{obj.Type(): true}}
+			c.check(st, func(i int, msg string) {
+				pass.Reportf(fieldNode(astStruct, i).Pos(), "%s: %s", ts.Name.Name, msg)
+			})
+			return true
+		})
+	}
+	return nil, nil
+}
+
+func hasGAETag(st *ast.StructType) bool {
+	for _, f := range st.Fields.List {
+		if f.Tag == nil {
+			continue
+		}
+		raw, err := strconv.Unquote(f.Tag.Value)
+		if err != nil {
+			continue
+		}
+		if _, ok := reflect.StructTag(raw).Lookup("gae"); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldNode returns the AST node for the i'th field of st (counting each
+// name of a multi-name field separately).
+func fieldNode(st *ast.StructType, i int) ast.Node {
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			if i == 0 {
+				return f
+			}
+			i--
+			continue
+		}
+		if i < len(f.Names) {
+			return f.Names[i]
+		}
+		i -= len(f.Names)
+	}
+	return st
+}
+
+type checker struct {
+	visiting map[types.Type]bool
+}
+
+// check checks the struct st, calling report for each problem found. It
+// returns the flattened property names of st, and whether any of them are
+// repeated (slices).
+func (c *checker) check(st *types.Struct, report func(i int, msg string)) (names map[string]bool, hasSlice bool) {
+	names = map[string]bool{}
+	metas := map[string]bool{}
+	hasExtra := false
+
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		ft := f.Type()
+		rep := func(format string, args ...interface{}) {
+			report(i, fmt.Sprintf(format, args...))
+		}
+
+		name := reflect.StructTag(st.Tag(i)).Get("gae")
+		opts := ""
+		if idx := strings.Index(name, ","); idx != -1 {
+			name, opts = name[:idx], name[idx+1:]
+		}
+
+		if opts == "extra" {
+			switch {
+			case hasExtra:
+				rep("struct has multiple fields tagged as 'extra'")
+			case name != "" && name != "-":
+				rep("struct 'extra' field has invalid name %s, expecing `` or `-`", name)
+			case !isDSType(ft, "PropertyMap"):
+				rep("struct 'extra' field has invalid type %s, expecing PropertyMap", ft)
+			}
+			hasExtra = true
+			continue
+		}
+		if opts != "" && !strings.HasPrefix(name, "$") {
+			for _, o := range strings.Split(opts, ",") {
+				switch {
+				case o == "noindex", o == "index":
+				case strings.HasPrefix(o, "alias="):
+					for _, a := range strings.Split(o[len("alias="):], "|") {
+						if !validPropertyName(a) {
+							rep("struct tag has invalid alias: %q", a)
+						}
+					}
+				default:
+					rep("unknown struct tag option %q", o)
+				}
+			}
+		}
+
+		convert := isConverter(ft)
+		switch {
+		case name == "":
+			if !f.Anonymous() {
+				name = f.Name()
+			}
+		case name[0] == '$':
+			key := name[1:]
+			if metas[key] {
+				rep("meta field %q set multiple times", name)
+			}
+			metas[key] = true
+			if key == "id" && strings.HasPrefix(opts, "fmt=") {
+				// A composite $id; GetPLS validates its format and fields.
+				continue
+			}
+			if !convert {
+				if err := checkMeta(key, opts, ft); err != nil {
+					rep("meta field %q has bad type: %s", name, err)
+				}
+			}
+			continue
+		case name == "-":
+			continue
+		default:
+			if !validPropertyName(name) {
+				rep("struct tag has invalid property name: %q", name)
+				continue
+			}
+		}
+		if !f.Exported() {
+			continue
+		}
+
+		isSlice := false
+		var substruct types.Type
+		if !convert {
+			switch u := ft.Underlying().(type) {
+			case *types.Struct:
+				if !isTimeOrGeoPoint(ft) {
+					substruct = ft
+				}
+			case *types.Slice:
+				if isConverter(u.Elem()) {
+					convert = true
+				} else if _, ok := u.Elem().Underlying().(*types.Struct); ok && !isTimeOrGeoPoint(u.Elem()) {
+					substruct = u.Elem()
+				}
+				isSlice = !isByte(u.Elem())
+			case *types.Array:
+				if isConverter(u.Elem()) {
+					convert = true
+				} else if _, ok := u.Elem().Underlying().(*types.Struct); ok && !isTimeOrGeoPoint(u.Elem()) {
+					substruct = u.Elem()
+				}
+				isSlice = !isByte(u.Elem())
+			case *types.Interface:
+				rep("field %q has non-concrete interface type %s", f.Name(), ft)
+				continue
+			}
+		}
+
+		if substruct != nil {
+			if c.visiting[substruct] {
+				rep("field %q is recursively defined", f.Name())
+				continue
+			}
+			c.visiting[substruct] = true
+			problem := ""
+			subNames, subSlice := c.check(substruct.Underlying().(*types.Struct), func(_ int, msg string) {
+				if problem == "" {
+					problem = msg
+				}
+			})
+			delete(c.visiting, substruct)
+			if problem != "" {
+				rep("field %q has problem: %s", f.Name(), problem)
+				continue
+			}
+			if isSlice && subSlice {
+				rep("flattening nested structs leads to a slice of slices: field %q", f.Name())
+				continue
+			}
+			hasSlice = hasSlice || isSlice || subSlice
+			if name != "" {
+				name += "."
+			}
+			for sub := range subNames {
+				if names[name+sub] {
+					rep("struct tag has repeated property name: %q", name+sub)
+				}
+				names[name+sub] = true
+			}
+			continue
+		}
+
+		if !convert {
+			t := ft
+			if isSlice {
+				switch u := t.Underlying().(type) {
+				case *types.Slice:
+					t = u.Elem()
+				case *types.Array:
+					t = u.Elem()
+				}
+			}
+			if p, ok := t.Underlying().(*types.Pointer); ok && !isDSKey(t) {
+				t = p.Elem()
+			}
+			if why := invalidType(t); why != "" {
+				rep("field %q has invalid type %s: %s", name, ft, why)
+				continue
+			}
+		}
+		hasSlice = hasSlice || isSlice
+		if names[name] {
+			rep("struct tag has repeated property name: %q", name)
+		}
+		names[name] = true
+	}
+	return
+}
+
+// invalidType returns a non-empty reason if t can't be stored in a Property.
+func invalidType(t types.Type) string {
+	if isDSKey(t) || isTimeOrGeoPoint(t) {
+		return ""
+	}
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch u.Kind() {
+		case types.Int, types.Int8, types.Int16, types.Int32, types.Int64,
+			types.Uint8, types.Uint16, types.Uint32,
+			types.Float32, types.Float64, types.Bool, types.String:
+			return ""
+		case types.Uint, types.Uint64, types.Uintptr:
+			return "unsigned integers wider than 32 bits are not supported"
+		}
+	case *types.Slice:
+		if isByte(u.Elem()) {
+			return ""
+		}
+		return "slices of slices are not supported"
+	case *types.Array:
+		if isByte(u.Elem()) {
+			return ""
+		}
+		return "nested arrays are not supported"
+	}
+	return "not a supported property type"
+}
+
+// checkMeta mirrors the datastore package's convertMeta, with additional
+// checks for the well-known meta keys.
+func checkMeta(key, dflt string, t types.Type) error {
+	switch key {
+	case "kind":
+		if b, ok := t.Underlying().(*types.Basic); !ok || b.Kind() != types.String {
+			return fmt.Errorf("$kind must be a string")
+		}
+	case "parent":
+		if !isDSKey(t) {
+			return fmt.Errorf("$parent must be a *Key")
+		}
+	case "id":
+		if b, ok := t.Underlying().(*types.Basic); !ok || b.Info()&(types.IsInteger|types.IsString) == 0 || isDSType(t, "Toggle") {
+			return fmt.Errorf("$id must be an integer or a string")
+		}
+	}
+
+	if isDSType(t, "Toggle") {
+		switch dflt {
+		case "on", "On", "true", "off", "Off", "false":
+			return nil
+		}
+		return fmt.Errorf("Toggle field has bad/missing default, got %q", dflt)
+	}
+	if isDSKey(t) {
+		if dflt != "" {
+			return fmt.Errorf("key field is not allowed to have a default: %q", dflt)
+		}
+		return nil
+	}
+	if b, ok := t.Underlying().(*types.Basic); ok {
+		switch b.Kind() {
+		case types.String:
+			return nil
+		case types.Int, types.Int8, types.Int16, types.Int32, types.Int64:
+			if dflt != "" {
+				if _, err := strconv.ParseInt(dflt, 10, 64); err != nil {
+					return err
+				}
+			}
+			return nil
+		case types.Uint8, types.Uint16, types.Uint32:
+			if dflt != "" {
+				if _, err := strconv.ParseUint(dflt, 10, 32); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("meta field with bad type/value %s/%q", t, dflt)
+}
+
+func isDSType(t types.Type, name string) bool {
+	n, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := n.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == dsPkgPath && obj.Name() == name
+}
+
+func isDSKey(t types.Type) bool {
+	p, ok := t.(*types.Pointer)
+	return ok && isDSType(p.Elem(), "Key")
+}
+
+func isTimeOrGeoPoint(t types.Type) bool {
+	if isDSType(t, "GeoPoint") {
+		return true
+	}
+	n, ok := t.(*types.Named)
+	return ok && n.Obj().Pkg() != nil && n.Obj().Pkg().Path() == "time" && n.Obj().Name() == "Time"
+}
+
+func isByte(t types.Type) bool {
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Kind() == types.Uint8
+}
+
+// isConverter returns true iff *t implements datastore.PropertyConverter.
+func isConverter(t types.Type) bool {
+	ms := types.NewMethodSet(types.NewPointer(t))
+	return hasMethod(ms, "ToProperty") && hasMethod(ms, "FromProperty")
+}
+
+func hasMethod(ms *types.MethodSet, name string) bool {
+	for i := 0; i < ms.Len(); i++ {
+		if ms.At(i).Obj().Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validPropertyName mirrors the datastore package's check: name must consist
+// of one or more valid Go identifiers joined by ".".
+func validPropertyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, s := range strings.Split(name, ".") {
+		if s == "" {
+			return false
+		}
+		for i, c := range s {
+			if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
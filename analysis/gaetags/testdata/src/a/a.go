@@ -0,0 +1,109 @@
+package a
+
+import (
+	"time"
+
+	"github.com/tetrafolium/gae/service/datastore"
+)
+
+type Good struct {
+	_kind  string           `gae:"$kind,Good"`
+	ID     int64            `gae:"$id"`
+	Parent *datastore.Key   `gae:"$parent"`
+	Flag   datastore.Toggle `gae:"$flag,true"`
+
+	Name   string `gae:"name"`
+	Small  uint32
+	Blob   []byte `gae:",noindex"`
+	When   time.Time
+	Where  datastore.GeoPoint
+	Maybe  *int64
+	Inner  Inner
+	Many   []Flat `gae:"many"`
+	Conv   Conv
+	Extra  datastore.PropertyMap `gae:",extra"`
+	hidden uint64
+}
+
+type GoodComposite struct {
+	_    struct{} `gae:"$id,fmt=%s|%d,fields=Name Rev"`
+	Name string   `gae:"-"`
+	Rev  int64    `gae:"-"`
+}
+
+type GoodNoIndex struct {
+	_    struct{} `gae:",noindex"`
+	Name string   `gae:",index"`
+	Log  string
+}
+
+type GoodArrays struct {
+	Digest [32]byte
+	Ints   [3]int64
+	Inners [2]Inner2
+}
+
+type GoodAlias struct {
+	Name string `gae:"name,noindex,alias=Name|FullName"`
+}
+
+type Inner2 struct {
+	A string
+}
+
+type Inner struct {
+	A string
+	B []int
+}
+
+type Flat struct {
+	A string
+}
+
+type Conv struct{ x uint64 }
+
+func (c *Conv) ToProperty() (datastore.Property, error) { return datastore.Property{}, nil }
+func (c *Conv) FromProperty(datastore.Property) error   { return nil }
+
+type NoTags struct {
+	U uint64
+}
+
+type Bad struct {
+	A     string      `gae:"x"`
+	B     string      `gae:"x"`         // want `Bad: struct tag has repeated property name: "x"`
+	C     string      `gae:"not valid"` // want `Bad: struct tag has invalid property name: "not valid"`
+	D     uint64      // want `Bad: field "D" has invalid type uint64: unsigned integers wider than 32 bits are not supported`
+	E     []Inner     // want `Bad: flattening nested structs leads to a slice of slices: field "E"`
+	F     string      `gae:",noindx"` // want `Bad: unknown struct tag option "noindx"`
+	G     interface{} // want `Bad: field "G" has non-concrete interface type interface\{\}`
+	H     [2][2]int   // want `Bad: field "H" has invalid type \[2\]\[2\]int: nested arrays are not supported`
+	I     string      `gae:",alias=ok|not ok"` // want `Bad: struct tag has invalid alias: "not ok"`
+	Self  *Bad        // want `Bad: field "Self" has invalid type \*a.Bad: not a supported property type`
+	Inner Inner       `gae:"Inner"`
+	Dup   Flat        `gae:"Inner"` // want `Bad: struct tag has repeated property name: "Inner.A"`
+}
+
+type BadMeta struct {
+	ID     float64          `gae:"$id"`       // want `BadMeta: meta field "\$id" has bad type: \$id must be an integer or a string`
+	Kind   int64            `gae:"$kind"`     // want `BadMeta: meta field "\$kind" has bad type: \$kind must be a string`
+	Parent string           `gae:"$parent"`   // want `BadMeta: meta field "\$parent" has bad type: \$parent must be a \*Key`
+	Flag   datastore.Toggle `gae:"$flag"`     // want `BadMeta: meta field "\$flag" has bad type: Toggle field has bad/missing default, got ""`
+	Num    int64            `gae:"$num,nope"` // want `BadMeta: meta field "\$num" has bad type: strconv.ParseInt: parsing "nope": invalid syntax`
+	Again  int64            `gae:"$num"`      // want `BadMeta: meta field "\$num" set multiple times`
+}
+
+type Recursive struct {
+	Name string     `gae:"name"`
+	Next Recursive2 // want `Recursive: field "Next" has problem: field "Back" is recursively defined`
+}
+
+type Recursive2 struct {
+	Back []Recursive
+}
+
+type BadExtra struct {
+	A datastore.PropertyMap `gae:",extra"`
+	B datastore.PropertyMap `gae:",extra"`  // want `BadExtra: struct has multiple fields tagged as 'extra'`
+	C map[string]int        `gae:"-,extra"` // want `BadExtra: struct has multiple fields tagged as 'extra'`
+}
@@ -0,0 +1,13 @@
+// Package datastore is a minimal stand-in for the real package, for use by
+// the analyzer tests.
+package datastore
+
+type Key struct{}
+
+type GeoPoint struct{ Lat, Lng float64 }
+
+type Toggle byte
+
+type Property struct{}
+
+type PropertyMap map[string][]Property
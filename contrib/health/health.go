@@ -0,0 +1,140 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package health checks that the services installed in a context respond,
+// with lightweight probes, for an app's readiness and liveness checks:
+//   - datastore: a Get of an entity which doesn't exist.
+//   - memcache: a Set of a short-lived item, and a Get of it.
+//   - taskqueue: the Stats of the default queue.
+//
+// Services which aren't installed in the context aren't probed. Handler serves
+// the results as JSON.
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	mc "github.com/tetrafolium/gae/service/memcache"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+)
+
+const (
+	// ProbeKind is the kind of the entity which the datastore probe gets. The
+	// entity is never written, so it normally doesn't exist.
+	ProbeKind = "HealthProbe"
+
+	// ProbeMemcacheKey is the key of the item which the memcache probe sets.
+	ProbeMemcacheKey = "health:probe"
+)
+
+// Probe is the result of probing one service.
+type Probe struct {
+	Service string `json:"service"`
+	OK      bool   `json:"ok"`
+	Error   string `json:"error,omitempty"`
+
+	// LatencyMS is how long the probe took, in milliseconds of the context's
+	// clock.
+	LatencyMS float64 `json:"latency_ms"`
+}
+
+// Status is the result of Check.
+type Status struct {
+	// OK is true if all of the probes succeeded.
+	OK     bool    `json:"ok"`
+	Probes []Probe `json:"probes"`
+}
+
+type probe struct {
+	service   string
+	installed func(c context.Context) bool
+	run       func(c context.Context) error
+}
+
+var probes = []probe{
+	{"datastore", func(c context.Context) bool { return ds.GetRaw(c) != nil }, probeDatastore},
+	{"memcache", func(c context.Context) bool { return mc.GetRaw(c) != nil }, probeMemcache},
+	{"taskqueue", func(c context.Context) bool { return tq.GetRaw(c) != nil }, probeTaskQueue},
+}
+
+func probeDatastore(c context.Context) error {
+	d := ds.Get(c)
+	pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey(ProbeKind, 1))}}
+	if err := d.Get(pm); err != nil && err != ds.ErrNoSuchEntity {
+		return err
+	}
+	return nil
+}
+
+func probeMemcache(c context.Context) error {
+	m := mc.Get(c)
+	val := []byte(clock.Now(c).UTC().Format(time.RFC3339Nano))
+	if err := m.Set(m.NewItem(ProbeMemcacheKey).SetValue(val).SetExpiration(time.Minute)); err != nil {
+		return err
+	}
+	itm, err := m.Get(ProbeMemcacheKey)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(itm.Value(), val) {
+		return fmt.Errorf("health: memcache returned %q, but %q was set", itm.Value(), val)
+	}
+	return nil
+}
+
+func probeTaskQueue(c context.Context) error {
+	_, err := tq.Get(c).Stats("")
+	return err
+}
+
+// Check probes the services installed in c, one at a time.
+func Check(c context.Context) *Status {
+	ret := &Status{OK: true, Probes: []Probe{}}
+	for _, p := range probes {
+		if !p.installed(c) {
+			continue
+		}
+		start := clock.Now(c)
+		err := p.run(c)
+		res := Probe{
+			Service:   p.service,
+			OK:        err == nil,
+			LatencyMS: float64(clock.Now(c).Sub(start)) / float64(time.Millisecond),
+		}
+		if err != nil {
+			res.Error = err.Error()
+			ret.OK = false
+		}
+		ret.Probes = append(ret.Probes, res)
+	}
+	return ret
+}
+
+// Handler serves the Status of c's services (see Check) as JSON, with the
+// status code 200 if they're all OK, and 503 otherwise. It's a filter.Handler,
+// so the filters' middlewares can wrap it.
+func Handler(c context.Context, rw http.ResponseWriter, r *http.Request) {
+	s := Check(c)
+	data, err := json.Marshal(s)
+	if err != nil {
+		http.Error(rw, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("Cache-Control", "no-cache")
+	if s.OK {
+		rw.WriteHeader(http.StatusOK)
+	} else {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}
+	rw.Write(data)
+}
@@ -0,0 +1,76 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package health
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/tetrafolium/gae/filter/featureBreaker"
+	"github.com/tetrafolium/gae/impl/memory"
+	mc "github.com/tetrafolium/gae/service/memcache"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestHealth(t *testing.T) {
+	t.Parallel()
+
+	Convey("health", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, _ := testclock.UseTime(context.Background(), now)
+		c = memory.Use(c)
+
+		serve := func(c context.Context) (*httptest.ResponseRecorder, *Status) {
+			rec := httptest.NewRecorder()
+			r, _ := http.NewRequest("GET", "/_ah/health", nil)
+			Handler(c, rec, r)
+			s := &Status{}
+			So(json.Unmarshal(rec.Body.Bytes(), s), ShouldBeNil)
+			return rec, s
+		}
+
+		Convey("reports healthy services", func() {
+			rec, s := serve(c)
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+			So(s.OK, ShouldBeTrue)
+			So(s.Probes, ShouldResemble, []Probe{
+				{Service: "datastore", OK: true},
+				{Service: "memcache", OK: true},
+				{Service: "taskqueue", OK: true},
+			})
+
+			itm, err := mc.Get(c).Get(ProbeMemcacheKey)
+			So(err, ShouldBeNil)
+			So(itm.Value(), ShouldNotBeEmpty)
+		})
+
+		Convey("reports failing services", func() {
+			c, fb := featureBreaker.FilterMC(c, errors.New("memcache is down"))
+			fb.BreakFeatures(nil, "GetMulti")
+
+			rec, s := serve(c)
+			So(rec.Code, ShouldEqual, http.StatusServiceUnavailable)
+			So(s.OK, ShouldBeFalse)
+			So(s.Probes[0].OK, ShouldBeTrue)
+			So(s.Probes[1], ShouldResemble, Probe{Service: "memcache", Error: "memcache is down"})
+			So(s.Probes[2].OK, ShouldBeTrue)
+		})
+
+		Convey("skips services which aren't installed", func() {
+			c := mc.SetRaw(c, nil)
+			s := Check(c)
+			So(s.OK, ShouldBeTrue)
+			So(len(s.Probes), ShouldEqual, 2)
+			So(s.Probes[1].Service, ShouldEqual, "taskqueue")
+		})
+	})
+}
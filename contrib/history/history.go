@@ -0,0 +1,143 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package history keeps the previous versions of the entities of chosen
+// kinds, so that they can be audited, or read as they were at some time.
+//
+// Filter installs a datastore filter which, whenever an entity of a tracked
+// kind is put or deleted, writes an Entry with the version which it replaced
+// (or the fact that there wasn't one), the time and the actor who made the
+// change. Entries are children of the entity, so writes in a transaction
+// record their history in the same transaction: it's committed, or rolled
+// back, with them.
+//
+// Writes made outside of a transaction record their history with a separate
+// write after the entity's, so if entities of tracked kinds are written
+// concurrently outside transactions, their histories may miss versions. Write
+// tracked kinds in transactions for an exact audit trail.
+//
+// AsOf reads an entity as it was at a time, History lists its entries, and
+// Prune deletes entries which are no longer needed.
+package history
+
+import (
+	"bytes"
+	"sort"
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"golang.org/x/net/context"
+)
+
+// Kind is the kind of history entries.
+const Kind = "EntityHistory"
+
+// Config configures Filter.
+type Config struct {
+	// Kinds are the kinds whose history is kept.
+	Kinds []string
+
+	// Actor returns who is making changes in c, e.g. the current user's email,
+	// for Entry.Actor. If it's nil, entries have no actor.
+	Actor func(c context.Context) string
+}
+
+// Entry records a change to an entity. Its key is a child of the entity's.
+type Entry struct {
+	Key *ds.Key `gae:"$key"`
+
+	// Time is when the change was made, which is when the recorded version
+	// stopped being current.
+	Time time.Time
+
+	// Actor is who made the change (see Config.Actor).
+	Actor string
+
+	// Existed is false if the entity didn't exist before the change.
+	Existed bool `gae:",noindex"`
+
+	// Entity is the serialized PropertyMap of the version which was replaced.
+	// Use Version to decode it.
+	Entity []byte `gae:",noindex"`
+}
+
+// Version returns the version of the entity which e recorded, or
+// ds.ErrNoSuchEntity if it didn't exist.
+func (e *Entry) Version() (ds.PropertyMap, error) {
+	if !e.Existed {
+		return nil, ds.ErrNoSuchEntity
+	}
+	return serialize.ReadPropertyMap(bytes.NewBuffer(e.Entity), serialize.WithContext, "", "")
+}
+
+type entriesByTime []*Entry
+
+func (s entriesByTime) Len() int           { return len(s) }
+func (s entriesByTime) Less(i, j int) bool { return s[i].Time.Before(s[j].Time) }
+func (s entriesByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// History returns the entries of the entity with the given key, oldest first.
+func History(c context.Context, key *ds.Key) ([]*Entry, error) {
+	all := []*Entry(nil)
+	if err := ds.Get(c).GetAll(ds.NewQuery(Kind).Ancestor(key), &all); err != nil {
+		return nil, err
+	}
+	// The query also returns the entries of the entity's descendants.
+	ret := all[:0]
+	for _, e := range all {
+		if e.Key.Parent().Equal(key) {
+			ret = append(ret, e)
+		}
+	}
+	sort.Stable(entriesByTime(ret))
+	return ret, nil
+}
+
+// AsOf loads dst (a pointer to a struct, or a PropertyLoadSaver, as for
+// datastore's Get) with its entity as it was at time t. It returns
+// ds.ErrNoSuchEntity if the entity didn't exist then.
+//
+// Only changes which were made with the Filter installed are known, so the
+// entity is assumed to have been as it was before the first of them.
+func AsOf(c context.Context, dst interface{}, t time.Time) error {
+	d := ds.Get(c)
+	key, err := d.KeyForObjErr(dst)
+	if err != nil {
+		return err
+	}
+	entries, err := History(c, key)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Time.After(t) {
+			continue
+		}
+		pm, err := e.Version()
+		if err != nil {
+			return err
+		}
+		if pls, ok := dst.(ds.PropertyLoadSaver); ok {
+			return pls.Load(pm)
+		}
+		return ds.GetPLS(dst).Load(pm)
+	}
+	return d.Get(dst)
+}
+
+// Prune deletes the entries of every entity which were recorded before the
+// given time, and returns how many were deleted. Afterwards, AsOf returns the
+// oldest remaining version for times before then.
+func Prune(c context.Context, before time.Time) (int, error) {
+	d := ds.Get(c)
+	keys := []*ds.Key(nil)
+	if err := d.GetAll(ds.NewQuery(Kind).Lt("Time", before).KeysOnly(true), &keys); err != nil {
+		return 0, err
+	}
+	if err := d.DeleteMulti(keys); err != nil {
+		return 0, err
+	}
+	return len(keys), nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package history
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+type Doc struct {
+	ID   int64 `gae:"$id"`
+	Body string
+}
+
+func TestHistory(t *testing.T) {
+	t.Parallel()
+
+	Convey("history", t, func() {
+		t0 := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, tc := testclock.UseTime(memory.Use(context.Background()), t0)
+		ds.Get(c).Testable().Consistent(true)
+		c = Filter(c, Config{
+			Kinds: []string{"Doc"},
+			Actor: func(context.Context) string { return "someone@example.com" },
+		})
+		d := ds.Get(c)
+
+		inTxn := func(f func(d ds.Interface) error) error {
+			return d.RunInTransaction(func(c context.Context) error {
+				return f(ds.Get(c))
+			}, nil)
+		}
+
+		So(inTxn(func(d ds.Interface) error { return d.Put(&Doc{ID: 1, Body: "v1"}) }), ShouldBeNil)
+		tc.Add(time.Hour)
+		So(d.Put(&Doc{ID: 1, Body: "v2"}), ShouldBeNil)
+		tc.Add(time.Hour)
+		So(inTxn(func(d ds.Interface) error { return d.Delete(d.MakeKey("Doc", 1)) }), ShouldBeNil)
+
+		Convey("records each change", func() {
+			entries, err := History(c, d.MakeKey("Doc", 1))
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 3)
+			for i, e := range entries {
+				So(e.Time, ShouldResemble, t0.Add(time.Duration(i)*time.Hour))
+				So(e.Actor, ShouldEqual, "someone@example.com")
+			}
+
+			_, err = entries[0].Version()
+			So(err, ShouldEqual, ds.ErrNoSuchEntity)
+			pm, err := entries[1].Version()
+			So(err, ShouldBeNil)
+			So(pm.GetString("Body", ""), ShouldEqual, "v1")
+		})
+
+		Convey("AsOf reads old versions", func() {
+			asOf := func(t time.Time) error { return AsOf(c, &Doc{ID: 1}, t) }
+			body := func(t time.Time) string {
+				doc := &Doc{ID: 1}
+				So(AsOf(c, doc, t), ShouldBeNil)
+				return doc.Body
+			}
+			So(asOf(t0.Add(-time.Minute)), ShouldEqual, ds.ErrNoSuchEntity)
+			So(body(t0.Add(30*time.Minute)), ShouldEqual, "v1")
+			So(body(t0.Add(90*time.Minute)), ShouldEqual, "v2")
+			So(asOf(t0.Add(3*time.Hour)), ShouldEqual, ds.ErrNoSuchEntity)
+
+			So(d.Put(&Doc{ID: 1, Body: "v3"}), ShouldBeNil)
+			So(body(t0.Add(3*time.Hour)), ShouldEqual, "v3")
+
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("Doc", 1))}}
+			So(AsOf(c, pm, t0.Add(30*time.Minute)), ShouldBeNil)
+			So(pm.GetString("Body", ""), ShouldEqual, "v1")
+		})
+
+		Convey("rolled back changes aren't recorded", func() {
+			So(inTxn(func(d ds.Interface) error {
+				So(d.Put(&Doc{ID: 2, Body: "nope"}), ShouldBeNil)
+				return errors.New("roll back")
+			}), ShouldNotBeNil)
+			entries, err := History(c, d.MakeKey("Doc", 2))
+			So(err, ShouldBeNil)
+			So(entries, ShouldBeEmpty)
+		})
+
+		Convey("other kinds aren't tracked", func() {
+			type Other struct {
+				ID int64 `gae:"$id"`
+			}
+			So(d.Put(&Other{ID: 1}), ShouldBeNil)
+			count, err := d.Count(ds.NewQuery(Kind))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+		})
+
+		Convey("Prune deletes old entries", func() {
+			n, err := Prune(c, t0.Add(90*time.Minute))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			entries, err := History(c, d.MakeKey("Doc", 1))
+			So(err, ShouldBeNil)
+			So(len(entries), ShouldEqual, 1)
+			So(entries[0].Time, ShouldResemble, t0.Add(2*time.Hour))
+		})
+	})
+}
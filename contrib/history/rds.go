@@ -0,0 +1,178 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package history
+
+import (
+	"bytes"
+
+	"github.com/luci/luci-go/common/clock"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"golang.org/x/net/context"
+)
+
+// Filter installs the datastore filter which records the history of the
+// kinds in cfg.
+func Filter(c context.Context, cfg Config) context.Context {
+	kinds := make(map[string]struct{}, len(cfg.Kinds))
+	for _, k := range cfg.Kinds {
+		kinds[k] = struct{}{}
+	}
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		return &historian{rds, ic, kinds, cfg.Actor}
+	})
+}
+
+type historian struct {
+	ds.RawInterface
+
+	c     context.Context
+	kinds map[string]struct{}
+	actor func(context.Context) string
+}
+
+var _ ds.RawInterface = (*historian)(nil)
+
+// tracked returns the indexes of the keys whose history is kept.
+func (h *historian) tracked(keys []*ds.Key) []int {
+	ret := []int(nil)
+	for i, k := range keys {
+		if _, ok := h.kinds[k.Kind()]; ok {
+			ret = append(ret, i)
+		}
+	}
+	return ret
+}
+
+// previous returns the current versions of keys[idxs], which are nil for
+// entities which don't exist (including ones with incomplete keys).
+func (h *historian) previous(keys []*ds.Key, idxs []int) ([]ds.PropertyMap, error) {
+	ret := make([]ds.PropertyMap, len(idxs))
+	get := []*ds.Key(nil)
+	getIdxs := []int(nil)
+	for i, idx := range idxs {
+		if !keys[idx].Incomplete() {
+			get = append(get, keys[idx])
+			getIdxs = append(getIdxs, i)
+		}
+	}
+	if len(get) == 0 {
+		return ret, nil
+	}
+
+	i := 0
+	err := h.RawInterface.GetMulti(get, nil, func(pm ds.PropertyMap, err error) error {
+		defer func() { i++ }()
+		switch err {
+		case nil:
+			ret[getIdxs[i]] = pm
+		case ds.ErrNoSuchEntity:
+		default:
+			return err
+		}
+		return nil
+	})
+	return ret, err
+}
+
+// record writes an Entry for each of the written keys (those which aren't
+// nil), recording that it replaced the corresponding version in prev.
+func (h *historian) record(keys []*ds.Key, prev []ds.PropertyMap) error {
+	now := clock.Now(h.c).UTC()
+	actor := ""
+	if h.actor != nil {
+		actor = h.actor(h.c)
+	}
+
+	entryKeys := []*ds.Key(nil)
+	entries := []ds.PropertyMap(nil)
+	for i, k := range keys {
+		if k == nil {
+			continue
+		}
+		entity := []byte(nil)
+		if prev[i] != nil {
+			buf := &bytes.Buffer{}
+			if err := serialize.WritePropertyMap(buf, serialize.WithContext, prev[i]); err != nil {
+				return err
+			}
+			entity = buf.Bytes()
+		}
+		entryKeys = append(entryKeys, ds.NewKey(k.AppID(), k.Namespace(), Kind, "", 0, k))
+		entries = append(entries, ds.PropertyMap{
+			"Time":    {ds.MkProperty(now)},
+			"Actor":   {ds.MkProperty(actor)},
+			"Existed": {ds.MkPropertyNI(prev[i] != nil)},
+			"Entity":  {ds.MkPropertyNI(entity)},
+		})
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+	return h.RawInterface.PutMulti(entryKeys, entries, func(_ *ds.Key, err error) error {
+		return err
+	})
+}
+
+func (h *historian) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	idxs := h.tracked(keys)
+	if len(idxs) == 0 {
+		return h.RawInterface.PutMulti(keys, vals, cb)
+	}
+	prev, err := h.previous(keys, idxs)
+	if err != nil {
+		return err
+	}
+
+	put := make([]*ds.Key, len(keys))
+	i := 0
+	err = h.RawInterface.PutMulti(keys, vals, func(k *ds.Key, err error) error {
+		if err == nil {
+			put[i] = k
+		}
+		i++
+		return cb(k, err)
+	})
+	if err != nil {
+		return err
+	}
+
+	written := make([]*ds.Key, len(idxs))
+	for i, idx := range idxs {
+		written[i] = put[idx]
+	}
+	return h.record(written, prev)
+}
+
+func (h *historian) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	idxs := h.tracked(keys)
+	if len(idxs) == 0 {
+		return h.RawInterface.DeleteMulti(keys, cb)
+	}
+	prev, err := h.previous(keys, idxs)
+	if err != nil {
+		return err
+	}
+
+	deleted := make([]bool, len(keys))
+	i := 0
+	err = h.RawInterface.DeleteMulti(keys, func(err error) error {
+		deleted[i] = err == nil
+		i++
+		return cb(err)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Deleting an entity which doesn't exist changes nothing.
+	written := make([]*ds.Key, len(idxs))
+	for i, idx := range idxs {
+		if deleted[idx] && prev[i] != nil {
+			written[i] = keys[idx]
+		}
+	}
+	return h.record(written, prev)
+}
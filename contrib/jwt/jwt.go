@@ -0,0 +1,242 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package jwt builds JSON Web Tokens which are signed by the app's service
+// account, using info.SignBytes, and verifies them against
+// info.PublicCertificates.
+//
+// Such tokens can be used to authenticate the app to Identity-Aware Proxy,
+// to Google APIs (by exchanging them for an access token), or to other
+// services which trust the app's public certificates.
+package jwt
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"github.com/tetrafolium/gae/service/info"
+	"golang.org/x/net/context"
+)
+
+// DefaultLifetime is the lifetime of tokens whose Claims don't set Expiry.
+const DefaultLifetime = time.Hour
+
+// Claims is the claim set of a token.
+type Claims struct {
+	// Issuer ("iss") defaults to the app's service account.
+	Issuer string `json:"iss,omitempty"`
+	// Subject ("sub") defaults to Issuer.
+	Subject  string `json:"sub,omitempty"`
+	Audience string `json:"aud,omitempty"`
+
+	// IssuedAt ("iat") and Expiry ("exp") are in seconds since the epoch.
+	// IssuedAt defaults to the current time of the context's clock, and Expiry
+	// to DefaultLifetime after IssuedAt.
+	IssuedAt int64 `json:"iat,omitempty"`
+	Expiry   int64 `json:"exp,omitempty"`
+
+	// Scope is the space-delimited list of scopes requested by a token which
+	// is exchanged for a Google API access token.
+	Scope string `json:"scope,omitempty"`
+	// TargetAudience is the client ID requested by a token which is exchanged
+	// for an Identity-Aware Proxy OpenID Connect token.
+	TargetAudience string `json:"target_audience,omitempty"`
+
+	// Extra holds any additional (private) claims. They must not have the same
+	// names as the claims above.
+	Extra map[string]interface{} `json:"-"`
+}
+
+type claimsNoExtra Claims
+
+// standardClaims are the JSON names of the fields of Claims.
+var standardClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "iat": true, "exp": true,
+	"scope": true, "target_audience": true,
+}
+
+// MarshalJSON implements json.Marshaler, flattening Extra into the claim set.
+func (c *Claims) MarshalJSON() ([]byte, error) {
+	std, err := json.Marshal((*claimsNoExtra)(c))
+	if err != nil || len(c.Extra) == 0 {
+		return std, err
+	}
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(std, &all); err != nil {
+		return nil, err
+	}
+	for k, v := range c.Extra {
+		if standardClaims[k] {
+			return nil, fmt.Errorf("jwt: extra claim %q conflicts with a standard claim", k)
+		}
+		all[k] = v
+	}
+	return json.Marshal(all)
+}
+
+// UnmarshalJSON implements json.Unmarshaler, putting unknown claims in Extra.
+func (c *Claims) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*claimsNoExtra)(c)); err != nil {
+		return err
+	}
+	all := map[string]interface{}{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+	c.Extra = nil
+	for k, v := range all {
+		if !standardClaims[k] {
+			if c.Extra == nil {
+				c.Extra = map[string]interface{}{}
+			}
+			c.Extra[k] = v
+		}
+	}
+	return nil
+}
+
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid,omitempty"`
+}
+
+var b64 = base64.RawURLEncoding
+
+// Sign builds a token with the claims cl, and signs it with the service
+// account of the app in c. Missing claims are filled in as documented on
+// Claims; cl itself isn't modified.
+func Sign(c context.Context, cl *Claims) (string, error) {
+	gi := info.Get(c)
+
+	filled := *cl
+	if filled.Issuer == "" {
+		sa, err := gi.ServiceAccount()
+		if err != nil {
+			return "", err
+		}
+		filled.Issuer = sa
+	}
+	if filled.Subject == "" {
+		filled.Subject = filled.Issuer
+	}
+	if filled.IssuedAt == 0 {
+		filled.IssuedAt = clock.Now(c).Unix()
+	}
+	if filled.Expiry == 0 {
+		filled.Expiry = filled.IssuedAt + int64(DefaultLifetime/time.Second)
+	}
+	payload, err := json.Marshal(&filled)
+	if err != nil {
+		return "", err
+	}
+
+	// The header has no "kid", since the name of the key is only known after
+	// signing. Verify tries each of the app's certificates instead.
+	hdr, err := json.Marshal(&header{Algorithm: "RS256", Type: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	signingInput := b64.EncodeToString(hdr) + "." + b64.EncodeToString(payload)
+	_, sig, err := gi.SignBytes([]byte(signingInput))
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + b64.EncodeToString(sig), nil
+}
+
+// Errors returned by Verify.
+var (
+	ErrMalformed  = errors.New("jwt: malformed token")
+	ErrBadSig     = errors.New("jwt: bad signature")
+	ErrExpired    = errors.New("jwt: token is expired")
+	ErrUnknownKey = errors.New("jwt: token is signed by an unknown key")
+)
+
+// Verify checks that token was signed by one of the public certificates of the
+// app in c (e.g. by Sign) and hasn't expired, and returns its claims. If the
+// token's header names its key ("kid"), only that certificate is tried.
+func Verify(c context.Context, token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrMalformed
+	}
+	raw := make([][]byte, len(parts))
+	for i, p := range parts {
+		var err error
+		if raw[i], err = b64.DecodeString(p); err != nil {
+			return nil, ErrMalformed
+		}
+	}
+	hdr := header{}
+	if err := json.Unmarshal(raw[0], &hdr); err != nil {
+		return nil, ErrMalformed
+	}
+	if hdr.Algorithm != "RS256" {
+		return nil, fmt.Errorf("jwt: unsupported algorithm %q", hdr.Algorithm)
+	}
+
+	certs, err := info.Get(c).PublicCertificates()
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	verified, known := false, false
+	for _, cert := range certs {
+		if hdr.KeyID != "" && cert.KeyName != hdr.KeyID {
+			continue
+		}
+		known = true
+		key, err := publicKey(cert)
+		if err != nil {
+			return nil, err
+		}
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], raw[2]) == nil {
+			verified = true
+			break
+		}
+	}
+	switch {
+	case !known:
+		return nil, ErrUnknownKey
+	case !verified:
+		return nil, ErrBadSig
+	}
+
+	cl := &Claims{}
+	if err := json.Unmarshal(raw[1], cl); err != nil {
+		return nil, ErrMalformed
+	}
+	if cl.Expiry != 0 && clock.Now(c).Unix() >= cl.Expiry {
+		return nil, ErrExpired
+	}
+	return cl, nil
+}
+
+// publicKey returns the RSA public key of cert.
+func publicKey(cert info.Certificate) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(cert.Data)
+	if block == nil {
+		return nil, fmt.Errorf("jwt: certificate %q isn't PEM-encoded", cert.KeyName)
+	}
+	x, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := x.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("jwt: certificate %q doesn't have an RSA key", cert.KeyName)
+	}
+	return key, nil
+}
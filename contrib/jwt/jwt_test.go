@@ -0,0 +1,102 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package jwt
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/tetrafolium/gae/impl/memory"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestJWT(t *testing.T) {
+	t.Parallel()
+
+	Convey("JWT", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), now)
+		c = memory.UseWithAppID(c, "dev~app")
+
+		Convey("signs and verifies tokens", func() {
+			tok, err := Sign(c, &Claims{
+				Audience: "https://example.com",
+				Extra:    map[string]interface{}{"email": "app@example.com"},
+			})
+			So(err, ShouldBeNil)
+			So(strings.Count(tok, "."), ShouldEqual, 2)
+
+			cl, err := Verify(c, tok)
+			So(err, ShouldBeNil)
+			So(cl, ShouldResemble, &Claims{
+				Issuer:   "dev~app@appspot.gserviceaccount.com",
+				Subject:  "dev~app@appspot.gserviceaccount.com",
+				Audience: "https://example.com",
+				IssuedAt: now.Unix(),
+				Expiry:   now.Add(DefaultLifetime).Unix(),
+				Extra:    map[string]interface{}{"email": "app@example.com"},
+			})
+
+			Convey("which expire", func() {
+				tc.Add(DefaultLifetime)
+				_, err := Verify(c, tok)
+				So(err, ShouldEqual, ErrExpired)
+			})
+
+			Convey("which can't be tampered with", func() {
+				parts := strings.Split(tok, ".")
+				other, err := Sign(c, &Claims{Audience: "https://evil.example.com"})
+				So(err, ShouldBeNil)
+				parts[1] = strings.Split(other, ".")[1]
+
+				_, err = Verify(c, strings.Join(parts, "."))
+				So(err, ShouldEqual, ErrBadSig)
+			})
+
+			Convey("which another app doesn't trust", func() {
+				_, err := Verify(memory.UseWithAppID(context.Background(), "dev~other"), tok)
+				So(err, ShouldEqual, ErrBadSig)
+			})
+		})
+
+		Convey("keeps explicit claims", func() {
+			tok, err := Sign(c, &Claims{
+				Issuer:         "someone@example.com",
+				Subject:        "someone-else@example.com",
+				IssuedAt:       100,
+				Expiry:         now.Add(time.Minute).Unix(),
+				TargetAudience: "client-id",
+			})
+			So(err, ShouldBeNil)
+
+			cl, err := Verify(c, tok)
+			So(err, ShouldBeNil)
+			So(cl, ShouldResemble, &Claims{
+				Issuer:         "someone@example.com",
+				Subject:        "someone-else@example.com",
+				IssuedAt:       100,
+				Expiry:         now.Add(time.Minute).Unix(),
+				TargetAudience: "client-id",
+			})
+		})
+
+		Convey("rejects conflicting extra claims", func() {
+			_, err := Sign(c, &Claims{Extra: map[string]interface{}{"aud": "x"}})
+			So(err, ShouldErrLike, `extra claim "aud" conflicts`)
+		})
+
+		Convey("rejects malformed tokens", func() {
+			_, err := Verify(c, "not a token")
+			So(err, ShouldEqual, ErrMalformed)
+
+			_, err = Verify(c, "a.b")
+			So(err, ShouldEqual, ErrMalformed)
+		})
+	})
+}
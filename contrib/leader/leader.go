@@ -0,0 +1,203 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package leader elects one instance of an application to do singleton
+// background work, like refreshing caches or sweeping old entities.
+//
+// The instances which want to do the work each make an Election with the
+// same Name, and call Try periodically (or use Run). The leader holds a lease
+// in the datastore, which it renews with each Try. Once the lease expires
+// (e.g. because the leader's instance went away), the next instance to Try
+// takes over. Leases are taken and renewed with transactions, so at most one
+// instance holds a lease at a time.
+//
+//   e := &leader.Election{Name: "sweeper", OnAcquire: startSweeping}
+//   go e.Run(c, 0)
+//   ...
+//   if e.IsLeader(c) {
+//     sweep(c)
+//   }
+//
+// Instances' clocks (clock.Get) are assumed to agree: an instance stops
+// considering itself the leader when its lease expires by its own clock.
+package leader
+
+import (
+	"sync"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	log "github.com/luci/luci-go/common/logging"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+	"golang.org/x/net/context"
+)
+
+// DefaultLeaseDuration is the lease duration of Elections which don't set
+// one.
+const DefaultLeaseDuration = time.Minute
+
+// Lease is the datastore entity of an Election's lease.
+type Lease struct {
+	_kind string `gae:"$kind,LeaderLease"`
+
+	// Name is the Election's Name.
+	Name string `gae:"$id"`
+
+	// Holder is the ID of the leader.
+	Holder string `gae:",noindex"`
+	// Expires is when the lease expires, unless the leader renews it.
+	Expires time.Time `gae:",noindex"`
+}
+
+// Election elects a leader among the instances with an Election of the same
+// Name. Its methods may be called concurrently.
+type Election struct {
+	// Name identifies the work to elect a leader for.
+	Name string
+
+	// ID identifies this instance. If it's empty, the instance ID
+	// (info.InstanceID) is used.
+	ID string
+
+	// LeaseDuration is how long a lease lasts without being renewed. If it's
+	// 0, DefaultLeaseDuration is used.
+	LeaseDuration time.Duration
+
+	// OnAcquire, if not nil, is called by the Try which makes this instance
+	// the leader.
+	OnAcquire func(c context.Context)
+	// OnLose, if not nil, is called by the first Try (or Resign) after this
+	// instance stops being the leader.
+	OnLose func(c context.Context)
+
+	mu      sync.Mutex
+	leader  bool
+	expires time.Time
+}
+
+func (e *Election) id(c context.Context) string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return info.Get(c).InstanceID()
+}
+
+func (e *Election) leaseDuration() time.Duration {
+	if e.LeaseDuration > 0 {
+		return e.LeaseDuration
+	}
+	return DefaultLeaseDuration
+}
+
+// IsLeader returns true if this instance holds the lease, as of its last Try.
+func (e *Election) IsLeader(c context.Context) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader && clock.Now(c).Before(e.expires)
+}
+
+// Try takes the lease if it's free or has expired, or renews it if this
+// instance holds it, and returns whether this instance is now the leader.
+//
+// If Try fails, this instance remains the leader until its lease expires.
+func (e *Election) Try(c context.Context) (bool, error) {
+	id := e.id(c)
+	now := clock.Now(c)
+	lease := &Lease{Name: e.Name}
+	err := ds.Get(c).RunInTransaction(func(c context.Context) error {
+		d := ds.Get(c)
+		switch err := d.Get(lease); {
+		case err == ds.ErrNoSuchEntity:
+		case err != nil:
+			return err
+		case lease.Holder != id && now.Before(lease.Expires):
+			return nil // someone else is the leader
+		}
+		lease.Holder = id
+		lease.Expires = now.Add(e.leaseDuration())
+		return d.Put(lease)
+	}, nil)
+
+	if err != nil {
+		e.mu.Lock()
+		expires := e.expires
+		e.mu.Unlock()
+		isLeader := e.IsLeader(c)
+		e.update(c, isLeader, expires)
+		return isLeader, err
+	}
+	isLeader := lease.Holder == id
+	e.update(c, isLeader, lease.Expires)
+	return isLeader, nil
+}
+
+// Resign gives up the lease, if this instance holds it, so that another
+// instance can take over without waiting for it to expire.
+func (e *Election) Resign(c context.Context) error {
+	id := e.id(c)
+	err := ds.Get(c).RunInTransaction(func(c context.Context) error {
+		d := ds.Get(c)
+		lease := &Lease{Name: e.Name}
+		switch err := d.Get(lease); {
+		case err == ds.ErrNoSuchEntity:
+			return nil
+		case err != nil:
+			return err
+		case lease.Holder != id:
+			return nil
+		}
+		return d.Delete(d.KeyForObj(lease))
+	}, nil)
+	if err != nil {
+		return err
+	}
+	e.update(c, false, time.Time{})
+	return nil
+}
+
+// update records the result of a Try or Resign, and calls the callbacks if
+// the leadership changed.
+func (e *Election) update(c context.Context, leader bool, expires time.Time) {
+	e.mu.Lock()
+	was := e.leader
+	e.leader, e.expires = leader, expires
+	e.mu.Unlock()
+
+	switch {
+	case leader && !was && e.OnAcquire != nil:
+		e.OnAcquire(c)
+	case !leader && was && e.OnLose != nil:
+		e.OnLose(c)
+	}
+}
+
+// Run calls Try every interval until c is cancelled, and then Resigns.
+// Errors are logged. If interval is 0, a third of the lease duration is used,
+// so that a leader renews its lease in time even if a Try fails.
+func (e *Election) Run(c context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = e.leaseDuration() / 3
+	}
+	for c.Err() == nil {
+		if _, err := e.Try(c); err != nil {
+			log.Fields{log.ErrorKey: err, "name": e.Name}.Warningf(c, "leader: failed to take or renew the lease")
+		}
+		clock.Sleep(c, interval)
+	}
+	// c is cancelled, so resign with a context which isn't.
+	if err := e.Resign(detached{c}); err != nil {
+		log.Fields{log.ErrorKey: err, "name": e.Name}.Warningf(c, "leader: failed to resign")
+	}
+}
+
+// detached is a context with the values of its parent, but without its
+// deadline or cancellation.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
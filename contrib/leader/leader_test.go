@@ -0,0 +1,84 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package leader
+
+import (
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+func TestElection(t *testing.T) {
+	t.Parallel()
+
+	Convey("Election", t, func() {
+		c, tc := testclock.UseTime(memory.Use(context.Background()), time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC))
+
+		events := []string(nil)
+		mkElection := func(id string) *Election {
+			return &Election{
+				Name:          "sweeper",
+				ID:            id,
+				LeaseDuration: time.Minute,
+				OnAcquire:     func(context.Context) { events = append(events, id+" acquired") },
+				OnLose:        func(context.Context) { events = append(events, id+" lost") },
+			}
+		}
+		a, b := mkElection("a"), mkElection("b")
+
+		try := func(e *Election) bool {
+			isLeader, err := e.Try(c)
+			So(err, ShouldBeNil)
+			So(e.IsLeader(c), ShouldEqual, isLeader)
+			return isLeader
+		}
+
+		So(try(a), ShouldBeTrue)
+		So(try(b), ShouldBeFalse)
+		So(events, ShouldResemble, []string{"a acquired"})
+
+		lease := &Lease{Name: "sweeper"}
+		So(ds.Get(c).Get(lease), ShouldBeNil)
+		So(lease.Holder, ShouldEqual, "a")
+
+		Convey("the leader renews its lease", func() {
+			tc.Add(40 * time.Second)
+			So(try(a), ShouldBeTrue)
+			tc.Add(40 * time.Second)
+			So(try(b), ShouldBeFalse)
+			So(a.IsLeader(c), ShouldBeTrue)
+			So(events, ShouldResemble, []string{"a acquired"})
+		})
+
+		Convey("expired leases are taken over", func() {
+			tc.Add(time.Minute)
+			So(a.IsLeader(c), ShouldBeFalse)
+			So(try(b), ShouldBeTrue)
+			So(try(a), ShouldBeFalse)
+			So(events, ShouldResemble, []string{"a acquired", "b acquired", "a lost"})
+		})
+
+		Convey("Resign frees the lease", func() {
+			So(b.Resign(c), ShouldBeNil)
+			So(a.IsLeader(c), ShouldBeTrue)
+
+			So(a.Resign(c), ShouldBeNil)
+			So(a.IsLeader(c), ShouldBeFalse)
+			So(ds.Get(c).Get(lease), ShouldEqual, ds.ErrNoSuchEntity)
+			So(try(b), ShouldBeTrue)
+			So(events, ShouldResemble, []string{"a acquired", "a lost", "b acquired"})
+		})
+
+		Convey("elections with other names are independent", func() {
+			other := &Election{Name: "refresher", ID: "b"}
+			So(try(other), ShouldBeTrue)
+		})
+	})
+}
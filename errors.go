@@ -0,0 +1,81 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package gae
+
+import (
+	"fmt"
+
+	"github.com/luci/luci-go/common/errors"
+)
+
+// ErrOverQuota is returned by service implementations when a call fails
+// because the app has run out of quota for the service. Implementations
+// convert their backends' errors into it, so that callers and filters (e.g.
+// ones which retry calls, or stop making them for a while) can recognize it
+// the same way with every implementation.
+type ErrOverQuota struct {
+	// Service is the name of the service whose quota ran out, e.g.
+	// "datastore".
+	Service string
+
+	// Err is the backend's error, if any.
+	Err error
+}
+
+func (e *ErrOverQuota) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("gae: %s: over quota", e.Service)
+	}
+	return fmt.Sprintf("gae: %s: over quota: %s", e.Service, e.Err)
+}
+
+// ErrCapabilityDisabled is returned by service implementations when a call
+// fails because the service (or the capability it needs, e.g. datastore
+// writes) is temporarily disabled, such as during scheduled maintenance. Like
+// ErrOverQuota, it's the same with every implementation.
+type ErrCapabilityDisabled struct {
+	// Service is the name of the disabled service, e.g. "datastore".
+	Service string
+
+	// Err is the backend's error, if any.
+	Err error
+}
+
+func (e *ErrCapabilityDisabled) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("gae: %s: capability disabled", e.Service)
+	}
+	return fmt.Sprintf("gae: %s: capability disabled: %s", e.Service, e.Err)
+}
+
+// IsOverQuota returns true if err is an *ErrOverQuota, or is a MultiError
+// containing one.
+func IsOverQuota(err error) bool {
+	return anyError(err, func(err error) bool {
+		_, ok := err.(*ErrOverQuota)
+		return ok
+	})
+}
+
+// IsCapabilityDisabled returns true if err is an *ErrCapabilityDisabled, or
+// is a MultiError containing one.
+func IsCapabilityDisabled(err error) bool {
+	return anyError(err, func(err error) bool {
+		_, ok := err.(*ErrCapabilityDisabled)
+		return ok
+	})
+}
+
+func anyError(err error, pred func(error) bool) bool {
+	if me, ok := errors.Fix(err).(errors.MultiError); ok {
+		for _, err := range me {
+			if anyError(err, pred) {
+				return true
+			}
+		}
+		return false
+	}
+	return err != nil && pred(err)
+}
@@ -0,0 +1,50 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package blobOverflow
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+// MemoryStore is a Store which keeps its blobs in memory. It's intended for
+// tests.
+type MemoryStore struct {
+	lock  sync.Mutex
+	blobs map[string][]byte
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// Put implements Store.Put.
+func (s *MemoryStore) Put(c context.Context, name string, data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.blobs == nil {
+		s.blobs = map[string][]byte{}
+	}
+	s.blobs[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Get implements Store.Get.
+func (s *MemoryStore) Get(c context.Context, name string) ([]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.blobs[name]
+	if !ok {
+		return nil, fmt.Errorf("no such blob: %q", name)
+	}
+	return data, nil
+}
+
+// Len returns the number of blobs in the store.
+func (s *MemoryStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.blobs)
+}
@@ -0,0 +1,189 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package blobOverflow contains a datastore filter which moves the large
+// properties of oversized entities out of the datastore, into a blob Store
+// (e.g. blobstore or Google Cloud Storage), and transparently puts them back
+// when entities are read. This allows kinds with values which are too big
+// for the datastore's entity size limit, without changing the code which puts
+// and gets them.
+//
+// When an entity's estimated size is over the filter's threshold, its largest
+// unindexed properties are moved until it fits. Each moved property is written
+// to the Store as a blob, named by the hash of its contents, and the entity
+// records the blob's name in its RefProperty. Entities without a RefProperty
+// are read as they are.
+//
+// Blobs are never deleted by the filter, since the datastore write which
+// replaces or deletes an entity may yet fail or be rolled back. Applications
+// which need to reclaim their space should garbage collect the blobs which
+// no entity's RefProperty refers to.
+//
+// Entities must always be read with the filter installed once they've been
+// written with it. Projection queries are unaffected, since they can't return
+// unindexed properties.
+package blobOverflow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"golang.org/x/net/context"
+)
+
+// DefaultThreshold is the estimated entity size over which properties are
+// moved to the Store, if FilterRDS is given a threshold of 0. It leaves some
+// room under the datastore's 1MiB limit for the entity's key and indexes.
+const DefaultThreshold = 900 * 1024
+
+// RefProperty is the name of the unindexed property which records which of
+// an entity's properties were moved to the Store. Each of its values is
+//   <blob name>:<property name>
+const RefProperty = "_overflow"
+
+// Store stores the blobs of moved properties. Implementations are typically
+// backed by blobstore or Google Cloud Storage.
+type Store interface {
+	// Put stores data as the blob called name. Since names are hashes of the
+	// data, a blob which already exists may be left as it is.
+	Put(c context.Context, name string, data []byte) error
+
+	// Get returns the data of the blob called name.
+	Get(c context.Context, name string) ([]byte, error)
+}
+
+// FilterRDS installs the overflowing datastore filter in the context. Entities
+// whose estimated size is over threshold bytes have properties moved to
+// store. If threshold is 0, DefaultThreshold is used.
+func FilterRDS(c context.Context, store Store, threshold int) context.Context {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return ds.AddRawFilters(c, func(c context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(c, filter.BlobOverflow) {
+			return &restorer{overflower{rds, c, store, int64(threshold)}}
+		}
+		return &overflower{rds, c, store, int64(threshold)}
+	})
+}
+
+// movable returns true if vals may be moved to the Store.
+func movable(name string, vals []ds.Property) bool {
+	if strings.HasPrefix(name, "$") || name == RefProperty || len(vals) == 0 {
+		return false
+	}
+	for _, v := range vals {
+		if v.IndexSetting() != ds.NoIndex {
+			return false
+		}
+	}
+	return true
+}
+
+type candidate struct {
+	name string
+	size int64
+}
+
+type bySize []candidate
+
+func (s bySize) Len() int      { return len(s) }
+func (s bySize) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s bySize) Less(i, j int) bool {
+	if s[i].size != s[j].size {
+		return s[i].size > s[j].size
+	}
+	return s[i].name < s[j].name
+}
+
+// overflow returns pm with its largest properties moved to store, until its
+// estimated size is at most threshold. pm isn't modified; if it's small
+// enough, pm itself is returned.
+func overflow(c context.Context, store Store, pm ds.PropertyMap, threshold int64) (ds.PropertyMap, error) {
+	size := pm.EstimateSize()
+	if size <= threshold {
+		return pm, nil
+	}
+
+	cands := bySize{}
+	for name, vals := range pm {
+		if movable(name, vals) {
+			cands = append(cands, candidate{name, ds.PropertyMap{name: vals}.EstimateSize()})
+		}
+	}
+	sort.Sort(cands)
+
+	ret := make(ds.PropertyMap, len(pm)+1)
+	for k, v := range pm {
+		ret[k] = v
+	}
+	for _, cand := range cands {
+		if size <= threshold {
+			break
+		}
+
+		buf := &bytes.Buffer{}
+		if err := serialize.WriteVersionedPropertyMap(buf, serialize.WithContext, ds.PropertyMap{cand.name: pm[cand.name]}); err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(buf.Bytes())
+		blob := hex.EncodeToString(hash[:])
+		if err := store.Put(c, blob, buf.Bytes()); err != nil {
+			return nil, fmt.Errorf("blobOverflow: storing property %q: %s", cand.name, err)
+		}
+
+		ref := ds.MkPropertyNI(blob + ":" + cand.name)
+		delete(ret, cand.name)
+		ret[RefProperty] = append(ret[RefProperty], ref)
+		size += ref.EstimateSize() - cand.size
+	}
+	return ret, nil
+}
+
+// restore returns pm without RefProperty, and with the properties which it
+// refers to read back from store. pm isn't modified; if it has no
+// RefProperty, pm itself is returned.
+func restore(c context.Context, store Store, pm ds.PropertyMap) (ds.PropertyMap, error) {
+	refs, ok := pm[RefProperty]
+	if !ok {
+		return pm, nil
+	}
+	ret := make(ds.PropertyMap, len(pm)+len(refs))
+	for k, v := range pm {
+		if k != RefProperty {
+			ret[k] = v
+		}
+	}
+
+	for _, r := range refs {
+		ref, _ := r.Value().(string)
+		parts := strings.SplitN(ref, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("blobOverflow: invalid %s value: %q", RefProperty, ref)
+		}
+		blob, name := parts[0], parts[1]
+
+		data, err := store.Get(c, blob)
+		if err != nil {
+			return nil, fmt.Errorf("blobOverflow: reading property %q: %s", name, err)
+		}
+		moved, err := serialize.ReadVersionedPropertyMap(bytes.NewBuffer(data), serialize.WithContext, "", "")
+		if err != nil {
+			return nil, fmt.Errorf("blobOverflow: decoding property %q: %s", name, err)
+		}
+		vals, ok := moved[name]
+		if !ok || len(moved) != 1 {
+			return nil, fmt.Errorf("blobOverflow: blob %q doesn't contain property %q", blob, name)
+		}
+		ret[name] = vals
+	}
+	return ret, nil
+}
@@ -0,0 +1,114 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package blobOverflow
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type object struct {
+	ID int64 `gae:"$id"`
+
+	Big     []byte   `gae:",noindex"`
+	Medium  []string `gae:",noindex"`
+	Small   string   `gae:",noindex"`
+	Indexed string
+}
+
+func TestOverflow(t *testing.T) {
+	t.Parallel()
+
+	Convey("blobOverflow", t, func() {
+		c := memory.Use(context.Background())
+		under := ds.Get(c)
+		under.Testable().Consistent(true)
+		store := &MemoryStore{}
+		c = FilterRDS(c, store, 1000)
+		d := ds.Get(c)
+
+		o := &object{
+			ID:      1,
+			Big:     []byte(strings.Repeat("b", 2000)),
+			Medium:  []string{strings.Repeat("m", 300), strings.Repeat("n", 300)},
+			Small:   "small",
+			Indexed: strings.Repeat("i", 300),
+		}
+		So(d.Put(o), ShouldBeNil)
+
+		raw := func(o *object) ds.PropertyMap {
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.KeyForObj(o))}}
+			So(under.Get(&pm), ShouldBeNil)
+			return pm
+		}
+
+		Convey("moves the largest unindexed properties", func() {
+			pm := raw(o)
+			So(pm, ShouldNotContainKey, "Big")
+			So(pm, ShouldContainKey, "Medium")
+			So(pm["Small"][0].Value(), ShouldEqual, "small")
+			So(pm["Indexed"][0].Value(), ShouldEqual, o.Indexed)
+			So(pm[RefProperty], ShouldHaveLength, 1)
+			So(pm[RefProperty][0].Value(), ShouldEndWith, ":Big")
+			So(store.Len(), ShouldEqual, 1)
+
+			Convey("until the entity fits", func() {
+				o.Indexed = strings.Repeat("i", 700)
+				So(d.Put(o), ShouldBeNil)
+				pm := raw(o)
+				So(pm, ShouldNotContainKey, "Big")
+				So(pm, ShouldNotContainKey, "Medium")
+				So(pm, ShouldContainKey, "Small")
+				So(pm[RefProperty], ShouldHaveLength, 2)
+			})
+		})
+
+		Convey("leaves small entities alone", func() {
+			o := &object{ID: 2, Big: []byte("b"), Small: "small"}
+			So(d.Put(o), ShouldBeNil)
+			So(raw(o), ShouldNotContainKey, RefProperty)
+		})
+
+		Convey("restores them", func() {
+			got := &object{ID: 1}
+			So(d.Get(got), ShouldBeNil)
+			So(got, ShouldResemble, o)
+
+			Convey("in queries too", func() {
+				objs := []*object{}
+				So(d.GetAll(ds.NewQuery("object"), &objs), ShouldBeNil)
+				So(objs, ShouldResemble, []*object{o})
+			})
+		})
+
+		Convey("when disabled, stores entities as they are, but still restores them", func() {
+			d := ds.Get(filter.Disable(c, filter.BlobOverflow))
+			o2 := &object{ID: 2, Big: o.Big}
+			So(d.Put(o2), ShouldBeNil)
+			So(raw(o2)["Big"][0].Value(), ShouldResemble, o.Big)
+			So(store.Len(), ShouldEqual, 1)
+
+			got := &object{ID: 1}
+			So(d.Get(got), ShouldBeNil)
+			So(got, ShouldResemble, o)
+		})
+
+		Convey("reports missing blobs", func() {
+			c := FilterRDS(memory.Use(context.Background()), &MemoryStore{}, 1000)
+			pm := raw(o)
+			So(ds.Get(c).Put(pm), ShouldBeNil)
+
+			got := &object{ID: 1}
+			So(ds.Get(c).Get(got), ShouldErrLike, `reading property "Big": no such blob`)
+		})
+	})
+}
@@ -0,0 +1,68 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package blobOverflow
+
+import (
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+type overflower struct {
+	ds.RawInterface
+
+	c         context.Context
+	store     Store
+	threshold int64
+}
+
+var _ ds.RawInterface = (*overflower)(nil)
+
+func (d *overflower) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	newVals := make([]ds.PropertyMap, len(vals))
+	for i, pm := range vals {
+		pm, err := overflow(d.c, d.store, pm, d.threshold)
+		if err != nil {
+			return err
+		}
+		newVals[i] = pm
+	}
+	return d.RawInterface.PutMulti(keys, newVals, cb)
+}
+
+// restorer is the filter used when blobOverflow is disabled with
+// filter.Disable: it stores entities as they are, but (as overflower) still
+// restores the properties which were moved to the Store when they're read.
+type restorer struct {
+	overflower
+}
+
+func (d *restorer) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	return d.RawInterface.PutMulti(keys, vals, cb)
+}
+
+func (d *overflower) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	return d.RawInterface.GetMulti(keys, metas, func(pm ds.PropertyMap, err error) error {
+		if err == nil && pm != nil {
+			pm, err = restore(d.c, d.store, pm)
+		}
+		if err != nil {
+			return cb(nil, err)
+		}
+		return cb(pm, nil)
+	})
+}
+
+func (d *overflower) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	if fq.KeysOnly() || len(fq.Project()) > 0 {
+		return d.RawInterface.Run(fq, cb)
+	}
+	return d.RawInterface.Run(fq, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		pm, err := restore(d.c, d.store, pm)
+		if err != nil {
+			return err
+		}
+		return cb(k, pm, gc)
+	})
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package callLog contains filters which log the calls made to the datastore,
+// memcache and taskqueue services, with their keys, at a log level chosen per
+// service, and a middleware which turns them on for a single request when the
+// request asks for it. This allows debugging a request in production without
+// redeploying it with extra instrumentation.
+//
+// The calls are logged with the luci-go logging package, so they're only
+// emitted if the context's log level allows them (see logging.SetLevel).
+package callLog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/luci/luci-go/common/errors"
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+)
+
+// These are the services whose calls can be logged.
+const (
+	Datastore = "datastore"
+	Memcache  = "memcache"
+	TaskQueue = "taskqueue"
+)
+
+// Levels is the log level of the calls to each service. The calls to services
+// which aren't in it aren't logged.
+type Levels map[string]log.Level
+
+var levelNames = map[string]log.Level{
+	"debug":   log.Debug,
+	"info":    log.Info,
+	"warning": log.Warning,
+	"error":   log.Error,
+}
+
+// ParseLevels parses Levels from a comma-separated list of services, each
+// optionally followed by "=" and the level of its calls (debug, info, warning
+// or error; debug if it's omitted). The service "*" stands for every service:
+//
+//	datastore=debug,memcache=info
+//	*
+func ParseLevels(s string) (Levels, error) {
+	ret := Levels{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		svc, lvl := part, log.Debug
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			svc = part[:i]
+			l, ok := levelNames[strings.ToLower(part[i+1:])]
+			if !ok {
+				return nil, fmt.Errorf("callLog: unknown level %q", part[i+1:])
+			}
+			lvl = l
+		}
+		switch svc {
+		case "*":
+			ret[Datastore], ret[Memcache], ret[TaskQueue] = lvl, lvl, lvl
+		case Datastore, Memcache, TaskQueue:
+			ret[svc] = lvl
+		default:
+			return nil, fmt.Errorf("callLog: unknown service %q", svc)
+		}
+	}
+	return ret, nil
+}
+
+// Filter installs filters which log every call to the services in levels
+// made with the context, at the service's level.
+func Filter(c context.Context, levels Levels) context.Context {
+	if l, ok := levels[Datastore]; ok {
+		c = filterRDS(c, l)
+	}
+	if l, ok := levels[Memcache]; ok {
+		c = filterMC(c, l)
+	}
+	if l, ok := levels[TaskQueue]; ok {
+		c = filterTQ(c, l)
+	}
+	return c
+}
+
+// logCall logs a call to method (e.g. "datastore.GetMulti"), which returned
+// err, at level l. args describes the call's arguments.
+func logCall(c context.Context, l log.Level, method, args string, err error) error {
+	if !log.IsLogging(c, l) {
+		return err
+	}
+	if err != nil {
+		log.Get(c).LogCall(l, 1, "callLog: %s(%s) -> %s", []interface{}{method, args, err})
+	} else {
+		log.Get(c).LogCall(l, 1, "callLog: %s(%s)", []interface{}{method, args})
+	}
+	return err
+}
+
+// itemErrs collects the errors which a multi call passes to its callback, one
+// per item, so that they can be logged as the call's error.
+type itemErrs struct {
+	sync.Mutex
+
+	errs errors.MultiError
+	bad  bool
+}
+
+func (e *itemErrs) add(err error) {
+	e.Lock()
+	defer e.Unlock()
+	e.errs = append(e.errs, err)
+	e.bad = e.bad || err != nil
+}
+
+// or returns err if it's not nil, and otherwise the items' errors (or nil if
+// every item succeeded).
+func (e *itemErrs) or(err error) error {
+	if err == nil && e.bad {
+		return e.errs
+	}
+	return err
+}
@@ -0,0 +1,104 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callLog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	log "github.com/luci/luci-go/common/logging"
+	"github.com/luci/luci-go/common/logging/memlogger"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	mc "github.com/tetrafolium/gae/service/memcache"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"golang.org/x/net/context"
+)
+
+func TestCallLog(t *testing.T) {
+	t.Parallel()
+
+	Convey("callLog", t, func() {
+		c := log.SetLevel(memory.Use(context.Background()), log.Debug)
+		messages := func(c context.Context) []memlogger.LogEntry {
+			return log.Get(c).(*memlogger.MemLogger).Messages()
+		}
+		doCalls := func(c context.Context) {
+			So(ds.Get(c).Put(ds.PropertyMap{"$key": {ds.MkPropertyNI(ds.Get(c).MakeKey("K", 1))}}), ShouldBeNil)
+			_, err := mc.Get(c).Get("missing")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+			So(tq.Get(c).Add(&tq.Task{Name: "t", Path: "/"}, ""), ShouldBeNil)
+		}
+
+		Convey("ParseLevels", func() {
+			l, err := ParseLevels("datastore=debug, memcache=INFO,taskqueue")
+			So(err, ShouldBeNil)
+			So(l, ShouldResemble, Levels{Datastore: log.Debug, Memcache: log.Info, TaskQueue: log.Debug})
+
+			l, err = ParseLevels("*=warning")
+			So(err, ShouldBeNil)
+			So(l, ShouldResemble, Levels{Datastore: log.Warning, Memcache: log.Warning, TaskQueue: log.Warning})
+
+			_, err = ParseLevels("mail")
+			So(err.Error(), ShouldContainSubstring, `unknown service "mail"`)
+			_, err = ParseLevels("datastore=loud")
+			So(err.Error(), ShouldContainSubstring, `unknown level "loud"`)
+		})
+
+		Convey("logs the calls of the chosen services", func() {
+			doCalls(Filter(c, Levels{Datastore: log.Info, TaskQueue: log.Debug}))
+
+			msgs := messages(c)
+			So(len(msgs), ShouldEqual, 2)
+			So(msgs[0].Level, ShouldEqual, log.Info)
+			So(msgs[0].Msg, ShouldEqual, "callLog: datastore.PutMulti([dev~app::/K,1])")
+			So(msgs[1].Level, ShouldEqual, log.Debug)
+			So(msgs[1].Msg, ShouldEqual, `callLog: taskqueue.AddMulti([t], "")`)
+		})
+
+		Convey("logs errors", func() {
+			doCalls(Filter(c, Levels{Memcache: log.Debug}))
+			msgs := messages(c)
+			So(len(msgs), ShouldEqual, 1)
+			So(msgs[0].Msg, ShouldEqual, "callLog: memcache.GetMulti([missing]) -> memcache: cache miss")
+		})
+
+		Convey("respects the context's log level", func() {
+			c := log.SetLevel(c, log.Info)
+			doCalls(Filter(c, Levels{Datastore: log.Debug}))
+			So(messages(c), ShouldBeEmpty)
+		})
+
+		Convey("Middleware", func() {
+			c := log.SetLevel(c, log.Error)
+			h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+				doCalls(c)
+			})
+			r, _ := http.NewRequest("GET", "/", nil)
+
+			Convey("does nothing without the header", func() {
+				h(c, httptest.NewRecorder(), r)
+				So(messages(c), ShouldBeEmpty)
+			})
+
+			Convey("logs the calls named by the header", func() {
+				r.Header.Set(DebugHeader, "*")
+				h(c, httptest.NewRecorder(), r)
+				So(len(messages(c)), ShouldEqual, 3)
+			})
+
+			Convey("ignores a bad header", func() {
+				r.Header.Set(DebugHeader, "mail")
+				c := log.SetLevel(c, log.Warning)
+				h(c, httptest.NewRecorder(), r)
+				msgs := messages(c)
+				So(len(msgs), ShouldEqual, 1)
+				So(msgs[0].Level, ShouldEqual, log.Warning)
+			})
+		})
+	})
+}
@@ -0,0 +1,99 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callLog
+
+import (
+	"fmt"
+
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	mc "github.com/tetrafolium/gae/service/memcache"
+)
+
+type mcLog struct {
+	mc.RawInterface
+
+	c context.Context
+	l log.Level
+}
+
+var _ mc.RawInterface = (*mcLog)(nil)
+
+func (m *mcLog) log(method, args string, err error) error {
+	return logCall(m.c, m.l, "memcache."+method, args, err)
+}
+
+func itemKeys(items []mc.Item) []string {
+	ret := make([]string, len(items))
+	for i, itm := range items {
+		ret[i] = itm.Key()
+	}
+	return ret
+}
+
+func (m *mcLog) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	ie := &itemErrs{}
+	err := m.RawInterface.AddMulti(items, func(err error) {
+		ie.add(err)
+		cb(err)
+	})
+	return m.log("AddMulti", fmt.Sprint(itemKeys(items)), ie.or(err))
+}
+
+func (m *mcLog) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	ie := &itemErrs{}
+	err := m.RawInterface.SetMulti(items, func(err error) {
+		ie.add(err)
+		cb(err)
+	})
+	return m.log("SetMulti", fmt.Sprint(itemKeys(items)), ie.or(err))
+}
+
+func (m *mcLog) GetMulti(keys []string, cb mc.RawItemCB) error {
+	ie := &itemErrs{}
+	err := m.RawInterface.GetMulti(keys, func(itm mc.Item, err error) {
+		ie.add(err)
+		cb(itm, err)
+	})
+	return m.log("GetMulti", fmt.Sprint(keys), ie.or(err))
+}
+
+func (m *mcLog) DeleteMulti(keys []string, cb mc.RawCB) error {
+	ie := &itemErrs{}
+	err := m.RawInterface.DeleteMulti(keys, func(err error) {
+		ie.add(err)
+		cb(err)
+	})
+	return m.log("DeleteMulti", fmt.Sprint(keys), ie.or(err))
+}
+
+func (m *mcLog) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	ie := &itemErrs{}
+	err := m.RawInterface.CompareAndSwapMulti(items, func(err error) {
+		ie.add(err)
+		cb(err)
+	})
+	return m.log("CompareAndSwapMulti", fmt.Sprint(itemKeys(items)), ie.or(err))
+}
+
+func (m *mcLog) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	v, err := m.RawInterface.Increment(key, delta, initialValue)
+	return v, m.log("Increment", fmt.Sprintf("%q, %d", key, delta), err)
+}
+
+func (m *mcLog) Flush() error {
+	return m.log("Flush", "", m.RawInterface.Flush())
+}
+
+func filterMC(c context.Context, l log.Level) context.Context {
+	return mc.AddRawFilters(c, func(ic context.Context, rmc mc.RawInterface) mc.RawInterface {
+		if filter.IsDisabled(ic, filter.CallLog) {
+			return rmc
+		}
+		return &mcLog{rmc, ic, l}
+	})
+}
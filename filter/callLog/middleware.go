@@ -0,0 +1,40 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callLog
+
+import (
+	"net/http"
+
+	"github.com/tetrafolium/gae/filter"
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+)
+
+// DebugHeader is the request header which asks Middleware to log the
+// request's service calls. Its value is parsed with ParseLevels.
+const DebugHeader = "X-Gae-Call-Log"
+
+// Middleware returns a Handler which calls h. If the request has a non-empty
+// DebugHeader, h's context logs the service calls which it names (with
+// Filter), and its log level is lowered if necessary so that they're emitted.
+// An invalid DebugHeader is logged as a warning, and otherwise ignored.
+func Middleware(h filter.Handler) filter.Handler {
+	return func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+		if hdr := r.Header.Get(DebugHeader); hdr != "" {
+			levels, err := ParseLevels(hdr)
+			if err != nil {
+				(log.Fields{log.ErrorKey: err}).Warningf(c, "callLog: bad %s header", DebugHeader)
+			} else {
+				for _, l := range levels {
+					if !log.IsLogging(c, l) {
+						c = log.SetLevel(c, l)
+					}
+				}
+				c = Filter(c, levels)
+			}
+		}
+		h(c, rw, r)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callLog
+
+import (
+	"fmt"
+
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+type dsLog struct {
+	ds.RawInterface
+
+	c context.Context
+	l log.Level
+}
+
+var _ ds.RawInterface = (*dsLog)(nil)
+
+func (d *dsLog) log(method, args string, err error) error {
+	return logCall(d.c, d.l, "datastore."+method, args, err)
+}
+
+func (d *dsLog) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	start, err := d.RawInterface.AllocateIDs(incomplete, n)
+	return start, d.log("AllocateIDs", fmt.Sprintf("%s, %d", incomplete, n), err)
+}
+
+func (d *dsLog) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	return d.log("Run", q.String(), d.RawInterface.Run(q, cb))
+}
+
+func (d *dsLog) Count(q *ds.FinalizedQuery) (int64, error) {
+	count, err := d.RawInterface.Count(q)
+	return count, d.log("Count", q.String(), err)
+}
+
+func (d *dsLog) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	return d.log("RunInTransaction", fmt.Sprintf("%+v", opts), d.RawInterface.RunInTransaction(f, opts))
+}
+
+func (d *dsLog) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	ie := &itemErrs{}
+	err := d.RawInterface.DeleteMulti(keys, func(err error) error {
+		ie.add(err)
+		return cb(err)
+	})
+	return d.log("DeleteMulti", fmt.Sprint(keys), ie.or(err))
+}
+
+func (d *dsLog) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	ie := &itemErrs{}
+	err := d.RawInterface.GetMulti(keys, meta, func(val ds.PropertyMap, err error) error {
+		ie.add(err)
+		return cb(val, err)
+	})
+	return d.log("GetMulti", fmt.Sprint(keys), ie.or(err))
+}
+
+func (d *dsLog) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	ie := &itemErrs{}
+	err := d.RawInterface.PutMulti(keys, vals, func(key *ds.Key, err error) error {
+		ie.add(err)
+		return cb(key, err)
+	})
+	return d.log("PutMulti", fmt.Sprint(keys), ie.or(err))
+}
+
+func filterRDS(c context.Context, l log.Level) context.Context {
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(ic, filter.CallLog) {
+			return rds
+		}
+		return &dsLog{rds, ic, l}
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callLog
+
+import (
+	"fmt"
+
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+)
+
+type tqLog struct {
+	tq.RawInterface
+
+	c context.Context
+	l log.Level
+}
+
+var _ tq.RawInterface = (*tqLog)(nil)
+
+func (t *tqLog) log(method, args string, err error) error {
+	return logCall(t.c, t.l, "taskqueue."+method, args, err)
+}
+
+// taskArgs describes the tasks of a call by their names (or paths, for tasks
+// which don't have a name yet).
+func taskArgs(tasks []*tq.Task, queueName string) string {
+	names := make([]string, len(tasks))
+	for i, tsk := range tasks {
+		if tsk.Name != "" {
+			names[i] = tsk.Name
+		} else {
+			names[i] = tsk.Path
+		}
+	}
+	return fmt.Sprintf("%v, %q", names, queueName)
+}
+
+func (t *tqLog) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	ie := &itemErrs{}
+	err := t.RawInterface.AddMulti(tasks, queueName, func(tsk *tq.Task, err error) {
+		ie.add(err)
+		cb(tsk, err)
+	})
+	return t.log("AddMulti", taskArgs(tasks, queueName), ie.or(err))
+}
+
+func (t *tqLog) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	ie := &itemErrs{}
+	err := t.RawInterface.DeleteMulti(tasks, queueName, func(err error) {
+		ie.add(err)
+		cb(err)
+	})
+	return t.log("DeleteMulti", taskArgs(tasks, queueName), ie.or(err))
+}
+
+func (t *tqLog) Purge(queueName string) error {
+	return t.log("Purge", fmt.Sprintf("%q", queueName), t.RawInterface.Purge(queueName))
+}
+
+func filterTQ(c context.Context, l log.Level) context.Context {
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		if filter.IsDisabled(ic, filter.CallLog) {
+			return rtq
+		}
+		return &tqLog{rtq, ic, l}
+	})
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package callStats contains filters which count and time the calls made to
+// the datastore, memcache and taskqueue services, and a middleware which
+// reports them for a request (as a Server-Timing trailer, which browsers show
+// in their developer tools) when the request asks for it.
+//
+// The calls are recorded with the count filters, which this package only
+// summarizes.
+package callStats
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tetrafolium/gae/filter/count"
+	"golang.org/x/net/context"
+)
+
+// Call is the summary of the calls to a single service method.
+type Call struct {
+	Count  int
+	Errors int
+
+	// Duration is the total wall-clock duration of the calls (see
+	// count.Entry.Duration).
+	Duration time.Duration
+
+	// Reads is the number of datastore entity reads that production would bill
+	// the calls for (see count.FilterRDS).
+	Reads int64
+}
+
+// Stats holds the Calls made with a context returned by Filter. It's safe for
+// concurrent use.
+type Stats struct {
+	ds *count.DSCounter
+	mc *count.MCCounter
+	tq *count.TQCounter
+}
+
+// Calls returns a snapshot of the calls made so far, by method name (e.g.
+// "datastore.GetMulti"). Methods which weren't called, and memcache NewItem
+// (which isn't a call to the service), are omitted.
+func (s *Stats) Calls() map[string]Call {
+	ret := map[string]Call{}
+	add := func(service string, counter interface{}) {
+		v := reflect.ValueOf(counter).Elem()
+		for i := 0; i < v.NumField(); i++ {
+			name := v.Type().Field(i).Name
+			e := v.Field(i).Addr().Interface().(*count.Entry)
+			if e.Total() == 0 || name == "NewItem" {
+				continue
+			}
+			ret[service+"."+name] = Call{
+				Count:    int(e.Total()),
+				Errors:   e.Errors(),
+				Duration: e.Duration(),
+				Reads:    e.Reads(),
+			}
+		}
+	}
+	add("datastore", s.ds)
+	add("memcache", s.mc)
+	add("taskqueue", s.tq)
+	return ret
+}
+
+// ServerTiming renders the calls made so far as the value of a Server-Timing
+// header, e.g.
+//
+//   datastore.GetMulti;dur=12.5;desc="3 calls, 7 reads", memcache.GetMulti;dur=1;desc="2 calls, 1 error"
+//
+// Methods are in alphabetical order, and durations are in milliseconds.
+func (s *Stats) ServerTiming() string {
+	calls := s.Calls()
+	methods := make([]string, 0, len(calls))
+	for method := range calls {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	metrics := make([]string, len(methods))
+	for i, method := range methods {
+		call := calls[method]
+		desc := fmt.Sprintf("%d calls", call.Count)
+		if call.Count == 1 {
+			desc = "1 call"
+		}
+		switch call.Errors {
+		case 0:
+		case 1:
+			desc += ", 1 error"
+		default:
+			desc += fmt.Sprintf(", %d errors", call.Errors)
+		}
+		switch call.Reads {
+		case 0:
+		case 1:
+			desc += ", 1 read"
+		default:
+			desc += fmt.Sprintf(", %d reads", call.Reads)
+		}
+		ms := float64(call.Duration) / float64(time.Millisecond)
+		metrics[i] = fmt.Sprintf("%s;dur=%g;desc=%q", method, ms, desc)
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// Filter installs the datastore, memcache and taskqueue count filters in the
+// context, and returns the Stats which summarizes them. The datastore reads are
+// counted as described by count.FilterRDS.
+func Filter(c context.Context) (context.Context, *Stats) {
+	s := &Stats{}
+	c, s.ds = count.FilterRDS(c)
+	c, s.mc = count.FilterMC(c)
+	c, s.tq = count.FilterTQ(c)
+	return c, s
+}
@@ -0,0 +1,126 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callStats
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/tetrafolium/gae/filter/featureBreaker"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	mc "github.com/tetrafolium/gae/service/memcache"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestCallStats(t *testing.T) {
+	t.Parallel()
+
+	Convey("callStats", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), now)
+		c = memory.Use(c)
+
+		Convey("records service calls", func() {
+			c, s := Filter(c)
+			So(ds.Get(c).Put(ds.PropertyMap{"$key": {ds.MkPropertyNI(ds.Get(c).MakeKey("K", 1))}}), ShouldBeNil)
+			_, err := mc.Get(c).Get("missing")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+			So(tq.Get(c).Add(tq.Get(c).NewTask("/"), ""), ShouldBeNil)
+
+			So(s.Calls(), ShouldResemble, map[string]Call{
+				"datastore.PutMulti": {Count: 1},
+				"memcache.GetMulti":  {Count: 1},
+				"taskqueue.AddMulti": {Count: 1},
+			})
+		})
+
+		Convey("counts billed datastore reads", func() {
+			d := ds.Get(c)
+			So(d.Put(ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("K", 1))}}), ShouldBeNil)
+			d.Testable().CatchupIndexes()
+
+			c, s := Filter(c)
+			d = ds.Get(c)
+			So(d.Run(ds.NewQuery("K"), func(ds.PropertyMap) {}), ShouldBeNil)
+			So(s.Calls()["datastore.Run"], ShouldResemble, Call{Count: 1, Reads: 2})
+		})
+
+		Convey("renders Server-Timing", func() {
+			d := ds.Get(c)
+			So(d.Put(ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("K", 1))}}), ShouldBeNil)
+			d.Testable().CatchupIndexes()
+
+			c, fb := featureBreaker.FilterRDS(c, nil)
+			fb.BreakFeatures(nil, "GetMulti")
+			c, s := Filter(c)
+			d = ds.Get(c)
+			So(d.Run(ds.NewQuery("K"), func(ds.PropertyMap) {
+				tc.Add(1500 * time.Microsecond)
+			}), ShouldBeNil)
+			So(d.Get(&ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("K", 1))}}), ShouldNotBeNil)
+			_, err := mc.Get(c).Get("missing")
+			So(err, ShouldEqual, mc.ErrCacheMiss)
+
+			So(s.ServerTiming(), ShouldEqual,
+				`datastore.GetMulti;dur=0;desc="1 call, 1 error, 1 read", `+
+					`datastore.Run;dur=1.5;desc="1 call, 2 reads", `+
+					`memcache.GetMulti;dur=0;desc="1 call"`)
+		})
+
+		Convey("Middleware", func() {
+			h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+				_, _ = mc.Get(c).Get("missing")
+				rw.Write([]byte("hi"))
+				_, _ = mc.Get(c).Get("missing")
+			})
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			rec := httptest.NewRecorder()
+
+			Convey("reports calls in a trailer when asked to", func() {
+				req.Header.Set(DebugHeader, "1")
+				h(c, rec, req)
+				res := rec.Result()
+				So(res.Header.Get("Server-Timing"), ShouldEqual, "")
+				So(res.Trailer.Get("Server-Timing"), ShouldEqual, `memcache.GetMulti;dur=0;desc="2 calls"`)
+				So(rec.Body.String(), ShouldEqual, "hi")
+			})
+
+			Convey("reports calls in a header if nothing was written", func() {
+				req.Header.Set(DebugHeader, "1")
+				h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+					_, _ = mc.Get(c).Get("missing")
+				})
+				h(c, rec, req)
+				res := rec.Result()
+				So(res.Header.Get("Server-Timing"), ShouldEqual, `memcache.GetMulti;dur=0;desc="1 call"`)
+				So(res.Header.Get("Trailer"), ShouldEqual, "")
+			})
+
+			Convey("passes Flusher and CloseNotifier through", func() {
+				req.Header.Set(DebugHeader, "1")
+				h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+					So(rw.(http.CloseNotifier).CloseNotify(), ShouldNotBeNil)
+					rw.(http.Flusher).Flush()
+				})
+				h(c, rec, req)
+				So(rec.Flushed, ShouldBeTrue)
+				So(rec.Result().Header.Get("Trailer"), ShouldEqual, "Server-Timing")
+			})
+
+			Convey("does nothing otherwise", func() {
+				h(c, rec, req)
+				So(rec.Result().Header.Get("Server-Timing"), ShouldEqual, "")
+				So(rec.Body.String(), ShouldEqual, "hi")
+			})
+		})
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package callStats
+
+import (
+	"net/http"
+
+	"github.com/tetrafolium/gae/filter"
+	"golang.org/x/net/context"
+)
+
+// DebugHeader is the request header which asks Middleware to report the
+// service calls made by the request.
+const DebugHeader = "X-Gae-Call-Stats"
+
+// Middleware returns a Handler which calls h. If the request has a non-empty
+// DebugHeader, h's context records its service calls (with Filter), and they
+// are reported as of when h returns, in the response's Server-Timing trailer.
+// If h doesn't write anything, they're reported in the Server-Timing header
+// instead.
+func Middleware(h filter.Handler) filter.Handler {
+	return func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(DebugHeader) == "" {
+			h(c, rw, r)
+			return
+		}
+		c, s := Filter(c)
+		sw := &statsWriter{ResponseWriter: rw}
+		h(c, sw, r)
+		// Before the headers are written, this sets the header, and after, the
+		// trailer declared by statsWriter.WriteHeader.
+		rw.Header().Set("Server-Timing", s.ServerTiming())
+	}
+}
+
+// statsWriter declares the Server-Timing trailer just before the response's
+// headers are written. It passes http.Flusher and http.CloseNotifier through
+// to the underlying ResponseWriter.
+type statsWriter struct {
+	http.ResponseWriter
+
+	wroteHeader bool
+}
+
+var (
+	_ http.Flusher       = (*statsWriter)(nil)
+	_ http.CloseNotifier = (*statsWriter)(nil)
+)
+
+func (w *statsWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.Header().Add("Trailer", "Server-Timing")
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statsWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush flushes the underlying ResponseWriter, if it's an http.Flusher.
+// Flushing writes the headers, so the trailer is declared first.
+func (w *statsWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify returns the underlying ResponseWriter's close notification
+// channel, or a channel which never receives anything if it isn't an
+// http.CloseNotifier.
+func (w *statsWriter) CloseNotify() <-chan bool {
+	if cn, ok := w.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
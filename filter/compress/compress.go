@@ -0,0 +1,198 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package compress contains a datastore filter which compresses large
+// unindexed []byte and string properties when entities are put, and
+// decompresses them when entities are read. This helps to keep large entities
+// under the datastore's size limit, without compressing them in the
+// application.
+//
+// A property is compressed if all of its values are unindexed and have the
+// same type ([]byte or string), their total size is at least the filter's
+// threshold, and compression makes them smaller. Each value is replaced with
+// its compressed bytes, and the entity records this in its FlagProperty, so
+// that entities written without the filter (or before it was installed) are
+// read as they are.
+//
+// Entities must always be read with the filter installed once they've been
+// written with it. Projection queries are unaffected, since they can't return
+// unindexed properties.
+package compress
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+// DefaultThreshold is the total size of a property's values at which it's
+// compressed, if FilterRDS is given a threshold of 0.
+const DefaultThreshold = 64 * 1024
+
+// FlagProperty is the name of the unindexed property which records which of
+// an entity's properties are compressed, and how. Each of its values is
+//   <codec>:<type>:<property name>
+// where codec is "zlib", and type is "bytes" or "string" (the original type
+// of the property's values).
+const FlagProperty = "_compressed"
+
+const codecZlib = "zlib"
+
+// FilterRDS installs the compressing datastore filter in the context.
+// Properties whose values have a total size of at least threshold bytes are
+// compressed. If threshold is 0, DefaultThreshold is used.
+func FilterRDS(c context.Context, threshold int) context.Context {
+	if threshold <= 0 {
+		threshold = DefaultThreshold
+	}
+	return ds.AddRawFilters(c, func(c context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(c, filter.Compress) {
+			return &decompressor{compressor{rds, threshold}}
+		}
+		return &compressor{rds, threshold}
+	})
+}
+
+// compressibleSize returns the total size of vals, and their type name (for
+// FlagProperty), if they can be compressed.
+func compressibleSize(vals []ds.Property) (size int, typ string, ok bool) {
+	for _, v := range vals {
+		if v.IndexSetting() != ds.NoIndex {
+			return 0, "", false
+		}
+		t := ""
+		switch x := v.Value().(type) {
+		case []byte:
+			t, size = "bytes", size+len(x)
+		case string:
+			t, size = "string", size+len(x)
+		default:
+			return 0, "", false
+		}
+		if typ != "" && t != typ {
+			return 0, "", false
+		}
+		typ = t
+	}
+	return size, typ, typ != ""
+}
+
+func zlibCompress(data []byte) []byte {
+	buf := bytes.Buffer{}
+	w := zlib.NewWriter(&buf)
+	// errs can't happen, since we're using a byte buffer.
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func zlibDecompress(data []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+// compress returns pm with its large properties compressed. pm isn't
+// modified; if nothing needs compressing, pm itself is returned.
+func compress(pm ds.PropertyMap, threshold int) ds.PropertyMap {
+	ret := pm
+	copied := false
+	for name, vals := range pm {
+		if strings.HasPrefix(name, "$") || name == FlagProperty {
+			continue
+		}
+		size, typ, ok := compressibleSize(vals)
+		if !ok || size < threshold {
+			continue
+		}
+
+		newVals := make([]ds.Property, len(vals))
+		newSize := 0
+		for i, v := range vals {
+			data := []byte(nil)
+			switch x := v.Value().(type) {
+			case []byte:
+				data = x
+			case string:
+				data = []byte(x)
+			}
+			data = zlibCompress(data)
+			newSize += len(data)
+			newVals[i] = ds.MkPropertyNI(data)
+		}
+		if newSize >= size {
+			continue
+		}
+
+		if !copied {
+			ret = make(ds.PropertyMap, len(pm)+1)
+			for k, v := range pm {
+				ret[k] = v
+			}
+			ret[FlagProperty] = nil
+			copied = true
+		}
+		ret[name] = newVals
+		ret[FlagProperty] = append(ret[FlagProperty],
+			ds.MkPropertyNI(fmt.Sprintf("%s:%s:%s", codecZlib, typ, name)))
+	}
+	return ret
+}
+
+// decompress returns pm without FlagProperty, and with the properties which
+// it lists decompressed. pm isn't modified; if it has no FlagProperty, pm
+// itself is returned.
+func decompress(pm ds.PropertyMap) (ds.PropertyMap, error) {
+	flags, ok := pm[FlagProperty]
+	if !ok {
+		return pm, nil
+	}
+	ret := make(ds.PropertyMap, len(pm))
+	for k, v := range pm {
+		if k != FlagProperty {
+			ret[k] = v
+		}
+	}
+
+	for _, f := range flags {
+		flag, _ := f.Value().(string)
+		parts := strings.SplitN(flag, ":", 3)
+		if len(parts) != 3 || parts[0] != codecZlib {
+			return nil, fmt.Errorf("compress: invalid %s value: %q", FlagProperty, flag)
+		}
+		typ, name := parts[1], parts[2]
+
+		vals := ret[name]
+		newVals := make([]ds.Property, len(vals))
+		for i, v := range vals {
+			data, ok := v.Value().([]byte)
+			if !ok {
+				return nil, fmt.Errorf("compress: compressed property %q has type %s", name, v.Type())
+			}
+			data, err := zlibDecompress(data)
+			if err != nil {
+				return nil, fmt.Errorf("compress: decompressing property %q: %s", name, err)
+			}
+			switch typ {
+			case "bytes":
+				newVals[i] = ds.MkPropertyNI(data)
+			case "string":
+				newVals[i] = ds.MkPropertyNI(string(data))
+			default:
+				return nil, fmt.Errorf("compress: invalid %s value: %q", FlagProperty, flag)
+			}
+		}
+		ret[name] = newVals
+	}
+	return ret, nil
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package compress
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type object struct {
+	ID int64 `gae:"$id"`
+
+	Text    string   `gae:",noindex"`
+	Blobs   [][]byte `gae:",noindex"`
+	Indexed string
+}
+
+func TestCompress(t *testing.T) {
+	t.Parallel()
+
+	Convey("compress", t, func() {
+		c := memory.Use(context.Background())
+		under := ds.Get(c)
+		under.Testable().Consistent(true)
+		c = FilterRDS(c, 100)
+		d := ds.Get(c)
+
+		big := strings.Repeat("compressible ", 100)
+		o := &object{
+			ID:      1,
+			Text:    big,
+			Blobs:   [][]byte{[]byte(big), []byte("x")},
+			Indexed: big,
+		}
+		So(d.Put(o), ShouldBeNil)
+
+		Convey("compresses large unindexed properties", func() {
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.KeyForObj(o))}}
+			So(under.Get(&pm), ShouldBeNil)
+
+			So(pm["Text"][0].Value(), ShouldHaveSameTypeAs, []byte(nil))
+			So(len(pm["Text"][0].Value().([]byte)), ShouldBeLessThan, len(big))
+			So(len(pm["Blobs"]), ShouldEqual, 2)
+			So(pm["Indexed"][0].Value(), ShouldEqual, big)
+			So(pm[FlagProperty], ShouldHaveLength, 2)
+		})
+
+		Convey("decompresses them", func() {
+			got := &object{ID: 1}
+			So(d.Get(got), ShouldBeNil)
+			So(got, ShouldResemble, o)
+
+			Convey("in queries too", func() {
+				objs := []*object{}
+				So(d.GetAll(ds.NewQuery("object"), &objs), ShouldBeNil)
+				So(objs, ShouldResemble, []*object{o})
+			})
+		})
+
+		Convey("when disabled, stores entities as they are, but still decompresses them", func() {
+			d := ds.Get(filter.Disable(c, filter.Compress))
+			o2 := &object{ID: 2, Text: big}
+			So(d.Put(o2), ShouldBeNil)
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.KeyForObj(o2))}}
+			So(under.Get(&pm), ShouldBeNil)
+			So(pm["Text"][0].Value(), ShouldEqual, big)
+			So(pm, ShouldNotContainKey, FlagProperty)
+
+			got := &object{ID: 1}
+			So(d.Get(got), ShouldBeNil)
+			So(got, ShouldResemble, o)
+		})
+
+		Convey("leaves small and incompressible properties alone", func() {
+			random := make([]byte, 200)
+			rand.New(rand.NewSource(1)).Read(random)
+			o := &object{ID: 2, Text: "small", Blobs: [][]byte{bytes.Repeat([]byte{1}, 1), random}}
+			So(d.Put(o), ShouldBeNil)
+
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.KeyForObj(o))}}
+			So(under.Get(&pm), ShouldBeNil)
+			So(pm["Text"][0].Value(), ShouldEqual, "small")
+			So(pm, ShouldNotContainKey, FlagProperty)
+		})
+
+		Convey("reports corrupt entities", func() {
+			pm := ds.PropertyMap{
+				"$key":       {ds.MkPropertyNI(d.MakeKey("object", 3))},
+				"Text":       {ds.MkPropertyNI([]byte("not zlib"))},
+				FlagProperty: {ds.MkPropertyNI("zlib:string:Text")},
+			}
+			So(under.Put(pm), ShouldBeNil)
+			So(d.Get(&object{ID: 3}), ShouldErrLike, `compress: decompressing property "Text"`)
+		})
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package compress
+
+import (
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+type compressor struct {
+	ds.RawInterface
+
+	threshold int
+}
+
+var _ ds.RawInterface = (*compressor)(nil)
+
+func (d *compressor) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	newVals := make([]ds.PropertyMap, len(vals))
+	for i, pm := range vals {
+		newVals[i] = compress(pm, d.threshold)
+	}
+	return d.RawInterface.PutMulti(keys, newVals, cb)
+}
+
+// decompressor is the filter used when compress is disabled with
+// filter.Disable: it stores entities as they are, but (as compressor) still
+// decompresses the ones which were compressed when they're read.
+type decompressor struct {
+	compressor
+}
+
+func (d *decompressor) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	return d.RawInterface.PutMulti(keys, vals, cb)
+}
+
+func (d *compressor) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	return d.RawInterface.GetMulti(keys, metas, func(pm ds.PropertyMap, err error) error {
+		if err == nil && pm != nil {
+			pm, err = decompress(pm)
+		}
+		if err != nil {
+			return cb(nil, err)
+		}
+		return cb(pm, nil)
+	})
+}
+
+func (d *compressor) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	if fq.KeysOnly() || len(fq.Project()) > 0 {
+		return d.RawInterface.Run(fq, cb)
+	}
+	return d.RawInterface.Run(fq, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		pm, err := decompress(pm)
+		if err != nil {
+			return err
+		}
+		return cb(k, pm, gc)
+	})
+}
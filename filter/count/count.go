@@ -11,6 +11,10 @@ package count
 import (
 	"fmt"
 	"sync/atomic"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
 )
 
 type counter struct {
@@ -28,6 +32,10 @@ func (c *counter) get() int {
 // Entry is a success/fail pair for a single API method. It's returned
 // by the Counter interface.
 type Entry struct {
+	// These are accessed atomically, so they come first to be 64-bit aligned.
+	duration int64
+	reads    int64
+
 	successes counter
 	errors    counter
 }
@@ -50,6 +58,32 @@ func (e *Entry) Errors() int {
 	return e.errors.get()
 }
 
+// Duration returns the total wall-clock duration of the invocations for this
+// Entry. For methods with callbacks (e.g. datastore Run), it includes the time
+// spent in the callbacks, and for RunInTransaction it includes the calls made
+// in the transaction. Calls to the GlobalInfo service, which are mostly local,
+// aren't timed.
+func (e *Entry) Duration() time.Duration {
+	return time.Duration(atomic.LoadInt64(&e.duration))
+}
+
+// Reads returns the number of datastore entity reads that production bills
+// the invocations for this Entry for (see FilterRDS). It's always 0 for the
+// other services.
+func (e *Entry) Reads() int64 {
+	return atomic.LoadInt64(&e.reads)
+}
+
+// since adds the time elapsed since start to the Entry's duration. It's
+// deferred with start evaluated when the call begins.
+func (e *Entry) since(c context.Context, start time.Time) {
+	atomic.AddInt64(&e.duration, int64(clock.Now(c).Sub(start)))
+}
+
+func (e *Entry) addReads(n int64) {
+	atomic.AddInt64(&e.reads, n)
+}
+
 func (e *Entry) up(errs ...error) error {
 	err := error(nil)
 	if len(errs) > 0 {
@@ -7,7 +7,9 @@ package count
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/filter/featureBreaker"
 	"github.com/tetrafolium/gae/impl/memory"
 	"github.com/tetrafolium/gae/service/datastore"
@@ -16,6 +18,7 @@ import (
 	"github.com/tetrafolium/gae/service/memcache"
 	"github.com/tetrafolium/gae/service/taskqueue"
 	"github.com/tetrafolium/gae/service/user"
+	"github.com/luci/luci-go/common/clock/testclock"
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
@@ -77,6 +80,12 @@ func TestCount(t *testing.T) {
 			})
 		})
 
+		Convey("disabled filters don't count", func() {
+			ds := datastore.Get(filter.Disable(c, filter.Count))
+			So(ds.PutMulti(vals), ShouldBeNil)
+			So(ctr.PutMulti.Total(), ShouldEqual, 0)
+		})
+
 		Convey("errors count against errors", func() {
 			fb.BreakFeatures(nil, "GetMulti")
 
@@ -94,6 +103,36 @@ func TestCount(t *testing.T) {
 		})
 	})
 
+	Convey("times calls and counts billed datastore reads", t, func() {
+		c, tc := testclock.UseTime(context.Background(), time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC))
+		c, ctr := FilterRDS(memory.Use(c))
+		ds := datastore.Get(c)
+
+		for i := int64(1); i <= 5; i++ {
+			So(ds.Put(datastore.PropertyMap{
+				"$key": {datastore.MkPropertyNI(ds.MakeKey("Kind", i))}}), ShouldBeNil)
+		}
+		ds.Testable().CatchupIndexes()
+
+		So(ds.Get(&datastore.PropertyMap{
+			"$key": {datastore.MkPropertyNI(ds.MakeKey("Kind", 1))}}), ShouldBeNil)
+		So(ds.Run(datastore.NewQuery("Kind").Offset(2).Limit(2), func(datastore.PropertyMap) {
+			tc.Add(time.Millisecond)
+		}), ShouldBeNil)
+		So(ds.Run(datastore.NewQuery("Kind").Offset(2), func(*datastore.Key) {}), ShouldBeNil)
+		_, err := ds.Count(datastore.NewQuery("Kind"))
+		So(err, ShouldBeNil)
+
+		So(ctr.GetMulti.Reads(), ShouldEqual, 1)
+		// 1 for each query, plus 2 skipped and 2 returned by the first one.
+		So(ctr.Run.Reads(), ShouldEqual, 6)
+		So(ctr.Count.Reads(), ShouldEqual, 1)
+		So(ctr.PutMulti.Reads(), ShouldEqual, 0)
+
+		So(ctr.Run.Duration(), ShouldEqual, 2*time.Millisecond)
+		So(ctr.GetMulti.Duration(), ShouldEqual, 0)
+	})
+
 	Convey("works for memcache", t, func() {
 		c, ctr := FilterMC(memory.Use(context.Background()))
 		So(c, ShouldNotBeNil)
@@ -9,6 +9,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/info"
 )
 
@@ -144,10 +145,17 @@ func (g *infoCounter) SignBytes(bytes []byte) (string, []byte, error) {
 	return keyName, signature, g.c.SignBytes.up(err)
 }
 
+func (g *infoCounter) Testable() info.Testable {
+	return g.gi.Testable()
+}
+
 // FilterGI installs a counter GlobalInfo filter in the context.
 func FilterGI(c context.Context) (context.Context, *InfoCounter) {
 	state := &InfoCounter{}
 	return info.AddFilters(c, func(ic context.Context, gi info.Interface) info.Interface {
+		if filter.IsDisabled(ic, filter.Count) {
+			return gi
+		}
 		return &infoCounter{state, gi}
 	}), state
 }
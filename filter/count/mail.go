@@ -5,6 +5,8 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/mail"
 	"golang.org/x/net/context"
 )
@@ -18,16 +20,19 @@ type MailCounter struct {
 type mailCounter struct {
 	c *MailCounter
 
-	m mail.Interface
+	m  mail.Interface
+	ic context.Context
 }
 
 var _ mail.Interface = (*mailCounter)(nil)
 
 func (m *mailCounter) Send(msg *mail.Message) error {
+	defer m.c.Send.since(m.ic, clock.Now(m.ic))
 	return m.c.Send.up(m.m.Send(msg))
 }
 
 func (m *mailCounter) SendToAdmins(msg *mail.Message) error {
+	defer m.c.SendToAdmins.since(m.ic, clock.Now(m.ic))
 	return m.c.SendToAdmins.up(m.m.SendToAdmins(msg))
 }
 
@@ -39,6 +44,9 @@ func (m *mailCounter) Testable() mail.Testable {
 func FilterMail(c context.Context) (context.Context, *MailCounter) {
 	state := &MailCounter{}
 	return mail.AddFilters(c, func(ic context.Context, u mail.Interface) mail.Interface {
-		return &mailCounter{state, u}
+		if filter.IsDisabled(ic, filter.Count) {
+			return u
+		}
+		return &mailCounter{state, u, ic}
 	}), state
 }
@@ -5,8 +5,10 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	mc "github.com/tetrafolium/gae/service/memcache"
 )
 
@@ -27,6 +29,7 @@ type mcCounter struct {
 	c *MCCounter
 
 	mc mc.RawInterface
+	ic context.Context
 }
 
 var _ mc.RawInterface = (*mcCounter)(nil)
@@ -37,33 +40,43 @@ func (m *mcCounter) NewItem(key string) mc.Item {
 }
 
 func (m *mcCounter) GetMulti(keys []string, cb mc.RawItemCB) error {
+	defer m.c.GetMulti.since(m.ic, clock.Now(m.ic))
 	return m.c.GetMulti.up(m.mc.GetMulti(keys, cb))
 }
 
 func (m *mcCounter) AddMulti(items []mc.Item, cb mc.RawCB) error {
+	defer m.c.AddMulti.since(m.ic, clock.Now(m.ic))
 	return m.c.AddMulti.up(m.mc.AddMulti(items, cb))
 }
 
 func (m *mcCounter) SetMulti(items []mc.Item, cb mc.RawCB) error {
+	defer m.c.SetMulti.since(m.ic, clock.Now(m.ic))
 	return m.c.SetMulti.up(m.mc.SetMulti(items, cb))
 }
 
 func (m *mcCounter) DeleteMulti(keys []string, cb mc.RawCB) error {
+	defer m.c.DeleteMulti.since(m.ic, clock.Now(m.ic))
 	return m.c.DeleteMulti.up(m.mc.DeleteMulti(keys, cb))
 }
 
 func (m *mcCounter) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
+	defer m.c.CompareAndSwapMulti.since(m.ic, clock.Now(m.ic))
 	return m.c.CompareAndSwapMulti.up(m.mc.CompareAndSwapMulti(items, cb))
 }
 
-func (m *mcCounter) Flush() error { return m.c.Flush.up(m.mc.Flush()) }
+func (m *mcCounter) Flush() error {
+	defer m.c.Flush.since(m.ic, clock.Now(m.ic))
+	return m.c.Flush.up(m.mc.Flush())
+}
 
 func (m *mcCounter) Increment(key string, delta int64, initialValue *uint64) (newValue uint64, err error) {
+	defer m.c.Increment.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mc.Increment(key, delta, initialValue)
 	return ret, m.c.Increment.up(err)
 }
 
 func (m *mcCounter) Stats() (*mc.Statistics, error) {
+	defer m.c.Stats.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mc.Stats()
 	return ret, m.c.Stats.up(err)
 }
@@ -72,6 +85,9 @@ func (m *mcCounter) Stats() (*mc.Statistics, error) {
 func FilterMC(c context.Context) (context.Context, *MCCounter) {
 	state := &MCCounter{}
 	return mc.AddRawFilters(c, func(ic context.Context, mc mc.RawInterface) mc.RawInterface {
-		return &mcCounter{state, mc}
+		if filter.IsDisabled(ic, filter.Count) {
+			return mc
+		}
+		return &mcCounter{state, mc, ic}
 	}), state
 }
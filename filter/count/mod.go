@@ -5,8 +5,10 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/module"
 )
 
@@ -25,39 +27,47 @@ type modCounter struct {
 	c *ModuleCounter
 
 	mod module.Interface
+	ic  context.Context
 }
 
 var _ module.Interface = (*modCounter)(nil)
 
 func (m *modCounter) List() ([]string, error) {
+	defer m.c.List.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mod.List()
 	return ret, m.c.List.up(err)
 }
 
 func (m *modCounter) NumInstances(mod, ver string) (int, error) {
+	defer m.c.NumInstances.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mod.NumInstances(mod, ver)
 	return ret, m.c.NumInstances.up(err)
 }
 
 func (m *modCounter) SetNumInstances(mod, ver string, instances int) error {
+	defer m.c.SetNumInstances.since(m.ic, clock.Now(m.ic))
 	return m.c.SetNumInstances.up(m.mod.SetNumInstances(mod, ver, instances))
 }
 
 func (m *modCounter) Versions(mod string) ([]string, error) {
+	defer m.c.Versions.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mod.Versions(mod)
 	return ret, m.c.Versions.up(err)
 }
 
 func (m *modCounter) DefaultVersion(mod string) (string, error) {
+	defer m.c.DefaultVersion.since(m.ic, clock.Now(m.ic))
 	ret, err := m.mod.DefaultVersion(mod)
 	return ret, m.c.DefaultVersion.up(err)
 }
 
 func (m *modCounter) Start(mod, ver string) error {
+	defer m.c.Start.since(m.ic, clock.Now(m.ic))
 	return m.c.Start.up(m.mod.Start(mod, ver))
 }
 
 func (m *modCounter) Stop(mod, ver string) error {
+	defer m.c.Stop.since(m.ic, clock.Now(m.ic))
 	return m.c.Stop.up(m.mod.Stop(mod, ver))
 }
 
@@ -65,6 +75,9 @@ func (m *modCounter) Stop(mod, ver string) error {
 func FilterModule(c context.Context) (context.Context, *ModuleCounter) {
 	state := &ModuleCounter{}
 	return module.AddFilters(c, func(ic context.Context, mod module.Interface) module.Interface {
-		return &modCounter{state, mod}
+		if filter.IsDisabled(ic, filter.Count) {
+			return mod
+		}
+		return &modCounter{state, mod, ic}
 	}), state
 }
@@ -5,8 +5,10 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	ds "github.com/tetrafolium/gae/service/datastore"
 )
 
@@ -26,42 +28,66 @@ type dsCounter struct {
 	c *DSCounter
 
 	ds ds.RawInterface
+	ic context.Context
 }
 
 var _ ds.RawInterface = (*dsCounter)(nil)
 
 func (r *dsCounter) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
+	defer r.c.AllocateIDs.since(r.ic, clock.Now(r.ic))
 	start, err := r.ds.AllocateIDs(incomplete, n)
 	return start, r.c.AllocateIDs.up(err)
 }
 
 func (r *dsCounter) DecodeCursor(s string) (ds.Cursor, error) {
+	defer r.c.DecodeCursor.since(r.ic, clock.Now(r.ic))
 	cursor, err := r.ds.DecodeCursor(s)
 	return cursor, r.c.DecodeCursor.up(err)
 }
 
 func (r *dsCounter) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
-	return r.c.Run.up(r.ds.Run(q, cb))
+	defer r.c.Run.since(r.ic, clock.Now(r.ic))
+	reads := int64(1)
+	small := q.KeysOnly() || len(q.Project()) > 0
+	if offset, ok := q.Offset(); ok && !small {
+		reads += int64(offset)
+	}
+	err := r.ds.Run(q, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		if !small {
+			reads++
+		}
+		return cb(k, pm, gc)
+	})
+	r.c.Run.addReads(reads)
+	return r.c.Run.up(err)
 }
 
 func (r *dsCounter) Count(q *ds.FinalizedQuery) (int64, error) {
+	defer r.c.Count.since(r.ic, clock.Now(r.ic))
 	count, err := r.ds.Count(q)
+	r.c.Count.addReads(1)
 	return count, r.c.Count.up(err)
 }
 
 func (r *dsCounter) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	defer r.c.RunInTransaction.since(r.ic, clock.Now(r.ic))
 	return r.c.RunInTransaction.up(r.ds.RunInTransaction(f, opts))
 }
 
 func (r *dsCounter) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	defer r.c.DeleteMulti.since(r.ic, clock.Now(r.ic))
 	return r.c.DeleteMulti.up(r.ds.DeleteMulti(keys, cb))
 }
 
 func (r *dsCounter) GetMulti(keys []*ds.Key, meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
-	return r.c.GetMulti.up(r.ds.GetMulti(keys, meta, cb))
+	defer r.c.GetMulti.since(r.ic, clock.Now(r.ic))
+	err := r.ds.GetMulti(keys, meta, cb)
+	r.c.GetMulti.addReads(int64(len(keys)))
+	return r.c.GetMulti.up(err)
 }
 
 func (r *dsCounter) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	defer r.c.PutMulti.since(r.ic, clock.Now(r.ic))
 	return r.c.PutMulti.up(r.ds.PutMulti(keys, vals, cb))
 }
 
@@ -70,9 +96,19 @@ func (r *dsCounter) Testable() ds.Testable {
 }
 
 // FilterRDS installs a counter datastore filter in the context.
+//
+// Besides calls, it counts the entity reads that production bills for: one per
+// key for GetMulti, and one per query plus one per entity returned by Run.
+// Entities skipped by a query's offset are billed as reads too, so they're
+// counted (assuming the offset was filled, since the skipped entities are never
+// seen). Keys-only and projection queries, and Count, are billed as small
+// operations after their first read, which aren't counted.
 func FilterRDS(c context.Context) (context.Context, *DSCounter) {
 	state := &DSCounter{}
 	return ds.AddRawFilters(c, func(ic context.Context, ds ds.RawInterface) ds.RawInterface {
-		return &dsCounter{state, ds}
+		if filter.IsDisabled(ic, filter.Count) {
+			return ds
+		}
+		return &dsCounter{state, ds, ic}
 	}), state
 }
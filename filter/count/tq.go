@@ -5,8 +5,10 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	tq "github.com/tetrafolium/gae/service/taskqueue"
 )
 
@@ -22,23 +24,28 @@ type tqCounter struct {
 	c *TQCounter
 
 	tq tq.RawInterface
+	ic context.Context
 }
 
 var _ tq.RawInterface = (*tqCounter)(nil)
 
 func (t *tqCounter) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	defer t.c.AddMulti.since(t.ic, clock.Now(t.ic))
 	return t.c.AddMulti.up(t.tq.AddMulti(tasks, queueName, cb))
 }
 
 func (t *tqCounter) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
+	defer t.c.DeleteMulti.since(t.ic, clock.Now(t.ic))
 	return t.c.DeleteMulti.up(t.tq.DeleteMulti(tasks, queueName, cb))
 }
 
 func (t *tqCounter) Purge(queueName string) error {
+	defer t.c.Purge.since(t.ic, clock.Now(t.ic))
 	return t.c.Purge.up(t.tq.Purge(queueName))
 }
 
 func (t *tqCounter) Stats(queueNames []string, cb tq.RawStatsCB) error {
+	defer t.c.Stats.since(t.ic, clock.Now(t.ic))
 	return t.c.Stats.up(t.tq.Stats(queueNames, cb))
 }
 
@@ -50,6 +57,9 @@ func (t *tqCounter) Testable() tq.Testable {
 func FilterTQ(c context.Context) (context.Context, *TQCounter) {
 	state := &TQCounter{}
 	return tq.AddRawFilters(c, func(ic context.Context, tq tq.RawInterface) tq.RawInterface {
-		return &tqCounter{state, tq}
+		if filter.IsDisabled(ic, filter.Count) {
+			return tq
+		}
+		return &tqCounter{state, tq, ic}
 	}), state
 }
@@ -5,6 +5,8 @@
 package count
 
 import (
+	"github.com/luci/luci-go/common/clock"
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/user"
 	"golang.org/x/net/context"
 )
@@ -23,42 +25,50 @@ type UserCounter struct {
 type userCounter struct {
 	c *UserCounter
 
-	u user.Interface
+	u  user.Interface
+	ic context.Context
 }
 
 var _ user.Interface = (*userCounter)(nil)
 
 func (u *userCounter) Current() *user.User {
+	defer u.c.Current.since(u.ic, clock.Now(u.ic))
 	u.c.Current.up()
 	return u.u.Current()
 }
 
 func (u *userCounter) CurrentOAuth(scopes ...string) (*user.User, error) {
+	defer u.c.CurrentOAuth.since(u.ic, clock.Now(u.ic))
 	ret, err := u.u.CurrentOAuth(scopes...)
 	return ret, u.c.CurrentOAuth.up(err)
 }
 
 func (u *userCounter) IsAdmin() bool {
+	defer u.c.IsAdmin.since(u.ic, clock.Now(u.ic))
 	u.c.IsAdmin.up()
 	return u.u.IsAdmin()
 }
 
 func (u *userCounter) LoginURL(dest string) (string, error) {
+	defer u.c.LoginURL.since(u.ic, clock.Now(u.ic))
 	ret, err := u.u.LoginURL(dest)
 	return ret, u.c.LoginURL.up(err)
 }
 
 func (u *userCounter) LoginURLFederated(dest, identity string) (string, error) {
+	defer u.c.LoginURLFederated.since(u.ic, clock.Now(u.ic))
 	ret, err := u.u.LoginURLFederated(dest, identity)
 	return ret, u.c.LoginURLFederated.up(err)
 }
 
 func (u *userCounter) LogoutURL(dest string) (string, error) {
+	defer u.c.LogoutURL.since(u.ic, clock.Now(u.ic))
 	ret, err := u.u.LogoutURL(dest)
 	return ret, u.c.LogoutURL.up(err)
 }
 
 func (u *userCounter) OAuthConsumerKey() (string, error) {
+	defer u.c.OAuthConsumerKey.since(u.ic, clock.Now(u.ic))
 	ret, err := u.u.OAuthConsumerKey()
 	return ret, u.c.OAuthConsumerKey.up(err)
 }
@@ -71,6 +81,9 @@ func (u *userCounter) Testable() user.Testable {
 func FilterUser(c context.Context) (context.Context, *UserCounter) {
 	state := &UserCounter{}
 	return user.AddFilters(c, func(ic context.Context, u user.Interface) user.Interface {
-		return &userCounter{state, u}
+		if filter.IsDisabled(ic, filter.Count) {
+			return u
+		}
+		return &userCounter{state, u, ic}
 	}), state
 }
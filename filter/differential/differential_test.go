@@ -0,0 +1,318 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package differential
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/tetrafolium/gae/filter/dscache"
+	"github.com/tetrafolium/gae/filter/txnBuf"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+const aid = "dev~app"
+
+var (
+	root = ds.MakeKey(aid, "", "Parent", 1)
+
+	// groupKeys are the keys in root's entity group. They're the only keys
+	// which transactions touch.
+	groupKeys = []*ds.Key{
+		root,
+		ds.MakeKey(aid, "", "Parent", 1, "Foo", 1),
+		ds.MakeKey(aid, "", "Parent", 1, "Foo", 2),
+		ds.MakeKey(aid, "", "Parent", 1, "Foo", 2, "Foo", 1),
+	}
+
+	allKeys = append([]*ds.Key{
+		ds.MakeKey(aid, "", "Foo", 1),
+		ds.MakeKey(aid, "", "Foo", 2),
+		ds.MakeKey(aid, "", "Foo", "three"),
+	}, groupKeys...)
+
+	// queries are the queries which ops run. The first numAncestorQueries of
+	// them have an ancestor, and so may also be run in transactions.
+	queries = []*ds.Query{
+		ds.NewQuery("Foo").Ancestor(root),
+		ds.NewQuery("Foo").Ancestor(root).KeysOnly(true),
+		ds.NewQuery("Foo").Ancestor(root).Order("Value"),
+		ds.NewQuery("Foo").Ancestor(root).Order("-Value").Limit(2),
+		ds.NewQuery("Foo").Ancestor(root).Eq("Value", 1),
+		ds.NewQuery("Foo"),
+		ds.NewQuery("Foo").Order("-Value").Offset(1),
+		ds.NewQuery("Foo").Eq("Value", 2).KeysOnly(true),
+		ds.NewQuery("Parent"),
+	}
+	numAncestorQueries = 5
+
+	// indexes are the composite indexes which queries need in transactions,
+	// where the memory datastore doesn't AutoIndex.
+	indexes = []*ds.IndexDefinition{
+		{Kind: "Foo", Ancestor: true, SortBy: []ds.IndexColumn{{Property: "Value"}}},
+		{Kind: "Foo", Ancestor: true, SortBy: []ds.IndexColumn{{Property: "Value", Descending: true}}},
+	}
+
+	errAbort = errors.New("abort")
+)
+
+type opKind int
+
+const (
+	opPut opKind = iota
+	opGet
+	opDelete
+	opQuery
+	opTxn
+)
+
+// op is a single randomly generated datastore operation.
+type op struct {
+	kind opKind
+
+	ents  []ds.PropertyMap // for opPut
+	keys  []*ds.Key        // for opGet and opDelete
+	query *ds.Query        // for opQuery
+
+	// txn is the body of an opTxn, which rolls back if abort is true.
+	txn   []op
+	abort bool
+}
+
+// genOps generates n random ops. If inTxn is true, they're only ops which may
+// run in a transaction on root's entity group.
+func genOps(rng *rand.Rand, n int, inTxn bool) []op {
+	keys, numQueries := allKeys, len(queries)
+	if inTxn {
+		keys, numQueries = groupKeys, numAncestorQueries
+	}
+	pickKeys := func() []*ds.Key {
+		ret := []*ds.Key{}
+		for _, i := range rng.Perm(len(keys))[:1+rng.Intn(3)] {
+			ret = append(ret, keys[i])
+		}
+		return ret
+	}
+
+	ret := make([]op, n)
+	for i := range ret {
+		switch x := rng.Intn(10); {
+		case x < 3 || (x == 9 && inTxn):
+			o := op{kind: opPut}
+			for _, k := range pickKeys() {
+				pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(k)}}
+				for j := rng.Intn(3); j > 0; j-- {
+					pm["Value"] = append(pm["Value"], ds.MkProperty(rng.Int63n(4)))
+				}
+				if rng.Intn(2) == 0 {
+					pm["Data"] = []ds.Property{ds.MkPropertyNI([]byte{byte(rng.Intn(256))})}
+				}
+				o.ents = append(o.ents, pm)
+			}
+			ret[i] = o
+		case x < 5:
+			ret[i] = op{kind: opGet, keys: pickKeys()}
+		case x < 6:
+			ret[i] = op{kind: opDelete, keys: pickKeys()}
+		case x < 9:
+			ret[i] = op{kind: opQuery, query: queries[rng.Intn(numQueries)]}
+		default:
+			ret[i] = op{
+				kind:  opTxn,
+				txn:   genOps(rng, 1+rng.Intn(5), true),
+				abort: rng.Intn(4) == 0,
+			}
+		}
+	}
+	return ret
+}
+
+// undoEntry is the state of an entity before a write in a transaction which
+// runs outside of the datastore's transactions. ent is nil if the entity
+// didn't exist.
+type undoEntry struct {
+	key *ds.Key
+	ent ds.PropertyMap
+}
+
+// runner runs ops against a datastore, and records what they return.
+type runner struct {
+	d ds.Interface
+
+	// txnReadsWrites runs transaction bodies outside of a datastore
+	// transaction, undoing their writes if they abort. This models transactions
+	// which observe their own writes, like txnBuf's.
+	txnReadsWrites bool
+	undo           []undoEntry
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (r *runner) runAll(ops []op) []interface{} {
+	ret := make([]interface{}, len(ops))
+	for i, o := range ops {
+		ret[i] = r.run(r.d, o)
+	}
+	return ret
+}
+
+// saveForUndo records the current state of keys, if the runner is in
+// a transaction which runs outside of the datastore's transactions.
+func (r *runner) saveForUndo(keys []*ds.Key) {
+	if r.undo == nil {
+		return
+	}
+	for _, k := range keys {
+		pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(k)}}
+		if err := r.d.Get(&pm); err != nil {
+			pm = nil
+		}
+		r.undo = append(r.undo, undoEntry{k, pm})
+	}
+}
+
+func (r *runner) run(d ds.Interface, o op) interface{} {
+	switch o.kind {
+	case opPut:
+		keys := make([]*ds.Key, len(o.ents))
+		ents := make([]ds.PropertyMap, len(o.ents))
+		for i, pm := range o.ents {
+			keys[i] = pm["$key"][0].Value().(*ds.Key)
+			ents[i] = make(ds.PropertyMap, len(pm))
+			for k, v := range pm {
+				ents[i][k] = v
+			}
+		}
+		r.saveForUndo(keys)
+		return errString(d.PutMulti(ents))
+
+	case opGet:
+		pms := make([]ds.PropertyMap, len(o.keys))
+		for i, k := range o.keys {
+			pms[i] = ds.PropertyMap{"$key": {ds.MkPropertyNI(k)}}
+		}
+		err := d.GetMulti(pms)
+		if err == nil {
+			return pms
+		}
+		me, ok := err.(errors.MultiError)
+		if !ok {
+			return err.Error()
+		}
+		ret := make([]interface{}, len(pms))
+		for i, pm := range pms {
+			ret[i] = pm
+			if me[i] != nil {
+				ret[i] = me[i].Error()
+			}
+		}
+		return ret
+
+	case opDelete:
+		r.saveForUndo(o.keys)
+		return errString(d.DeleteMulti(o.keys))
+
+	case opQuery:
+		got := []ds.PropertyMap{}
+		err := d.GetAll(o.query, &got)
+		return []interface{}{got, errString(err)}
+
+	case opTxn:
+		results := []interface{}{}
+		body := func(d ds.Interface) error {
+			results = results[:0]
+			for _, o := range o.txn {
+				results = append(results, r.run(d, o))
+			}
+			if o.abort {
+				return errAbort
+			}
+			return nil
+		}
+
+		err := error(nil)
+		if r.txnReadsWrites {
+			r.undo = []undoEntry{}
+			if err = body(d); err != nil {
+				for i := len(r.undo) - 1; i >= 0; i-- {
+					u := r.undo[i]
+					if u.ent == nil {
+						So(d.Delete(u.key), ShouldBeNil)
+					} else {
+						So(d.Put(u.ent), ShouldBeNil)
+					}
+				}
+			}
+			r.undo = nil
+		} else {
+			err = d.RunInTransaction(func(c context.Context) error {
+				return body(ds.Get(c))
+			}, nil)
+		}
+		return []interface{}{results, errString(err)}
+	}
+	panic("unknown op")
+}
+
+func newDatastore(filter func(context.Context) context.Context) ds.Interface {
+	c := memory.Use(context.Background())
+	t := ds.Get(c).Testable()
+	t.Consistent(true)
+	t.AutoIndex(true)
+	t.AddIndexes(indexes...)
+	if filter != nil {
+		c = filter(c)
+	}
+	return ds.Get(c)
+}
+
+func TestFilters(t *testing.T) {
+	t.Parallel()
+
+	filters := []struct {
+		name   string
+		filter func(context.Context) context.Context
+
+		// txnReadsWrites is true if the filter's transactions observe their own
+		// writes.
+		txnReadsWrites bool
+	}{
+		{"dscache", func(c context.Context) context.Context { return dscache.FilterRDS(c, nil) }, false},
+		{"txnBuf", txnBuf.FilterRDS, true},
+	}
+
+	// step identifies an op in the failure message, if a test fails.
+	type step struct {
+		Seed   int64
+		Op     int
+		Result interface{}
+	}
+
+	Convey("Random operations return the same results with and without filters", t, func() {
+		for _, f := range filters {
+			f := f
+			Convey(f.name, func() {
+				for seed := int64(0); seed < 30; seed++ {
+					ops := genOps(rand.New(rand.NewSource(seed)), 50, false)
+
+					expect := (&runner{d: newDatastore(nil), txnReadsWrites: f.txnReadsWrites}).runAll(ops)
+					got := (&runner{d: newDatastore(f.filter)}).runAll(ops)
+					for i := range ops {
+						So(step{seed, i, got[i]}, ShouldResemble, step{seed, i, expect[i]})
+					}
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,11 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package differential contains randomized tests for the datastore filters.
+//
+// The tests generate random sequences of puts, gets, deletes, queries and
+// transactions, and run them against the in-memory datastore both with and
+// without a filter (e.g. dscache or txnBuf) installed. Any difference in what
+// the two datastores return is a bug in the filter.
+package differential
@@ -0,0 +1,127 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package filter contains the service filters (in its subpackages), Disable,
+// which allows them to be bypassed for particular requests, and Handler, the
+// type of the handlers which their middlewares wrap.
+//
+// Disable is meant for incident response: if a filter misbehaves, the code
+// which builds a request's context can consult some runtime configuration
+// (e.g. a datastore entity, or a header on requests from administrators) and
+// disable the filter for just the affected traffic, without a redeploy:
+//
+//   if cfg.BypassDSCache(r) {
+//     c = filter.Disable(c, filter.DSCache)
+//   }
+//
+// Filters consult IsDisabled with the context that their service is retrieved
+// from, so Disable affects everything which uses the returned context (and
+// contexts derived from it).
+package filter
+
+import (
+	"golang.org/x/net/context"
+)
+
+// Names of the filters which can be disabled.
+const (
+	// BlobOverflow is the blobOverflow filter. Disabling it stops properties
+	// from being moved to the Store, but entities which already have moved
+	// properties still get them back when they're read, since they'd be
+	// incomplete otherwise.
+	BlobOverflow = "blobOverflow"
+
+	// CallLog is the callLog filter. Disabling it stops calls from being
+	// logged.
+	CallLog = "callLog"
+
+	// Compress is the compress filter. Disabling it stops properties from being
+	// compressed, but entities which already have compressed properties are
+	// still decompressed when they're read, since they'd be unreadable
+	// otherwise.
+	Compress = "compress"
+
+	// Count is the count filter. Disabling it stops calls from being counted,
+	// including the ones summarized by callStats, which is built on it.
+	Count = "count"
+
+	// DSCache is the dscache filter. Disabling it makes reads go directly to
+	// the datastore. Writes still invalidate the cache, so it stays coherent
+	// for the requests which use it.
+	DSCache = "dscache"
+
+	// FeatureBreaker is the featureBreaker filter. Disabling it makes every
+	// feature work, whether or not it's broken.
+	FeatureBreaker = "featureBreaker"
+
+	// PutBatch is the putBatch filter. Disabling it makes puts go directly to
+	// the datastore. Entities which were already deferred are still saved by
+	// the next flush.
+	PutBatch = "putBatch"
+
+	// QueryLint is the queryLint filter. Disabling it stops queries from being
+	// recorded.
+	QueryLint = "queryLint"
+
+	// RequestTrace is the requestTrace filter. Disabling it stops the trace
+	// headers from being added to tasks and urlfetch requests.
+	RequestTrace = "requestTrace"
+
+	// SchemaDrift is the schemaDrift filter. Disabling it stops entities from
+	// being checked.
+	SchemaDrift = "schemaDrift"
+
+	// TQBudget is the tqBudget filter. Disabling it lets tasks be added without
+	// taking from (or being limited by) the budget.
+	TQBudget = "tqBudget"
+
+	// TQThrottle is the tqThrottle filter. Disabling it stops tasks from being
+	// deferred.
+	TQThrottle = "tqThrottle"
+
+	// TxnBuf is the txnBuf filter. Disabling it makes new transactions run
+	// directly in the datastore, so they can't be nested. Transactions which
+	// are already buffered stay buffered until they finish.
+	TxnBuf = "txnBuf"
+)
+
+type key int
+
+var disabledKey key
+
+// Disable returns a context in which the named filters are disabled.
+func Disable(c context.Context, names ...string) context.Context {
+	return setDisabled(c, names, true)
+}
+
+// Enable returns a context in which the named filters are no longer disabled
+// (e.g. for a particular operation of a request which disabled them).
+func Enable(c context.Context, names ...string) context.Context {
+	return setDisabled(c, names, false)
+}
+
+func setDisabled(c context.Context, names []string, disabled bool) context.Context {
+	if len(names) == 0 {
+		return c
+	}
+	cur, _ := c.Value(disabledKey).(map[string]bool)
+	set := make(map[string]bool, len(cur)+len(names))
+	for name := range cur {
+		set[name] = true
+	}
+	for _, name := range names {
+		if disabled {
+			set[name] = true
+		} else {
+			delete(set, name)
+		}
+	}
+	return context.WithValue(c, disabledKey, set)
+}
+
+// IsDisabled returns true if the named filter is disabled in c.
+func IsDisabled(c context.Context, name string) bool {
+	disabled, _ := c.Value(disabledKey).(map[string]bool)
+	return disabled[name]
+}
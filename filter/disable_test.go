@@ -0,0 +1,34 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestDisable(t *testing.T) {
+	t.Parallel()
+
+	Convey("Disable", t, func() {
+		c := context.Background()
+		So(IsDisabled(c, DSCache), ShouldBeFalse)
+
+		dc := Disable(c, DSCache, TQThrottle)
+		So(IsDisabled(dc, DSCache), ShouldBeTrue)
+		So(IsDisabled(dc, TQThrottle), ShouldBeTrue)
+		So(IsDisabled(dc, TQBudget), ShouldBeFalse)
+		So(IsDisabled(c, DSCache), ShouldBeFalse)
+
+		Convey("can be undone by Enable", func() {
+			ec := Enable(dc, DSCache)
+			So(IsDisabled(ec, DSCache), ShouldBeFalse)
+			So(IsDisabled(ec, TQThrottle), ShouldBeTrue)
+			So(IsDisabled(dc, DSCache), ShouldBeTrue)
+		})
+	})
+}
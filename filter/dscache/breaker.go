@@ -0,0 +1,62 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package dscache
+
+import (
+	"sync"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+)
+
+// memcacheBreaker detects sustained memcache failure. It's shared by all
+// requests on this instance, since a memcache outage affects all of them.
+type memcacheBreaker struct {
+	sync.Mutex
+
+	// failures is the number of consecutive failed memcache calls.
+	failures int
+
+	// openUntil is the time until which reads bypass memcache.
+	openUntil time.Time
+}
+
+var breaker = memcacheBreaker{}
+
+// open returns true if reads should bypass memcache.
+func (b *memcacheBreaker) open(c context.Context) bool {
+	b.Lock()
+	defer b.Unlock()
+	return clock.Now(c).Before(b.openUntil)
+}
+
+// record records the outcome of a memcache call. err must not contain any
+// expected errors (like memcache.ErrCacheMiss), since they don't indicate that
+// memcache is failing.
+func (b *memcacheBreaker) record(c context.Context, err error) {
+	b.Lock()
+	defer b.Unlock()
+
+	if err == nil {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if BreakerThreshold <= 0 || b.failures < BreakerThreshold {
+		return
+	}
+
+	now := clock.Now(c)
+	if now.Before(b.openUntil) {
+		return
+	}
+	b.openUntil = now.Add(BreakerCoolDown)
+	// After the cool-down, a single failure opens the breaker again.
+	b.failures = BreakerThreshold - 1
+	(log.Fields{log.ErrorKey: err}).Errorf(
+		c, "dscache: memcache is failing; bypassing it for reads for %s", BreakerCoolDown)
+}
@@ -5,6 +5,7 @@
 package dscache
 
 import (
+	"github.com/tetrafolium/gae/filter"
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/info"
 	mc "github.com/tetrafolium/gae/service/memcache"
@@ -42,14 +43,19 @@ func FilterRDS(c context.Context, shardsForKey func(*ds.Key) int) context.Contex
 // AlwaysFilterRDS installs a caching RawDatastore filter in the context.
 //
 // Unlike FilterRDS it doesn't check GlobalConfig via IsGloballyEnabled call,
-// assuming caller already knows whether filter should be applied or not.
+// assuming caller already knows whether filter should be applied or not. It
+// uses the epoch which IsGloballyEnabled last saw (see BumpEpoch).
 func AlwaysFilterRDS(c context.Context, shardsForKey func(*ds.Key) int) context.Context {
+	epoch, bumped := lastEpoch()
+	settling := epochSettling(c, bumped)
 	return ds.AddRawFilters(c, func(c context.Context, ds ds.RawInterface) ds.RawInterface {
 		i := info.Get(c)
 
 		sc := &supportContext{
 			i.AppID(),
 			i.GetNamespace(),
+			epoch,
+			settling,
 			c,
 			mc.Get(c),
 			mathrand.Get(c),
@@ -58,6 +64,9 @@ func AlwaysFilterRDS(c context.Context, shardsForKey func(*ds.Key) int) context.
 
 		v := c.Value(dsTxnCacheKey)
 		if v == nil {
+			if filter.IsDisabled(c, filter.DSCache) {
+				return &dsCacheBypass{dsCache{ds, sc}}
+			}
 			return &dsCache{ds, sc}
 		}
 		return &dsTxnCache{ds, v.(*dsTxnState), sc}
@@ -20,8 +20,14 @@
 //
 //   "gae:" | vers | ":" | shard | ":" | Base64_std_nopad(SHA1(datastore.Key))
 //
+// or, once the epoch has been bumped (see BumpEpoch), like
+//
+//   "gae:" | vers | ":e" | epoch | ":" | shard | ":" | Base64_std_nopad(SHA1(datastore.Key))
+//
 // Where:
 //   - vers is an ascii-hex-encoded number (currently 1).
+//   - epoch is the ascii-hex-encoded GlobalConfig Epoch. Bumping it abandons
+//     every entry cached so far, without flushing all of memcache.
 //   - shard is a zero-based ascii-hex-encoded number (depends on shardsForKey).
 //   - SHA1 has been chosen as unlikely (p == 1e-18) to collide, given dedicated
 //     memcache sizes of up to 170 Exabytes (assuming an average entry size of
@@ -31,8 +37,12 @@
 //
 // The memcache value is a compression byte, indicating the scheme (See
 // CompressionType), followed by the encoded (and possibly compressed) value.
-// Encoding is done with datastore.PropertyMap.Write(). The memcache value
-// may also be the empty byte sequence, indicating that this entity is deleted.
+// Encoding is done with serialize.WriteVersionedPropertyMap, and the
+// compression byte's high bit is set to show that the value starts with its
+// encoding version. Values without it were written by older binaries, and are
+// still read. Values written by newer binaries, in a version that this one
+// doesn't know, are treated as cache misses. The memcache value may also be
+// the empty byte sequence, indicating that this entity is deleted.
 //
 // The memcache entry may also have a 'flags' value set to one of the following:
 //   - 0 "entity" (cached value)
@@ -71,6 +81,10 @@
 // the object to memcache. The CAS will succeed if nothing else touched the
 // memcache in the meantime (like a Put, a memcache expiration/eviction, etc.).
 //
+// Keys-only Gets (e.g. from datastore.Interface.Exists) don't Add a lock. If
+// the flag is "entity", they only check whether the Value is empty, without
+// decoding it. Otherwise they go to the datastore without updating memcache.
+//
 // Algorithm - Transactions
 //
 // In a transaction, all Put memcache operations are held until the very end of
@@ -117,6 +131,9 @@
 // The purpose of sharding is to alleviate hot memcache keys, as recommended by
 // https://cloud.google.com/appengine/articles/best-practices-for-app-engine-memcache#distribute-load .
 //
+// Preload can be used to populate the cache ahead of traffic (e.g. from
+// a warmup handler, or a cron job after a memcache flush).
+//
 // Caveats
 //
 // A couple things to note that may differ from other appengine datastore
@@ -140,8 +157,11 @@
 //
 // So, if memcache is DOWN, you will effectively see tons of errors in the logs,
 // and all cached datastore access will be essentially degraded to a slow
-// read-only state. At this point, you have essentially 3 mitigration
-// strategies:
+// read-only state. dscache notices this: after BreakerThreshold consecutive
+// failed memcache calls (cache misses don't count), reads go straight to the
+// datastore for BreakerCoolDown, and a single error is logged. Writes still
+// try to set their locks, and fail if they can't. At this point, you have
+// essentially 3 mitigration strategies:
 //   - wait for memcache to come back up.
 //   - dynamically disable all memcache access by writing the datastore entry:
 //       /dscache,1 = {"Enable": false}
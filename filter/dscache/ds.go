@@ -35,17 +35,31 @@ func (d *dsCache) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMulti
 }
 
 func (d *dsCache) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	if breaker.open(d.c) {
+		return d.RawInterface.GetMulti(keys, metas, cb)
+	}
+
 	lockItems, nonce := d.mkRandLockItems(keys, metas)
 	if len(lockItems) == 0 {
 		return d.RawInterface.GetMulti(keys, metas, cb)
 	}
 
-	if err := d.mc.AddMulti(lockItems); err != nil {
-		// Ignore this error. Either we couldn't add them because they exist
-		// (so, not an issue), or because memcache is having sad times (in which
-		// case we'll see so in the GetMulti which immediately follows this).
+	// Either we couldn't add them because they exist (so, not an issue), or
+	// because memcache is having sad times (in which case we'll see so in the
+	// GetMulti which immediately follows this).
+	// Keys-only reads don't populate the cache, so they don't need locks.
+	toLock := make([]memcache.Item, 0, len(lockItems))
+	for i, itm := range lockItems {
+		if itm != nil && !isKeysOnly(metas, i) {
+			toLock = append(toLock, itm)
+		}
+	}
+	if len(toLock) > 0 {
+		breaker.record(d.c, errors.Filter(d.mc.AddMulti(toLock), memcache.ErrNotStored))
 	}
-	if err := errors.Filter(d.mc.GetMulti(lockItems), memcache.ErrCacheMiss); err != nil {
+	err := errors.Filter(d.mc.GetMulti(lockItems), memcache.ErrCacheMiss)
+	breaker.record(d.c, err)
+	if err != nil {
 		(log.Fields{log.ErrorKey: err}).Warningf(
 			d.c, "dscache: GetMulti: memcache.GetMulti")
 	}
@@ -109,7 +123,10 @@ func (d *dsCache) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMu
 		}
 		if len(toCas) > 0 {
 			// we have entries to save back to memcache.
-			if err := d.mc.CompareAndSwapMulti(toCas); err != nil {
+			err := errors.Filter(d.mc.CompareAndSwapMulti(toCas),
+				memcache.ErrCASConflict, memcache.ErrNotStored)
+			breaker.record(d.c, err)
+			if err != nil {
 				(log.Fields{log.ErrorKey: err}).Warningf(
 					d.c, "dscache: GetMulti: memcache.CompareAndSwapMulti")
 			}
@@ -125,6 +142,17 @@ func (d *dsCache) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMu
 	return nil
 }
 
+// dsCacheBypass is the filter used when dscache is disabled with
+// filter.Disable: it reads directly from the datastore, but (as dsCache) still
+// invalidates the cache on writes.
+type dsCacheBypass struct {
+	dsCache
+}
+
+func (d *dsCacheBypass) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	return d.RawInterface.GetMulti(keys, metas, cb)
+}
+
 func (d *dsCache) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
 	txnState := dsTxnState{}
 	err := d.RawInterface.RunInTransaction(func(ctx context.Context) error {
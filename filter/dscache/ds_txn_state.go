@@ -42,6 +42,7 @@ func (s *dsTxnState) apply(sc *supportContext) error {
 	// this is a hard failure. No mutation can occur if we're unable to set
 	// locks out. See "DANGER ZONE" in the docs.
 	err := sc.mc.SetMulti(s.toLock)
+	breaker.record(sc.c, err)
 	if err != nil {
 		(log.Fields{log.ErrorKey: err}).Errorf(
 			sc.c, "dscache: HARD FAILURE: dsTxnState.apply(): mc.SetMulti")
@@ -61,7 +62,9 @@ func (s *dsTxnState) release(sc *supportContext) {
 		delKeys = append(delKeys, k)
 	}
 
-	if err := errors.Filter(sc.mc.DeleteMulti(delKeys), memcache.ErrCacheMiss); err != nil {
+	err := errors.Filter(sc.mc.DeleteMulti(delKeys), memcache.ErrCacheMiss)
+	breaker.record(sc.c, err)
+	if err != nil {
 		(log.Fields{log.ErrorKey: err}).Warningf(
 			sc.c, "dscache: txn.release: memcache.DeleteMulti")
 	}
@@ -43,6 +43,15 @@ var (
 	// DefaultEnabled indicates whether or not caching is globally enabled or
 	// disabled by default. Can still be overridden by CacheEnableMeta.
 	DefaultEnabled = true
+
+	// BreakerThreshold is the number of consecutive failed memcache calls after
+	// which reads bypass memcache for BreakerCoolDown. Cache misses don't count
+	// as failures. A value of 0 disables this.
+	BreakerThreshold = 10
+
+	// BreakerCoolDown is how long reads bypass memcache once BreakerThreshold
+	// is reached.
+	BreakerCoolDown = time.Minute
 )
 
 const (
@@ -54,6 +63,11 @@ const (
 	//   gae:<version>:<shard#>:<base64_std_nopad(sha1(datastore.Key))>
 	KeyFormat = "gae:" + MemcacheVersion + ":%x:%s"
 
+	// EpochKeyFormat is the format string used to generate memcache keys in
+	// epochs other than 0 (see BumpEpoch). It's
+	//   gae:<version>:e<epoch>:<shard#>:<base64_std_nopad(sha1(datastore.Key))>
+	EpochKeyFormat = "gae:" + MemcacheVersion + ":e%x:%x:%s"
+
 	// Sha1B64Padding is the number of padding characters a base64 encoding of
 	// a sha1 has.
 	Sha1B64Padding = 1
@@ -126,10 +140,23 @@ const (
 	ItemHasLock
 )
 
-// MakeMemcacheKey generates a memcache key for the given datastore Key. This
-// is useful for debugging.
+// MakeMemcacheKey generates a memcache key for the given datastore Key in
+// epoch 0. This is useful for debugging.
 func MakeMemcacheKey(shard int, k *datastore.Key) string {
-	return fmt.Sprintf(KeyFormat, shard, HashKey(k))
+	return MakeEpochMemcacheKey(0, shard, k)
+}
+
+// MakeEpochMemcacheKey generates a memcache key for the given datastore Key in
+// the given epoch (see BumpEpoch).
+func MakeEpochMemcacheKey(epoch int64, shard int, k *datastore.Key) string {
+	return memcacheKey(epoch, shard, HashKey(k))
+}
+
+func memcacheKey(epoch int64, shard int, hash string) string {
+	if epoch == 0 {
+		return fmt.Sprintf(KeyFormat, shard, hash)
+	}
+	return fmt.Sprintf(EpochKeyFormat, epoch, shard, hash)
 }
 
 // HashKey generates just the hashed portion of the MemcacheKey.
@@ -7,11 +7,12 @@ package dscache
 import (
 	"bytes"
 	"encoding/binary"
-	"errors"
 	"math/rand"
 	"testing"
 	"time"
 
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/filter/count"
 	"github.com/tetrafolium/gae/filter/featureBreaker"
 	"github.com/tetrafolium/gae/impl/memory"
 	"github.com/tetrafolium/gae/service/datastore"
@@ -19,6 +20,7 @@ import (
 	"github.com/tetrafolium/gae/service/memcache"
 	"github.com/luci/luci-go/common/clock"
 	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
 	"github.com/luci/luci-go/common/mathrand"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
@@ -28,7 +30,7 @@ type object struct {
 	ID int64 `gae:"$id"`
 
 	Value   string
-	BigData []byte
+	BigData []byte `gae:",noindex"`
 }
 
 type shardObj struct { // see shardsForKey() at top
@@ -92,10 +94,10 @@ func TestDSCache(t *testing.T) {
 
 			Convey("basically works", func() {
 				pm := datastore.PropertyMap{
-					"BigData": {datastore.MkProperty([]byte(""))},
+					"BigData": {datastore.MkPropertyNI([]byte(""))},
 					"Value":   {datastore.MkProperty("hi")},
 				}
-				encoded := append([]byte{0}, serialize.ToBytes(pm)...)
+				encoded := append([]byte{byte(NoCompression) | versionedValue, serialize.Version}, serialize.ToBytes(pm)...)
 
 				o := object{ID: 1, Value: "hi"}
 				So(ds.Put(&o), ShouldBeNil)
@@ -144,7 +146,37 @@ func TestDSCache(t *testing.T) {
 
 					// this one hits memcache
 					So(ds.Get(&o), ShouldEqual, datastore.ErrNoSuchEntity)
+					ex, err := ds.Exists(ds.KeyForObj(&o))
+					So(err, ShouldBeNil)
+					So(ex, ShouldBeFalse)
 				})
+
+				Convey("Exists doesn't decode cached entities", func() {
+					k := ds.KeyForObj(&object{ID: 1})
+					So(dsUnder.Delete(k), ShouldBeNil)
+
+					// a Get would have to go to the datastore for this.
+					itm := mc.NewItem(MakeMemcacheKey(0, k)).SetValue([]byte("bogus")).SetFlags(uint32(ItemHasData))
+					So(mc.Set(itm), ShouldBeNil)
+
+					ex, err := ds.Exists(k)
+					So(err, ShouldBeNil)
+					So(ex, ShouldBeTrue)
+				})
+			})
+
+			Convey("Exists doesn't populate the cache", func() {
+				o := object{ID: 3, Value: "hi"}
+				So(dsUnder.Put(&o), ShouldBeNil)
+				k := ds.KeyForObj(&o)
+
+				ex, err := ds.ExistsMulti([]*datastore.Key{k, ds.MakeKey("object", 4)})
+				So(err, ShouldBeNil)
+				So(ex, ShouldResemble, datastore.BoolList{true, false})
+
+				_, err = mc.Get(MakeMemcacheKey(0, k))
+				So(err, ShouldEqual, memcache.ErrCacheMiss)
+				So(numMemcacheItems(), ShouldEqual, 0)
 			})
 
 			Convey("compression works", func() {
@@ -162,7 +194,7 @@ func TestDSCache(t *testing.T) {
 				itm, err := mc.Get(MakeMemcacheKey(0, ds.KeyForObj(&o)))
 				So(err, ShouldBeNil)
 
-				So(itm.Value()[0], ShouldEqual, ZlibCompression)
+				So(itm.Value()[0], ShouldEqual, byte(ZlibCompression)|versionedValue)
 				So(len(itm.Value()), ShouldEqual, 653) // a bit smaller than 4k
 
 				// ensure the next Get comes from the cache
@@ -263,6 +295,27 @@ func TestDSCache(t *testing.T) {
 					So(numMemcacheItems(), ShouldEqual, 4)
 				})
 
+				Convey("per-request bypass", func() {
+					bypass := datastore.Get(filter.Disable(c, filter.DSCache))
+
+					o := object{ID: 1, Value: "hi"}
+					So(ds.Put(&o), ShouldBeNil)
+					So(bypass.Get(&object{ID: 1}), ShouldBeNil)
+					So(numMemcacheItems(), ShouldEqual, 0)
+
+					So(ds.Get(&object{ID: 1}), ShouldBeNil)
+					So(numMemcacheItems(), ShouldEqual, 1)
+
+					Convey("still invalidates the cache on writes", func() {
+						o.Value = "there"
+						So(bypass.Put(&o), ShouldBeNil)
+
+						o = object{ID: 1}
+						So(ds.Get(&o), ShouldBeNil)
+						So(o.Value, ShouldEqual, "there")
+					})
+				})
+
 				Convey("per-key cache disablement", func() {
 					n := &noCacheObj{ID: "nurbs", Value: true}
 					So(ds.Put(n), ShouldBeNil)
@@ -411,12 +464,38 @@ func TestDSCache(t *testing.T) {
 					So(ZlibCompression.String(), ShouldEqual, "ZlibCompression")
 					So(CompressionType(100).String(), ShouldEqual, "UNKNOWN_CompressionType(100)")
 				})
+
+				Convey("item values", func() {
+					pm := datastore.PropertyMap{"Value": {datastore.MkProperty("hi")}}
+
+					Convey("round trip", func() {
+						dec, err := decodeItemValue(encodeItemValue(pm), "ns", "aid")
+						So(err, ShouldBeNil)
+						So(dec, ShouldResemble, pm)
+					})
+
+					Convey("written before versioning are read", func() {
+						val := append([]byte{byte(NoCompression)}, serialize.ToBytes(pm)...)
+						dec, err := decodeItemValue(val, "ns", "aid")
+						So(err, ShouldBeNil)
+						So(dec, ShouldResemble, pm)
+					})
+
+					Convey("written by a newer version are rejected", func() {
+						val := encodeItemValue(pm)
+						val[1] = serialize.Version + 1
+						_, err := decodeItemValue(val, "ns", "aid")
+						So(err, ShouldHaveSameTypeAs, &serialize.ErrUnknownVersion{})
+					})
+				})
 			})
 		})
 
 		Convey("disabled cases", func() {
 			defer func() {
 				globalEnabled = true
+				globalEpoch = 0
+				globalEpochBumped = time.Time{}
 			}()
 
 			So(IsGloballyEnabled(c), ShouldBeTrue)
@@ -433,13 +512,210 @@ func TestDSCache(t *testing.T) {
 			So(mc.Set(mc.NewItem("test").SetValue([]byte("hi"))), ShouldBeNil)
 			So(numMemcacheItems(), ShouldEqual, 1)
 			So(SetGlobalEnable(c, true), ShouldBeNil)
-			// memcache gets flushed as a side effect
-			So(numMemcacheItems(), ShouldEqual, 0)
+			// the epoch is bumped instead of flushing memcache
+			So(numMemcacheItems(), ShouldEqual, 1)
+			cfg := &GlobalConfig{}
+			So(dsUnder.Get(cfg), ShouldBeNil)
+			So(cfg.Epoch, ShouldEqual, 1)
 
 			// Still takes 5 minutes to kick in
 			So(IsGloballyEnabled(c), ShouldBeFalse)
+			epoch, _ := lastEpoch()
+			So(epoch, ShouldEqual, 0)
 			clk.Add(time.Minute*5 + time.Second)
 			So(IsGloballyEnabled(c), ShouldBeTrue)
+			epoch, bumped := lastEpoch()
+			So(epoch, ShouldEqual, 1)
+			So(bumped, ShouldResemble, cfg.EpochBumped)
+			So(epochSettling(c, bumped), ShouldBeFalse)
+		})
+	})
+}
+
+func TestPreload(t *testing.T) {
+	t.Parallel()
+
+	Convey("Preload", t, func() {
+		c := memory.Use(context.Background())
+		dsUnder := datastore.Get(c)
+		c, dsCount := count.FilterRDS(c)
+		c = AlwaysFilterRDS(c, nil)
+		ds := datastore.Get(c)
+		mc := memcache.Get(c)
+
+		keys := []*datastore.Key{}
+		for id := int64(1); id <= 4; id++ {
+			if id < 4 {
+				So(dsUnder.Put(&object{ID: id, Value: "hi"}), ShouldBeNil)
+			}
+			keys = append(keys, ds.MakeKey("object", id))
+		}
+
+		progress := [][]int{}
+		So(Preload(c, keys, &PreloadOptions{
+			BatchSize: 3,
+			Progress: func(done, total int) {
+				progress = append(progress, []int{done, total})
+			},
+		}), ShouldBeNil)
+		So(progress, ShouldResemble, [][]int{{3, 4}, {4, 4}})
+		So(dsCount.GetMulti.Successes(), ShouldEqual, 2)
+
+		Convey("caches the entities", func() {
+			So(dsUnder.DeleteMulti(keys[:3]), ShouldBeNil)
+
+			o := &object{ID: 1}
+			So(ds.Get(o), ShouldBeNil)
+			So(o.Value, ShouldEqual, "hi")
+			So(ds.Get(&object{ID: 4}), ShouldEqual, datastore.ErrNoSuchEntity)
+			So(dsCount.GetMulti.Successes(), ShouldEqual, 2)
+		})
+
+		Convey("doesn't reload cached entities", func() {
+			So(mc.Delete(MakeMemcacheKey(0, keys[1])), ShouldBeNil)
+			So(Preload(c, keys, nil), ShouldBeNil)
+			So(dsCount.GetMulti.Successes(), ShouldEqual, 3)
+		})
+
+		Convey("reports errors for some keys", func() {
+			err := Preload(c, []*datastore.Key{keys[0], ds.MakeKey("object", 0)}, nil)
+			So(err, ShouldResemble, errors.MultiError{nil, datastore.ErrInvalidKey})
+		})
+	})
+}
+
+func TestMemcacheBreaker(t *testing.T) {
+	// This isn't parallel, since the breaker is shared by the whole package.
+
+	Convey("Test memcache breaker", t, func() {
+		defer func() { breaker = memcacheBreaker{} }()
+
+		c, clk := testclock.UseTime(context.Background(), time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC))
+		c = memory.Use(c)
+		c, fb := featureBreaker.FilterMC(c, nil)
+		c, mcCount := count.FilterMC(c)
+		c = AlwaysFilterRDS(c, nil)
+		ds := datastore.Get(c)
+
+		So(ds.Put(&object{ID: 1, Value: "hi"}), ShouldBeNil)
+
+		Convey("ignores cache misses", func() {
+			for i := 0; i < BreakerThreshold; i++ {
+				So(ds.Get(&object{ID: 2}), ShouldEqual, datastore.ErrNoSuchEntity)
+			}
+			So(breaker.open(c), ShouldBeFalse)
+		})
+
+		Convey("bypasses memcache for reads when it keeps failing", func() {
+			fb.BreakFeatures(errors.New("memcache is down"), "AddMulti", "GetMulti", "CompareAndSwapMulti")
+			for i := 0; i < BreakerThreshold && !breaker.open(c); i++ {
+				So(ds.Get(&object{ID: 1}), ShouldBeNil)
+			}
+			So(breaker.open(c), ShouldBeTrue)
+
+			calls := mcCount.GetMulti.Total()
+			o := &object{ID: 1}
+			So(ds.Get(o), ShouldBeNil)
+			So(o.Value, ShouldEqual, "hi")
+			So(mcCount.GetMulti.Total(), ShouldEqual, calls)
+
+			Convey("writes still need memcache", func() {
+				fb.BreakFeatures(errors.New("memcache is down"), "SetMulti")
+				So(ds.Put(&object{ID: 1, Value: "bye"}), ShouldNotBeNil)
+			})
+
+			Convey("and uses it again after the cool-down", func() {
+				fb.UnbreakFeatures("AddMulti", "GetMulti", "CompareAndSwapMulti")
+				clk.Add(BreakerCoolDown)
+				So(breaker.open(c), ShouldBeFalse)
+
+				So(ds.Get(o), ShouldBeNil)
+				So(mcCount.GetMulti.Total(), ShouldEqual, calls+1)
+			})
+
+			Convey("but not if it fails again", func() {
+				clk.Add(BreakerCoolDown)
+				So(ds.Get(o), ShouldBeNil)
+				So(breaker.open(c), ShouldBeTrue)
+			})
+		})
+	})
+}
+
+func TestBumpEpoch(t *testing.T) {
+	// This isn't parallel, since the epoch is shared by the whole package.
+
+	Convey("Test BumpEpoch", t, func() {
+		defer func() { globalEpoch, globalEpochBumped = 0, time.Time{} }()
+
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, clk := testclock.UseTime(context.Background(), now)
+		c = memory.Use(c)
+		mc := memcache.Get(c)
+		ds := datastore.Get(AlwaysFilterRDS(c, nil))
+		So(ds.Put(&object{ID: 1, Value: "hi"}), ShouldBeNil)
+		So(ds.Get(&object{ID: 1}), ShouldBeNil)
+		key := ds.KeyForObj(&object{ID: 1})
+		_, err := mc.Get(MakeMemcacheKey(0, key))
+		So(err, ShouldBeNil)
+
+		epoch, err := BumpEpoch(c)
+		So(err, ShouldBeNil)
+		So(epoch, ShouldEqual, 1)
+		epoch, bumped := lastEpoch()
+		So(epoch, ShouldEqual, 1)
+		So(bumped, ShouldResemble, now)
+
+		Convey("abandons the cached entities", func() {
+			// Change the entity behind dscache's back.
+			So(datastore.Get(c).Put(&object{ID: 1, Value: "bye"}), ShouldBeNil)
+
+			o := &object{ID: 1}
+			So(datastore.Get(AlwaysFilterRDS(c, nil)).Get(o), ShouldBeNil)
+			So(o.Value, ShouldEqual, "bye")
+		})
+
+		Convey("doesn't cache anything until the other instances have seen it", func() {
+			ds := datastore.Get(AlwaysFilterRDS(c, nil))
+			So(ds.Get(&object{ID: 1}), ShouldBeNil)
+			_, err := mc.Get(MakeEpochMemcacheKey(1, 0, key))
+			So(err, ShouldEqual, memcache.ErrCacheMiss)
+
+			Convey("while invalidating the previous epoch's entries", func() {
+				So(ds.Put(&object{ID: 1, Value: "bye"}), ShouldBeNil)
+				_, err := mc.Get(MakeMemcacheKey(0, key))
+				So(err, ShouldEqual, memcache.ErrCacheMiss)
+			})
+
+			Convey("and then caches in the new epoch", func() {
+				clk.Add(GlobalEnabledCheckInterval)
+				So(datastore.Get(AlwaysFilterRDS(c, nil)).Get(&object{ID: 1}), ShouldBeNil)
+				_, err := mc.Get(MakeEpochMemcacheKey(1, 0, key))
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("leaves the rest of memcache alone", func() {
+			_, err := mc.Get(MakeMemcacheKey(0, key))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("increments the epoch each time", func() {
+			clk.Add(time.Minute)
+			epoch, err := BumpEpoch(c)
+			So(err, ShouldBeNil)
+			So(epoch, ShouldEqual, 2)
+
+			cfg := &GlobalConfig{}
+			So(datastore.Get(c).Get(cfg), ShouldBeNil)
+			So(cfg, ShouldResemble, &GlobalConfig{Enable: true, Epoch: 2, EpochBumped: now.Add(time.Minute)})
+
+			Convey("including when dscache is enabled again", func() {
+				So(SetGlobalEnable(c, false), ShouldBeNil)
+				So(SetGlobalEnable(c, true), ShouldBeNil)
+				So(datastore.Get(c).Get(cfg), ShouldBeNil)
+				So(cfg.Epoch, ShouldEqual, 3)
+			})
 		})
 	})
 }
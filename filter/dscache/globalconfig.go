@@ -10,7 +10,6 @@ import (
 
 	"github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/info"
-	"github.com/tetrafolium/gae/service/memcache"
 	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 )
@@ -20,13 +19,20 @@ import (
 // It's Enable field can be set to false to cause all dscache operations
 // (read and write) to cease in a given application.
 //
+// Its Epoch field is mixed into every memcache key which dscache uses, so
+// incrementing it (see BumpEpoch) invalidates every cached entity at once,
+// without flushing the rest of memcache. EpochBumped is when that was last
+// done.
+//
 // This should be manipulated in the GLOBAL (e.g. empty) namespace only. When
 // written there, it affects activity in all namespaces.
 type GlobalConfig struct {
 	_id   int64  `gae:"$id,1"`
 	_kind string `gae:"$kind,dscache"`
 
-	Enable bool
+	Enable      bool
+	Epoch       int64
+	EpochBumped time.Time `gae:",noindex"`
 }
 
 var (
@@ -37,6 +43,12 @@ var (
 	// true.
 	globalEnabled = true
 
+	// globalEpoch and globalEpochBumped are the GlobalConfig's Epoch and
+	// EpochBumped, as of IsGloballyEnabled's last successful check (or this
+	// instance's last BumpEpoch).
+	globalEpoch       = int64(0)
+	globalEpochBumped = time.Time{}
+
 	// globalEnabledNextCheck is IsGloballyEnabled's last successful check of the
 	// global disable key.
 	globalEnabledNextCheck = time.Time{}
@@ -83,36 +95,117 @@ func IsGloballyEnabled(c context.Context) bool {
 		return true
 	}
 	globalEnabled = cfg.Enable
+	sawEpochLocked(cfg)
 	globalEnabledNextCheck = now.Add(GlobalEnabledCheckInterval)
 	return globalEnabled
 }
 
-// SetGlobalEnable is a convenience function for manipulating the GlobalConfig.
-//
-// It's meant to be called from admin handlers on your app to turn dscache
-// functionality on or off in emergencies.
-func SetGlobalEnable(c context.Context, memcacheEnabled bool) error {
+// lastEpoch returns the epoch which IsGloballyEnabled last saw, and when it
+// was bumped.
+func lastEpoch() (int64, time.Time) {
+	globalEnabledLock.RLock()
+	defer globalEnabledLock.RUnlock()
+	return globalEpoch, globalEpochBumped
+}
+
+// sawEpoch records the Epoch and EpochBumped of cfg, unless a later epoch has
+// been seen already.
+func sawEpoch(cfg *GlobalConfig) {
+	globalEnabledLock.Lock()
+	defer globalEnabledLock.Unlock()
+	sawEpochLocked(cfg)
+}
+
+func sawEpochLocked(cfg *GlobalConfig) {
+	if cfg.Epoch > globalEpoch {
+		globalEpoch = cfg.Epoch
+		globalEpochBumped = cfg.EpochBumped
+	}
+}
+
+// epochSettling returns true if the epoch was bumped so recently that some
+// instances may still be using the previous one (i.e. less than
+// GlobalEnabledCheckInterval ago).
+func epochSettling(c context.Context, bumped time.Time) bool {
+	return clock.Now(c).Before(bumped.Add(GlobalEnabledCheckInterval))
+}
+
+// bumpEpoch increments the epoch of cfg.
+func bumpEpoch(c context.Context, cfg *GlobalConfig) {
+	cfg.Epoch++
+	cfg.EpochBumped = clock.Now(c).UTC()
+}
+
+// updateGlobalConfig runs cb in a transaction on the GlobalConfig, and puts it
+// if cb returns true. It returns the resulting GlobalConfig.
+func updateGlobalConfig(c context.Context, cb func(cfg *GlobalConfig) bool) (*GlobalConfig, error) {
 	// always go to the default namespace
 	c, err := info.Get(c).Namespace("")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return datastore.Get(c).RunInTransaction(func(c context.Context) error {
+	var ret *GlobalConfig
+	err = datastore.Get(c).RunInTransaction(func(c context.Context) error {
 		ds := datastore.Get(c)
 		cfg := &GlobalConfig{Enable: true}
 		if err := ds.Get(cfg); err != nil && err != datastore.ErrNoSuchEntity {
 			return err
 		}
-		if cfg.Enable == memcacheEnabled {
+		ret = cfg
+		if !cb(cfg) {
 			return nil
 		}
+		return ds.Put(cfg)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// SetGlobalEnable is a convenience function for manipulating the GlobalConfig.
+//
+// It's meant to be called from admin handlers on your app to turn dscache
+// functionality on or off in emergencies. When it turns dscache back on, it
+// bumps the epoch (see BumpEpoch), since the cached entities may have been
+// changed while dscache was off. Instances pick up the new epoch along with
+// the Enable bit, so none of them uses the old epoch's entries again, and none
+// caches anything until every instance has had the time to see it.
+func SetGlobalEnable(c context.Context, memcacheEnabled bool) error {
+	_, err := updateGlobalConfig(c, func(cfg *GlobalConfig) bool {
+		if cfg.Enable == memcacheEnabled {
+			return false
+		}
 		cfg.Enable = memcacheEnabled
 		if memcacheEnabled {
-			// when going false -> true, wipe memcache.
-			if err := memcache.Get(c).Flush(); err != nil {
-				return err
-			}
+			// when going false -> true, abandon everything cached so far.
+			bumpEpoch(c, cfg)
 		}
-		return ds.Put(cfg)
-	}, nil)
+		return true
+	})
+	return err
+}
+
+// BumpEpoch increments the GlobalConfig's Epoch, and returns the new epoch.
+// Since the epoch is part of every memcache key which dscache uses, this
+// invalidates every cached entity at once, unlike memcache's Flush, which
+// also wipes everything else in memcache. The entities cached in older
+// epochs are left for memcache to evict.
+//
+// Other instances only pick up the new epoch when they next check the
+// GlobalConfig (see GlobalEnabledCheckInterval). Until then, their writes
+// only invalidate the previous epoch's entries. So for one
+// GlobalEnabledCheckInterval after the bump, the instances which have seen
+// it read through to the datastore without caching anything, and their
+// writes invalidate the previous epoch's entries as well as the new one's.
+func BumpEpoch(c context.Context) (int64, error) {
+	cfg, err := updateGlobalConfig(c, func(cfg *GlobalConfig) bool {
+		bumpEpoch(c, cfg)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	sawEpoch(cfg)
+	return cfg.Epoch, nil
 }
@@ -0,0 +1,79 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package dscache
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var updateGolden = flag.Bool("update-golden", false,
+	"Write the testdata/item.v<serialize.Version>*.golden files from the current encoding.")
+
+// goldenItems are the PropertyMaps in the golden files, by the suffix of their
+// file names. The "zlib" one is big enough to be compressed.
+var goldenItems = map[string]datastore.PropertyMap{
+	"": {
+		"Value": {datastore.MkProperty("hi")},
+		"Ref":   {datastore.MkProperty(datastore.MakeKey("aid", "ns", "Kind", 1))},
+		"Nums":  {datastore.MkPropertyNI(1), datastore.MkPropertyNI(2)},
+	},
+	".zlib": {
+		"BigData": {datastore.MkPropertyNI([]byte(strings.Repeat("data", CompressionThreshold)))},
+	},
+}
+
+func goldenItemPath(version byte, suffix string) string {
+	return filepath.Join("testdata", fmt.Sprintf("item.v%d%s.golden", version, suffix))
+}
+
+// TestGoldenItemValues checks that memcache values written by every version of
+// the serialize encoding can still be read, so that dscache keeps working
+// while old and new versions of an app run side by side. See
+// serialize.TestGoldenCompatibility for when to run `go test -update-golden`.
+func TestGoldenItemValues(t *testing.T) {
+	t.Parallel()
+
+	Convey("Golden memcache item values", t, func() {
+		for suffix, pm := range goldenItems {
+			suffix, pm := suffix, pm
+			cur := encodeItemValue(pm)
+			if *updateGolden {
+				So(ioutil.WriteFile(goldenItemPath(serialize.Version, suffix), cur, 0644), ShouldBeNil)
+			}
+
+			Convey(fmt.Sprintf("item%s", suffix), func() {
+				if suffix == "" {
+					Convey("the current encoding matches its golden file", func() {
+						data, err := ioutil.ReadFile(goldenItemPath(serialize.Version, suffix))
+						So(err, ShouldBeNil)
+						// If this fails, the encoding has changed without a new
+						// serialize.Version.
+						So(data, ShouldResemble, cur)
+					})
+				}
+
+				for v := serialize.Unversioned; v <= serialize.Version; v++ {
+					v := v
+					Convey(fmt.Sprintf("version %d is readable", v), func() {
+						data, err := ioutil.ReadFile(goldenItemPath(v, suffix))
+						So(err, ShouldBeNil)
+						dec, err := decodeItemValue(data, "aid", "ns")
+						So(err, ShouldBeNil)
+						So(dec, ShouldResemble, pm)
+					})
+				}
+			})
+		}
+	})
+}
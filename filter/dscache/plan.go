@@ -85,6 +85,11 @@ func (p *plan) empty() bool {
 //     from datastore and then attempt to save them back to memcache.
 //   * some entries are 'lock' entries, owned by something else, so we should
 //     get them from datastore and then NOT save them to memcache.
+//   * some entries are for keys-only reads (see datastore.KeysOnlyMeta). We
+//     answer them from the memcache data without decoding it, and never save
+//     them to memcache.
+//   * some entries are for Preload, which doesn't need the data of entities
+//     which are already cached, so we don't decode it.
 //
 // Or some combination thereof. This also handles memcache enries with invalid
 // data in them, cases where items have caching disabled entirely, etc.
@@ -105,9 +110,12 @@ func makeFetchPlan(c context.Context, aid, ns string, f *facts) *plan {
 			continue
 		}
 
+		keysOnly := isKeysOnly(f.getMeta, i)
+		preload := ds.GetMetaDefault(m, preloadMeta, false).(bool)
+
 		switch FlagValue(lockItm.Flags()) {
 		case ItemHasLock:
-			if bytes.Equal(f.nonce, lockItm.Value()) {
+			if !keysOnly && bytes.Equal(f.nonce, lockItm.Value()) {
 				// we have the lock
 				p.add(i, getKey, m, lockItm)
 			} else {
@@ -116,6 +124,20 @@ func makeFetchPlan(c context.Context, aid, ns string, f *facts) *plan {
 			}
 
 		case ItemHasData:
+			if preload {
+				// Preload only needs the entity to be cached, which it already is.
+				continue
+			}
+			if keysOnly {
+				// The caller only wants to know that the entity exists, so there's
+				// no need to decode it.
+				if len(lockItm.Value()) == 0 {
+					p.lme.Assign(i, ds.ErrNoSuchEntity)
+				} else {
+					p.decoded[i] = ds.PropertyMap{}
+				}
+				continue
+			}
 			pmap, err := decodeItemValue(lockItm.Value(), aid, ns)
 			switch err {
 			case nil:
@@ -135,3 +157,9 @@ func makeFetchPlan(c context.Context, aid, ns string, f *facts) *plan {
 	}
 	return &p
 }
+
+// isKeysOnly returns true if the caller of GetMulti only needs to know whether
+// the i'th entity exists (see datastore.KeysOnlyMeta).
+func isKeysOnly(metas ds.MultiMetaGetter, i int) bool {
+	return ds.GetMetaDefault(metas.GetSingle(i), ds.KeysOnlyMeta, false).(bool)
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package dscache
+
+import (
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/clock"
+	"github.com/luci/luci-go/common/errors"
+	"golang.org/x/net/context"
+)
+
+// DefaultPreloadBatchSize is the number of keys which Preload loads at a time,
+// if PreloadOptions.BatchSize isn't set.
+const DefaultPreloadBatchSize = 100
+
+// preloadMeta is the metadata key which Preload sets for the keys it gets, so
+// that dscache doesn't decode the entities which are already cached.
+const preloadMeta = "dscache.preload"
+
+type preloadMetaGetterType struct{}
+
+func (preloadMetaGetterType) GetMeta(key string) (interface{}, bool) {
+	if key == preloadMeta {
+		return true, true
+	}
+	return nil, false
+}
+
+// PreloadOptions controls the behavior of Preload.
+type PreloadOptions struct {
+	// BatchSize is the number of keys to load with each datastore GetMulti. If
+	// it's 0, DefaultPreloadBatchSize is used.
+	BatchSize int
+
+	// Interval is the minimum amount of time between the starts of consecutive
+	// batches, to limit the load on the datastore.
+	Interval time.Duration
+
+	// Progress, if not nil, is called after each batch with the number of keys
+	// which have been loaded so far, and the total number of keys.
+	Progress func(done, total int)
+}
+
+// Preload loads the entities with the given keys into memcache, ahead of the
+// requests which will need them (e.g. in a warmup handler, or from a cron job
+// after a memcache flush). c must have the dscache filter installed (see
+// FilterRDS).
+//
+// Entities which are already cached aren't read from the datastore. Others are
+// cached exactly as a Get would cache them, in one of their shards and with
+// the default expiration (CacheTimeSeconds). Entities which don't exist are
+// cached as such.
+//
+// If loading some of the entities fails, Preload still loads the rest, and
+// returns an errors.MultiError with the same length as keys. It returns the
+// context's error if c is cancelled between batches.
+func Preload(c context.Context, keys []*ds.Key, opts *PreloadOptions) error {
+	if opts == nil {
+		opts = &PreloadOptions{}
+	}
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultPreloadBatchSize
+	}
+
+	rds := ds.GetRaw(c)
+	lme := errors.NewLazyMultiError(len(keys))
+	for start := 0; start < len(keys); start += batchSize {
+		if start > 0 && opts.Interval > 0 {
+			clock.Sleep(c, opts.Interval)
+		}
+		if err := c.Err(); err != nil {
+			return err
+		}
+
+		end := start + batchSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		batch := keys[start:end]
+		metas := make(ds.MultiMetaGetter, len(batch))
+		for i := range metas {
+			metas[i] = preloadMetaGetterType{}
+		}
+
+		i := start
+		err := rds.GetMulti(batch, metas, func(_ ds.PropertyMap, err error) error {
+			if err != ds.ErrNoSuchEntity {
+				lme.Assign(i, err)
+			}
+			i++
+			return nil
+		})
+		if err != nil {
+			for i := start; i < end; i++ {
+				lme.Assign(i, err)
+			}
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(end, len(keys))
+		}
+	}
+	return lme.Get()
+}
@@ -7,24 +7,31 @@ package dscache
 import (
 	"bytes"
 	"compress/zlib"
-	"io/ioutil"
+	"fmt"
+	"io"
 
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/datastore/serialize"
 )
 
+// versionedValue is set in the compression byte of values whose encoded
+// PropertyMap starts with its serialize.Version. Values without it were
+// written before the encoding was versioned, and are read as
+// serialize.Unversioned.
+const versionedValue = 0x80
+
 func encodeItemValue(pm ds.PropertyMap) []byte {
 	pm, _ = pm.Save(false)
 
 	buf := bytes.Buffer{}
 	// errs can't happen, since we're using a byte buffer.
-	_ = buf.WriteByte(byte(NoCompression))
-	_ = serialize.WritePropertyMap(&buf, serialize.WithoutContext, pm)
+	_ = buf.WriteByte(byte(NoCompression) | versionedValue)
+	_ = serialize.WriteVersionedPropertyMap(&buf, serialize.WithoutContext, pm)
 
 	data := buf.Bytes()
 	if buf.Len() > CompressionThreshold {
 		buf2 := bytes.NewBuffer(make([]byte, 0, len(data)))
-		_ = buf2.WriteByte(byte(ZlibCompression))
+		_ = buf2.WriteByte(byte(ZlibCompression) | versionedValue)
 		writer := zlib.NewWriter(buf2)
 		_, _ = writer.Write(data[1:]) // skip the NoCompression byte
 		writer.Close()
@@ -43,18 +50,26 @@ func decodeItemValue(val []byte, ns, aid string) (ds.PropertyMap, error) {
 	if err != nil {
 		return nil, err
 	}
+	versioned := compTypeByte&versionedValue != 0
 
-	if CompressionType(compTypeByte) == ZlibCompression {
+	// The PropertyMap is decoded as it's decompressed, so large values aren't
+	// held in memory a second time.
+	r := io.Reader(buf)
+	switch compType := CompressionType(compTypeByte &^ versionedValue); compType {
+	case NoCompression:
+	case ZlibCompression:
 		reader, err := zlib.NewReader(buf)
 		if err != nil {
 			return nil, err
 		}
 		defer reader.Close()
-		data, err := ioutil.ReadAll(reader)
-		if err != nil {
-			return nil, err
-		}
-		buf = bytes.NewBuffer(data)
+		r = reader
+	default:
+		return nil, fmt.Errorf("unknown compression type %s", compType)
+	}
+
+	if versioned {
+		return serialize.ReadVersionedPropertyMapFrom(r, serialize.WithoutContext, ns, aid)
 	}
-	return serialize.ReadPropertyMap(buf, serialize.WithoutContext, ns, aid)
+	return serialize.ReadPropertyMapVersionFrom(r, serialize.Unversioned, serialize.WithoutContext, ns, aid)
 }
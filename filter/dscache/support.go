@@ -5,7 +5,6 @@
 package dscache
 
 import (
-	"fmt"
 	"math/rand"
 	"time"
 
@@ -17,8 +16,14 @@ import (
 )
 
 type supportContext struct {
-	aid string
-	ns  string
+	aid   string
+	ns    string
+	epoch int64
+
+	// settling is true if epoch was bumped so recently that other instances
+	// may still use the previous one (see BumpEpoch). Reads don't fill the
+	// cache then, and writes invalidate both epochs.
+	settling bool
 
 	c            context.Context
 	mc           memcache.Interface
@@ -41,6 +46,9 @@ func (s *supportContext) numShards(k *ds.Key) int {
 }
 
 func (s *supportContext) mkRandKeys(keys []*ds.Key, metas ds.MultiMetaGetter) []string {
+	if s.settling {
+		return nil
+	}
 	ret := []string(nil)
 	for i, key := range keys {
 		mg := metas.GetSingle(i)
@@ -54,7 +62,7 @@ func (s *supportContext) mkRandKeys(keys []*ds.Key, metas ds.MultiMetaGetter) []
 		if ret == nil {
 			ret = make([]string, len(keys))
 		}
-		ret[i] = MakeMemcacheKey(s.mr.Intn(shards), key)
+		ret[i] = MakeEpochMemcacheKey(s.epoch, s.mr.Intn(shards), key)
 	}
 	return ret
 }
@@ -72,12 +80,18 @@ func (s *supportContext) mkAllKeys(keys []*ds.Key) []string {
 	if size == 0 {
 		return nil
 	}
-	ret := make([]string, 0, size)
+	epochs := []int64{s.epoch}
+	if s.settling && s.epoch > 0 {
+		epochs = append(epochs, s.epoch-1)
+	}
+	ret := make([]string, 0, size*len(epochs))
 	for i, key := range keys {
 		if !key.Incomplete() {
 			keySuffix := HashKey(key)
-			for shard := 0; shard < nums[i]; shard++ {
-				ret = append(ret, fmt.Sprintf(KeyFormat, shard, keySuffix))
+			for _, epoch := range epochs {
+				for shard := 0; shard < nums[i]; shard++ {
+					ret = append(ret, memcacheKey(epoch, shard, keySuffix))
+				}
 			}
 		}
 	}
@@ -109,16 +123,20 @@ func (s *supportContext) mutation(keys []*ds.Key, f func() error) error {
 	if lockItems == nil {
 		return f()
 	}
-	if err := s.mc.SetMulti(lockItems); err != nil {
+	err := s.mc.SetMulti(lockItems)
+	breaker.record(s.c, err)
+	if err != nil {
 		// this is a hard failure. No mutation can occur if we're unable to set
 		// locks out. See "DANGER ZONE" in the docs.
 		(log.Fields{log.ErrorKey: err}).Errorf(
 			s.c, "dscache: HARD FAILURE: supportContext.mutation(): mc.SetMulti")
 		return err
 	}
-	err := f()
+	err = f()
 	if err == nil {
-		if err := errors.Filter(s.mc.DeleteMulti(lockKeys), memcache.ErrCacheMiss); err != nil {
+		err := errors.Filter(s.mc.DeleteMulti(lockKeys), memcache.ErrCacheMiss)
+		breaker.record(s.c, err)
+		if err != nil {
 			(log.Fields{log.ErrorKey: err}).Warningf(
 				s.c, "dscache: mc.DeleteMulti")
 		}
@@ -0,0 +1,149 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package featureBreaker
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+// Service is one of the services which FilterAll breaks.
+type Service string
+
+// These are the services which FilterAll breaks.
+const (
+	Datastore Service = "datastore"
+	Info      Service = "info"
+	Mail      Service = "mail"
+	Memcache  Service = "memcache"
+	Module    Service = "module"
+	TaskQueue Service = "taskqueue"
+	User      Service = "user"
+)
+
+// Breakers is the FeatureBreaker of each Service.
+type Breakers map[Service]FeatureBreaker
+
+// FilterAll installs a featureBreaker filter for every Service in the context,
+// all with the same defaultError.
+func FilterAll(c context.Context, defaultError error) (context.Context, Breakers) {
+	ret := make(Breakers, 7)
+	for _, f := range []struct {
+		svc    Service
+		filter func(context.Context, error) (context.Context, FeatureBreaker)
+	}{
+		{Datastore, FilterRDS},
+		{Info, FilterGI},
+		{Mail, FilterMail},
+		{Memcache, FilterMC},
+		{Module, FilterModule},
+		{TaskQueue, FilterTQ},
+		{User, FilterUser},
+	} {
+		c, ret[f.svc] = f.filter(c, defaultError)
+	}
+	return c, ret
+}
+
+// Event is a change to the broken features of a Service at some point of a
+// Scenario.
+type Event struct {
+	// At is when the event happens, relative to the start of the scenario.
+	At time.Duration
+
+	Service Service
+
+	// Features are the features which are broken or unbroken. If it's empty,
+	// every feature of the service is (i.e. AllFeatures).
+	Features []string
+
+	// Unbreak is true if the features are unbroken, instead of broken.
+	Unbreak bool
+
+	// Err is the error which the broken features return (see BreakFeatures).
+	Err error
+}
+
+// Scenario is a script of failures, like "memcache dies after 5 seconds, and
+// datastore Puts start failing after 10":
+//   Scenario{
+//     {At: 5 * time.Second, Service: Memcache},
+//     {At: 10 * time.Second, Service: Datastore, Features: []string{"PutMulti"}},
+//   }
+type Scenario []Event
+
+// Chaos installs a featureBreaker filter for every Service in the context (see
+// FilterAll), which are broken and unbroken by the events of the scenario,
+// as time passes on the context's clock (e.g. a testclock). The scenario
+// starts when Chaos is called.
+//
+// It panics if an event has an unknown Service. Events are applied in order of their At (and then in the order they're
+// listed), when a broken service is next called after they're due. So
+// advancing a testclock past an event deterministically affects the next call.
+func Chaos(c context.Context, defaultError error, scenario Scenario) (context.Context, Breakers) {
+	c, ret := FilterAll(c, defaultError)
+
+	sc := &scenarioState{
+		clk:      clock.Get(c),
+		start:    clock.Now(c),
+		events:   append(scenario[:0:0], scenario...),
+		breakers: ret,
+	}
+	for _, e := range sc.events {
+		if ret[e.Service] == nil {
+			panic(fmt.Errorf("featureBreaker: unknown service %q", e.Service))
+		}
+	}
+	sort.Stable(eventsByTime(sc.events))
+	for _, fb := range ret {
+		fb.(*state).tick = sc.apply
+	}
+	return c, ret
+}
+
+type eventsByTime []Event
+
+func (s eventsByTime) Len() int           { return len(s) }
+func (s eventsByTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s eventsByTime) Less(i, j int) bool { return s[i].At < s[j].At }
+
+type scenarioState struct {
+	sync.Mutex
+
+	clk   clock.Clock
+	start time.Time
+
+	// events are the events which haven't happened yet, sorted by At.
+	events   []Event
+	breakers Breakers
+}
+
+// apply applies the events which are due.
+func (s *scenarioState) apply() {
+	s.Lock()
+	defer s.Unlock()
+
+	elapsed := s.clk.Now().Sub(s.start)
+	for len(s.events) > 0 && s.events[0].At <= elapsed {
+		e := s.events[0]
+		s.events = s.events[1:]
+
+		fb := s.breakers[e.Service]
+		features := e.Features
+		if len(features) == 0 {
+			features = []string{AllFeatures}
+		}
+		if e.Unbreak {
+			fb.UnbreakFeatures(features...)
+		} else {
+			fb.BreakFeatures(e.Err, features...)
+		}
+	}
+}
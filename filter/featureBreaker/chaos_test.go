@@ -0,0 +1,66 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package featureBreaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tetrafolium/gae/impl/memory"
+	"github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/memcache"
+	"golang.org/x/net/context"
+)
+
+func TestChaos(t *testing.T) {
+	t.Parallel()
+
+	Convey("Chaos", t, func() {
+		c, tc := testclock.UseTime(context.Background(), time.Date(2000, time.January, 1, 1, 1, 1, 1, time.UTC))
+		c = memory.Use(c)
+
+		dsErr := errors.New("datastore degraded")
+		c, fbs := Chaos(c, memcache.ErrServerError, Scenario{
+			{At: 15 * time.Second, Service: Memcache, Unbreak: true},
+			{At: 5 * time.Second, Service: Memcache},
+			{At: 10 * time.Second, Service: Datastore, Features: []string{"PutMulti"}, Err: dsErr},
+		})
+		So(len(fbs), ShouldEqual, 7)
+
+		mc := memcache.Get(c)
+		ds := datastore.Get(c)
+		type Foo struct {
+			ID int64 `gae:"$id"`
+		}
+		check := func(mcErr, putErr error) {
+			So(mc.Set(mc.NewItem("k")), ShouldEqual, mcErr)
+			So(ds.Put(&Foo{ID: 1}), ShouldEqual, putErr)
+			So(ds.Get(&Foo{ID: 1}), ShouldBeIn, []error{nil, datastore.ErrNoSuchEntity})
+		}
+
+		check(nil, nil)
+		tc.Add(5 * time.Second)
+		check(memcache.ErrServerError, nil)
+		tc.Add(5 * time.Second)
+		check(memcache.ErrServerError, dsErr)
+		tc.Add(time.Hour)
+		check(nil, dsErr)
+
+		Convey("and the breakers still work by hand", func() {
+			fbs[Datastore].UnbreakFeatures(AllFeatures)
+			check(nil, nil)
+		})
+	})
+
+	Convey("Chaos rejects unknown services", t, func() {
+		So(func() {
+			Chaos(memory.Use(context.Background()), nil, Scenario{{Service: "nope"}})
+		}, ShouldPanicLike, `unknown service "nope"`)
+	})
+}
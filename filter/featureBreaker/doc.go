@@ -6,5 +6,6 @@
 // API features at test-time.
 //
 // In particular, it can be used to cause specific service methods to start
-// returning specific errors during the test.
+// returning specific errors during the test. Chaos breaks every service
+// according to a Scenario, for end-to-end failure drills.
 package featureBreaker
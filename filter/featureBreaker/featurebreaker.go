@@ -29,12 +29,19 @@ import (
 // You may also pass nil as the error for BreakFeatures, and the fake will
 // provide the DefaultError which you passed to the Filter function.
 //
+// The feature AllFeatures breaks (or unbreaks) every feature of the service.
+//
 // This interface can only break features which return errors.
 type FeatureBreaker interface {
 	BreakFeatures(err error, feature ...string)
 	UnbreakFeatures(feature ...string)
 }
 
+// AllFeatures is the feature which stands for every feature of a service.
+// UnbreakFeatures(AllFeatures) unbreaks every feature, including ones which
+// were broken by name.
+const AllFeatures = "*"
+
 // ErrBrokenFeaturesBroken is returned from RunIfNotBroken when BrokenFeatures
 // itself isn't working correctly.
 var ErrBrokenFeaturesBroken = errors.New("featureBreaker: Unable to retrieve caller information")
@@ -48,6 +55,10 @@ type state struct {
 	// BreakFeatures(nil, ...). If this is unset and the user calls BreakFeatures
 	// with nil, BrokenFeatures will return a generic error.
 	defaultError error
+
+	// tick, if set, is called before each call of a feature, to let a Scenario
+	// break and unbreak features.
+	tick func()
 }
 
 func newState(dflt error) *state {
@@ -78,11 +89,18 @@ func (s *state) UnbreakFeatures(feature ...string) {
 	s.Lock()
 	defer s.Unlock()
 	for _, f := range feature {
+		if f == AllFeatures {
+			s.broken = map[string]error{}
+			return
+		}
 		delete(s.broken, f)
 	}
 }
 
 func (s *state) run(f func() error) error {
+	if s.tick != nil {
+		s.tick()
+	}
 	if s.noBrokenFeatures() {
 		return f()
 	}
@@ -94,6 +112,9 @@ func (s *state) run(f func() error) error {
 
 	s.Lock()
 	err, ok := s.broken[name]
+	if !ok {
+		err, ok = s.broken[AllFeatures]
+	}
 	dflt := s.defaultError
 	s.Unlock()
 
@@ -9,6 +9,7 @@ import (
 
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/info"
 )
 
@@ -78,6 +79,9 @@ func (g *infoState) SignBytes(bytes []byte) (keyName string, signature []byte, e
 func FilterGI(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return info.AddFilters(c, func(ic context.Context, i info.Interface) info.Interface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return i
+		}
 		return &infoState{state, i}
 	}), state
 }
@@ -5,6 +5,7 @@
 package featureBreaker
 
 import (
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/mail"
 	"golang.org/x/net/context"
 )
@@ -29,6 +30,9 @@ func (m *mailState) SendToAdmins(msg *mail.Message) error {
 func FilterMail(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return mail.AddFilters(c, func(ic context.Context, i mail.Interface) mail.Interface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return i
+		}
 		return &mailState{state, i}
 	}), state
 }
@@ -7,6 +7,7 @@ package featureBreaker
 import (
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	mc "github.com/tetrafolium/gae/service/memcache"
 )
 
@@ -52,6 +53,9 @@ func (m *mcState) Stats() (ret *mc.Statistics, err error) {
 func FilterMC(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return mc.AddRawFilters(c, func(ic context.Context, rds mc.RawInterface) mc.RawInterface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return rds
+		}
 		return &mcState{state, rds}
 	}), state
 }
@@ -7,6 +7,7 @@ package featureBreaker
 import (
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/module"
 )
 
@@ -70,6 +71,9 @@ func (m *modState) Stop(mod, ver string) error {
 func FilterModule(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return module.AddFilters(c, func(ic context.Context, i module.Interface) module.Interface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return i
+		}
 		return &modState{state, i}
 	}), state
 }
@@ -7,6 +7,7 @@ package featureBreaker
 import (
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	ds "github.com/tetrafolium/gae/service/datastore"
 )
 
@@ -84,6 +85,9 @@ func (r *dsState) Testable() ds.Testable {
 func FilterRDS(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return ds.AddRawFilters(c, func(ic context.Context, RawDatastore ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return RawDatastore
+		}
 		return &dsState{state, RawDatastore}
 	}), state
 }
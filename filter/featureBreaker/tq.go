@@ -7,6 +7,7 @@ package featureBreaker
 import (
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	tq "github.com/tetrafolium/gae/service/taskqueue"
 )
 
@@ -42,6 +43,9 @@ func (t *tqState) Testable() tq.Testable {
 func FilterTQ(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return tq.AddRawFilters(c, func(ic context.Context, tq tq.RawInterface) tq.RawInterface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return tq
+		}
 		return &tqState{state, tq}
 	}), state
 }
@@ -5,6 +5,7 @@
 package featureBreaker
 
 import (
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/service/user"
 	"golang.org/x/net/context"
 )
@@ -61,6 +62,9 @@ func (u *userState) OAuthConsumerKey() (ret string, err error) {
 func FilterUser(c context.Context, defaultError error) (context.Context, FeatureBreaker) {
 	state := newState(defaultError)
 	return user.AddFilters(c, func(ic context.Context, i user.Interface) user.Interface {
+		if filter.IsDisabled(ic, filter.FeatureBreaker) {
+			return i
+		}
 		return &userState{state, i}
 	}), state
 }
@@ -0,0 +1,18 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package filter
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+)
+
+// Handler is an HTTP handler which takes the request's context (e.g. from
+// prod.Use). The filters' middlewares take and return Handlers, so that they
+// can be chained:
+//
+//   h = callLog.Middleware(callStats.Middleware(putBatch.Middleware(h)))
+type Handler func(c context.Context, rw http.ResponseWriter, r *http.Request)
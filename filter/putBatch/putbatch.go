@@ -0,0 +1,176 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package putBatch contains a datastore filter which defers the entities that
+// a request puts outside of transactions, and saves them in large batches.
+// This makes handlers which save many small entities one at a time (e.g. in
+// a loop) faster, since they make fewer datastore calls.
+//
+// The deferred entities are saved (flushed) when:
+//   - BatchSize of them are pending.
+//   - A query is run (with Run or Count) or a transaction begins, so that they
+//     observe the deferred entities.
+//   - An entity with an incomplete key is put, since it needs its ID now.
+//   - Flush is called (e.g. by Middleware, at the end of the request).
+//
+// Get returns the deferred version of an entity, and Delete cancels it.
+//
+// DANGER: since PutMulti returns before the entities are saved, an error saving
+// them is returned from the call which flushes them instead (see FlushError).
+// The entities which fail to save are dropped. So a handler must call Flush
+// before reporting success, if it needs to know that its entities were saved.
+package putBatch
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"github.com/luci/luci-go/common/errors"
+	log "github.com/luci/luci-go/common/logging"
+	"golang.org/x/net/context"
+)
+
+// DefaultBatchSize is the number of pending entities at which they're flushed,
+// if FilterRDS is given a batchSize of 0. It's the most entities which the
+// datastore allows in a single PutMulti.
+const DefaultBatchSize = 500
+
+type key int
+
+var (
+	stateKey key
+	inTxnKey key = 1
+)
+
+// FlushError is returned when flushing the deferred entities fails.
+type FlushError struct {
+	// Keys are the keys of the entities which were being flushed.
+	Keys []*ds.Key
+
+	// Errors has the error for each of Keys, which is nil for the entities
+	// which were saved.
+	Errors errors.MultiError
+}
+
+func (e *FlushError) Error() string {
+	failed, first := 0, error(nil)
+	for _, err := range e.Errors {
+		if err != nil {
+			if first == nil {
+				first = err
+			}
+			failed++
+		}
+	}
+	return fmt.Sprintf("putBatch: failed to save %d of %d deferred entities: %s",
+		failed, len(e.Keys), first)
+}
+
+// pendingPut is an entity which has been put, but not yet flushed.
+type pendingPut struct {
+	key *ds.Key
+	val ds.PropertyMap
+}
+
+// state holds the pending entities of the context returned by FilterRDS.
+type state struct {
+	sync.Mutex
+
+	batchSize int
+
+	// parent is the (non-transactional) datastore below the filter, which the
+	// pending entities are flushed to.
+	parent ds.RawInterface
+
+	pending []pendingPut
+	// byKey maps the encoded keys of the pending entities to their index in
+	// pending.
+	byKey map[string]int
+}
+
+func encKey(k *ds.Key) string {
+	return string(serialize.ToBytes(k))
+}
+
+// flush saves the pending entities.
+func (s *state) flush() error {
+	// Hold the lock while saving, so that a concurrent flush can't save older
+	// versions of the entities after this one.
+	s.Lock()
+	defer s.Unlock()
+
+	if len(s.pending) == 0 {
+		return nil
+	}
+	keys := make([]*ds.Key, len(s.pending))
+	vals := make([]ds.PropertyMap, len(s.pending))
+	for i, p := range s.pending {
+		keys[i], vals[i] = p.key, p.val
+	}
+	s.pending, s.byKey = nil, nil
+
+	errs := make(errors.MultiError, len(keys))
+	i := 0
+	err := s.parent.PutMulti(keys, vals, func(_ *ds.Key, err error) error {
+		errs[i] = err
+		i++
+		return nil
+	})
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return &FlushError{keys, errs}
+		}
+	}
+	return nil
+}
+
+// FilterRDS installs the write-batching datastore filter in the context. Puts
+// made outside of transactions with the returned context (or contexts derived
+// from it) are deferred until the next flush.
+//
+// If batchSize is 0, DefaultBatchSize is used.
+func FilterRDS(c context.Context, batchSize int) context.Context {
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	s := &state{batchSize: batchSize}
+	c = context.WithValue(c, stateKey, s)
+	return ds.AddRawFilters(c, func(c context.Context, rds ds.RawInterface) ds.RawInterface {
+		if c.Value(inTxnKey) != nil || filter.IsDisabled(c, filter.PutBatch) {
+			return rds
+		}
+		return &batcher{rds, s}
+	})
+}
+
+// Flush saves the deferred entities of c. It does nothing if c doesn't have
+// the filter installed.
+func Flush(c context.Context) error {
+	if s, _ := c.Value(stateKey).(*state); s != nil {
+		return s.flush()
+	}
+	return nil
+}
+
+// Middleware returns a Handler which calls h with the filter installed (with
+// DefaultBatchSize), and flushes its deferred entities when it returns. Since
+// the response has already been written by then, errors are only logged.
+func Middleware(h filter.Handler) filter.Handler {
+	return func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+		c = FilterRDS(c, 0)
+		h(c, rw, r)
+		if err := Flush(c); err != nil {
+			(log.Fields{log.ErrorKey: err}).Errorf(c, "putBatch: failed to flush at the end of the request")
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package putBatch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/filter/count"
+	"github.com/tetrafolium/gae/filter/featureBreaker"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type object struct {
+	ID    int64 `gae:"$id"`
+	Value string
+}
+
+func TestPutBatch(t *testing.T) {
+	t.Parallel()
+
+	Convey("putBatch", t, func() {
+		c := memory.Use(context.Background())
+		under := ds.Get(c)
+		under.Testable().Consistent(true)
+		c, fb := featureBreaker.FilterRDS(c, nil)
+		c, counter := count.FilterRDS(c)
+		c = FilterRDS(c, 3)
+		d := ds.Get(c)
+
+		exists := func(id int64) bool {
+			ex, err := under.Exists(under.MakeKey("object", id))
+			So(err, ShouldBeNil)
+			return ex
+		}
+
+		Convey("defers puts until the batch is full", func() {
+			So(d.Put(&object{ID: 1, Value: "a"}), ShouldBeNil)
+			So(d.PutMulti([]*object{{ID: 2}, {ID: 1, Value: "b"}}), ShouldBeNil)
+			So(counter.PutMulti.Total(), ShouldEqual, 0)
+			So(exists(1), ShouldBeFalse)
+
+			So(d.Put(&object{ID: 3}), ShouldBeNil)
+			So(counter.PutMulti.Successes(), ShouldEqual, 1)
+			o := &object{ID: 1}
+			So(under.Get(o), ShouldBeNil)
+			So(o.Value, ShouldEqual, "b")
+		})
+
+		Convey("returns deferred entities from Get", func() {
+			So(d.Put(&object{ID: 1, Value: "a"}), ShouldBeNil)
+			So(under.Put(&object{ID: 2, Value: "saved"}), ShouldBeNil)
+
+			objs := []*object{{ID: 1}, {ID: 2}, {ID: 3}}
+			So(d.GetMulti(objs), ShouldResemble, errors.MultiError{nil, nil, ds.ErrNoSuchEntity})
+			So(objs[0].Value, ShouldEqual, "a")
+			So(objs[1].Value, ShouldEqual, "saved")
+			So(counter.PutMulti.Total(), ShouldEqual, 0)
+		})
+
+		Convey("when disabled, puts directly, but still flushes deferred entities", func() {
+			So(d.Put(&object{ID: 1}), ShouldBeNil)
+			dc := filter.Disable(c, filter.PutBatch)
+			So(ds.Get(dc).Put(&object{ID: 2}), ShouldBeNil)
+			So(exists(2), ShouldBeTrue)
+			So(exists(1), ShouldBeFalse)
+
+			So(Flush(dc), ShouldBeNil)
+			So(exists(1), ShouldBeTrue)
+		})
+
+		Convey("cancels deferred entities on Delete", func() {
+			So(d.PutMulti([]*object{{ID: 1}, {ID: 2}}), ShouldBeNil)
+			So(d.Delete(d.MakeKey("object", 1)), ShouldBeNil)
+			So(d.Get(&object{ID: 1}), ShouldEqual, ds.ErrNoSuchEntity)
+
+			So(Flush(c), ShouldBeNil)
+			So(exists(1), ShouldBeFalse)
+			So(exists(2), ShouldBeTrue)
+		})
+
+		Convey("flushes before", func() {
+			So(d.Put(&object{ID: 1}), ShouldBeNil)
+
+			Convey("queries", func() {
+				n, err := d.Count(ds.NewQuery("object"))
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 1)
+			})
+
+			Convey("transactions, which aren't batched", func() {
+				So(d.RunInTransaction(func(c context.Context) error {
+					So(exists(1), ShouldBeTrue)
+					return ds.Get(c).Put(&object{ID: 1, Value: "txn"})
+				}, nil), ShouldBeNil)
+				o := &object{ID: 1}
+				So(under.Get(o), ShouldBeNil)
+				So(o.Value, ShouldEqual, "txn")
+			})
+
+			Convey("puts with incomplete keys", func() {
+				o := &object{}
+				So(d.Put(o), ShouldBeNil)
+				So(o.ID, ShouldNotEqual, 0)
+				So(exists(1), ShouldBeTrue)
+				So(exists(o.ID), ShouldBeTrue)
+			})
+		})
+
+		Convey("reports flush errors", func() {
+			So(d.PutMulti([]*object{{ID: 1}, {ID: 2}}), ShouldBeNil)
+			fb.BreakFeatures(errors.New("boom"), "PutMulti")
+
+			err := Flush(c)
+			So(err, ShouldErrLike, "putBatch: failed to save 2 of 2 deferred entities: boom")
+			So(err.(*FlushError).Keys, ShouldResemble, []*ds.Key{d.MakeKey("object", 1), d.MakeKey("object", 2)})
+
+			// the entities were dropped.
+			fb.UnbreakFeatures("PutMulti")
+			So(Flush(c), ShouldBeNil)
+			So(exists(1), ShouldBeFalse)
+		})
+
+		Convey("Middleware flushes at the end of the request", func() {
+			c := memory.Use(context.Background())
+			under := ds.Get(c)
+			exists := func(id int64) bool {
+				ex, err := under.Exists(under.MakeKey("object", id))
+				So(err, ShouldBeNil)
+				return ex
+			}
+
+			h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+				So(ds.Get(c).Put(&object{ID: 1}), ShouldBeNil)
+				So(exists(1), ShouldBeFalse)
+			})
+			req, err := http.NewRequest("GET", "/", nil)
+			So(err, ShouldBeNil)
+			h(c, httptest.NewRecorder(), req)
+			So(exists(1), ShouldBeTrue)
+		})
+	})
+}
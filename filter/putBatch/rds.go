@@ -0,0 +1,147 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package putBatch
+
+import (
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+// batcher is the filter used outside of transactions.
+type batcher struct {
+	ds.RawInterface
+
+	s *state
+}
+
+var _ ds.RawInterface = (*batcher)(nil)
+
+func (d *batcher) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+	for _, k := range keys {
+		if k.Incomplete() {
+			// the caller needs the new IDs now.
+			if err := d.s.flush(); err != nil {
+				return err
+			}
+			return d.RawInterface.PutMulti(keys, vals, cb)
+		}
+	}
+
+	d.s.Lock()
+	d.s.parent = d.RawInterface
+	if d.s.byKey == nil {
+		d.s.byKey = make(map[string]int, len(keys))
+	}
+	for i, k := range keys {
+		ek := encKey(k)
+		if idx, ok := d.s.byKey[ek]; ok {
+			d.s.pending[idx].val = vals[i]
+			continue
+		}
+		d.s.byKey[ek] = len(d.s.pending)
+		d.s.pending = append(d.s.pending, pendingPut{k, vals[i]})
+	}
+	full := len(d.s.pending) >= d.s.batchSize
+	d.s.Unlock()
+
+	for _, k := range keys {
+		cb(k, nil)
+	}
+	if full {
+		return d.s.flush()
+	}
+	return nil
+}
+
+func (d *batcher) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	found := make([]ds.PropertyMap, len(keys))
+	idxMap := []int(nil)
+	toGet := []*ds.Key(nil)
+	toGetMeta := ds.MultiMetaGetter(nil)
+
+	d.s.Lock()
+	for i, k := range keys {
+		if idx, ok := d.s.byKey[encKey(k)]; ok {
+			// leave out the metadata, like the datastore would.
+			found[i], _ = d.s.pending[idx].val.Save(false)
+			continue
+		}
+		idxMap = append(idxMap, i)
+		toGet = append(toGet, k)
+		if metas != nil {
+			toGetMeta = append(toGetMeta, metas.GetSingle(i))
+		}
+	}
+	d.s.Unlock()
+
+	if len(toGet) == len(keys) {
+		return d.RawInterface.GetMulti(keys, metas, cb)
+	}
+
+	errs := make([]error, len(keys))
+	if len(toGet) > 0 {
+		j := 0
+		err := d.RawInterface.GetMulti(toGet, toGetMeta, func(pm ds.PropertyMap, err error) error {
+			i := idxMap[j]
+			found[i], errs[i] = pm, err
+			j++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	for i, pm := range found {
+		cb(pm, errs[i])
+	}
+	return nil
+}
+
+func (d *batcher) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+	d.s.Lock()
+	if len(d.s.byKey) > 0 {
+		deleted := make(map[string]struct{}, len(keys))
+		for _, k := range keys {
+			deleted[encKey(k)] = struct{}{}
+		}
+		pending := d.s.pending[:0]
+		for _, p := range d.s.pending {
+			ek := encKey(p.key)
+			if _, ok := deleted[ek]; ok {
+				delete(d.s.byKey, ek)
+				continue
+			}
+			d.s.byKey[ek] = len(pending)
+			pending = append(pending, p)
+		}
+		d.s.pending = pending
+	}
+	d.s.Unlock()
+
+	return d.RawInterface.DeleteMulti(keys, cb)
+}
+
+func (d *batcher) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	if err := d.s.flush(); err != nil {
+		return err
+	}
+	return d.RawInterface.Run(fq, cb)
+}
+
+func (d *batcher) Count(fq *ds.FinalizedQuery) (int64, error) {
+	if err := d.s.flush(); err != nil {
+		return 0, err
+	}
+	return d.RawInterface.Count(fq)
+}
+
+func (d *batcher) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	if err := d.s.flush(); err != nil {
+		return err
+	}
+	return d.RawInterface.RunInTransaction(func(c context.Context) error {
+		return f(context.WithValue(c, inTxnKey, true))
+	}, opts)
+}
@@ -0,0 +1,186 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package queryLint
+
+import (
+	"bytes"
+	"fmt"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+)
+
+// MaxOffset is the largest query offset which Check accepts. Production
+// still reads (and bills for) every entity which an offset skips, so queries
+// which page further than this should use cursors instead.
+var MaxOffset int32 = 1000
+
+// Problem is a query which needs a composite index that isn't declared, or
+// which has an offset larger than MaxOffset.
+type Problem struct {
+	Query Query
+
+	// Missing is a composite index which the query needs. It's nil if the
+	// problem is the query's offset.
+	Missing *ds.IndexDefinition
+}
+
+func (p *Problem) Error() string {
+	if p.Missing == nil {
+		offset, _ := p.Query.Query.Offset()
+		return fmt.Sprintf("queryLint: query %s has offset %d (more than %d); use a cursor instead",
+			p.Query.Query, offset, MaxOffset)
+	}
+	return fmt.Sprintf("queryLint: query %s needs undeclared index %s", p.Query.Query, p.Missing)
+}
+
+// Problems is the list of Problems found by Check. It's returned as an error
+// by Recorder.CheckIndexYAML.
+type Problems []*Problem
+
+func (ps Problems) Error() string {
+	missing, offsets := Problems(nil), Problems(nil)
+	for _, p := range ps {
+		if p.Missing == nil {
+			offsets = append(offsets, p)
+		} else {
+			missing = append(missing, p)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	if len(missing) > 0 {
+		fmt.Fprintf(buf, "queryLint: %d queries need undeclared composite indexes:\n", len(missing))
+		for _, p := range missing {
+			fmt.Fprintf(buf, "  %s\n", p.Query.Query)
+		}
+		fmt.Fprintf(buf, "Consider adding to index.yaml:\n%s", ps.YAML())
+	}
+	if len(offsets) > 0 {
+		fmt.Fprintf(buf, "queryLint: %d queries have offsets over %d, and should use cursors:\n", len(offsets), MaxOffset)
+		for _, p := range offsets {
+			fmt.Fprintf(buf, "  %s\n", p.Query.Query)
+		}
+	}
+	return buf.String()
+}
+
+// YAML returns the index.yaml entries for all of the missing indexes, without
+// duplicates.
+func (ps Problems) YAML() string {
+	buf := &bytes.Buffer{}
+	seen := []*ds.IndexDefinition{}
+outer:
+	for _, p := range ps {
+		if p.Missing == nil {
+			continue
+		}
+		for _, s := range seen {
+			if s.Equal(p.Missing) {
+				continue outer
+			}
+		}
+		seen = append(seen, p.Missing)
+
+		y, err := p.Missing.YAMLString()
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintln(buf, y)
+	}
+	return buf.String()
+}
+
+// Check checks queries against the composite indexes in indexes. It returns
+// a Problem for every composite index that one of the queries would need, but
+// which is not in indexes.
+//
+// A query may need more than one missing index, in which case it has a Problem
+// for each of them. Once a missing index has been reported, it's considered to
+// be declared for the remaining queries.
+//
+// Check also returns a Problem (with a nil Missing index) for each query with
+// an offset larger than MaxOffset.
+func Check(queries []Query, indexes []*ds.IndexDefinition) (Problems, error) {
+	compound := make([]*ds.IndexDefinition, 0, len(indexes))
+	for _, idx := range indexes {
+		if !idx.Builtin() {
+			compound = append(compound, idx)
+		}
+	}
+
+	// The in-memory implementation only needs the index definitions (and not
+	// any data) to decide if a query can be satisfied, so we let it do the
+	// index selection in an empty datastore for each app.
+	contexts := map[string]context.Context{}
+
+	ret := Problems(nil)
+	for _, q := range queries {
+		if offset, ok := q.Query.Offset(); ok && offset > MaxOffset {
+			ret = append(ret, &Problem{Query: q})
+		}
+
+		c, ok := contexts[q.AppID]
+		if !ok {
+			c = memory.UseWithAppID(context.Background(), q.AppID)
+			t := ds.Get(c).Testable()
+			t.Consistent(true)
+			t.AddIndexes(compound...)
+			contexts[q.AppID] = c
+		}
+		c, err := info.Get(c).Namespace(q.Namespace)
+		if err != nil {
+			return nil, err
+		}
+
+		var last *ds.IndexDefinition
+		for {
+			_, err := ds.Get(c).Raw().Count(q.Query)
+			if err == nil {
+				break
+			}
+			mi, ok := err.(*memory.ErrMissingIndex)
+			if !ok {
+				return nil, fmt.Errorf("queryLint: checking query %s: %s", q.Query, err)
+			}
+			if last != nil && last.Equal(mi.Missing) {
+				return nil, fmt.Errorf("queryLint: checking query %s: index %s is not sufficient", q.Query, last)
+			}
+			last = mi.Missing
+			ret = append(ret, &Problem{q, mi.Missing})
+			ds.Get(c).Testable().AddIndexes(mi.Missing)
+		}
+	}
+	return ret, nil
+}
+
+// Check checks all of the queries recorded so far against indexes. See Check.
+func (r *Recorder) Check(indexes []*ds.IndexDefinition) (Problems, error) {
+	return Check(r.Queries(), indexes)
+}
+
+// CheckIndexYAML finds and parses the index YAML file using
+// datastore.FindAndParseIndexYAML(path), and checks all of the queries recorded
+// so far against it.
+//
+// It returns a Problems error if any query needs an undeclared composite index,
+// or has an offset larger than MaxOffset.
+func (r *Recorder) CheckIndexYAML(path string) error {
+	indexes, err := ds.FindAndParseIndexYAML(path)
+	if err != nil {
+		return err
+	}
+	problems, err := r.Check(indexes)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package queryLint detects datastore queries which need composite indexes
+// that aren't declared in index.yaml, or which have large offsets that should
+// be cursors.
+//
+// Queries are collected with a Recorder, either by installing the FilterRDS
+// filter in the context used by a test suite, or by adding them directly
+// (e.g. from query builders) with Recorder.Add. The recorded queries are then
+// checked against a set of index definitions with Check or CheckIndexYAML,
+// using the same index selection logic as the in-memory datastore
+// implementation.
+//
+// A typical use is to fail CI when a test runs a query that production would
+// reject:
+//
+//   var rec = &queryLint.Recorder{}
+//
+//   func TestMain(m *testing.M) {
+//     code := m.Run()
+//     if err := rec.CheckIndexYAML("."); err != nil {
+//       fmt.Fprintln(os.Stderr, err)
+//       code = 1
+//     }
+//     os.Exit(code)
+//   }
+//
+// And in each test:
+//
+//   c := rec.FilterRDS(memory.Use(context.Background()))
+package queryLint
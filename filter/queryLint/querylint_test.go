@@ -0,0 +1,124 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package queryLint
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+const indexYAML = `indexes:
+- kind: Foo
+  properties:
+  - name: A
+  - name: B
+    direction: desc
+`
+
+func TestQueryLint(t *testing.T) {
+	t.Parallel()
+
+	Convey("queryLint", t, func() {
+		c := memory.Use(context.Background())
+		ds.Get(c).Testable().AutoIndex(true)
+		c, rec := FilterRDS(c)
+		d := ds.Get(c)
+
+		declared, err := ds.ParseIndexYAML(strings.NewReader(indexYAML))
+		So(err, ShouldBeNil)
+
+		Convey("records queries once", func() {
+			q := ds.NewQuery("Foo").Eq("A", 1)
+			_, err := d.Count(q)
+			So(err, ShouldBeNil)
+			So(d.Run(q, func(ds.PropertyMap) {}), ShouldBeNil)
+			_, err = d.Count(ds.NewQuery("Foo").Eq("A", 2))
+			So(err, ShouldBeNil)
+
+			qs := rec.Queries()
+			So(len(qs), ShouldEqual, 2)
+			So(qs[0].AppID, ShouldEqual, "dev~app")
+			So(qs[0].Namespace, ShouldEqual, "")
+			So(qs[0].Query.String(), ShouldEqual, "SELECT * FROM `Foo` WHERE `A` = 1 ORDER BY `__key__`")
+		})
+
+		Convey("builtin indexes are always fine", func() {
+			d.Count(ds.NewQuery("Foo"))
+			d.Count(ds.NewQuery("Foo").Eq("A", 1).Eq("B", 2))
+			d.Count(ds.NewQuery("Foo").Order("-A"))
+			d.Count(ds.NewQuery("Foo").Ancestor(d.NewKey("Foo", "", 1, nil)))
+
+			problems, err := rec.Check(nil)
+			So(err, ShouldBeNil)
+			So(problems, ShouldBeEmpty)
+		})
+
+		Convey("reports undeclared composite indexes", func() {
+			d.Count(ds.NewQuery("Foo").Eq("A", 1).Order("-B"))
+			d.Count(ds.NewQuery("Foo").Eq("A", 1).Order("C"))
+			d.Count(ds.NewQuery("Foo").Eq("A", 2).Order("C"))
+
+			problems, err := rec.Check(declared)
+			So(err, ShouldBeNil)
+			So(len(problems), ShouldEqual, 1)
+			So(problems[0].Query.Query.String(), ShouldContainSubstring, "ORDER BY `C`")
+			So(problems[0].Missing.String(), ShouldEqual, "C:Foo/A/C")
+			So(problems.YAML(), ShouldEqual,
+				"- kind: Foo\n  properties:\n  - name: A\n  - name: C\n")
+			So(problems, ShouldErrLike, "1 queries need undeclared composite indexes")
+		})
+
+		Convey("checks ancestor queries in their namespace", func() {
+			c := info.Get(c).MustNamespace("ns")
+			d := ds.Get(c)
+			d.Count(ds.NewQuery("Foo").Ancestor(d.NewKey("Foo", "", 1, nil)).Order("A"))
+
+			qs := rec.Queries()
+			So(len(qs), ShouldEqual, 1)
+			So(qs[0].Namespace, ShouldEqual, "ns")
+
+			problems, err := rec.Check(declared)
+			So(err, ShouldBeNil)
+			So(len(problems), ShouldEqual, 1)
+			So(problems[0].Missing.String(), ShouldEqual, "C:Foo|A/A")
+		})
+
+		Convey("reports large offsets", func() {
+			d.Count(ds.NewQuery("Foo").Offset(MaxOffset))
+			d.Count(ds.NewQuery("Foo").Offset(MaxOffset + 1))
+
+			problems, err := rec.Check(nil)
+			So(err, ShouldBeNil)
+			So(len(problems), ShouldEqual, 1)
+			So(problems[0].Missing, ShouldBeNil)
+			So(problems[0], ShouldErrLike, "has offset 1001 (more than 1000); use a cursor instead")
+			So(problems.YAML(), ShouldEqual, "")
+			So(problems, ShouldErrLike, "1 queries have offsets over 1000, and should use cursors")
+		})
+
+		Convey("can check against index.yaml", func() {
+			dir, err := ioutil.TempDir("", "queryLint")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			So(ioutil.WriteFile(filepath.Join(dir, "index.yaml"), []byte(indexYAML), 0644), ShouldBeNil)
+
+			d.Count(ds.NewQuery("Foo").Eq("A", 1).Order("-B"))
+			So(rec.CheckIndexYAML(dir), ShouldBeNil)
+
+			d.Count(ds.NewQuery("Foo").Eq("B", 1).Order("A"))
+			So(rec.CheckIndexYAML(dir), ShouldErrLike, "- name: B\n  - name: A")
+		})
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package queryLint
+
+import (
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+)
+
+// Query is a query recorded by a Recorder, along with the app ID and
+// namespace that it ran in.
+type Query struct {
+	AppID     string
+	Namespace string
+	Query     *ds.FinalizedQuery
+}
+
+// Recorder records datastore queries. The zero value is ready to use, and
+// a Recorder is safe for concurrent use.
+type Recorder struct {
+	lock    sync.Mutex
+	seen    map[queryKey]struct{}
+	queries []Query
+}
+
+type queryKey struct {
+	aid, ns, gql string
+}
+
+// Add records fq as having run in the given app ID and namespace. Identical
+// queries are only recorded once.
+func (r *Recorder) Add(aid, ns string, fq *ds.FinalizedQuery) {
+	k := queryKey{aid, ns, fq.GQL()}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.seen == nil {
+		r.seen = map[queryKey]struct{}{}
+	}
+	if _, ok := r.seen[k]; ok {
+		return
+	}
+	r.seen[k] = struct{}{}
+	r.queries = append(r.queries, Query{aid, ns, fq})
+}
+
+// Queries returns all recorded queries, in the order that they were first
+// recorded.
+func (r *Recorder) Queries() []Query {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]Query(nil), r.queries...)
+}
+
+// FilterRDS installs a datastore filter in the context which records every
+// query run (or counted) with it into r.
+func (r *Recorder) FilterRDS(c context.Context) context.Context {
+	return ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(ic, filter.QueryLint) {
+			return rds
+		}
+		i := info.Get(ic)
+		return &dsRecorder{rds, r, i.FullyQualifiedAppID(), i.GetNamespace()}
+	})
+}
+
+// FilterRDS installs a query recording datastore filter in the context, and
+// returns the new Recorder.
+func FilterRDS(c context.Context) (context.Context, *Recorder) {
+	r := &Recorder{}
+	return r.FilterRDS(c), r
+}
+
+type dsRecorder struct {
+	ds.RawInterface
+
+	r       *Recorder
+	aid, ns string
+}
+
+var _ ds.RawInterface = (*dsRecorder)(nil)
+
+func (d *dsRecorder) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	d.r.Add(d.aid, d.ns, fq)
+	return d.RawInterface.Run(fq, cb)
+}
+
+func (d *dsRecorder) Count(fq *ds.FinalizedQuery) (int64, error) {
+	d.r.Add(d.aid, d.ns, fq)
+	return d.RawInterface.Count(fq)
+}
@@ -0,0 +1,46 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package requestTrace
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/service/info"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+)
+
+type tqTrace struct {
+	tq.RawInterface
+
+	c context.Context
+}
+
+var _ tq.RawInterface = (*tqTrace)(nil)
+
+func (t *tqTrace) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	requestID := info.Get(t.c).RequestID()
+	stamped := make([]*tq.Task, len(tasks))
+	for i, tsk := range tasks {
+		tsk = tsk.Duplicate()
+		if tsk.Header == nil {
+			tsk.Header = http.Header{}
+		}
+		stamp(t.c, requestID, tsk.Header)
+		stamped[i] = tsk
+	}
+	return t.RawInterface.AddMulti(stamped, queueName, cb)
+}
+
+func filterTQ(c context.Context) context.Context {
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		if filter.IsDisabled(ic, filter.RequestTrace) {
+			return rtq
+		}
+		return &tqTrace{rtq, ic}
+	})
+}
@@ -0,0 +1,110 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package requestTrace propagates a trace ID, and the ID of the request which
+// caused the current one, across task queue hops and outgoing fetches. This
+// allows the logs of the requests which result from a single original request
+// (e.g. a user's request, its tasks, and the tasks which they add) to be
+// found and tied together.
+//
+// Middleware reads the Trace of an incoming request from its headers, and
+// installs the filters in its context. The filters add the headers to every
+// task added to a taskqueue, and every request made with urlfetch's
+// http.RoundTripper:
+//   - TraceIDHeader, with the trace ID, which is generated by the first
+//     request of the trace.
+//   - ParentRequestIDHeader, with the info.RequestID of the request which
+//     added the task or made the fetch.
+//
+// Headers which the application sets itself are left as they are.
+package requestTrace
+
+import (
+	"encoding/hex"
+	"net/http"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/luci/luci-go/common/mathrand"
+	"golang.org/x/net/context"
+)
+
+const (
+	// TraceIDHeader is the header which holds the trace ID.
+	TraceIDHeader = "X-Gae-Trace-Id"
+
+	// ParentRequestIDHeader is the header which holds the request ID of the
+	// request which caused the request.
+	ParentRequestIDHeader = "X-Gae-Parent-Request-Id"
+)
+
+// Trace identifies the trace which a request is part of.
+type Trace struct {
+	// TraceID is shared by every request which results from the same original
+	// request.
+	TraceID string
+
+	// ParentRequestID is the request ID of the request which added the task, or
+	// made the fetch, which is the current request. It's empty for the original
+	// request.
+	ParentRequestID string
+}
+
+// FromRequest returns the Trace of an incoming HTTP request, from its headers.
+// The TraceID is empty if the request doesn't have one.
+func FromRequest(r *http.Request) Trace {
+	return Trace{
+		TraceID:         r.Header.Get(TraceIDHeader),
+		ParentRequestID: r.Header.Get(ParentRequestIDHeader),
+	}
+}
+
+type key int
+
+var traceKey key
+
+// Get returns the Trace installed in c by Filter, or a zero Trace if there
+// isn't one.
+func Get(c context.Context) Trace {
+	t, _ := c.Value(traceKey).(Trace)
+	return t
+}
+
+// Filter records t as the trace of the context, and installs the filters which
+// propagate it to tasks and fetches. If t doesn't have a TraceID, a random one
+// is generated, starting a new trace.
+func Filter(c context.Context, t Trace) context.Context {
+	if t.TraceID == "" {
+		t.TraceID = newTraceID(c)
+	}
+	c = context.WithValue(c, traceKey, t)
+	return filterURLFetch(filterTQ(c))
+}
+
+// Middleware returns a Handler which calls h with a context which continues
+// the request's Trace (see Filter).
+func Middleware(h filter.Handler) filter.Handler {
+	return func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+		h(Filter(c, FromRequest(r)), rw, r)
+	}
+}
+
+// newTraceID returns a random 128 bit hex trace ID.
+func newTraceID(c context.Context) string {
+	buf := make([]byte, 16)
+	r := mathrand.Get(c)
+	for i := range buf {
+		buf[i] = byte(r.Intn(256))
+	}
+	return hex.EncodeToString(buf)
+}
+
+// stamp adds the headers of the trace in c to h, unless they're already set.
+func stamp(c context.Context, requestID string, h http.Header) {
+	if h.Get(TraceIDHeader) == "" {
+		h.Set(TraceIDHeader, Get(c).TraceID)
+	}
+	if h.Get(ParentRequestIDHeader) == "" && requestID != "" {
+		h.Set(ParentRequestIDHeader, requestID)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package requestTrace
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"github.com/tetrafolium/gae/impl/memory"
+	"github.com/tetrafolium/gae/service/info"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"github.com/tetrafolium/gae/service/urlfetch"
+	"golang.org/x/net/context"
+)
+
+type requestIDInfo struct {
+	info.Interface
+}
+
+func (requestIDInfo) RequestID() string { return "req-1" }
+
+type recordingTransport struct {
+	reqs []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	t.reqs = append(t.reqs, r)
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("")), Request: r}, nil
+}
+
+func TestRequestTrace(t *testing.T) {
+	t.Parallel()
+
+	Convey("requestTrace", t, func() {
+		c := memory.Use(context.Background())
+		c = info.AddFilters(c, func(ic context.Context, i info.Interface) info.Interface {
+			return requestIDInfo{i}
+		})
+		rt := &recordingTransport{}
+		c = urlfetch.Set(c, rt)
+
+		taskHeader := func(c context.Context) http.Header {
+			tasks := tq.Get(c).Testable().GetScheduledTasks()["default"]
+			So(len(tasks), ShouldEqual, 1)
+			for _, tsk := range tasks {
+				return tsk.Header
+			}
+			return nil
+		}
+
+		Convey("Middleware continues the request's trace", func() {
+			h := Middleware(func(c context.Context, rw http.ResponseWriter, r *http.Request) {
+				So(Get(c), ShouldResemble, Trace{"trace", "parent"})
+
+				So(tq.Get(c).Add(tq.Get(c).NewTask("/task"), ""), ShouldBeNil)
+				So(taskHeader(c).Get(TraceIDHeader), ShouldEqual, "trace")
+				So(taskHeader(c).Get(ParentRequestIDHeader), ShouldEqual, "req-1")
+
+				req, _ := http.NewRequest("GET", "http://example.com", nil)
+				resp, err := (&http.Client{Transport: urlfetch.Get(c)}).Do(req)
+				So(err, ShouldBeNil)
+				resp.Body.Close()
+				So(len(rt.reqs), ShouldEqual, 1)
+				So(rt.reqs[0].Header.Get(TraceIDHeader), ShouldEqual, "trace")
+				So(rt.reqs[0].Header.Get(ParentRequestIDHeader), ShouldEqual, "req-1")
+				So(req.Header.Get(TraceIDHeader), ShouldEqual, "")
+			})
+
+			r, _ := http.NewRequest("GET", "/", nil)
+			r.Header.Set(TraceIDHeader, "trace")
+			r.Header.Set(ParentRequestIDHeader, "parent")
+			h(c, httptest.NewRecorder(), r)
+		})
+
+		Convey("Filter starts new traces", func() {
+			c := Filter(c, Trace{})
+			So(Get(c).TraceID, ShouldHaveLength, 32)
+			So(Get(c).ParentRequestID, ShouldEqual, "")
+			So(Get(Filter(c, Trace{})).TraceID, ShouldNotEqual, Get(c).TraceID)
+		})
+
+		Convey("headers set by the application are kept", func() {
+			c := Filter(c, Trace{TraceID: "trace"})
+			tsk := tq.Get(c).NewTask("/task")
+			tsk.Header = http.Header{TraceIDHeader: {"mine"}}
+			So(tq.Get(c).Add(tsk, ""), ShouldBeNil)
+			So(taskHeader(c).Get(TraceIDHeader), ShouldEqual, "mine")
+			So(taskHeader(c).Get(ParentRequestIDHeader), ShouldEqual, "req-1")
+		})
+	})
+}
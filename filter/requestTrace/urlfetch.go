@@ -0,0 +1,47 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package requestTrace
+
+import (
+	"net/http"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/service/info"
+	"github.com/tetrafolium/gae/service/urlfetch"
+)
+
+type traceTransport struct {
+	base http.RoundTripper
+
+	c context.Context
+}
+
+var _ http.RoundTripper = (*traceTransport)(nil)
+
+// RoundTrip sends a copy of r with the trace's headers, since RoundTrippers
+// mustn't modify their requests.
+func (t *traceTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := *r
+	r2.Header = make(http.Header, len(r.Header)+2)
+	for k, vs := range r.Header {
+		r2.Header[k] = vs
+	}
+	stamp(t.c, info.Get(t.c).RequestID(), r2.Header)
+	return t.base.RoundTrip(&r2)
+}
+
+// filterURLFetch wraps the urlfetch RoundTripper of c. urlfetch doesn't have
+// filters, so the RoundTripper is replaced by one which gets the original from
+// c (and so panics in the same way if c doesn't have one).
+func filterURLFetch(c context.Context) context.Context {
+	return urlfetch.SetFactory(c, func(ic context.Context) http.RoundTripper {
+		if filter.IsDisabled(ic, filter.RequestTrace) {
+			return urlfetch.Get(c)
+		}
+		return &traceTransport{urlfetch.Get(c), ic}
+	})
+}
@@ -0,0 +1,188 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package schemaDrift contains a datastore filter which compares a sample of
+// the entities read from the datastore with the schemas of their kinds, as
+// registered with datastore.RegisterKind, and reports any differences to a
+// Sink. This shows which legacy shapes of data are still in the datastore,
+// e.g. before a migration which drops support for them.
+//
+// An entity drifts from its kind's schema if it has:
+//   - properties which aren't in the schema (unless the schema's struct has an
+//     extra field, which loads them).
+//   - no value for a property which isn't repeated.
+//   - values whose type isn't the property's type (other than PTNull for
+//     nullable properties).
+//
+// Kinds which aren't registered aren't checked. If several struct types are
+// registered for a kind, entities are compared with the first one in
+// datastore.RegisteredSchema's order.
+package schemaDrift
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/mathrand"
+	"golang.org/x/net/context"
+)
+
+// TypeMismatch is a property whose values don't have the schema's type.
+type TypeMismatch struct {
+	Property string
+	Want     string // the schema's type, e.g. "PTInt"
+	Got      string // the type of the first mismatching value
+}
+
+// Drift describes how an entity differs from its kind's schema.
+type Drift struct {
+	Key    *ds.Key
+	GoType string // the Go type of the schema the entity was compared with
+
+	// Unknown and Missing are sorted property names.
+	Unknown []string
+	Missing []string
+
+	// TypeMismatches are sorted by property name.
+	TypeMismatches []TypeMismatch
+}
+
+// Sink receives the Drift of each sampled entity which differs from its
+// schema. It's called synchronously, while the datastore operation which read
+// the entity is in progress, so it should be quick (e.g. increment a metric).
+type Sink func(c context.Context, d *Drift)
+
+// Options configures the filter.
+type Options struct {
+	// SampleRate is the fraction of read entities which are checked, between 0
+	// and 1. If it's 0, nothing is checked and the filter isn't installed; use
+	// 1 to check every entity.
+	SampleRate float64
+
+	// Sink receives the drift of the entities which differ from their schema.
+	Sink Sink
+}
+
+// FilterRDS installs the schema drift filter in the context. Kinds must have
+// been registered (e.g. in init functions) before it's called.
+//
+// It panics if opts.SampleRate isn't between 0 and 1.
+func FilterRDS(c context.Context, opts Options) context.Context {
+	if !(opts.SampleRate >= 0 && opts.SampleRate <= 1) {
+		panic(fmt.Errorf("schemaDrift: SampleRate %v is not between 0 and 1", opts.SampleRate))
+	}
+	if opts.SampleRate == 0 {
+		return c
+	}
+	schemas := map[string]*ds.KindSchema{}
+	for _, s := range ds.RegisteredSchema() {
+		if _, ok := schemas[s.Kind]; !ok && s.Kind != "" {
+			schemas[s.Kind] = s
+		}
+	}
+	return ds.AddRawFilters(c, func(c context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(c, filter.SchemaDrift) {
+			return rds
+		}
+		return &checker{rds, c, opts, schemas}
+	})
+}
+
+// compare returns the drift of pm from s, or nil if it doesn't drift.
+func compare(k *ds.Key, pm ds.PropertyMap, s *ds.KindSchema) *Drift {
+	d := &Drift{Key: k, GoType: s.GoType}
+	known := make(map[string]struct{}, len(s.Properties))
+	for _, p := range s.Properties {
+		known[p.Name] = struct{}{}
+		vals, ok := pm[p.Name]
+		if !ok {
+			if !p.Repeated {
+				d.Missing = append(d.Missing, p.Name)
+			}
+			continue
+		}
+		if p.Type == "" {
+			continue
+		}
+		for _, v := range vals {
+			got := v.Type()
+			if got.String() != p.Type && !(got == ds.PTNull && p.Nullable) {
+				d.TypeMismatches = append(d.TypeMismatches, TypeMismatch{p.Name, p.Type, got.String()})
+				break
+			}
+		}
+	}
+	if !s.Extra {
+		for name := range pm {
+			if _, ok := known[name]; !ok && !strings.HasPrefix(name, "$") {
+				d.Unknown = append(d.Unknown, name)
+			}
+		}
+	}
+
+	if len(d.Unknown) == 0 && len(d.Missing) == 0 && len(d.TypeMismatches) == 0 {
+		return nil
+	}
+	sort.Strings(d.Unknown)
+	sort.Strings(d.Missing)
+	sort.Sort(byProperty(d.TypeMismatches))
+	return d
+}
+
+type byProperty []TypeMismatch
+
+func (s byProperty) Len() int           { return len(s) }
+func (s byProperty) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byProperty) Less(i, j int) bool { return s[i].Property < s[j].Property }
+
+type checker struct {
+	ds.RawInterface
+
+	c       context.Context
+	opts    Options
+	schemas map[string]*ds.KindSchema
+}
+
+var _ ds.RawInterface = (*checker)(nil)
+
+// check samples and compares a single read entity.
+func (d *checker) check(k *ds.Key, pm ds.PropertyMap) {
+	if k == nil || pm == nil {
+		return
+	}
+	s, ok := d.schemas[k.Kind()]
+	if !ok {
+		return
+	}
+	if d.opts.SampleRate < 1 && mathrand.Get(d.c).Float64() >= d.opts.SampleRate {
+		return
+	}
+	if drift := compare(k, pm, s); drift != nil && d.opts.Sink != nil {
+		d.opts.Sink(d.c, drift)
+	}
+}
+
+func (d *checker) GetMulti(keys []*ds.Key, metas ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	i := 0
+	return d.RawInterface.GetMulti(keys, metas, func(pm ds.PropertyMap, err error) error {
+		if err == nil && !ds.GetMetaDefault(metas.GetSingle(i), ds.KeysOnlyMeta, false).(bool) {
+			d.check(keys[i], pm)
+		}
+		i++
+		return cb(pm, err)
+	})
+}
+
+func (d *checker) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	if fq.KeysOnly() || len(fq.Project()) > 0 {
+		return d.RawInterface.Run(fq, cb)
+	}
+	return d.RawInterface.Run(fq, func(k *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		d.check(k, pm)
+		return cb(k, pm, gc)
+	})
+}
@@ -0,0 +1,123 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package schemaDrift
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/mathrand"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type driftModel struct {
+	ID int64 `gae:"$id"`
+
+	Name  string
+	Count int64
+	Tags  []string
+	Note  *string
+}
+
+func init() {
+	ds.RegisterKind(&driftModel{})
+}
+
+func TestSchemaDrift(t *testing.T) {
+	t.Parallel()
+
+	Convey("schemaDrift", t, func() {
+		c := memory.Use(context.Background())
+		under := ds.Get(c)
+		under.Testable().Consistent(true)
+
+		drifts := []*Drift{}
+		sink := func(c context.Context, d *Drift) { drifts = append(drifts, d) }
+		d := ds.Get(FilterRDS(c, Options{SampleRate: 1, Sink: sink}))
+
+		put := func(id int64, pm ds.PropertyMap) {
+			pm["$key"] = []ds.Property{ds.MkPropertyNI(under.MakeKey("driftModel", id))}
+			So(under.Put(pm), ShouldBeNil)
+		}
+		put(1, ds.PropertyMap{
+			"Name":  {ds.MkProperty("a")},
+			"Count": {ds.MkProperty(1)},
+			"Note":  {ds.MkProperty(nil)},
+		})
+		put(2, ds.PropertyMap{
+			"Name":   {ds.MkProperty("b")},
+			"Count":  {ds.MkProperty("lots")},
+			"Legacy": {ds.MkProperty(true)},
+		})
+
+		Convey("doesn't report matching entities", func() {
+			So(d.Get(&driftModel{ID: 1}), ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+
+		Convey("reports drift on Get", func() {
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("driftModel", 2))}}
+			So(d.Get(&pm), ShouldBeNil)
+			So(drifts, ShouldResemble, []*Drift{{
+				Key:            d.MakeKey("driftModel", 2),
+				GoType:         "schemaDrift.driftModel",
+				Unknown:        []string{"Legacy"},
+				Missing:        []string{"Note"},
+				TypeMismatches: []TypeMismatch{{"Count", "PTInt", "PTString"}},
+			}})
+		})
+
+		Convey("reports drift in queries", func() {
+			pms := []ds.PropertyMap{}
+			So(d.GetAll(ds.NewQuery("driftModel"), &pms), ShouldBeNil)
+			So(drifts, ShouldHaveLength, 1)
+			So(drifts[0].Key.IntID(), ShouldEqual, 2)
+
+			Convey("but not keys-only ones", func() {
+				drifts = drifts[:0]
+				keys := []*ds.Key{}
+				So(d.GetAll(ds.NewQuery("driftModel").KeysOnly(true), &keys), ShouldBeNil)
+				So(drifts, ShouldBeEmpty)
+			})
+		})
+
+		Convey("ignores unregistered kinds", func() {
+			pm := ds.PropertyMap{
+				"$key": {ds.MkPropertyNI(d.MakeKey("Other", 1))},
+				"Wat":  {ds.MkProperty(1)},
+			}
+			So(d.Put(pm), ShouldBeNil)
+			So(d.Get(&pm), ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+
+		Convey("samples", func() {
+			c := mathrand.Set(c, rand.New(rand.NewSource(0)))
+			d := ds.Get(FilterRDS(c, Options{SampleRate: 0.5, Sink: sink}))
+			for i := 0; i < 100; i++ {
+				pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("driftModel", 2))}}
+				So(d.Get(&pm), ShouldBeNil)
+			}
+			So(len(drifts), ShouldBeBetween, 30, 70)
+		})
+
+		Convey("a zero SampleRate checks nothing", func() {
+			d := ds.Get(FilterRDS(c, Options{Sink: sink}))
+			pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(d.MakeKey("driftModel", 2))}}
+			So(d.Get(&pm), ShouldBeNil)
+			So(drifts, ShouldBeEmpty)
+		})
+
+		Convey("rejects SampleRates out of range", func() {
+			So(func() { FilterRDS(c, Options{SampleRate: 1.5}) }, ShouldPanic)
+			So(func() { FilterRDS(c, Options{SampleRate: -0.1}) }, ShouldPanic)
+			So(func() { FilterRDS(c, Options{SampleRate: math.NaN()}) }, ShouldPanic)
+		})
+	})
+}
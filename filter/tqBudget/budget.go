@@ -0,0 +1,173 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package tqBudget contains a taskqueue filter which caps the number of tasks
+// that may be added with a context (e.g. by a single request).
+//
+// It's meant to catch accidental O(n) fan-out bugs, e.g. in tests, before they
+// flood production queues.
+package tqBudget
+
+import (
+	"fmt"
+	"sync"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+)
+
+// ErrBudgetExceeded is returned by AddMulti when adding the tasks would exceed
+// the budget. None of the tasks are added in that case.
+type ErrBudgetExceeded struct {
+	Limit int
+	Added int
+
+	Queue  string
+	Adding int
+}
+
+func (e *ErrBudgetExceeded) Error() string {
+	return fmt.Sprintf(
+		"tqBudget: adding %d tasks to queue %q would exceed the budget of %d tasks (%d already added)",
+		e.Adding, e.Queue, e.Limit, e.Added)
+}
+
+// Budget tracks the tasks added with a context.
+type Budget struct {
+	lock  sync.Mutex
+	limit int
+	added int
+}
+
+// Limit returns the maximum number of tasks which may be added.
+func (b *Budget) Limit() int {
+	return b.limit
+}
+
+// Added returns the number of tasks added so far.
+func (b *Budget) Added() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.added
+}
+
+func (b *Budget) take(queueName string, n int) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.added+n > b.limit {
+		return &ErrBudgetExceeded{b.limit, b.added, queueName, n}
+	}
+	b.added += n
+	return nil
+}
+
+// settle gives back the tasks which were taken but not added, and records the
+// added ones in a, if it's not nil.
+func (b *Budget) settle(a *txnAttempt, taken, added int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.added -= taken - added
+	if a != nil {
+		a.added += added
+	}
+}
+
+// abandon gives back the tasks added in a, which didn't commit.
+func (b *Budget) abandon(a *txnAttempt) {
+	if a == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.added -= a.added
+	a.added = 0
+}
+
+type key int
+
+var attemptKey key
+
+// txnAttempt is an attempt of a top-level transaction. The tasks added in it
+// only stay in the budget if it commits.
+type txnAttempt struct {
+	added int
+}
+
+type tqBudget struct {
+	tq.RawInterface
+
+	c context.Context
+	b *Budget
+}
+
+var _ tq.RawInterface = (*tqBudget)(nil)
+
+func (t *tqBudget) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	if err := t.b.take(queueName, len(tasks)); err != nil {
+		return err
+	}
+	added := 0
+	err := t.RawInterface.AddMulti(tasks, queueName, func(tsk *tq.Task, err error) {
+		if err == nil {
+			added++
+		}
+		cb(tsk, err)
+	})
+	a, _ := t.c.Value(attemptKey).(*txnAttempt)
+	t.b.settle(a, len(tasks), added)
+	return err
+}
+
+// dsBudget gives back the tasks added in the attempts of transactions which
+// don't commit, so that retries aren't charged twice.
+type dsBudget struct {
+	ds.RawInterface
+
+	c context.Context
+	b *Budget
+}
+
+func (d *dsBudget) RunInTransaction(f func(context.Context) error, opts *ds.TransactionOptions) error {
+	if ds.CurrentTransaction(d.c) != nil {
+		// Nested transactions commit (or not) with the top-level one.
+		return d.RawInterface.RunInTransaction(f, opts)
+	}
+	var cur *txnAttempt
+	err := d.RawInterface.RunInTransaction(func(c context.Context) error {
+		// Being called again means the previous attempt didn't commit.
+		d.b.abandon(cur)
+		cur = &txnAttempt{}
+		return f(context.WithValue(c, attemptKey, cur))
+	}, opts)
+	if err != nil {
+		d.b.abandon(cur)
+	}
+	return err
+}
+
+// FilterTQ installs a taskqueue filter in the context which allows at most
+// limit tasks to be added with it (including in transactions). Once the limit
+// would be exceeded, AddMulti fails with an *ErrBudgetExceeded.
+//
+// Only the tasks which are actually added count: those which fail to be added,
+// and those added in attempts of transactions which don't commit, are given
+// back to the budget. For the latter, it also installs a datastore filter.
+func FilterTQ(c context.Context, limit int) (context.Context, *Budget) {
+	b := &Budget{limit: limit}
+	c = ds.AddRawFilters(c, func(ic context.Context, rds ds.RawInterface) ds.RawInterface {
+		if filter.IsDisabled(ic, filter.TQBudget) {
+			return rds
+		}
+		return &dsBudget{rds, ic, b}
+	})
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		if filter.IsDisabled(ic, filter.TQBudget) {
+			return rtq
+		}
+		return &tqBudget{rtq, ic, b}
+	}), b
+}
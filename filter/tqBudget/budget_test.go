@@ -0,0 +1,78 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package tqBudget
+
+import (
+	"testing"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestBudget(t *testing.T) {
+	t.Parallel()
+
+	Convey("tqBudget", t, func() {
+		c, b := FilterTQ(memory.Use(context.Background()), 3)
+		q := tq.Get(c)
+		So(b.Limit(), ShouldEqual, 3)
+
+		So(q.AddMulti([]*tq.Task{q.NewTask(""), q.NewTask("")}, ""), ShouldBeNil)
+		So(b.Added(), ShouldEqual, 2)
+
+		Convey("fails when the budget would be exceeded", func() {
+			err := q.AddMulti([]*tq.Task{q.NewTask(""), q.NewTask("")}, "")
+			So(err, ShouldErrLike, `adding 2 tasks to queue "" would exceed the budget of 3 tasks (2 already added)`)
+			So(err, ShouldHaveSameTypeAs, &ErrBudgetExceeded{})
+			So(b.Added(), ShouldEqual, 2)
+			So(len(q.Testable().GetScheduledTasks()["default"]), ShouldEqual, 2)
+
+			So(q.Add(q.NewTask(""), ""), ShouldBeNil)
+			So(q.Add(q.NewTask(""), ""), ShouldErrLike, "would exceed the budget")
+		})
+
+		Convey("only counts tasks which are added", func() {
+			bad := q.NewTask("")
+			bad.Method = "BOGUS"
+			So(q.Add(bad, ""), ShouldErrLike, "bad method")
+			So(q.Add(q.NewTask(""), "nope"), ShouldErrLike, "UNKNOWN_QUEUE")
+			So(b.Added(), ShouldEqual, 2)
+		})
+
+		Convey("counts tasks added in transactions", func() {
+			So(ds.Get(c).RunInTransaction(func(c context.Context) error {
+				q := tq.Get(c)
+				return q.Add(q.NewTask(""), "")
+			}, nil), ShouldBeNil)
+			So(b.Added(), ShouldEqual, 3)
+		})
+
+		Convey("but not those of transactions which don't commit", func() {
+			So(ds.Get(c).RunInTransaction(func(c context.Context) error {
+				q := tq.Get(c)
+				So(q.Add(q.NewTask(""), ""), ShouldBeNil)
+				return q.Add(q.NewTask(""), "")
+			}, nil), ShouldErrLike, "would exceed the budget")
+			So(b.Added(), ShouldEqual, 2)
+		})
+
+		Convey("or of retried attempts", func() {
+			ds.GetRaw(c).Testable().SetTransactionRetryCount(1)
+			attempts := 0
+			So(ds.Get(c).RunInTransaction(func(c context.Context) error {
+				attempts++
+				q := tq.Get(c)
+				return q.Add(q.NewTask(""), "")
+			}, nil), ShouldBeNil)
+			So(attempts, ShouldEqual, 2)
+			So(b.Added(), ShouldEqual, 3)
+			So(len(q.Testable().GetScheduledTasks()["default"]), ShouldEqual, 3)
+		})
+	})
+}
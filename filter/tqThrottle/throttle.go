@@ -0,0 +1,89 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package tqThrottle contains a taskqueue filter which defers new tasks when
+// the backlog of their queue is too long, to protect the services which the
+// tasks talk to from a sudden burst of work.
+package tqThrottle
+
+import (
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/filter"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"github.com/luci/luci-go/common/clock"
+	"github.com/luci/luci-go/common/mathrand"
+)
+
+// Options controls when and how the filter defers tasks.
+type Options struct {
+	// Threshold is the number of tasks (as reported by Stats) above which the
+	// tasks added to a queue are deferred.
+	Threshold int
+
+	// Delay is added to the ETA of deferred tasks.
+	Delay time.Duration
+
+	// Spread additionally offsets the ETA of each deferred task by a random
+	// duration within Spread.Window. Spread.Start is ignored.
+	Spread tq.Spread
+}
+
+type tqThrottle struct {
+	tq.RawInterface
+
+	c context.Context
+	o *Options
+}
+
+var _ tq.RawInterface = (*tqThrottle)(nil)
+
+func (t *tqThrottle) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
+	if len(tasks) > 0 && t.overThreshold(queueName) {
+		now := clock.Now(t.c)
+		r := mathrand.Get(t.c)
+
+		deferred := make([]*tq.Task, len(tasks))
+		for i, tsk := range tasks {
+			tsk = tsk.Duplicate()
+			if tsk.ETA.IsZero() {
+				tsk.ETA = now.Add(tsk.Delay)
+				tsk.Delay = 0
+			}
+			tsk.ETA = tsk.ETA.Add(t.o.Delay + t.o.Spread.Offset(r))
+			deferred[i] = tsk
+		}
+		tasks = deferred
+	}
+	return t.RawInterface.AddMulti(tasks, queueName, cb)
+}
+
+// overThreshold returns true if the queue has more than Threshold tasks. If the
+// statistics aren't available (e.g. in a transaction), it returns false.
+func (t *tqThrottle) overThreshold(queueName string) bool {
+	backlog := -1
+	err := t.RawInterface.Stats([]string{queueName}, func(s *tq.Statistics, err error) {
+		if err == nil {
+			backlog = s.Tasks
+		}
+	})
+	return err == nil && backlog > t.o.Threshold
+}
+
+// FilterTQ installs a taskqueue filter in the context which defers the tasks
+// added to a queue while its backlog is above o.Threshold tasks. It returns an
+// error, and leaves the context unchanged, if o.Spread isn't valid.
+func FilterTQ(c context.Context, o Options) (context.Context, error) {
+	if err := o.Spread.Validate(); err != nil {
+		return c, err
+	}
+	return tq.AddRawFilters(c, func(ic context.Context, rtq tq.RawInterface) tq.RawInterface {
+		if filter.IsDisabled(ic, filter.TQThrottle) {
+			return rtq
+		}
+		return &tqThrottle{rtq, ic, &o}
+	}), nil
+}
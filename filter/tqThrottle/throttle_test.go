@@ -0,0 +1,73 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package tqThrottle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/filter"
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestThrottle(t *testing.T) {
+	t.Parallel()
+
+	Convey("tqThrottle", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 1, time.UTC)
+		c, _ := testclock.UseTime(context.Background(), now)
+		c, err := FilterTQ(memory.Use(c), Options{
+			Threshold: 2,
+			Delay:     time.Minute,
+			Spread:    tq.Spread{Window: 10 * time.Second},
+		})
+		So(err, ShouldBeNil)
+		q := tq.Get(c)
+
+		Convey("rejects an invalid Spread", func() {
+			_, err := FilterTQ(c, Options{Spread: tq.Spread{Distribution: 100}})
+			So(err.Error(), ShouldContainSubstring, "unknown distribution")
+		})
+
+		Convey("adds tasks as-is below the threshold", func() {
+			tasks := []*tq.Task{q.NewTask(""), q.NewTask(""), q.NewTask("")}
+			tasks[1].Delay = time.Second
+			So(q.AddMulti(tasks, ""), ShouldBeNil)
+			So(tasks[0].ETA, ShouldResemble, now)
+			So(tasks[1].ETA, ShouldResemble, now.Add(time.Second))
+			So(tasks[2].ETA, ShouldResemble, now)
+
+			Convey("and defers them above it", func() {
+				tasks := []*tq.Task{q.NewTask(""), q.NewTask("")}
+				tasks[1].ETA = now.Add(time.Hour)
+				So(q.AddMulti(tasks, ""), ShouldBeNil)
+				So(tasks[0].ETA, ShouldHappenOnOrBetween, now.Add(time.Minute), now.Add(time.Minute+10*time.Second))
+				So(tasks[1].ETA, ShouldHappenOnOrBetween, now.Add(time.Hour+time.Minute), now.Add(time.Hour+time.Minute+10*time.Second))
+				So(len(q.Testable().GetScheduledTasks()["default"]), ShouldEqual, 5)
+			})
+
+			Convey("unless the filter is disabled", func() {
+				q := tq.Get(filter.Disable(c, filter.TQThrottle))
+				tsk := q.NewTask("")
+				So(q.Add(tsk, ""), ShouldBeNil)
+				So(tsk.ETA, ShouldResemble, now)
+			})
+
+			Convey("but not in transactions", func() {
+				So(ds.Get(c).RunInTransaction(func(c context.Context) error {
+					tsk := tq.Get(c).NewTask("")
+					So(tq.Get(c).Add(tsk, ""), ShouldBeNil)
+					So(tsk.ETA, ShouldResemble, now)
+					return nil
+				}, nil), ShouldBeNil)
+			})
+		})
+	})
+}
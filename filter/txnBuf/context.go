@@ -7,6 +7,7 @@ package txnBuf
 import (
 	"golang.org/x/net/context"
 
+	"github.com/tetrafolium/gae/filter"
 	ds "github.com/tetrafolium/gae/service/datastore"
 )
 
@@ -22,14 +23,28 @@ func FilterRDS(c context.Context) context.Context {
 	// TODO(riannucci): allow the specification of the set of roots to limit this
 	// transaction to, transitively.
 	return ds.AddRawFilters(c, func(c context.Context, rds ds.RawInterface) ds.RawInterface {
-		if par, _ := c.Value(dsTxnBufParent).(*txnBufState); par != nil {
+		if par := getParentState(c); par != nil {
 			haveLock, _ := c.Value(dsTxnBufHaveLock).(bool)
 			return &dsTxnBuf{c, par, haveLock}
 		}
+		if filter.IsDisabled(c, filter.TxnBuf) {
+			return rds
+		}
 		return &dsBuf{rds}
 	})
 }
 
+// getParentState returns the state of the buffered transaction which c is in,
+// if any. Contexts returned by ds.WithoutTransaction aren't in a transaction,
+// even if they're derived from one which is.
+func getParentState(c context.Context) *txnBufState {
+	if ds.CurrentTransaction(c) == nil {
+		return nil
+	}
+	par, _ := c.Value(dsTxnBufParent).(*txnBufState)
+	return par
+}
+
 // impossible is a marker function to indicate that the given error is an
 // impossible state, due to conditions outside of the function.
 func impossible(err error) {
@@ -5,6 +5,9 @@
 package txnBuf
 
 import (
+	"fmt"
+	"strings"
+
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/luci/luci-go/common/errors"
 	"golang.org/x/net/context"
@@ -15,10 +18,30 @@ import (
 var ErrTransactionTooLarge = errors.New(
 	"applying the transaction would make the parent transaction too large")
 
-// ErrTooManyRoots is returned when executing an operation which would cause
+// TooManyRootsError is returned when executing an operation which would cause
 // the transaction to exceed it's allotted number of entity groups.
-var ErrTooManyRoots = errors.New(
-	"operating on too many entity groups in nested transaction")
+type TooManyRootsError struct {
+	// Roots are the root keys of the entity groups which the transaction already
+	// operates on, sorted.
+	Roots []*ds.Key
+
+	// Key is the first key of the operation which isn't in one of Roots' entity
+	// groups, and would exceed Limit.
+	Key *ds.Key
+
+	// Limit is the number of entity groups which the transaction may operate on.
+	Limit int
+}
+
+func (e *TooManyRootsError) Error() string {
+	roots := make([]string, len(e.Roots))
+	for i, r := range e.Roots {
+		roots[i] = r.String()
+	}
+	return fmt.Sprintf(
+		"operating on too many entity groups in nested transaction: %s would exceed the limit of %d, with %s",
+		e.Key, e.Limit, strings.Join(roots, ", "))
+}
 
 type dsTxnBuf struct {
 	ic       context.Context
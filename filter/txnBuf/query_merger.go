@@ -72,7 +72,7 @@ func adjustQuery(fq *ds.FinalizedQuery) (*ds.FinalizedQuery, error) {
 	// to push back to the datastore "yeah, I know you told me that the (1, 2)
 	// result came from `/Bob,1`, but would you mind pretending that it didn't
 	// and tell me next the one instead?
-	q = q.Distinct(false)
+	q = q.Distinct(false).DistinctOn()
 
 	// since we need to merge results, we must have all order-related fields
 	// in each result. The only time we wouldn't have all the data available would
@@ -141,6 +141,13 @@ func runMergedQueries(fq *ds.FinalizedQuery, sizes *sizeTracker,
 			for i, p := range proj {
 				distinctOrder[i].Property = p
 			}
+		} else if on := fq.DistinctOn(); len(on) > 0 {
+			// likewise, but only by the distinct-on fields.
+			distinct = stringset.New(0)
+			distinctOrder = make([]ds.IndexColumn, len(on))
+			for i, p := range on {
+				distinctOrder[i].Property = p
+			}
 		}
 	} else {
 		// the original was a normal or keys-only query, so we need to dedup by keys.
@@ -269,12 +276,18 @@ func toComparableString(start, end []byte, order []ds.IndexColumn, k *ds.Key, pm
 				row = serialize.PropertySlice(vals)
 			}
 		}
+		// A descending column's first index entry is for its largest value, so
+		// invert the values before sorting them.
+		if ord.Descending {
+			inverted := make(serialize.SerializedPslice, len(row))
+			for i, serialized := range row {
+				inverted[i] = serialize.Invert(serialized)
+			}
+			row = inverted
+		}
 		sort.Sort(row)
 		foundOne := false
 		for _, serialized := range row {
-			if ord.Descending {
-				serialized = serialize.Invert(serialized)
-			}
 			if doCmp {
 				maybe := serialize.Join(soFar, serialized)
 				cmp := bytes.Compare(maybe, start)
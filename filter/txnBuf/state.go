@@ -6,6 +6,7 @@ package txnBuf
 
 import (
 	"bytes"
+	"sort"
 	"sync"
 
 	"github.com/tetrafolium/gae/impl/memory"
@@ -43,8 +44,8 @@ const XGTransactionGroupLimit = 25
 
 // sizeTracker tracks the size of a buffered transaction. The rules are simple:
 //   * deletes count for the size of their key, but 0 data
-//   * puts count for the size of their key plus the 'EstimateSize' for their
-//     data.
+//   * puts count for the size of their key plus the 'EstimateCost' size for
+//     their data (including its index rows).
 type sizeTracker struct {
 	keyToSize map[string]int64
 	total     int64
@@ -125,7 +126,7 @@ func withTxnBuf(ctx context.Context, cb func(context.Context) error, opts *datas
 	inf := info.Get(ctx)
 	ns := inf.GetNamespace()
 
-	parentState, _ := ctx.Value(dsTxnBufParent).(*txnBufState)
+	parentState := getParentState(ctx)
 	roots := stringset.New(0)
 	rootLimit := 1
 	if opts != nil && opts.XG {
@@ -218,17 +219,15 @@ func (i *item) getCmpRow(lower, upper []byte, order []datastore.IndexColumn) str
 	return i.cmpRow
 }
 
-func (t *txnBufState) updateRootsLocked(roots stringset.Set) error {
-	curRootLen := t.roots.Len()
+func (t *txnBufState) updateRootsLocked(keys []*datastore.Key, roots []string) error {
 	proposedRoots := stringset.New(1)
-	roots.Iter(func(root string) bool {
-		if !t.roots.Has(root) {
-			proposedRoots.Add(root)
+	for i, root := range roots {
+		if t.roots.Has(root) || !proposedRoots.Add(root) {
+			continue
+		}
+		if proposedRoots.Len()+t.roots.Len() > t.rootLimit {
+			return t.tooManyRootsLocked(keys[i])
 		}
-		return proposedRoots.Len()+curRootLen <= t.rootLimit
-	})
-	if proposedRoots.Len()+curRootLen > t.rootLimit {
-		return ErrTooManyRoots
 	}
 	// only need to update the roots if they did something that required updating
 	if proposedRoots.Len() > 0 {
@@ -240,6 +239,21 @@ func (t *txnBufState) updateRootsLocked(roots stringset.Set) error {
 	return nil
 }
 
+// tooManyRootsLocked returns the TooManyRootsError for key, whose entity group
+// couldn't be added to the transaction.
+func (t *txnBufState) tooManyRootsLocked(key *datastore.Key) error {
+	ret := &TooManyRootsError{Key: key, Limit: t.rootLimit}
+	t.roots.Iter(func(root string) bool {
+		k, err := serialize.ReadKey(bytes.NewBufferString(root), serialize.WithoutContext,
+			key.AppID(), key.Namespace())
+		memoryCorruption(err)
+		ret.Roots = append(ret.Roots, k)
+		return true
+	})
+	sort.Sort(keySlice(ret.Roots))
+	return ret
+}
+
 func (t *txnBufState) getMulti(keys []*datastore.Key, metas datastore.MultiMetaGetter, cb datastore.GetMultiCB, haveLock bool) error {
 	encKeys, roots := toEncoded(keys)
 	data := make([]item, len(keys))
@@ -254,7 +268,7 @@ func (t *txnBufState) getMulti(keys []*datastore.Key, metas datastore.MultiMetaG
 			defer t.Unlock()
 		}
 
-		if err := t.updateRootsLocked(roots); err != nil {
+		if err := t.updateRootsLocked(keys, roots); err != nil {
 			return err
 		}
 
@@ -336,7 +350,7 @@ func (t *txnBufState) deleteMulti(keys []*datastore.Key, cb datastore.DeleteMult
 			defer t.Unlock()
 		}
 
-		if err := t.updateRootsLocked(roots); err != nil {
+		if err := t.updateRootsLocked(keys, roots); err != nil {
 			return err
 		}
 
@@ -405,14 +419,14 @@ func (t *txnBufState) putMulti(keys []*datastore.Key, vals []datastore.PropertyM
 			defer t.Unlock()
 		}
 
-		if err := t.updateRootsLocked(roots); err != nil {
+		if err := t.updateRootsLocked(keys, roots); err != nil {
 			return err
 		}
 
 		i := 0
 		err := t.bufDS.PutMulti(keys, vals, func(k *datastore.Key, err error) error {
 			impossible(err)
-			t.entState.set(encKeys[i], vals[i].EstimateSize())
+			t.entState.set(encKeys[i], vals[i].EstimateCost(keys[i]).Size())
 			i++
 			return nil
 		})
@@ -534,13 +548,19 @@ func (t *txnBufState) commitLocked(s *txnBufState) {
 }
 
 // toEncoded returns a list of all of the serialized versions of these keys,
-// plus a stringset of all the encoded root keys that `keys` represents.
-func toEncoded(keys []*datastore.Key) (full []string, roots stringset.Set) {
-	roots = stringset.New(len(keys))
+// plus the serialized versions of their root keys.
+func toEncoded(keys []*datastore.Key) (full, roots []string) {
+	roots = make([]string, len(keys))
 	full = make([]string, len(keys))
 	for i, k := range keys {
-		roots.Add(string(serialize.ToBytes(k.Root())))
+		roots[i] = string(serialize.ToBytes(k.Root()))
 		full[i] = string(serialize.ToBytes(k))
 	}
 	return
 }
+
+type keySlice []*datastore.Key
+
+func (s keySlice) Len() int           { return len(s) }
+func (s keySlice) Less(i, j int) bool { return s[i].Less(s[j]) }
+func (s keySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -10,6 +10,7 @@ import (
 	"math/rand"
 	"testing"
 
+	"github.com/tetrafolium/gae/filter"
 	"github.com/tetrafolium/gae/filter/count"
 	"github.com/tetrafolium/gae/impl/memory"
 	"github.com/tetrafolium/gae/service/datastore"
@@ -275,6 +276,32 @@ func TestTransactionBuffers(t *testing.T) {
 				So(k.IntID(), fooShouldHave(ds), nums)
 			})
 
+			Convey("describes nested transactions", func() {
+				outers := []*datastore.Transaction{}
+				So(ds.RunInTransaction(func(c context.Context) error {
+					outer := datastore.CurrentTransaction(c)
+					outers = append(outers, outer)
+					So(outer.Depth(), ShouldEqual, 1)
+					So(outer.Parent(), ShouldBeNil)
+					So(outer.Options().XG, ShouldBeTrue)
+
+					return datastore.Get(c).RunInTransaction(func(c context.Context) error {
+						inner := datastore.CurrentTransaction(c)
+						So(inner.Depth(), ShouldEqual, 2)
+						So(inner.Parent(), ShouldEqual, outer)
+						So(inner.Attempt(), ShouldEqual, 1)
+						So(inner.Options().XG, ShouldBeFalse)
+						return datastore.Get(c).Put(&Foo{ID: 1, Value: []int64{1}})
+					}, nil)
+				}, &datastore.TransactionOptions{XG: true}), ShouldBeNil)
+
+				// The first attempt is made to fail by SetTransactionRetryCount.
+				So(len(outers), ShouldEqual, 2)
+				So(outers[0], ShouldNotEqual, outers[1])
+				So(outers[0].Attempt(), ShouldEqual, 1)
+				So(outers[1].Attempt(), ShouldEqual, 2)
+			})
+
 		})
 
 		Convey("Bad", func() {
@@ -287,9 +314,15 @@ func TestTransactionBuffers(t *testing.T) {
 					So(ds.Get(f), ShouldBeNil)
 					So(f, ShouldResemble, dataMultiRoot[6])
 
-					So(ds.RunInTransaction(func(c context.Context) error {
+					err := ds.RunInTransaction(func(c context.Context) error {
 						return datastore.Get(c).Get(&Foo{ID: 6})
-					}, nil), ShouldErrLike, "too many entity groups")
+					}, nil)
+					So(err, ShouldErrLike, "too many entity groups")
+					So(err, ShouldResemble, &TooManyRootsError{
+						Roots: []*datastore.Key{ds.KeyForObj(f)},
+						Key:   ds.KeyForObj(&Foo{ID: 6}),
+						Limit: 1,
+					})
 
 					f.Value = []int64{9}
 					So(ds.Put(f), ShouldBeNil)
@@ -313,6 +346,28 @@ func TestTransactionBuffers(t *testing.T) {
 				So(over.PutMulti.Successes(), ShouldEqual, 1)
 			})
 
+			Convey("writes without the transaction persist after it fails", func() {
+				So(ds.RunInTransaction(func(c context.Context) error {
+					So(datastore.Get(c).Put(&Foo{ID: 1, Value: []int64{1, 2, 3, 4}}), ShouldBeNil)
+
+					nc := datastore.WithoutTransaction(c)
+					So(datastore.CurrentTransaction(nc), ShouldBeNil)
+					So(datastore.Get(nc).Put(&Foo{ID: 2, Value: []int64{5}}), ShouldBeNil)
+
+					// New transactions in nc aren't nested in the buffered one.
+					So(datastore.Get(nc).RunInTransaction(func(c context.Context) error {
+						So(datastore.CurrentTransaction(c).Depth(), ShouldEqual, 1)
+						return datastore.Get(c).Put(&Foo{ID: 3, Value: []int64{6}})
+					}, nil), ShouldBeNil)
+
+					return errors.New("boop")
+				}, nil), ShouldErrLike, "boop")
+
+				So(1, fooShouldHave(ds), dataMultiRoot[0].Value)
+				So(2, fooShouldHave(ds), 5)
+				So(3, fooShouldHave(ds), 6)
+			})
+
 		})
 
 	})
@@ -908,3 +963,127 @@ func TestQuerySupport(t *testing.T) {
 	})
 
 }
+
+// withoutEntityGroups removes the __entity_group__ pseudo-entities (which
+// kindless queries return) from pms, since their versions depend on how the
+// writes were batched.
+func withoutEntityGroups(pms []datastore.PropertyMap) []datastore.PropertyMap {
+	ret := make([]datastore.PropertyMap, 0, len(pms))
+	for _, pm := range pms {
+		if k, _ := pm.GetMeta("key"); k.(*datastore.Key).Kind() != "__entity_group__" {
+			ret = append(ret, pm)
+		}
+	}
+	return ret
+}
+
+func TestRandomAncestorQueries(t *testing.T) {
+	t.Parallel()
+
+	// Each round runs the same random writes in a buffered transaction, and
+	// directly against a plain memory datastore (the oracle), and then checks
+	// that ancestor queries in the transaction return what the oracle does.
+	// The parent datastore starts out with no entities under root in some
+	// rounds, so that all of the results come from the buffer.
+	Convey("random ancestor queries in a buffered transaction match the memory datastore", t, func() {
+		idxs := []*datastore.IndexDefinition{
+			{Kind: "Foo", Ancestor: true, SortBy: []datastore.IndexColumn{{Property: "__key__", Descending: true}}},
+			{Kind: "Foo", Ancestor: true, SortBy: []datastore.IndexColumn{{Property: "Value"}}},
+			{Kind: "Foo", Ancestor: true, SortBy: []datastore.IndexColumn{{Property: "Value", Descending: true}}},
+		}
+
+		for seed := int64(0); seed < 20; seed++ {
+			rng := rand.New(rand.NewSource(seed))
+
+			mkKeys := func(ds datastore.Interface) []*datastore.Key {
+				r := ds.MakeKey("Parent", 1)
+				ret := []*datastore.Key{r}
+				for i := int64(1); i <= 4; i++ {
+					k := ds.NewKey("Foo", "", i, r)
+					ret = append(ret, k, ds.NewKey("Foo", "", i, k))
+				}
+				return ret
+			}
+			mkEnt := func(k *datastore.Key) datastore.PropertyMap {
+				pm := datastore.PropertyMap{"$key": {datastore.MkPropertyNI(k)}}
+				for i := rng.Intn(3); i > 0; i-- {
+					pm["Value"] = append(pm["Value"], datastore.MkProperty(rng.Int63n(5)))
+				}
+				return pm
+			}
+
+			c := memory.UseWithAppID(context.Background(), "something~else")
+			datastore.Get(c).Testable().AddIndexes(idxs...)
+			ds := datastore.Get(FilterRDS(c))
+			oracle, err := memory.NewDatastore("something~else", "")
+			So(err, ShouldBeNil)
+			oracle.Testable().AddIndexes(idxs...)
+
+			keys := mkKeys(ds)
+			if seed%2 == 1 {
+				initial := []datastore.PropertyMap{}
+				for _, k := range keys {
+					if rng.Intn(2) == 0 {
+						initial = append(initial, mkEnt(k))
+					}
+				}
+				So(ds.PutMulti(initial), ShouldBeNil)
+				So(oracle.PutMulti(initial), ShouldBeNil)
+			}
+
+			queries := []*datastore.Query{
+				datastore.NewQuery("").Ancestor(keys[0]),
+				datastore.NewQuery("Foo").Ancestor(keys[0]),
+				datastore.NewQuery("Foo").Ancestor(keys[0]).Order("-__key__"),
+				datastore.NewQuery("Foo").Ancestor(keys[0]).Order("Value"),
+				datastore.NewQuery("Foo").Ancestor(keys[0]).Order("-Value"),
+				datastore.NewQuery("Foo").Ancestor(keys[0]).Gt("__key__", keys[3]),
+				datastore.NewQuery("Foo").Ancestor(keys[3]),
+				datastore.NewQuery("Foo").Ancestor(keys[0]).Order("-__key__").Offset(1).Limit(3),
+			}
+
+			So(ds.RunInTransaction(func(c context.Context) error {
+				ds := datastore.Get(c)
+				for i := 0; i < 10; i++ {
+					k := keys[rng.Intn(len(keys))]
+					if rng.Intn(3) == 0 {
+						So(ds.Delete(k), ShouldBeNil)
+						So(oracle.Delete(k), ShouldBeNil)
+					} else {
+						pm := mkEnt(k)
+						So(ds.Put(pm), ShouldBeNil)
+						So(oracle.Put(pm), ShouldBeNil)
+					}
+				}
+
+				for _, q := range queries {
+					for _, keysOnly := range []bool{false, true} {
+						q := q.KeysOnly(keysOnly)
+						got, expect := []datastore.PropertyMap{}, []datastore.PropertyMap{}
+						So(ds.GetAll(q, &got), ShouldBeNil)
+						So(oracle.GetAll(q, &expect), ShouldBeNil)
+						So(withoutEntityGroups(got), ShouldResemble, withoutEntityGroups(expect))
+					}
+				}
+				return nil
+			}, nil), ShouldBeNil)
+		}
+	})
+}
+
+func TestDisable(t *testing.T) {
+	t.Parallel()
+
+	Convey("disabled transactions aren't buffered", t, func() {
+		c := FilterRDS(memory.Use(context.Background()))
+		nested := func(c context.Context) error {
+			return datastore.Get(c).RunInTransaction(func(c context.Context) error {
+				return datastore.Get(c).RunInTransaction(func(context.Context) error {
+					return nil
+				}, nil)
+			}, nil)
+		}
+		So(nested(c), ShouldBeNil)
+		So(nested(filter.Disable(c, filter.TxnBuf)), ShouldErrLike, "nested transactions are not supported")
+	})
+}
@@ -0,0 +1,299 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package conformance
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+	mc "github.com/tetrafolium/gae/service/memcache"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"golang.org/x/net/context"
+)
+
+// Cases is the default suite.
+var Cases = []Case{
+	{"datastore/round-trip", dsRoundTrip},
+	{"datastore/get-missing", dsGetMissing},
+	{"datastore/mixed-type-order", dsMixedTypeOrder},
+	{"datastore/inequality-order", dsInequalityOrder},
+	{"datastore/keys-only-count", dsKeysOnlyCount},
+	{"datastore/transaction-ancestor", dsTransactionAncestor},
+	{"datastore/namespace-isolation", dsNamespaceIsolation},
+	{"memcache/add-existing", mcAddExisting},
+	{"memcache/compare-and-swap", mcCompareAndSwap},
+	{"memcache/increment", mcIncrement},
+	{"taskqueue/named-task-twice", tqNamedTaskTwice},
+}
+
+// keyPath renders k without its app ID and namespace, which differ between
+// implementations.
+func keyPath(k *ds.Key) string {
+	if k == nil {
+		return ""
+	}
+	_, _, toks := k.Split()
+	parts := make([]string, len(toks))
+	for i, t := range toks {
+		if t.StringID != "" {
+			parts[i] = fmt.Sprintf("%s,%q", t.Kind, t.StringID)
+		} else {
+			parts[i] = fmt.Sprintf("%s,%d", t.Kind, t.IntID)
+		}
+	}
+	return "/" + strings.Join(parts, "/")
+}
+
+// queryPaths returns the keyPaths of the keys which q returns.
+func queryPaths(d ds.Interface, q *ds.Query) ([]string, error) {
+	ret := []string{}
+	err := d.Run(q.KeysOnly(true), func(k *ds.Key) {
+		ret = append(ret, keyPath(k))
+	})
+	return ret, err
+}
+
+type roundTrip struct {
+	ID int64 `gae:"$id"`
+
+	Int    int64
+	Float  float64
+	Bool   bool
+	Str    string
+	Bytes  []byte `gae:",noindex"`
+	Time   time.Time
+	Geo    ds.GeoPoint
+	Key    *ds.Key
+	Ints   []int64
+	NilKey *ds.Key
+}
+
+func dsRoundTrip(c context.Context) (interface{}, error) {
+	d := ds.Get(c)
+	in := &roundTrip{
+		ID:    1,
+		Int:   -7,
+		Float: 1.5,
+		Bool:  true,
+		Str:   "hello",
+		Bytes: []byte{0, 1, 2},
+		// Datastore times have microsecond precision.
+		Time: time.Date(2015, time.March, 4, 5, 6, 7, 8000, time.UTC),
+		Geo:  ds.GeoPoint{Lat: 1.25, Lng: -2.5},
+		Key:  d.MakeKey("Parent", "p", "Child", 2),
+		Ints: []int64{3, 1, 2},
+	}
+	if err := d.Put(in); err != nil {
+		return nil, err
+	}
+	out := &roundTrip{ID: 1}
+	if err := d.Get(out); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"Int":    out.Int,
+		"Float":  out.Float,
+		"Bool":   out.Bool,
+		"Str":    out.Str,
+		"Bytes":  out.Bytes,
+		"Time":   out.Time.UTC(),
+		"Geo":    out.Geo,
+		"Key":    keyPath(out.Key),
+		"Ints":   out.Ints,
+		"NilKey": out.NilKey == nil,
+	}, nil
+}
+
+func dsGetMissing(c context.Context) (interface{}, error) {
+	pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(ds.Get(c).MakeKey("Missing", 1))}}
+	err := ds.Get(c).Get(&pm)
+	return map[string]bool{"ErrNoSuchEntity": err == ds.ErrNoSuchEntity}, nil
+}
+
+func dsMixedTypeOrder(c context.Context) (interface{}, error) {
+	d := ds.Get(c)
+	vals := []interface{}{
+		"str", int64(10), true, 2.5, []byte("bytes"), nil,
+		ds.GeoPoint{Lat: 1, Lng: 1}, d.MakeKey("Other", 1), int64(-1), false,
+	}
+	pms := make([]ds.PropertyMap, len(vals))
+	for i, v := range vals {
+		pms[i] = ds.PropertyMap{
+			"$key": {ds.MkPropertyNI(d.MakeKey("Mixed", i+1))},
+			"V":    {ds.MkProperty(v)},
+		}
+	}
+	if err := d.PutMulti(pms); err != nil {
+		return nil, err
+	}
+	return queryPaths(d, ds.NewQuery("Mixed").Order("V"))
+}
+
+func dsInequalityOrder(c context.Context) (interface{}, error) {
+	d := ds.Get(c)
+	pms := make([]ds.PropertyMap, 6)
+	for i := range pms {
+		pms[i] = ds.PropertyMap{
+			"$key": {ds.MkPropertyNI(d.MakeKey("Ineq", i+1))},
+			"V":    {ds.MkProperty(int64(i % 3))},
+			"W":    {ds.MkProperty(int64(i))},
+		}
+	}
+	if err := d.PutMulti(pms); err != nil {
+		return nil, err
+	}
+	return queryPaths(d, ds.NewQuery("Ineq").Gt("V", 0).Order("-V", "W"))
+}
+
+func dsKeysOnlyCount(c context.Context) (interface{}, error) {
+	d := ds.Get(c)
+	pms := make([]ds.PropertyMap, 5)
+	for i := range pms {
+		pms[i] = ds.PropertyMap{
+			"$key": {ds.MkPropertyNI(d.MakeKey("Counted", i+1))},
+			"Even": {ds.MkProperty(i%2 == 0)},
+		}
+	}
+	if err := d.PutMulti(pms); err != nil {
+		return nil, err
+	}
+	q := ds.NewQuery("Counted").Eq("Even", true)
+	all, err := d.Count(q)
+	if err != nil {
+		return nil, err
+	}
+	limited, err := d.Count(q.Limit(2))
+	if err != nil {
+		return nil, err
+	}
+	paths, err := queryPaths(d, q)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"Count": all, "Limited": limited, "Keys": paths}, nil
+}
+
+func dsTransactionAncestor(c context.Context) (interface{}, error) {
+	parent := ds.Get(c).MakeKey("Group", "g")
+	seen := []string(nil)
+	err := ds.Get(c).RunInTransaction(func(c context.Context) error {
+		d := ds.Get(c)
+		pm := ds.PropertyMap{
+			"$key": {ds.MkPropertyNI(d.NewKey("Member", "", 1, parent))},
+			"V":    {ds.MkProperty(int64(1))},
+		}
+		if err := d.Put(pm); err != nil {
+			return err
+		}
+		// Queries in a transaction don't see its own writes.
+		var err error
+		seen, err = queryPaths(d, ds.NewQuery("Member").Ancestor(parent))
+		return err
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	after, err := queryPaths(ds.Get(c), ds.NewQuery("Member").Ancestor(parent))
+	if err != nil {
+		return nil, err
+	}
+	return map[string][]string{"InTransaction": seen, "After": after}, nil
+}
+
+func dsNamespaceIsolation(c context.Context) (interface{}, error) {
+	other, err := info.Get(c).Namespace(info.Get(c).GetNamespace() + "-other")
+	if err != nil {
+		return nil, err
+	}
+	pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(ds.Get(c).MakeKey("Isolated", 1))}}
+	if err := ds.Get(c).Put(pm); err != nil {
+		return nil, err
+	}
+	here, err := ds.Get(c).Exists(ds.Get(c).MakeKey("Isolated", 1))
+	if err != nil {
+		return nil, err
+	}
+	there, err := ds.Get(other).Exists(ds.Get(other).MakeKey("Isolated", 1))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]bool{"Here": here, "There": there}, nil
+}
+
+func mcAddExisting(c context.Context) (interface{}, error) {
+	m := mc.Get(c)
+	if err := m.Add(m.NewItem("k").SetValue([]byte("first"))); err != nil {
+		return nil, err
+	}
+	err := m.Add(m.NewItem("k").SetValue([]byte("second")))
+	itm, gerr := m.Get("k")
+	if gerr != nil {
+		return nil, gerr
+	}
+	return map[string]interface{}{
+		"ErrNotStored": err == mc.ErrNotStored,
+		"Value":        string(itm.Value()),
+	}, nil
+}
+
+func mcCompareAndSwap(c context.Context) (interface{}, error) {
+	m := mc.Get(c)
+	if err := m.Set(m.NewItem("k").SetValue([]byte("v1"))); err != nil {
+		return nil, err
+	}
+	a, err := m.Get("k")
+	if err != nil {
+		return nil, err
+	}
+	b, err := m.Get("k")
+	if err != nil {
+		return nil, err
+	}
+	first := m.CompareAndSwap(a.SetValue([]byte("v2")))
+	second := m.CompareAndSwap(b.SetValue([]byte("v3")))
+	final, err := m.Get("k")
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"First":          first == nil,
+		"ErrCASConflict": second == mc.ErrCASConflict,
+		"Value":          string(final.Value()),
+	}, nil
+}
+
+func mcIncrement(c context.Context) (interface{}, error) {
+	m := mc.Get(c)
+	_, missing := m.IncrementExisting("n", 1)
+	first, err := m.Increment("n", 5, 10)
+	if err != nil {
+		return nil, err
+	}
+	second, err := m.Increment("n", -20, 10)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"ErrCacheMiss": missing == mc.ErrCacheMiss,
+		"First":        first,
+		"Second":       second,
+	}, nil
+}
+
+func tqNamedTaskTwice(c context.Context) (interface{}, error) {
+	q := tq.Get(c)
+	t := q.NewTask("/conformance")
+	t.Name = "named-task"
+	if err := q.Add(t, ""); err != nil {
+		return nil, err
+	}
+	t = q.NewTask("/conformance")
+	t.Name = "named-task"
+	err := q.Add(t, "")
+	return map[string]bool{"ErrTaskAlreadyAdded": err == tq.ErrTaskAlreadyAdded}, nil
+}
@@ -0,0 +1,124 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package conformance is a suite of observable service behaviors which can be
+// run against several implementations (e.g. impl/memory and impl/prod backed
+// by aetest), to produce a parity report of where they differ.
+//
+// The report is JSON, so that it can be archived and compared with the report
+// of a previous release, to catch fidelity regressions in impl/memory:
+//
+//   rep := conformance.NewReport(map[string][]conformance.Result{
+//     "memory": conformance.Run(newMemoryContext, conformance.Cases),
+//     "prod":   conformance.Run(newAETestContext, conformance.Cases),
+//   })
+//   err := rep.WriteJSON(w)
+//   ...
+//   regressed := rep.Regressions(previous)
+package conformance
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/info"
+	"golang.org/x/net/context"
+)
+
+// Case is a single behavior in the suite.
+type Case struct {
+	// Name identifies the case in reports. It must be unique within a suite.
+	Name string
+
+	// Run exercises the behavior in c, and returns what it observed. The result
+	// is compared between implementations in its JSON form, so it must not
+	// contain anything implementation-specific, such as app IDs. Errors are
+	// only compared by their presence (see Outcome.Equal), so a case which
+	// expects a particular error should check for it, and report it in its
+	// result.
+	//
+	// c is in a namespace which is only used by this case.
+	Run func(c context.Context) (interface{}, error)
+}
+
+// Outcome is the observed behavior of a Case on one implementation.
+type Outcome struct {
+	// Output is the JSON encoding of the value returned by Case.Run.
+	Output string `json:"output,omitempty"`
+
+	// Error is the error returned by Case.Run (or its panic), if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Equal returns true iff o and other are the same behavior. Errors are only
+// compared by their presence, since their messages generally differ between
+// implementations.
+func (o Outcome) Equal(other Outcome) bool {
+	return o.Output == other.Output && (o.Error == "") == (other.Error == "")
+}
+
+// Result is the Outcome of a Case.
+type Result struct {
+	Case string `json:"case"`
+	Outcome
+}
+
+// Run runs cases, each in a new context from newContext.
+func Run(newContext func() (context.Context, error), cases []Case) []Result {
+	ret := make([]Result, len(cases))
+	for i, cs := range cases {
+		ret[i] = Result{cs.Name, runCase(newContext, cs)}
+	}
+	return ret
+}
+
+func runCase(newContext func() (context.Context, error), cs Case) (o Outcome) {
+	defer func() {
+		if r := recover(); r != nil {
+			o = Outcome{Error: fmt.Sprintf("panic: %v", r)}
+		}
+	}()
+
+	c, err := newContext()
+	if err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	if c, err = info.Get(c).Namespace(caseNamespace(cs.Name)); err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	val, err := cs.Run(c)
+	if err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return Outcome{Error: err.Error()}
+	}
+	return Outcome{Output: string(data)}
+}
+
+// NewMemoryContext returns a new impl/memory context, configured to behave
+// like a strongly consistent datastore with automatic indexes (like aetest's
+// dev_appserver).
+func NewMemoryContext() (context.Context, error) {
+	c := memory.Use(context.Background())
+	t := ds.Get(c).Testable()
+	t.Consistent(true)
+	t.AutoIndex(true)
+	return c, nil
+}
+
+// caseNamespace returns the namespace which the case name runs in.
+func caseNamespace(name string) string {
+	return "conformance-" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package conformance
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+func TestConformance(t *testing.T) {
+	t.Parallel()
+
+	Convey("Run", t, func() {
+		Convey("runs the default suite against memory", func() {
+			for _, r := range Run(NewMemoryContext, Cases) {
+				So(r.Error, ShouldEqual, "")
+				So(r.Output, ShouldNotEqual, "")
+			}
+		})
+
+		Convey("catches panics and errors", func() {
+			rs := Run(NewMemoryContext, []Case{
+				{"panics", func(context.Context) (interface{}, error) { panic("boom") }},
+				{"fails", func(context.Context) (interface{}, error) { return nil, errors.New("bad") }},
+			})
+			So(rs, ShouldResemble, []Result{
+				{"panics", Outcome{Error: "panic: boom"}},
+				{"fails", Outcome{Error: "bad"}},
+			})
+		})
+	})
+
+	Convey("Report", t, func() {
+		rep := NewReport(map[string][]Result{
+			"memory": {
+				{"same", Outcome{Output: "1"}},
+				{"error", Outcome{Error: "memory error"}},
+				{"different", Outcome{Output: "1"}},
+				{"missing", Outcome{Output: "1"}},
+			},
+			"prod": {
+				{"same", Outcome{Output: "1"}},
+				{"error", Outcome{Error: "prod error"}},
+				{"different", Outcome{Output: "2"}},
+			},
+		})
+		So(rep.Impls, ShouldResemble, []string{"memory", "prod"})
+		So(rep.Matching, ShouldEqual, 2)
+		So(rep.Mismatches(), ShouldResemble, []string{"different", "missing"})
+
+		Convey("round-trips through JSON", func() {
+			buf := &bytes.Buffer{}
+			So(rep.WriteJSON(buf), ShouldBeNil)
+			back, err := ReadReport(buf)
+			So(err, ShouldBeNil)
+			So(back, ShouldResemble, rep)
+		})
+
+		Convey("finds regressions", func() {
+			prev := NewReport(map[string][]Result{
+				"memory": {{"same", Outcome{Output: "1"}}, {"different", Outcome{Output: "1"}}},
+				"prod":   {{"same", Outcome{Output: "1"}}, {"different", Outcome{Output: "1"}}},
+			})
+			So(rep.Regressions(prev), ShouldResemble, []string{"different"})
+			So(prev.Regressions(rep), ShouldBeNil)
+		})
+	})
+}
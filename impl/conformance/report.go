@@ -0,0 +1,117 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package conformance
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+)
+
+// Report is the parity report of several implementations.
+type Report struct {
+	// Impls are the names of the implementations, sorted.
+	Impls []string `json:"impls"`
+
+	// Cases are in the order in which they were run.
+	Cases []CaseReport `json:"cases"`
+
+	// Matching is the number of Cases whose Outcomes all match.
+	Matching int `json:"matching"`
+}
+
+// CaseReport is the Outcomes of a single Case.
+type CaseReport struct {
+	Case string `json:"case"`
+
+	// Outcomes are by implementation name.
+	Outcomes map[string]Outcome `json:"outcomes"`
+
+	// Match is true iff all implementations had Equal outcomes.
+	Match bool `json:"match"`
+}
+
+// NewReport compares the results of each implementation (by name). The cases
+// are taken from the first implementation (by name); a case which another
+// implementation didn't run doesn't match.
+func NewReport(results map[string][]Result) *Report {
+	ret := &Report{}
+	for impl := range results {
+		ret.Impls = append(ret.Impls, impl)
+	}
+	sort.Strings(ret.Impls)
+	if len(ret.Impls) == 0 {
+		return ret
+	}
+
+	byCase := make(map[string]map[string]Outcome, len(results[ret.Impls[0]]))
+	for impl, rs := range results {
+		for _, r := range rs {
+			if byCase[r.Case] == nil {
+				byCase[r.Case] = make(map[string]Outcome, len(results))
+			}
+			byCase[r.Case][impl] = r.Outcome
+		}
+	}
+
+	for _, r := range results[ret.Impls[0]] {
+		outcomes := byCase[r.Case]
+		cr := CaseReport{Case: r.Case, Outcomes: outcomes, Match: len(outcomes) == len(ret.Impls)}
+		for _, o := range outcomes {
+			cr.Match = cr.Match && o.Equal(r.Outcome)
+		}
+		if cr.Match {
+			ret.Matching++
+		}
+		ret.Cases = append(ret.Cases, cr)
+	}
+	return ret
+}
+
+// Mismatches returns the names of the cases which don't match.
+func (r *Report) Mismatches() []string {
+	ret := []string(nil)
+	for _, cr := range r.Cases {
+		if !cr.Match {
+			ret = append(ret, cr.Case)
+		}
+	}
+	return ret
+}
+
+// Regressions returns the names of the cases which matched in prev, but don't
+// match in r.
+func (r *Report) Regressions(prev *Report) []string {
+	matched := make(map[string]bool, len(prev.Cases))
+	for _, cr := range prev.Cases {
+		matched[cr.Case] = cr.Match
+	}
+	ret := []string(nil)
+	for _, cr := range r.Cases {
+		if !cr.Match && matched[cr.Case] {
+			ret = append(ret, cr.Case)
+		}
+	}
+	return ret
+}
+
+// WriteJSON writes r to w as indented JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// ReadReport reads a Report written by WriteJSON.
+func ReadReport(r io.Reader) (*Report, error) {
+	ret := &Report{}
+	if err := json.NewDecoder(r).Decode(ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
@@ -7,6 +7,7 @@ package memory
 import (
 	"errors"
 	"sync"
+	"time"
 
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/luci/luci-go/common/logging/memlogger"
@@ -122,7 +123,11 @@ func UseWithAppID(c context.Context, aid string) context.Context {
 	memctx := newMemContext(aid)
 	c = context.WithValue(c, memContextKey, memctx)
 	c = context.WithValue(c, memContextNoTxnKey, memctx)
-	c = context.WithValue(c, giContextKey, &globalInfoData{appid: aid})
+	c = context.WithValue(c, giContextKey, &globalInfoData{
+		appid:  aid,
+		tokens: &tokenData{lifetime: time.Hour},
+		signer: &signer{},
+	})
 	return useMod(useMail(useUser(useTQ(useRDS(useMC(useGI(c, aid)))))))
 }
 
@@ -141,6 +146,7 @@ type memContextKeyType int
 var (
 	memContextKey      memContextKeyType
 	memContextNoTxnKey memContextKeyType = 1
+	readSnapshotKey    memContextKeyType = 2
 )
 
 // weird stuff
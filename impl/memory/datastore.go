@@ -7,7 +7,9 @@ package memory
 import (
 	"errors"
 	"fmt"
+	"io"
 
+	"github.com/luci/luci-go/common/clock"
 	"golang.org/x/net/context"
 
 	ds "github.com/tetrafolium/gae/service/datastore"
@@ -34,6 +36,9 @@ func useRDS(c context.Context) context.Context {
 
 		dsd := maybeTxnCtx.Get(memContextDSIdx)
 		if x, ok := dsd.(*dataStoreData); ok {
+			if snap, ok := ic.Value(readSnapshotKey).(*readSnapshot); ok {
+				return &snapDsImpl{x, snap, ns}
+			}
 			if needResetCtx {
 				ic = context.WithValue(ic, memContextKey, maybeTxnCtx)
 			}
@@ -82,7 +87,8 @@ func (d *dsImpl) AllocateIDs(incomplete *ds.Key, n int) (int64, error) {
 }
 
 func (d *dsImpl) PutMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
-	d.data.putMulti(keys, vals, cb)
+	d.data.putMulti(keys, vals, newCommit(clock.Now(d.c).UTC()), cb)
+	d.data.maybeCatchupIndexes(d.c)
 	return nil
 }
 
@@ -91,7 +97,8 @@ func (d *dsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMul
 }
 
 func (d *dsImpl) DeleteMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
-	d.data.delMulti(keys, cb)
+	d.data.delMulti(keys, newCommit(clock.Now(d.c).UTC()), cb)
+	d.data.maybeCatchupIndexes(d.c)
 	return nil
 }
 
@@ -100,6 +107,7 @@ func (d *dsImpl) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (d *dsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	d.data.recordUsedIndex(fq)
 	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
 	err := executeQuery(fq, d.data.aid, d.ns, false, idx, head, cb)
 	if d.data.maybeAutoIndex(err) {
@@ -110,6 +118,7 @@ func (d *dsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
 }
 
 func (d *dsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
+	d.data.recordUsedIndex(fq)
 	idx, head := d.data.getQuerySnaps(!fq.EventuallyConsistent())
 	ret, err = countQuery(fq, d.data.aid, d.ns, false, idx, head)
 	if d.data.maybeAutoIndex(err) {
@@ -133,6 +142,41 @@ func (d *dsImpl) AddIndexes(idxs ...*ds.IndexDefinition) {
 	d.data.addIndexes(d.ns, idxs)
 }
 
+func (d *dsImpl) LoadIndexYAML(r io.Reader) error {
+	idxs, err := ds.ParseIndexYAML(r)
+	if err != nil {
+		return err
+	}
+	compound := make([]*ds.IndexDefinition, 0, len(idxs))
+	for _, i := range idxs {
+		switch {
+		case i.Builtin():
+		case i.Compound():
+			compound = append(compound, i)
+		default:
+			return fmt.Errorf("memory: invalid index in index.yaml: %s", i)
+		}
+	}
+	d.AddIndexes(compound...)
+	return nil
+}
+
+func (d *dsImpl) UsedIndexes() []*ds.IndexDefinition {
+	return d.data.getUsedIndexes()
+}
+
+func (d *dsImpl) WriteUsedIndexYAML(w io.Writer) error {
+	_, err := io.WriteString(w, "indexes:\n")
+	for _, idx := range d.UsedIndexes() {
+		if err != nil {
+			return err
+		}
+		yaml, _ := idx.YAMLString() // UsedIndexes are all compound
+		_, err = fmt.Fprintf(w, "\n%s\n", yaml)
+	}
+	return err
+}
+
 func (d *dsImpl) TakeIndexSnapshot() ds.TestingSnapshot {
 	return d.data.takeSnapshot()
 }
@@ -145,6 +189,14 @@ func (d *dsImpl) CatchupIndexes() {
 	d.data.catchupIndexes()
 }
 
+func (d *dsImpl) GetIndexRows(idx *ds.IndexDefinition) [][]byte {
+	return d.data.getIndexRows(d.ns, idx)
+}
+
+func (d *dsImpl) SetIndexRows(idx *ds.IndexDefinition, rows [][]byte) {
+	d.data.setIndexRows(d.ns, idx, rows)
+}
+
 func (d *dsImpl) SetTransactionRetryCount(count int) {
 	d.data.setTxnRetry(count)
 }
@@ -153,14 +205,53 @@ func (d *dsImpl) Consistent(always bool) {
 	d.data.setConsistent(always)
 }
 
+func (d *dsImpl) SetConsistencyProbability(p float64) {
+	d.data.setConsistencyProbability(p)
+}
+
 func (d *dsImpl) AutoIndex(enable bool) {
 	d.data.setAutoIndex(enable)
 }
 
+func (d *dsImpl) RequireIndexes(require bool) {
+	d.data.setRequireIndexes(require)
+}
+
 func (d *dsImpl) DisableSpecialEntities(enabled bool) {
 	d.data.setDisableSpecialEntities(enabled)
 }
 
+func (d *dsImpl) AllowReservedKinds(allow bool) {
+	d.data.setAllowReservedKinds(allow)
+}
+
+func (d *dsImpl) ReservedKindsAllowed() bool {
+	return d.data.getAllowReservedKinds()
+}
+
+func (d *dsImpl) ReadSnapshot() context.Context {
+	idx, head := d.data.getQuerySnaps(false)
+	return context.WithValue(d.c, readSnapshotKey, &readSnapshot{idx, head})
+}
+
+func (d *dsImpl) Snapshot() ds.TestingSnapshot {
+	idx, head := d.data.getQuerySnaps(false)
+	return &readSnapshot{idx, head}
+}
+
+func (d *dsImpl) Restore(snap ds.TestingSnapshot) {
+	d.data.restore(snap.(*readSnapshot))
+}
+
+func (d *dsImpl) UpdateStatistics() {
+	d.data.updateStatistics(clock.Now(d.c).UTC())
+	d.data.maybeCatchupIndexes(d.c)
+}
+
+func (d *dsImpl) LastCommit(key *ds.Key) (ds.EntityCommit, error) {
+	return d.data.lastCommit(key)
+}
+
 func (d *dsImpl) Testable() ds.Testable {
 	return d
 }
@@ -202,6 +293,7 @@ func (d *txnDsImpl) DecodeCursor(s string) (ds.Cursor, error) {
 }
 
 func (d *txnDsImpl) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	d.data.parent.recordUsedIndex(q)
 	// note that autoIndex has no effect inside transactions. This is because
 	// the transaction guarantees a consistent view of head at the time that the
 	// transaction opens. At best, we could add the index on head, but then return
@@ -215,6 +307,7 @@ func (d *txnDsImpl) Run(q *ds.FinalizedQuery, cb ds.RawRunCB) error {
 }
 
 func (d *txnDsImpl) Count(fq *ds.FinalizedQuery) (ret int64, err error) {
+	d.data.parent.recordUsedIndex(fq)
 	return countQuery(fq, d.data.parent.aid, d.ns, true, d.data.snap, d.data.snap)
 }
 
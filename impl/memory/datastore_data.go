@@ -7,12 +7,17 @@ package memory
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"github.com/luci/gkvlite"
+	"github.com/luci/luci-go/common/clock"
 	"github.com/luci/luci-go/common/errors"
+	"github.com/luci/luci-go/common/mathrand"
 	"golang.org/x/net/context"
 )
 
@@ -31,13 +36,24 @@ type dataStoreData struct {
 	snap *memStore
 	// For testing, see SetTransactionRetryCount.
 	txnFakeRetry int
+	// For testing, see SetConsistencyProbability.
+	consistencyProbability float64
+	// The compound indexes needed by queries, keyed by their YAML. See
+	// Testable.UsedIndexes.
+	usedIndexes map[string]*ds.IndexDefinition
 	// true means that queries with insufficent indexes will pause to add them
 	// and then continue instead of failing.
 	autoIndex bool
+	// true means that queries with insufficient indexes fail even if autoIndex
+	// is true. See Testable.RequireIndexes.
+	requireIndexes bool
 	// true means that all of the __...__ keys which are normally automatically
 	// maintained will be omitted. This also means that Put with an incomplete
 	// key will become an error.
 	disableSpecialEntities bool
+	// true means that the application may write entities with reserved kinds
+	// and key names. See Testable.AllowReservedKinds.
+	allowReservedKinds bool
 }
 
 var (
@@ -79,6 +95,24 @@ func (d *dataStoreData) setConsistent(always bool) {
 	}
 }
 
+func (d *dataStoreData) setConsistencyProbability(p float64) {
+	d.Lock()
+	defer d.Unlock()
+	d.consistencyProbability = p
+}
+
+// maybeCatchupIndexes catches the indexes up after a write, with the
+// probability set by setConsistencyProbability.
+func (d *dataStoreData) maybeCatchupIndexes(c context.Context) {
+	d.rwlock.RLock()
+	p := d.consistencyProbability
+	d.rwlock.RUnlock()
+
+	if p > 0 && mathrand.Get(c).Float64() < p {
+		d.catchupIndexes()
+	}
+}
+
 func (d *dataStoreData) addIndexes(ns string, idxs []*ds.IndexDefinition) {
 	d.Lock()
 	defer d.Unlock()
@@ -91,6 +125,52 @@ func (d *dataStoreData) setAutoIndex(enable bool) {
 	d.autoIndex = enable
 }
 
+// recordUsedIndex records the compound index which fq needs, if any.
+func (d *dataStoreData) recordUsedIndex(fq *ds.FinalizedQuery) {
+	if orders := fq.Orders(); len(orders) > 0 && orders[0].Property == ds.ScatterProperty {
+		return // served by the builtin __scatter__ index
+	}
+	if isMetadataQuery(fq) {
+		return // served by the metadata pseudo-entities
+	}
+	cost, err := EstimateCost(fq)
+	if err != nil || cost.Index == nil {
+		return
+	}
+	yaml, err := cost.Index.YAMLString()
+	if err != nil {
+		return
+	}
+
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	if d.usedIndexes == nil {
+		d.usedIndexes = map[string]*ds.IndexDefinition{}
+	}
+	d.usedIndexes[yaml] = cost.Index
+}
+
+func (d *dataStoreData) getUsedIndexes() []*ds.IndexDefinition {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	yamls := make([]string, 0, len(d.usedIndexes))
+	for y := range d.usedIndexes {
+		yamls = append(yamls, y)
+	}
+	sort.Strings(yamls)
+	ret := make([]*ds.IndexDefinition, len(yamls))
+	for i, y := range yamls {
+		ret[i] = d.usedIndexes[y]
+	}
+	return ret
+}
+
+func (d *dataStoreData) setRequireIndexes(require bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.requireIndexes = require
+}
+
 func (d *dataStoreData) maybeAutoIndex(err error) bool {
 	mi, ok := err.(*ErrMissingIndex)
 	if !ok {
@@ -98,7 +178,7 @@ func (d *dataStoreData) maybeAutoIndex(err error) bool {
 	}
 
 	d.rwlock.RLock()
-	ai := d.autoIndex
+	ai := d.autoIndex && !d.requireIndexes
 	d.rwlock.RUnlock()
 
 	if !ai {
@@ -121,6 +201,18 @@ func (d *dataStoreData) getDisableSpecialEntities() bool {
 	return d.disableSpecialEntities
 }
 
+func (d *dataStoreData) setAllowReservedKinds(allow bool) {
+	d.Lock()
+	defer d.Unlock()
+	d.allowReservedKinds = allow
+}
+
+func (d *dataStoreData) getAllowReservedKinds() bool {
+	d.rwlock.RLock()
+	defer d.rwlock.RUnlock()
+	return d.allowReservedKinds
+}
+
 func (d *dataStoreData) getQuerySnaps(consistent bool) (idx, head *memStore) {
 	d.rwlock.RLock()
 	defer d.rwlock.RUnlock()
@@ -165,6 +257,42 @@ func (d *dataStoreData) catchupIndexes() {
 	d.snap = d.head.Snapshot()
 }
 
+func (d *dataStoreData) getIndexRows(ns string, idx *ds.IndexDefinition) [][]byte {
+	snap, _ := d.getQuerySnaps(false)
+	coll := snap.GetCollection(idxCollName(ns, idx.Normalize()))
+	if coll == nil {
+		return nil
+	}
+	ret := [][]byte(nil)
+	coll.VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
+		ret = append(ret, i.Key)
+		return true
+	})
+	return ret
+}
+
+func (d *dataStoreData) setIndexRows(ns string, idx *ds.IndexDefinition, rows [][]byte) {
+	d.Lock()
+	defer d.Unlock()
+
+	idx = idx.Normalize()
+	if idx.Compound() {
+		d.head.SetCollection("idx", nil).Set(serialize.ToBytes(*idx.PrepForIdxTable()), []byte{})
+	}
+	coll := d.head.SetCollection(idxCollName(ns, idx), nil)
+	old := [][]byte(nil)
+	coll.VisitItemsAscend(nil, false, func(i *gkvlite.Item) bool {
+		old = append(old, i.Key)
+		return true
+	})
+	for _, k := range old {
+		coll.Delete(k)
+	}
+	for _, r := range rows {
+		coll.Set(r, []byte{})
+	}
+}
+
 /////////////////////////// indexes(dataStoreData) ////////////////////////////
 
 func groupMetaKey(key *ds.Key) []byte {
@@ -179,6 +307,85 @@ func rootIDsKey(kind string) []byte {
 	return keyBytes(ds.NewKey("", "", "__entity_root_ids__", kind, 0, nil))
 }
 
+// commit is a write to the datastore. Outside of transactions, each entity is
+// written in its own commit, while a transaction is a single commit which
+// increments the version of each of its entity groups once.
+type commit struct {
+	now time.Time
+
+	// versions are the versions of the entity groups which the commit has made,
+	// keyed by their groupMetaKey, or nil if each entity is its own commit.
+	versions map[string]int64
+}
+
+func newCommit(now time.Time) *commit {
+	return &commit{now: now}
+}
+
+func newTxnCommit(now time.Time) *commit {
+	return &commit{now: now, versions: map[string]int64{}}
+}
+
+// groupVersionLocked returns the version of key's entity group which the
+// commit makes, incrementing it if it hasn't already.
+func (c *commit) groupVersionLocked(d *dataStoreData, ents *memCollection, key *ds.Key) int64 {
+	if d.disableSpecialEntities {
+		return 0
+	}
+	mkey := groupMetaKey(key)
+	if c.versions == nil {
+		return incrementLocked(ents, mkey, 1)
+	}
+	v, ok := c.versions[string(mkey)]
+	if !ok {
+		v = incrementLocked(ents, mkey, 1)
+		c.versions[string(mkey)] = v
+	}
+	return v
+}
+
+func commitsCollName(ns string) string {
+	return "commits:" + ns
+}
+
+// setCommitLocked records that the entity with the encoded key kb was written
+// by a commit at now, which made its group's version.
+func (d *dataStoreData) setCommitLocked(ns string, kb []byte, version int64, now time.Time) {
+	coll := commitsCollName(ns)
+	commits := d.head.GetCollection(coll)
+	if commits == nil {
+		commits = d.head.SetCollection(coll, nil)
+	}
+	commits.Set(kb, serialize.ToBytes(ds.PropertyMap{
+		"__version__":   {ds.MkPropertyNI(version)},
+		"__timestamp__": {ds.MkPropertyNI(now)},
+	}))
+}
+
+func (d *dataStoreData) delCommitLocked(ns string, kb []byte) {
+	if commits := d.head.GetCollection(commitsCollName(ns)); commits != nil {
+		commits.Delete(kb)
+	}
+}
+
+// lastCommit returns the last commit which put key's entity.
+func (d *dataStoreData) lastCommit(key *ds.Key) (ds.EntityCommit, error) {
+	commits := d.takeSnapshot().GetCollection(commitsCollName(key.Namespace()))
+	if commits == nil {
+		return ds.EntityCommit{}, ds.ErrNoSuchEntity
+	}
+	data := commits.Get(keyBytes(key))
+	if data == nil {
+		return ds.EntityCommit{}, ds.ErrNoSuchEntity
+	}
+	pm, err := rpm(data)
+	memoryCorruption(err)
+	return ds.EntityCommit{
+		Version:   pm["__version__"][0].Value().(int64),
+		Timestamp: pm["__timestamp__"][0].Value().(time.Time),
+	}, nil
+}
+
 func curVersion(ents *memCollection, key []byte) int64 {
 	if ents != nil {
 		if v := ents.Get(key); v != nil {
@@ -249,7 +456,7 @@ func (d *dataStoreData) fixKeyLocked(ents *memCollection, key *ds.Key) (*ds.Key,
 	return key, nil
 }
 
-func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.PutMultiCB) error {
+func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, c *commit, cb ds.PutMultiCB) error {
 	ns := keys[0].Namespace()
 
 	for i, k := range keys {
@@ -266,9 +473,7 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Pu
 			if err != nil {
 				return
 			}
-			if !d.disableSpecialEntities {
-				incrementLocked(ents, groupMetaKey(ret), 1)
-			}
+			version := c.groupVersionLocked(d, ents, ret)
 
 			old := ents.Get(keyBytes(ret))
 			oldPM := ds.PropertyMap(nil)
@@ -278,6 +483,7 @@ func (d *dataStoreData) putMulti(keys []*ds.Key, vals []ds.PropertyMap, cb ds.Pu
 				}
 			}
 			ents.Set(keyBytes(ret), dataBytes)
+			d.setCommitLocked(ns, keyBytes(ret), version, c.now)
 			updateIndexes(d.head, ret, oldPM, pmap)
 			return
 		}()
@@ -324,7 +530,7 @@ func (d *dataStoreData) getMulti(keys []*ds.Key, cb ds.GetMultiCB) error {
 	})
 }
 
-func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
+func (d *dataStoreData) delMulti(keys []*ds.Key, c *commit, cb ds.DeleteMultiCB) error {
 	ns := keys[0].Namespace()
 
 	hasEntsInNS := func() bool {
@@ -343,15 +549,14 @@ func (d *dataStoreData) delMulti(keys []*ds.Key, cb ds.DeleteMultiCB) error {
 
 				ents := d.mutableEntsLocked(ns)
 
-				if !d.disableSpecialEntities {
-					incrementLocked(ents, groupMetaKey(k), 1)
-				}
+				c.groupVersionLocked(d, ents, k)
 				if old := ents.Get(kb); old != nil {
 					oldPM, err := rpm(old)
 					if err != nil {
 						return err
 					}
 					ents.Delete(kb)
+					d.delCommitLocked(ns, kb)
 					updateIndexes(d.head, k, oldPM, nil)
 				}
 				return nil
@@ -406,6 +611,7 @@ func (d *dataStoreData) canApplyTxn(obj memContextObj) bool {
 
 func (d *dataStoreData) applyTxn(c context.Context, obj memContextObj) {
 	txn := obj.(*txnDataStoreData)
+	cmt := newTxnCommit(clock.Now(c).UTC())
 	for _, muts := range txn.muts {
 		if len(muts) == 0 { // read-only
 			continue
@@ -414,14 +620,15 @@ func (d *dataStoreData) applyTxn(c context.Context, obj memContextObj) {
 		for _, m := range muts {
 			k := m.key
 			if m.data == nil {
-				impossible(d.delMulti([]*ds.Key{k},
+				impossible(d.delMulti([]*ds.Key{k}, cmt,
 					func(e error) error { return e }))
 			} else {
-				impossible(d.putMulti([]*ds.Key{m.key}, []ds.PropertyMap{m.data},
+				impossible(d.putMulti([]*ds.Key{m.key}, []ds.PropertyMap{m.data}, cmt,
 					func(_ *ds.Key, e error) error { return e }))
 			}
 		}
 	}
+	d.maybeCatchupIndexes(c)
 }
 
 func (d *dataStoreData) mkTxn(o *ds.TransactionOptions) memContextObj {
@@ -0,0 +1,477 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+// importBatchSize is how many imported entities are put at a time.
+const importBatchSize = 500
+
+// ImportDatastoreExport puts the entities of one output file of a Cloud
+// Datastore managed export (the "output-N" files) into the memory datastore of
+// c, and returns how many it put. This allows tests to run against samples of
+// production data.
+//
+// The files are LevelDB logs of entity protos. The entities keep their
+// namespaces, but their keys (including key properties) are moved to the app
+// ID of c. Entities with properties which the datastore package doesn't
+// support, like users and embedded entities, are errors.
+//
+// Entities are put directly, without any filters installed in c, and indexes
+// catch up with them immediately.
+func ImportDatastoreExport(c context.Context, r io.Reader) (int, error) {
+	d, err := curDataStoreData(c)
+	if err != nil {
+		return 0, err
+	}
+
+	cmt := newCommit(clock.Now(c).UTC())
+	imported := 0
+	batches := map[string]*importBatch{}
+	flush := func(b *importBatch) error {
+		err := d.putMulti(b.keys, b.vals, cmt, func(_ *ds.Key, err error) error { return err })
+		if err == nil {
+			imported += len(b.keys)
+		}
+		b.keys, b.vals = b.keys[:0], b.vals[:0]
+		return err
+	}
+
+	lr := &levelDBLogReader{r: r}
+	for {
+		rec, err := lr.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("memory: reading export: %s", err)
+		}
+		k, pm, err := decodeEntityProto(d.aid, rec)
+		if err != nil {
+			return imported, fmt.Errorf("memory: reading export: %s", err)
+		}
+
+		b := batches[k.Namespace()]
+		if b == nil {
+			b = &importBatch{}
+			batches[k.Namespace()] = b
+		}
+		b.keys = append(b.keys, k)
+		b.vals = append(b.vals, pm)
+		if len(b.keys) == importBatchSize {
+			if err := flush(b); err != nil {
+				return imported, err
+			}
+		}
+	}
+	for _, b := range batches {
+		if len(b.keys) > 0 {
+			if err := flush(b); err != nil {
+				return imported, err
+			}
+		}
+	}
+	d.catchupIndexes()
+	return imported, nil
+}
+
+// ImportDatastoreExportDir imports (see ImportDatastoreExport) every output
+// file of the managed export in dir, which is the directory with the
+// export's .overall_export_metadata file (or any directory under it).
+func ImportDatastoreExportDir(c context.Context, dir string) (int, error) {
+	imported := 0
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || !strings.HasPrefix(info.Name(), "output-") {
+			return err
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		n, err := ImportDatastoreExport(c, f)
+		imported += n
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		return nil
+	})
+	return imported, err
+}
+
+type importBatch struct {
+	keys []*ds.Key
+	vals []ds.PropertyMap
+}
+
+////////////////////////////////// LevelDB logs ////////////////////////////////
+
+const (
+	levelDBBlockSize  = 32 * 1024
+	levelDBHeaderSize = 7
+
+	levelDBFull   = 1
+	levelDBFirst  = 2
+	levelDBMiddle = 3
+	levelDBLast   = 4
+)
+
+var levelDBCRCTable = crc32.MakeTable(crc32.Castagnoli)
+
+// levelDBLogReader reads the records of a LevelDB log, which are split into
+// chunks so that they fit into its blocks. See
+// https://github.com/google/leveldb/blob/master/doc/log_format.md
+type levelDBLogReader struct {
+	r     io.Reader
+	block [levelDBBlockSize]byte
+	rest  []byte
+}
+
+// next returns the next record, or io.EOF at the end of the log.
+func (l *levelDBLogReader) next() ([]byte, error) {
+	rec := []byte(nil)
+	inRecord := false
+	for {
+		if len(l.rest) < levelDBHeaderSize {
+			// The rest of the block is padding.
+			n, err := io.ReadFull(l.r, l.block[:])
+			switch {
+			case err == io.EOF && inRecord:
+				return nil, errors.New("truncated record")
+			case err == io.EOF:
+				return nil, io.EOF
+			case err != nil && err != io.ErrUnexpectedEOF:
+				return nil, err
+			}
+			l.rest = l.block[:n]
+			continue
+		}
+
+		sum := binary.LittleEndian.Uint32(l.rest)
+		length := int(binary.LittleEndian.Uint16(l.rest[4:]))
+		typ := l.rest[6]
+		if typ == 0 && length == 0 {
+			// Preallocated, zeroed space.
+			l.rest = nil
+			continue
+		}
+		if levelDBHeaderSize+length > len(l.rest) {
+			return nil, errors.New("corrupt chunk length")
+		}
+		chunk := l.rest[levelDBHeaderSize : levelDBHeaderSize+length]
+		l.rest = l.rest[levelDBHeaderSize+length:]
+
+		crc := crc32.Update(crc32.Update(0, levelDBCRCTable, []byte{typ}), levelDBCRCTable, chunk)
+		if (crc>>15|crc<<17)+0xa282ead8 != sum {
+			return nil, errors.New("chunk checksum mismatch")
+		}
+
+		switch {
+		case typ == levelDBFull && !inRecord:
+			return append([]byte(nil), chunk...), nil
+		case typ == levelDBFirst && !inRecord:
+			rec = append(rec, chunk...)
+			inRecord = true
+		case typ == levelDBMiddle && inRecord:
+			rec = append(rec, chunk...)
+		case typ == levelDBLast && inRecord:
+			return append(rec, chunk...), nil
+		default:
+			return nil, fmt.Errorf("unexpected chunk type %d", typ)
+		}
+	}
+}
+
+////////////////////////////////// entity protos ///////////////////////////////
+
+// protoField is a field of a protobuf message, in wire format.
+type protoField struct {
+	num  int
+	wire int
+	// v is the value of varint and fixed fields.
+	v uint64
+	// data is the value of length-delimited fields, or the contents of groups.
+	data []byte
+}
+
+func (f *protoField) str() string      { return string(f.data) }
+func (f *protoField) float64() float64 { return math.Float64frombits(f.v) }
+
+// protoMessage reads the fields of a protobuf message, in wire format.
+type protoMessage []byte
+
+func (m *protoMessage) varint() (uint64, error) {
+	v, n := binary.Uvarint(*m)
+	if n <= 0 {
+		return 0, errors.New("bad varint")
+	}
+	*m = (*m)[n:]
+	return v, nil
+}
+
+// next returns the next field, or io.EOF at the end of the message. Groups
+// are returned whole, as one field.
+func (m *protoMessage) next() (f protoField, err error) {
+	if len(*m) == 0 {
+		return f, io.EOF
+	}
+	key, err := m.varint()
+	if err != nil {
+		return
+	}
+	f.num, f.wire = int(key>>3), int(key&7)
+
+	fixed := func(n int) {
+		if len(*m) < n {
+			err = errors.New("truncated field")
+			return
+		}
+		buf := make([]byte, 8)
+		copy(buf, (*m)[:n])
+		f.v = binary.LittleEndian.Uint64(buf)
+		*m = (*m)[n:]
+	}
+
+	switch f.wire {
+	case 0:
+		f.v, err = m.varint()
+	case 1:
+		fixed(8)
+	case 5:
+		fixed(4)
+	case 2:
+		n := uint64(0)
+		if n, err = m.varint(); err == nil {
+			if n > uint64(len(*m)) {
+				return f, errors.New("truncated field")
+			}
+			f.data, *m = (*m)[:n], (*m)[n:]
+		}
+	case 3:
+		start := *m
+		for {
+			before := *m
+			g, err := m.next()
+			if err != nil {
+				if err == io.EOF {
+					err = errors.New("unterminated group")
+				}
+				return f, err
+			}
+			if g.wire == 4 {
+				if g.num != f.num {
+					return f, errors.New("mismatched group end")
+				}
+				f.data = start[:len(start)-len(before)]
+				break
+			}
+		}
+	case 4:
+		// The end of a group, which the group's start handles.
+	default:
+		err = fmt.Errorf("unknown wire type %d", f.wire)
+	}
+	return
+}
+
+// each calls cb with each field of m.
+func (m protoMessage) each(cb func(f *protoField) error) error {
+	for {
+		f, err := m.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := cb(&f); err != nil {
+			return err
+		}
+	}
+}
+
+// Meanings of entity proto properties.
+const (
+	meaningGDWhen      = 7
+	meaningBlob        = 14
+	meaningText        = 15
+	meaningByteString  = 16
+	meaningBlobKey     = 17
+	meaningEntityProto = 19
+	meaningEmptyList   = 24
+)
+
+// decodeEntityProto decodes an EntityProto, moving its key to the app ID aid.
+func decodeEntityProto(aid string, b []byte) (*ds.Key, ds.PropertyMap, error) {
+	key := (*ds.Key)(nil)
+	pm := ds.PropertyMap{}
+	err := protoMessage(b).each(func(f *protoField) (err error) {
+		switch f.num {
+		case 13: // key: Reference
+			key, err = decodeReference(aid, f.data, 14, 2, 3, 4)
+		case 14, 15: // property, raw_property
+			err = decodeProperty(aid, f.data, f.num == 14, pm)
+		}
+		return
+	})
+	if err == nil && key == nil {
+		err = errors.New("entity without a key")
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad entity: %s", err)
+	}
+	return key, pm, nil
+}
+
+// decodeReference decodes a Reference, or a PropertyValue's ReferenceValue,
+// which have the same app and namespace fields, but different path fields.
+// In References, the path is a message of Element groups; in ReferenceValues,
+// the path is the PathElement groups themselves.
+func decodeReference(aid string, b []byte, pathNum, kindNum, idNum, nameNum int) (*ds.Key, error) {
+	ns := ""
+	toks := []ds.KeyTok(nil)
+	addTok := func(f *protoField) error {
+		tok := ds.KeyTok{}
+		err := protoMessage(f.data).each(func(f *protoField) error {
+			switch f.num {
+			case kindNum:
+				tok.Kind = f.str()
+			case idNum:
+				tok.IntID = int64(f.v)
+			case nameNum:
+				tok.StringID = f.str()
+			}
+			return nil
+		})
+		toks = append(toks, tok)
+		return err
+	}
+
+	err := protoMessage(b).each(func(f *protoField) error {
+		switch {
+		case f.num == 20: // name_space
+			ns = f.str()
+		case f.num == pathNum && f.wire == 2: // Reference.path: Path
+			return protoMessage(f.data).each(func(f *protoField) error {
+				if f.num == 1 { // Path.Element
+					return addTok(f)
+				}
+				return nil
+			})
+		case f.num == pathNum: // ReferenceValue.PathElement
+			return addTok(f)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	key := ds.NewKeyToks(aid, ns, toks)
+	if !key.Valid(false, aid, ns) {
+		return nil, fmt.Errorf("invalid key %s", key)
+	}
+	return key, nil
+}
+
+// decodeProperty decodes a Property, and adds its value to pm.
+func decodeProperty(aid string, b []byte, indexed bool, pm ds.PropertyMap) error {
+	name, meaning := "", uint64(0)
+	val := protoMessage(nil)
+	err := protoMessage(b).each(func(f *protoField) error {
+		switch f.num {
+		case 1:
+			meaning = f.v
+		case 3:
+			name = f.str()
+		case 5:
+			val = f.data
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if meaning == meaningEmptyList {
+		if _, ok := pm[name]; !ok {
+			pm[name] = []ds.Property{}
+		}
+		return nil
+	}
+
+	v := interface{}(nil)
+	err = val.each(func(f *protoField) (err error) {
+		switch f.num {
+		case 1: // int64Value
+			if meaning == meaningGDWhen {
+				us := int64(f.v)
+				v = time.Unix(us/1e6, (us%1e6)*1e3).UTC()
+			} else {
+				v = int64(f.v)
+			}
+		case 2: // booleanValue
+			v = f.v != 0
+		case 3: // stringValue
+			switch meaning {
+			case meaningBlob, meaningByteString:
+				v = f.data
+			case meaningBlobKey:
+				v = blobstore.Key(f.str())
+			case meaningEntityProto:
+				err = fmt.Errorf("property %q: embedded entities aren't supported", name)
+			default:
+				v = f.str()
+			}
+		case 4: // doubleValue
+			v = f.float64()
+		case 5: // PointValue
+			pt := ds.GeoPoint{}
+			err = protoMessage(f.data).each(func(f *protoField) error {
+				switch f.num {
+				case 6:
+					pt.Lat = f.float64()
+				case 7:
+					pt.Lng = f.float64()
+				}
+				return nil
+			})
+			v = pt
+		case 8: // UserValue
+			err = fmt.Errorf("property %q: users aren't supported", name)
+		case 12: // ReferenceValue
+			v, err = decodeReference(aid, f.data, 14, 15, 16, 17)
+		}
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	prop := ds.Property{}
+	idx := ds.NoIndex
+	if indexed {
+		idx = ds.ShouldIndex
+	}
+	if err := prop.SetValue(v, idx); err != nil {
+		return fmt.Errorf("property %q: %s", name, err)
+	}
+	pm[name] = append(pm[name], prop)
+	return nil
+}
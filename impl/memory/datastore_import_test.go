@@ -0,0 +1,271 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+	dsS "github.com/tetrafolium/gae/service/datastore"
+	infoS "github.com/tetrafolium/gae/service/info"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// protoEnc encodes protobuf messages in wire format, for making exports.
+type protoEnc struct {
+	bytes.Buffer
+}
+
+func (e *protoEnc) key(num, wire int) { e.varint(uint64(num<<3 | wire)) }
+
+func (e *protoEnc) varint(v uint64) {
+	buf := make([]byte, binary.MaxVarintLen64)
+	e.Write(buf[:binary.PutUvarint(buf, v)])
+}
+
+func (e *protoEnc) int(num int, v int64) { e.key(num, 0); e.varint(uint64(v)) }
+
+func (e *protoEnc) double(num int, v float64) {
+	e.key(num, 1)
+	binary.Write(e, binary.LittleEndian, math.Float64bits(v))
+}
+
+func (e *protoEnc) bytes(num int, v []byte) {
+	e.key(num, 2)
+	e.varint(uint64(len(v)))
+	e.Write(v)
+}
+
+func (e *protoEnc) group(num int, f func(e *protoEnc)) {
+	e.key(num, 3)
+	f(e)
+	e.key(num, 4)
+}
+
+func (e *protoEnc) message(num int, f func(e *protoEnc)) {
+	inner := &protoEnc{}
+	f(inner)
+	e.bytes(num, inner.Bytes())
+}
+
+// reference encodes a ReferenceValue's fields (see decodeReference).
+func (e *protoEnc) referenceValue(ns string, toks ...dsS.KeyTok) {
+	e.bytes(13, []byte("s~prod"))
+	e.bytes(20, []byte(ns))
+	for _, t := range toks {
+		e.group(14, func(e *protoEnc) {
+			e.bytes(15, []byte(t.Kind))
+			if t.StringID != "" {
+				e.bytes(17, []byte(t.StringID))
+			} else {
+				e.int(16, t.IntID)
+			}
+		})
+	}
+}
+
+type exportProp struct {
+	name    string
+	meaning int
+	indexed bool
+	value   func(e *protoEnc)
+}
+
+func entityProto(ns string, toks []dsS.KeyTok, props ...exportProp) []byte {
+	e := &protoEnc{}
+	e.message(13, func(e *protoEnc) {
+		e.bytes(13, []byte("s~prod"))
+		e.bytes(20, []byte(ns))
+		e.message(14, func(e *protoEnc) {
+			for _, t := range toks {
+				e.group(1, func(e *protoEnc) {
+					e.bytes(2, []byte(t.Kind))
+					if t.StringID != "" {
+						e.bytes(4, []byte(t.StringID))
+					} else {
+						e.int(3, t.IntID)
+					}
+				})
+			}
+		})
+	})
+	for _, p := range props {
+		num := 15
+		if p.indexed {
+			num = 14
+		}
+		e.message(num, func(e *protoEnc) {
+			if p.meaning != 0 {
+				e.int(1, int64(p.meaning))
+			}
+			e.bytes(3, []byte(p.name))
+			e.message(5, p.value)
+		})
+	}
+	return e.Bytes()
+}
+
+// levelDBLog writes records in the LevelDB log format.
+func levelDBLog(recs ...[]byte) []byte {
+	buf := &bytes.Buffer{}
+	left := levelDBBlockSize
+	for _, rec := range recs {
+		first := true
+		for {
+			if left < levelDBHeaderSize {
+				buf.Write(make([]byte, left))
+				left = levelDBBlockSize
+			}
+			n := len(rec)
+			if n > left-levelDBHeaderSize {
+				n = left - levelDBHeaderSize
+			}
+			last := n == len(rec)
+			typ := byte(levelDBMiddle)
+			switch {
+			case first && last:
+				typ = levelDBFull
+			case first:
+				typ = levelDBFirst
+			case last:
+				typ = levelDBLast
+			}
+			crc := crc32.Update(crc32.Update(0, levelDBCRCTable, []byte{typ}), levelDBCRCTable, rec[:n])
+			binary.Write(buf, binary.LittleEndian, (crc>>15|crc<<17)+0xa282ead8)
+			binary.Write(buf, binary.LittleEndian, uint16(n))
+			buf.WriteByte(typ)
+			buf.Write(rec[:n])
+			left -= levelDBHeaderSize + n
+			rec, first = rec[n:], false
+			if last {
+				break
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestImportDatastoreExport(t *testing.T) {
+	t.Parallel()
+
+	Convey("ImportDatastoreExport", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+
+		when := time.Date(2015, time.March, 1, 2, 3, 4, 5000, time.UTC)
+		big := strings.Repeat("x", 3*levelDBBlockSize)
+		export := levelDBLog(
+			entityProto("", []dsS.KeyTok{{Kind: "Parent", StringID: "p"}, {Kind: "Foo", IntID: 1}},
+				exportProp{"Int", 0, true, func(e *protoEnc) { e.int(1, 10) }},
+				exportProp{"Multi", 0, true, func(e *protoEnc) { e.int(1, 1) }},
+				exportProp{"Multi", 0, true, func(e *protoEnc) { e.int(1, 2) }},
+				exportProp{"Bool", 0, true, func(e *protoEnc) { e.int(2, 1) }},
+				exportProp{"Str", 0, true, func(e *protoEnc) { e.bytes(3, []byte("hi")) }},
+				exportProp{"Text", meaningText, false, func(e *protoEnc) { e.bytes(3, []byte("long")) }},
+				exportProp{"Blob", meaningBlob, false, func(e *protoEnc) { e.bytes(3, []byte{0, 1}) }},
+				exportProp{"BlobKey", meaningBlobKey, true, func(e *protoEnc) { e.bytes(3, []byte("bk")) }},
+				exportProp{"Float", 0, true, func(e *protoEnc) { e.double(4, 1.5) }},
+				exportProp{"When", meaningGDWhen, true, func(e *protoEnc) { e.int(1, when.UnixNano()/1000) }},
+				exportProp{"Point", 0, true, func(e *protoEnc) {
+					e.group(5, func(e *protoEnc) { e.double(6, 1); e.double(7, 2) })
+				}},
+				exportProp{"Ref", 0, true, func(e *protoEnc) {
+					e.group(12, func(e *protoEnc) { e.referenceValue("", dsS.KeyTok{Kind: "Other", StringID: "o"}) })
+				}},
+				exportProp{"Null", 0, true, func(e *protoEnc) {}},
+				exportProp{"Empty", meaningEmptyList, false, func(e *protoEnc) {}},
+			),
+			entityProto("ns", []dsS.KeyTok{{Kind: "Foo", StringID: "big"}},
+				exportProp{"Text", meaningText, false, func(e *protoEnc) { e.bytes(3, []byte(big)) }},
+			),
+		)
+
+		n, err := ImportDatastoreExport(c, bytes.NewReader(export))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 2)
+
+		Convey("decodes every property type", func() {
+			pm := dsS.PropertyMap{"$key": {dsS.MkPropertyNI(ds.MakeKey("Parent", "p", "Foo", 1))}}
+			So(ds.Get(pm), ShouldBeNil)
+			delete(pm, "$key")
+
+			// Key properties are stored without their app IDs.
+			So(pm["Ref"][0].Value().(*dsS.Key).PathString(), ShouldEqual, "Other,o")
+			delete(pm, "Ref")
+
+			So(pm, ShouldResemble, dsS.PropertyMap{
+				"Int":     {dsS.MkProperty(10)},
+				"Multi":   {dsS.MkProperty(1), dsS.MkProperty(2)},
+				"Bool":    {dsS.MkProperty(true)},
+				"Str":     {dsS.MkProperty("hi")},
+				"Text":    {dsS.MkPropertyNI("long")},
+				"Blob":    {dsS.MkPropertyNI([]byte{0, 1})},
+				"BlobKey": {dsS.MkProperty(blobstore.Key("bk"))},
+				"Float":   {dsS.MkProperty(1.5)},
+				"When":    {dsS.MkProperty(when)},
+				"Point":   {dsS.MkProperty(dsS.GeoPoint{Lat: 1, Lng: 2})},
+				"Null":    {dsS.MkProperty(nil)},
+				"Empty":   nil,
+			})
+		})
+
+		Convey("keeps namespaces, across blocks", func() {
+			nsC, err := infoS.Get(c).Namespace("ns")
+			So(err, ShouldBeNil)
+			pm := dsS.PropertyMap{"$key": {dsS.MkPropertyNI(dsS.Get(nsC).MakeKey("Foo", "big"))}}
+			So(dsS.Get(nsC).Get(pm), ShouldBeNil)
+			So(pm.GetString("Text", ""), ShouldEqual, big)
+		})
+
+		Convey("indexes are caught up", func() {
+			count, err := ds.Count(dsS.NewQuery("Foo").Eq("Multi", 2))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+
+		Convey("ImportDatastoreExportDir finds the output files", func() {
+			dir, err := ioutil.TempDir("", "memory_import")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			kindDir := filepath.Join(dir, "all_namespaces", "kind_Foo")
+			So(os.MkdirAll(kindDir, 0700), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(dir, "x.overall_export_metadata"), []byte("meta"), 0600), ShouldBeNil)
+			So(ioutil.WriteFile(filepath.Join(kindDir, "output-0"), export, 0600), ShouldBeNil)
+
+			c := Use(context.Background())
+			n, err := ImportDatastoreExportDir(c, dir)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+		})
+
+		Convey("bad exports", func() {
+			corrupt := append([]byte(nil), export...)
+			corrupt[levelDBHeaderSize] ^= 1
+			_, err := ImportDatastoreExport(c, bytes.NewReader(corrupt))
+			So(err, ShouldErrLike, "checksum mismatch")
+
+			_, err = ImportDatastoreExport(c, bytes.NewReader(export[:levelDBBlockSize]))
+			So(err, ShouldErrLike, "truncated record")
+
+			user := levelDBLog(entityProto("", []dsS.KeyTok{{Kind: "Foo", IntID: 2}},
+				exportProp{"User", 0, true, func(e *protoEnc) { e.group(8, func(e *protoEnc) {}) }}))
+			_, err = ImportDatastoreExport(c, bytes.NewReader(user))
+			So(err, ShouldErrLike, `property "User": users aren't supported`)
+
+			So(ds.Get(dsS.PropertyMap{"$key": {dsS.MkPropertyNI(ds.MakeKey("Foo", 2))}}), ShouldEqual, dsS.ErrNoSuchEntity)
+		})
+	})
+}
@@ -45,7 +45,7 @@ func defaultIndexes(kind string, pmap ds.PropertyMap) []*ds.IndexDefinition {
 
 func indexEntriesWithBuiltins(k *ds.Key, pm ds.PropertyMap, complexIdxs []*ds.IndexDefinition) *memStore {
 	sip := serialize.PropertyMapPartially(k, pm)
-	return indexEntries(sip, k.Namespace(), append(defaultIndexes(k.Kind(), pm), complexIdxs...))
+	return indexEntries(sip, k.Kind(), k.Namespace(), append(defaultIndexes(k.Kind(), pm), complexIdxs...))
 }
 
 // indexRowGen contains enough information to generate all of the index rows which
@@ -132,17 +132,22 @@ func (m *matcher) match(sortBy []ds.IndexColumn, sip serialize.SerializedPmap) (
 	return m.buf, true
 }
 
-func indexEntries(sip serialize.SerializedPmap, ns string, idxs []*ds.IndexDefinition) *memStore {
+// indexEntries returns the rows of the indexes in idxs for the entity sip,
+// which has the given kind. Indexes of other kinds are skipped.
+func indexEntries(sip serialize.SerializedPmap, kind, ns string, idxs []*ds.IndexDefinition) *memStore {
 	ret := newMemStore()
 	idxColl := ret.SetCollection("idx", nil)
 
 	mtch := matcher{}
 	for _, idx := range idxs {
+		if idx.Kind != kind {
+			continue
+		}
 		idx = idx.Normalize()
 		if irg, ok := mtch.match(idx.GetFullSortOrder(), sip); ok {
 			idxBin := serialize.ToBytes(*idx.PrepForIdxTable())
 			idxColl.Set(idxBin, []byte{})
-			coll := ret.SetCollection(fmt.Sprintf("idx:%s:%s", ns, idxBin), nil)
+			coll := ret.SetCollection(idxCollName(ns, idx), nil)
 			irg.permute(coll.Set)
 		}
 	}
@@ -150,6 +155,12 @@ func indexEntries(sip serialize.SerializedPmap, ns string, idxs []*ds.IndexDefin
 	return ret
 }
 
+// idxCollName returns the name of the collection with the rows of the
+// (normalized) index idx in the namespace ns.
+func idxCollName(ns string, idx *ds.IndexDefinition) string {
+	return fmt.Sprintf("idx:%s:%s", ns, serialize.ToBytes(*idx.PrepForIdxTable()))
+}
+
 // walkCompIdxs walks the table of compound indexes in the store. If `endsWith`
 // is provided, this will only walk over compound indexes which match
 // Kind, Ancestor, and whose SortBy has `endsWith.SortBy` as a suffix.
@@ -246,7 +257,7 @@ func addIndexes(store *memStore, aid, ns string, compIdx []*ds.IndexDefinition)
 
 			mergeIndexes(ns, store,
 				newMemStore(),
-				indexEntries(sip, ns, normalized))
+				indexEntries(sip, k.Kind(), ns, normalized))
 			return true
 		})
 	}
@@ -260,7 +271,13 @@ func updateIndexes(store *memStore, key *ds.Key, oldEnt, newEnt ds.PropertyMap)
 		return true
 	})
 
-	mergeIndexes(key.Namespace(), store,
-		indexEntriesWithBuiltins(key, oldEnt, compIdx),
-		indexEntriesWithBuiltins(key, newEnt, compIdx))
+	// A nil entity doesn't exist, and so has no index rows at all (not even
+	// the ones which only contain its key).
+	entries := func(ent ds.PropertyMap) *memStore {
+		if ent == nil {
+			return newMemStore()
+		}
+		return indexEntriesWithBuiltins(key, ent, compIdx)
+	}
+	mergeIndexes(key.Namespace(), store, entries(oldEnt), entries(newEnt))
 }
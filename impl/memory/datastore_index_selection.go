@@ -20,6 +20,9 @@ import (
 type ErrMissingIndex struct {
 	ns      string
 	Missing *ds.IndexDefinition
+
+	// Query is the query which needed the index, if known.
+	Query *ds.FinalizedQuery
 }
 
 func (e *ErrMissingIndex) Error() string {
@@ -27,6 +30,10 @@ func (e *ErrMissingIndex) Error() string {
 	if err != nil {
 		panic(err)
 	}
+	if e.Query != nil {
+		return fmt.Sprintf(
+			"Insufficient indexes for query:\n  %s\nConsider adding:\n%s", e.Query.GQL(), yaml)
+	}
 	return fmt.Sprintf(
 		"Insufficient indexes. Consider adding:\n%s", yaml)
 }
@@ -196,8 +203,7 @@ func (idxs *indexDefinitionSortableSlice) maybeAddDefinition(q *reducedQuery, s
 	// index's potential just because the collection doesn't exist. If it's
 	// a builtin and it doesn't exist, it still needs to be one of the 'possible'
 	// indexes... it just means that the user's query will end up with no results.
-	coll := s.GetCollection(
-		fmt.Sprintf("idx:%s:%s", q.ns, serialize.ToBytes(*id.PrepForIdxTable())))
+	coll := s.GetCollection(idxCollName(q.ns, id))
 
 	// First, see if it's a perfect match. If it is, then our search is over.
 	//
@@ -319,7 +325,7 @@ func getRelevantIndexes(q *reducedQuery, s *memStore) (indexDefinitionSortableSl
 			impossible(
 				fmt.Errorf("recommended missing index would be a builtin: %s", remains))
 		}
-		return nil, &ErrMissingIndex{q.ns, remains}
+		return nil, &ErrMissingIndex{ns: q.ns, Missing: remains}
 	}
 
 	return idxs, nil
@@ -484,7 +490,12 @@ func getIndexes(q *reducedQuery, s *memStore) ([]*iterDefinition, error) {
 	if len(relevantIdxs) == 0 {
 		return nil, ds.ErrNullQuery
 	}
+	return joinIndexes(q, relevantIdxs)
+}
 
+// joinIndexes picks the indexes out of relevantIdxs which will be merged
+// together to service q, and returns their iterator definitions.
+func joinIndexes(q *reducedQuery, relevantIdxs indexDefinitionSortableSlice) ([]*iterDefinition, error) {
 	// This sorts it so that relevantIdxs goes less filters -> more filters. We
 	// traverse this list backwards, however, so we traverse it in more filters ->
 	// less filters order.
@@ -549,3 +560,88 @@ func getIndexes(q *reducedQuery, s *memStore) ([]*iterDefinition, error) {
 
 	return ret, nil
 }
+
+// QueryCost describes the work which the datastore would do to run a query.
+type QueryCost struct {
+	// Index is the composite index which the query needs, or nil if the
+	// builtin indexes are sufficient.
+	Index *ds.IndexDefinition
+
+	// RowsPerResult is the number of index rows expected to be scanned for each
+	// result. Queries which merge several indexes together (e.g. with equality
+	// filters on multiple properties, but without a composite index to serve
+	// them) scan a row from each index per result. It's 0 if the query can't
+	// return any results.
+	RowsPerResult int
+
+	// IndexOnly is true if the query can be satisfied from the index rows alone,
+	// without fetching entities (i.e. it's a keys-only or projection query).
+	IndexOnly bool
+}
+
+// EstimateCost reports the cost of running fq, using the same index selection
+// as this in-memory datastore. It assumes that the composite index needed by
+// fq, if any, exists.
+//
+// Returns an error if the query is invalid (e.g. it has too many filters).
+func EstimateCost(fq *ds.FinalizedQuery) (QueryCost, error) {
+	ret := QueryCost{IndexOnly: fq.KeysOnly() || len(fq.Project()) > 0}
+
+	aid, ns := queryAppNS(fq)
+	q, err := reduce(fq, aid, ns, false)
+	if err == ds.ErrNullQuery {
+		return ret, nil
+	}
+	if err != nil {
+		return ret, err
+	}
+	if q.kind == "" {
+		// kindless queries scan the entities directly.
+		ret.RowsPerResult = 1
+		return ret, nil
+	}
+
+	store := newMemStore()
+	relevantIdxs, err := getRelevantIndexes(q, store)
+	if mi, ok := err.(*ErrMissingIndex); ok {
+		ret.Index = mi.Missing
+		addIndexes(store, aid, ns, []*ds.IndexDefinition{mi.Missing})
+		relevantIdxs, err = getRelevantIndexes(q, store)
+	}
+	if err != nil {
+		return ret, err
+	}
+
+	// store doesn't have any data, so pretend that every index has some.
+	for i := range relevantIdxs {
+		if relevantIdxs[i].coll == nil {
+			relevantIdxs[i].coll = store.MakePrivateCollection(nil)
+		}
+	}
+	idxs, err := joinIndexes(q, relevantIdxs)
+	if err != nil {
+		return ret, err
+	}
+	ret.RowsPerResult = len(idxs)
+	return ret, nil
+}
+
+// queryAppNS returns the app ID and namespace of the keys in fq, if it has
+// any.
+func queryAppNS(fq *ds.FinalizedQuery) (aid, ns string) {
+	keys := []*ds.Key{fq.Ancestor()}
+	if fq.IneqFilterProp() == "__key__" {
+		if _, op, v := fq.IneqFilterLow(); op != "" {
+			keys = append(keys, v.Value().(*ds.Key))
+		}
+		if _, op, v := fq.IneqFilterHigh(); op != "" {
+			keys = append(keys, v.Value().(*ds.Key))
+		}
+	}
+	for _, k := range keys {
+		if k != nil {
+			return k.AppID(), k.Namespace()
+		}
+	}
+	return "", ""
+}
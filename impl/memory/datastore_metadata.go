@@ -0,0 +1,269 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"github.com/luci/gkvlite"
+	"github.com/luci/luci-go/common/stringset"
+)
+
+// The kinds of the metadata pseudo-entities, which describe the namespaces,
+// kinds and properties of the entities in the datastore. Like in production:
+//   - There's a __namespace__ entity for each namespace with entities. Its
+//     key's StringID is the namespace, or its IntID is 1 for the default
+//     namespace.
+//   - There's a __kind__ entity for each kind with entities in the query's
+//     namespace. Its key's StringID is the kind.
+//   - There's a __property__ entity for each indexed property of each kind,
+//     whose parent is the kind's __kind__ key. Its key's StringID is the
+//     property name, and its property_representation property lists the
+//     representations of the property's values (e.g. "INT64" or "STRING").
+//
+// Metadata queries may only filter on __key__ (and __ancestor__, for
+// __property__ queries), and may only be ordered by __key__.
+const (
+	namespaceKind = "__namespace__"
+	kindKind      = "__kind__"
+	propertyKind  = "__property__"
+)
+
+func isMetadataQuery(fq *ds.FinalizedQuery) bool {
+	switch fq.Kind() {
+	case namespaceKind, kindKind, propertyKind:
+		return true
+	}
+	return false
+}
+
+// propertyRepresentation is the property_representation of a type of value,
+// as the production datastore stores it in its indexes.
+func propertyRepresentation(pt ds.PropertyType) string {
+	switch pt {
+	case ds.PTNull:
+		return "NULL"
+	case ds.PTInt, ds.PTTime:
+		return "INT64"
+	case ds.PTBool:
+		return "BOOLEAN"
+	case ds.PTString, ds.PTBytes, ds.PTBlobKey:
+		return "STRING"
+	case ds.PTFloat:
+		return "DOUBLE"
+	case ds.PTGeoPoint:
+		return "POINT"
+	case ds.PTKey:
+		return "REFERENCE"
+	}
+	impossible(fmt.Errorf("unknown property type %s", pt))
+	return ""
+}
+
+// metaEntity is a metadata pseudo-entity.
+type metaEntity struct {
+	key *ds.Key
+	pm  ds.PropertyMap
+}
+
+type metaEntities []metaEntity
+
+func (s metaEntities) Len() int           { return len(s) }
+func (s metaEntities) Less(i, j int) bool { return s[i].key.Less(s[j].key) }
+func (s metaEntities) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// visitUserEntities calls cb with the key and encoded PropertyMap of each
+// entity in the namespace ns of store, except for the special ones (like
+// __entity_group__), until cb returns false.
+func visitUserEntities(store *memStore, aid, ns string, cb func(key *ds.Key, rawEnt []byte) bool) {
+	coll := store.GetCollection("ents:" + ns)
+	if coll == nil {
+		return
+	}
+	coll.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+		p, err := serialize.ReadProperty(bytes.NewBuffer(i.Key), serialize.WithoutContext, aid, ns)
+		memoryCorruption(err)
+		key := p.Value().(*ds.Key)
+		if key.LastTok().Special() {
+			return true
+		}
+		return cb(key, i.Val)
+	})
+}
+
+func namespaceEntities(store *memStore, aid, ns string) metaEntities {
+	ret := metaEntities{}
+	for _, name := range store.GetCollectionNames() {
+		if !strings.HasPrefix(name, "ents:") {
+			continue
+		}
+		entNS := name[len("ents:"):]
+		visitUserEntities(store, aid, entNS, func(*ds.Key, []byte) bool {
+			key := ds.NewKey(aid, ns, namespaceKind, entNS, 0, nil)
+			if entNS == "" {
+				key = ds.NewKey(aid, ns, namespaceKind, "", 1, nil)
+			}
+			ret = append(ret, metaEntity{key, ds.PropertyMap{}})
+			return false
+		})
+	}
+	return ret
+}
+
+func kindEntities(store *memStore, aid, ns string) metaEntities {
+	kinds := stringset.New(0)
+	visitUserEntities(store, aid, ns, func(key *ds.Key, _ []byte) bool {
+		kinds.Add(key.Kind())
+		return true
+	})
+	ret := make(metaEntities, 0, kinds.Len())
+	kinds.Iter(func(kind string) bool {
+		ret = append(ret, metaEntity{ds.NewKey(aid, ns, kindKind, kind, 0, nil), ds.PropertyMap{}})
+		return true
+	})
+	return ret
+}
+
+// propertyEntities returns the __property__ entities of the kind named by
+// kindKey, or of every kind if it's nil.
+func propertyEntities(store *memStore, aid, ns string, kindKey *ds.Key) metaEntities {
+	// kind -> property -> representations
+	props := map[string]map[string]stringset.Set{}
+	visitUserEntities(store, aid, ns, func(key *ds.Key, rawEnt []byte) bool {
+		if kindKey != nil && key.Kind() != kindKey.StringID() {
+			return true
+		}
+		pm, err := serialize.ReadPropertyMap(bytes.NewBuffer(rawEnt), serialize.WithoutContext, aid, ns)
+		memoryCorruption(err)
+
+		kindProps := props[key.Kind()]
+		if kindProps == nil {
+			kindProps = map[string]stringset.Set{}
+			props[key.Kind()] = kindProps
+		}
+		for name, vals := range pm {
+			for _, v := range vals {
+				if v.IndexSetting() != ds.ShouldIndex {
+					continue
+				}
+				if kindProps[name] == nil {
+					kindProps[name] = stringset.New(1)
+				}
+				kindProps[name].Add(propertyRepresentation(v.Type()))
+			}
+		}
+		return true
+	})
+
+	ret := metaEntities{}
+	for kind, kindProps := range props {
+		parent := ds.NewKey(aid, ns, kindKind, kind, 0, nil)
+		for name, reps := range kindProps {
+			repList := reps.ToSlice()
+			sort.Strings(repList)
+			repProps := make([]ds.Property, len(repList))
+			for i, r := range repList {
+				repProps[i] = ds.MkProperty(r)
+			}
+			ret = append(ret, metaEntity{
+				ds.NewKey(aid, ns, propertyKind, name, 0, parent),
+				ds.PropertyMap{"property_representation": repProps},
+			})
+		}
+	}
+	return ret
+}
+
+// executeMetadataQuery runs a query for metadata pseudo-entities, which are
+// computed from the entities in idx, rather than being stored.
+func executeMetadataQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx *memStore, cb ds.RawRunCB) error {
+	kind := fq.Kind()
+	if isTxn {
+		return fmt.Errorf("queries of %s can't be run in transactions", kind)
+	}
+	eqFilts := fq.EqFilters()
+	delete(eqFilts, "__ancestor__")
+	if len(eqFilts) > 0 || len(fq.Project()) > 0 || (fq.IneqFilterProp() != "" && fq.IneqFilterProp() != "__key__") {
+		return fmt.Errorf("queries of %s may only filter on __key__", kind)
+	}
+	for _, o := range fq.Orders() {
+		if o.Property != "__key__" {
+			return fmt.Errorf("queries of %s may only be ordered by __key__", kind)
+		}
+	}
+	if start, end := fq.Bounds(); start != nil || end != nil {
+		return fmt.Errorf("cursors aren't supported on queries of %s", kind)
+	}
+	anc := fq.Ancestor()
+	if anc != nil && (kind != propertyKind || anc.Kind() != kindKind || anc.Parent() != nil) {
+		return fmt.Errorf("only queries of %s may have an ancestor, which must be a %s key", propertyKind, kindKind)
+	}
+
+	ents := metaEntities(nil)
+	switch kind {
+	case namespaceKind:
+		ents = namespaceEntities(idx, aid, ns)
+	case kindKind:
+		ents = kindEntities(idx, aid, ns)
+	case propertyKind:
+		ents = propertyEntities(idx, aid, ns, anc)
+	}
+	if fq.Orders()[0].Descending {
+		sort.Sort(sort.Reverse(ents))
+	} else {
+		sort.Sort(ents)
+	}
+
+	inRange := func(k *ds.Key) bool {
+		if _, op, v := fq.IneqFilterLow(); op != "" {
+			low := v.Value().(*ds.Key).WithContext(aid, ns)
+			if k.Less(low) || (op == ">" && k.Equal(low)) {
+				return false
+			}
+		}
+		if _, op, v := fq.IneqFilterHigh(); op != "" {
+			high := v.Value().(*ds.Key).WithContext(aid, ns)
+			if high.Less(k) || (op == "<" && k.Equal(high)) {
+				return false
+			}
+		}
+		return true
+	}
+
+	offset, _ := fq.Offset()
+	limit, hasLimit := fq.Limit()
+	noCursor := func() (ds.Cursor, error) {
+		return nil, fmt.Errorf("cursors aren't supported on queries of %s", kind)
+	}
+	for _, e := range ents {
+		if hasLimit && limit <= 0 {
+			break
+		}
+		if !inRange(e.key) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		pm := e.pm
+		if fq.KeysOnly() {
+			pm = nil
+		}
+		if err := cb(e.key, pm, noCursor); err != nil {
+			if err == ds.Stop {
+				return nil
+			}
+			return err
+		}
+		limit--
+	}
+	return nil
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/luci/gkvlite"
+	"github.com/luci/luci-go/common/cmpbin"
+	"golang.org/x/net/context"
+)
+
+// persistMagic starts every saved datastore, and changes whenever the format
+// does.
+const persistMagic = "gae/impl/memory datastore v1"
+
+// ErrNotMemoryContext is returned when a context without the memory
+// implementation (see Use) is used to save or load the datastore.
+var ErrNotMemoryContext = errors.New("memory: the context doesn't use the memory implementation")
+
+func curDataStoreData(c context.Context) (*dataStoreData, error) {
+	mc := curNoTxn(c)
+	if mc == nil {
+		return nil, ErrNotMemoryContext
+	}
+	return mc.Get(memContextDSIdx).(*dataStoreData), nil
+}
+
+// SaveDatastore writes the state of the memory datastore of c to w: its
+// entities, compound indexes and allocated IDs. LoadDatastore reads it back.
+//
+// Settings (like Testable().Consistent) aren't saved.
+func SaveDatastore(c context.Context, w io.Writer) error {
+	d, err := curDataStoreData(c)
+	if err != nil {
+		return err
+	}
+	// A snapshot can be written out without holding up the datastore.
+	_, head := d.getQuerySnaps(true)
+
+	bw := bufio.NewWriter(w)
+	ew := &errWriter{w: bw}
+	ew.writeString(persistMagic)
+	ew.writeString(d.aid)
+	names := head.GetCollectionNames()
+	ew.writeUint(uint64(len(names)))
+	for _, name := range names {
+		coll := head.GetCollection(name)
+		numItems, _ := coll.GetTotals()
+		ew.writeString(name)
+		ew.writeUint(numItems)
+		coll.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+			ew.writeBytes(i.Key)
+			ew.writeBytes(i.Val)
+			return ew.err == nil
+		})
+	}
+	if ew.err != nil {
+		return ew.err
+	}
+	return bw.Flush()
+}
+
+// LoadDatastore replaces the state of the memory datastore of c with one
+// written by SaveDatastore. It must have been saved with the same app ID.
+//
+// Indexes catch up with the loaded entities immediately.
+func LoadDatastore(c context.Context, r io.Reader) error {
+	d, err := curDataStoreData(c)
+	if err != nil {
+		return err
+	}
+
+	er := &errReader{r: bufio.NewReader(r)}
+	if magic := er.readString(); er.err == nil && magic != persistMagic {
+		return errors.New("memory: not a saved datastore")
+	}
+	if aid := er.readString(); er.err == nil && aid != d.aid {
+		return fmt.Errorf("memory: the datastore was saved with app ID %q, not %q", aid, d.aid)
+	}
+	head := newMemStore()
+	for numColls := er.readUint(); er.err == nil && numColls > 0; numColls-- {
+		coll := head.SetCollection(er.readString(), nil)
+		for numItems := er.readUint(); er.err == nil && numItems > 0; numItems-- {
+			k, v := er.readBytes(), er.readBytes()
+			if er.err == nil {
+				coll.Set(k, v)
+			}
+		}
+	}
+	if er.err != nil {
+		return fmt.Errorf("memory: reading saved datastore: %s", er.err)
+	}
+
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.head = head
+	if d.snap != nil {
+		d.snap = head.Snapshot()
+	}
+	return nil
+}
+
+// SaveDatastoreFile saves the memory datastore of c (see SaveDatastore) to
+// the file at path. The file is replaced atomically, so it's never left half
+// written.
+//
+// Together with LoadDatastoreFile, this lets development servers keep their
+// data across restarts, like dev_appserver's --datastore_path.
+func SaveDatastoreFile(c context.Context, path string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = SaveDatastore(c, f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// LoadDatastoreFile loads the memory datastore of c (see LoadDatastore) from
+// the file at path, which was written by SaveDatastoreFile. If there's no
+// such file, the datastore is left as it is, and no error is returned.
+func LoadDatastoreFile(c context.Context, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	return LoadDatastore(c, f)
+}
+
+// errWriter writes the cmpbin encodings of values, until the first error,
+// which it keeps.
+type errWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *errWriter) writeUint(v uint64) {
+	if w.err == nil {
+		_, w.err = cmpbin.WriteUint(w.w, v)
+	}
+}
+
+func (w *errWriter) writeBytes(v []byte) {
+	if w.err == nil {
+		_, w.err = cmpbin.WriteBytes(w.w, v)
+	}
+}
+
+func (w *errWriter) writeString(v string) {
+	if w.err == nil {
+		_, w.err = cmpbin.WriteString(w.w, v)
+	}
+}
+
+// errReader is the errWriter for reading.
+type errReader struct {
+	r   *bufio.Reader
+	err error
+}
+
+func (r *errReader) readUint() (v uint64) {
+	if r.err == nil {
+		v, _, r.err = cmpbin.ReadUint(r.r)
+	}
+	return
+}
+
+func (r *errReader) readBytes() (v []byte) {
+	if r.err == nil {
+		v, _, r.err = cmpbin.ReadBytes(r.r)
+	}
+	return
+}
+
+func (r *errReader) readString() (v string) {
+	if r.err == nil {
+		v, _, r.err = cmpbin.ReadString(r.r)
+	}
+	return
+}
@@ -88,6 +88,28 @@ func sortOrdersEqual(as, bs []ds.IndexColumn) bool {
 	return true
 }
 
+// cursorRow returns the raw row data of a cursor with the columns cols, for
+// a query with suffixFormat. The cursor may also come from the reversed query
+// (see Query.Reverse), in which case its row is converted. ok is false if the
+// cursor can't be used in the query.
+func cursorRow(cols []ds.IndexColumn, row []byte, suffixFormat []ds.IndexColumn) (ret []byte, ok bool) {
+	if sortOrdersEqual(cols, suffixFormat) {
+		return row, true
+	}
+	if len(cols) != len(suffixFormat) {
+		return nil, false
+	}
+	for i, col := range cols {
+		if col.Property != suffixFormat[i].Property || col.Descending == suffixFormat[i].Descending {
+			return nil, false
+		}
+	}
+	// Every column of the reversed index is inverted. The cursor points just
+	// past the row r that it follows (at r+1), and so in this index it points at
+	// ^r, which is ^(r+1)+1.
+	return increment(serialize.Invert(row)), true
+}
+
 func numComponents(fq *ds.FinalizedQuery) int {
 	numComponents := len(fq.Orders())
 	if p, _, _ := fq.IneqFilterLow(); p != "" {
@@ -209,7 +231,8 @@ func reduce(fq *ds.FinalizedQuery, aid, ns string, isTxn bool) (*reducedQuery, e
 					return nil, err
 				}
 
-				if !sortOrdersEqual(startCols, ret.suffixFormat) {
+				startD, ok = cursorRow(startCols, startD, ret.suffixFormat)
+				if !ok {
 					return nil, errors.New("gae/memory: start cursor is invalid for this query")
 				}
 				if ret.start == nil || bytes.Compare(ret.start, startD) < 0 {
@@ -227,7 +250,8 @@ func reduce(fq *ds.FinalizedQuery, aid, ns string, isTxn bool) (*reducedQuery, e
 					return nil, err
 				}
 
-				if !sortOrdersEqual(endCols, ret.suffixFormat) {
+				endD, ok = cursorRow(endCols, endD, ret.suffixFormat)
+				if !ok {
 					return nil, errors.New("gae/memory: end cursor is invalid for this query")
 				}
 				if ret.end == nil || bytes.Compare(endD, ret.end) < 0 {
@@ -6,7 +6,9 @@ package memory
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"fmt"
+	"sort"
 
 	ds "github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/datastore/serialize"
@@ -35,6 +37,10 @@ type projectionStrategy struct {
 
 	project  []projectionLookup
 	distinct stringset.Set
+
+	// distinctOn holds the indexes (in project) of the properties which
+	// distinct rows are distinguished by.
+	distinctOn []int
 }
 
 func newProjectionStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB) queryStrategy {
@@ -56,27 +62,35 @@ func newProjectionStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRun
 	ret := &projectionStrategy{cb: cb, project: projectionLookups}
 	if fq.Distinct() {
 		ret.distinct = stringset.New(0)
+		ret.distinctOn = make([]int, len(proj))
+		for i := range proj {
+			ret.distinctOn[i] = i
+		}
+	} else if on := stringset.NewFromSlice(fq.DistinctOn()...); on.Len() > 0 {
+		ret.distinct = stringset.New(0)
+		for i, prop := range proj {
+			if on.Has(prop) {
+				ret.distinctOn = append(ret.distinctOn, i)
+			}
+		}
 	}
 	return ret
 }
 
 func (s *projectionStrategy) handle(rawData [][]byte, decodedProps []ds.Property, key *ds.Key, gc func() (ds.Cursor, error)) error {
-	projectedRaw := [][]byte(nil)
 	if s.distinct != nil {
-		projectedRaw = make([][]byte, len(decodedProps))
-	}
-	pmap := make(ds.PropertyMap, len(s.project))
-	for i, p := range s.project {
-		if s.distinct != nil {
-			projectedRaw[i] = rawData[p.suffixIndex]
+		distinctRaw := make([][]byte, len(s.distinctOn))
+		for i, idx := range s.distinctOn {
+			distinctRaw[i] = rawData[s.project[idx].suffixIndex]
 		}
-		pmap[p.propertyName] = []ds.Property{decodedProps[p.suffixIndex]}
-	}
-	if s.distinct != nil {
-		if !s.distinct.Add(string(serialize.Join(projectedRaw...))) {
+		if !s.distinct.Add(string(serialize.Join(distinctRaw...))) {
 			return nil
 		}
 	}
+	pmap := make(ds.PropertyMap, len(s.project))
+	for _, p := range s.project {
+		pmap[p.propertyName] = []ds.Property{decodedProps[p.suffixIndex]}
+	}
 	return s.cb(key, pmap, gc)
 }
 
@@ -100,14 +114,24 @@ type normalStrategy struct {
 	ns    string
 	head  *memCollection
 	dedup stringset.Set
+
+	// tombstones holds the entities as of the index snapshot, for eventually
+	// consistent queries. Entities which have been deleted since the snapshot
+	// are still returned from here, like production returns deleted entities
+	// until its indexes catch up. It's nil if the query is consistent.
+	tombstones *memCollection
 }
 
-func newNormalStrategy(aid, ns string, cb ds.RawRunCB, head *memStore) queryStrategy {
+func newNormalStrategy(aid, ns string, cb ds.RawRunCB, idx, head *memStore) queryStrategy {
 	coll := head.GetCollection("ents:" + ns)
-	if coll == nil {
+	tombstones := (*memCollection)(nil)
+	if idx != head {
+		tombstones = idx.GetCollection("ents:" + ns)
+	}
+	if coll == nil && tombstones == nil {
 		return nil
 	}
-	return &normalStrategy{cb, aid, ns, coll, stringset.New(0)}
+	return &normalStrategy{cb, aid, ns, coll, stringset.New(0), tombstones}
 }
 
 func (s *normalStrategy) handle(rawData [][]byte, _ []ds.Property, key *ds.Key, gc func() (ds.Cursor, error)) error {
@@ -116,7 +140,13 @@ func (s *normalStrategy) handle(rawData [][]byte, _ []ds.Property, key *ds.Key,
 		return nil
 	}
 
-	rawEnt := s.head.Get(rawKey)
+	rawEnt := []byte(nil)
+	if s.head != nil {
+		rawEnt = s.head.Get(rawKey)
+	}
+	if rawEnt == nil && s.tombstones != nil {
+		rawEnt = s.tombstones.Get(rawKey)
+	}
 	if rawEnt == nil {
 		// entity doesn't exist at head
 		return nil
@@ -127,14 +157,14 @@ func (s *normalStrategy) handle(rawData [][]byte, _ []ds.Property, key *ds.Key,
 	return s.cb(key, pm, gc)
 }
 
-func pickQueryStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB, head *memStore) queryStrategy {
+func pickQueryStrategy(fq *ds.FinalizedQuery, rq *reducedQuery, cb ds.RawRunCB, idx, head *memStore) queryStrategy {
 	if fq.KeysOnly() {
 		return &keysOnlyStrategy{cb, stringset.New(0)}
 	}
 	if len(fq.Project()) > 0 {
 		return newProjectionStrategy(fq, rq, cb)
 	}
-	return newNormalStrategy(rq.aid, rq.ns, cb, head)
+	return newNormalStrategy(rq.aid, rq.ns, cb, idx, head)
 }
 
 func parseSuffix(aid, ns string, suffixFormat []ds.IndexColumn, suffix []byte, count int) (raw [][]byte, decoded []ds.Property) {
@@ -178,7 +208,86 @@ func countQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *me
 	return
 }
 
+// scatterOrder sorts keys by their hashes, which stand in for their
+// __scatter__ values.
+type scatterOrder struct {
+	desc   bool
+	keys   []*ds.Key
+	hashes []string
+}
+
+func (s scatterOrder) Len() int { return len(s.keys) }
+func (s scatterOrder) Less(i, j int) bool {
+	if s.desc {
+		return s.hashes[i] > s.hashes[j]
+	}
+	return s.hashes[i] < s.hashes[j]
+}
+func (s scatterOrder) Swap(i, j int) {
+	s.keys[i], s.keys[j] = s.keys[j], s.keys[i]
+	s.hashes[i], s.hashes[j] = s.hashes[j], s.hashes[i]
+}
+
+// executeScatterQuery emulates ordering on __scatter__. Production only
+// stores scatter values for a random sample of the entities, but here every
+// entity is in the "sample", ordered by a hash of its key.
+func executeScatterQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *memStore, cb ds.RawRunCB) error {
+	if !fq.KeysOnly() || len(fq.Orders()) != 2 {
+		return fmt.Errorf(
+			"queries ordered by %s must be keys-only, with no other orders", ds.ScatterProperty)
+	}
+	desc := fq.Orders()[0].Descending
+
+	unordered, err := fq.Original().ClearOrder().Limit(-1).Offset(-1).Finalize()
+	if err != nil {
+		return err
+	}
+	keys := []*ds.Key{}
+	err = executeQuery(unordered, aid, ns, isTxn, idx, head, func(k *ds.Key, _ ds.PropertyMap, _ ds.CursorCB) error {
+		keys = append(keys, k)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sorted := scatterOrder{desc: desc, keys: keys, hashes: make([]string, len(keys))}
+	for i, k := range keys {
+		h := sha1.Sum(serialize.ToBytes(k))
+		sorted.hashes[i] = string(h[:])
+	}
+	sort.Sort(sorted)
+
+	offset, _ := fq.Offset()
+	if int(offset) >= len(keys) {
+		return nil
+	}
+	keys = keys[offset:]
+	if limit, ok := fq.Limit(); ok && int(limit) < len(keys) {
+		keys = keys[:limit]
+	}
+	noCursor := func() (ds.Cursor, error) {
+		return nil, fmt.Errorf("cursors aren't supported on queries ordered by %s", ds.ScatterProperty)
+	}
+	for _, k := range keys {
+		if err := cb(k, nil, noCursor); err != nil {
+			if err == ds.Stop {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
 func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *memStore, cb ds.RawRunCB) error {
+	if isMetadataQuery(fq) {
+		return executeMetadataQuery(fq, aid, ns, isTxn, idx, cb)
+	}
+	if orders := fq.Orders(); len(orders) > 0 && orders[0].Property == ds.ScatterProperty {
+		return executeScatterQuery(fq, aid, ns, isTxn, idx, head, cb)
+	}
+
 	rq, err := reduce(fq, aid, ns, isTxn)
 	if err == ds.ErrNullQuery {
 		return nil
@@ -191,20 +300,45 @@ func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *
 	if err == ds.ErrNullQuery {
 		return nil
 	}
+	if mi, ok := err.(*ErrMissingIndex); ok {
+		mi.Query = fq
+	}
 	if err != nil {
 		return err
 	}
 
-	strategy := pickQueryStrategy(fq, rq, cb, head)
+	// The offset and limit count results, like in production, and not index
+	// rows, which the strategies may skip (e.g. duplicate rows for
+	// multi-valued properties, or rows of DISTINCT projections).
+	offset, _ := fq.Offset()
+	limit, hasLimit := fq.Limit()
+	if hasLimit && limit <= 0 {
+		return nil
+	}
+	userCB := cb
+	cb = func(key *ds.Key, pm ds.PropertyMap, gc ds.CursorCB) error {
+		if offset > 0 {
+			offset--
+			return nil
+		}
+		if err := userCB(key, pm, gc); err != nil {
+			return err
+		}
+		if hasLimit {
+			if limit--; limit <= 0 {
+				return ds.Stop
+			}
+		}
+		return nil
+	}
+
+	strategy := pickQueryStrategy(fq, rq, cb, idx, head)
 	if strategy == nil {
 		// e.g. the normalStrategy found that there were NO entities in the current
 		// namespace.
 		return nil
 	}
 
-	offset, _ := fq.Offset()
-	limit, hasLimit := fq.Limit()
-
 	cursorPrefix := []byte(nil)
 	getCursorFn := func(suffix []byte) func() (ds.Cursor, error) {
 		return func() (ds.Cursor, error) {
@@ -226,17 +360,6 @@ func executeQuery(fq *ds.FinalizedQuery, aid, ns string, isTxn bool, idx, head *
 	}
 
 	return multiIterate(idxs, func(suffix []byte) error {
-		if offset > 0 {
-			offset--
-			return nil
-		}
-		if hasLimit {
-			if limit <= 0 {
-				return ds.Stop
-			}
-			limit--
-		}
-
 		rawData, decodedProps := parseSuffix(aid, ns, rq.suffixFormat, suffix, -1)
 
 		keyProp := decodedProps[len(decodedProps)-1]
@@ -164,6 +164,12 @@ var queryExecutionTests = []qExTest{
 					stage1Data[2],
 				}},
 
+				// The offset and limit count entities, not the index rows of their
+				// multi-valued properties.
+				{q: nq("Kind").Order("Val").Offset(2).Limit(1), get: []ds.PropertyMap{
+					stage1Data[3],
+				}},
+
 				{q: nq("Missing"), get: []ds.PropertyMap{}},
 
 				{q: nq("Missing").Eq("Bogus", 3), get: []ds.PropertyMap{}},
@@ -241,9 +247,8 @@ var queryExecutionTests = []qExTest{
 				}},
 
 				{q: nq("").Gt("__key__", key("Kind", 2)),
-					// count counts from the index with KeysOnly and so counts the deleted
-					// entity Unique/1.
-					count: 8,
+					// The deleted entity Unique/1 is still returned from the index
+					// snapshot, since the query is eventually consistent.
 					get: []ds.PropertyMap{
 						// TODO(riannucci): determine if the real datastore shows metadata
 						// during kindless queries. The documentation seems to imply so, but
@@ -259,6 +264,7 @@ var queryExecutionTests = []qExTest{
 						stage1Data[3],
 						pmap("$key", key("Kind", 6, "__entity_group__", 1), Next,
 							"__version__", 1),
+						stage1Data[5],
 						pmap("$key", key("Unique", 1, "__entity_group__", 1), Next,
 							"__version__", 2),
 					}},
@@ -325,6 +331,21 @@ var queryExecutionTests = []qExTest{
 							"Val", 3),
 					}},
 
+				{q: (nq("Kind").
+					Order("-Extra", "-Val").
+					Ancestor(key("Kind", 3)).Project("Extra", "Val").DistinctOn("Extra")),
+					get: []ds.PropertyMap{
+						pmap("$key", key("Kind", 3), Next,
+							"Extra", "waffle", Next,
+							"Val", 100),
+						pmap("$key", key("Kind", 3, "Kind", 3), Next,
+							"Extra", "nuts", Next,
+							"Val", 4),
+						pmap("$key", key("Kind", 3, "Kind", 1), Next,
+							"Extra", "hello", Next,
+							"Val", 28),
+					}},
+
 				// Projecting a complex type (time), gets the index type (int64)
 				// instead. Additionally, mixed-types within the same index type are
 				// smooshed together in the result.
@@ -401,10 +422,10 @@ var queryExecutionTests = []qExTest{
 
 		{
 			expect: []qExpect{
-				// eventual consistency; Unique/1 is deleted at HEAD. Keysonly finds it,
-				// but 'normal' doesn't.
+				// Unique/1 was deleted, and the indexes have caught up, so its rows
+				// (even the ones which only hold its key) are gone.
 				{q: nq("Unique").Gt("__key__", key("AKind", 5)).Lte("__key__", key("Zeta", "prime")),
-					keys: []*ds.Key{key("Unique", 1)},
+					keys: []*ds.Key{},
 					get:  []ds.PropertyMap{}},
 
 				{q: nq("Kind").Eq("Val", 1, 3), get: []ds.PropertyMap{
@@ -589,7 +610,7 @@ func TestQueryExecution(t *testing.T) {
 		q := nq("Kind").Gt("Val", 2).Order("Val", "Extra")
 
 		count, err := data.Count(q)
-		So(err, ShouldErrLike, "Insufficient indexes")
+		So(err, ShouldErrLike, "Insufficient indexes for query:\n  SELECT __key__ FROM `Kind` WHERE `Val` > 2 ORDER BY `Val`, `Extra`")
 
 		testing.AutoIndex(true)
 
@@ -176,3 +176,54 @@ func TestQueries(t *testing.T) {
 		})
 	})
 }
+
+func TestEstimateCost(t *testing.T) {
+	t.Parallel()
+
+	Convey("EstimateCost", t, func() {
+		estimate := func(q *dstore.Query) QueryCost {
+			fq, err := q.Finalize()
+			So(err, ShouldErrLike, nil)
+			cost, err := EstimateCost(fq)
+			So(err, ShouldErrLike, nil)
+			return cost
+		}
+
+		Convey("uses builtin indexes", func() {
+			So(estimate(nq()), ShouldResemble, QueryCost{RowsPerResult: 1})
+			So(estimate(nq().Eq("A", 1).Lt("__key__", key("Foo", 10))), ShouldResemble,
+				QueryCost{RowsPerResult: 1})
+			So(estimate(nq().KeysOnly(true).Ancestor(key("Parent", 1))), ShouldResemble,
+				QueryCost{RowsPerResult: 1, IndexOnly: true})
+			So(estimate(nq().Project("A")), ShouldResemble,
+				QueryCost{RowsPerResult: 1, IndexOnly: true})
+		})
+
+		Convey("merges builtin indexes for equality filters", func() {
+			So(estimate(nq().Eq("A", 1).Eq("B", 2)), ShouldResemble, QueryCost{RowsPerResult: 2})
+			So(estimate(nq().Eq("Tag", "a", "b")), ShouldResemble, QueryCost{RowsPerResult: 2})
+		})
+
+		Convey("reports the composite index it needs", func() {
+			So(estimate(nq().Eq("A", 1).Order("-B")), ShouldResemble, QueryCost{
+				Index: indx("Foo", "A", "-B"), RowsPerResult: 1})
+			So(estimate(nq().Ancestor(key("Parent", 1)).Order("A")), ShouldResemble, QueryCost{
+				Index: indx("Foo!", "A"), RowsPerResult: 1})
+		})
+
+		Convey("is free for kindless queries", func() {
+			So(estimate(nq("").Ancestor(key("Parent", 1))), ShouldResemble, QueryCost{RowsPerResult: 1})
+		})
+
+		Convey("rejects invalid queries", func() {
+			q := nq()
+			for i := 0; i < 100; i++ {
+				q = q.Eq("something", i)
+			}
+			fq, err := q.Finalize()
+			So(err, ShouldErrLike, nil)
+			_, err = EstimateCost(fq)
+			So(err, ShouldErrLike, "query is too large")
+		})
+	})
+}
@@ -0,0 +1,111 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/luci/gkvlite"
+	"golang.org/x/net/context"
+)
+
+// ErrReadOnlySnapshot is returned by writes in a context returned by
+// Testable().ReadSnapshot().
+var ErrReadOnlySnapshot = errors.New("datastore: the datastore is a read-only snapshot")
+
+// readSnapshot is the frozen state of the datastore in a context returned by
+// Testable().ReadSnapshot(). idx is the index state which eventually
+// consistent queries see, and head is everything else.
+type readSnapshot struct {
+	idx  *memStore
+	head *memStore
+}
+
+func (*readSnapshot) ImATestingSnapshot() {}
+
+// restore replaces the state of d with snap (see Testable.Restore).
+func (d *dataStoreData) restore(snap *readSnapshot) {
+	// gkvlite snapshots are read-only, so head has to be copied. idx is only
+	// ever read, so it can be used as it is.
+	head := copyMemStore(snap.head)
+
+	d.rwlock.Lock()
+	defer d.rwlock.Unlock()
+	d.head = head
+	if d.snap != nil {
+		d.snap = snap.idx
+	}
+}
+
+// copyMemStore returns a writable copy of every collection in ms.
+func copyMemStore(ms *memStore) *memStore {
+	ret := newMemStore()
+	for _, name := range ms.GetCollectionNames() {
+		dst := ret.SetCollection(name, nil)
+		ms.GetCollection(name).VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+			dst.Set(i.Key, i.Val)
+			return true
+		})
+	}
+	return ret
+}
+
+// snapDsImpl is the datastore in a read snapshot's context.
+type snapDsImpl struct {
+	data *dataStoreData
+	snap *readSnapshot
+	ns   string
+}
+
+var _ ds.RawInterface = (*snapDsImpl)(nil)
+
+func (d *snapDsImpl) AllocateIDs(*ds.Key, int) (int64, error) {
+	return 0, ErrReadOnlySnapshot
+}
+
+func (d *snapDsImpl) PutMulti([]*ds.Key, []ds.PropertyMap, ds.PutMultiCB) error {
+	return ErrReadOnlySnapshot
+}
+
+func (d *snapDsImpl) GetMulti(keys []*ds.Key, _meta ds.MultiMetaGetter, cb ds.GetMultiCB) error {
+	return getMultiInner(keys, cb, func() (*memCollection, error) {
+		return d.snap.head.GetCollection("ents:" + keys[0].Namespace()), nil
+	})
+}
+
+func (d *snapDsImpl) DeleteMulti([]*ds.Key, ds.DeleteMultiCB) error {
+	return ErrReadOnlySnapshot
+}
+
+func (d *snapDsImpl) DecodeCursor(s string) (ds.Cursor, error) {
+	return newCursor(s)
+}
+
+// queryIdx returns the index state which fq sees.
+func (d *snapDsImpl) queryIdx(fq *ds.FinalizedQuery) *memStore {
+	if fq.EventuallyConsistent() {
+		return d.snap.idx
+	}
+	return d.snap.head
+}
+
+// Run executes queries against the snapshot. Like in transactions, AutoIndex
+// has no effect, since indexes added now wouldn't be in the snapshot.
+func (d *snapDsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
+	return executeQuery(fq, d.data.aid, d.ns, false, d.queryIdx(fq), d.snap.head, cb)
+}
+
+func (d *snapDsImpl) Count(fq *ds.FinalizedQuery) (int64, error) {
+	return countQuery(fq, d.data.aid, d.ns, false, d.queryIdx(fq), d.snap.head)
+}
+
+func (*snapDsImpl) RunInTransaction(func(c context.Context) error, *ds.TransactionOptions) error {
+	return ErrReadOnlySnapshot
+}
+
+func (*snapDsImpl) Testable() ds.Testable {
+	return nil
+}
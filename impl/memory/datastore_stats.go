@@ -0,0 +1,175 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memory
+
+import (
+	"bytes"
+	"strings"
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+	"github.com/luci/gkvlite"
+	"github.com/luci/luci-go/common/stringset"
+)
+
+// The kinds of the statistics entities, like the production datastore's:
+//   - __Stat_Total__ and __Stat_Kind__ (one per kind) are in the default
+//     namespace, and describe the entities of every namespace.
+//   - __Stat_Ns_Total__ and __Stat_Ns_Kind__ are in each namespace with
+//     entities, and describe the entities of that namespace.
+const (
+	statTotalKind   = "__Stat_Total__"
+	statKindKind    = "__Stat_Kind__"
+	statNsTotalKind = "__Stat_Ns_Total__"
+	statNsKindKind  = "__Stat_Ns_Kind__"
+
+	// statTotalName is the StringID of the total statistics entities.
+	statTotalName = "total_entity_usage"
+)
+
+func isStatKind(kind string) bool {
+	switch kind {
+	case statTotalKind, statKindKind, statNsTotalKind, statNsKindKind:
+		return true
+	}
+	return false
+}
+
+// usageStats is the usage of some entities. The sizes are those of the
+// memory datastore's encodings, so they only approximate production's.
+type usageStats struct {
+	count               int64
+	entityBytes         int64
+	builtinIndexCount   int64
+	builtinIndexBytes   int64
+	compositeIndexCount int64
+	compositeIndexBytes int64
+}
+
+func (s *usageStats) add(o *usageStats) {
+	s.count += o.count
+	s.entityBytes += o.entityBytes
+	s.builtinIndexCount += o.builtinIndexCount
+	s.builtinIndexBytes += o.builtinIndexBytes
+	s.compositeIndexCount += o.compositeIndexCount
+	s.compositeIndexBytes += o.compositeIndexBytes
+}
+
+func (s *usageStats) propertyMap(now time.Time) ds.PropertyMap {
+	return ds.PropertyMap{
+		"count":                 {ds.MkProperty(s.count)},
+		"bytes":                 {ds.MkProperty(s.entityBytes + s.builtinIndexBytes + s.compositeIndexBytes)},
+		"entity_bytes":          {ds.MkProperty(s.entityBytes)},
+		"builtin_index_count":   {ds.MkProperty(s.builtinIndexCount)},
+		"builtin_index_bytes":   {ds.MkProperty(s.builtinIndexBytes)},
+		"composite_index_count": {ds.MkProperty(s.compositeIndexCount)},
+		"composite_index_bytes": {ds.MkProperty(s.compositeIndexBytes)},
+		"timestamp":             {ds.MkProperty(now)},
+	}
+}
+
+// entityUsage returns the usage of a single entity, whose encoded key and
+// PropertyMap are rawKey and rawEnt.
+func entityUsage(key *ds.Key, rawKey, rawEnt []byte, compIdx []*ds.IndexDefinition) *usageStats {
+	pm, err := serialize.ReadPropertyMap(bytes.NewBuffer(rawEnt), serialize.WithoutContext, key.AppID(), key.Namespace())
+	memoryCorruption(err)
+
+	ret := &usageStats{count: 1, entityBytes: int64(len(rawKey) + len(rawEnt))}
+	builtins := stringset.New(0)
+	for _, idx := range defaultIndexes(key.Kind(), pm) {
+		builtins.Add(idxCollName(key.Namespace(), idx.Normalize()))
+	}
+	rows := indexEntriesWithBuiltins(key, pm, compIdx)
+	for _, name := range rows.GetCollectionNames() {
+		if name == "idx" {
+			continue
+		}
+		count, size := &ret.compositeIndexCount, &ret.compositeIndexBytes
+		if builtins.Has(name) {
+			count, size = &ret.builtinIndexCount, &ret.builtinIndexBytes
+		}
+		rows.GetCollection(name).VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+			*count++
+			*size += int64(len(i.Key))
+			return true
+		})
+	}
+	return ret
+}
+
+// updateStatistics replaces the statistics entities with ones describing the
+// entities as of now.
+func (d *dataStoreData) updateStatistics(now time.Time) {
+	_, head := d.getQuerySnaps(true)
+	compIdx := []*ds.IndexDefinition{}
+	walkCompIdxs(head, nil, func(i *ds.IndexDefinition) bool {
+		compIdx = append(compIdx, i)
+		return true
+	})
+
+	// Namespaces with old statistics entities must have them deleted, even if
+	// they don't have any entities any more.
+	namespaces := []string{""}
+	for _, name := range head.GetCollectionNames() {
+		if strings.HasPrefix(name, "ents:") && name != "ents:" {
+			namespaces = append(namespaces, name[len("ents:"):])
+		}
+	}
+
+	total, kinds := &usageStats{}, map[string]*usageStats{}
+	for _, ns := range namespaces {
+		old := []*ds.Key{}
+		nsTotal, nsKinds := &usageStats{}, map[string]*usageStats{}
+		if coll := head.GetCollection("ents:" + ns); coll != nil {
+			coll.VisitItemsAscend(nil, true, func(i *gkvlite.Item) bool {
+				p, err := serialize.ReadProperty(bytes.NewBuffer(i.Key), serialize.WithoutContext, d.aid, ns)
+				memoryCorruption(err)
+				key := p.Value().(*ds.Key)
+				switch {
+				case isStatKind(key.Kind()):
+					old = append(old, key)
+				case !key.LastTok().Special():
+					u := entityUsage(key, i.Key, i.Val, compIdx)
+					nsTotal.add(u)
+					if nsKinds[key.Kind()] == nil {
+						nsKinds[key.Kind()] = &usageStats{}
+					}
+					nsKinds[key.Kind()].add(u)
+				}
+				return true
+			})
+		}
+
+		if len(old) > 0 {
+			d.delMulti(old, newCommit(now), nil)
+		}
+		if nsTotal.count > 0 {
+			d.putStatistics(ns, statNsTotalKind, statNsKindKind, nsTotal, nsKinds, now)
+		}
+		total.add(nsTotal)
+		for kind, u := range nsKinds {
+			if kinds[kind] == nil {
+				kinds[kind] = &usageStats{}
+			}
+			kinds[kind].add(u)
+		}
+	}
+	if total.count > 0 {
+		d.putStatistics("", statTotalKind, statKindKind, total, kinds, now)
+	}
+}
+
+func (d *dataStoreData) putStatistics(ns, totalKind, perKind string, total *usageStats, kinds map[string]*usageStats, now time.Time) {
+	keys := []*ds.Key{ds.NewKey(d.aid, ns, totalKind, statTotalName, 0, nil)}
+	vals := []ds.PropertyMap{total.propertyMap(now)}
+	for kind, u := range kinds {
+		pm := u.propertyMap(now)
+		pm["kind_name"] = []ds.Property{ds.MkProperty(kind)}
+		keys = append(keys, ds.NewKey(d.aid, ns, perKind, kind, 0, nil))
+		vals = append(vals, pm)
+	}
+	d.putMulti(keys, vals, newCommit(now), nil)
+}
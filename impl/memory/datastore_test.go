@@ -5,13 +5,22 @@
 package memory
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	dsS "github.com/tetrafolium/gae/service/datastore"
 	"github.com/tetrafolium/gae/service/datastore/serialize"
 	infoS "github.com/tetrafolium/gae/service/info"
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
+	"github.com/luci/luci-go/common/mathrand"
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
@@ -474,6 +483,54 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 			})
 		})
 
+		Convey("Testable.SetConsistencyProbability", func() {
+			q := dsS.NewQuery("Foo")
+			count := func(ds dsS.Interface) int64 {
+				n, err := ds.Count(q)
+				So(err, ShouldBeNil)
+				return n
+			}
+
+			Convey("1 makes every write visible", func() {
+				ds.Testable().SetConsistencyProbability(1)
+				So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+				So(count(ds), ShouldEqual, 1)
+				So(ds.Delete(ds.MakeKey("Foo", 1)), ShouldBeNil)
+				So(count(ds), ShouldEqual, 0)
+				So(ds.RunInTransaction(func(c context.Context) error {
+					return dsS.Get(c).Put(&Foo{ID: 2, Val: 2})
+				}, nil), ShouldBeNil)
+				So(count(ds), ShouldEqual, 1)
+			})
+
+			// visibleAfter puts 20 entities with the given consistency probability,
+			// and returns how many of the puts were immediately visible.
+			visibleAfter := func(p float64) int {
+				ds := dsS.Get(mathrand.Set(c, rand.New(rand.NewSource(0))))
+				ds.Testable().SetConsistencyProbability(p)
+				visible := 0
+				for i := 1; i <= 20; i++ {
+					So(ds.Put(&Foo{ID: int64(i), Val: i}), ShouldBeNil)
+					if count(ds) == int64(i) {
+						visible++
+					}
+				}
+				return visible
+			}
+
+			Convey("less than 1 makes some writes visible", func() {
+				So(visibleAfter(0.5), ShouldEqual, 10)
+			})
+
+			Convey("1 makes all of them visible", func() {
+				So(visibleAfter(1), ShouldEqual, 20)
+			})
+
+			Convey("0 makes none of them visible", func() {
+				So(visibleAfter(0), ShouldEqual, 0)
+			})
+		})
+
 		Convey("Testable.Consistent", func() {
 			Convey("false", func() {
 				ds.Testable().Consistent(false) // the default
@@ -497,6 +554,29 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 				So(count, ShouldEqual, 6)
 			})
 
+			Convey("false returns deleted entities until indexes catch up", func() {
+				So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+				ds.Testable().CatchupIndexes()
+				So(ds.Delete(ds.MakeKey("Foo", 1)), ShouldBeNil)
+
+				foos := []*Foo(nil)
+				So(ds.GetAll(dsS.NewQuery("Foo"), &foos), ShouldBeNil)
+				So(foos, ShouldResemble, []*Foo{{ID: 1, Val: 1}})
+
+				Convey("but not from ancestor queries", func() {
+					foos = nil
+					So(ds.GetAll(dsS.NewQuery("Foo").Ancestor(ds.MakeKey("Foo", 1)), &foos), ShouldBeNil)
+					So(foos, ShouldBeEmpty)
+				})
+
+				Convey("or once they've caught up", func() {
+					ds.Testable().CatchupIndexes()
+					foos = nil
+					So(ds.GetAll(dsS.NewQuery("Foo"), &foos), ShouldBeNil)
+					So(foos, ShouldBeEmpty)
+				})
+			})
+
 			Convey("true", func() {
 				ds.Testable().Consistent(true)
 				for i := 0; i < 10; i++ {
@@ -515,6 +595,180 @@ func TestDatastoreSingleReadWriter(t *testing.T) {
 			})
 		})
 
+		Convey("Testable index rows", func() {
+			for i := 0; i < 3; i++ {
+				So(ds.Put(&Foo{ID: int64(i + 1), Val: i + 1}), ShouldBeNil)
+			}
+			byVal := &dsS.IndexDefinition{Kind: "Foo", SortBy: []dsS.IndexColumn{{Property: "Val"}}}
+			So(ds.Testable().GetIndexRows(byVal), ShouldBeEmpty)
+
+			ds.Testable().CatchupIndexes()
+			rows := ds.Testable().GetIndexRows(byVal)
+			So(len(rows), ShouldEqual, 3)
+			So(rows[0], ShouldResemble, append(
+				serialize.ToBytes(dsS.MkProperty(1)),
+				serialize.ToBytes(dsS.MkProperty(ds.MakeKey("Foo", 1)))...))
+
+			vals := func() (ret []int) {
+				foos := []*Foo(nil)
+				So(ds.GetAll(dsS.NewQuery("Foo").Gt("Val", 0), &foos), ShouldBeNil)
+				for _, f := range foos {
+					ret = append(ret, f.Val)
+				}
+				return
+			}
+			So(vals(), ShouldResemble, []int{1, 2, 3})
+
+			ds.Testable().SetIndexRows(byVal, [][]byte{rows[2], rows[0]})
+			So(vals(), ShouldResemble, []int{1, 2, 3})
+			ds.Testable().CatchupIndexes()
+			So(ds.Testable().GetIndexRows(byVal), ShouldResemble, [][]byte{rows[0], rows[2]})
+			So(vals(), ShouldResemble, []int{1, 3})
+
+			Convey("of compound indexes", func() {
+				comp := &dsS.IndexDefinition{Kind: "Foo", Ancestor: true, SortBy: []dsS.IndexColumn{{Property: "Val"}}}
+				q := dsS.NewQuery("Foo").Ancestor(ds.MakeKey("Foo", 1)).Order("Val")
+				So(ds.GetAll(q, &[]*Foo{}), ShouldErrLike, "Insufficient indexes")
+
+				ds.Testable().SetIndexRows(comp, nil)
+				ds.Testable().CatchupIndexes()
+				So(ds.Testable().GetIndexRows(comp), ShouldBeNil)
+				foos := []*Foo(nil)
+				So(ds.GetAll(q, &foos), ShouldBeNil)
+				So(foos, ShouldBeEmpty)
+			})
+		})
+
+		Convey("Testable.LoadIndexYAML and RequireIndexes", func() {
+			ds.Testable().Consistent(true)
+			for i := 1; i <= 3; i++ {
+				So(ds.Put(&Foo{ID: int64(i), Val: i}), ShouldBeNil)
+			}
+			q := dsS.NewQuery("Foo").Eq("Val", 2).Order("-__key__")
+			count := func() (int64, error) { return ds.Count(q) }
+
+			_, err := count()
+			So(err, ShouldErrLike, "Insufficient indexes")
+
+			So(ds.Testable().LoadIndexYAML(strings.NewReader(`
+indexes:
+- kind: Foo
+  properties:
+  - name: Val
+- kind: Foo
+  properties:
+  - name: Val
+  - name: __key__
+    direction: desc
+`)), ShouldBeNil)
+			n, err := count()
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+
+			Convey("bad files", func() {
+				So(ds.Testable().LoadIndexYAML(strings.NewReader("nope: []")), ShouldErrLike, "missing key `indexes`")
+				So(ds.Testable().LoadIndexYAML(strings.NewReader(`
+indexes:
+- properties:
+  - name: Val
+  - name: Other
+`)), ShouldErrLike, "invalid index")
+			})
+
+			Convey("RequireIndexes overrides AutoIndex", func() {
+				ds.Testable().AutoIndex(true)
+				ds.Testable().RequireIndexes(true)
+				q := dsS.NewQuery("Foo").Gt("Val", 0).Order("-Val", "-__key__")
+				_, err := ds.Count(q)
+				So(err, ShouldErrLike, "Insufficient indexes")
+
+				ds.Testable().RequireIndexes(false)
+				_, err = ds.Count(q)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("Testable.UsedIndexes", func() {
+			ds.Testable().Consistent(true)
+			ds.Testable().AutoIndex(true)
+			So(ds.Put(&Foo{ID: 1, Val: 1}), ShouldBeNil)
+
+			So(ds.Testable().UsedIndexes(), ShouldBeEmpty)
+			_, err := ds.Count(dsS.NewQuery("Foo").Eq("Val", 1))
+			So(err, ShouldBeNil)
+			So(ds.Testable().UsedIndexes(), ShouldBeEmpty)
+
+			So(ds.Run(dsS.NewQuery("Foo").Gt("Val", 0).Order("-Val", "-__key__"), func(*Foo) {}), ShouldBeNil)
+			So(ds.RunInTransaction(func(c context.Context) error {
+				_, err := dsS.Get(c).Count(dsS.NewQuery("Foo").Ancestor(ds.MakeKey("Foo", 1)).Order("Val"))
+				return err
+			}, nil), ShouldErrLike, "Insufficient indexes") // recorded anyway
+			_, err = ds.Count(dsS.NewQuery("Foo").Gt("Val", 0).Order("-Val"))
+			So(err, ShouldBeNil)
+
+			used := ds.Testable().UsedIndexes()
+			So(len(used), ShouldEqual, 2)
+			So(used[0].String(), ShouldEqual, "C:Foo|A/Val")
+			So(used[1].String(), ShouldEqual, "C:Foo/-Val/-__key__")
+
+			buf := &bytes.Buffer{}
+			So(ds.Testable().WriteUsedIndexYAML(buf), ShouldBeNil)
+			So(buf.String(), ShouldEqual, `indexes:
+
+- kind: Foo
+  ancestor: yes
+  properties:
+  - name: Val
+
+- kind: Foo
+  properties:
+  - name: Val
+    direction: desc
+  - name: __key__
+    direction: desc
+`)
+			parsed, err := dsS.ParseIndexYAML(buf)
+			So(err, ShouldBeNil)
+			So(parsed, ShouldResemble, used)
+		})
+
+		Convey("Testable.AllowReservedKinds", func() {
+			put := func(k *dsS.Key) error {
+				return ds.Put(dsS.PropertyMap{"$key": {dsS.MkPropertyNI(k)}, "Val": {dsS.MkProperty(1)}})
+			}
+			reserved := []*dsS.Key{
+				ds.MakeKey("__Stat_Kind__", "Foo"),
+				ds.MakeKey("__Backup", 1),
+				ds.MakeKey("Foo", "__name__"),
+			}
+			for _, k := range reserved {
+				So(put(k), ShouldErrLike, "reserved")
+				So(ds.Delete(k), ShouldErrLike, "reserved")
+			}
+			So(put(ds.MakeKey("Foo", "__")), ShouldBeNil)
+
+			ds.Testable().AllowReservedKinds(true)
+			So(ds.Testable().ReservedKindsAllowed(), ShouldBeTrue)
+			for _, k := range reserved {
+				So(put(k), ShouldBeNil)
+				So(ds.Delete(k), ShouldBeNil)
+			}
+
+			Convey("but not over-long keys", func() {
+				So(put(ds.MakeKey("Foo", strings.Repeat("x", 1501))), ShouldErrLike,
+					`key of kind "Foo" has a name of 1501 bytes, more than the limit of 1500`)
+
+				toks := make([]dsS.KeyTok, 101)
+				for i := range toks {
+					toks[i] = dsS.KeyTok{Kind: "Foo", IntID: 1}
+				}
+				k := dsS.NewKeyToks(ds.MakeKey("Foo", 1).AppID(), "", toks)
+				So(put(k), ShouldErrLike, "has 101 elements, more than the limit of 100")
+				So(ds.Delete(k), ShouldErrLike, "has 101 elements")
+				So(put(dsS.NewKeyToks(k.AppID(), "", toks[1:])), ShouldBeNil)
+			})
+		})
+
 		Convey("Testable.DisableSpecialEntities", func() {
 			ds.Testable().DisableSpecialEntities(true)
 
@@ -631,3 +885,671 @@ func TestNewDatastore(t *testing.T) {
 		So(vals[1]["Value"][0].Value(), ShouldEqual, 30)
 	})
 }
+
+func TestProjectionIntoStruct(t *testing.T) {
+	t.Parallel()
+
+	Convey("Projection queries decode into struct fields", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			When  time.Time
+			Data  []byte
+			Name  string
+			Other int64
+		}
+
+		ds, err := NewDatastore("aid", "ns")
+		So(err, ShouldBeNil)
+
+		when := time.Date(2016, time.January, 2, 3, 4, 5, 0, time.UTC)
+		So(ds.Put(&Model{ID: 1, When: when, Data: []byte("hi"), Name: "bob", Other: 7}), ShouldBeNil)
+
+		vals := []*Model{}
+		So(ds.GetAll(dsS.NewQuery("Model").Project("When", "Data"), &vals), ShouldBeNil)
+		So(vals, ShouldResemble, []*Model{{ID: 1, When: when, Data: []byte("hi")}})
+	})
+}
+
+func TestRunProjection(t *testing.T) {
+	t.Parallel()
+
+	Convey("RunProjection", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Name  string
+			Age   int64
+			Tags  []string
+			Other int64
+		}
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().AutoIndex(true)
+		ds.Testable().Consistent(true)
+		So(ds.Put(&Model{ID: 1, Name: "bob", Age: 30, Tags: []string{"a", "b"}, Other: 7}), ShouldBeNil)
+		So(ds.Put(&Model{ID: 2, Name: "sue", Age: 20, Other: 8}), ShouldBeNil)
+
+		Convey("loads the projected properties", func() {
+			type Summary struct {
+				ID   int64 `gae:"$id"`
+				Name string
+				Age  int64
+			}
+			sums := []Summary{}
+			So(dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name", "Age").Order("Age"), &sums), ShouldBeNil)
+			So(sums, ShouldResemble, []Summary{{2, "sue", 20}, {1, "bob", 30}})
+
+			ptrs := []*struct{ Name string }{}
+			So(dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name"), &ptrs), ShouldBeNil)
+			So(len(ptrs), ShouldEqual, 2)
+			So(ptrs[0].Name, ShouldEqual, "bob")
+		})
+
+		Convey("checks the struct against the projection", func() {
+			sums := []struct{ Name string }{}
+			So(dsS.RunProjection(c, dsS.NewQuery("Model"), &sums), ShouldErrLike, "doesn't project any properties")
+			So(dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name", "Age"), &sums),
+				ShouldErrLike, `projected property "Age" has no field`)
+
+			two := []struct{ Name, Other string }{}
+			So(dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name"), &two),
+				ShouldErrLike, `aren't projected by the query: ["Other"]`)
+
+			tags := []struct{ Tags []string }{}
+			So(dsS.RunProjection(c, dsS.NewQuery("Model").Project("Tags"), &tags), ShouldErrLike, "is a slice")
+			So(tags, ShouldBeEmpty)
+		})
+
+		Convey("panics on a bad dst", func() {
+			So(func() { dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name"), []string{}) }, ShouldPanic)
+			So(func() { dsS.RunProjection(c, dsS.NewQuery("Model").Project("Name"), &[]string{}) }, ShouldPanic)
+		})
+	})
+}
+
+func TestReverseQuery(t *testing.T) {
+	t.Parallel()
+
+	Convey("Reversed queries can page backwards from a cursor", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Value int64
+		}
+
+		ds, err := NewDatastore("aid", "ns")
+		So(err, ShouldBeNil)
+		for i := int64(1); i <= 6; i++ {
+			So(ds.Put(&Model{ID: i, Value: i % 3}), ShouldBeNil)
+		}
+
+		// page returns the IDs of the results of q, and a cursor after them.
+		page := func(q *dsS.Query) ([]int64, dsS.Cursor) {
+			ids := []int64{}
+			cursor := dsS.Cursor(nil)
+			So(ds.Run(q, func(m *Model, gc dsS.CursorCB) error {
+				ids = append(ids, m.ID)
+				cursor, err = gc()
+				return err
+			}), ShouldBeNil)
+			return ids, cursor
+		}
+
+		for _, q := range []*dsS.Query{
+			dsS.NewQuery("Model").Order("Value"),
+			dsS.NewQuery("Model").Order("-Value"),
+			dsS.NewQuery("Model").Gt("Value", 0),
+		} {
+			all, _ := page(q)
+			q = q.Limit(2)
+			first, cursor := page(q)
+			So(first, ShouldResemble, all[:2])
+			second, _ := page(q.Start(cursor))
+			So(second, ShouldResemble, all[2:4])
+
+			prev, _ := page(q.Reverse().Start(cursor))
+			So(prev, ShouldResemble, []int64{all[1], all[0]})
+
+			Convey(q.String(), func() {
+				Convey("and the cursors of reversed queries work forwards", func() {
+					_, cursor := page(q.Reverse())
+					last, _ := page(q.Reverse().Reverse().Start(cursor))
+					So(last, ShouldResemble, all[len(all)-2:])
+				})
+
+				Convey("and bound the reversed results", func() {
+					rest, _ := page(q.End(cursor).Reverse().Limit(-1))
+					So(rest, ShouldResemble, []int64{all[1], all[0]})
+				})
+			})
+		}
+	})
+}
+
+func TestRunMulti(t *testing.T) {
+	t.Parallel()
+
+	Convey("RunMulti merges queries by their sort order", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Tag   []string
+			Score int64
+		}
+
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().AutoIndex(true)
+		ds.Testable().Consistent(true)
+
+		So(ds.PutMulti([]*Model{
+			{ID: 1, Tag: []string{"a"}, Score: 10},
+			{ID: 2, Tag: []string{"b"}, Score: 30},
+			{ID: 3, Tag: []string{"a", "b"}, Score: 20},
+			{ID: 4, Tag: []string{"c"}, Score: 40},
+		}), ShouldBeNil)
+
+		qs := []*dsS.Query{
+			dsS.NewQuery("Model").Eq("Tag", "a").Order("-Score"),
+			dsS.NewQuery("Model").Eq("Tag", "b").Order("-Score"),
+		}
+
+		Convey("entities", func() {
+			ids := []int64(nil)
+			So(ds.RunMulti(qs, func(m *Model) {
+				ids = append(ids, m.ID)
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{2, 3, 1})
+		})
+
+		Convey("keys", func() {
+			ids := []int64(nil)
+			So(ds.RunMulti(qs, func(k *dsS.Key) {
+				ids = append(ids, k.IntID())
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{2, 3, 1})
+		})
+
+		Convey("limits", func() {
+			ids := []int64(nil)
+			So(ds.RunMulti([]*dsS.Query{qs[0].Limit(2), qs[1].Limit(2)}, func(k *dsS.Key) {
+				ids = append(ids, k.IntID())
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{2, 3})
+		})
+
+		Convey("expands In filters", func() {
+			q := dsS.NewQuery("Model").In("Tag", "a", "b", "d").Order("-Score")
+
+			ids := []int64(nil)
+			So(ds.Run(q, func(k *dsS.Key) {
+				ids = append(ids, k.IntID())
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{2, 3, 1})
+
+			models := []*Model(nil)
+			So(ds.GetAll(q, &models), ShouldBeNil)
+			So(len(models), ShouldEqual, 3)
+
+			count, err := ds.Count(q)
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 3)
+		})
+
+		Convey("expands Ne filters", func() {
+			q := dsS.NewQuery("Model").Ne("Score", 20)
+
+			ids := []int64(nil)
+			So(ds.Run(q, func(m *Model) {
+				ids = append(ids, m.ID)
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{1, 2, 4})
+		})
+	})
+}
+
+func TestSplitKind(t *testing.T) {
+	t.Parallel()
+
+	Convey("SplitKind splits a kind into roughly even ranges", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		pms := make([]dsS.PropertyMap, 100)
+		for i := range pms {
+			pms[i] = dsS.PropertyMap{"$key": {dsS.MkPropertyNI(ds.MakeKey("Foo", i+1))}}
+		}
+		So(ds.PutMulti(pms), ShouldBeNil)
+
+		Convey("__scatter__ returns every key once", func() {
+			keys := []*dsS.Key(nil)
+			So(ds.GetAll(dsS.NewQuery("Foo").Order(dsS.ScatterProperty).KeysOnly(true), &keys), ShouldBeNil)
+			So(len(keys), ShouldEqual, 100)
+			inKeyOrder := true
+			for i := 1; i < len(keys); i++ {
+				inKeyOrder = inKeyOrder && keys[i-1].Less(keys[i])
+			}
+			So(inKeyOrder, ShouldBeFalse)
+
+			keys = nil
+			So(ds.GetAll(dsS.NewQuery("Foo").Order(dsS.ScatterProperty).KeysOnly(true).Limit(10), &keys), ShouldBeNil)
+			So(len(keys), ShouldEqual, 10)
+
+			So(ds.GetAll(dsS.NewQuery("Foo").Order(dsS.ScatterProperty), &pms), ShouldErrLike, "must be keys-only")
+		})
+
+		Convey("ranges cover every key", func() {
+			ranges, err := dsS.SplitKind(ds, "Foo", 4)
+			So(err, ShouldBeNil)
+			So(len(ranges), ShouldEqual, 4)
+			So(ranges[0].Start, ShouldBeNil)
+			So(ranges[3].End, ShouldBeNil)
+
+			total := 0
+			for _, r := range ranges {
+				count, err := ds.Count(dsS.NewQuery("Foo").KeyRange(r))
+				So(err, ShouldBeNil)
+				So(count, ShouldEqual, 25)
+				total += int(count)
+			}
+			So(total, ShouldEqual, 100)
+		})
+
+		Convey("small kinds give fewer ranges", func() {
+			ranges, err := dsS.SplitKind(ds, "Foo", 200)
+			So(err, ShouldBeNil)
+			So(len(ranges), ShouldEqual, 100)
+
+			ranges, err = dsS.SplitKind(ds, "Bar", 4)
+			So(err, ShouldBeNil)
+			So(ranges, ShouldResemble, []dsS.KeyRange{{}})
+		})
+	})
+}
+
+func TestReadSnapshot(t *testing.T) {
+	t.Parallel()
+
+	Convey("ReadSnapshot freezes reads", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Value int64
+		}
+
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+		ds.Testable().AutoIndex(true)
+
+		So(ds.PutMulti([]*Model{{ID: 1, Value: 1}, {ID: 2, Value: 2}}), ShouldBeNil)
+		// Build the index which the snapshot's queries need up front.
+		_, err := ds.Count(dsS.NewQuery("Model").Order("-Value"))
+		So(err, ShouldBeNil)
+
+		snap := dsS.Get(ds.Testable().ReadSnapshot())
+
+		So(ds.PutMulti([]*Model{{ID: 1, Value: 10}, {ID: 3, Value: 3}}), ShouldBeNil)
+		So(ds.Delete(ds.MakeKey("Model", 2)), ShouldBeNil)
+
+		Convey("for gets", func() {
+			ms := []*Model{{ID: 1}, {ID: 2}, {ID: 3}}
+			So(snap.GetMulti(ms), ShouldResemble, errors.MultiError{nil, nil, dsS.ErrNoSuchEntity})
+			So(ms[0].Value, ShouldEqual, 1)
+			So(ms[1].Value, ShouldEqual, 2)
+		})
+
+		Convey("for queries", func() {
+			ms := []*Model{}
+			So(snap.GetAll(dsS.NewQuery("Model").Order("-Value"), &ms), ShouldBeNil)
+			So(ms, ShouldResemble, []*Model{{ID: 2, Value: 2}, {ID: 1, Value: 1}})
+
+			count, err := snap.Count(dsS.NewQuery("Model"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("while the datastore moves on", func() {
+			count, err := ds.Count(dsS.NewQuery("Model"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+
+			m := &Model{ID: 1}
+			So(ds.Get(m), ShouldBeNil)
+			So(m.Value, ShouldEqual, 10)
+		})
+
+		Convey("and rejects writes", func() {
+			So(snap.Put(&Model{ID: 4}), ShouldEqual, ErrReadOnlySnapshot)
+			So(snap.Delete(ds.MakeKey("Model", 1)), ShouldEqual, ErrReadOnlySnapshot)
+			So(snap.RunInTransaction(func(context.Context) error { return nil }, nil), ShouldEqual, ErrReadOnlySnapshot)
+		})
+	})
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	t.Parallel()
+
+	Convey("Restore returns to a Snapshot", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Value int64
+		}
+
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+		ds.Testable().AutoIndex(true)
+
+		So(ds.PutMulti([]*Model{{ID: 1, Value: 1}, {ID: 2, Value: 2}}), ShouldBeNil)
+		snap := ds.Testable().Snapshot()
+
+		values := func() []int64 {
+			ms := []*Model{}
+			So(ds.GetAll(dsS.NewQuery("Model"), &ms), ShouldBeNil)
+			ret := make([]int64, len(ms))
+			for i, m := range ms {
+				ret[i] = m.Value
+			}
+			return ret
+		}
+
+		for i := 0; i < 2; i++ {
+			So(ds.PutMulti([]*Model{{ID: 1, Value: 10}, {ID: 3, Value: 3}}), ShouldBeNil)
+			So(ds.Delete(ds.MakeKey("Model", 2)), ShouldBeNil)
+			_, err := ds.Count(dsS.NewQuery("Model").Order("-Value", "-__key__"))
+			So(err, ShouldBeNil)
+			So(values(), ShouldResemble, []int64{10, 3})
+
+			ds.Testable().Restore(snap)
+			So(values(), ShouldResemble, []int64{1, 2})
+			So(ds.Get(&Model{ID: 3}), ShouldEqual, dsS.ErrNoSuchEntity)
+
+			// The index added after the snapshot is gone too.
+			ds.Testable().AutoIndex(false)
+			_, err = ds.Count(dsS.NewQuery("Model").Order("-Value", "-__key__"))
+			So(err, ShouldErrLike, "Insufficient indexes")
+			ds.Testable().AutoIndex(true)
+		}
+
+		Convey("the restored datastore is writable", func() {
+			ds.Testable().Restore(snap)
+			So(ds.Put(&Model{ID: 4, Value: 4}), ShouldBeNil)
+			So(values(), ShouldResemble, []int64{1, 2, 4})
+		})
+	})
+}
+
+func TestPersist(t *testing.T) {
+	t.Parallel()
+
+	Convey("SaveDatastore and LoadDatastore", t, func() {
+		type Model struct {
+			ID    int64 `gae:"$id"`
+			Value int64
+		}
+
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+		ds.Testable().AddIndexes(&dsS.IndexDefinition{Kind: "Model", SortBy: []dsS.IndexColumn{
+			{Property: "Value", Descending: true},
+			{Property: "__key__", Descending: true},
+		}})
+		So(ds.PutMulti([]*Model{{ID: 1, Value: 1}, {ID: 2, Value: 2}}), ShouldBeNil)
+		start, err := ds.AllocateIDs(ds.MakeKey("Model", 0), 10)
+		So(err, ShouldBeNil)
+		nsC, err := infoS.Get(c).Namespace("ns")
+		So(err, ShouldBeNil)
+		So(dsS.Get(nsC).Put(&Model{ID: 1, Value: 3}), ShouldBeNil)
+
+		buf := &bytes.Buffer{}
+		So(SaveDatastore(c, buf), ShouldBeNil)
+
+		check := func(c context.Context) {
+			ds := dsS.Get(c)
+			ms := []*Model{}
+			So(ds.GetAll(dsS.NewQuery("Model").Gt("Value", 0).Order("-Value", "-__key__"), &ms), ShouldBeNil)
+			So(ms, ShouldResemble, []*Model{{ID: 2, Value: 2}, {ID: 1, Value: 1}})
+
+			m := &Model{ID: 1}
+			nsC, err := infoS.Get(c).Namespace("ns")
+			So(err, ShouldBeNil)
+			So(dsS.Get(nsC).Get(m), ShouldBeNil)
+			So(m.Value, ShouldEqual, 3)
+
+			next, err := ds.AllocateIDs(ds.MakeKey("Model", 0), 1)
+			So(err, ShouldBeNil)
+			So(next, ShouldEqual, start+10)
+		}
+
+		Convey("round trips", func() {
+			c := Use(context.Background())
+			So(dsS.Get(c).Put(&Model{ID: 5}), ShouldBeNil) // replaced by the load
+			So(LoadDatastore(c, buf), ShouldBeNil)
+			check(c)
+			So(dsS.Get(c).Get(&Model{ID: 5}), ShouldEqual, dsS.ErrNoSuchEntity)
+		})
+
+		Convey("through files", func() {
+			dir, err := ioutil.TempDir("", "memory_persist")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "datastore")
+
+			c2 := Use(context.Background())
+			So(LoadDatastoreFile(c2, path), ShouldBeNil) // no file yet
+
+			So(SaveDatastoreFile(c, path), ShouldBeNil)
+			So(LoadDatastoreFile(c2, path), ShouldBeNil)
+			check(c2)
+		})
+
+		Convey("rejects other data", func() {
+			So(LoadDatastore(UseWithAppID(context.Background(), "dev~other"), buf), ShouldErrLike, `saved with app ID "dev~app"`)
+			So(LoadDatastore(Use(context.Background()), strings.NewReader("nope")), ShouldErrLike, "reading saved datastore")
+			So(LoadDatastore(context.Background(), buf), ShouldEqual, ErrNotMemoryContext)
+		})
+	})
+}
+
+func TestMetadataQueries(t *testing.T) {
+	t.Parallel()
+
+	Convey("Metadata queries", t, func() {
+		c := Use(context.Background())
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		So(ds.PutMulti([]dsS.PropertyMap{
+			{"$key": {propNI(ds.MakeKey("Foo", 1))}, "A": {prop(1)}, "B": {prop("x")}, "Hidden": {propNI(true)}},
+			{"$key": {propNI(ds.MakeKey("Foo", 2))}, "A": {prop("a"), prop(nil)}},
+			{"$key": {propNI(ds.MakeKey("Bar", "b"))}, "C": {prop(1.5)}},
+		}), ShouldBeNil)
+		nsC, err := infoS.Get(c).Namespace("other")
+		So(err, ShouldBeNil)
+		So(dsS.Get(nsC).Put(dsS.PropertyMap{"$key": {propNI(dsS.Get(nsC).MakeKey("Baz", 1))}}), ShouldBeNil)
+
+		keys := func(q *dsS.Query) []string {
+			ks := []*dsS.Key{}
+			So(ds.GetAll(q.KeysOnly(true), &ks), ShouldBeNil)
+			ret := make([]string, len(ks))
+			for i, k := range ks {
+				ret[i] = k.PathString()
+			}
+			return ret
+		}
+
+		Convey("__namespace__", func() {
+			So(keys(dsS.NewQuery("__namespace__")), ShouldResemble, []string{
+				"__namespace__,1", "__namespace__,other"})
+		})
+
+		Convey("__kind__", func() {
+			So(keys(dsS.NewQuery("__kind__")), ShouldResemble, []string{
+				"__kind__,Bar", "__kind__,Foo"})
+			So(keys(dsS.NewQuery("__kind__").Gt("__key__", ds.MakeKey("__kind__", "Bar"))), ShouldResemble, []string{
+				"__kind__,Foo"})
+			So(keys(dsS.NewQuery("__kind__").Order("-__key__").Limit(1)), ShouldResemble, []string{
+				"__kind__,Foo"})
+
+			count, err := ds.Count(dsS.NewQuery("__kind__"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 2)
+		})
+
+		Convey("__property__", func() {
+			So(keys(dsS.NewQuery("__property__")), ShouldResemble, []string{
+				"__kind__,Bar/__property__,C",
+				"__kind__,Foo/__property__,A",
+				"__kind__,Foo/__property__,B",
+			})
+
+			props := []dsS.PropertyMap{}
+			So(ds.GetAll(dsS.NewQuery("__property__").Ancestor(ds.MakeKey("__kind__", "Foo")), &props), ShouldBeNil)
+			So(len(props), ShouldEqual, 2)
+			So(props[0]["property_representation"], ShouldResemble, []dsS.Property{prop("INT64"), prop("NULL"), prop("STRING")})
+			So(props[1]["property_representation"], ShouldResemble, []dsS.Property{prop("STRING")})
+		})
+
+		Convey("bad queries", func() {
+			So(ds.GetAll(dsS.NewQuery("__kind__").Eq("A", 1), &[]dsS.PropertyMap{}), ShouldErrLike, "may only filter on __key__")
+			So(ds.GetAll(dsS.NewQuery("__kind__").Ancestor(ds.MakeKey("Foo", 1)), &[]dsS.PropertyMap{}), ShouldErrLike, "may have an ancestor")
+		})
+	})
+}
+
+func TestUpdateStatistics(t *testing.T) {
+	t.Parallel()
+
+	Convey("UpdateStatistics", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, _ := testclock.UseTime(Use(context.Background()), now)
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		So(ds.PutMulti([]dsS.PropertyMap{
+			{"$key": {propNI(ds.MakeKey("Foo", 1))}, "A": {prop(1)}, "Blob": {propNI([]byte("data"))}},
+			{"$key": {propNI(ds.MakeKey("Foo", 2))}, "A": {prop(2)}},
+			{"$key": {propNI(ds.MakeKey("Bar", 1))}},
+		}), ShouldBeNil)
+		nsC, err := infoS.Get(c).Namespace("other")
+		So(err, ShouldBeNil)
+		So(dsS.Get(nsC).Put(dsS.PropertyMap{"$key": {propNI(dsS.Get(nsC).MakeKey("Foo", 3))}}), ShouldBeNil)
+
+		ds.Testable().UpdateStatistics()
+
+		getStat := func(d dsS.Interface, kind, name string) dsS.PropertyMap {
+			pm := dsS.PropertyMap{"$key": {propNI(d.MakeKey(kind, name))}}
+			So(d.Get(pm), ShouldBeNil)
+			return pm
+		}
+
+		Convey("totals cover every namespace", func() {
+			total := getStat(ds, "__Stat_Total__", "total_entity_usage")
+			So(total["count"][0].Value(), ShouldEqual, 4)
+			So(total["timestamp"][0].Value(), ShouldResemble, now)
+			// Each entity has a row in the kind index, and each indexed value has
+			// two more.
+			So(total["builtin_index_count"][0].Value(), ShouldEqual, 4+2*2)
+			So(total["bytes"][0].Value(), ShouldBeGreaterThan, total["entity_bytes"][0].Value())
+
+			foo := getStat(ds, "__Stat_Kind__", "Foo")
+			So(foo["count"][0].Value(), ShouldEqual, 3)
+			So(foo["kind_name"][0].Value(), ShouldEqual, "Foo")
+
+			// The statistics are queryable, like in production.
+			kinds := []dsS.PropertyMap{}
+			So(ds.GetAll(dsS.NewQuery("__Stat_Kind__").Order("-count"), &kinds), ShouldBeNil)
+			So(len(kinds), ShouldEqual, 2)
+			So(kinds[0]["kind_name"][0].Value(), ShouldEqual, "Foo")
+		})
+
+		Convey("namespaces have their own", func() {
+			nsTotal := getStat(dsS.Get(nsC), "__Stat_Ns_Total__", "total_entity_usage")
+			So(nsTotal["count"][0].Value(), ShouldEqual, 1)
+			So(getStat(ds, "__Stat_Ns_Kind__", "Foo")["count"][0].Value(), ShouldEqual, 2)
+		})
+
+		Convey("stale statistics are replaced", func() {
+			So(ds.Delete(ds.MakeKey("Bar", 1)), ShouldBeNil)
+			ds.Testable().UpdateStatistics()
+			So(getStat(ds, "__Stat_Total__", "total_entity_usage")["count"][0].Value(), ShouldEqual, 3)
+			So(ds.Get(dsS.PropertyMap{"$key": {propNI(ds.MakeKey("__Stat_Kind__", "Bar"))}}), ShouldEqual, dsS.ErrNoSuchEntity)
+
+			// Statistics entities aren't user kinds.
+			count, err := ds.Count(dsS.NewQuery("__kind__"))
+			So(err, ShouldBeNil)
+			So(count, ShouldEqual, 1)
+		})
+	})
+}
+
+func TestEntityGroupVersions(t *testing.T) {
+	t.Parallel()
+
+	Convey("Entity group versions", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, tc := testclock.UseTime(Use(context.Background()), now)
+		ds := dsS.Get(c)
+		ds.Testable().Consistent(true)
+
+		root := ds.MakeKey("Foo", 1)
+		child := ds.MakeKey("Foo", 1, "Bar", 2)
+		version := func() int64 {
+			meta := &dsS.EntityGroupMeta{Parent: root}
+			So(ds.Get(meta), ShouldBeNil)
+			return meta.Version
+		}
+
+		Convey("are 0 for groups which were never written", func() {
+			meta := &dsS.EntityGroupMeta{Parent: root}
+			So(ds.Get(meta), ShouldEqual, dsS.ErrNoSuchEntity)
+			_, err := ds.Testable().LastCommit(root)
+			So(err, ShouldEqual, dsS.ErrNoSuchEntity)
+		})
+
+		Convey("increase with each write outside of transactions", func() {
+			So(ds.Put(dsS.PropertyMap{"$key": {propNI(root)}}), ShouldBeNil)
+			tc.Add(time.Second)
+			So(ds.Put(dsS.PropertyMap{"$key": {propNI(child)}}), ShouldBeNil)
+			So(version(), ShouldEqual, 2)
+
+			commit, err := ds.Testable().LastCommit(root)
+			So(err, ShouldBeNil)
+			So(commit, ShouldResemble, dsS.EntityCommit{Version: 1, Timestamp: now})
+			commit, err = ds.Testable().LastCommit(child)
+			So(err, ShouldBeNil)
+			So(commit, ShouldResemble, dsS.EntityCommit{Version: 2, Timestamp: now.Add(time.Second)})
+
+			Convey("and deletes, which forget the commit", func() {
+				So(ds.Delete(child), ShouldBeNil)
+				So(version(), ShouldEqual, 3)
+				_, err := ds.Testable().LastCommit(child)
+				So(err, ShouldEqual, dsS.ErrNoSuchEntity)
+			})
+		})
+
+		Convey("increase once per transaction", func() {
+			So(ds.Put(dsS.PropertyMap{"$key": {propNI(root)}}), ShouldBeNil)
+			tc.Add(time.Second)
+			So(ds.RunInTransaction(func(c context.Context) error {
+				ds := dsS.Get(c)
+				So(ds.Put(dsS.PropertyMap{"$key": {propNI(root)}, "A": {prop(1)}}), ShouldBeNil)
+				return ds.Put(dsS.PropertyMap{"$key": {propNI(child)}})
+			}, nil), ShouldBeNil)
+			So(version(), ShouldEqual, 2)
+
+			for _, k := range []*dsS.Key{root, child} {
+				commit, err := ds.Testable().LastCommit(k)
+				So(err, ShouldBeNil)
+				So(commit, ShouldResemble, dsS.EntityCommit{Version: 2, Timestamp: now.Add(time.Second)})
+			}
+		})
+
+		Convey("are restored with snapshots", func() {
+			snap := ds.Testable().Snapshot()
+			So(ds.Put(dsS.PropertyMap{"$key": {propNI(root)}}), ShouldBeNil)
+			ds.Testable().Restore(snap)
+			_, err := ds.Testable().LastCommit(root)
+			So(err, ShouldEqual, dsS.ErrNoSuchEntity)
+		})
+	})
+}
@@ -5,9 +5,23 @@
 package memory
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
 	"fmt"
+	"math/big"
 	"regexp"
+	"strings"
+	"sync"
+	"time"
 
+	"github.com/luci/luci-go/common/clock"
+
+	"github.com/tetrafolium/gae"
 	"github.com/tetrafolium/gae/impl/dummy"
 	"github.com/tetrafolium/gae/service/info"
 	"golang.org/x/net/context"
@@ -27,23 +41,36 @@ func curGID(c context.Context) *globalInfoData {
 // useGI adds a gae.GlobalInfo context, accessible
 // by gae.GetGI(c)
 func useGI(c context.Context, appID string) context.Context {
-	return info.SetFactory(c, func(ic context.Context) info.Interface {
+	c = info.SetCoreFactory(c, func(ic context.Context) info.Core {
 		return &giImpl{dummy.Info(), curGID(ic), ic}
 	})
+	return info.AddTokenCache(c, &info.TokenCache{})
 }
 
 type globalInfoData struct {
 	appid     string
 	namespace string
+
+	// tokens and signer are shared by all namespaces.
+	tokens *tokenData
+	signer *signer
+}
+
+type tokenData struct {
+	sync.Mutex
+	lifetime time.Duration
+	minted   int
 }
 
+// giImpl only implements info.Core, so the info.Legacy methods (e.g.
+// Datacenter) return empty strings instead of panicking.
 type giImpl struct {
-	info.Interface
+	info.Core
 	*globalInfoData
 	c context.Context
 }
 
-var _ = info.Interface((*giImpl)(nil))
+var _ = info.Core((*giImpl)(nil))
 
 func (gi *giImpl) GetNamespace() string {
 	return gi.namespace
@@ -53,7 +80,7 @@ func (gi *giImpl) Namespace(ns string) (ret context.Context, err error) {
 	if !validNamespace.MatchString(ns) {
 		return nil, fmt.Errorf("appengine: namespace %q does not match /%s/", ns, validNamespace)
 	}
-	return context.WithValue(gi.c, giContextKey, &globalInfoData{gi.appid, ns}), nil
+	return context.WithValue(gi.c, giContextKey, &globalInfoData{gi.appid, ns, gi.tokens, gi.signer}), nil
 }
 
 func (gi *giImpl) MustNamespace(ns string) context.Context {
@@ -76,8 +103,90 @@ func (gi *giImpl) IsDevAppServer() bool {
 	return true
 }
 
+func (gi *giImpl) IsOverQuota(err error) bool {
+	return gae.IsOverQuota(err)
+}
+
 func (gi *giImpl) VersionID() string {
 	// VersionID returns X.Y where Y is autogenerated by appengine, and X is
 	// whatever's in app.yaml.
 	return "testVersionID.1"
 }
+
+func (gi *giImpl) AccessToken(scopes ...string) (token string, expiry time.Time, err error) {
+	gi.tokens.Lock()
+	defer gi.tokens.Unlock()
+	gi.tokens.minted++
+	return fmt.Sprintf("token-%d:%s", gi.tokens.minted, strings.Join(scopes, " ")),
+		clock.Now(gi.c).Add(gi.tokens.lifetime), nil
+}
+
+func (gi *giImpl) Testable() info.Testable {
+	return gi.tokens
+}
+
+func (t *tokenData) SetTokenLifetime(d time.Duration) {
+	t.Lock()
+	defer t.Unlock()
+	t.lifetime = d
+}
+
+func (t *tokenData) MintedTokens() int {
+	t.Lock()
+	defer t.Unlock()
+	return t.minted
+}
+
+// signerKeyName is the name of the key which SignBytes signs with.
+const signerKeyName = "memory-key"
+
+// signer is a fake app identity, with an RSA key which is generated on first
+// use.
+type signer struct {
+	once sync.Once
+	key  *rsa.PrivateKey
+	cert []byte
+	err  error
+}
+
+func (s *signer) init(appid string) error {
+	s.once.Do(func() {
+		if s.key, s.err = rsa.GenerateKey(rand.Reader, 2048); s.err != nil {
+			return
+		}
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: appid},
+			NotBefore:    time.Unix(0, 0),
+			NotAfter:     time.Date(9999, time.December, 31, 0, 0, 0, 0, time.UTC),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &s.key.PublicKey, s.key)
+		if err != nil {
+			s.err = err
+			return
+		}
+		s.cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	})
+	return s.err
+}
+
+func (gi *giImpl) ServiceAccount() (string, error) {
+	return gi.appid + "@appspot.gserviceaccount.com", nil
+}
+
+// SignBytes signs bytes with RSA-SHA256, like the production implementation.
+func (gi *giImpl) SignBytes(bytes []byte) (keyName string, signature []byte, err error) {
+	if err = gi.signer.init(gi.appid); err != nil {
+		return
+	}
+	h := sha256.Sum256(bytes)
+	signature, err = rsa.SignPKCS1v15(rand.Reader, gi.signer.key, crypto.SHA256, h[:])
+	return signerKeyName, signature, err
+}
+
+func (gi *giImpl) PublicCertificates() ([]info.Certificate, error) {
+	if err := gi.signer.init(gi.appid); err != nil {
+		return nil, err
+	}
+	return []info.Certificate{{KeyName: signerKeyName, Data: gi.signer.cert}}, nil
+}
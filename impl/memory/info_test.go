@@ -6,7 +6,11 @@ package memory
 
 import (
 	"testing"
+	"time"
 
+	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
+	"github.com/tetrafolium/gae"
 	"github.com/tetrafolium/gae/service/info"
 	"golang.org/x/net/context"
 
@@ -26,3 +30,71 @@ func TestMustNamespace(t *testing.T) {
 		}, ShouldPanic)
 	})
 }
+
+func TestIsOverQuota(t *testing.T) {
+	Convey("IsOverQuota recognizes gae.ErrOverQuota", t, func() {
+		i := info.Get(Use(context.Background()))
+
+		overQuota := &gae.ErrOverQuota{Service: "datastore"}
+		So(i.IsOverQuota(overQuota), ShouldBeTrue)
+		So(i.IsOverQuota(errors.MultiError{nil, overQuota}), ShouldBeTrue)
+		So(i.IsOverQuota(&gae.ErrCapabilityDisabled{Service: "datastore"}), ShouldBeFalse)
+		So(i.IsOverQuota(errors.New("nope")), ShouldBeFalse)
+		So(i.IsOverQuota(nil), ShouldBeFalse)
+	})
+}
+
+func TestLegacy(t *testing.T) {
+	Convey("Legacy methods return empty strings", t, func() {
+		i := info.Get(Use(context.Background()))
+
+		So(i.Datacenter(), ShouldEqual, "")
+		So(i.ServerSoftware(), ShouldEqual, "")
+	})
+}
+
+func TestAccessToken(t *testing.T) {
+	Convey("AccessToken", t, func() {
+		now := time.Date(2000, time.January, 1, 1, 1, 1, 1, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), now)
+		c = Use(c)
+		i := info.Get(c)
+		i.Testable().SetTokenLifetime(10 * time.Minute)
+
+		tok, exp, err := i.AccessToken("b", "a")
+		So(err, ShouldBeNil)
+		So(tok, ShouldEqual, "token-1:b a")
+		So(exp, ShouldResemble, now.Add(10*time.Minute))
+
+		Convey("is cached per set of scopes", func() {
+			tok, _, err := i.AccessToken("a", "b")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "token-1:b a")
+
+			tok, _, err = i.AccessToken("a")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "token-2:a")
+			So(i.Testable().MintedTokens(), ShouldEqual, 2)
+		})
+
+		Convey("is cached across namespaces", func() {
+			tok, _, err := info.Get(i.MustNamespace("ns")).AccessToken("a", "b")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "token-1:b a")
+			So(i.Testable().MintedTokens(), ShouldEqual, 1)
+		})
+
+		Convey("is refreshed shortly before it expires", func() {
+			tc.Add(10*time.Minute - info.DefaultTokenMargin - time.Second)
+			tok, _, err := i.AccessToken("a", "b")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "token-1:b a")
+
+			tc.Add(time.Second)
+			tok, _, err = i.AccessToken("a", "b")
+			So(err, ShouldBeNil)
+			So(tok, ShouldEqual, "token-2:a b")
+			So(i.Testable().MintedTokens(), ShouldEqual, 2)
+		})
+	})
+}
@@ -202,6 +202,9 @@ func doCBs(items []mc.Item, cb mc.RawCB, inner func(mc.Item) error) {
 func (m *memcacheImpl) AddMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
 	doCBs(items, cb, func(itm mc.Item) error {
+		if err := mc.ValidateItem(itm); err != nil {
+			return err
+		}
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 		if !m.data.hasItemLocked(now, itm.Key()) {
@@ -216,6 +219,9 @@ func (m *memcacheImpl) AddMulti(items []mc.Item, cb mc.RawCB) error {
 func (m *memcacheImpl) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
 	doCBs(items, cb, func(itm mc.Item) error {
+		if err := mc.ValidateItem(itm); err != nil {
+			return err
+		}
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 
@@ -240,6 +246,9 @@ func (m *memcacheImpl) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
 func (m *memcacheImpl) SetMulti(items []mc.Item, cb mc.RawCB) error {
 	now := clock.Now(m.ctx)
 	doCBs(items, cb, func(itm mc.Item) error {
+		if err := mc.ValidateItem(itm); err != nil {
+			return err
+		}
 		m.data.lock.Lock()
 		defer m.data.lock.Unlock()
 		m.data.setItemLocked(now, itm)
@@ -256,6 +265,9 @@ func (m *memcacheImpl) GetMulti(keys []string, cb mc.RawItemCB) error {
 
 	for i, k := range keys {
 		itms[i], errs[i] = func() (mc.Item, error) {
+			if err := mc.ValidateKey(k); err != nil {
+				return nil, err
+			}
 			m.data.lock.RLock()
 			defer m.data.lock.RUnlock()
 			val, err := m.data.retrieveLocked(now, k)
@@ -280,6 +292,9 @@ func (m *memcacheImpl) DeleteMulti(keys []string, cb mc.RawCB) error {
 
 	for i, k := range keys {
 		errs[i] = func() error {
+			if err := mc.ValidateKey(k); err != nil {
+				return err
+			}
 			m.data.lock.Lock()
 			defer m.data.lock.Unlock()
 			_, err := m.data.retrieveLocked(now, k)
@@ -307,6 +322,9 @@ func (m *memcacheImpl) Flush() error {
 }
 
 func (m *memcacheImpl) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	if err := mc.ValidateKey(key); err != nil {
+		return 0, err
+	}
 	now := clock.Now(m.ctx)
 
 	m.data.lock.Lock()
@@ -5,11 +5,13 @@
 package memory
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	mcS "github.com/tetrafolium/gae/service/memcache"
 	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
@@ -169,6 +171,28 @@ func TestMemcache(t *testing.T) {
 			})
 		})
 
+		Convey("enforces production limits", func() {
+			longKey := strings.Repeat("k", mcS.MaxKeySize+1)
+
+			So(mc.Set(mc.NewItem(longKey)), ShouldEqual, mcS.ErrKeyTooLong)
+			So(mc.Add(mc.NewItem("has space")), ShouldEqual, mcS.ErrInvalidKey)
+			So(mc.Set(mc.NewItem("big").SetValue(make([]byte, mcS.MaxValueSize+1))), ShouldEqual, mcS.ErrValueTooLarge)
+			So(mc.Set(mc.NewItem("ok").SetValue(make([]byte, mcS.MaxValueSize))), ShouldBeNil)
+
+			_, err := mc.Get("new\nline")
+			So(err, ShouldEqual, mcS.ErrInvalidKey)
+			So(mc.Delete(longKey), ShouldEqual, mcS.ErrKeyTooLong)
+			_, err = mc.Increment(longKey, 1, 0)
+			So(err, ShouldEqual, mcS.ErrKeyTooLong)
+
+			Convey("per item", func() {
+				err := mc.SetMulti([]mcS.Item{mc.NewItem("a"), mc.NewItem(longKey), mc.NewItem("b")})
+				So(err, ShouldResemble, errors.MultiError{nil, mcS.ErrKeyTooLong, nil})
+				_, err = mc.Get("b")
+				So(err, ShouldBeNil)
+			})
+		})
+
 		Convey("check that the internal implementation is sane", func() {
 			curTime := now
 			err := mc.Add(&mcItem{
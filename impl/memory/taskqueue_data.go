@@ -8,8 +8,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"golang.org/x/net/context"
 
@@ -92,6 +94,27 @@ func (t *taskQueueData) GetTombstonedTasks() tq.QueueData {
 	return dupQueue(t.archived)
 }
 
+type timeSlice []time.Time
+
+func (s timeSlice) Len() int           { return len(s) }
+func (s timeSlice) Less(i, j int) bool { return s[i].Before(s[j]) }
+func (s timeSlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+func (t *taskQueueData) GetPendingETAs(queueName string) []time.Time {
+	t.Lock()
+	defer t.Unlock()
+
+	if queueName == "" {
+		queueName = "default"
+	}
+	ret := make(timeSlice, 0, len(t.named[queueName]))
+	for _, tsk := range t.named[queueName] {
+		ret = append(ret, tsk.ETA)
+	}
+	sort.Sort(ret)
+	return ret
+}
+
 func (t *taskQueueData) resetTasksWithLock() {
 	for queueName := range t.named {
 		t.named[queueName] = map[string]*tq.Task{}
@@ -157,6 +180,10 @@ func (t *taskQueueData) prepTask(c context.Context, ns string, task *tq.Task, qu
 		return nil, fmt.Errorf("taskqueue: bad method %q", toSched.Method)
 	}
 
+	if err := toSched.ValidateTarget(); err != nil {
+		return nil, err
+	}
+
 	if _, ok := toSched.Header[currentNamespace]; !ok {
 		if ns != "" {
 			if toSched.Header == nil {
@@ -254,6 +281,10 @@ func (t *txnTaskQueueData) GetScheduledTasks() tq.QueueData {
 	return t.parent.GetScheduledTasks()
 }
 
+func (t *txnTaskQueueData) GetPendingETAs(queueName string) []time.Time {
+	return t.parent.GetPendingETAs(queueName)
+}
+
 func (t *txnTaskQueueData) CreateQueue(queueName string) {
 	t.parent.CreateQueue(queueName)
 }
@@ -16,6 +16,7 @@ import (
 	tqS "github.com/tetrafolium/gae/service/taskqueue"
 	"github.com/luci/luci-go/common/clock"
 	"github.com/luci/luci-go/common/clock/testclock"
+	"github.com/luci/luci-go/common/errors"
 	"github.com/luci/luci-go/common/mathrand"
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
@@ -74,6 +75,33 @@ func TestTaskQueue(t *testing.T) {
 
 				})
 
+				Convey("can be routed to a target", func() {
+					So(t.SetTarget(tqS.Target{Version: "v2", Module: "backend"}, "app.example.com"), ShouldBeNil)
+					So(tq.Add(t, ""), ShouldBeNil)
+					So(t.Header, ShouldResemble, http.Header{
+						"Host": {"v2.backend.app.example.com"},
+					})
+
+					So(t.SetTarget(tqS.Target{Module: "backend"}, "localhost:8080"), ShouldBeNil)
+					So(t.Header.Get("Host"), ShouldEqual, "backend.localhost:8080")
+
+					So(t.SetTarget(tqS.Target{}, "app.example.com"), ShouldBeNil)
+					So(t.Header, ShouldResemble, http.Header{})
+				})
+
+				Convey("validates targets", func() {
+					So(t.SetTarget(tqS.Target{Module: "Back_End"}, "app.example.com"),
+						ShouldErrLike, `invalid target module "Back_End"`)
+					So(t.SetTarget(tqS.Target{Version: "-v"}, "app.example.com"),
+						ShouldErrLike, `invalid target version "-v"`)
+
+					t.Header = http.Header{"Host": {"bad..host"}}
+					So(tq.Add(t, ""), ShouldErrLike, `invalid Host "bad..host"`)
+
+					t.Header = http.Header{"Host": {"a.example.com", "b.example.com"}}
+					So(tq.Add(t, ""), ShouldErrLike, "expected one Host header")
+				})
+
 				Convey("cannot add to bad queues", func() {
 					So(tq.Add(nil, "waaat").Error(), ShouldContainSubstring, "UNKNOWN_QUEUE")
 
@@ -137,6 +165,90 @@ func TestTaskQueue(t *testing.T) {
 					So(tq.Add(t, "").Error(), ShouldContainSubstring, "INVALID_TASK_NAME")
 				})
 
+				Convey("AddSpread spreads ETAs", func() {
+					tasks := make([]*tqS.Task, 250)
+					for i := range tasks {
+						tasks[i] = tq.NewTask("/spread")
+					}
+					quarters := func() (ret [4]int) {
+						for _, t := range tasks {
+							So(t.ETA, ShouldHappenOnOrAfter, now)
+							So(t.ETA, ShouldHappenBefore, now.Add(time.Hour))
+							ret[t.ETA.Sub(now)*4/time.Hour]++
+						}
+						return
+					}
+
+					Convey("uniformly", func() {
+						So(tq.AddSpread(tasks, "", tqS.Spread{Window: time.Hour}), ShouldBeNil)
+						So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 250)
+						for _, n := range quarters() {
+							So(n, ShouldBeBetween, 40, 85)
+						}
+					})
+
+					Convey("exponentially", func() {
+						So(tq.AddSpread(tasks, "", tqS.Spread{
+							Window: time.Hour, Distribution: tqS.Exponential}), ShouldBeNil)
+						q := quarters()
+						So(q[0], ShouldBeGreaterThan, 125)
+						So(q[3], ShouldBeLessThan, 25)
+					})
+
+					Convey("rejecting bad spreads and tasks before changing any", func() {
+						tasks = tasks[:2]
+						err := tq.AddSpread(tasks, "", tqS.Spread{Window: time.Hour, Distribution: 42})
+						So(err.Error(), ShouldContainSubstring, "unknown distribution Distribution(42)")
+						So(tasks[0].ETA.IsZero(), ShouldBeTrue)
+
+						tasks[1].Delay = time.Minute
+						tasks[1].ETA = now
+						So(tq.AddSpread(tasks, "", tqS.Spread{Window: time.Hour}).Error(), ShouldContainSubstring, "both Delay and ETA")
+						So(tasks[0].ETA.IsZero(), ShouldBeTrue)
+						So(tasks[1].ETA, ShouldResemble, now)
+						So(tasks[1].Delay, ShouldEqual, time.Minute)
+						So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 0)
+					})
+
+					Convey("relative to Start, Delay and ETA", func() {
+						tasks = tasks[:3]
+						tasks[1].Delay = time.Minute
+						tasks[2].ETA = now.Add(time.Hour)
+						So(tq.AddSpread(tasks, "", tqS.Spread{
+							Window: time.Second, Start: now.Add(time.Minute)}), ShouldBeNil)
+						So(tasks[0].ETA, ShouldHappenWithin, time.Second, now.Add(time.Minute))
+						So(tasks[1].ETA, ShouldHappenWithin, time.Second, now.Add(2*time.Minute))
+						So(tasks[1].Delay, ShouldEqual, 0)
+						So(tasks[2].ETA, ShouldHappenWithin, time.Second, now.Add(time.Hour))
+					})
+				})
+
+				Convey("AddDelayed uses the clock", func() {
+					So(tq.AddDelayed(t, "", time.Minute), ShouldBeNil)
+					tc.Add(time.Hour)
+					t2 := tq.NewTask("/delayed")
+					t2.Delay = time.Hour
+					So(tq.AddDelayed(t2, "", time.Second), ShouldBeNil)
+					So(t2.Delay, ShouldEqual, 0)
+					So(tqt.GetPendingETAs(""), ShouldResemble, []time.Time{
+						now.Add(time.Minute), now.Add(time.Hour + time.Second)})
+
+					Convey("and rejects tasks with ETAs", func() {
+						t3 := tq.NewTask("/eta")
+						t3.ETA = now
+						So(tq.AddDelayed(t3, "", time.Second), ShouldErrLike, "both Delay and ETA")
+					})
+
+					Convey("in transactions", func() {
+						So(dsS.Get(c).RunInTransaction(func(c context.Context) error {
+							So(tqS.Get(c).AddDelayed(tqS.Get(c).NewTask("/txn"), "", time.Minute), ShouldBeNil)
+							tc.Add(time.Hour)
+							return nil
+						}, nil), ShouldBeNil)
+						So(tqt.GetPendingETAs("default")[2], ShouldResemble, now.Add(time.Hour+time.Minute))
+					})
+				})
+
 				Convey("AddMulti also works", func() {
 					t2 := t.Duplicate()
 					t2.Path = "/hi/city"
@@ -342,7 +454,41 @@ func TestTaskQueue(t *testing.T) {
 					for i := 0; i < 5; i++ {
 						So(tqS.Get(c).Add(t.Duplicate(), ""), ShouldBeNil)
 					}
-					So(tqS.Get(c).Add(t, "").Error(), ShouldContainSubstring, "BAD_REQUEST")
+					So(tqS.Get(c).Add(t, ""), ShouldEqual, tqS.ErrTooManyTransactionalTasks)
+					return nil
+				}, nil), ShouldBeNil)
+			})
+
+			Convey("and the limit covers the whole transaction", func() {
+				So(dsS.Get(c).RunInTransaction(func(c context.Context) error {
+					t.Name = ""
+					So(tqS.Get(c).AddMulti([]*tqS.Task{t.Duplicate(), t.Duplicate(), t.Duplicate()}, ""), ShouldBeNil)
+					err := tqS.Get(c).AddMulti([]*tqS.Task{t.Duplicate(), t.Duplicate(), t.Duplicate()}, "")
+					So(err, ShouldResemble, errors.MultiError{
+						tqS.ErrTooManyTransactionalTasks, tqS.ErrTooManyTransactionalTasks, tqS.ErrTooManyTransactionalTasks})
+					So(len(tqS.Get(c).Testable().GetTransactionTasks()["default"]), ShouldEqual, 3)
+
+					// Without the transaction, there's no limit.
+					So(tqS.GetNoTxn(c).AddMulti([]*tqS.Task{t.Duplicate(), t.Duplicate(), t.Duplicate()}, ""), ShouldBeNil)
+					return nil
+				}, nil), ShouldBeNil)
+			})
+
+			Convey("but tasks which fail to be added don't count", func() {
+				So(dsS.Get(c).RunInTransaction(func(c context.Context) error {
+					t.Name = ""
+					bad := t.Duplicate()
+					bad.Method = "BOGUS"
+					err := tqS.Get(c).AddMulti([]*tqS.Task{t.Duplicate(), bad, bad.Duplicate()}, "")
+					So(err.(errors.MultiError)[0], ShouldBeNil)
+					So(err.(errors.MultiError)[1].Error(), ShouldContainSubstring, "bad method")
+					So(tqS.Get(c).Add(t.Duplicate(), "meat").Error(), ShouldContainSubstring, "UNKNOWN_QUEUE")
+
+					for i := 0; i < 4; i++ {
+						So(tqS.Get(c).Add(t.Duplicate(), ""), ShouldBeNil)
+					}
+					So(tqS.Get(c).Add(t.Duplicate(), ""), ShouldEqual, tqS.ErrTooManyTransactionalTasks)
+					So(len(tqS.Get(c).Testable().GetTransactionTasks()["default"]), ShouldEqual, 5)
 					return nil
 				}, nil), ShouldBeNil)
 			})
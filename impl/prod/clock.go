@@ -0,0 +1,48 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package prod
+
+import (
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+// skewedClock is a clock which is skew ahead of its base clock.
+type skewedClock struct {
+	clock.Clock
+
+	skew time.Duration
+}
+
+func (s skewedClock) Now() time.Time { return s.Clock.Now().Add(s.skew) }
+
+// useClockSkew replaces the clock of c (clock.Get) with one which is skew
+// ahead of it, and records the skew so that absolute times passed to the
+// appengine SDK can be shifted back by it.
+func useClockSkew(c context.Context, skew time.Duration) context.Context {
+	if skew == 0 {
+		return c
+	}
+	c = context.WithValue(c, clockSkewKey, skew)
+	return clock.Set(c, skewedClock{clock.Get(c), skew})
+}
+
+// getClockSkew returns the skew installed in c by useClockSkew.
+func getClockSkew(c context.Context) time.Duration {
+	skew, _ := c.Value(clockSkewKey).(time.Duration)
+	return skew
+}
+
+// unskew converts t from the (possibly skewed) clock of the context to real
+// time, which is what the SDK and the services expect. Zero times are left
+// as they are.
+func unskew(t time.Time, skew time.Duration) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.Add(-skew)
+}
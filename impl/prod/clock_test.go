@@ -0,0 +1,74 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// +build appengine
+
+package prod
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/service/info"
+	"github.com/luci/luci-go/common/clock"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// fqaidGI is a giImpl which doesn't need the probe cache for
+// FullyQualifiedAppID.
+type fqaidGI struct{ giImpl }
+
+func (fqaidGI) FullyQualifiedAppID() string { return "s~app" }
+
+func TestAccessTokenSkew(t *testing.T) {
+	// This isn't parallel, since it replaces appengineAccessToken.
+
+	Convey("AccessToken", t, func() {
+		realNow := time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC)
+		c, tc := testclock.UseTime(context.Background(), realNow)
+
+		calls := 0
+		defer func(orig func(context.Context, ...string) (string, time.Time, error)) {
+			appengineAccessToken = orig
+		}(appengineAccessToken)
+		appengineAccessToken = func(context.Context, ...string) (string, time.Time, error) {
+			calls++
+			return "tok", realNow.Add(time.Hour), nil
+		}
+
+		for _, skew := range []time.Duration{0, -2 * time.Hour, 2 * time.Hour} {
+			Convey("with a skew of "+skew.String(), func() {
+				c := useClockSkew(c, skew)
+				gi := giImpl{c, nil}
+
+				Convey("returns the expiry in the skewed time", func() {
+					tok, expiry, err := gi.AccessToken("scope")
+					So(err, ShouldBeNil)
+					So(tok, ShouldEqual, "tok")
+					So(expiry, ShouldResemble, realNow.Add(time.Hour+skew))
+					So(expiry.Sub(clock.Now(c)), ShouldEqual, time.Hour)
+				})
+
+				Convey("which TokenCache reuses until it expires", func() {
+					cache := info.TokenCache{}
+					c := info.SetFactory(c, func(context.Context) info.Interface { return fqaidGI{gi} })
+					c = info.AddTokenCache(c, &cache)
+					for i := 0; i < 2; i++ {
+						tok, _, err := info.Get(c).AccessToken("scope")
+						So(err, ShouldBeNil)
+						So(tok, ShouldEqual, "tok")
+					}
+					So(calls, ShouldEqual, 1)
+
+					tc.Add(time.Hour - info.DefaultTokenMargin)
+					_, _, err := info.Get(c).AccessToken("scope")
+					So(err, ShouldBeNil)
+					So(calls, ShouldEqual, 2)
+				})
+			})
+		}
+	})
+}
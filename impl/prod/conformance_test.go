@@ -0,0 +1,82 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// +build appengine
+
+package prod
+
+import (
+	"flag"
+	"os"
+	"testing"
+
+	"github.com/tetrafolium/gae/impl/conformance"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/aetest"
+)
+
+var (
+	parityReport = flag.String("parity-report", "",
+		"write the memory/prod conformance parity report to this path")
+	parityBaseline = flag.String("parity-baseline", "",
+		"fail if a case which matched in this previous parity report no longer does")
+)
+
+// TestConformance runs the conformance suite against impl/memory and against
+// this package (backed by aetest), and reports where they differ. e.g.
+//
+//   goapp test -run TestConformance ./impl/prod \
+//     -parity-report=parity.json -parity-baseline=previous/parity.json
+func TestConformance(t *testing.T) {
+	inst, err := aetest.NewInstance(&aetest.Options{
+		StronglyConsistentDatastore: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inst.Close()
+
+	newProdContext := func() (context.Context, error) {
+		req, err := inst.NewRequest("GET", "/", nil)
+		if err != nil {
+			return nil, err
+		}
+		return Use(context.Background(), req), nil
+	}
+
+	rep := conformance.NewReport(map[string][]conformance.Result{
+		"memory": conformance.Run(conformance.NewMemoryContext, conformance.Cases),
+		"prod":   conformance.Run(newProdContext, conformance.Cases),
+	})
+	for _, name := range rep.Mismatches() {
+		t.Logf("parity mismatch: %s", name)
+	}
+	t.Logf("%d/%d cases match", rep.Matching, len(rep.Cases))
+
+	if *parityReport != "" {
+		f, err := os.Create(*parityReport)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if err := rep.WriteJSON(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if *parityBaseline != "" {
+		f, err := os.Open(*parityBaseline)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		prev, err := conformance.ReadReport(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range rep.Regressions(prev) {
+			t.Errorf("parity regression: %s", name)
+		}
+	}
+}
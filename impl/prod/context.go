@@ -32,6 +32,7 @@ var (
 	prodContextKey      key
 	prodContextNoTxnKey key = 1
 	probeCacheKey       key = 2
+	clockSkewKey        key = 3
 )
 
 // AEContext retrieves the raw "google.golang.org/appengine" compatible Context.
@@ -117,12 +118,23 @@ func Use(c context.Context, r *http.Request) context.Context {
 //       - "https://www.googleapis.com/auth/appengine.apis"
 //       - "https://www.googleapis.com/auth/userinfo.email"
 //       - "https://www.googleapis.com/auth/cloud.platform"
-func UseRemote(inOutCtx *context.Context, host string, client *http.Client) (err error) {
+func UseRemote(inOutCtx *context.Context, host string, client *http.Client) error {
+	aeCtx, err := remoteAECtx(*inOutCtx, host, client)
+	if err != nil {
+		return err
+	}
+	*inOutCtx = setupAECtx(*inOutCtx, aeCtx)
+	return nil
+}
+
+// remoteAECtx returns a Remote API context for host. See UseRemote for how
+// client is chosen if it's nil.
+func remoteAECtx(c context.Context, host string, client *http.Client) (ret context.Context, err error) {
 	if client == nil {
 		if strings.HasPrefix(host, "localhost") {
 			transp := http.DefaultTransport
-			if aeCtx := AEContextNoTxn(*inOutCtx); aeCtx != nil {
-				transp = urlfetch.Get(*inOutCtx)
+			if aeCtx := AEContextNoTxn(c); aeCtx != nil {
+				transp = urlfetch.Get(c)
 			}
 
 			client = &http.Client{Transport: transp}
@@ -143,7 +155,7 @@ func UseRemote(inOutCtx *context.Context, host string, client *http.Client) (err
 			}
 			defer rsp.Body.Close()
 		} else {
-			aeCtx := AEContextNoTxn(*inOutCtx)
+			aeCtx := AEContextNoTxn(c)
 			if aeCtx == nil {
 				aeCtx = context.Background()
 			}
@@ -154,10 +166,5 @@ func UseRemote(inOutCtx *context.Context, host string, client *http.Client) (err
 		}
 	}
 
-	aeCtx, err := remote_api.NewRemoteContext(host, client)
-	if err != nil {
-		return
-	}
-	*inOutCtx = setupAECtx(*inOutCtx, aeCtx)
-	return nil
+	return remote_api.NewRemoteContext(host, client)
 }
@@ -0,0 +1,15 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// +build appengine
+
+package prod
+
+import (
+	"golang.org/x/net/context"
+)
+
+func backgroundAECtx() (context.Context, error) {
+	return nil, ErrNoBackgroundContext
+}
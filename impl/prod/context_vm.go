@@ -18,3 +18,7 @@ import (
 func UseBackground(c context.Context) context.Context {
 	return setupAECtx(c, appengine.BackgroundContext())
 }
+
+func backgroundAECtx() (context.Context, error) {
+	return appengine.BackgroundContext(), nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package prod
+
+import (
+	"reflect"
+
+	"github.com/luci/luci-go/common/errors"
+	"github.com/tetrafolium/gae"
+	"google.golang.org/appengine"
+)
+
+// The SDK's errors are of types in google.golang.org/appengine/internal,
+// which can't be imported, so they're recognized by reflection.
+const (
+	sdkInternalPkg = "google.golang.org/appengine/internal"
+
+	// The codes of CallErrors, which are generic RPC failures.
+	callErrorCapabilityDisabled = 6 // RpcError_CAPABILITY_DISABLED
+
+	// The code of datastore_v3's APIError for disabled capabilities.
+	datastoreCapabilityDisabled = 9 // Error_CAPABILITY_DISABLED
+)
+
+// sdkError returns the struct which err points to, if it's an error of the
+// SDK's internal type typeName.
+func sdkError(err error, typeName string) (reflect.Value, bool) {
+	v := reflect.ValueOf(err)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	if t := v.Elem().Type(); t.PkgPath() != sdkInternalPkg || t.Name() != typeName {
+		return reflect.Value{}, false
+	}
+	return v.Elem(), true
+}
+
+func isCapabilityDisabled(err error) bool {
+	if ce, ok := sdkError(err, "CallError"); ok {
+		return ce.FieldByName("Code").Int() == callErrorCapabilityDisabled
+	}
+	if ae, ok := sdkError(err, "APIError"); ok {
+		return ae.FieldByName("Service").String() == "datastore_v3" &&
+			ae.FieldByName("Code").Int() == datastoreCapabilityDisabled
+	}
+	return false
+}
+
+// mapErr converts the SDK's over-quota and capability-disabled errors
+// returned by a call to service into gae.ErrOverQuota and
+// gae.ErrCapabilityDisabled. Other errors are returned as they are. The
+// elements of MultiErrors are converted, keeping the MultiError's type.
+func mapErr(service string, err error) error {
+	switch me := err.(type) {
+	case nil:
+		return nil
+	case appengine.MultiError:
+		ret := make(appengine.MultiError, len(me))
+		for i, err := range me {
+			ret[i] = mapErr(service, err)
+		}
+		return ret
+	case errors.MultiError:
+		ret := make(errors.MultiError, len(me))
+		for i, err := range me {
+			ret[i] = mapErr(service, err)
+		}
+		return ret
+	}
+
+	switch {
+	case appengine.IsOverQuota(err):
+		return &gae.ErrOverQuota{Service: service, Err: err}
+	case isCapabilityDisabled(err):
+		return &gae.ErrCapabilityDisabled{Service: service, Err: err}
+	}
+	return err
+}
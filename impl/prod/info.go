@@ -7,6 +7,7 @@ package prod
 import (
 	"time"
 
+	"github.com/tetrafolium/gae"
 	"github.com/tetrafolium/gae/service/info"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
@@ -21,18 +22,29 @@ func useGI(usrCtx context.Context) context.Context {
 		usrCtx = withProbeCache(usrCtx, probe(AEContext(usrCtx)))
 	}
 
-	return info.SetFactory(usrCtx, func(ci context.Context) info.Interface {
+	usrCtx = info.SetFactory(usrCtx, func(ci context.Context) info.Interface {
 		return giImpl{ci, AEContext(ci)}
 	})
+	return info.AddTokenCache(usrCtx, &tokenCache)
 }
 
+// tokenCache caches access tokens for all requests handled by this instance.
+var tokenCache info.TokenCache
+
+// appengineAccessToken is appengine.AccessToken, which tests may replace.
+var appengineAccessToken = appengine.AccessToken
+
 type giImpl struct {
 	usrCtx context.Context
 	aeCtx  context.Context
 }
 
+// AccessToken returns the token's expiry in the time of the context's clock,
+// which may be skewed (see Options.ClockSkew), so that it can be compared with
+// clock.Now (e.g. by info.TokenCache).
 func (g giImpl) AccessToken(scopes ...string) (token string, expiry time.Time, err error) {
-	return appengine.AccessToken(g.aeCtx, scopes...)
+	token, expiry, err = appengineAccessToken(g.aeCtx, scopes...)
+	return token, unskew(expiry, -getClockSkew(g.usrCtx)), err
 }
 func (g giImpl) AppID() string {
 	return appengine.AppID(g.aeCtx)
@@ -56,7 +68,7 @@ func (g giImpl) IsDevAppServer() bool {
 	return appengine.IsDevAppServer()
 }
 func (g giImpl) IsOverQuota(err error) bool {
-	return appengine.IsOverQuota(err)
+	return gae.IsOverQuota(err) || appengine.IsOverQuota(err)
 }
 func (g giImpl) IsTimeoutError(err error) bool {
 	return appengine.IsTimeoutError(err)
@@ -110,6 +122,9 @@ func (g giImpl) SignBytes(bytes []byte) (keyName string, signature []byte, err e
 func (g giImpl) VersionID() string {
 	return appengine.VersionID(g.aeCtx)
 }
+func (g giImpl) Testable() info.Testable {
+	return nil
+}
 
 type infoProbeCache struct {
 	namespace string
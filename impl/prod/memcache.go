@@ -105,34 +105,101 @@ func (m mcImpl) NewItem(key string) mc.Item {
 	return mcItem{&memcache.Item{Key: key}}
 }
 
-func doCB(err error, cb mc.RawCB) error {
-	if me, ok := err.(appengine.MultiError); ok {
-		for _, err := range me {
-			cb(err)
+// validateMulti calls f with the valid items (see mc.ValidateItem), and then
+// calls cb with the error for each of items: either its validation error, or
+// the corresponding error returned by f.
+func validateMulti(items []mc.Item, cb mc.RawCB, f func([]*memcache.Item) error) error {
+	errs := make([]error, len(items))
+	valid := make([]*memcache.Item, 0, len(items))
+	for i, itm := range items {
+		if errs[i] = mc.ValidateItem(itm); errs[i] == nil {
+			valid = append(valid, mcF2R(itm))
+		}
+	}
+	if len(valid) > 0 {
+		if err := mergeErrs(errs, len(valid), f(valid)); err != nil {
+			return err
+		}
+	}
+	for _, err := range errs {
+		cb(err)
+	}
+	return nil
+}
+
+// validKeys returns the keys which pass mc.ValidateKey, and the errors for
+// all of keys (nil for the valid ones).
+func validKeys(keys []string) ([]string, []error) {
+	errs := make([]error, len(keys))
+	valid := make([]string, 0, len(keys))
+	for i, k := range keys {
+		if errs[i] = mc.ValidateKey(k); errs[i] == nil {
+			valid = append(valid, k)
 		}
-		err = nil
 	}
-	return err
+	return valid, errs
+}
+
+// mergeErrs fills the nil entries of errs with the elements of err, if it's an
+// appengine.MultiError for numValid items. Any other non-nil err is returned.
+func mergeErrs(errs []error, numValid int, err error) error {
+	err = mapErr("memcache", err)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		return err
+	}
+	if len(me) != numValid {
+		return mc.ErrServerError
+	}
+	j := 0
+	for i := range errs {
+		if errs[i] == nil {
+			errs[i] = me[j]
+			j++
+		}
+	}
+	return nil
 }
 
 func (m mcImpl) DeleteMulti(keys []string, cb mc.RawCB) error {
-	return doCB(memcache.DeleteMulti(m.aeCtx, keys), cb)
+	valid, errs := validKeys(keys)
+	if len(valid) > 0 {
+		if err := mergeErrs(errs, len(valid), memcache.DeleteMulti(m.aeCtx, valid)); err != nil {
+			return err
+		}
+	}
+	for _, err := range errs {
+		cb(err)
+	}
+	return nil
 }
 
 func (m mcImpl) AddMulti(items []mc.Item, cb mc.RawCB) error {
-	return doCB(memcache.AddMulti(m.aeCtx, mcMF2R(items)), cb)
+	return validateMulti(items, cb, func(items []*memcache.Item) error {
+		return memcache.AddMulti(m.aeCtx, items)
+	})
 }
 
 func (m mcImpl) SetMulti(items []mc.Item, cb mc.RawCB) error {
-	return doCB(memcache.SetMulti(m.aeCtx, mcMF2R(items)), cb)
+	return validateMulti(items, cb, func(items []*memcache.Item) error {
+		return memcache.SetMulti(m.aeCtx, items)
+	})
 }
 
 func (m mcImpl) GetMulti(keys []string, cb mc.RawItemCB) error {
-	realItems, err := memcache.GetMulti(m.aeCtx, keys)
-	if err != nil {
-		return err
+	valid, errs := validKeys(keys)
+	realItems := map[string]*memcache.Item(nil)
+	if len(valid) > 0 {
+		var err error
+		if realItems, err = memcache.GetMulti(m.aeCtx, valid); err != nil {
+			return mapErr("memcache", err)
+		}
 	}
-	for _, k := range keys {
+	for i, k := range keys {
+		if errs[i] != nil {
+			cb(nil, errs[i])
+			continue
+		}
 		itm := realItems[k]
 		if itm == nil {
 			cb(nil, memcache.ErrCacheMiss)
@@ -144,24 +211,32 @@ func (m mcImpl) GetMulti(keys []string, cb mc.RawItemCB) error {
 }
 
 func (m mcImpl) CompareAndSwapMulti(items []mc.Item, cb mc.RawCB) error {
-	return doCB(memcache.CompareAndSwapMulti(m.aeCtx, mcMF2R(items)), cb)
+	return validateMulti(items, cb, func(items []*memcache.Item) error {
+		return memcache.CompareAndSwapMulti(m.aeCtx, items)
+	})
 }
 
 func (m mcImpl) Increment(key string, delta int64, initialValue *uint64) (uint64, error) {
+	if err := mc.ValidateKey(key); err != nil {
+		return 0, err
+	}
+	ret, err := uint64(0), error(nil)
 	if initialValue == nil {
-		return memcache.IncrementExisting(m.aeCtx, key, delta)
+		ret, err = memcache.IncrementExisting(m.aeCtx, key, delta)
+	} else {
+		ret, err = memcache.Increment(m.aeCtx, key, delta, *initialValue)
 	}
-	return memcache.Increment(m.aeCtx, key, delta, *initialValue)
+	return ret, mapErr("memcache", err)
 }
 
 func (m mcImpl) Flush() error {
-	return memcache.Flush(m.aeCtx)
+	return mapErr("memcache", memcache.Flush(m.aeCtx))
 }
 
 func (m mcImpl) Stats() (*mc.Statistics, error) {
 	stats, err := memcache.Stats(m.aeCtx)
 	if err != nil {
-		return nil, err
+		return nil, mapErr("memcache", err)
 	}
 	return (*mc.Statistics)(stats), nil
 }
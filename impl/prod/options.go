@@ -0,0 +1,97 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package prod
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// Backend selects the kind of appengine context which UseWithOptions backs the
+// gae services with.
+type Backend int
+
+const (
+	// RequestBackend is associated with an incoming request (Options.Request),
+	// like Use. This is what servers normally want.
+	RequestBackend Backend = iota
+
+	// BackgroundBackend isn't associated with any particular request, like
+	// UseBackground. It's only available on Managed VMs.
+	BackgroundBackend
+
+	// RemoteBackend talks to a remote app (Options.Host) with the Remote API,
+	// like UseRemote. This is what CLIs and other non-AppEngine binaries want.
+	RemoteBackend
+)
+
+// ErrNoBackgroundContext is returned by UseWithOptions for BackgroundBackend
+// when the binary is built for the classic AppEngine runtime, which doesn't
+// have background contexts.
+var ErrNoBackgroundContext = errors.New("prod: background contexts are only available on Managed VMs")
+
+// Options configures UseWithOptions.
+type Options struct {
+	Backend Backend
+
+	// Request is the incoming request for RequestBackend.
+	Request *http.Request
+
+	// Host is the remote host (e.g. "my-app.appspot.com") for RemoteBackend.
+	Host string
+	// Client is the client used for RemoteBackend. If it's nil, one is chosen
+	// as described in UseRemote.
+	Client *http.Client
+
+	// ClockSkew moves the clock of the returned context (clock.Get) forward by
+	// this much (or back, if it's negative). This is for trying out behaviour
+	// which depends on the time, like expirations and scheduled work, on a
+	// staging app. Task ETAs are converted back to real time when they're
+	// passed to the taskqueue service, so that tasks still run when the
+	// skewed clock says they should. Expirations are durations, so they need no
+	// conversion.
+	ClockSkew time.Duration
+}
+
+// UseWithOptions is like Use, except that the appengine context backing the
+// services is chosen at runtime by opts.Backend, rather than by which
+// constructor (and build tags) the binary was compiled with. This lets the
+// same code be set up as a request-scoped server or a remote CLI.
+//
+// On error, c is returned unchanged.
+func UseWithOptions(c context.Context, opts *Options) (context.Context, error) {
+	var aeCtx context.Context
+	switch opts.Backend {
+	case RequestBackend:
+		if opts.Request == nil {
+			return c, errors.New("prod: RequestBackend requires a Request")
+		}
+		aeCtx = appengine.NewContext(opts.Request)
+
+	case BackgroundBackend:
+		var err error
+		if aeCtx, err = backgroundAECtx(); err != nil {
+			return c, err
+		}
+
+	case RemoteBackend:
+		if opts.Host == "" {
+			return c, errors.New("prod: RemoteBackend requires a Host")
+		}
+		var err error
+		if aeCtx, err = remoteAECtx(c, opts.Host, opts.Client); err != nil {
+			return c, err
+		}
+
+	default:
+		return c, fmt.Errorf("prod: unknown Backend %d", opts.Backend)
+	}
+	return useClockSkew(setupAECtx(c, aeCtx), opts.ClockSkew), nil
+}
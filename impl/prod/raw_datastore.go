@@ -50,7 +50,7 @@ func idxCallbacker(err error, amt int, cb func(idx int, err error)) error {
 		}
 		return nil
 	}
-	err = errors.Fix(err)
+	err = errors.Fix(mapErr("datastore", err))
 	me, ok := err.(errors.MultiError)
 	if ok {
 		for i, err := range me {
@@ -68,6 +68,7 @@ func (d rdsImpl) AllocateIDs(incomplete *ds.Key, n int) (start int64, err error)
 	}
 
 	start, _, err = datastore.AllocateIDs(d.aeCtx, incomplete.Kind(), par, n)
+	err = mapErr("datastore", err)
 	return
 }
 
@@ -187,6 +188,8 @@ func (d rdsImpl) fixQuery(fq *ds.FinalizedQuery) (*datastore.Query, error) {
 	ret = ret.Project(fq.Project()...)
 	if fq.Distinct() {
 		ret = ret.Distinct()
+	} else if on := fq.DistinctOn(); len(on) > 0 {
+		ret = ret.DistinctOn(on...)
 	}
 
 	return ret, nil
@@ -214,7 +217,7 @@ func (d rdsImpl) Run(fq *ds.FinalizedQuery, cb ds.RawRunCB) error {
 			return nil
 		}
 		if err != nil {
-			return err
+			return mapErr("datastore", err)
 		}
 		if err := cb(dsR2F(k), tf.pm, cfunc); err != nil {
 			if err == ds.Stop {
@@ -231,14 +234,15 @@ func (d rdsImpl) Count(fq *ds.FinalizedQuery) (int64, error) {
 		return 0, err
 	}
 	ret, err := q.Count(d.aeCtx)
-	return int64(ret), err
+	return int64(ret), mapErr("datastore", err)
 }
 
 func (d rdsImpl) RunInTransaction(f func(c context.Context) error, opts *ds.TransactionOptions) error {
 	ropts := (*datastore.TransactionOptions)(opts)
-	return datastore.RunInTransaction(d.aeCtx, func(c context.Context) error {
+	err := datastore.RunInTransaction(d.aeCtx, func(c context.Context) error {
 		return f(context.WithValue(d.userCtx, prodContextKey, c))
 	}, ropts)
+	return mapErr("datastore", err)
 }
 
 func (d rdsImpl) Testable() ds.Testable {
@@ -7,6 +7,7 @@ package prod
 import (
 	"fmt"
 	"reflect"
+	"time"
 
 	tq "github.com/tetrafolium/gae/service/taskqueue"
 	"golang.org/x/net/context"
@@ -19,14 +20,18 @@ import (
 func useTQ(c context.Context) context.Context {
 	return tq.SetRawFactory(c, func(ci context.Context, wantTxn bool) tq.RawInterface {
 		if wantTxn {
-			return tqImpl{AEContext(ci)}
+			return tqImpl{AEContext(ci), getClockSkew(ci)}
 		}
-		return tqImpl{AEContextNoTxn(ci)}
+		return tqImpl{AEContextNoTxn(ci), getClockSkew(ci)}
 	})
 }
 
 type tqImpl struct {
 	aeCtx context.Context
+
+	// skew is the clock skew of the context (see Options.ClockSkew), which is
+	// removed from ETAs on the way to the SDK, and added on the way back.
+	skew time.Duration
 }
 
 func init() {
@@ -47,7 +52,7 @@ func init() {
 }
 
 // tqR2F (TQ real-to-fake) converts a *taskqueue.Task to a *tq.Task.
-func tqR2F(o *taskqueue.Task) *tq.Task {
+func tqR2F(o *taskqueue.Task, skew time.Duration) *tq.Task {
 	if o == nil {
 		return nil
 	}
@@ -58,14 +63,14 @@ func tqR2F(o *taskqueue.Task) *tq.Task {
 	n.Method = o.Method
 	n.Name = o.Name
 	n.Delay = o.Delay
-	n.ETA = o.ETA
+	n.ETA = unskew(o.ETA, -skew)
 	n.RetryCount = o.RetryCount
 	n.RetryOptions = (*tq.RetryOptions)(o.RetryOptions)
 	return &n
 }
 
 // tqF2R (TQ fake-to-real) converts a *tq.Task to a *taskqueue.Task.
-func tqF2R(n *tq.Task) *taskqueue.Task {
+func tqF2R(n *tq.Task, skew time.Duration) *taskqueue.Task {
 	o := taskqueue.Task{}
 	o.Path = n.Path
 	o.Payload = n.Payload
@@ -73,23 +78,29 @@ func tqF2R(n *tq.Task) *taskqueue.Task {
 	o.Method = n.Method
 	o.Name = n.Name
 	o.Delay = n.Delay
-	o.ETA = n.ETA
+	o.ETA = unskew(n.ETA, skew)
 	o.RetryCount = n.RetryCount
 	o.RetryOptions = (*taskqueue.RetryOptions)(n.RetryOptions)
 	return &o
 }
 
 // tqMF2R (TQ multi-fake-to-real) converts []*tq.Task to []*taskqueue.Task.
-func tqMF2R(ns []*tq.Task) []*taskqueue.Task {
+func tqMF2R(ns []*tq.Task, skew time.Duration) []*taskqueue.Task {
 	ret := make([]*taskqueue.Task, len(ns))
 	for i, t := range ns {
-		ret[i] = tqF2R(t)
+		ret[i] = tqF2R(t, skew)
 	}
 	return ret
 }
 
 func (t tqImpl) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) error {
-	realTasks, err := taskqueue.AddMulti(t.aeCtx, tqMF2R(tasks), queueName)
+	for _, tsk := range tasks {
+		if err := tsk.ValidateTarget(); err != nil {
+			return err
+		}
+	}
+	realTasks, err := taskqueue.AddMulti(t.aeCtx, tqMF2R(tasks, t.skew), queueName)
+	err = mapErr("taskqueue", err)
 	if err != nil {
 		if me, ok := err.(appengine.MultiError); ok {
 			for i, err := range me {
@@ -97,20 +108,20 @@ func (t tqImpl) AddMulti(tasks []*tq.Task, queueName string, cb tq.RawTaskCB) er
 				if realTasks != nil {
 					tsk = realTasks[i]
 				}
-				cb(tqR2F(tsk), err)
+				cb(tqR2F(tsk, t.skew), err)
 			}
 			err = nil
 		}
 	} else {
 		for _, tsk := range realTasks {
-			cb(tqR2F(tsk), nil)
+			cb(tqR2F(tsk, t.skew), nil)
 		}
 	}
 	return err
 }
 
 func (t tqImpl) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) error {
-	err := taskqueue.DeleteMulti(t.aeCtx, tqMF2R(tasks), queueName)
+	err := mapErr("taskqueue", taskqueue.DeleteMulti(t.aeCtx, tqMF2R(tasks, t.skew), queueName))
 	if me, ok := err.(appengine.MultiError); ok {
 		for _, err := range me {
 			cb(err)
@@ -121,15 +132,16 @@ func (t tqImpl) DeleteMulti(tasks []*tq.Task, queueName string, cb tq.RawCB) err
 }
 
 func (t tqImpl) Purge(queueName string) error {
-	return taskqueue.Purge(t.aeCtx, queueName)
+	return mapErr("taskqueue", taskqueue.Purge(t.aeCtx, queueName))
 }
 
 func (t tqImpl) Stats(queueNames []string, cb tq.RawStatsCB) error {
 	stats, err := taskqueue.QueueStats(t.aeCtx, queueNames)
 	if err != nil {
-		return err
+		return mapErr("taskqueue", err)
 	}
 	for _, s := range stats {
+		s.OldestETA = unskew(s.OldestETA, -t.skew)
 		cb((*tq.Statistics)(&s), nil)
 	}
 	return nil
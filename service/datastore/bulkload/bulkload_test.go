@@ -0,0 +1,274 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+const mappingYAML = `
+kind: Person
+key: {field: id, int: true}
+parents:
+  - {kind: Company, field: company}
+columns:
+  - {field: name, property: Name}
+  - {field: age, property: Age, type: int}
+  - {field: bio, property: Bio, noindex: true, optional: true}
+  - {field: joined, property: Joined, type: time}
+`
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	Convey("Import", t, func() {
+		c := memory.Use(context.Background())
+		dstore := ds.Get(c)
+
+		m, err := ParseMapping(strings.NewReader(mappingYAML))
+		So(err, ShouldBeNil)
+
+		joined := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		get := func(company string, id int64) ds.PropertyMap {
+			pm := ds.PropertyMap{
+				"$key": {ds.MkPropertyNI(dstore.NewKey("Person", "", id, dstore.NewKey("Company", company, 0, nil)))},
+			}
+			So(dstore.Get(pm), ShouldBeNil)
+			return pm
+		}
+
+		Convey("CSV", func() {
+			data := strings.Join([]string{
+				"company,id,name,age,bio,joined",
+				"acme,1,Wile,7,genius,2015-01-02T03:04:05Z",
+				"acme,2,Road Runner,3,,2015-01-02T03:04:05Z",
+				"other,1,Bugs,75,wabbit,2015-01-02T03:04:05Z",
+			}, "\n")
+
+			n, err := Import(c, m, NewCSVReader(strings.NewReader(data)), &Options{BatchSize: 2})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+
+			pm := get("acme", 1)
+			So(pm["Name"], ShouldResemble, []ds.Property{ds.MkProperty("Wile")})
+			So(pm["Age"], ShouldResemble, []ds.Property{ds.MkProperty(7)})
+			So(pm["Bio"], ShouldResemble, []ds.Property{ds.MkPropertyNI("genius")})
+			So(pm["Joined"], ShouldResemble, []ds.Property{ds.MkProperty(joined)})
+
+			So(get("acme", 2), ShouldNotContainKey, "Bio")
+			So(get("other", 1)["Name"], ShouldResemble, []ds.Property{ds.MkProperty("Bugs")})
+		})
+
+		Convey("JSON-lines", func() {
+			data := `
+				{"company": "acme", "id": 1, "name": "Wile", "age": 7, "joined": "2015-01-02T03:04:05Z"}
+				{"company": "acme", "id": "2", "name": "Road Runner", "age": "3", "joined": "2015-01-02T03:04:05Z"}
+			`
+			n, err := Import(c, m, NewJSONLinesReader(strings.NewReader(data)), nil)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+
+			So(get("acme", 1)["Age"], ShouldResemble, []ds.Property{ds.MkProperty(7)})
+			So(get("acme", 2)["Age"], ShouldResemble, []ds.Property{ds.MkProperty(3)})
+		})
+
+		Convey("bad records", func() {
+			data := strings.Join([]string{
+				"company,id,name,age,joined",
+				"acme,1,Wile,7,2015-01-02T03:04:05Z",
+				"acme,2,Road Runner,old,2015-01-02T03:04:05Z",
+			}, "\n")
+			n, err := Import(c, m, NewCSVReader(strings.NewReader(data)), nil)
+			So(err, ShouldErrLike, `record 1: bad field "age"`)
+			So(n, ShouldEqual, 0)
+
+			Convey("unknown fields", func() {
+				data := "company,id,name,age,joined,shoe\nacme,1,Wile,7,2015-01-02T03:04:05Z,12\n"
+				_, err := Import(c, m, NewCSVReader(strings.NewReader(data)), nil)
+				So(err, ShouldErrLike, `unknown field "shoe"`)
+
+				m.IgnoreUnknown = true
+				_, err = Import(c, m, NewCSVReader(strings.NewReader(data)), nil)
+				So(err, ShouldBeNil)
+			})
+		})
+
+		Convey("resume", func() {
+			data := strings.Join([]string{
+				"company,id,name,age,joined",
+				"acme,1,Wile,7,2015-01-02T03:04:05Z",
+				"acme,2,Road Runner,3,2015-01-02T03:04:05Z",
+				"acme,3,Elmer,50,2015-01-02T03:04:05Z",
+			}, "\n")
+
+			stop := errors.New("stop")
+			n, err := Import(c, m, NewCSVReader(strings.NewReader(data)), &Options{
+				BatchSize: 1,
+				Progress: func(done int64) error {
+					if done == 2 {
+						return stop
+					}
+					return nil
+				},
+			})
+			So(err, ShouldEqual, stop)
+			So(n, ShouldEqual, 2)
+
+			So(dstore.Delete(dstore.NewKey("Person", "", 1, dstore.NewKey("Company", "acme", 0, nil))), ShouldBeNil)
+
+			n, err = Import(c, m, NewCSVReader(strings.NewReader(data)), &Options{Skip: n})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+
+			exists, err := dstore.Exists(dstore.NewKey("Person", "", 1, dstore.NewKey("Company", "acme", 0, nil)))
+			So(err, ShouldBeNil)
+			So(exists, ShouldBeFalse)
+			So(get("acme", 3)["Name"], ShouldResemble, []ds.Property{ds.MkProperty("Elmer")})
+		})
+	})
+}
+
+func TestParseMapping(t *testing.T) {
+	t.Parallel()
+
+	Convey("ParseMapping", t, func() {
+		Convey("rejects bad mappings", func() {
+			_, err := ParseMapping(strings.NewReader(`columns: [{field: a}]`))
+			So(err, ShouldErrLike, "no kind")
+
+			_, err = ParseMapping(strings.NewReader(`{kind: K, columns: [{field: a, type: blob}]}`))
+			So(err, ShouldErrLike, "unknown type")
+
+			_, err = ParseMapping(strings.NewReader(`{kind: K, columns: [{field: a, property: X}, {field: b, property: X}]}`))
+			So(err, ShouldErrLike, "more than once")
+
+			_, err = ParseMapping(strings.NewReader(`{kind: K, columns: [{field: a, property: $key}]}`))
+			So(err, ShouldErrLike, "meta property")
+		})
+	})
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	Convey("Export", t, func() {
+		c := memory.Use(context.Background())
+		dstore := ds.Get(c)
+		dstore.Testable().Consistent(true)
+
+		joined := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+		keys := []*ds.Key{dstore.MakeKey("Person", 1), dstore.MakeKey("Person", 2), dstore.MakeKey("Person", 3)}
+		So(dstore.PutMulti([]ds.PropertyMap{
+			{"$key": {ds.MkPropertyNI(keys[0])}, "Name": {ds.MkProperty("Wile")}, "Age": {ds.MkProperty(7)},
+				"Joined": {ds.MkProperty(joined)}},
+			{"$key": {ds.MkPropertyNI(keys[1])}, "Name": {ds.MkProperty("Road, Runner")},
+				"Tags": {ds.MkProperty("fast"), ds.MkProperty("bird")}},
+			{"$key": {ds.MkPropertyNI(keys[2])}, "Name": {ds.MkProperty("Bugs")}, "Data": {ds.MkPropertyNI([]byte("hi"))}},
+		}), ShouldBeNil)
+		q := ds.NewQuery("Person")
+
+		Convey("CSV", func() {
+			buf := bytes.Buffer{}
+			n, err := Export(c, q, NewCSVWriter(&buf, []string{"key", "Name", "Age", "Joined", "Tags"}), &ExportOptions{
+				Properties: []string{"Name", "Age", "Joined", "Tags"},
+				KeyField:   "key",
+			})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(buf.String(), ShouldEqual, strings.Join([]string{
+				"key,Name,Age,Joined,Tags",
+				keys[0].Encode() + ",Wile,7,2015-01-02T03:04:05Z,",
+				keys[1].Encode() + `,"Road, Runner",,,"[""fast"",""bird""]"`,
+				keys[2].Encode() + ",Bugs,,,",
+				"",
+			}, "\n"))
+
+			Convey("in a form which can be imported", func() {
+				buf.Reset()
+				_, err := Export(c, q, NewJSONLinesWriter(&buf), &ExportOptions{
+					Properties: []string{"Name", "Age", "Joined", "Data"},
+				})
+				So(err, ShouldBeNil)
+
+				m, err := ParseMapping(strings.NewReader(`
+kind: Copy
+key: {field: Name}
+columns:
+  - {field: Age, property: Age, type: int, optional: true}
+  - {field: Joined, property: Joined, type: time, optional: true}
+  - {field: Data, property: Data, type: bytes, noindex: true, optional: true}
+`))
+				So(err, ShouldBeNil)
+				n, err := Import(c, m, NewJSONLinesReader(&buf), nil)
+				So(err, ShouldBeNil)
+				So(n, ShouldEqual, 3)
+
+				pm := ds.PropertyMap{"$key": {ds.MkPropertyNI(dstore.MakeKey("Copy", "Wile"))}}
+				So(dstore.Get(pm), ShouldBeNil)
+				So(pm["Age"], ShouldResemble, []ds.Property{ds.MkProperty(7)})
+				So(pm["Joined"], ShouldResemble, []ds.Property{ds.MkProperty(joined)})
+
+				pm = ds.PropertyMap{"$key": {ds.MkPropertyNI(dstore.MakeKey("Copy", "Bugs"))}}
+				So(dstore.Get(pm), ShouldBeNil)
+				So(pm["Data"], ShouldResemble, []ds.Property{ds.MkPropertyNI([]byte("hi"))})
+			})
+		})
+
+		Convey("JSON-lines of all properties", func() {
+			buf := bytes.Buffer{}
+			n, err := Export(c, q.Gt("__key__", keys[1]), NewJSONLinesWriter(&buf), nil)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(buf.String(), ShouldEqual, `{"Data":"aGk=","Name":"Bugs"}`+"\n")
+		})
+
+		Convey("checkpoints", func() {
+			buf := bytes.Buffer{}
+			type checkpoint struct {
+				done int64
+				out  string
+			}
+			cps := []checkpoint(nil)
+			cursors := []ds.Cursor(nil)
+			opts := &ExportOptions{
+				Properties:      []string{"Name"},
+				CheckpointEvery: 2,
+				Checkpoint: func(done int64, cur ds.Cursor) error {
+					cps = append(cps, checkpoint{done, buf.String()})
+					cursors = append(cursors, cur)
+					return nil
+				},
+			}
+			n, err := Export(c, q, NewJSONLinesWriter(&buf), opts)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 3)
+			So(cps, ShouldResemble, []checkpoint{{2, `{"Name":"Wile"}` + "\n" + `{"Name":"Road, Runner"}` + "\n"}})
+
+			buf.Reset()
+			n, err = Export(c, q.Start(cursors[0]), NewJSONLinesWriter(&buf), opts)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 1)
+			So(buf.String(), ShouldEqual, `{"Name":"Bugs"}`+"\n")
+
+			Convey("and stop on errors", func() {
+				opts.CheckpointEvery = 1
+				opts.Checkpoint = func(int64, ds.Cursor) error { return errors.New("stop") }
+				n, err := Export(c, q, NewJSONLinesWriter(&buf), opts)
+				So(err, ShouldErrLike, "stop")
+				So(n, ShouldEqual, 1)
+			})
+		})
+	})
+}
@@ -0,0 +1,31 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package bulkload imports CSV or JSON-lines data into the datastore, and
+// exports the results of queries in the same formats.
+//
+// The shape of the imported entities is described declaratively by a Mapping,
+// which says which input field holds the entity's id (and its parents'), and
+// how each input field is converted into a Property. Mappings may be written
+// in Go, or parsed from YAML/JSON with ParseMapping:
+//
+//   kind: Person
+//   key: {field: email}
+//   parents:
+//     - {kind: Company, field: company_id, int: true}
+//   columns:
+//     - {field: name, property: Name}
+//     - {field: age, property: Age, type: int}
+//     - {field: bio, property: Bio, noindex: true}
+//     - {field: joined, property: Joined, type: time}
+//
+// Entities are written with PutMulti in chunks of Options.BatchSize. Import
+// returns the number of input records which have been durably written, so an
+// interrupted import can be resumed by passing that number back in as
+// Options.Skip.
+//
+// Export streams the entities of a query to a Writer, checkpointing its
+// progress with the query's cursor every ExportOptions.CheckpointEvery
+// entities.
+package bulkload
@@ -0,0 +1,138 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+// ExportOptions controls the behavior of Export.
+type ExportOptions struct {
+	// Properties are the properties which are exported, as fields of the same
+	// name. If it's empty, every property of each entity is exported (which is
+	// only useful with NewJSONLinesWriter, since CSV has fixed columns).
+	Properties []string
+
+	// KeyField, if set, is the name of a field which holds each entity's key,
+	// as produced by *Key.Encode (i.e. TypeKey).
+	KeyField string
+
+	// CheckpointEvery is the number of entities between calls to Checkpoint.
+	// If it's <= 0, DefaultBatchSize is used.
+	CheckpointEvery int
+
+	// Checkpoint, if not nil, is called every CheckpointEvery entities, after
+	// the Writer has been flushed, with the total number of entities exported
+	// so far and the cursor of the query after the last of them. An interrupted
+	// export can be resumed by running the query from that cursor. If it returns
+	// an error, Export stops and returns that error.
+	Checkpoint func(done int64, cursor ds.Cursor) error
+}
+
+// Export runs q, and writes each of its entities to w as a Record, streaming
+// them rather than loading them all first. It returns the number of entities
+// which have been written and flushed.
+//
+// The values of the Record are those which Import accepts for the
+// corresponding Column types: strings, int64s, float64s and bools as
+// themselves, times in RFC 3339 format, []byte in standard base64, keys as
+// produced by *Key.Encode, and GeoPoints as {"lat": ..., "lng": ...}. Missing
+// properties are nil, and multi-valued properties are a []interface{} of
+// their values.
+func Export(c context.Context, q *ds.Query, w Writer, opts *ExportOptions) (int64, error) {
+	o := ExportOptions{}
+	if opts != nil {
+		o = *opts
+	}
+	every := o.CheckpointEvery
+	if every <= 0 {
+		every = DefaultBatchSize
+	}
+
+	done, pending := int64(0), int64(0)
+	err := ds.Get(c).Run(q, func(pm ds.PropertyMap, getCursor ds.CursorCB) error {
+		if err := w.Write(exportRecord(pm, &o)); err != nil {
+			return err
+		}
+		pending++
+		if pending < int64(every) {
+			return nil
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+		done, pending = done+pending, 0
+		if o.Checkpoint == nil {
+			return nil
+		}
+		cur, err := getCursor()
+		if err != nil {
+			return err
+		}
+		return o.Checkpoint(done, cur)
+	})
+	if err != nil {
+		return done, err
+	}
+	if err := w.Flush(); err != nil {
+		return done, err
+	}
+	return done + pending, nil
+}
+
+func exportRecord(pm ds.PropertyMap, o *ExportOptions) Record {
+	ret := make(Record, len(o.Properties)+1)
+	if o.KeyField != "" {
+		if k, ok := pm.GetMeta("key"); ok {
+			ret[o.KeyField] = k.(*ds.Key).Encode()
+		}
+	}
+	if len(o.Properties) == 0 {
+		for name, vals := range pm {
+			if len(name) > 0 && name[0] != '$' {
+				ret[name] = exportValues(vals)
+			}
+		}
+	}
+	for _, name := range o.Properties {
+		ret[name] = exportValues(pm[name])
+	}
+	return ret
+}
+
+func exportValues(vals []ds.Property) interface{} {
+	switch len(vals) {
+	case 0:
+		return nil
+	case 1:
+		return exportValue(vals[0].Value())
+	}
+	ret := make([]interface{}, len(vals))
+	for i := range vals {
+		ret[i] = exportValue(vals[i].Value())
+	}
+	return ret
+}
+
+func exportValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		return base64.StdEncoding.EncodeToString(x)
+	case *ds.Key:
+		return x.Encode()
+	case blobstore.Key:
+		return string(x)
+	case ds.GeoPoint:
+		return map[string]interface{}{"lat": x.Lat, "lng": x.Lng}
+	}
+	return v
+}
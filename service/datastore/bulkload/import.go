@@ -0,0 +1,112 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"fmt"
+	"io"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"golang.org/x/net/context"
+)
+
+// DefaultBatchSize is the number of entities written per PutMulti if
+// Options.BatchSize is unset.
+const DefaultBatchSize = 500
+
+// Options controls the behavior of Import.
+type Options struct {
+	// BatchSize is the maximum number of entities written per PutMulti. If
+	// it's <= 0, DefaultBatchSize is used.
+	BatchSize int
+
+	// Skip is the number of input records to skip before importing. Pass the
+	// count returned by a previous, failed, Import to resume it.
+	Skip int64
+
+	// Progress, if not nil, is called after every successful batch with the
+	// total number of input records processed so far (including Skip). If it
+	// returns an error, Import stops and returns that error.
+	Progress func(done int64) error
+}
+
+// RecordError is returned by Import when a single input record can't be
+// converted to an entity.
+type RecordError struct {
+	// Record is the 0-based index of the bad record in the input.
+	Record int64
+	Err    error
+}
+
+func (e *RecordError) Error() string {
+	return fmt.Sprintf("bulkload: record %d: %s", e.Record, e.Err)
+}
+
+// Import reads every Record from r, converts it to an entity using m, and
+// writes it to the datastore in c.
+//
+// It returns the number of input records which were durably processed
+// (including o.Skip). On error, this is the value to use as Options.Skip in
+// order to resume the import. o may be nil.
+func Import(c context.Context, m *Mapping, r Reader, o *Options) (int64, error) {
+	if err := m.Validate(); err != nil {
+		return 0, err
+	}
+	if o == nil {
+		o = &Options{}
+	}
+	batchSize := o.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+
+	done := int64(0)
+	for ; done < o.Skip; done++ {
+		if _, err := r.Next(); err != nil {
+			if err == io.EOF {
+				return done, nil
+			}
+			return done, err
+		}
+	}
+
+	dstore := ds.Get(c)
+	batch := make([]ds.PropertyMap, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := dstore.PutMulti(batch); err != nil {
+			return err
+		}
+		done += int64(len(batch))
+		batch = batch[:0]
+		if o.Progress != nil {
+			return o.Progress(done)
+		}
+		return nil
+	}
+
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return done, err
+		}
+		pm, err := m.Entity(dstore, rec)
+		if err != nil {
+			return done, &RecordError{done + int64(len(batch)), err}
+		}
+		batch = append(batch, pm)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return done, err
+			}
+		}
+	}
+	return done, flush()
+}
@@ -0,0 +1,303 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"gopkg.in/yaml.v2"
+)
+
+// These are the valid values for Column.Type.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeTime   = "time"  // RFC 3339
+	TypeBytes  = "bytes" // standard base64
+	TypeKey    = "key"   // as produced by *Key.Encode
+)
+
+// KeyColumn describes an input field which contributes an id to the entity's
+// Key.
+type KeyColumn struct {
+	// Kind is the kind of this key token. It is ignored for Mapping.Key (where
+	// Mapping.Kind is used instead).
+	Kind string `yaml:"kind,omitempty" json:"kind,omitempty"`
+
+	// Field is the input field which holds the id.
+	Field string `yaml:"field" json:"field"`
+
+	// Int, if true, means that the id is an IntID. Otherwise it's a StringID.
+	Int bool `yaml:"int,omitempty" json:"int,omitempty"`
+}
+
+// Column describes how a single input field maps to a Property.
+type Column struct {
+	// Field is the name of the input field (the CSV header, or the JSON object
+	// key).
+	Field string `yaml:"field" json:"field"`
+
+	// Property is the name of the datastore property. If empty, Field is used.
+	Property string `yaml:"property,omitempty" json:"property,omitempty"`
+
+	// Type is one of the Type* constants. If empty, TypeString is used.
+	Type string `yaml:"type,omitempty" json:"type,omitempty"`
+
+	// NoIndex, if true, stores the property as unindexed.
+	NoIndex bool `yaml:"noindex,omitempty" json:"noindex,omitempty"`
+
+	// Optional, if true, means that a missing or empty field will be omitted
+	// from the entity instead of being an error.
+	Optional bool `yaml:"optional,omitempty" json:"optional,omitempty"`
+}
+
+// Mapping is a declarative description of how input records are converted to
+// datastore entities.
+type Mapping struct {
+	// Kind is the kind of every imported entity.
+	Kind string `yaml:"kind" json:"kind"`
+
+	// Key identifies the field holding the entity's id. If it's nil, the
+	// entities will be written with incomplete keys, and will be assigned ids
+	// by the datastore.
+	Key *KeyColumn `yaml:"key,omitempty" json:"key,omitempty"`
+
+	// Parents, if specified, is the entity's ancestry from the root down.
+	Parents []KeyColumn `yaml:"parents,omitempty" json:"parents,omitempty"`
+
+	// Columns lists the fields which are imported as properties.
+	Columns []Column `yaml:"columns" json:"columns"`
+
+	// IgnoreUnknown, if false, makes any input field which isn't mentioned by
+	// Key, Parents or Columns an error.
+	IgnoreUnknown bool `yaml:"ignore_unknown,omitempty" json:"ignore_unknown,omitempty"`
+}
+
+// ParseMapping parses a YAML (or JSON, which is a subset of YAML) Mapping.
+func ParseMapping(r io.Reader) (*Mapping, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	m := &Mapping{}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate returns an error if the Mapping is malformed.
+func (m *Mapping) Validate() error {
+	if m.Kind == "" {
+		return fmt.Errorf("bulkload: mapping has no kind")
+	}
+	if m.Key != nil && m.Key.Field == "" {
+		return fmt.Errorf("bulkload: key has no field")
+	}
+	for i, p := range m.Parents {
+		if p.Kind == "" || p.Field == "" {
+			return fmt.Errorf("bulkload: parent %d must have both kind and field", i)
+		}
+	}
+	props := make(map[string]struct{}, len(m.Columns))
+	for _, c := range m.Columns {
+		if c.Field == "" {
+			return fmt.Errorf("bulkload: column has no field")
+		}
+		switch c.Type {
+		case "", TypeString, TypeInt, TypeFloat, TypeBool, TypeTime, TypeBytes, TypeKey:
+		default:
+			return fmt.Errorf("bulkload: column %q has unknown type %q", c.Field, c.Type)
+		}
+		name := c.propName()
+		if strings.HasPrefix(name, "$") {
+			return fmt.Errorf("bulkload: column %q maps to meta property %q", c.Field, name)
+		}
+		if _, ok := props[name]; ok {
+			return fmt.Errorf("bulkload: property %q is mapped more than once", name)
+		}
+		props[name] = struct{}{}
+	}
+	return nil
+}
+
+func (c *Column) propName() string {
+	if c.Property != "" {
+		return c.Property
+	}
+	return c.Field
+}
+
+// known returns the set of input fields that this Mapping consumes.
+func (m *Mapping) known() map[string]struct{} {
+	ret := make(map[string]struct{}, len(m.Columns)+len(m.Parents)+1)
+	if m.Key != nil {
+		ret[m.Key.Field] = struct{}{}
+	}
+	for _, p := range m.Parents {
+		ret[p.Field] = struct{}{}
+	}
+	for _, c := range m.Columns {
+		ret[c.Field] = struct{}{}
+	}
+	return ret
+}
+
+// isEmpty returns true if v is missing, JSON null or the empty string.
+func isEmpty(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	}
+	return false
+}
+
+// toString renders a raw input value as a string.
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case string:
+		return x
+	case json.Number:
+		return x.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// convert converts the raw input value v to a Property value of the given
+// Column type.
+func convert(v interface{}, typ string) (interface{}, error) {
+	switch typ {
+	case "", TypeString:
+		return toString(v), nil
+
+	case TypeInt:
+		return strconv.ParseInt(toString(v), 10, 64)
+
+	case TypeFloat:
+		return strconv.ParseFloat(toString(v), 64)
+
+	case TypeBool:
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return strconv.ParseBool(toString(v))
+
+	case TypeTime:
+		t, err := time.Parse(time.RFC3339Nano, toString(v))
+		if err != nil {
+			return nil, err
+		}
+		return t.UTC(), nil
+
+	case TypeBytes:
+		return base64.StdEncoding.DecodeString(toString(v))
+
+	case TypeKey:
+		return ds.NewKeyEncoded(toString(v))
+	}
+	return nil, fmt.Errorf("unknown type %q", typ)
+}
+
+// keyTok builds a KeyTok for kind from the raw id value v.
+func keyTok(kind string, v interface{}, isInt bool) (ds.KeyTok, error) {
+	ret := ds.KeyTok{Kind: kind}
+	if isInt {
+		id, err := strconv.ParseInt(toString(v), 10, 64)
+		if err != nil {
+			return ret, err
+		}
+		if id == 0 {
+			return ret, fmt.Errorf("IntID may not be 0")
+		}
+		ret.IntID = id
+	} else {
+		ret.StringID = toString(v)
+	}
+	return ret, nil
+}
+
+// Entity converts a single input record into a PropertyMap (including the
+// "$key" meta property), using the current appID/namespace of dstore.
+func (m *Mapping) Entity(dstore ds.Interface, rec Record) (ds.PropertyMap, error) {
+	if !m.IgnoreUnknown {
+		known := m.known()
+		for f := range rec {
+			if _, ok := known[f]; !ok {
+				return nil, fmt.Errorf("unknown field %q", f)
+			}
+		}
+	}
+
+	toks := make([]ds.KeyTok, 0, len(m.Parents)+1)
+	for _, p := range m.Parents {
+		v := rec[p.Field]
+		if isEmpty(v) {
+			return nil, fmt.Errorf("missing parent field %q", p.Field)
+		}
+		tok, err := keyTok(p.Kind, v, p.Int)
+		if err != nil {
+			return nil, fmt.Errorf("bad parent field %q: %s", p.Field, err)
+		}
+		toks = append(toks, tok)
+	}
+	if m.Key == nil {
+		toks = append(toks, ds.KeyTok{Kind: m.Kind})
+	} else {
+		v := rec[m.Key.Field]
+		if isEmpty(v) {
+			return nil, fmt.Errorf("missing key field %q", m.Key.Field)
+		}
+		tok, err := keyTok(m.Kind, v, m.Key.Int)
+		if err != nil {
+			return nil, fmt.Errorf("bad key field %q: %s", m.Key.Field, err)
+		}
+		toks = append(toks, tok)
+	}
+
+	pm := make(ds.PropertyMap, len(m.Columns)+1)
+	pm["$key"] = []ds.Property{ds.MkPropertyNI(dstore.NewKeyToks(toks))}
+	for _, c := range m.Columns {
+		v := rec[c.Field]
+		if isEmpty(v) && (c.Optional || c.Type == "" || c.Type == TypeString) {
+			if c.Optional {
+				continue
+			}
+			v = ""
+		} else if isEmpty(v) {
+			return nil, fmt.Errorf("missing field %q", c.Field)
+		}
+
+		val, err := convert(v, c.Type)
+		if err != nil {
+			return nil, fmt.Errorf("bad field %q: %s", c.Field, err)
+		}
+		is := ds.ShouldIndex
+		if c.NoIndex {
+			is = ds.NoIndex
+		}
+		prop := ds.Property{}
+		if err := prop.SetValue(val, is); err != nil {
+			return nil, fmt.Errorf("bad field %q: %s", c.Field, err)
+		}
+		name := c.propName()
+		pm[name] = append(pm[name], prop)
+	}
+	return pm, nil
+}
@@ -0,0 +1,77 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Record is a single input record, keyed by field name.
+//
+// Values are either strings (CSV), or any of the values produced by
+// encoding/json with UseNumber set (JSON-lines).
+type Record map[string]interface{}
+
+// Reader produces a stream of Records.
+type Reader interface {
+	// Next returns the next Record, or io.EOF when there are no more.
+	Next() (Record, error)
+}
+
+type csvReader struct {
+	r      *csv.Reader
+	header []string
+}
+
+// NewCSVReader returns a Reader for CSV data. The first row of the data is
+// a header which names the fields.
+func NewCSVReader(r io.Reader) Reader {
+	return &csvReader{r: csv.NewReader(r)}
+}
+
+func (c *csvReader) Next() (Record, error) {
+	if c.header == nil {
+		hdr, err := c.r.Read()
+		if err != nil {
+			return nil, err
+		}
+		c.header = hdr
+	}
+	row, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	ret := make(Record, len(c.header))
+	for i, f := range c.header {
+		ret[f] = row[i]
+	}
+	return ret, nil
+}
+
+type jsonLinesReader struct {
+	d *json.Decoder
+}
+
+// NewJSONLinesReader returns a Reader for a stream of JSON objects, usually
+// one per line.
+func NewJSONLinesReader(r io.Reader) Reader {
+	d := json.NewDecoder(r)
+	d.UseNumber()
+	return &jsonLinesReader{d}
+}
+
+func (j *jsonLinesReader) Next() (Record, error) {
+	ret := Record{}
+	if err := j.d.Decode(&ret); err != nil {
+		if err != io.EOF {
+			err = fmt.Errorf("bulkload: bad JSON record: %s", err)
+		}
+		return nil, err
+	}
+	return ret, nil
+}
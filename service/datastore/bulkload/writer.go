@@ -0,0 +1,104 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package bulkload
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Writer consumes a stream of Records.
+type Writer interface {
+	// Write writes a single Record. It may be buffered until Flush.
+	Write(Record) error
+
+	// Flush writes any buffered Records to the underlying io.Writer.
+	Flush() error
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	header []string
+	row    []string
+}
+
+// NewCSVWriter returns a Writer of CSV data, with a column for each of the
+// fields in header, which is written as the first row (even if there are no
+// Records).
+//
+// Each field of a Record is written as a string: nil as "", strings as
+// themselves, lists (as produced by Export for multi-valued properties) in
+// their JSON encoding, and anything else formatted like fmt.Sprint.
+func NewCSVWriter(w io.Writer, header []string) Writer {
+	return &csvWriter{w: csv.NewWriter(w), header: header}
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.row != nil {
+		return nil
+	}
+	c.row = make([]string, len(c.header))
+	return c.w.Write(c.header)
+}
+
+func (c *csvWriter) Write(rec Record) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	for i, f := range c.header {
+		s, err := csvString(rec[f])
+		if err != nil {
+			return fmt.Errorf("field %q: %s", f, err)
+		}
+		c.row[i] = s
+	}
+	return c.w.Write(c.row)
+}
+
+func (c *csvWriter) Flush() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func csvString(v interface{}) (string, error) {
+	switch x := v.(type) {
+	case nil:
+		return "", nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case []interface{}, map[string]interface{}:
+		b, err := json.Marshal(x)
+		return string(b), err
+	}
+	return toString(v), nil
+}
+
+type jsonLinesWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+// NewJSONLinesWriter returns a Writer of JSON-lines data, with a JSON object
+// per line for each Record.
+func NewJSONLinesWriter(w io.Writer) Writer {
+	bw := bufio.NewWriter(w)
+	return &jsonLinesWriter{bw, json.NewEncoder(bw)}
+}
+
+func (j *jsonLinesWriter) Write(rec Record) error {
+	// Encode adds the newline.
+	return j.enc.Encode(rec)
+}
+
+func (j *jsonLinesWriter) Flush() error {
+	return j.w.Flush()
+}
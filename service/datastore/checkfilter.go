@@ -9,14 +9,18 @@ import (
 
 	"github.com/tetrafolium/gae/service/info"
 	"github.com/luci/luci-go/common/errors"
+	log "github.com/luci/luci-go/common/logging"
 	"golang.org/x/net/context"
 )
 
 type checkFilter struct {
 	RawInterface
 
-	aid string
-	ns  string
+	c      context.Context
+	aid    string
+	ns     string
+	txn    *Transaction
+	strict Strictness
 }
 
 func (tcf *checkFilter) AllocateIDs(incomplete *Key, n int) (start int64, err error) {
@@ -33,7 +37,7 @@ func (tcf *checkFilter) RunInTransaction(f func(c context.Context) error, opts *
 	if f == nil {
 		return fmt.Errorf("datastore: RunInTransaction function is nil")
 	}
-	return tcf.RawInterface.RunInTransaction(f, opts)
+	return runInTransaction(tcf.RawInterface, tcf.txn, f, opts)
 }
 
 func (tcf *checkFilter) Run(fq *FinalizedQuery, cb RawRunCB) error {
@@ -80,13 +84,25 @@ func (tcf *checkFilter) PutMulti(keys []*Key, vals []PropertyMap, cb PutMultiCB)
 	}
 	lme := errors.NewLazyMultiError(len(keys))
 	for i, k := range keys {
-		if !k.PartialValid(tcf.aid, tcf.ns) {
+		if err := tcf.checkWritableKey(k); err != nil {
+			lme.Assign(i, err)
+			continue
+		}
+		vk := k
+		if k.Incomplete() {
+			vk = NewKey(k.AppID(), k.Namespace(), k.Kind(), "", 1, k.Parent())
+		}
+		if !tcf.validWritableKey(vk) {
 			lme.Assign(i, ErrInvalidKey)
 			continue
 		}
 		v := vals[i]
 		if v == nil {
 			lme.Assign(i, errors.New("datastore: PutMulti got nil vals entry"))
+			continue
+		}
+		if err := tcf.check(tcf.validate(k, v)); err != nil {
+			lme.Assign(i, err)
 		}
 	}
 	if me := lme.Get(); me != nil {
@@ -108,7 +124,9 @@ func (tcf *checkFilter) DeleteMulti(keys []*Key, cb DeleteMultiCB) error {
 	}
 	lme := errors.NewLazyMultiError(len(keys))
 	for i, k := range keys {
-		if k.Incomplete() || !k.Valid(false, tcf.aid, tcf.ns) {
+		if err := tcf.checkWritableKey(k); err != nil {
+			lme.Assign(i, err)
+		} else if k.Incomplete() || !tcf.validWritableKey(k) {
 			lme.Assign(i, ErrInvalidKey)
 		}
 	}
@@ -121,7 +139,67 @@ func (tcf *checkFilter) DeleteMulti(keys []*Key, cb DeleteMultiCB) error {
 	return tcf.RawInterface.DeleteMulti(keys, cb)
 }
 
+// validate makes the checks of the entity with key k and properties v which
+// depend on the Strictness.
+func (tcf *checkFilter) validate(k *Key, v PropertyMap) error {
+	if tcf.strict == StrictnessOff {
+		return nil
+	}
+	if err := checkIndexedCount(v); err != nil {
+		return &ValidationError{k, err}
+	}
+	if err := validateIndexedValues(v); err != nil {
+		return &ValidationError{k, err}
+	}
+	if tcf.strict != StrictnessDefault || GetMetaDefault(v, "validate", false) == true {
+		if err := ValidateProperties(v); err != nil {
+			return &ValidationError{k, err}
+		}
+		if size := EntitySize(k, v); size > MaxEntitySize {
+			return &EntityTooLargeError{k, size}
+		}
+	}
+	return nil
+}
+
+// checkWritableKey checks that k may be written to. Keys with reserved kinds
+// or names are always rejected (unless the datastore allows them), while the
+// limits on the key's size depend on the Strictness.
+func (tcf *checkFilter) checkWritableKey(k *Key) error {
+	if err := checkReservedKey(k, tcf.reservedKindsAllowed); err != nil {
+		return err
+	}
+	if tcf.strict == StrictnessOff {
+		return nil
+	}
+	return tcf.check(checkKeyLimits(k))
+}
+
+// validWritableKey returns true if the complete key k is valid for writes.
+// Like in production, special keys (e.g. of __kind__) are invalid, unless the
+// datastore allows writes to reserved kinds.
+func (tcf *checkFilter) validWritableKey(k *Key) bool {
+	return k.Valid(false, tcf.aid, tcf.ns) || (k.Valid(true, tcf.aid, tcf.ns) && tcf.reservedKindsAllowed())
+}
+
+// check returns err, the result of a check which depends on the Strictness,
+// unless the failure should only be logged.
+func (tcf *checkFilter) check(err error) error {
+	if err != nil && tcf.strict == StrictnessWarn {
+		(log.Fields{log.ErrorKey: err}).Warningf(tcf.c, "datastore: writing despite failed validation")
+		return nil
+	}
+	return err
+}
+
+// reservedKindsAllowed returns true if the datastore is a testing one which
+// allows writes to reserved kinds (see Testable.AllowReservedKinds).
+func (tcf *checkFilter) reservedKindsAllowed() bool {
+	t := tcf.RawInterface.Testable()
+	return t != nil && t.ReservedKindsAllowed()
+}
+
 func applyCheckFilter(c context.Context, i RawInterface) RawInterface {
 	inf := info.Get(c)
-	return &checkFilter{i, inf.FullyQualifiedAppID(), inf.GetNamespace()}
+	return &checkFilter{i, c, inf.FullyQualifiedAppID(), inf.GetNamespace(), CurrentTransaction(c), GetStrictness(c)}
 }
@@ -7,15 +7,21 @@
 package datastore
 
 import (
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/tetrafolium/gae/service/info"
+	log "github.com/luci/luci-go/common/logging"
+	"github.com/luci/luci-go/common/logging/memlogger"
 	. "github.com/smartystreets/goconvey/convey"
 	"golang.org/x/net/context"
 )
 
 type fakeRDS struct{ RawInterface }
 
+func (fakeRDS) Testable() Testable { return nil }
+
 func TestCheckFilter(t *testing.T) {
 	t.Parallel()
 
@@ -105,6 +111,76 @@ func TestCheckFilter(t *testing.T) {
 				return nil
 			}), ShouldBeNil)
 
+			vals = []PropertyMap{{
+				"$validate": {mpNI(true)},
+				"__bad__":   {mp(1)},
+			}}
+			So(rds.PutMulti(keys, vals, func(k *Key, err error) error {
+				So(k, ShouldBeNil)
+				So(err, ShouldResemble, &ValidationError{keys[0], errors.New(`property name "__bad__" is reserved`)})
+				return nil
+			}), ShouldBeNil)
+
+			many := make([]Property, maxIndexedProperties)
+			for i := range many {
+				many[i] = mp(int64(i))
+			}
+			vals = []PropertyMap{{
+				"A": many[:3], "B": many[3:], "C": many[:1], "D": many[:2], "E": {mpNI(1)},
+			}}
+			So(rds.PutMulti(keys, vals, func(k *Key, err error) error {
+				So(err, ShouldResemble, &ValidationError{keys[0], &TooManyIndexedError{
+					maxIndexedProperties + 3,
+					[]IndexedCount{{"B", maxIndexedProperties - 3}, {"A", 3}, {"D", 2}},
+				}})
+				So(err.Error(), ShouldEndWith, `20003 indexed values is more than the limit of 20000; the most are in "B" (19997), "A" (3), "D" (2)`)
+				return nil
+			}), ShouldBeNil)
+
+			long := strings.Repeat("x", 1501)
+			vals = []PropertyMap{{
+				"$validate": {mpNI(true)},
+				"Long":      {mpNI(long), mp(long)},
+			}}
+			So(rds.PutMulti(keys, vals, func(k *Key, err error) error {
+				So(err, ShouldResemble, &ValidationError{keys[0], errors.New(
+					`indexed PTString value of property "Long" is 1501 bytes, more than the limit of 1500; it must be NoIndex`)})
+				return nil
+			}), ShouldBeNil)
+
+			// Long indexed values are rejected even without $validate.
+			vals = []PropertyMap{{"Long": {mp([]byte(long))}}}
+			So(rds.PutMulti(keys, vals, func(k *Key, err error) error {
+				So(err, ShouldResemble, &ValidationError{keys[0], errors.New(
+					`indexed PTBytes value of property "Long" is 1501 bytes, more than the limit of 1500; it must be NoIndex`)})
+				return nil
+			}), ShouldBeNil)
+
+			big := PropertyMap{
+				"$validate": {mpNI(true)},
+				"Blob":      {mpNI(make([]byte, MaxEntitySize))},
+			}
+			keys = append(keys, mkKey("s~aid", "ns", "Kind", 2))
+			vals = []PropertyMap{{}, big}
+			errs := []error(nil)
+			So(rds.PutMulti(keys, vals, func(k *Key, err error) error {
+				So(k, ShouldBeNil)
+				errs = append(errs, err)
+				return nil
+			}), ShouldBeNil)
+			So(errs, ShouldResemble, []error{nil, &EntityTooLargeError{keys[1], EntitySize(keys[1], big)}})
+			So(errs[1].Error(), ShouldContainSubstring, "/Kind,2 is too large")
+			keys, vals = keys[:1], []PropertyMap{{
+				"$validate": {mpNI(true)},
+				"__bad__":   {mp(1)},
+			}}
+
+			// Without $validate, the PropertyMap is passed through.
+			delete(vals[0], "$validate")
+			So(func() {
+				rds.PutMulti(keys, vals, func(k *Key, err error) error { return nil })
+			}, ShouldPanic)
+
 			vals = []PropertyMap{{}}
 			hit := false
 			So(func() {
@@ -116,6 +192,62 @@ func TestCheckFilter(t *testing.T) {
 			So(hit, ShouldBeFalse)
 		})
 
+		Convey("Strictness", func() {
+			c := memlogger.Use(c)
+			keys := []*Key{mkKey("Kind", 1)}
+			vals := []PropertyMap{{"__bad__": {mp(1)}}}
+			long := []*Key{mkKey("Kind", strings.Repeat("x", 1501))}
+			putErr := func(c context.Context, keys []*Key, vals []PropertyMap) (ret error) {
+				So(GetRaw(c).PutMulti(keys, vals, func(k *Key, err error) error {
+					ret = err
+					return nil
+				}), ShouldBeNil)
+				return
+			}
+
+			Convey("Strict validates every entity", func() {
+				c := WithStrictness(c, StrictnessStrict)
+				So(GetStrictness(c), ShouldEqual, StrictnessStrict)
+				So(putErr(c, keys, vals), ShouldResemble, &ValidationError{keys[0], errors.New(`property name "__bad__" is reserved`)})
+			})
+
+			Convey("Off skips the checks", func() {
+				c := WithStrictness(c, StrictnessOff)
+				vals := []PropertyMap{{"$validate": {mpNI(true)}, "__bad__": {mp(1)}}}
+				So(func() { putErr(c, keys, vals) }, ShouldPanic)
+				So(func() { putErr(c, long, []PropertyMap{{}}) }, ShouldPanic)
+				So(func() { GetRaw(c).DeleteMulti(long, func(error) error { return nil }) }, ShouldPanic)
+
+				So(putErr(c, []*Key{MakeKey("s~other", "ns", "Kind", 1)}, []PropertyMap{{}}), ShouldEqual, ErrInvalidKey)
+			})
+
+			Convey("reserved kinds are rejected regardless", func() {
+				for _, st := range []Strictness{StrictnessOff, StrictnessWarn} {
+					c := WithStrictness(c, st)
+					for _, k := range []*Key{mkKey("__kind__", "Foo"), mkKey("__Stat_Total__", "total"), mkKey("Kind", "__name__")} {
+						So(putErr(c, []*Key{k}, []PropertyMap{{}}).Error(), ShouldContainSubstring, "reserved")
+						So(GetRaw(c).DeleteMulti([]*Key{k}, func(err error) error {
+							So(err.Error(), ShouldContainSubstring, "reserved")
+							return nil
+						}), ShouldBeNil)
+					}
+				}
+				So(log.Get(c).(*memlogger.MemLogger).Messages(), ShouldBeEmpty)
+			})
+
+			Convey("Warn logs the failures", func() {
+				c := WithStrictness(c, StrictnessWarn)
+				So(func() { putErr(c, keys, vals) }, ShouldPanic)
+				So(func() { putErr(c, long, []PropertyMap{{}}) }, ShouldPanic)
+
+				msgs := log.Get(c).(*memlogger.MemLogger).Messages()
+				So(len(msgs), ShouldEqual, 2)
+				So(msgs[0].Level, ShouldEqual, log.Warning)
+				So(msgs[0].Data[log.ErrorKey], ShouldResemble, &ValidationError{keys[0], errors.New(`property name "__bad__" is reserved`)})
+				So(msgs[1].Data[log.ErrorKey].(error).Error(), ShouldContainSubstring, "more than the limit of 1500")
+			})
+		})
+
 		Convey("DeleteMulti", func() {
 			So(rds.DeleteMulti(nil, nil), ShouldBeNil)
 			So(rds.DeleteMulti([]*Key{mkKey("", "", "", "")}, nil).Error(), ShouldContainSubstring, "is nil")
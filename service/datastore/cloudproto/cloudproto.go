@@ -0,0 +1,231 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package cloudproto converts between the datastore package's Key and
+// PropertyMap, and the Cloud Datastore v1 API's Key and Entity protos
+// (google.datastore.v1).
+//
+// PropertyMaps hold every property as a list of values, while Cloud Datastore
+// distinguishes single values from arrays. A property with exactly one value is
+// converted to a single value, and any other property to an array. Converting
+// an entity back gives the same PropertyMap.
+//
+// App IDs like "s~project" are converted to the Cloud project ID "project".
+// Since the partition (e.g. "s~") can't be recovered from the project ID,
+// the functions which convert protos to keys take the app ID to give them.
+package cloudproto
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/tetrafolium/gae/service/blobstore"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	pb "google.golang.org/genproto/googleapis/datastore/v1"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// MeaningBlobKey is the meaning of string Values which hold a blobstore.Key.
+// It's the same meaning as the App Engine SDKs use.
+const MeaningBlobKey = 17
+
+// ProjectID returns the Cloud project ID of an app ID, by removing its
+// partition prefix (e.g. "s~" or "dev~"), if any.
+func ProjectID(appID string) string {
+	if idx := strings.Index(appID, "~"); idx != -1 {
+		return appID[idx+1:]
+	}
+	return appID
+}
+
+// KeyToProto converts a Key to a Cloud Datastore Key. Incomplete keys are
+// converted to keys whose last path element has no ID.
+func KeyToProto(k *ds.Key) *pb.Key {
+	aid, ns, toks := k.Split()
+	ret := &pb.Key{
+		PartitionId: &pb.PartitionId{ProjectId: ProjectID(aid), NamespaceId: ns},
+		Path:        make([]*pb.Key_PathElement, len(toks)),
+	}
+	for i, t := range toks {
+		e := &pb.Key_PathElement{Kind: t.Kind}
+		switch {
+		case t.StringID != "":
+			e.IdType = &pb.Key_PathElement_Name{Name: t.StringID}
+		case t.IntID != 0:
+			e.IdType = &pb.Key_PathElement_Id{Id: t.IntID}
+		}
+		ret.Path[i] = e
+	}
+	return ret
+}
+
+// KeyFromProto converts a Cloud Datastore Key to a Key with the app ID aid.
+// If aid is empty, the key's project ID is used.
+func KeyFromProto(k *pb.Key, aid string) (*ds.Key, error) {
+	if k == nil || len(k.Path) == 0 {
+		return nil, fmt.Errorf("cloudproto: key has no path")
+	}
+	if aid == "" {
+		aid = k.GetPartitionId().GetProjectId()
+	}
+	toks := make([]ds.KeyTok, len(k.Path))
+	for i, e := range k.Path {
+		if e.Kind == "" {
+			return nil, fmt.Errorf("cloudproto: key path element %d has no kind", i)
+		}
+		toks[i] = ds.KeyTok{Kind: e.Kind, IntID: e.GetId(), StringID: e.GetName()}
+	}
+	return ds.NewKeyToks(aid, k.GetPartitionId().GetNamespaceId(), toks), nil
+}
+
+// EntityToProto converts the entity pm, with key k, to a Cloud Datastore
+// Entity. Metadata in pm (like "$key") is ignored. k may be nil, in which case
+// the Entity has no key.
+func EntityToProto(k *ds.Key, pm ds.PropertyMap) (*pb.Entity, error) {
+	pm, _ = pm.Save(false)
+	ret := &pb.Entity{Properties: make(map[string]*pb.Value, len(pm))}
+	if k != nil {
+		ret.Key = KeyToProto(k)
+	}
+	for name, vals := range pm {
+		if len(vals) == 1 {
+			v, err := ValueToProto(vals[0])
+			if err != nil {
+				return nil, fmt.Errorf("cloudproto: property %q: %s", name, err)
+			}
+			ret.Properties[name] = v
+			continue
+		}
+
+		arr := &pb.ArrayValue{Values: make([]*pb.Value, len(vals))}
+		for i, p := range vals {
+			v, err := ValueToProto(p)
+			if err != nil {
+				return nil, fmt.Errorf("cloudproto: property %q: %s", name, err)
+			}
+			arr.Values[i] = v
+		}
+		ret.Properties[name] = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: arr}}
+	}
+	return ret, nil
+}
+
+// EntityFromProto converts a Cloud Datastore Entity to a key and PropertyMap.
+// Its keys are given the app ID aid, like KeyFromProto. The key is nil if the
+// Entity doesn't have one. Embedded entities aren't supported.
+func EntityFromProto(e *pb.Entity, aid string) (*ds.Key, ds.PropertyMap, error) {
+	k := (*ds.Key)(nil)
+	if e.Key != nil {
+		var err error
+		if k, err = KeyFromProto(e.Key, aid); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pm := make(ds.PropertyMap, len(e.Properties))
+	for name, v := range e.Properties {
+		vals := []*pb.Value{v}
+		if arr, ok := v.ValueType.(*pb.Value_ArrayValue); ok {
+			vals = arr.ArrayValue.GetValues()
+		}
+		props := make([]ds.Property, len(vals))
+		for i, v := range vals {
+			p, err := ValueFromProto(v, aid)
+			if err != nil {
+				return nil, nil, fmt.Errorf("cloudproto: property %q: %s", name, err)
+			}
+			props[i] = p
+		}
+		pm[name] = props
+	}
+	return k, pm, nil
+}
+
+// ValueToProto converts a single Property to a Cloud Datastore Value.
+func ValueToProto(p ds.Property) (*pb.Value, error) {
+	ret := &pb.Value{ExcludeFromIndexes: p.IndexSetting() == ds.NoIndex}
+	switch x := p.Value().(type) {
+	case nil:
+		ret.ValueType = &pb.Value_NullValue{NullValue: structpb.NullValue_NULL_VALUE}
+	case int64:
+		ret.ValueType = &pb.Value_IntegerValue{IntegerValue: x}
+	case bool:
+		ret.ValueType = &pb.Value_BooleanValue{BooleanValue: x}
+	case float64:
+		ret.ValueType = &pb.Value_DoubleValue{DoubleValue: x}
+	case string:
+		ret.ValueType = &pb.Value_StringValue{StringValue: x}
+	case []byte:
+		ret.ValueType = &pb.Value_BlobValue{BlobValue: x}
+	case blobstore.Key:
+		ret.ValueType = &pb.Value_StringValue{StringValue: string(x)}
+		ret.Meaning = MeaningBlobKey
+	case ds.GeoPoint:
+		ret.ValueType = &pb.Value_GeoPointValue{GeoPointValue: &latlng.LatLng{Latitude: x.Lat, Longitude: x.Lng}}
+	case *ds.Key:
+		ret.ValueType = &pb.Value_KeyValue{KeyValue: KeyToProto(x)}
+	case time.Time:
+		ts, err := ptypes.TimestampProto(x)
+		if err != nil {
+			return nil, err
+		}
+		ret.ValueType = &pb.Value_TimestampValue{TimestampValue: ts}
+	default:
+		return nil, fmt.Errorf("unsupported type %s", p.Type())
+	}
+	return ret, nil
+}
+
+// ValueFromProto converts a Cloud Datastore Value to a Property. Key values
+// are given the app ID aid, like KeyFromProto.
+func ValueFromProto(v *pb.Value, aid string) (ds.Property, error) {
+	val := interface{}(nil)
+	switch x := v.ValueType.(type) {
+	case *pb.Value_NullValue:
+	case *pb.Value_IntegerValue:
+		val = x.IntegerValue
+	case *pb.Value_BooleanValue:
+		val = x.BooleanValue
+	case *pb.Value_DoubleValue:
+		val = x.DoubleValue
+	case *pb.Value_StringValue:
+		val = x.StringValue
+		if v.Meaning == MeaningBlobKey {
+			val = blobstore.Key(x.StringValue)
+		}
+	case *pb.Value_BlobValue:
+		val = x.BlobValue
+	case *pb.Value_GeoPointValue:
+		val = ds.GeoPoint{Lat: x.GeoPointValue.GetLatitude(), Lng: x.GeoPointValue.GetLongitude()}
+	case *pb.Value_KeyValue:
+		k, err := KeyFromProto(x.KeyValue, aid)
+		if err != nil {
+			return ds.Property{}, err
+		}
+		val = k
+	case *pb.Value_TimestampValue:
+		t, err := ptypes.Timestamp(x.TimestampValue)
+		if err != nil {
+			return ds.Property{}, err
+		}
+		val = t
+	case *pb.Value_ArrayValue:
+		return ds.Property{}, fmt.Errorf("nested arrays aren't supported")
+	case *pb.Value_EntityValue:
+		return ds.Property{}, fmt.Errorf("embedded entities aren't supported")
+	default:
+		return ds.Property{}, fmt.Errorf("unknown value type %T", x)
+	}
+
+	is := ds.ShouldIndex
+	if v.ExcludeFromIndexes {
+		is = ds.NoIndex
+	}
+	ret := ds.Property{}
+	err := ret.SetValue(val, is)
+	return ret, err
+}
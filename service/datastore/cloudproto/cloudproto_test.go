@@ -0,0 +1,98 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package cloudproto
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	pb "google.golang.org/genproto/googleapis/datastore/v1"
+)
+
+func TestCloudProto(t *testing.T) {
+	t.Parallel()
+
+	Convey("cloudproto", t, func() {
+		k := ds.MakeKey("s~proj", "ns", "Parent", 1, "Child", "a")
+
+		Convey("keys", func() {
+			pk := KeyToProto(k)
+			So(pk.PartitionId.ProjectId, ShouldEqual, "proj")
+			So(pk.PartitionId.NamespaceId, ShouldEqual, "ns")
+			So(pk.Path, ShouldHaveLength, 2)
+			So(pk.Path[0].GetId(), ShouldEqual, 1)
+			So(pk.Path[1].GetName(), ShouldEqual, "a")
+
+			got, err := KeyFromProto(pk, "s~proj")
+			So(err, ShouldBeNil)
+			So(got.Equal(k), ShouldBeTrue)
+
+			got, err = KeyFromProto(pk, "")
+			So(err, ShouldBeNil)
+			So(got.AppID(), ShouldEqual, "proj")
+
+			Convey("incomplete", func() {
+				pk := KeyToProto(ds.MakeKey("s~proj", "", "Kind", 0))
+				So(pk.Path[0].IdType, ShouldBeNil)
+				got, err := KeyFromProto(pk, "s~proj")
+				So(err, ShouldBeNil)
+				So(got.Incomplete(), ShouldBeTrue)
+			})
+
+			Convey("bad", func() {
+				_, err := KeyFromProto(&pb.Key{}, "")
+				So(err, ShouldErrLike, "key has no path")
+				_, err = KeyFromProto(&pb.Key{Path: []*pb.Key_PathElement{{}}}, "")
+				So(err, ShouldErrLike, "has no kind")
+			})
+		})
+
+		Convey("entities round trip", func() {
+			pm := ds.PropertyMap{
+				"Null":   {ds.MkProperty(nil)},
+				"Int":    {ds.MkProperty(1), ds.MkPropertyNI(2)},
+				"Time":   {ds.MkProperty(time.Date(2015, time.June, 1, 2, 3, 4, 5000, time.UTC))},
+				"Bool":   {ds.MkProperty(true)},
+				"Bytes":  {ds.MkPropertyNI([]byte("hi"))},
+				"String": {ds.MkProperty("hi")},
+				"Float":  {ds.MkProperty(1.5)},
+				"Geo":    {ds.MkProperty(ds.GeoPoint{Lat: 1, Lng: 2})},
+				"Key":    {ds.MkProperty(k)},
+				"Blob":   {ds.MkProperty(blobstore.Key("bk"))},
+				"Empty":  {},
+			}
+			e, err := EntityToProto(k, pm)
+			So(err, ShouldBeNil)
+			So(e.Properties["Int"].GetArrayValue().GetValues(), ShouldHaveLength, 2)
+			So(e.Properties["Int"].GetArrayValue().GetValues()[1].ExcludeFromIndexes, ShouldBeTrue)
+			So(e.Properties["String"].GetStringValue(), ShouldEqual, "hi")
+			So(e.Properties["Blob"].Meaning, ShouldEqual, MeaningBlobKey)
+
+			gotKey, got, err := EntityFromProto(e, "s~proj")
+			So(err, ShouldBeNil)
+			So(gotKey.Equal(k), ShouldBeTrue)
+			So(got, ShouldResemble, pm)
+		})
+
+		Convey("ignores metadata", func() {
+			e, err := EntityToProto(nil, ds.PropertyMap{"$key": {ds.MkPropertyNI(k)}, "A": {ds.MkProperty(1)}})
+			So(err, ShouldBeNil)
+			So(e.Key, ShouldBeNil)
+			So(e.Properties, ShouldHaveLength, 1)
+		})
+
+		Convey("rejects embedded entities", func() {
+			e := &pb.Entity{Properties: map[string]*pb.Value{
+				"E": {ValueType: &pb.Value_EntityValue{EntityValue: &pb.Entity{}}},
+			}}
+			_, _, err := EntityFromProto(e, "")
+			So(err, ShouldErrLike, `property "E": embedded entities aren't supported`)
+		})
+	})
+}
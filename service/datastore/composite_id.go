@@ -0,0 +1,264 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CompositeIDSeparator separates the parts of a CompositeID.
+const CompositeIDSeparator = '|'
+
+// compositeIDEscape escapes CompositeIDSeparator and itself in the parts of a
+// CompositeID.
+const compositeIDEscape = '\\'
+
+// CompositeID returns a deterministic string ID built from parts, for use as a
+// key's StringID when an entity is identified by several values.
+//
+// Each part is normalized to a string: strings are used as they are, integers
+// and bools are formatted in decimal (or "true" and "false"), times are
+// formatted as RFC 3339 in UTC, and keys are encoded with Key.Encode. Any other
+// type panics. The parts are then joined with CompositeIDSeparator, escaping
+// any separators (and escape characters) inside them, so that different parts
+// never produce the same ID, and SplitCompositeID can recover them.
+func CompositeID(parts ...interface{}) string {
+	buf := bytes.Buffer{}
+	for i, p := range parts {
+		if i > 0 {
+			buf.WriteByte(CompositeIDSeparator)
+		}
+		s := compositeIDPart(p)
+		for j := 0; j < len(s); j++ {
+			if s[j] == CompositeIDSeparator || s[j] == compositeIDEscape {
+				buf.WriteByte(compositeIDEscape)
+			}
+			buf.WriteByte(s[j])
+		}
+	}
+	return buf.String()
+}
+
+// HashedCompositeID returns the hex-encoded SHA-256 hash of CompositeID(parts).
+// It's always 64 characters long, no matter how long the parts are, but it
+// can't be split back into them.
+func HashedCompositeID(parts ...interface{}) string {
+	h := sha256.Sum256([]byte(CompositeID(parts...)))
+	return hex.EncodeToString(h[:])
+}
+
+// SplitCompositeID returns the (normalized) parts of an ID returned by
+// CompositeID.
+func SplitCompositeID(id string) ([]string, error) {
+	ret := []string{}
+	part := bytes.Buffer{}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; c {
+		case compositeIDEscape:
+			if i++; i == len(id) {
+				return nil, fmt.Errorf("composite ID %q ends with an escape", id)
+			}
+			part.WriteByte(id[i])
+		case CompositeIDSeparator:
+			ret = append(ret, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(ret, part.String()), nil
+}
+
+func compositeIDPart(p interface{}) string {
+	switch x := p.(type) {
+	case string:
+		return x
+	case int:
+		return strconv.FormatInt(int64(x), 10)
+	case int8:
+		return strconv.FormatInt(int64(x), 10)
+	case int16:
+		return strconv.FormatInt(int64(x), 10)
+	case int32:
+		return strconv.FormatInt(int64(x), 10)
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case uint:
+		return strconv.FormatUint(uint64(x), 10)
+	case uint8:
+		return strconv.FormatUint(uint64(x), 10)
+	case uint16:
+		return strconv.FormatUint(uint64(x), 10)
+	case uint32:
+		return strconv.FormatUint(uint64(x), 10)
+	case uint64:
+		return strconv.FormatUint(x, 10)
+	case bool:
+		return strconv.FormatBool(x)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	case *Key:
+		return x.Encode()
+	default:
+		panic(fmt.Errorf("CompositeID: unsupported part type %T", p))
+	}
+}
+
+// compositeID implements a string $id meta which is built from other fields of
+// the struct, declared with a tag like:
+//
+//   _ struct{} `gae:"$id,fmt=%s|%d,fields=Parent ID"`
+//
+// The format may contain the verbs %s (for string fields) and %d (for integer
+// fields), and %% for a literal '%'. Consecutive verbs must be separated by
+// a non-empty literal, so that the ID can be parsed back into the fields.
+// Field values which would make the ID ambiguous (e.g. a string containing the
+// literal which follows its verb, like "a|b" for the first %s of "%s|%s") make
+// the key an error, rather than being parsed back into different values.
+type compositeID struct {
+	format string
+	verbs  []byte
+	fields []int
+
+	re *regexp.Regexp
+}
+
+// isCompositeIDTag returns true if the options of an $id meta field declare
+// a composite ID.
+func isCompositeIDTag(opts string) bool {
+	return strings.HasPrefix(opts, "fmt=")
+}
+
+func parseCompositeID(t reflect.Type, opts string) (*compositeID, error) {
+	idx := strings.LastIndex(opts, ",fields=")
+	if idx == -1 {
+		return nil, fmt.Errorf("composite $id is missing fields=")
+	}
+	ret := &compositeID{format: strings.TrimPrefix(opts[:idx], "fmt=")}
+	names := strings.Fields(opts[idx+len(",fields="):])
+
+	pattern := &bytes.Buffer{}
+	pattern.WriteByte('^')
+	literal := &bytes.Buffer{}
+	lastWasVerb := false
+	for i := 0; i < len(ret.format); i++ {
+		if ret.format[i] != '%' {
+			literal.WriteByte(ret.format[i])
+			continue
+		}
+		if i++; i == len(ret.format) {
+			return nil, fmt.Errorf("composite $id format %q ends with %%", ret.format)
+		}
+		switch verb := ret.format[i]; verb {
+		case '%':
+			literal.WriteByte('%')
+		case 's', 'd':
+			if lastWasVerb && literal.Len() == 0 {
+				return nil, fmt.Errorf("composite $id format %q has adjacent verbs", ret.format)
+			}
+			pattern.WriteString(regexp.QuoteMeta(literal.String()))
+			literal.Reset()
+			if verb == 's' {
+				pattern.WriteString("(.*?)")
+			} else {
+				pattern.WriteString(`(-?\d+)`)
+			}
+			ret.verbs = append(ret.verbs, verb)
+			lastWasVerb = true
+		default:
+			return nil, fmt.Errorf("composite $id format %q has unsupported verb %%%c", ret.format, verb)
+		}
+	}
+	pattern.WriteString(regexp.QuoteMeta(literal.String()))
+	pattern.WriteByte('$')
+	ret.re = regexp.MustCompile(pattern.String())
+
+	if len(names) != len(ret.verbs) {
+		return nil, fmt.Errorf("composite $id format %q has %d verbs, but %d fields",
+			ret.format, len(ret.verbs), len(names))
+	}
+	for i, name := range names {
+		f, ok := t.FieldByName(name)
+		if !ok || len(f.Index) != 1 {
+			return nil, fmt.Errorf("composite $id refers to unknown field %q", name)
+		}
+		if f.PkgPath != "" {
+			return nil, fmt.Errorf("composite $id refers to unexported field %q", name)
+		}
+		switch k := f.Type.Kind(); {
+		case ret.verbs[i] == 's' && k == reflect.String:
+		case ret.verbs[i] == 'd' && f.Type != typeOfToggle && (k == reflect.Int ||
+			k == reflect.Int8 || k == reflect.Int16 || k == reflect.Int32 || k == reflect.Int64 ||
+			k == reflect.Uint8 || k == reflect.Uint16 || k == reflect.Uint32):
+		default:
+			return nil, fmt.Errorf("composite $id field %q has type %s, which doesn't match %%%c",
+				name, f.Type, ret.verbs[i])
+		}
+		ret.fields = append(ret.fields, f.Index[0])
+	}
+	return ret, nil
+}
+
+// get returns the composite ID of the struct o. It returns an error if the ID
+// wouldn't parse back into the same fields, e.g. because a string field
+// contains the literal which follows it in the format.
+func (c *compositeID) get(o reflect.Value) (string, error) {
+	args := make([]interface{}, len(c.fields))
+	for i, idx := range c.fields {
+		args[i] = o.Field(idx).Interface()
+	}
+	id := fmt.Sprintf(c.format, args...)
+
+	m := c.re.FindStringSubmatch(id)
+	for i, arg := range args {
+		if m == nil || m[i+1] != fmt.Sprintf("%"+string(c.verbs[i]), arg) {
+			return "", fmt.Errorf("datastore: composite $id %q is ambiguous: field %q (%v) can't be parsed back from it",
+				id, o.Type().Field(c.fields[i]).Name, arg)
+		}
+	}
+	return id, nil
+}
+
+// set parses id and assigns its parts to the fields of the struct o. It returns
+// false (without modifying o) if id doesn't match the format.
+func (c *compositeID) set(o reflect.Value, id string) bool {
+	m := c.re.FindStringSubmatch(id)
+	if m == nil {
+		return false
+	}
+	vals := make([]reflect.Value, len(c.fields))
+	for i, idx := range c.fields {
+		f := o.Field(idx)
+		v := reflect.New(f.Type()).Elem()
+		if c.verbs[i] == 's' {
+			v.SetString(m[i+1])
+		} else if v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uint64 {
+			n, err := strconv.ParseUint(m[i+1], 10, v.Type().Bits())
+			if err != nil {
+				return false
+			}
+			v.SetUint(n)
+		} else {
+			n, err := strconv.ParseInt(m[i+1], 10, v.Type().Bits())
+			if err != nil {
+				return false
+			}
+			v.SetInt(n)
+		}
+		vals[i] = v
+	}
+	for i, idx := range c.fields {
+		o.Field(idx).Set(vals[i])
+	}
+	return true
+}
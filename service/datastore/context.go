@@ -14,6 +14,10 @@ type key int
 var (
 	rawDatastoreKey       key
 	rawDatastoreFilterKey key = 1
+	queryObserverKey      key = 2
+	transactionKey        key = 3
+	withoutTransactionKey key = 4
+	strictnessKey         key = 5
 )
 
 // RawFactory is the function signature for factory methods compatible with
@@ -39,6 +43,9 @@ func getUnfiltered(c context.Context, wantTxn bool) RawInterface {
 // getFiltered gets the datastore (transactional or not), and applies all of
 // the currently installed filters to it.
 func getFiltered(c context.Context, wantTxn bool) RawInterface {
+	if wantTxn && outsideTransaction(c) {
+		wantTxn = false
+	}
 	ret := getUnfiltered(c, wantTxn)
 	if ret == nil {
 		return nil
@@ -46,6 +53,9 @@ func getFiltered(c context.Context, wantTxn bool) RawInterface {
 	for _, f := range getCurFilters(c) {
 		ret = f(c, ret)
 	}
+	if obs := getQueryObservers(c); obs != nil {
+		ret = &queryObserverFilter{ret, c, obs}
+	}
 	return applyCheckFilter(c, ret)
 }
 
@@ -58,7 +68,7 @@ func GetRaw(c context.Context) RawInterface {
 // currently active transaction, this will return a non-transactional connection
 // to the datastore, otherwise this is the same as GetRaw.
 func GetRawNoTxn(c context.Context) RawInterface {
-	return getFiltered(c, false)
+	return getFiltered(WithoutTransaction(c), false)
 }
 
 // Get gets the Interface implementation from context.
@@ -68,6 +78,7 @@ func Get(c context.Context) Interface {
 		GetRaw(c),
 		inf.FullyQualifiedAppID(),
 		inf.GetNamespace(),
+		c,
 	}
 }
 
@@ -81,6 +92,7 @@ func GetNoTxn(c context.Context) Interface {
 		GetRawNoTxn(c),
 		inf.FullyQualifiedAppID(),
 		inf.GetNamespace(),
+		c,
 	}
 }
 
@@ -45,7 +45,7 @@ func TestServices(t *testing.T) {
 			c = SetRaw(info.Set(c, fakeInfo{}), fakeService{})
 
 			Convey("lets you pull them back out", func() {
-				So(GetRaw(c), ShouldResemble, &checkFilter{fakeService{}, "s~aid", "ns"})
+				So(GetRaw(c), ShouldResemble, &checkFilter{fakeService{}, c, "s~aid", "ns", nil, StrictnessDefault})
 			})
 
 			Convey("and lets you add filters", func() {
@@ -15,6 +15,7 @@ import (
 	"strings"
 
 	"github.com/luci/luci-go/common/errors"
+	"golang.org/x/net/context"
 
 	"gopkg.in/yaml.v2"
 )
@@ -24,6 +25,10 @@ type datastoreImpl struct {
 
 	aid string
 	ns  string
+
+	// c is the Context that this Interface was obtained from. It's passed to
+	// the BeforeSave/AfterLoad hooks.
+	c context.Context
 }
 
 var _ Interface = (*datastoreImpl)(nil)
@@ -102,15 +107,63 @@ func runParseCallback(cbIface interface{}) (isKey, hasErr, hasCursorCB bool, mat
 }
 
 func (d *datastoreImpl) Run(q *Query, cbIface interface{}) error {
-	isKey, hasErr, hasCursorCB, mat := runParseCallback(cbIface)
+	isKey, cb := d.runRawCallback(cbIface)
 
-	if isKey {
-		q = q.KeysOnly(true)
+	fqs, err := finalizeAll([]*Query{q}, isKey)
+	if err != nil {
+		return err
 	}
-	fq, err := q.Finalize()
+	return d.runAll(fqs, cb)
+}
+
+func (d *datastoreImpl) RunKeys(q *Query, cb func(*Key, CursorCB) error) error {
+	fqs, err := finalizeAll([]*Query{q}, true)
 	if err != nil {
 		return err
 	}
+	return d.runAll(fqs, func(k *Key, _ PropertyMap, gc CursorCB) error {
+		return cb(k, gc)
+	})
+}
+
+// runAll runs fqs, merging their results with runMulti if there's more than
+// one of them.
+func (d *datastoreImpl) runAll(fqs []*FinalizedQuery, cb RawRunCB) error {
+	if len(fqs) == 1 {
+		return d.RawInterface.Run(fqs[0], cb)
+	}
+	return runMulti(d.RawInterface, fqs, cb)
+}
+
+// finalizeAll expands the In filters of queries (see Query.Expand), and
+// finalizes the resulting queries. If keysOnly is true, they're made keys-only
+// first.
+func finalizeAll(queries []*Query, keysOnly bool) ([]*FinalizedQuery, error) {
+	fqs := make([]*FinalizedQuery, 0, len(queries))
+	for _, q := range queries {
+		if keysOnly {
+			q = q.KeysOnly(true)
+		}
+		expanded, err := q.Expand()
+		if err != nil {
+			return nil, err
+		}
+		for _, eq := range expanded {
+			fq, err := eq.Finalize()
+			if err != nil {
+				return nil, err
+			}
+			fqs = append(fqs, fq)
+		}
+	}
+	return fqs, nil
+}
+
+// runRawCallback adapts a user callback passed to Run (or RunMulti) into a
+// RawRunCB. isKey is true if the callback takes a *Key, which implies a
+// keys-only query.
+func (d *datastoreImpl) runRawCallback(cbIface interface{}) (isKey bool, rawCB RawRunCB) {
+	isKey, hasErr, hasCursorCB, mat := runParseCallback(cbIface)
 
 	cbVal := reflect.ValueOf(cbIface)
 	var cb func(reflect.Value, CursorCB) error
@@ -147,27 +200,54 @@ func (d *datastoreImpl) Run(q *Query, cbIface interface{}) error {
 	}
 
 	if isKey {
-		return d.RawInterface.Run(fq, func(k *Key, _ PropertyMap, gc CursorCB) error {
+		return true, func(k *Key, _ PropertyMap, gc CursorCB) error {
 			return cb(reflect.ValueOf(k), gc)
-		})
+		}
 	}
 
-	return d.RawInterface.Run(fq, func(k *Key, pm PropertyMap, gc CursorCB) error {
+	return false, func(k *Key, pm PropertyMap, gc CursorCB) error {
 		itm := mat.newElem()
 		if err := mat.setPM(itm, pm); err != nil {
 			return err
 		}
 		mat.setKey(itm, k)
+		if err := mat.afterLoad(d.c, itm); err != nil {
+			return err
+		}
 		return cb(itm, gc)
-	})
+	}
+}
+
+func (d *datastoreImpl) RunMulti(queries []*Query, cbIface interface{}) error {
+	isKey, cb := d.runRawCallback(cbIface)
+
+	fqs, err := finalizeAll(queries, isKey)
+	if err != nil {
+		return err
+	}
+	return runMulti(d.RawInterface, fqs, cb)
 }
 
 func (d *datastoreImpl) Count(q *Query) (int64, error) {
-	fq, err := q.Finalize()
+	fqs, err := finalizeAll([]*Query{q}, false)
 	if err != nil {
 		return 0, err
 	}
-	return d.RawInterface.Count(fq)
+	if len(fqs) == 1 {
+		return d.RawInterface.Count(fqs[0])
+	}
+
+	// The expanded queries may have results in common, so they can't just be
+	// counted separately.
+	if fqs, err = finalizeAll([]*Query{q}, true); err != nil {
+		return 0, err
+	}
+	count := int64(0)
+	err = runMulti(d.RawInterface, fqs, func(*Key, PropertyMap, CursorCB) error {
+		count++
+		return nil
+	})
+	return count, err
 }
 
 func (d *datastoreImpl) GetAll(q *Query, dst interface{}) error {
@@ -180,17 +260,17 @@ func (d *datastoreImpl) GetAll(q *Query, dst interface{}) error {
 	}
 
 	if keys, ok := dst.(*[]*Key); ok {
-		fq, err := q.KeysOnly(true).Finalize()
+		fqs, err := finalizeAll([]*Query{q}, true)
 		if err != nil {
 			return err
 		}
 
-		return d.RawInterface.Run(fq, func(k *Key, _ PropertyMap, _ CursorCB) error {
+		return d.runAll(fqs, func(k *Key, _ PropertyMap, _ CursorCB) error {
 			*keys = append(*keys, k)
 			return nil
 		})
 	}
-	fq, err := q.Finalize()
+	fqs, err := finalizeAll([]*Query{q}, false)
 	if err != nil {
 		return err
 	}
@@ -203,11 +283,14 @@ func (d *datastoreImpl) GetAll(q *Query, dst interface{}) error {
 
 	errs := map[int]error{}
 	i := 0
-	err = d.RawInterface.Run(fq, func(k *Key, pm PropertyMap, _ CursorCB) error {
+	err = d.runAll(fqs, func(k *Key, pm PropertyMap, _ CursorCB) error {
 		slice.Set(reflect.Append(slice, mat.newElem()))
 		itm := slice.Index(i)
 		mat.setKey(itm, k)
 		err := mat.setPM(itm, pm)
+		if err == nil {
+			err = mat.afterLoad(d.c, itm)
+		}
 		if err != nil {
 			errs[i] = err
 		}
@@ -248,8 +331,12 @@ func isOkType(t reflect.Type) error {
 func (d *datastoreImpl) ExistsMulti(keys []*Key) (BoolList, error) {
 	lme := errors.NewLazyMultiError(len(keys))
 	ret := make(BoolList, len(keys))
+	metas := make(MultiMetaGetter, len(keys))
+	for i := range metas {
+		metas[i] = keysOnlyMetaGetter
+	}
 	i := 0
-	err := d.RawInterface.GetMulti(keys, nil, func(_ PropertyMap, err error) error {
+	err := d.RawInterface.GetMulti(keys, metas, func(_ PropertyMap, err error) error {
 		if err == nil {
 			ret[i] = true
 		} else if err != ErrNoSuchEntity {
@@ -288,20 +375,35 @@ func (d *datastoreImpl) Delete(key *Key) (err error) {
 }
 
 func (d *datastoreImpl) GetMulti(dst interface{}) error {
+	_, err := d.getMulti(dst, false)
+	return err
+}
+
+func (d *datastoreImpl) GetMultiExists(dst interface{}) (BoolList, error) {
+	return d.getMulti(dst, true)
+}
+
+// getMulti implements GetMulti and GetMultiExists. If missingOK is true,
+// ErrNoSuchEntity isn't an error.
+func (d *datastoreImpl) getMulti(dst interface{}, missingOK bool) (BoolList, error) {
 	slice := reflect.ValueOf(dst)
 	mat := parseMultiArg(slice.Type())
 
-	keys, pms, err := mat.GetKeysPMs(d.aid, d.ns, slice, true)
+	keys, pms, err := mat.GetKeysPMs(d.c, d.aid, d.ns, slice, true)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
+	exists := make(BoolList, len(keys))
 	lme := errors.NewLazyMultiError(len(keys))
 	i := 0
 	meta := NewMultiMetaGetter(pms)
 	err = d.RawInterface.GetMulti(keys, meta, func(pm PropertyMap, err error) error {
-		if !lme.Assign(i, err) {
-			lme.Assign(i, mat.setPM(slice.Index(i), pm))
+		exists[i] = err == nil
+		switch {
+		case missingOK && err == ErrNoSuchEntity:
+		case !lme.Assign(i, err) && !lme.Assign(i, mat.setPM(slice.Index(i), pm)):
+			lme.Assign(i, mat.afterLoad(d.c, slice.Index(i)))
 		}
 		i++
 		return nil
@@ -310,14 +412,14 @@ func (d *datastoreImpl) GetMulti(dst interface{}) error {
 	if err == nil {
 		err = lme.Get()
 	}
-	return err
+	return exists, err
 }
 
 func (d *datastoreImpl) PutMulti(src interface{}) error {
 	slice := reflect.ValueOf(src)
 	mat := parseMultiArg(slice.Type())
 
-	keys, vals, err := mat.GetKeysPMs(d.aid, d.ns, slice, false)
+	keys, vals, err := mat.GetKeysPMs(d.c, d.aid, d.ns, slice, false)
 	if err != nil {
 		return err
 	}
@@ -13,6 +13,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 
 	"github.com/tetrafolium/gae/service/info"
@@ -323,6 +324,37 @@ func TestKeyForObj(t *testing.T) {
 				So(ds.KeyForObj(pls).String(), ShouldEqual, `s~aid:ns:/Hello,"world"/CommonStruct,1`)
 			})
 
+			Convey("struct with a composite $id", func() {
+				type Composite struct {
+					_    struct{} `gae:"$id,fmt=%s:%d,fields=Name Rev"`
+					Name string
+					Rev  int
+				}
+				So(ds.KeyForObj(&Composite{Name: "doc", Rev: 3}).String(), ShouldEqual, `s~aid:ns:/Composite,"doc:3"`)
+
+				_, err := ds.KeyForObjErr(&Composite{Name: "doc:3", Rev: 3})
+				So(err, ShouldBeNil)
+
+				type Pair struct {
+					_ struct{} `gae:"$id,fmt=%s:%s,fields=A B"`
+					A string
+					B string
+				}
+				_, err = ds.KeyForObjErr(&Pair{A: "a:b", B: "c"})
+				So(err, ShouldErrLike, "is ambiguous")
+				So(ds.Put(&Pair{A: "a:b", B: "c"}), ShouldErrLike, "is ambiguous")
+			})
+
+			Convey("an invalid $parent is an error", func() {
+				_, err := ds.KeyForObjErr(&CommonStruct{ID: 1, Parent: ds.MakeKey("Hello", 0)})
+				So(err, ShouldEqual, ErrInvalidKey)
+
+				_, err = ds.KeyForObjErr(&CommonStruct{ID: 1, Parent: MakeKey("s~aid", "other", "Hello", "world")})
+				So(err, ShouldEqual, ErrInvalidKey)
+
+				So(func() { ds.KeyForObj(&CommonStruct{ID: 1, Parent: ds.MakeKey("Hello", 0)}) }, ShouldPanic)
+			})
+
 			Convey("can see if things exist", func() {
 				e, err := ds.Exists(k)
 				So(err, ShouldBeNil)
@@ -354,6 +386,28 @@ func TestKeyForObj(t *testing.T) {
 				So(err, ShouldErrLike, "GetMulti fail")
 			})
 
+			Convey("GetMultiExists reports which entities exist", func() {
+				mkPM := func(k *Key) PropertyMap { return PropertyMap{"$key": {MkPropertyNI(k)}} }
+				pms := []PropertyMap{mkPM(k), mkPM(ds.MakeKey("DNE", "nope")), mkPM(ds.MakeKey("hello", "other"))}
+
+				bl, err := ds.GetMultiExists(pms)
+				So(err, ShouldBeNil)
+				So(bl, ShouldResemble, BoolList{true, false, true})
+				So(pms[0].GetInt64("Value", 0), ShouldEqual, 1)
+				So(pms[1].GetInt64("Value", 0), ShouldEqual, 0)
+				So(pms[2].GetInt64("Value", 0), ShouldEqual, 3)
+
+				So(ds.GetMulti(pms), ShouldResemble, errors.MultiError{nil, ErrNoSuchEntity, nil})
+
+				pms = append(pms, mkPM(ds.MakeKey("Fail", "boom")))
+				bl, err = ds.GetMultiExists(pms)
+				So(err, ShouldResemble, errors.MultiError{nil, nil, nil, errors.New("GetMulti fail")})
+				So(bl, ShouldResemble, BoolList{true, false, true, false})
+
+				_, err = ds.GetMultiExists([]PropertyMap{mkPM(ds.MakeKey("FailAll", 1))})
+				So(err, ShouldErrLike, "GetMulti fail all")
+			})
+
 		})
 
 		Convey("bad", func() {
@@ -1018,6 +1072,82 @@ func TestRun(t *testing.T) {
 				}), ShouldBeNil)
 			})
 
+			Convey("RunKeys", func() {
+				i := 0
+				So(ds.RunKeys(q, func(k *Key, gc CursorCB) error {
+					So(k.IntID(), ShouldEqual, i+1)
+					curs, err := gc()
+					So(err, ShouldBeNil)
+					So(curs.String(), ShouldEqual, "CURSOR")
+					i++
+					if i == 3 {
+						return Stop
+					}
+					return nil
+				}), ShouldBeNil)
+				So(i, ShouldEqual, 3)
+
+				So(ds.RunKeys(q, func(*Key, CursorCB) error {
+					return errors.New("bad")
+				}), ShouldErrLike, "bad")
+			})
+
+		})
+	})
+}
+
+func TestRunMulti(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test RunMulti", t, func() {
+		c := info.Set(context.Background(), fakeInfo{})
+		c = SetRawFactory(c, fakeDatastoreFactory)
+		ds := Get(c)
+
+		Convey("merges and deduplicates results", func() {
+			ids := []int64(nil)
+			So(ds.RunMulti([]*Query{NewQuery("kind").Limit(3), NewQuery("kind").Limit(5)}, func(cs *CommonStruct) {
+				ids = append(ids, cs.ID)
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{1, 2, 3, 4, 5})
+		})
+
+		Convey("limits results to the largest limit", func() {
+			ids := []int64(nil)
+			So(ds.RunMulti([]*Query{NewQuery("kind").Limit(2), NewQuery("kind").Limit(4)}, func(k *Key) {
+				ids = append(ids, k.IntID())
+			}), ShouldBeNil)
+			So(ids, ShouldResemble, []int64{1, 2, 3, 4})
+		})
+
+		Convey("can return error to stop", func() {
+			i := 0
+			So(ds.RunMulti([]*Query{NewQuery("kind").Limit(5), NewQuery("kind").Limit(5)}, func(k *Key) error {
+				i++
+				return Stop
+			}), ShouldBeNil)
+			So(i, ShouldEqual, 1)
+		})
+
+		Convey("doesn't support cursors", func() {
+			So(ds.RunMulti([]*Query{NewQuery("kind").Limit(1)}, func(k *Key, ccb CursorCB) error {
+				_, err := ccb()
+				return err
+			}), ShouldEqual, ErrRunMultiCursor)
+		})
+
+		Convey("returns query errors", func() {
+			bad := NewQuery("kind").Limit(5).Eq("$err_single", "Query fail").Eq("$err_single_idx", 3)
+			So(ds.RunMulti([]*Query{NewQuery("kind").Limit(5), bad}, func(k *Key) {}), ShouldErrLike, "Query fail")
+		})
+
+		Convey("bad", func() {
+			So(ds.RunMulti([]*Query{NewQuery("kind"), NewQuery("kind").Order("Value")}, func(k *Key) {}),
+				ShouldErrLike, "query 1 has orders")
+			So(ds.RunMulti([]*Query{NewQuery("kind").Offset(1)}, func(k *Key) {}),
+				ShouldErrLike, "query 0 has an offset")
+			So(ds.RunMulti([]*Query{NewQuery("kind").Project("Value")}, func(pm PropertyMap) {}),
+				ShouldErrLike, "query 0 is a projection query")
 		})
 	})
 }
@@ -1059,7 +1189,7 @@ func TestSchemaChange(t *testing.T) {
 
 	Convey("Test changing schemas", t, func() {
 		fds := fixedDataDatastore{}
-		ds := &datastoreImpl{&fds, "", ""}
+		ds := &datastoreImpl{&fds, "", "", context.Background()}
 
 		Convey("Can add fields", func() {
 			initial := PropertyMap{
@@ -1315,6 +1445,138 @@ func TestSchemaChange(t *testing.T) {
 	})
 }
 
+type HookStruct struct {
+	ID    int64 `gae:"$id"`
+	Value string
+
+	Saved  int    `gae:"-"`
+	Loaded int    `gae:"-"`
+	Err    string `gae:"-"`
+}
+
+func (h *HookStruct) BeforeSave(c context.Context) error {
+	if h.Err != "" {
+		return errors.New(h.Err)
+	}
+	h.Saved++
+	h.Value = strings.ToUpper(h.Value)
+	return nil
+}
+
+func (h *HookStruct) AfterLoad(c context.Context) error {
+	if h.Value == "BAD" {
+		return errors.New("bad value")
+	}
+	h.Loaded++
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test BeforeSave/AfterLoad hooks", t, func() {
+		fds := fixedDataDatastore{}
+		ds := &datastoreImpl{&fds, "", "", context.Background()}
+
+		Convey("BeforeSave runs before Put", func() {
+			h := &HookStruct{ID: 1, Value: "hello"}
+			So(ds.Put(h), ShouldBeNil)
+			So(h.Saved, ShouldEqual, 1)
+			So(fds.data[ds.MakeKey("HookStruct", 1).String()]["Value"], ShouldResemble,
+				[]Property{mp("HELLO")})
+
+			Convey("and AfterLoad runs after Get", func() {
+				h := &HookStruct{ID: 1}
+				So(ds.Get(h), ShouldBeNil)
+				So(h.Loaded, ShouldEqual, 1)
+				So(h.Value, ShouldEqual, "HELLO")
+			})
+		})
+
+		Convey("BeforeSave errors prevent the Put", func() {
+			hs := []*HookStruct{{ID: 1}, {ID: 2, Err: "nope"}}
+			So(ds.PutMulti(hs), ShouldResemble, errors.MultiError{nil, errors.New("nope")})
+			So(fds.data, ShouldBeEmpty)
+		})
+
+		Convey("AfterLoad errors are returned per-entity", func() {
+			So(ds.PutMulti([]*HookStruct{{ID: 1, Value: "ok"}, {ID: 2, Value: "bad"}}), ShouldBeNil)
+
+			hs := []*HookStruct{{ID: 1}, {ID: 2}}
+			So(ds.GetMulti(hs), ShouldResemble, errors.MultiError{nil, errors.New("bad value")})
+			So(hs[0].Loaded, ShouldEqual, 1)
+			So(hs[1].Loaded, ShouldEqual, 0)
+		})
+	})
+}
+
+type ValidatedStruct struct {
+	ID    int64 `gae:"$id"`
+	Value string
+}
+
+func (v *ValidatedStruct) Validate() error {
+	if v.Value == "" {
+		return errors.New("Value is required")
+	}
+	return nil
+}
+
+type ValidatedProperties struct {
+	_     Toggle `gae:"$validate,true"`
+	ID    int64  `gae:"$id"`
+	Value []string
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test Validator", t, func() {
+		fds := fixedDataDatastore{}
+		ds := &datastoreImpl{&fds, "", "", context.Background()}
+
+		Convey("valid entities are Put", func() {
+			So(ds.Put(&ValidatedStruct{ID: 1, Value: "ok"}), ShouldBeNil)
+			So(fds.data, ShouldNotBeEmpty)
+		})
+
+		Convey("invalid entities prevent the Put", func() {
+			vs := []*ValidatedStruct{{ID: 1, Value: "ok"}, {ID: 2}}
+			err := ds.PutMulti(vs)
+			So(err, ShouldResemble, errors.MultiError{
+				nil, &ValidationError{ds.MakeKey("ValidatedStruct", 2), errors.New("Value is required")},
+			})
+			So(err, ShouldErrLike, "datastore: invalid entity ::/ValidatedStruct,2: Value is required")
+			So(fds.data, ShouldBeEmpty)
+		})
+
+		Convey("$validate is saved as a meta", func() {
+			pm, err := GetPLS(&ValidatedProperties{}).Save(true)
+			So(err, ShouldBeNil)
+			So(GetMetaDefault(pm, "validate", false), ShouldBeTrue)
+		})
+	})
+
+	Convey("ValidateProperties", t, func() {
+		So(ValidateProperties(PropertyMap{"$meta": {mp(1)}, "Good": {mp(1)}}), ShouldBeNil)
+		So(ValidateProperties(PropertyMap{"": {mp(1)}}), ShouldErrLike, "empty name")
+		So(ValidateProperties(PropertyMap{"__key__": {mp(1)}}), ShouldErrLike, `"__key__" is reserved`)
+
+		vals := make([]Property, maxIndexedProperties+1)
+		for i := range vals {
+			vals[i] = mp(int64(i))
+		}
+		So(ValidateProperties(PropertyMap{"Many": vals}), ShouldErrLike, "more than the limit")
+		So(ValidateProperties(PropertyMap{"Many": vals[1:]}), ShouldBeNil)
+
+		long := make([]byte, 1501)
+		So(ValidateProperties(PropertyMap{"Long": {mpNI(long)}}), ShouldBeNil)
+		So(ValidateProperties(PropertyMap{"Long": {mp(long[1:])}}), ShouldBeNil)
+		So(ValidateProperties(PropertyMap{"Long": {mp(long)}}), ShouldErrLike,
+			`indexed PTBytes value of property "Long" is 1501 bytes`)
+	})
+}
+
 func TestParseIndexYAML(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,140 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package dstest has helpers for tests of datastore code which don't use
+// GoConvey: comparing entities and PropertyMaps with readable diffs, and
+// checking the per-item errors of multi-calls like GetMulti.
+//
+// The Expect* functions report failures with T.Errorf, so they don't stop
+// the test, and keep no state, so they may be used from parallel tests:
+//
+//   func TestPut(t *testing.T) {
+//     t.Parallel()
+//     ...
+//     dstest.ExpectErrors(t, ds.PutMulti(foos), nil, nil)
+//     got := &Foo{ID: 1}
+//     dstest.ExpectErrors(t, ds.Get(got), nil)
+//     dstest.ExpectEntity(t, &Foo{ID: 1, Val: "hi"}, got)
+//   }
+package dstest
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/luci/luci-go/common/errors"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+// T is the part of testing.TB which the Expect* functions use.
+type T interface {
+	Errorf(format string, args ...interface{})
+}
+
+// DiffPropertyMaps returns a human-readable description of the differences
+// between want and got (see PropertyMap.Diff), with a line for each
+// property. It returns "" if they're the same.
+func DiffPropertyMaps(want, got ds.PropertyMap) string {
+	buf := bytes.Buffer{}
+	for _, d := range want.Diff(got) {
+		switch {
+		case d.New == nil:
+			fmt.Fprintf(&buf, "%q: missing, want %v\n", d.Name, d.Old)
+		case d.Old == nil:
+			fmt.Fprintf(&buf, "%q: unexpected %v\n", d.Name, d.New)
+		default:
+			fmt.Fprintf(&buf, "%q: got %v, want %v\n", d.Name, d.New, d.Old)
+		}
+	}
+	return buf.String()
+}
+
+// DiffEntities is like DiffPropertyMaps, but compares two entities (pointers
+// to structs, or PropertyLoadSavers, as accepted by Get) by their saved
+// properties, including their metadata (e.g. "$id" and "$kind").
+func DiffEntities(want, got interface{}) (string, error) {
+	wpm, err := save(want)
+	if err != nil {
+		return "", err
+	}
+	gpm, err := save(got)
+	if err != nil {
+		return "", err
+	}
+	return DiffPropertyMaps(wpm, gpm), nil
+}
+
+func save(obj interface{}) (ds.PropertyMap, error) {
+	pls, ok := obj.(ds.PropertyLoadSaver)
+	if !ok {
+		pls = ds.GetPLS(obj)
+	}
+	pm, err := pls.Save(true)
+	if err != nil {
+		return nil, fmt.Errorf("dstest: saving %T: %s", obj, err)
+	}
+	return pm, nil
+}
+
+// ExpectPropertyMap reports an error to t if got isn't the same as want.
+func ExpectPropertyMap(t T, want, got ds.PropertyMap) {
+	if diff := DiffPropertyMaps(want, got); diff != "" {
+		t.Errorf("PropertyMaps differ:\n%s", diff)
+	}
+}
+
+// ExpectEntity reports an error to t if got isn't the same entity as want
+// (see DiffEntities).
+func ExpectEntity(t T, want, got interface{}) {
+	diff, err := DiffEntities(want, got)
+	switch {
+	case err != nil:
+		t.Errorf("%s", err)
+	case diff != "":
+		t.Errorf("%T entities differ:\n%s", got, diff)
+	}
+}
+
+// Errors returns the per-item errors of a multi-call (like GetMulti) of n
+// items which returned err: a nil err is n nils, an errors.MultiError is
+// itself, and any other error applies to every item.
+func Errors(err error, n int) []error {
+	ret := make([]error, n)
+	switch e := err.(type) {
+	case nil:
+	case errors.MultiError:
+		copy(ret, e)
+	default:
+		for i := range ret {
+			ret[i] = e
+		}
+	}
+	return ret
+}
+
+// ExpectErrors reports an error to t unless err has the per-item errors want
+// (see Errors). An item's error matches if it's the same as the wanted one, or
+// has the same message. If err is an errors.MultiError, it must have
+// len(want) items.
+//
+// For a single-item call, like Get, this checks err itself.
+func ExpectErrors(t T, err error, want ...error) {
+	if me, ok := err.(errors.MultiError); ok && len(me) != len(want) {
+		t.Errorf("got %d errors, want %d: %s", len(me), len(want), err)
+		return
+	}
+	for i, got := range Errors(err, len(want)) {
+		if !errorMatches(got, want[i]) {
+			t.Errorf("item %d: got error %v, want %v", i, got, want[i])
+		}
+	}
+}
+
+func errorMatches(got, want error) bool {
+	if got == nil || want == nil {
+		return got == want
+	}
+	return got == want || got.Error() == want.Error()
+}
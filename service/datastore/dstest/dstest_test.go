@@ -0,0 +1,92 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package dstest
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	lerrors "github.com/luci/luci-go/common/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+type recorder []string
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	*r = append(*r, fmt.Sprintf(format, args...))
+}
+
+type Foo struct {
+	ID  int64 `gae:"$id"`
+	Val string
+}
+
+func TestDSTest(t *testing.T) {
+	t.Parallel()
+
+	Convey("dstest", t, func() {
+		r := recorder(nil)
+
+		Convey("compares PropertyMaps", func() {
+			want := ds.PropertyMap{
+				"A": {ds.MkProperty(1)},
+				"B": {ds.MkProperty("b")},
+			}
+			So(DiffPropertyMaps(want, want.Clone()), ShouldEqual, "")
+			ExpectPropertyMap(&r, want, want.Clone())
+			So(r, ShouldBeEmpty)
+
+			got := ds.PropertyMap{
+				"B": {ds.MkPropertyNI("b")},
+				"C": {ds.MkProperty(true)},
+			}
+			So(DiffPropertyMaps(want, got), ShouldEqual,
+				`"A": missing, want [PTInt(1)]`+"\n"+
+					`"B": got [PTString("b", NoIndex)], want [PTString("b")]`+"\n"+
+					`"C": unexpected [PTBool(true)]`+"\n")
+			ExpectPropertyMap(&r, want, got)
+			So(len(r), ShouldEqual, 1)
+		})
+
+		Convey("compares entities", func() {
+			ExpectEntity(&r, &Foo{ID: 1, Val: "a"}, &Foo{ID: 1, Val: "a"})
+			So(r, ShouldBeEmpty)
+
+			diff, err := DiffEntities(&Foo{ID: 1, Val: "a"}, &Foo{ID: 2, Val: "a"})
+			So(err, ShouldBeNil)
+			So(diff, ShouldEqual, `"$id": got [PTInt(2, NoIndex)], want [PTInt(1, NoIndex)]`+"\n")
+
+			pm := ds.PropertyMap{"$id": {ds.MkPropertyNI(1)}, "$kind": {ds.MkPropertyNI("Foo")}, "Val": {ds.MkProperty("b")}}
+			ExpectEntity(&r, &Foo{ID: 1, Val: "a"}, pm)
+			So(r, ShouldResemble, recorder{"datastore.PropertyMap entities differ:\n" +
+				`"Val": got [PTString("b")], want [PTString("a")]` + "\n"})
+		})
+
+		Convey("checks errors", func() {
+			bad := errors.New("bad")
+			So(Errors(nil, 2), ShouldResemble, []error{nil, nil})
+			So(Errors(bad, 2), ShouldResemble, []error{bad, bad})
+			So(Errors(lerrors.MultiError{nil, bad}, 2), ShouldResemble, []error{nil, bad})
+
+			ExpectErrors(&r, nil, nil, nil)
+			ExpectErrors(&r, bad, errors.New("bad"))
+			ExpectErrors(&r, lerrors.MultiError{nil, bad}, nil, bad)
+			So(r, ShouldBeEmpty)
+
+			ExpectErrors(&r, lerrors.MultiError{nil, bad}, nil)
+			ExpectErrors(&r, lerrors.MultiError{nil, bad}, bad, nil)
+			ExpectErrors(&r, nil, bad)
+			So(r, ShouldResemble, recorder{
+				"got 2 errors, want 1: bad",
+				"item 0: got error <nil>, want bad",
+				"item 1: got error bad, want <nil>",
+				"item 0: got error <nil>, want bad",
+			})
+		})
+	})
+}
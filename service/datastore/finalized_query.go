@@ -29,8 +29,9 @@ type FinalizedQuery struct {
 	start Cursor
 	end   Cursor
 
-	project []string
-	orders  []IndexColumn
+	project    []string
+	distinctOn []string
+	orders     []IndexColumn
 
 	eqFilts map[string]PropertySlice
 
@@ -79,6 +80,19 @@ func (q *FinalizedQuery) Distinct() bool {
 	return q.distinct
 }
 
+// DistinctOn returns the (sorted) fields which this projection query returns
+// distinct combinations of, or nil if it isn't a distinct-on query. It's
+// always a strict subset of Project(); if a query is distinct on all of its
+// projected fields, it's a Distinct query instead.
+func (q *FinalizedQuery) DistinctOn() []string {
+	if len(q.distinctOn) == 0 {
+		return nil
+	}
+	ret := make([]string, len(q.distinctOn))
+	copy(ret, q.distinctOn)
+	return ret
+}
+
 // KeysOnly returns true iff this query will only return keys (as opposed to a
 // normal or projection query).
 func (q *FinalizedQuery) KeysOnly() bool {
@@ -233,6 +247,12 @@ func (q *FinalizedQuery) GQL() string {
 	if len(q.project) != 0 {
 		if q.distinct {
 			ws(" DISTINCT")
+		} else if len(q.distinctOn) != 0 {
+			on := make([]string, len(q.distinctOn))
+			for i, p := range q.distinctOn {
+				on[i] = gqlQuoteName(p)
+			}
+			fmt.Fprintf(&ret, " DISTINCT ON (%s)", strings.Join(on, ", "))
 		}
 		proj := make([]string, len(q.project))
 		for i, p := range q.project {
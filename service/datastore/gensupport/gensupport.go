@@ -0,0 +1,96 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package gensupport contains runtime support for the PropertyLoadSaver and
+// MetaGetterSetter implementations generated by tools/gaegen. It isn't meant
+// to be used directly.
+package gensupport
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+// FieldMismatch returns a *datastore.ErrFieldMismatch for the struct pointed to
+// by obj.
+func FieldMismatch(obj interface{}, name, reason string) error {
+	return &ds.ErrFieldMismatch{
+		StructType: reflect.TypeOf(obj).Elem(),
+		FieldName:  name,
+		Reason:     reason,
+	}
+}
+
+// Project projects p to the PropertyType to. If p can't be projected, it
+// returns a non-empty ErrFieldMismatch reason, describing the mismatch between
+// p and a struct field of Go type goType.
+func Project(p ds.Property, to ds.PropertyType, goType string) (interface{}, string) {
+	v, err := p.Project(to)
+	if err != nil {
+		return nil, fmt.Sprintf("type mismatch: %T versus %s", p.Value(), goType)
+	}
+	return v, ""
+}
+
+// Overflow returns the ErrFieldMismatch reason used when the value v
+// doesn't fit in a struct field of Go type goType.
+func Overflow(v interface{}, goType string) string {
+	return fmt.Sprintf("value %v overflows struct field of type %s", v, goType)
+}
+
+// MetaInt converts a value passed to SetMeta to an int64.
+func MetaInt(val interface{}) (int64, bool) {
+	ret, ok := ds.UpconvertUnderlyingType(val).(int64)
+	return ret, ok
+}
+
+// MetaString converts a value passed to SetMeta to a string.
+func MetaString(val interface{}) (string, bool) {
+	ret, ok := ds.UpconvertUnderlyingType(val).(string)
+	return ret, ok
+}
+
+// MetaToggle converts a value passed to SetMeta to a Toggle. A bool is
+// converted to On or Off.
+func MetaToggle(val interface{}) (ds.Toggle, bool) {
+	switch x := val.(type) {
+	case bool:
+		if x {
+			return ds.On, true
+		}
+		return ds.Off, true
+	case ds.Toggle:
+		return x, true
+	}
+	return ds.Auto, false
+}
+
+// MetaKey converts a value passed to SetMeta to a *Key.
+func MetaKey(val interface{}) (*ds.Key, bool) {
+	ret, ok := val.(*ds.Key)
+	return ret, ok
+}
+
+// OverflowFloat32 returns true iff x doesn't fit in a float32 (matching
+// reflect.Value.OverflowFloat).
+func OverflowFloat32(x float64) bool {
+	if x < 0 {
+		x = -x
+	}
+	return math.MaxFloat32 < x && x <= math.MaxFloat64
+}
+
+// DefaultKind returns the default $kind of obj: the result of its Kind
+// method if it implements KindGetter (and it's not empty), or typeName.
+func DefaultKind(obj interface{}, typeName string) string {
+	if kg, ok := obj.(ds.KindGetter); ok {
+		if kind := kg.Kind(); kind != "" {
+			return kind
+		}
+	}
+	return typeName
+}
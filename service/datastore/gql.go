@@ -0,0 +1,597 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+)
+
+// ParseGQL parses a Cloud Datastore GQL query into a Query. It's meant for
+// admin tooling and debug handlers which accept ad-hoc queries, and it
+// accepts everything emitted by FinalizedQuery.GQL:
+//
+//   SELECT (* | __key__ | [DISTINCT [ON (<property>, ...)]] <property>, ...)
+//     [FROM <kind>]
+//     [WHERE <condition> [AND <condition> ...]]
+//     [ORDER BY <property> [ASC | DESC], ...]
+//     [LIMIT <n>] [OFFSET <n>]
+//
+// where a condition is one of:
+//
+//   <property> (= | < | <= | > | >=) <value>
+//   <property> IS NULL
+//   __key__ HAS ANCESTOR <key>
+//
+// Keywords are case-insensitive. Names may be bare identifiers or quoted with
+// backticks. Values are integers, floats, "strings" (or 'strings'), TRUE,
+// FALSE, NULL, or one of:
+//
+//   KEY([DATASET("app"),] [NAMESPACE("ns"),] "Kind", <id>, ...)
+//   BLOB("<url-safe base64>")
+//   BLOBKEY("<key>")
+//   DATETIME("<RFC 3339 time>")
+//   GEOPOINT(<lat>, <lng>)
+//
+// A KEY without a DATASET has an empty app ID (and so won't be valid for
+// filtering in most contexts). Binding arguments (@name) aren't supported.
+//
+// The query is finalized to check it for errors, so the returned Query will
+// always Finalize successfully.
+func ParseGQL(gql string) (*Query, error) {
+	p := &gqlParser{input: gql}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	q, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := q.Finalize(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+type gqlTokenType int
+
+const (
+	gqlEOF gqlTokenType = iota
+	gqlIdent
+	gqlName // a `quoted` name
+	gqlString
+	gqlNumber
+	gqlPunct
+)
+
+type gqlToken struct {
+	typ gqlTokenType
+	val string
+	pos int
+}
+
+func (t gqlToken) String() string {
+	if t.typ == gqlEOF {
+		return "end of query"
+	}
+	return strconv.Quote(t.val)
+}
+
+type gqlParser struct {
+	input string
+	toks  []gqlToken
+	i     int
+}
+
+func (p *gqlParser) errorf(t gqlToken, format string, args ...interface{}) error {
+	return fmt.Errorf("gql: at offset %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+// isGQLIdent returns true if the byte c may be part of an unquoted name. Other
+// names (e.g. non-ASCII ones) must be quoted with backticks.
+func isGQLIdent(c byte, first bool) bool {
+	switch {
+	case c == '_', c == '$', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return true
+	}
+	return !first && (c == '.' || c >= '0' && c <= '9')
+}
+
+// lex splits p.input into p.toks.
+func (p *gqlParser) lex() error {
+	s := p.input
+	for i := 0; i < len(s); {
+		r := rune(s[i])
+		switch {
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			i++
+
+		case r == '`' || r == '"' || r == '\'':
+			val, n, err := gqlUnquote(s[i:])
+			if err != nil {
+				return p.errorf(gqlToken{pos: i}, "%s", err)
+			}
+			typ := gqlString
+			if r == '`' {
+				typ = gqlName
+			}
+			p.toks = append(p.toks, gqlToken{typ, val, i})
+			i += n
+
+		case r == '-' || r == '+' || r == '.' || (r >= '0' && r <= '9'):
+			j := i + 1
+			for j < len(s) && strings.IndexByte("0123456789.eE+-", s[j]) != -1 {
+				if (s[j] == '+' || s[j] == '-') && s[j-1] != 'e' && s[j-1] != 'E' {
+					break
+				}
+				j++
+			}
+			p.toks = append(p.toks, gqlToken{gqlNumber, s[i:j], i})
+			i = j
+
+		case r == '<' || r == '>':
+			if i+1 < len(s) && s[i+1] == '=' {
+				p.toks = append(p.toks, gqlToken{gqlPunct, s[i : i+2], i})
+				i += 2
+			} else {
+				p.toks = append(p.toks, gqlToken{gqlPunct, s[i : i+1], i})
+				i++
+			}
+
+		case strings.IndexRune("(),*=", r) != -1:
+			p.toks = append(p.toks, gqlToken{gqlPunct, s[i : i+1], i})
+			i++
+
+		default:
+			j := i
+			for j < len(s) && isGQLIdent(s[j], j == i) {
+				j++
+			}
+			if j == i {
+				return p.errorf(gqlToken{pos: i}, "unexpected character %q", r)
+			}
+			p.toks = append(p.toks, gqlToken{gqlIdent, s[i:j], i})
+			i = j
+		}
+	}
+	p.toks = append(p.toks, gqlToken{gqlEOF, "", len(s)})
+	return nil
+}
+
+// gqlUnquote decodes the quoted string at the start of s (reversing
+// gqlQuoteName and gqlQuoteString), and returns it and its length in s.
+func gqlUnquote(s string) (string, int, error) {
+	quote := s[0]
+	ret := make([]byte, 0, len(s))
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == quote:
+			if i+1 < len(s) && s[i+1] == quote {
+				ret = append(ret, quote)
+				i++
+				continue
+			}
+			return string(ret), i + 1, nil
+
+		case c == '\\':
+			i++
+			if i == len(s) {
+				break
+			}
+			switch e := s[i]; e {
+			case '0':
+				ret = append(ret, 0)
+			case 'b':
+				ret = append(ret, '\b')
+			case 'n':
+				ret = append(ret, '\n')
+			case 'r':
+				ret = append(ret, '\r')
+			case 't':
+				ret = append(ret, '\t')
+			case 'Z':
+				ret = append(ret, '\x1A')
+			case '%', '_':
+				// These are kept escaped, for LIKE patterns.
+				ret = append(ret, '\\', e)
+			default:
+				ret = append(ret, e)
+			}
+
+		default:
+			ret = append(ret, c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated %c-quoted string", quote)
+}
+
+func (p *gqlParser) peek() gqlToken { return p.toks[p.i] }
+
+func (p *gqlParser) next() gqlToken {
+	t := p.toks[p.i]
+	if t.typ != gqlEOF {
+		p.i++
+	}
+	return t
+}
+
+// keyword consumes the next token and returns true, if it's the (case
+// insensitive) keyword kw.
+func (p *gqlParser) keyword(kw string) bool {
+	if t := p.peek(); t.typ == gqlIdent && strings.EqualFold(t.val, kw) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectKeyword(kw string) error {
+	if !p.keyword(kw) {
+		return p.errorf(p.peek(), "expected %s, got %s", kw, p.peek())
+	}
+	return nil
+}
+
+// punct consumes the next token and returns true, if it's the punctuation s.
+func (p *gqlParser) punct(s string) bool {
+	if t := p.peek(); t.typ == gqlPunct && t.val == s {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expectPunct(s string) error {
+	if !p.punct(s) {
+		return p.errorf(p.peek(), "expected %q, got %s", s, p.peek())
+	}
+	return nil
+}
+
+// name parses a property or kind name.
+func (p *gqlParser) name() (string, error) {
+	t := p.next()
+	if t.typ != gqlIdent && t.typ != gqlName {
+		return "", p.errorf(t, "expected a name, got %s", t)
+	}
+	return t.val, nil
+}
+
+// names parses a comma-separated list of names.
+func (p *gqlParser) names() ([]string, error) {
+	ret := []string(nil)
+	for {
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, name)
+		if !p.punct(",") {
+			return ret, nil
+		}
+	}
+}
+
+func (p *gqlParser) parse() (*Query, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+
+	keysOnly, distinct := false, false
+	project, distinctOn := []string(nil), []string(nil)
+	switch {
+	case p.punct("*"):
+	case p.keyword("__key__"):
+		keysOnly = true
+	default:
+		if p.keyword("DISTINCT") {
+			if p.keyword("ON") {
+				if err := p.expectPunct("("); err != nil {
+					return nil, err
+				}
+				var err error
+				if distinctOn, err = p.names(); err != nil {
+					return nil, err
+				}
+				if err := p.expectPunct(")"); err != nil {
+					return nil, err
+				}
+			} else {
+				distinct = true
+			}
+		}
+		var err error
+		if project, err = p.names(); err != nil {
+			return nil, err
+		}
+	}
+
+	q := NewQuery("")
+	if p.keyword("FROM") {
+		kind, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		q = NewQuery(kind)
+	}
+	q = q.KeysOnly(keysOnly).Project(project...).Distinct(distinct).DistinctOn(distinctOn...)
+
+	if p.keyword("WHERE") {
+		for {
+			var err error
+			if q, err = p.condition(q); err != nil {
+				return nil, err
+			}
+			if !p.keyword("AND") {
+				break
+			}
+		}
+	}
+
+	if p.keyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		for {
+			name, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if p.keyword("DESC") {
+				name = "-" + name
+			} else {
+				p.keyword("ASC")
+			}
+			q = q.Order(name)
+			if !p.punct(",") {
+				break
+			}
+		}
+	}
+
+	for {
+		t := p.peek()
+		switch {
+		case p.keyword("LIMIT"):
+			n, err := p.int32()
+			if err != nil {
+				return nil, err
+			}
+			q = q.Limit(n)
+			continue
+		case p.keyword("OFFSET"):
+			n, err := p.int32()
+			if err != nil {
+				return nil, err
+			}
+			q = q.Offset(n)
+			continue
+		case t.typ != gqlEOF:
+			return nil, p.errorf(t, "unexpected %s", t)
+		}
+		return q, nil
+	}
+}
+
+func (p *gqlParser) int32() (int32, error) {
+	t := p.next()
+	if t.typ == gqlNumber {
+		if n, err := strconv.ParseInt(t.val, 10, 32); err == nil {
+			return int32(n), nil
+		}
+	}
+	return 0, p.errorf(t, "expected a 32-bit integer, got %s", t)
+}
+
+func (p *gqlParser) condition(q *Query) (*Query, error) {
+	field, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.keyword("IS") {
+		if err := p.expectKeyword("NULL"); err != nil {
+			return nil, err
+		}
+		return q.Eq(field, nil), nil
+	}
+	if p.keyword("HAS") {
+		if err := p.expectKeyword("ANCESTOR"); err != nil {
+			return nil, err
+		}
+		if field != "__key__" {
+			return nil, p.errorf(p.peek(), "HAS ANCESTOR must be applied to __key__, not %q", field)
+		}
+		t := p.peek()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		k, ok := v.(*Key)
+		if !ok {
+			return nil, p.errorf(t, "HAS ANCESTOR requires a KEY, got %s", t)
+		}
+		return q.Ancestor(k), nil
+	}
+
+	t := p.next()
+	if t.typ != gqlPunct {
+		return nil, p.errorf(t, "expected a comparison operator, got %s", t)
+	}
+	v, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	switch t.val {
+	case "=":
+		return q.Eq(field, v), nil
+	case "<":
+		return q.Lt(field, v), nil
+	case "<=":
+		return q.Lte(field, v), nil
+	case ">":
+		return q.Gt(field, v), nil
+	case ">=":
+		return q.Gte(field, v), nil
+	}
+	return nil, p.errorf(t, "expected a comparison operator, got %s", t)
+}
+
+// value parses a literal value.
+func (p *gqlParser) value() (interface{}, error) {
+	t := p.next()
+	switch t.typ {
+	case gqlString:
+		return t.val, nil
+
+	case gqlNumber:
+		if i, err := strconv.ParseInt(t.val, 10, 64); err == nil {
+			return i, nil
+		}
+		if f, err := strconv.ParseFloat(t.val, 64); err == nil {
+			return f, nil
+		}
+		return nil, p.errorf(t, "bad number %s", t)
+
+	case gqlIdent:
+		switch strings.ToUpper(t.val) {
+		case "TRUE":
+			return true, nil
+		case "FALSE":
+			return false, nil
+		case "NULL":
+			return nil, nil
+		case "KEY":
+			return p.key()
+		case "BLOB":
+			s, err := p.stringArg()
+			if err != nil {
+				return nil, err
+			}
+			b, err := base64.URLEncoding.DecodeString(s)
+			if err != nil {
+				return nil, p.errorf(t, "bad BLOB: %s", err)
+			}
+			return b, nil
+		case "BLOBKEY":
+			s, err := p.stringArg()
+			return blobstore.Key(s), err
+		case "DATETIME":
+			s, err := p.stringArg()
+			if err != nil {
+				return nil, err
+			}
+			tm, err := time.Parse(time.RFC3339Nano, s)
+			if err != nil {
+				return nil, p.errorf(t, "bad DATETIME: %s", err)
+			}
+			return tm.UTC(), nil
+		case "GEOPOINT":
+			return p.geoPoint()
+		}
+	}
+	return nil, p.errorf(t, "expected a value, got %s", t)
+}
+
+// stringArg parses `("string")`.
+func (p *gqlParser) stringArg() (string, error) {
+	if err := p.expectPunct("("); err != nil {
+		return "", err
+	}
+	t := p.next()
+	if t.typ != gqlString {
+		return "", p.errorf(t, "expected a string, got %s", t)
+	}
+	return t.val, p.expectPunct(")")
+}
+
+// geoPoint parses `(lat, lng)`.
+func (p *gqlParser) geoPoint() (interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	ll := [2]float64{}
+	for i := range ll {
+		if i > 0 {
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.next()
+		f, err := strconv.ParseFloat(t.val, 64)
+		if t.typ != gqlNumber || err != nil {
+			return nil, p.errorf(t, "expected a number, got %s", t)
+		}
+		ll[i] = f
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	gp := GeoPoint{Lat: ll[0], Lng: ll[1]}
+	if !gp.Valid() {
+		return nil, fmt.Errorf("gql: invalid GEOPOINT(%v, %v)", gp.Lat, gp.Lng)
+	}
+	return gp, nil
+}
+
+// key parses the arguments of a KEY literal.
+func (p *gqlParser) key() (interface{}, error) {
+	if err := p.expectPunct("("); err != nil {
+		return nil, err
+	}
+	aid, ns := "", ""
+	for _, fn := range []struct {
+		name string
+		dst  *string
+	}{{"DATASET", &aid}, {"NAMESPACE", &ns}} {
+		if p.keyword(fn.name) {
+			s, err := p.stringArg()
+			if err != nil {
+				return nil, err
+			}
+			*fn.dst = s
+			if err := p.expectPunct(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	toks := []KeyTok(nil)
+	for {
+		kind := p.next()
+		if kind.typ != gqlString && kind.typ != gqlIdent && kind.typ != gqlName {
+			return nil, p.errorf(kind, "expected a kind, got %s", kind)
+		}
+		if err := p.expectPunct(","); err != nil {
+			return nil, err
+		}
+		tok := KeyTok{Kind: kind.val}
+		switch id := p.next(); id.typ {
+		case gqlString:
+			tok.StringID = id.val
+		case gqlNumber:
+			n, err := strconv.ParseInt(id.val, 10, 64)
+			if err != nil {
+				return nil, p.errorf(id, "bad key id %s", id)
+			}
+			tok.IntID = n
+		default:
+			return nil, p.errorf(id, "expected a key id, got %s", id)
+		}
+		toks = append(toks, tok)
+		if !p.punct(",") {
+			break
+		}
+	}
+	if err := p.expectPunct(")"); err != nil {
+		return nil, err
+	}
+	return NewKeyToks(aid, ns, toks), nil
+}
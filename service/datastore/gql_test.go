@@ -0,0 +1,86 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseGQL(t *testing.T) {
+	t.Parallel()
+
+	Convey("ParseGQL", t, func() {
+		Convey("parses the output of FinalizedQuery.GQL", func() {
+			for _, tc := range queryTests {
+				if tc.gql == "" || tc.err != nil {
+					continue
+				}
+				q, err := ParseGQL(tc.gql)
+				So(err, ShouldBeNil)
+				fq, err := q.Finalize()
+				So(err, ShouldBeNil)
+				So(fq.GQL(), ShouldEqual, tc.gql)
+			}
+		})
+
+		Convey("parses queries", func() {
+			parsesTo := func(gql string, q *Query) {
+				pq, err := ParseGQL(gql)
+				So(err, ShouldBeNil)
+				fq, err := pq.Finalize()
+				So(err, ShouldBeNil)
+				fq2, err := q.Finalize()
+				So(err, ShouldBeNil)
+				So(fq.GQL(), ShouldEqual, fq2.GQL())
+			}
+
+			parsesTo("select * from Foo", NewQuery("Foo"))
+			parsesTo("SELECT __key__", NewQuery("").KeysOnly(true))
+			parsesTo("SELECT DISTINCT a, `b c` FROM `Foo Bar` ORDER BY a DESC, `b c` ASC",
+				NewQuery("Foo Bar").Project("a", "b c").Distinct(true).Order("-a", "b c"))
+			parsesTo("SELECT DISTINCT ON (a) a, b FROM Foo",
+				NewQuery("Foo").Project("a", "b").DistinctOn("a"))
+			parsesTo("SELECT * FROM Foo WHERE a.b = 'it''s' AND c IS NULL AND d = -1.5e3 AND e = true",
+				NewQuery("Foo").Eq("a.b", "it's").Eq("c", nil).Eq("d", -1.5e3).Eq("e", true))
+			parsesTo("SELECT * FROM Foo WHERE a >= 1 AND a < 10 OFFSET 5 LIMIT 3",
+				NewQuery("Foo").Gte("a", 1).Lt("a", 10).Limit(3).Offset(5))
+			parsesTo(`SELECT * FROM Foo WHERE a = "\"\\\n"`, NewQuery("Foo").Eq("a", "\"\\\n"))
+			parsesTo(`SELECT * FROM Foo WHERE a = BLOB("aGk=") AND b = BLOBKEY("bk")`,
+				NewQuery("Foo").Eq("a", []byte("hi")).Eq("b", blobstore.Key("bk")))
+			parsesTo(`SELECT * FROM Foo WHERE a = DATETIME("2015-01-02T03:04:05.000006Z")`,
+				NewQuery("Foo").Eq("a", time.Date(2015, 1, 2, 3, 4, 5, 6000, time.UTC)))
+			parsesTo(`SELECT * FROM Foo WHERE a = GEOPOINT(1.5, -2)`,
+				NewQuery("Foo").Eq("a", GeoPoint{Lat: 1.5, Lng: -2}))
+			parsesTo(`SELECT * WHERE __key__ HAS ANCESTOR KEY(DATASET("s~aid"), NAMESPACE("ns"), Parent, "p", "Child", 2)`,
+				NewQuery("").Ancestor(MakeKey("s~aid", "ns", "Parent", "p", "Child", 2)))
+		})
+
+		Convey("rejects bad queries", func() {
+			bad := func(gql, err string) {
+				_, e := ParseGQL(gql)
+				So(e, ShouldErrLike, err)
+			}
+
+			bad("", "at offset 0: expected SELECT, got end of query")
+			bad("SELECT * FROM", "expected a name, got end of query")
+			bad("SELECT * FROM Foo WHERE a == 1", `expected a value, got "="`)
+			bad("SELECT * FROM Foo WHERE a = 'x", "unterminated '-quoted string")
+			bad("SELECT * FROM Foo WHERE a = @x", `unexpected character '@'`)
+			bad("SELECT * FROM Foo LIMIT x", `expected a 32-bit integer, got "x"`)
+			bad("SELECT * FROM Foo ORDER a", `expected BY, got "a"`)
+			bad("SELECT * FROM Foo WHERE a HAS ANCESTOR 1", "HAS ANCESTOR must be applied to __key__")
+			bad("SELECT * FROM Foo WHERE __key__ HAS ANCESTOR 1", `HAS ANCESTOR requires a KEY, got "1"`)
+			bad("SELECT * FROM Foo WHERE a = GEOPOINT(100, 0)", "invalid GEOPOINT(100, 0)")
+			bad("SELECT * FROM Foo garbage", `unexpected "garbage"`)
+			bad("SELECT * FROM Foo WHERE a > 1 AND b > 2", "inequality filters on multiple properties")
+		})
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"golang.org/x/net/context"
+)
+
+// BeforeSaver may be implemented by an object passed to Interface's Put
+// methods. BeforeSave is invoked before the object's key is extracted and
+// before it's serialized, which makes it a good place for validation,
+// timestamps or denormalization.
+//
+// If BeforeSave returns an error, the object will not be written, and the
+// error will be returned (in the object's slot, for PutMulti).
+type BeforeSaver interface {
+	BeforeSave(c context.Context) error
+}
+
+// AfterLoader may be implemented by an object passed to (or produced by)
+// Interface's Get, GetMulti, GetAll and Run methods. AfterLoad is invoked
+// after the object has been fully populated from the datastore (including its
+// key metadata).
+//
+// If AfterLoad returns an error, it will be returned in the object's slot
+// (for GetMulti and GetAll), or it will stop the query (for Run).
+type AfterLoader interface {
+	AfterLoad(c context.Context) error
+}
+
+// beforeSave invokes BeforeSave on obj, if it implements BeforeSaver.
+func beforeSave(c context.Context, obj interface{}) error {
+	if bs, ok := obj.(BeforeSaver); ok {
+		return bs.BeforeSave(c)
+	}
+	return nil
+}
+
+// afterLoad invokes AfterLoad on obj, if it implements AfterLoader.
+func afterLoad(c context.Context, obj interface{}) error {
+	if al, ok := obj.(AfterLoader); ok {
+		return al.AfterLoad(c)
+	}
+	return nil
+}
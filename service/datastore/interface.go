@@ -43,6 +43,10 @@ func (bl BoolList) Any() bool {
 //
 // Struct objects passed in will be converted to PropertyLoadSaver interfaces
 // using this package's GetPLS function.
+//
+// Objects which implement BeforeSaver or AfterLoader will have those hooks
+// invoked automatically when they're written or read. Objects which implement
+// Validator are validated before they're written.
 type Interface interface {
 	// AllocateIDs allows you to allocate IDs from the datastore without putting
 	// any data. `incomplete` must be a PartialValid Key. If there's no error,
@@ -95,7 +99,10 @@ type Interface interface {
 	//   - "kind" (optional, type: string) - The kind of the Key to create. If
 	//     blank or not present, KeyForObjErr will extract the name of the src
 	//     object's type.
-	//   - "parent" (optional, type: Key) - The parent key to use.
+	//   - "parent" (optional, type: *Key) - The parent key to use. If it's not
+	//     nil, it must be a complete key in the same app and namespace,
+	//     otherwise KeyForObjErr returns ErrInvalidKey. A struct can export it
+	//     with a `gae:"$parent"` field of type *Key.
 	//
 	// By default, the metadata will be extracted from the struct and its tagged
 	// properties. However, if the struct implements MetaGetterSetter it is
@@ -138,10 +145,31 @@ type Interface interface {
 	// Run may also stop on the first datastore error encountered, which can occur
 	// due to flakiness, timeout, etc. If it encounters such an error, it will
 	// be returned.
+	//
+	// If q has In or Ne filters, it's expanded (see Query.Expand) and the
+	// results of the expanded queries are merged as with RunMulti.
 	Run(q *Query, cb interface{}) error
 
+	// RunKeys is like Run with a `func(*Key, CursorCB) error` callback, but
+	// without the cost of reflecting on the callback for each result. It's
+	// useful for scanning through many keys.
+	RunKeys(q *Query, cb func(*Key, CursorCB) error) error
+
+	// RunMulti executes the given queries concurrently, and calls `cb` for each
+	// distinct entity they retrieve, in the order given by their sort orders.
+	// This emulates an OR of the queries' filters, which the datastore doesn't
+	// support.
+	//
+	// The queries must have the same sort orders, and can't be projection
+	// queries or have offsets. If they all have limits, at most the largest of
+	// them is returned. The getCursor callback always returns
+	// ErrRunMultiCursor.
+	//
+	// cb has the same signature and behavior as Run's callback.
+	RunMulti(queries []*Query, cb interface{}) error
+
 	// Count executes the given query and returns the number of entries which
-	// match it.
+	// match it. Like Run, it expands In and Ne filters.
 	Count(q *Query) (int64, error)
 
 	// DecodeCursor converts a string returned by a Cursor into a Cursor instance.
@@ -156,6 +184,8 @@ type Interface interface {
 	//   - *[]P or *[]*P where *P is a concrete type implementing
 	//     PropertyLoadSaver
 	//   - *[]*Key implies a keys-only query.
+	//
+	// Like Run, it expands In and Ne filters.
 	GetAll(q *Query, dst interface{}) error
 
 	// Does a Get for this key and returns true iff it exists. Will only return
@@ -200,6 +230,16 @@ type Interface interface {
 	//     be non-nil, and its underlying type must be either *S or *P.
 	GetMulti(dst interface{}) error
 
+	// GetMultiExists is like GetMulti, except that entities which don't exist
+	// aren't errors. It returns which of them existed, in the same order as
+	// dst. The elements of dst for entities which don't exist are left as they
+	// were.
+	//
+	// The error, if any, is for the entities which existed, or for the whole
+	// call, as for GetMulti. The BoolList is nil if the call failed before
+	// any entities were read.
+	GetMultiExists(dst interface{}) (BoolList, error)
+
 	// PutMulti writes items to the datastore.
 	//
 	// src must be one of:
@@ -157,11 +157,20 @@ func NewKeyEncoded(encoded string) (ret *Key, err error) {
 	if err != nil {
 		return
 	}
+	return newKeyProto(b)
+}
 
+// newKeyProto decodes a Key from the protobuf written by Key.encodeProto.
+func newKeyProto(b []byte) (ret *Key, err error) {
+	ret = &Key{}
 	r := &pb.Reference{}
 	if err = proto.Unmarshal(b, r); err != nil {
 		return
 	}
+	if len(r.GetPath().GetElement()) == 0 {
+		err = errors.New("datastore: encoded key has no path")
+		return
+	}
 
 	ret.appID = r.GetApp()
 	ret.namespace = r.GetNameSpace()
@@ -276,6 +285,12 @@ func (k *Key) MarshalJSON() ([]byte, error) {
 //
 // It's encoded with the urlsafe base64 table without padding.
 func (k *Key) Encode() string {
+	// trim padding
+	return strings.TrimRight(base64.URLEncoding.EncodeToString(k.encodeProto()), "=")
+}
+
+// encodeProto encodes the key as a serialized SDK Reference protobuf.
+func (k *Key) encodeProto() []byte {
 	e := make([]*pb.Path_Element, len(k.toks))
 	for i, t := range k.toks {
 		t := t
@@ -302,9 +317,7 @@ func (k *Key) Encode() string {
 	if err != nil {
 		panic(err)
 	}
-
-	// trim padding
-	return strings.TrimRight(base64.URLEncoding.EncodeToString(r), "=")
+	return r
 }
 
 // UnmarshalJSON allows this key to be automatically unmarshaled by encoding/json.
@@ -335,6 +348,42 @@ func (k *Key) GobDecode(buf []byte) error {
 	return nil
 }
 
+// MarshalText allows the Key to be used wherever encoding.TextMarshaler is,
+// e.g. as a map key with encoding/json. It's the same as Encode.
+func (k *Key) MarshalText() ([]byte, error) {
+	return []byte(k.Encode()), nil
+}
+
+// UnmarshalText decodes a Key written by MarshalText.
+func (k *Key) UnmarshalText(buf []byte) error {
+	return k.GobDecode(buf)
+}
+
+// MarshalBinary allows the Key to be used wherever encoding.BinaryMarshaler
+// is. It's the protobuf which Encode base64 encodes, so it's shorter.
+func (k *Key) MarshalBinary() ([]byte, error) {
+	return k.encodeProto(), nil
+}
+
+// UnmarshalBinary decodes a Key written by MarshalBinary.
+func (k *Key) UnmarshalBinary(buf []byte) error {
+	nk, err := newKeyProto(buf)
+	if err != nil {
+		return err
+	}
+	*k = *nk
+	return nil
+}
+
+// WithContext returns k (including all of its ancestors) in the app ID aid and
+// namespace ns instead of its own. If k is already in them, k is returned.
+func (k *Key) WithContext(aid, ns string) *Key {
+	if k.appID == aid && k.namespace == ns {
+		return k
+	}
+	return &Key{aid, ns, k.toks}
+}
+
 // Root returns the entity root for the given key.
 func (k *Key) Root() *Key {
 	if len(k.toks) > 1 {
@@ -345,7 +394,10 @@ func (k *Key) Root() *Key {
 	return k
 }
 
-// Less returns true iff k would sort before other.
+// Less returns true iff k would sort before other. Keys with the same app ID
+// and namespace are in the order which the datastore sorts them (e.g. in
+// indexes and __key__ queries): by each token's kind, then ID, with IntIDs
+// before StringIDs, and ancestors before their descendants.
 func (k *Key) Less(other *Key) bool {
 	if k.appID < other.appID {
 		return true
@@ -390,6 +442,12 @@ func (k *Key) HasAncestor(other *Key) bool {
 	return true
 }
 
+// IsAncestorOf returns true iff k is an ancestor of other. Unlike
+// other.HasAncestor(k), it's false if k == other.
+func (k *Key) IsAncestorOf(other *Key) bool {
+	return len(k.toks) < len(other.toks) && other.HasAncestor(k)
+}
+
 // GQL returns a correctly formatted Cloud Datastore GQL key literal.
 //
 // The flavor of GQL that this emits is defined here:
@@ -428,6 +486,22 @@ func (k *Key) Equal(other *Key) (ret bool) {
 	return
 }
 
+// PathEqual returns true iff the two keys have identical paths (their kinds
+// and IDs), ignoring their app IDs and namespaces. This compares keys which
+// refer to the "same" entity in different namespaces, e.g. a tenant's entity
+// and its copy in a backup namespace.
+func (k *Key) PathEqual(other *Key) bool {
+	if len(k.toks) != len(other.toks) {
+		return false
+	}
+	for i, t := range k.toks {
+		if t != other.toks[i] {
+			return false
+		}
+	}
+	return true
+}
+
 // Split componentizes the key into pieces (AppID, Namespace and tokens)
 //
 // Each token represents one piece of they key's 'path'.
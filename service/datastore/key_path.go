@@ -0,0 +1,127 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PathString returns the path of the key, without its app ID and namespace,
+// in the human-readable form parsed by ParseKeyPath:
+//   Parent,1/Child,foo
+//
+// Kinds and StringIDs are quoted (like strconv.Quote) if they would otherwise
+// be ambiguous: if they're empty, contain '/', ',' or unprintable characters,
+// start with '"', or (for StringIDs) look like IntIDs.
+func (k *Key) PathString() string {
+	buf := bytes.Buffer{}
+	for i, t := range k.toks {
+		if i > 0 {
+			buf.WriteByte('/')
+		}
+		buf.WriteString(quoteKeyPathPart(t.Kind, false))
+		buf.WriteByte(',')
+		if t.StringID != "" {
+			buf.WriteString(quoteKeyPathPart(t.StringID, true))
+		} else {
+			buf.WriteString(strconv.FormatInt(t.IntID, 10))
+		}
+	}
+	return buf.String()
+}
+
+func quoteKeyPathPart(s string, isID bool) string {
+	q := strconv.Quote(s)
+	if s == "" || q != `"`+s+`"` || strings.ContainsAny(s, "/,") {
+		return q
+	}
+	if isID {
+		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return q
+		}
+	}
+	return s
+}
+
+// ParseKeyPath parses a key path in the form returned by Key.PathString, and
+// returns the key with that path in the given app ID and namespace. Each of
+// the path's elements is a kind and an ID, separated by ','. Unquoted IDs
+// which are integers are IntIDs, and any other IDs are StringIDs:
+//   Parent,1/Child,foo/Grandchild,"2"
+//
+// The last element's ID may be 0, for an incomplete key.
+func ParseKeyPath(aid, ns, path string) (*Key, error) {
+	bad := func(format string, args ...interface{}) error {
+		return fmt.Errorf("datastore: bad key path %q: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	toks := []KeyTok(nil)
+	rest := path
+	for {
+		kind, _, r, err := readKeyPathPart(rest)
+		if err != nil {
+			return nil, bad("%s", err)
+		}
+		if kind == "" {
+			return nil, bad("element %d has no kind", len(toks))
+		}
+		if !strings.HasPrefix(r, ",") {
+			return nil, bad("element %d has no ID", len(toks))
+		}
+
+		id, quoted, r, err := readKeyPathPart(r[1:])
+		if err != nil {
+			return nil, bad("%s", err)
+		}
+		tok := KeyTok{Kind: kind}
+		if i, err := strconv.ParseInt(id, 10, 64); err == nil && !quoted {
+			tok.IntID = i
+		} else if id == "" {
+			return nil, bad("element %d has an empty ID", len(toks))
+		} else {
+			tok.StringID = id
+		}
+		if len(toks) > 0 && toks[len(toks)-1].Incomplete() {
+			return nil, bad("element %d has an incomplete parent", len(toks))
+		}
+		toks = append(toks, tok)
+
+		if r == "" {
+			break
+		}
+		if r[0] != '/' {
+			return nil, bad("unexpected %q after element %d", r[0], len(toks)-1)
+		}
+		rest = r[1:]
+	}
+	return NewKeyToks(aid, ns, toks), nil
+}
+
+// readKeyPathPart reads a kind or ID from the start of s, up to the next ','
+// or '/'. It returns the unquoted part, whether it was quoted, and the rest of
+// s.
+func readKeyPathPart(s string) (part string, quoted bool, rest string, err error) {
+	if !strings.HasPrefix(s, `"`) {
+		end := strings.IndexAny(s, ",/")
+		if end == -1 {
+			end = len(s)
+		}
+		return s[:end], false, s[end:], nil
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			part, err = strconv.Unquote(s[:i+1])
+			return part, true, s[i+1:], err
+		}
+	}
+	return "", true, "", fmt.Errorf("unterminated quoted string")
+}
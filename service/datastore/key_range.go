@@ -0,0 +1,105 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ScatterProperty is the reserved property which the datastore fills with
+// random data for a sample of each kind's entities. Ordering a keys-only query
+// by it returns a random sample of the kind's keys.
+const ScatterProperty = "__scatter__"
+
+// ScatterOversampling is the number of scatter samples which SplitKind reads
+// for each range it returns. More samples make the ranges more even.
+const ScatterOversampling = 32
+
+// KeyRange is a range of keys, for use with Query.KeyRange.
+//
+// By default the range is half-open, [Start, End). A nil Start or End leaves
+// that side of the range unbounded.
+type KeyRange struct {
+	Start          *Key
+	StartExclusive bool
+
+	End          *Key
+	EndInclusive bool
+}
+
+// Contains returns true iff k is in the range.
+func (r KeyRange) Contains(k *Key) bool {
+	if r.Start != nil {
+		if k.Less(r.Start) || (r.StartExclusive && k.Equal(r.Start)) {
+			return false
+		}
+	}
+	if r.End != nil {
+		if r.End.Less(k) || (!r.EndInclusive && k.Equal(r.End)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r KeyRange) String() string {
+	open, close := "[", ")"
+	if r.StartExclusive {
+		open = "("
+	}
+	if r.EndInclusive {
+		close = "]"
+	}
+	return fmt.Sprintf("%s%s, %s%s", open, r.Start, r.End, close)
+}
+
+// SplitKind splits the keyspace of kind into at most n ranges containing
+// roughly equal numbers of entities, using a random sample of the kind's keys
+// read by ordering on ScatterProperty. The ranges are contiguous, in key order,
+// and together cover the whole keyspace, so running a query on each of them
+// covers every entity of the kind.
+//
+// If the datastore has too few samples (e.g. because the kind is small),
+// SplitKind returns fewer than n ranges. It always returns at least one.
+func SplitKind(d Interface, kind string, n int) ([]KeyRange, error) {
+	if n <= 1 {
+		return []KeyRange{{}}, nil
+	}
+
+	q := NewQuery(kind).Order(ScatterProperty).KeysOnly(true).Limit(int32(n * ScatterOversampling))
+	samples := []*Key(nil)
+	if err := d.GetAll(q, &samples); err != nil {
+		return nil, err
+	}
+	sort.Sort(keySlice(samples))
+
+	splits := make([]*Key, 0, n-1)
+	for i := 1; i < n; i++ {
+		idx := i * len(samples) / n
+		if idx == 0 {
+			continue
+		}
+		k := samples[idx]
+		if len(splits) > 0 && !splits[len(splits)-1].Less(k) {
+			continue
+		}
+		splits = append(splits, k)
+	}
+
+	ret := make([]KeyRange, 0, len(splits)+1)
+	start := (*Key)(nil)
+	for _, k := range splits {
+		ret = append(ret, KeyRange{Start: start, End: k})
+		start = k
+	}
+	return append(ret, KeyRange{Start: start}), nil
+}
+
+type keySlice []*Key
+
+func (s keySlice) Len() int           { return len(s) }
+func (s keySlice) Less(i, j int) bool { return s[i].Less(s[j]) }
+func (s keySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -5,9 +5,12 @@
 package datastore
 
 import (
+	"bytes"
+	"encoding/gob"
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
@@ -56,6 +59,50 @@ func TestKeyEncode(t *testing.T) {
 				So(dec.UnmarshalJSON(data), ShouldBeNil)
 				So(dec, ShouldEqualKey, k)
 			})
+
+			Convey(k.String()+" (text)", func() {
+				data, err := k.MarshalText()
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, k.Encode())
+
+				dec := &Key{}
+				So(dec.UnmarshalText(data), ShouldBeNil)
+				So(dec, ShouldEqualKey, k)
+			})
+
+			Convey(k.String()+" (binary)", func() {
+				data, err := k.MarshalBinary()
+				So(err, ShouldBeNil)
+
+				dec := &Key{}
+				So(dec.UnmarshalBinary(data), ShouldBeNil)
+				So(dec, ShouldEqualKey, k)
+			})
+
+			Convey(k.String()+" (gob)", func() {
+				type payload struct {
+					K *Key
+				}
+				buf := &bytes.Buffer{}
+				So(gob.NewEncoder(buf).Encode(&payload{k}), ShouldBeNil)
+
+				dec := payload{}
+				So(gob.NewDecoder(buf).Decode(&dec), ShouldBeNil)
+				So(dec.K, ShouldEqualKey, k)
+			})
+
+			Convey(k.String()+" (json map key)", func() {
+				data, err := json.Marshal(map[*Key]int{k: 1})
+				So(err, ShouldBeNil)
+
+				dec := map[*Key]int{}
+				So(json.Unmarshal(data, &dec), ShouldBeNil)
+				So(len(dec), ShouldEqual, 1)
+				for dk, v := range dec {
+					So(dk, ShouldEqualKey, k)
+					So(v, ShouldEqual, 1)
+				}
+			})
 		}
 	})
 
@@ -89,6 +136,11 @@ func TestKeyEncode(t *testing.T) {
 			So(err, ShouldErrLike, "EOF")
 		})
 
+		Convey("binary encoding without a path", func() {
+			err := (&Key{}).UnmarshalBinary(nil)
+			So(err, ShouldErrLike, "no path")
+		})
+
 		Convey("json encoding includes quotes", func() {
 			data, err := keys[0].MarshalJSON()
 			So(err, ShouldBeNil)
@@ -172,6 +224,35 @@ func TestMiscKey(t *testing.T) {
 		So(k3.HasAncestor(k4), ShouldBeFalse)
 	})
 
+	Convey("IsAncestorOf", t, func() {
+		k1 := MakeKey("a", "n", "kind", 1)
+		k2 := MakeKey("a", "n", "kind", 1, "other", "wat")
+		k3 := MakeKey("a", "n", "kind", 1, "other", "wat", "extra", "data")
+		k4 := MakeKey("a", "", "kind", 1, "other", "wat")
+
+		So(k1.IsAncestorOf(k1), ShouldBeFalse)
+		So(k1.IsAncestorOf(k2), ShouldBeTrue)
+		So(k1.IsAncestorOf(k3), ShouldBeTrue)
+		So(k2.IsAncestorOf(k1), ShouldBeFalse)
+		So(k1.IsAncestorOf(k4), ShouldBeFalse)
+	})
+
+	Convey("WithContext", t, func() {
+		k := MakeKey("a", "n", "knd", 1, "other", "wat")
+		nk := k.WithContext("b", "")
+		So(nk, ShouldEqualKey, MakeKey("b", "", "knd", 1, "other", "wat"))
+		So(nk.Parent(), ShouldEqualKey, MakeKey("b", "", "knd", 1))
+		So(k, ShouldEqualKey, MakeKey("a", "n", "knd", 1, "other", "wat"))
+		So(k.WithContext("a", "n"), ShouldEqual, k)
+	})
+
+	Convey("PathEqual", t, func() {
+		k1 := MakeKey("a", "n", "knd", 1, "other", "wat")
+		So(k1.PathEqual(MakeKey("b", "", "knd", 1, "other", "wat")), ShouldBeTrue)
+		So(k1.PathEqual(MakeKey("a", "n", "knd", 1, "other", "meep")), ShouldBeFalse)
+		So(k1.PathEqual(MakeKey("a", "n", "knd", 1)), ShouldBeFalse)
+	})
+
 	Convey("*GenericKey supports json encoding", t, func() {
 		type TestStruct struct {
 			Key *Key
@@ -247,3 +328,96 @@ func TestKeySort(t *testing.T) {
 		}
 	})
 }
+
+func TestCompositeID(t *testing.T) {
+	t.Parallel()
+
+	Convey("CompositeID", t, func() {
+		Convey("joins normalized parts", func() {
+			when := time.Date(2015, time.June, 1, 2, 3, 4, 0, time.UTC)
+			So(CompositeID("a", 1, int64(-2), uint8(3), true, when), ShouldEqual,
+				"a|1|-2|3|true|2015-06-01T02:03:04Z")
+			So(CompositeID(), ShouldEqual, "")
+		})
+
+		Convey("escapes separators", func() {
+			So(CompositeID("a|b", "c"), ShouldEqual, `a\|b|c`)
+			So(CompositeID("a", "b|c"), ShouldEqual, `a|b\|c`)
+			So(CompositeID(`a\`, "b"), ShouldEqual, `a\\|b`)
+			So(CompositeID("a|b", "c"), ShouldNotEqual, CompositeID("a", "b|c"))
+		})
+
+		Convey("splits back into parts", func() {
+			k := MakeKey("dev~app", "", "Kind", 1)
+			parts, err := SplitCompositeID(CompositeID(`a|\b`, "", 7, k))
+			So(err, ShouldBeNil)
+			So(parts, ShouldResemble, []string{`a|\b`, "", "7", k.Encode()})
+
+			_, err = SplitCompositeID(`a\`)
+			So(err, ShouldErrLike, "ends with an escape")
+		})
+
+		Convey("hashes", func() {
+			So(HashedCompositeID("a", 1), ShouldHaveLength, 64)
+			So(HashedCompositeID("a", 1), ShouldEqual, HashedCompositeID("a", "1"))
+			So(HashedCompositeID("a|b", "c"), ShouldNotEqual, HashedCompositeID("a", "b|c"))
+		})
+
+		Convey("panics on unsupported types", func() {
+			So(func() { CompositeID(1.5) }, ShouldPanic)
+		})
+	})
+}
+
+func TestKeyPath(t *testing.T) {
+	t.Parallel()
+
+	Convey("Key paths", t, func() {
+		Convey("round trip", func() {
+			keys := []*Key{
+				MakeKey("aid", "ns", "Parent", 1, "Child", "foo"),
+				MakeKey("aid", "ns", "Parent", "2", "Child", -3),
+				MakeKey("aid", "", "Kind", "with/slash,comma"),
+				MakeKey("aid", "", "odd/kind", "\"quoted\"\n"),
+				MakeKey("aid", "ns", "Parent", 1, "Child", 0),
+			}
+			for _, k := range keys {
+				k, ps := k, k.PathString()
+				Convey(ps, func() {
+					dec, err := ParseKeyPath("aid", k.Namespace(), ps)
+					So(err, ShouldBeNil)
+					So(dec, ShouldEqualKey, k)
+				})
+			}
+		})
+
+		Convey("PathString", func() {
+			So(MakeKey("a", "", "Parent", 1, "Child", "foo").PathString(), ShouldEqual, "Parent,1/Child,foo")
+			So(MakeKey("a", "", "Kind", "12").PathString(), ShouldEqual, `Kind,"12"`)
+			So(MakeKey("a", "", "K/ind", "a,b").PathString(), ShouldEqual, `"K/ind","a,b"`)
+		})
+
+		Convey("ParseKeyPath", func() {
+			k, err := ParseKeyPath("aid", "ns", `Parent,1/Child,foo/Grandchild,"2"`)
+			So(err, ShouldBeNil)
+			So(k, ShouldEqualKey, MakeKey("aid", "ns", "Parent", 1, "Child", "foo", "Grandchild", "2"))
+		})
+
+		Convey("bad paths", func() {
+			for path, msg := range map[string]string{
+				"":                  "has no kind",
+				"Kind":              "has no ID",
+				"Kind,":             "has an empty ID",
+				",1":                "has no kind",
+				"Kind,1/":           "has no kind",
+				"Kind,1x/Child,2,3": "unexpected ','",
+				`Kind,"1`:           "unterminated",
+				`Kind,"1"x`:         "unexpected 'x'",
+				"Kind,0/Child,1":    "incomplete parent",
+			} {
+				_, err := ParseKeyPath("aid", "", path)
+				So(err, ShouldErrLike, msg)
+			}
+		})
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"reflect"
 
 	"github.com/luci/luci-go/common/errors"
+	"golang.org/x/net/context"
 )
 
 type multiArgType struct {
@@ -18,9 +19,13 @@ type multiArgType struct {
 	setPM     func(slot reflect.Value, pm PropertyMap) error
 	setKey    func(slot reflect.Value, k *Key)
 	newElem   func() reflect.Value
+	getObj    func(slot reflect.Value) interface{}
 }
 
-func (mat *multiArgType) GetKeysPMs(aid, ns string, slice reflect.Value, meta bool) ([]*Key, []PropertyMap, error) {
+// GetKeysPMs extracts the keys and PropertyMaps from every element of slice.
+// If meta is true, only the metadata is extracted (e.g. for GetMulti).
+// Otherwise, BeforeSave hooks are run on each element prior to extraction.
+func (mat *multiArgType) GetKeysPMs(c context.Context, aid, ns string, slice reflect.Value, meta bool) ([]*Key, []PropertyMap, error) {
 	retKey := make([]*Key, slice.Len())
 	retPM := make([]PropertyMap, slice.Len())
 	getter := mat.getPM
@@ -31,9 +36,15 @@ func (mat *multiArgType) GetKeysPMs(aid, ns string, slice reflect.Value, meta bo
 	}
 	lme := errors.NewLazyMultiError(len(retKey))
 	for i := range retKey {
+		if !meta && lme.Assign(i, beforeSave(c, mat.getObj(slice.Index(i)))) {
+			continue
+		}
 		key, err := mat.getKey(aid, ns, slice.Index(i))
 		if !lme.Assign(i, err) {
 			retKey[i] = key
+			if !meta && lme.Assign(i, validate(key, mat.getObj(slice.Index(i)))) {
+				continue
+			}
 			pm, err := getter(slice.Index(i))
 			if !lme.Assign(i, err) {
 				retPM[i] = pm
@@ -43,6 +54,11 @@ func (mat *multiArgType) GetKeysPMs(aid, ns string, slice reflect.Value, meta bo
 	return retKey, retPM, lme.Get()
 }
 
+// afterLoad runs the AfterLoad hook on the object in slot, if it has one.
+func (mat *multiArgType) afterLoad(c context.Context, slot reflect.Value) error {
+	return afterLoad(c, mat.getObj(slot))
+}
+
 // parseMultiArg checks that v has type []S, []*S, []I, []P or []*P, for some
 // struct type S, for some interface type I, or some non-interface non-pointer
 // type P such that P or *P implements PropertyLoadSaver.
@@ -101,6 +117,9 @@ func multiArgTypePLS(et reflect.Type) multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Addr().Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Addr().Interface()
+		},
 	}
 	if et.Kind() == reflect.Map {
 		ret.newElem = func() reflect.Value {
@@ -138,6 +157,9 @@ func multiArgTypePLSPtr(et reflect.Type) multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Interface()
+		},
 	}
 	if et.Kind() == reflect.Map {
 		ret.newElem = func() reflect.Value {
@@ -179,6 +201,9 @@ func multiArgTypeStruct(et reflect.Type) multiArgType {
 		newElem: func() reflect.Value {
 			return reflect.New(et).Elem()
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Addr().Interface()
+		},
 	}
 }
 
@@ -210,6 +235,9 @@ func multiArgTypeStructPtr(et reflect.Type) multiArgType {
 		newElem: func() reflect.Value {
 			return reflect.New(et)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Interface()
+		},
 	}
 }
 
@@ -231,6 +259,9 @@ func multiArgTypeInterface() multiArgType {
 		setKey: func(slot reflect.Value, k *Key) {
 			setKey(slot.Elem().Interface(), k)
 		},
+		getObj: func(slot reflect.Value) interface{} {
+			return slot.Elem().Interface()
+		},
 	}
 }
 
@@ -247,11 +278,19 @@ func newKeyObjErr(aid, ns string, src interface{}) (*Key, error) {
 	}
 
 	// get id - allow both to be default for default keys
+	if sp, ok := pls.(*structPLS); ok {
+		if err := sp.compositeIDErr(); err != nil {
+			return nil, err
+		}
+	}
 	sid := GetMetaDefault(pls, "id", "").(string)
 	iid := GetMetaDefault(pls, "id", 0).(int64)
 
 	// get parent
 	par, _ := GetMetaDefault(pls, "parent", nil).(*Key)
+	if par != nil && !par.Valid(false, aid, ns) {
+		return nil, ErrInvalidKey
+	}
 
 	return NewKey(aid, ns, kind, sid, iid, par), nil
 }
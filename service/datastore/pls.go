@@ -26,7 +26,9 @@ import (
 // The following field types are supported:
 //   * int64, int32, int16, int8, int
 //   * uint32, uint16, uint8, byte
-//   * float64, float32
+//   * float64, float32. float32 fields are saved as (widened) float64
+//     properties. When loading, a value which doesn't fit in a float32 is an
+//     error, rather than being silently truncated to infinity.
 //   * string
 //   * []byte
 //   * bool
@@ -34,9 +36,17 @@ import (
 //   * GeoPoint
 //   * *Key
 //   * any Type whose underlying type is one of the above types
+//   * a pointer to any of the above types (other than *Key), e.g. *int64 or
+//     *time.Time. A nil pointer is saved as a PTNull property, and a PTNull
+//     property loads as a nil pointer, so "unset" can be told apart from the
+//     zero value.
 //   * Types which implement PropertyConverter on (*Type)
 //   * A struct composed of the above types (except for nested slices)
 //   * A slice of any of the above types
+//   * An array ([N]T) of any of the above types, which is saved like a slice.
+//     Loading more than N values into it is an error. A byte array (e.g. a
+//     [32]byte digest) is saved as a single []byte value instead, which must
+//     have exactly N bytes when it's loaded.
 //
 // GetPLS supports the following struct tag syntax:
 //   `gae:"fieldName[,noindex]"` -- an alternate fieldname for an exportable
@@ -55,6 +65,32 @@ import (
 //      field's actual name. Note that by default, all fields (with indexable
 //      types) are indexed.
 //
+//      If noindex is specified on a struct-typed field, then none of the
+//      fields of the nested struct are indexed, except for those which are
+//      tagged with index (see below).
+//
+//   `gae:",noindex"` on a blank field (conventionally `_ struct{}`) --
+//      indicates that the fields of this struct aren't indexed by default.
+//      This is useful for large log-style entities, most of whose fields
+//      are never queried. Fields which should be indexed can be tagged with
+//      `gae:"[fieldName],index"`, which overrides noindex on the struct (or
+//      on the enclosing struct field). For example:
+//        _       struct{} `gae:",noindex"`
+//        User    string   `gae:",index"`
+//        Message string
+//        Details string
+//      only indexes User.
+//
+//   `gae:"fieldName[,noindex|index],alias=OldName1[|OldName2...]"` -- allows
+//      a property to be renamed gradually. Load accepts values stored under
+//      any of the legacy names (as well as fieldName), but Save only writes
+//      fieldName, so entities are migrated as they're rewritten. If an entity
+//      has values under both fieldName and a legacy name, the legacy values
+//      are ignored. Aliases of a struct-typed field apply to its nested
+//      properties, e.g.:
+//        Addr Address `gae:"Address,alias=Addr|Location"`
+//      loads "Location.Street" into Addr.Street.
+//
 //   `gae:"$metaKey[,<value>]` -- indicates a field is metadata. Metadata
 //      can be used to control filter behavior, or to store key data when using
 //      the Interface.KeyForObj* methods. The supported field types are:
@@ -69,6 +105,19 @@ import (
 //      Only exported fields allow SetMeta, but all fields of appropriate type
 //      allow tagged defaults for use with GetMeta. See Examples.
 //
+//   `gae:"$id,fmt=<format>,fields=<Field> [<Field>...]"` -- declares a
+//      composite string $id which is built from other (exported) fields of the
+//      struct, replacing a hand-written MetaGetterSetter. The type of the
+//      tagged field doesn't matter; it's conventionally `_ struct{}`. The
+//      format may contain %s (for string fields) and %d (for integer fields)
+//      verbs, which must be separated by literals so that SetMeta can parse
+//      the $id back into the fields. String fields must not contain the
+//      literal which follows them. For example:
+//        _      struct{} `gae:"$id,fmt=%s|%d,fields=Parent Num"`
+//        Parent string   `gae:"-"`
+//        Num    int64    `gae:"-"`
+//      has an $id of "moo|100" when Parent is "moo" and Num is 100.
+//
 //   `gae:"[-],extra"` -- indicates that any extra, unrecognized or mismatched
 //      property types (type in datastore doesn't match your struct's field
 //      type) should be loaded into and saved from this field. The precise type
@@ -126,7 +175,8 @@ import (
 // Example "normal" structure that you might use in a go-only appengine app.
 //   struct User {
 //     ID string `gae:"$id"`
-//     // "kind" is automatically implied by the struct name: "User"
+//     // "kind" is automatically implied by the struct name: "User" (or by
+//     // its Kind method, if it implements KindGetter)
 //     // "parent" is nil... Users are root entities
 //
 //     // 'Name' will serialized to the datastore in the field 'Name'
@@ -137,8 +187,11 @@ import (
 //     ID int64 `gae:"$id"`
 //     // "kind" is automatically implied by the struct name: "Comment"
 //
-//     // Parent will be enforced by the application to be a User key.
-//     Parent Key `gae:"$parent"`
+//     // Parent will be enforced by the application to be a User key. It's
+//     // used as the parent of the Comment's key by KeyForObj (and therefore
+//     // by Get and Put), and is populated from the key when the Comment is
+//     // loaded by a query, or Put with an incomplete key.
+//     Parent *Key `gae:"$parent"`
 //
 //     // 'Lines' will serialized to the datastore in the field 'Lines'
 //     Lines []string
@@ -151,6 +204,10 @@ import (
 // $kind field, the $kind field will take precedence and your GetMeta
 // implementation will not be called for "kind".
 //
+// For structs which only use simple field types, the tools/gaegen generator can
+// produce equivalent PropertyLoadSaver and MetaGetterSetter implementations
+// which avoid reflection entirely.
+//
 // A struct overloading any of the PropertyLoadSaver or MetaGetterSetter
 // interfaces may evoke the default struct behavior by using GetPLS on itself.
 // For example:
@@ -17,16 +17,15 @@ import (
 	"github.com/luci/luci-go/common/errors"
 )
 
-// Entities with more than this many indexed properties will not be saved.
-const maxIndexedProperties = 20000
-
 type structTag struct {
 	name           string
 	idxSetting     IndexSetting
+	forceIndex     bool
 	isSlice        bool
 	substructCodec *structCodec
 	convert        bool
 	metaVal        interface{}
+	composite      *compositeID
 	isExtra        bool
 	canSet         bool
 }
@@ -36,11 +35,70 @@ type structCodec struct {
 	byName    map[string]int
 	bySpecial map[string]int
 
+	// aliases maps legacy property names (tagged with "alias=") to the names
+	// in byName which they load into.
+	aliases map[string]string
+
 	byIndex  []structTag
 	hasSlice bool
+	noIndex  bool
 	problem  error
 }
 
+// indexSetting returns the IndexSetting of the field st of c, given is, the
+// IndexSetting inherited from the enclosing struct field (if any).
+//
+// A field tagged noindex is never indexed, and a field tagged index always is.
+// Otherwise, fields of a struct tagged noindex (with a blank field like
+// `_ struct{} gae:",noindex"`) aren't indexed, and other fields inherit is.
+func (c *structCodec) indexSetting(st *structTag, is IndexSetting) IndexSetting {
+	switch {
+	case st.idxSetting == NoIndex:
+		return NoIndex
+	case st.forceIndex:
+		return ShouldIndex
+	case c.noIndex:
+		return NoIndex
+	}
+	return is
+}
+
+// propertyIndexSetting returns the IndexSetting of the property called name
+// which structs of type t (whose codec is c) save, and false if they don't
+// save such a property. name may be an alias, or the dotted name of a property
+// of a nested struct.
+func (c *structCodec) propertyIndexSetting(t reflect.Type, name string) (IndexSetting, bool) {
+	if canon, ok := c.aliases[name]; ok {
+		name = canon
+	}
+	is := ShouldIndex
+	for {
+		i, ok := c.byName[name]
+		if !ok {
+			return is, false
+		}
+		st := &c.byIndex[i]
+		is = c.indexSetting(st, is)
+
+		ft := t.Field(i).Type
+		if st.isSlice {
+			ft = ft.Elem()
+		}
+		if st.substructCodec == nil {
+			if st.convert && is == ShouldIndex {
+				// converters pick their own IndexSetting.
+				if p, err := reflect.New(ft).Interface().(PropertyConverter).ToProperty(); err == nil {
+					is = p.IndexSetting()
+				}
+			}
+			return is, true
+		}
+		// Strip the "I." from "I.X".
+		name = name[len(st.name):]
+		c, t = st.substructCodec, ft
+	}
+}
+
 type structPLS struct {
 	o reflect.Value
 	c *structCodec
@@ -69,9 +127,15 @@ func (p *structPLS) Load(propMap PropertyMap) error {
 	}
 	t := reflect.Type(nil)
 	for name, props := range propMap {
-		multiple := len(props) > 1
+		field := name
+		if canon, ok := p.c.aliases[name]; ok {
+			if _, ok := propMap[canon]; ok {
+				continue // the current name takes precedence over legacy ones
+			}
+			field = canon
+		}
 		for i, prop := range props {
-			if reason := loadInner(p.c, p.o, i, name, prop, multiple); reason != "" {
+			if reason := loadInner(p.c, p.o, i, field, prop, len(props)); reason != "" {
 				if useExtra {
 					if extra != nil {
 						if *extra == nil {
@@ -101,7 +165,13 @@ func (p *structPLS) Load(propMap PropertyMap) error {
 	return nil
 }
 
-func loadInner(codec *structCodec, structValue reflect.Value, index int, name string, p Property, requireSlice bool) string {
+// loadInner loads p, the index'th of count values of the property name, into
+// structValue.
+func loadInner(codec *structCodec, structValue reflect.Value, index int, name string, p Property, count int) string {
+	requireSlice := count > 1
+	// short is set if the property has fewer values than the array field it's
+	// loaded into. It zeroes the elements which aren't loaded.
+	short := (func() string)(nil)
 	var v reflect.Value
 	// Traverse a struct's struct-typed fields.
 	for {
@@ -122,6 +192,21 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 			}
 			structValue = v.Index(index)
 			requireSlice = false
+		} else if v.Kind() == reflect.Array {
+			if index >= v.Len() {
+				return tooManyValuesReason(v)
+			}
+			if index == count-1 && count < v.Len() {
+				arr, rest, sc := v, name[len(st.name):], st.substructCodec
+				short = func() string {
+					for i := count; i < arr.Len(); i++ {
+						zeroField(sc, arr.Index(i), rest)
+					}
+					return tooFewValuesReason(arr)
+				}
+			}
+			structValue = v.Index(index)
+			requireSlice = false
 		} else {
 			structValue = v
 		}
@@ -133,7 +218,7 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 	doConversion := func(v reflect.Value) (string, bool) {
 		a := v.Addr()
 		if conv, ok := a.Interface().(PropertyConverter); ok {
-			err := conv.FromProperty(p)
+			err := conv.FromProperty(projectForConverter(p, v.Type()))
 			if err != nil {
 				return err.Error(), true
 			}
@@ -150,15 +235,37 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() != reflect.Uint8 {
 		slice = v
 		v = reflect.New(v.Type().Elem()).Elem()
+	} else if v.Kind() == reflect.Array && v.Type().Elem().Kind() != reflect.Uint8 {
+		if index >= v.Len() {
+			return tooManyValuesReason(v)
+		}
+		if index == count-1 && count < v.Len() {
+			arr := v
+			short = func() string {
+				for i := count; i < arr.Len(); i++ {
+					arr.Index(i).Set(reflect.Zero(arr.Type().Elem()))
+				}
+				return tooFewValuesReason(arr)
+			}
+		}
+		v = v.Index(index)
 	} else if requireSlice {
 		return "multiple-valued property requires a slice field type"
 	}
+	slot := v
 
 	if ret, ok := doConversion(v); ok {
 		if ret != "" {
 			return ret
 		}
+	} else if isNullableType(v.Type()) && p.Type() == PTNull {
+		v.Set(reflect.Zero(v.Type()))
 	} else {
+		if isNullableType(v.Type()) {
+			ptr := reflect.New(v.Type().Elem())
+			v.Set(ptr)
+			v = ptr.Elem()
+		}
 		knd := v.Kind()
 
 		project := PTNull
@@ -210,6 +317,12 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 			set = func(x interface{}) {
 				v.SetBytes(reflect.ValueOf(x).Bytes())
 			}
+		case reflect.Array:
+			project = PTBytes
+			overflow = func(x interface{}) bool { return len(x.([]byte)) != v.Len() }
+			set = func(x interface{}) {
+				reflect.Copy(v, reflect.ValueOf(x))
+			}
 		default:
 			panic(fmt.Errorf("helper: impossible: %s", typeMismatchReason(p.Value(), v)))
 		}
@@ -224,11 +337,83 @@ func loadInner(codec *structCodec, structValue reflect.Value, index int, name st
 		set(pVal)
 	}
 	if slice.IsValid() {
-		slice.Set(reflect.Append(slice, v))
+		slice.Set(reflect.Append(slice, slot))
+	}
+	if short != nil {
+		return short()
 	}
 	return ""
 }
 
+// zeroField zeroes the field of structValue which the property name is loaded
+// into, if any.
+func zeroField(codec *structCodec, structValue reflect.Value, name string) {
+	for {
+		fieldIndex, ok := codec.byName[name]
+		if !ok {
+			return
+		}
+		v := structValue.Field(fieldIndex)
+		st := codec.byIndex[fieldIndex]
+		if st.substructCodec == nil || v.Kind() != reflect.Struct {
+			v.Set(reflect.Zero(v.Type()))
+			return
+		}
+		structValue = v
+		name = name[len(st.name):]
+		codec = st.substructCodec
+	}
+}
+
+// projectForConverter side-casts p to the type which the PropertyConverter
+// type t saves, if p is that type's index representation (e.g. the PTInt which
+// a projection query returns for a converter which saves a PTTime). Otherwise
+// p is returned unchanged.
+func projectForConverter(p Property, t reflect.Type) Property {
+	if pt := p.Type(); pt != PTInt && pt != PTString {
+		// the only index types which can stand in for another type.
+		return p
+	}
+	saved, err := reflect.New(t).Interface().(PropertyConverter).ToProperty()
+	if err != nil || saved.Type() == p.Type() {
+		return p
+	}
+	if it, _ := saved.IndexTypeAndValue(); it != p.Type() {
+		return p
+	}
+	val, err := p.Project(saved.Type())
+	if err != nil {
+		return p
+	}
+	ret := Property{}
+	if err := ret.SetValue(val, p.IndexSetting()); err != nil {
+		return p
+	}
+	return ret
+}
+
+// tooManyValuesReason returns the reason that a multiple-valued property
+// can't be loaded into the array v.
+func tooManyValuesReason(v reflect.Value) string {
+	return fmt.Sprintf("too many values for array field of type %v", v.Type())
+}
+
+// tooFewValuesReason returns the reason that a property with fewer values
+// than the length of the array v was loaded into it.
+func tooFewValuesReason(v reflect.Value) string {
+	return fmt.Sprintf("too few values for array field of type %v", v.Type())
+}
+
+// isNullableType returns true iff t is a pointer to a scalar property type
+// (e.g. *int64, *string, *time.Time). Such fields save a nil pointer as
+// a PTNull property, and load a PTNull property as a nil pointer.
+//
+// *Key is not nullable in this sense, since it is already a property type of
+// its own.
+func isNullableType(t reflect.Type) bool {
+	return t.Kind() == reflect.Ptr && t != typeOfKey && t.Elem().Kind() != reflect.Ptr
+}
+
 func (p *structPLS) Save(withMeta bool) (PropertyMap, error) {
 	ret := PropertyMap(nil)
 	if withMeta {
@@ -236,7 +421,10 @@ func (p *structPLS) Save(withMeta bool) (PropertyMap, error) {
 	} else {
 		ret = make(PropertyMap, len(p.c.byName))
 	}
-	if _, err := p.save(ret, "", ShouldIndex); err != nil {
+	if err := p.save(ret, "", ShouldIndex); err != nil {
+		return nil, err
+	}
+	if err := checkIndexedCount(ret); err != nil {
 		return nil, err
 	}
 	return ret, nil
@@ -246,38 +434,39 @@ func (p *structPLS) getDefaultKind() string {
 	if !p.o.IsValid() {
 		return ""
 	}
+	if p.o.CanAddr() {
+		if kg, ok := p.o.Addr().Interface().(KindGetter); ok {
+			if kind := kg.Kind(); kind != "" {
+				return kind
+			}
+		}
+	}
 	return p.o.Type().Name()
 }
 
-func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (idxCount int, err error) {
+func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (err error) {
 	saveProp := func(name string, si IndexSetting, v reflect.Value, st *structTag) (err error) {
 		if st.substructCodec != nil {
-			count, err := (&structPLS{v, st.substructCodec}).save(propMap, name, si)
-			if err == nil {
-				idxCount += count
-				if idxCount > maxIndexedProperties {
-					err = errors.New("gae: too many indexed properties")
-				}
-			}
-			return err
+			return (&structPLS{v, st.substructCodec}).save(propMap, name, si)
 		}
 
 		prop := Property{}
-		if st.convert {
+		switch {
+		case st.convert:
 			prop, err = v.Addr().Interface().(PropertyConverter).ToProperty()
-		} else {
+		case isNullableType(v.Type()):
+			if v.IsNil() {
+				err = prop.SetValue(nil, si)
+			} else {
+				err = prop.SetValue(v.Elem().Interface(), si)
+			}
+		default:
 			err = prop.SetValue(v.Interface(), si)
 		}
 		if err != nil {
 			return err
 		}
 		propMap[name] = append(propMap[name], prop)
-		if prop.IndexSetting() == ShouldIndex {
-			idxCount++
-			if idxCount > maxIndexedProperties {
-				return errors.New("gae: too many indexed properties")
-			}
-		}
 		return nil
 	}
 
@@ -290,10 +479,7 @@ func (p *structPLS) save(propMap PropertyMap, prefix string, is IndexSetting) (i
 			name = prefix + name
 		}
 		v := p.o.Field(i)
-		is1 := is
-		if st.idxSetting == NoIndex {
-			is1 = NoIndex
-		}
+		is1 := p.c.indexSetting(&st, is)
 		if st.isSlice {
 			for j := 0; j < v.Len(); j++ {
 				if err = saveProp(name, is1, v.Index(j), &st); err != nil {
@@ -333,6 +519,13 @@ func (p *structPLS) GetMeta(key string) (interface{}, bool) {
 
 func (p *structPLS) getMetaFor(idx int) (interface{}, bool) {
 	st := p.c.byIndex[idx]
+	if st.composite != nil {
+		id, err := st.composite.get(p.o)
+		if err != nil {
+			return nil, false
+		}
+		return id, true
+	}
 	val := st.metaVal
 	if st.canSet {
 		f := p.o.Field(idx)
@@ -356,6 +549,18 @@ func (p *structPLS) getMetaFor(idx int) (interface{}, bool) {
 	return val, true
 }
 
+// compositeIDErr returns the error building the struct's composite $id, if it
+// has one which can't be built.
+func (p *structPLS) compositeIDErr() error {
+	if idx, ok := p.c.byMeta["id"]; ok {
+		if cid := p.c.byIndex[idx].composite; cid != nil {
+			_, err := cid.get(p.o)
+			return err
+		}
+	}
+	return nil
+}
+
 func (p *structPLS) GetAllMeta() PropertyMap {
 	needKind := true
 	ret := make(PropertyMap, len(p.c.byMeta)+1)
@@ -382,6 +587,10 @@ func (p *structPLS) SetMeta(key string, val interface{}) bool {
 		return false
 	}
 	st := p.c.byIndex[idx]
+	if st.composite != nil {
+		id, ok := val.(string)
+		return ok && st.composite.set(p.o, id)
+	}
 	if !st.canSet {
 		return false
 	}
@@ -484,6 +693,7 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 		byName:    make(map[string]int, t.NumField()),
 		byMeta:    make(map[string]int, t.NumField()),
 		bySpecial: make(map[string]int, 1),
+		aliases:   map[string]string{},
 
 		problem: errRecursiveStruct, // we'll clear this later if it's not recursive
 	}
@@ -493,10 +703,20 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			c.byIndex = nil
 			c.byName = nil
 			c.byMeta = nil
+			c.aliases = nil
 		}
 	}()
 	structCodecs[t] = c
 
+	addAlias := func(alias, canon string) bool {
+		if _, ok := c.aliases[alias]; ok {
+			c.problem = me("struct tag has repeated alias: %q", alias)
+			return false
+		}
+		c.aliases[alias] = canon
+		return true
+	}
+
 	for i := range c.byIndex {
 		st := &c.byIndex[i]
 		f := t.Field(i)
@@ -508,6 +728,11 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			name, opts = name[:i], name[i+1:]
 		}
 		st.canSet = f.PkgPath == "" // blank == exported
+		if f.Name == "_" && name == "" && opts == "noindex" {
+			c.noIndex = true
+			st.name = "-"
+			continue
+		}
 		if opts == "extra" {
 			if _, ok := c.bySpecial["extra"]; ok {
 				c.problem = me("struct has multiple fields tagged as 'extra'")
@@ -539,13 +764,24 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				return
 			}
 			c.byMeta[name] = i
-			if !st.convert {
+			if name == "id" && isCompositeIDTag(opts) {
+				cid, err := parseCompositeID(t, opts)
+				if err != nil {
+					c.problem = me("meta field %q is invalid: %s", "$"+name, err)
+					return
+				}
+				st.composite = cid
+			} else if !st.convert {
 				mv, err := convertMeta(opts, ft)
 				if err != nil {
 					c.problem = me("meta field %q has bad type: %s", "$"+name, err)
 					return
 				}
 				st.metaVal = mv
+				if (name == "parent" || name == "key") && ft != typeOfKey {
+					c.problem = me("meta field %q has bad type: must be *Key, got %s", "$"+name, ft)
+					return
+				}
 			}
 			fallthrough
 		case name == "-":
@@ -562,6 +798,25 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 			continue
 		}
 
+		idxOpt, aliases := "", []string(nil)
+		for _, o := range strings.Split(opts, ",") {
+			if !strings.HasPrefix(o, "alias=") {
+				idxOpt = o
+				continue
+			}
+			for _, a := range strings.Split(o[len("alias="):], "|") {
+				if !validPropertyName(a) {
+					c.problem = me("struct tag has invalid alias: %q", a)
+					return
+				}
+				aliases = append(aliases, a)
+			}
+		}
+		if len(aliases) > 0 && name == "" {
+			c.problem = me("embedded field %q can't have aliases", f.Name)
+			return
+		}
+
 		substructType := reflect.Type(nil)
 		if !st.convert {
 			switch ft.Kind() {
@@ -569,7 +824,7 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				if ft != typeOfTime && ft != typeOfGeoPoint {
 					substructType = ft
 				}
-			case reflect.Slice:
+			case reflect.Slice, reflect.Array:
 				if reflect.PtrTo(ft.Elem()).Implements(typeOfPropertyConverter) {
 					st.convert = true
 				} else if ft.Elem().Kind() == reflect.Struct {
@@ -612,6 +867,21 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 					return
 				}
 				c.byName[absName] = i
+				for _, a := range aliases {
+					if !addAlias(a+"."+relName, absName) {
+						return
+					}
+				}
+			}
+			for relAlias, relCanon := range sub.aliases {
+				if !addAlias(name+relAlias, name+relCanon) {
+					return
+				}
+				for _, a := range aliases {
+					if !addAlias(a+"."+relAlias, name+relCanon) {
+						return
+					}
+				}
 			}
 		} else {
 			if !st.convert { // check the underlying static type of the field
@@ -619,6 +889,9 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				if st.isSlice {
 					t = t.Elem()
 				}
+				if isNullableType(t) {
+					t = t.Elem()
+				}
 				v := UpconvertUnderlyingType(reflect.New(t).Elem().Interface())
 				if _, err := PropertyTypeOf(v, false); err != nil {
 					c.problem = me("field %q has invalid type: %s", name, ft)
@@ -631,10 +904,24 @@ func getStructCodecLocked(t reflect.Type) (c *structCodec) {
 				return
 			}
 			c.byName[name] = i
+			for _, a := range aliases {
+				if !addAlias(a, name) {
+					return
+				}
+			}
 		}
 		st.name = name
-		if opts == "noindex" {
+		switch idxOpt {
+		case "noindex":
 			st.idxSetting = NoIndex
+		case "index":
+			st.forceIndex = true
+		}
+	}
+	for alias := range c.aliases {
+		if _, ok := c.byName[alias]; ok {
+			c.problem = me("struct tag alias %q is also a property name", alias)
+			return
 		}
 	}
 	if c.problem == errRecursiveStruct {
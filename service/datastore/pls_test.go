@@ -18,6 +18,7 @@ import (
 	"time"
 
 	"github.com/tetrafolium/gae/service/blobstore"
+	"github.com/luci/luci-go/common/errors"
 	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
 )
@@ -182,6 +183,38 @@ type T struct {
 	T time.Time
 }
 
+type P0 struct {
+	I *int64
+	S *string
+	B *bool
+	T *time.Time
+}
+
+type F32 struct {
+	F  float32
+	FS []float32
+	FP *float32
+}
+
+type A0 struct {
+	I      [3]int64
+	S      [2]string
+	Digest [4]byte
+	Inner  [2]struct{ X int64 }
+}
+
+type P1 struct {
+	I []*int64
+}
+
+type R0 struct {
+	Name  string   `gae:"name,alias=Name|FullName"`
+	Tags  []string `gae:",noindex,alias=Labels"`
+	Inner struct {
+		X int64 `gae:",alias=Y"`
+	} `gae:"In,alias=Inner"`
+}
+
 type X0 struct {
 	S string
 	I int
@@ -609,6 +642,25 @@ func (i *IDParser) SetMeta(key string, value interface{}) bool {
 	return GetPLS(i).SetMeta(key, value)
 }
 
+type ShardedKind struct {
+	ID    int64 `gae:"$id"`
+	Shard int   `gae:"-"`
+}
+
+var _ KindGetter = (*ShardedKind)(nil)
+
+func (s *ShardedKind) Kind() string {
+	if s.Shard < 0 {
+		return ""
+	}
+	return fmt.Sprintf("ShardedKind%d", s.Shard)
+}
+
+type ShardedKindOverride struct {
+	ShardedKind
+	_kind string `gae:"$kind,Override"`
+}
+
 type KindOverride struct {
 	ID int64 `gae:"$id"`
 
@@ -959,6 +1011,15 @@ var testCases = []testCase{
 					`{"epic":"success","no_way!":[true,"story"],"what":["is","really",100]}`))},
 		},
 	},
+	{
+		desc: "convertable json KVMap (projected)",
+		src: PropertyMap{
+			"kewelmap": {mp(`{"epic":"success"}`)},
+		},
+		want: &Impossible3{
+			JSONKVProp{"epic": "success"},
+		},
+	},
 	{
 		desc: "convertable complex slice",
 		src: &Impossible4{
@@ -1596,6 +1657,45 @@ var testCases = []testCase{
 			"B.X": {mpNI("")},
 		},
 	},
+	{
+		desc: "save structs with index overrides",
+		src: &struct {
+			_ struct{} `gae:",noindex"`
+			A struct {
+				X string `gae:",index"`
+				Y string
+			} `gae:",noindex"`
+			B struct {
+				_ struct{} `gae:",noindex"`
+				X string   `gae:",index"`
+				Y string
+			}
+			C string `gae:",index"`
+			D string
+		}{},
+		want: PropertyMap{
+			"A.X": {mp("")},
+			"A.Y": {mpNI("")},
+			"B.X": {mp("")},
+			"B.Y": {mpNI("")},
+			"C":   {mp("")},
+			"D":   {mpNI("")},
+		},
+	},
+	{
+		desc: "noindex struct doesn't apply to its parent",
+		src: &struct {
+			A struct {
+				_ struct{} `gae:",noindex"`
+				X string
+			}
+			B string
+		}{},
+		want: PropertyMap{
+			"A.X": {mpNI("")},
+			"B":   {mp("")},
+		},
+	},
 	{
 		desc: "embedded struct with name override",
 		src: &struct {
@@ -1606,6 +1706,217 @@ var testCases = []testCase{
 			"foo.X": {mp("")},
 		},
 	},
+	{
+		desc: "nil pointer fields",
+		src:  &P0{},
+		want: &P0{},
+	},
+	{
+		desc: "float32 fields are widened to float64",
+		src: &F32{
+			F:  1.5,
+			FS: []float32{0.25, -2},
+			FP: func() *float32 { f := float32(3); return &f }(),
+		},
+		want: PropertyMap{
+			"F":  {mp(1.5)},
+			"FS": {mp(0.25), mp(-2.0)},
+			"FP": {mp(3.0)},
+		},
+	},
+	{
+		desc: "float32 fields round trip",
+		src: &F32{
+			F:  1.5,
+			FS: []float32{0.25, -2},
+			FP: func() *float32 { f := float32(3); return &f }(),
+		},
+		want: &F32{
+			F:  1.5,
+			FS: []float32{0.25, -2},
+			FP: func() *float32 { f := float32(3); return &f }(),
+		},
+	},
+	{
+		desc:    "float32 fields are range-checked on load",
+		src:     PropertyMap{"FS": {mp(1.0), mp(-math.MaxFloat64)}},
+		want:    &F32{},
+		loadErr: "overflows struct field of type float32",
+	},
+	{
+		desc: "array fields",
+		src: &A0{
+			I:      [3]int64{1, 2, 3},
+			S:      [2]string{"a", "b"},
+			Digest: [4]byte{0xde, 0xad, 0xbe, 0xef},
+			Inner:  [2]struct{ X int64 }{{10}, {20}},
+		},
+		want: PropertyMap{
+			"I":       {mp(1), mp(2), mp(3)},
+			"S":       {mp("a"), mp("b")},
+			"Digest":  {mp([]byte{0xde, 0xad, 0xbe, 0xef})},
+			"Inner.X": {mp(10), mp(20)},
+		},
+	},
+	{
+		desc: "array fields round trip",
+		src: &A0{
+			I:      [3]int64{1, 2, 3},
+			S:      [2]string{"a", "b"},
+			Digest: [4]byte{0xde, 0xad, 0xbe, 0xef},
+			Inner:  [2]struct{ X int64 }{{10}, {20}},
+		},
+		want: &A0{
+			I:      [3]int64{1, 2, 3},
+			S:      [2]string{"a", "b"},
+			Digest: [4]byte{0xde, 0xad, 0xbe, 0xef},
+			Inner:  [2]struct{ X int64 }{{10}, {20}},
+		},
+	},
+	{
+		desc:    "array fields are length-checked on load",
+		src:     PropertyMap{"S": {mp("a"), mp("b"), mp("c")}},
+		want:    &A0{},
+		loadErr: "too many values for array field of type [2]string",
+	},
+	{
+		desc:    "array struct fields are length-checked on load",
+		src:     PropertyMap{"Inner.X": {mp(1), mp(2), mp(3)}},
+		want:    &A0{},
+		loadErr: "too many values for array field of type [2]struct",
+	},
+	{
+		desc:    "array fields with too few values are reported on load",
+		src:     PropertyMap{"I": {mp(1), mp(2)}},
+		want:    &A0{},
+		loadErr: "too few values for array field of type [3]int64",
+	},
+	{
+		desc:    "array struct fields with too few values are reported on load",
+		src:     PropertyMap{"Inner.X": {mp(1)}},
+		want:    &A0{},
+		loadErr: "too few values for array field of type [2]struct",
+	},
+	{
+		desc:    "byte array fields are length-checked on load",
+		src:     PropertyMap{"Digest": {mp([]byte{1, 2, 3})}},
+		want:    &A0{},
+		loadErr: "overflows struct field of type [4]uint8",
+	},
+	{
+		desc: "aliased fields save under their current name",
+		src: func() *R0 {
+			r := &R0{Name: "n", Tags: []string{"a", "b"}}
+			r.Inner.X = 1
+			return r
+		}(),
+		want: PropertyMap{
+			"name": {mp("n")},
+			"Tags": {mpNI("a"), mpNI("b")},
+			"In.X": {mp(1)},
+		},
+	},
+	{
+		desc: "aliased fields load from legacy names",
+		src: PropertyMap{
+			"FullName": {mp("n")},
+			"Labels":   {mp("a"), mp("b")},
+			"Inner.Y":  {mp(1)},
+		},
+		want: func() *R0 {
+			r := &R0{Name: "n", Tags: []string{"a", "b"}}
+			r.Inner.X = 1
+			return r
+		}(),
+	},
+	{
+		desc: "aliased fields prefer their current name",
+		src: PropertyMap{
+			"name":     {mp("new")},
+			"Name":     {mp("old")},
+			"FullName": {mp("older")},
+			"In.Y":     {mp(1)},
+			"In.X":     {mp(2)},
+		},
+		want: func() *R0 {
+			r := &R0{Name: "new"}
+			r.Inner.X = 2
+			return r
+		}(),
+	},
+	{
+		desc: "aliases can't be property names",
+		src: &struct {
+			A string `gae:",alias=B"`
+			B string
+		}{},
+		plsErr: `struct tag alias "B" is also a property name`,
+	},
+	{
+		desc: "aliases can't be repeated",
+		src: &struct {
+			A string `gae:",alias=C"`
+			B string `gae:",alias=C"`
+		}{},
+		plsErr: `struct tag has repeated alias: "C"`,
+	},
+	{
+		desc: "aliases must be valid property names",
+		src: &struct {
+			A string `gae:",alias=no good"`
+		}{},
+		plsErr: `struct tag has invalid alias: "no good"`,
+	},
+	{
+		desc: "nil pointer fields as props",
+		src:  &P0{},
+		want: PropertyMap{
+			"I": {mp(nil)},
+			"S": {mp(nil)},
+			"B": {mp(nil)},
+			"T": {mp(nil)},
+		},
+	},
+	{
+		desc: "non-nil pointer fields",
+		src: &P0{
+			I: func() *int64 { i := int64(0); return &i }(),
+			S: func() *string { s := "hi"; return &s }(),
+			B: func() *bool { b := false; return &b }(),
+		},
+		want: PropertyMap{
+			"I": {mp(0)},
+			"S": {mp("hi")},
+			"B": {mp(false)},
+			"T": {mp(nil)},
+		},
+	},
+	{
+		desc: "non-nil pointer fields round trip",
+		src: &P0{
+			I: func() *int64 { i := int64(10); return &i }(),
+			S: func() *string { s := ""; return &s }(),
+		},
+		want: &P0{
+			I: func() *int64 { i := int64(10); return &i }(),
+			S: func() *string { s := ""; return &s }(),
+		},
+	},
+	{
+		desc: "slice of pointers",
+		src: &P1{
+			I: []*int64{nil, func() *int64 { i := int64(3); return &i }()},
+		},
+		want: PropertyMap{
+			"I": {mp(nil), mp(3)},
+		},
+	},
+	{
+		desc:    "pointer field type mismatch",
+		src:     &struct{ I string }{"nope"},
+		want:    &P0{},
+		loadErr: "type mismatch",
+	},
 	{
 		desc:   "slice of slices",
 		src:    &SliceOfSlices{},
@@ -1726,6 +2037,37 @@ func TestRoundTrip(t *testing.T) {
 	})
 }
 
+func TestArrayLoad(t *testing.T) {
+	t.Parallel()
+
+	Convey("Loading fewer values than an array's length", t, func() {
+		o := &A0{
+			I:     [3]int64{1, 2, 3},
+			S:     [2]string{"a", "b"},
+			Inner: [2]struct{ X int64 }{{10}, {20}},
+		}
+		err := GetPLS(o).Load(PropertyMap{
+			"I":       {mp(7)},
+			"S":       {mp("x"), mp("y")},
+			"Inner.X": {mp(30)},
+		})
+
+		Convey("reports a mismatch for each short array", func() {
+			So(err, ShouldHaveLength, 2)
+			for _, err := range err.(errors.MultiError) {
+				So(err, ShouldHaveSameTypeAs, &ErrFieldMismatch{})
+				So(err, ShouldErrLike, "too few values for array field")
+			}
+		})
+
+		Convey("zeroes the elements which aren't loaded", func() {
+			So(o.I, ShouldResemble, [3]int64{7, 0, 0})
+			So(o.S, ShouldResemble, [2]string{"x", "y"})
+			So(o.Inner, ShouldResemble, [2]struct{ X int64 }{{30}, {0}})
+		})
+	})
+}
+
 func TestMeta(t *testing.T) {
 	t.Parallel()
 
@@ -1808,6 +2150,20 @@ func TestMeta(t *testing.T) {
 			So(func() { GetPLS(o) }, ShouldPanicLike, "multiple times")
 		})
 
+		Convey("$parent and $key must be *Key", func() {
+			type BadParent struct {
+				Parent string `gae:"$parent"`
+			}
+			So(func() { GetPLS(&BadParent{}) }, ShouldPanicLike,
+				`meta field "$parent" has bad type: must be *Key, got string`)
+
+			type BadKey struct {
+				Key Key `gae:"$key"`
+			}
+			So(func() { GetPLS(&BadKey{}) }, ShouldPanicLike,
+				`meta field "$key" has bad type`)
+		})
+
 		Convey("empty property names are invalid", func() {
 			So(validPropertyName(""), ShouldBeFalse)
 		})
@@ -1981,6 +2337,108 @@ func TestMeta(t *testing.T) {
 			})
 		})
 
+		Convey("composite $id", func() {
+			type Composite struct {
+				_      struct{} `gae:"$id,fmt=%s|%d,fields=Parent ID"`
+				_kind  string   `gae:"$kind,CoolKind"`
+				Parent string   `gae:"-"`
+				ID     uint32   `gae:"-"`
+				Value  int64
+			}
+			c := &Composite{Parent: "moo", ID: 100}
+			mgs := getMGS(c)
+			So(GetMetaDefault(mgs, "id", ""), ShouldEqual, "moo|100")
+
+			So(mgs.SetMeta("id", "a|b|27"), ShouldBeTrue)
+			So(c.Parent, ShouldEqual, "a|b")
+			So(c.ID, ShouldEqual, 27)
+
+			So(mgs.SetMeta("id", "nope"), ShouldBeFalse)
+			So(mgs.SetMeta("id", "x|-1"), ShouldBeFalse)
+			So(mgs.SetMeta("id", 27), ShouldBeFalse)
+			So(c.Parent, ShouldEqual, "a|b")
+
+			So(mgs.GetAllMeta(), ShouldResemble, PropertyMap{
+				"$id":   {mpNI("a|b|27")},
+				"$kind": {mpNI("CoolKind")},
+			})
+
+			pm, err := GetPLS(c).Save(false)
+			So(err, ShouldBeNil)
+			So(pm, ShouldResemble, PropertyMap{"Value": {mp(0)}})
+
+			Convey("round-trips values containing the separator", func() {
+				type Pair struct {
+					_ struct{} `gae:"$id,fmt=%s|%s,fields=A B"`
+					A string
+					B string
+				}
+				for _, p := range []*Pair{{A: "a", B: "b|c"}, {A: "", B: "|"}, {A: "a", B: ""}} {
+					id := GetMetaDefault(getMGS(p), "id", "")
+					So(id, ShouldNotEqual, "")
+					loaded := &Pair{}
+					So(getMGS(loaded).SetMeta("id", id), ShouldBeTrue)
+					So(loaded, ShouldResemble, p)
+				}
+
+				// "a|b|c" would load back as {"a", "b|c"}, so it's not an ID.
+				bad := getMGS(&Pair{A: "a|b", B: "c"})
+				_, ok := bad.GetMeta("id")
+				So(ok, ShouldBeFalse)
+				So(bad.(*structPLS).compositeIDErr(), ShouldErrLike, `composite $id "a|b|c" is ambiguous: field "A"`)
+			})
+		})
+
+		Convey("bad composite $id", func() {
+			type BadVerb struct {
+				_ struct{} `gae:"$id,fmt=%s-%x,fields=A B"`
+				A string
+				B int64
+			}
+			So(func() { GetPLS(&BadVerb{}) }, ShouldPanicLike, "unsupported verb %x")
+
+			type Adjacent struct {
+				_ struct{} `gae:"$id,fmt=%s%d,fields=A B"`
+				A string
+				B int64
+			}
+			So(func() { GetPLS(&Adjacent{}) }, ShouldPanicLike, "adjacent verbs")
+
+			type Mismatch struct {
+				_ struct{} `gae:"$id,fmt=%d,fields=A"`
+				A string
+			}
+			So(func() { GetPLS(&Mismatch{}) }, ShouldPanicLike, `field "A" has type string`)
+
+			type Missing struct {
+				_ struct{} `gae:"$id,fmt=%s/%s,fields=A B"`
+				A string
+			}
+			So(func() { GetPLS(&Missing{}) }, ShouldPanicLike, `unknown field "B"`)
+
+			type Count struct {
+				_ struct{} `gae:"$id,fmt=%s,fields=A B"`
+				A string
+				B string
+			}
+			So(func() { GetPLS(&Count{}) }, ShouldPanicLike, "has 1 verbs, but 2 fields")
+		})
+
+		Convey("KindGetter implementation", func() {
+			sk := &ShardedKind{ID: 1, Shard: 3}
+			mgs := getMGS(sk)
+			So(GetMetaDefault(mgs, "kind", ""), ShouldEqual, "ShardedKind3")
+			So(mgs.GetAllMeta(), ShouldResemble, PropertyMap{
+				"$id":   {mpNI(1)},
+				"$kind": {mpNI("ShardedKind3")},
+			})
+
+			sk.Shard = -1
+			So(GetMetaDefault(mgs, "kind", ""), ShouldEqual, "ShardedKind")
+
+			So(GetMetaDefault(getMGS(&ShardedKindOverride{}), "kind", ""), ShouldEqual, "Override")
+		})
+
 		Convey("MetaGetterSetter implementation (KindOverride)", func() {
 			ko := &KindOverride{ID: 20}
 			mgs := getMGS(ko)
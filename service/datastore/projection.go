@@ -0,0 +1,116 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"golang.org/x/net/context"
+)
+
+// RunProjection runs the projection query q with the datastore in c, and
+// appends a struct to dst for each of its results, like GetAll. dst must be a
+// *[]S or *[]*S, for a struct type S which holds just the projected
+// properties:
+//   type Summary struct {
+//     Name string
+//     Age  int64
+//   }
+//   summaries := []Summary{}
+//   err := RunProjection(c, NewQuery("Person").Project("Name", "Age"), &summaries)
+//
+// Before the query is run, S is checked against the query's projection:
+// every projected property must have a field in S, which isn't a slice (since
+// each result has one value of each projected property), and every
+// (non-meta) field of S must be projected. Meta fields, like $id, are loaded
+// as usual.
+//
+// It panics if dst isn't a pointer to a slice of structs.
+func RunProjection(c context.Context, q *Query, dst interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Slice {
+		panic(fmt.Errorf("invalid RunProjection dst: must be a ptr-to-slice of structs: %T", dst))
+	}
+	et := v.Elem().Type().Elem()
+	if et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	if et.Kind() != reflect.Struct {
+		panic(fmt.Errorf("invalid RunProjection dst: must be a ptr-to-slice of structs: %T", dst))
+	}
+
+	fq, err := q.Finalize()
+	if err != nil {
+		return err
+	}
+	if err := checkProjection(et, fq.Project()); err != nil {
+		return err
+	}
+	return Get(c).GetAll(q, dst)
+}
+
+// checkProjection checks that the struct type t holds exactly the projected
+// properties (see RunProjection).
+func checkProjection(t reflect.Type, project []string) error {
+	if len(project) == 0 {
+		return fmt.Errorf("datastore: RunProjection query doesn't project any properties")
+	}
+	codec := getCodec(t)
+
+	projected := make(map[string]bool, len(project))
+	for _, name := range project {
+		ft, ok := codec.propertyType(t, name)
+		if !ok {
+			return fmt.Errorf("datastore: projected property %q has no field in %s", name, t)
+		}
+		if ft.Kind() == reflect.Slice && ft.Elem().Kind() != reflect.Uint8 {
+			return fmt.Errorf("datastore: field of projected property %q in %s is a slice (%s), but a "+
+				"projection has one value of each property", name, t, ft)
+		}
+		if canon, ok := codec.aliases[name]; ok {
+			name = canon
+		}
+		projected[name] = true
+	}
+
+	missing := []string(nil)
+	for name := range codec.byName {
+		if !projected[name] {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("datastore: fields of %s aren't projected by the query: %q", t, missing)
+	}
+	return nil
+}
+
+// propertyType returns the type of the field of structs of type t (whose
+// codec is c) which the property called name loads into, and false if there
+// isn't one. name may be an alias, or the dotted name of a property of a
+// nested struct, in which case the type is the nested struct's field's (or the
+// slice of nested structs, if they're in one).
+func (c *structCodec) propertyType(t reflect.Type, name string) (reflect.Type, bool) {
+	if canon, ok := c.aliases[name]; ok {
+		name = canon
+	}
+	for {
+		i, ok := c.byName[name]
+		if !ok {
+			return nil, false
+		}
+		st := &c.byIndex[i]
+		ft := t.Field(i).Type
+		if st.substructCodec == nil || st.isSlice {
+			return ft, true
+		}
+		// Strip the "I." from "I.X".
+		name = name[len(st.name):]
+		c, t = st.substructCodec, ft
+	}
+}
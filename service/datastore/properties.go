@@ -9,8 +9,11 @@ import (
 	"encoding/base64"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"reflect"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/tetrafolium/gae/service/blobstore"
@@ -92,7 +95,10 @@ type PropertyConverter interface {
 // has a Project(PropertyType) method which will side-cast to your intended
 // type. If you project into a structure with the high-level Interface
 // implementation, or use StructPLS, this conversion will be done for you
-// automatically, using the type of the destination field to cast.
+// automatically, using the type of the destination field to cast. Fields which
+// implement PropertyConverter are given the type that they save, if the
+// projected value can be cast to it; fields which weren't projected are left
+// untouched.
 type PropertyType byte
 
 //go:generate stringer -type=PropertyType
@@ -117,6 +123,9 @@ const (
 	// python where 'None' is a distinct value than the 'zero' value (e.g. a
 	// StringProperty can have the value "" OR None).
 	//
+	// Pointer-typed struct fields (e.g. *string) are the exception: a nil
+	// pointer saves as PTNull, and PTNull loads as a nil pointer.
+	//
 	// PTNull is a Projection-query type
 	PTNull PropertyType = iota
 
@@ -295,7 +304,20 @@ func timeLocationIsUTC(l *time.Location) bool {
 // its native datastore-compatible type. e.g. int16 will convert to int64, and
 // `type Foo string` will convert to `string`.
 func UpconvertUnderlyingType(o interface{}) interface{} {
-	if o == nil {
+	// Fast path: values which already have their native type don't need
+	// reflection.
+	switch x := o.(type) {
+	case nil, int64, float64, bool, string, []byte, GeoPoint, blobstore.Key:
+		return o
+	case time.Time:
+		if !x.IsZero() {
+			return RoundTime(x)
+		}
+		return o
+	case *Key:
+		if x == nil {
+			return nil
+		}
 		return o
 	}
 
@@ -318,6 +340,13 @@ func UpconvertUnderlyingType(o interface{}) interface{} {
 		if t.Elem().Kind() == reflect.Uint8 {
 			o = v.Bytes()
 		}
+	case reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// e.g. a [32]byte digest.
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			o = b
+		}
 	case reflect.Struct:
 		if t == typeOfTime {
 			tim := v.Interface().(time.Time)
@@ -386,9 +415,8 @@ func (p *Property) Type() PropertyType { return p.propType }
 // field types.
 //
 // A value may also be the nil interface value; this is equivalent to
-// Python's None but not directly representable by a Go struct. Loading
-// a nil-valued property into a struct will set that field to the zero
-// value.
+// Python's None. Loading a nil-valued property into a struct will set that
+// field to the zero value (nil, for pointer fields).
 func (p *Property) SetValue(value interface{}, is IndexSetting) (err error) {
 	pt := PTNull
 	if value != nil {
@@ -660,6 +688,33 @@ func (p *Property) GQL() string {
 	panic(fmt.Errorf("bad type: %s", p.propType))
 }
 
+// String returns a human-readable representation of the Property, like
+// `PTString("hello")` or `PTInt(10, NoIndex)`. It's meant for logs and test
+// failures, not for serialization.
+func (p Property) String() string {
+	val := ""
+	switch v := p.Value().(type) {
+	case nil:
+		val = "nil"
+	case string:
+		val = strconv.Quote(v)
+	case []byte:
+		val = strconv.Quote(string(v))
+	case blobstore.Key:
+		val = strconv.Quote(string(v))
+	case time.Time:
+		val = v.Format(time.RFC3339Nano)
+	case GeoPoint:
+		val = fmt.Sprintf("%v,%v", v.Lat, v.Lng)
+	default:
+		val = fmt.Sprint(v)
+	}
+	if p.indexSetting == NoIndex {
+		return fmt.Sprintf("%s(%s, %s)", p.propType, val, NoIndex)
+	}
+	return fmt.Sprintf("%s(%s)", p.propType, val)
+}
+
 // PropertySlice is a slice of Properties. It implements sort.Interface.
 type PropertySlice []Property
 
@@ -774,6 +829,17 @@ type MetaGetterSetter interface {
 	SetMeta(key string, val interface{}) bool
 }
 
+// KindGetter may be implemented by a *struct to choose its kind at runtime
+// (e.g. for sharded or per-tenant kinds), without implementing a full
+// MetaGetterSetter.
+//
+// If implemented, GetPLS uses the result of Kind as the default $kind of the
+// struct instead of the struct's type name (unless it's empty). An explicit
+// `gae:"$kind"` field still takes precedence.
+type KindGetter interface {
+	Kind() string
+}
+
 // PropertyMap represents the contents of a datastore entity in a generic way.
 // It maps from property name to a list of property values which correspond to
 // that property name. It is the spiritual successor to PropertyList from the
@@ -858,6 +924,28 @@ func (pm PropertyMap) SetMeta(key string, val interface{}) bool {
 	return true
 }
 
+// GetString returns the first value of the property name, or def if it doesn't
+// have any values or the first isn't a string.
+func (pm PropertyMap) GetString(name, def string) string {
+	if vals := pm[name]; len(vals) > 0 {
+		if s, ok := vals[0].Value().(string); ok {
+			return s
+		}
+	}
+	return def
+}
+
+// GetInt64 returns the first value of the property name, or def if it doesn't
+// have any values or the first isn't an integer.
+func (pm PropertyMap) GetInt64(name string, def int64) int64 {
+	if vals := pm[name]; len(vals) > 0 {
+		if i, ok := vals[0].Value().(int64); ok {
+			return i
+		}
+	}
+	return def
+}
+
 // Problem implements PropertyLoadSaver.Problem. It ALWAYS returns nil.
 func (pm PropertyMap) Problem() error {
 	return nil
@@ -882,6 +970,267 @@ func (pm PropertyMap) EstimateSize() int64 {
 	return ret
 }
 
+// maxIndexedValueSize is the number of bytes of a string-like value which are
+// stored in an index row.
+const maxIndexedValueSize = 1500
+
+// indexRowOverhead is the fixed per-row overhead of an index row.
+const indexRowOverhead = 4
+
+// Cost is an estimate of what it takes to store an entity in the production
+// Appengine datastore, as returned by PropertyMap.EstimateCost.
+type Cost struct {
+	// EntitySize is the estimated size of the entity itself. It's the same as
+	// PropertyMap.EstimateSize.
+	EntitySize int64
+
+	// IndexSize is the estimated size of the builtin index rows of the entity:
+	// one EntitiesByKind row, and an ascending and a descending
+	// EntitiesByProperty row for every indexed property value.
+	IndexSize int64
+
+	// IndexRows is the number of builtin index rows of the entity.
+	IndexRows int64
+
+	// WriteOps is the number of datastore write operations needed to put the
+	// entity as a new entity: 2 for the entity (and its EntitiesByKind row),
+	// plus 2 for every indexed property value.
+	WriteOps int64
+}
+
+// Size returns the total estimated number of bytes of c.
+func (c Cost) Size() int64 {
+	return c.EntitySize + c.IndexSize
+}
+
+// EstimateCost estimates the cost of storing this PropertyMap as the entity
+// with the given key in the production Appengine datastore, including its
+// builtin index rows. Values with the NoIndex setting don't have index rows.
+// Composite indexes are not taken into account. The calculation excludes
+// metadata fields in the map.
+//
+// key may be nil, in which case the size of the key (which is part of every
+// index row) isn't included.
+//
+// Like EstimateSize, it uses
+// https://cloud.google.com/appengine/articles/storage_breakdown?csw=1 as
+// a guide for sizes.
+func (pm PropertyMap) EstimateCost(key *Key) Cost {
+	rowSize := int64(indexRowOverhead)
+	if key != nil {
+		rowSize += int64(len(key.AppID())+len(key.Kind())) + key.EstimateSize()
+	}
+
+	ret := Cost{
+		EntitySize: pm.EstimateSize(),
+		IndexSize:  rowSize,
+		IndexRows:  1,
+		WriteOps:   2,
+	}
+	for k, vals := range pm {
+		if isMetaKey(k) {
+			continue
+		}
+		for i := range vals {
+			if vals[i].IndexSetting() == NoIndex {
+				continue
+			}
+			valSize := vals[i].EstimateSize()
+			if valSize > 1+maxIndexedValueSize {
+				valSize = 1 + maxIndexedValueSize
+			}
+			ret.IndexSize += 2 * (rowSize + int64(len(k)) + valSize)
+			ret.IndexRows += 2
+			ret.WriteOps += 2
+		}
+	}
+	return ret
+}
+
+// String returns a human-readable representation of the PropertyMap, with
+// its properties sorted by name, e.g.
+// `{"$key": [PTKey(app::/Kind,1, NoIndex)], "Name": [PTString("hi")]}`.
+//
+// Because the output is deterministic, it's suitable for logs and test
+// failures.
+func (pm PropertyMap) String() string {
+	buf := &bytes.Buffer{}
+	pm.write(buf, false)
+	return buf.String()
+}
+
+// Format implements fmt.Formatter. The "%+v" verb prints the PropertyMap with
+// one property per line, which is useful for debug dumps of large entities.
+// "%#v" prints the Go-syntax representation of the underlying map. All other
+// verbs are equivalent to String().
+func (pm PropertyMap) Format(f fmt.State, verb rune) {
+	switch {
+	case verb == 'v' && f.Flag('#'):
+		fmt.Fprintf(f, "%#v", map[string][]Property(pm))
+	case verb == 'v' && f.Flag('+'):
+		pm.write(f, true)
+	default:
+		pm.write(f, false)
+	}
+}
+
+func (pm PropertyMap) write(w io.Writer, multiline bool) {
+	keys := make([]string, 0, len(pm))
+	for k := range pm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	sep := ", "
+	if multiline {
+		sep = ",\n"
+	}
+	fmt.Fprint(w, "{")
+	if multiline && len(keys) > 0 {
+		fmt.Fprint(w, "\n")
+	}
+	for i, k := range keys {
+		if i > 0 {
+			fmt.Fprint(w, sep)
+		}
+		if multiline {
+			fmt.Fprint(w, "  ")
+		}
+		fmt.Fprintf(w, "%q: [", k)
+		for j, p := range pm[k] {
+			if j > 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprint(w, p.String())
+		}
+		fmt.Fprint(w, "]")
+	}
+	if multiline && len(keys) > 0 {
+		fmt.Fprint(w, ",\n")
+	}
+	fmt.Fprint(w, "}")
+}
+
+// Clone returns a deep copy of pm, including its metadata. Modifying the
+// returned PropertyMap (or any []byte values in it) will not affect pm.
+func (pm PropertyMap) Clone() PropertyMap {
+	if pm == nil {
+		return nil
+	}
+	ret := make(PropertyMap, len(pm))
+	for k, vals := range pm {
+		ret[k] = cloneProperties(vals)
+	}
+	return ret
+}
+
+func cloneProperties(vals []Property) []Property {
+	if vals == nil {
+		return nil
+	}
+	ret := make([]Property, len(vals))
+	for i, v := range vals {
+		if bs, ok := v.value.(bytesByteSequence); ok {
+			v.value = append(bytesByteSequence(nil), bs...)
+		}
+		ret[i] = v
+	}
+	return ret
+}
+
+// WithKeyContext returns a copy of pm with every key in it (including those in
+// metadata, like "$key" and "$parent") moved to the app ID aid and namespace
+// ns, with Key.WithContext. Properties which don't contain keys are shared
+// with pm, rather than copied.
+func (pm PropertyMap) WithKeyContext(aid, ns string) PropertyMap {
+	if pm == nil {
+		return nil
+	}
+	ret := make(PropertyMap, len(pm))
+	for name, vals := range pm {
+		newVals := vals
+		for i, v := range vals {
+			k, ok := v.value.(*Key)
+			if !ok {
+				continue
+			}
+			if nk := k.WithContext(aid, ns); nk != k {
+				if &newVals[0] == &vals[0] {
+					newVals = append([]Property(nil), vals...)
+				}
+				newVals[i].value = nk
+			}
+		}
+		ret[name] = newVals
+	}
+	return ret
+}
+
+// PropertyDiff describes a single property which differs between two
+// PropertyMaps.
+type PropertyDiff struct {
+	Name string
+
+	// Old is the property's values in the original PropertyMap. It's nil if the
+	// property was added.
+	Old []Property
+
+	// New is the property's values in the other PropertyMap. It's nil if the
+	// property was removed.
+	New []Property
+}
+
+// Diff returns the properties (including metadata) which differ between pm
+// and other, sorted by name.
+//
+// Two properties are the same iff they have the same number of values, and
+// each value has the same type, index setting and value, in order.
+func (pm PropertyMap) Diff(other PropertyMap) []PropertyDiff {
+	ret := []PropertyDiff(nil)
+	for k, vals := range pm {
+		ovals, ok := other[k]
+		if !ok {
+			ret = append(ret, PropertyDiff{k, vals, nil})
+		} else if !propertiesEqual(vals, ovals) {
+			ret = append(ret, PropertyDiff{k, vals, ovals})
+		}
+	}
+	for k, ovals := range other {
+		if _, ok := pm[k]; !ok {
+			ret = append(ret, PropertyDiff{k, nil, ovals})
+		}
+	}
+	sort.Sort(propertyDiffs(ret))
+	return ret
+}
+
+type propertyDiffs []PropertyDiff
+
+func (s propertyDiffs) Len() int           { return len(s) }
+func (s propertyDiffs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s propertyDiffs) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+func propertiesEqual(a, b []Property) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Type() != b[i].Type() || !a[i].Equal(&b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge copies every property in other into pm, replacing the values of any
+// same-named property already in pm. The values are copied, so that pm does
+// not share any storage with other.
+func (pm PropertyMap) Merge(other PropertyMap) {
+	for k, vals := range other {
+		pm[k] = cloneProperties(vals)
+	}
+}
+
 func isMetaKey(k string) bool {
 	// empty counts as a metakey since it's not a valid data key, but it's
 	// not really a valid metakey either.
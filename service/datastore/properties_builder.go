@@ -0,0 +1,55 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+// PMBuilder builds a PropertyMap, for code which would otherwise spell one
+// out as a literal:
+//   pm := NewPM().Set("Name", "x").SetIndexed("Age", 3).Build()
+//
+// Like MkProperty, its methods panic if they're given invalid values.
+type PMBuilder struct {
+	pm PropertyMap
+}
+
+// NewPM returns a PMBuilder for an empty PropertyMap.
+func NewPM() *PMBuilder {
+	return &PMBuilder{PropertyMap{}}
+}
+
+// Set sets the property name to the given values, which aren't indexed.
+// A property with more than one value is multi-valued.
+func (b *PMBuilder) Set(name string, vals ...interface{}) *PMBuilder {
+	return b.set(name, NoIndex, vals)
+}
+
+// SetIndexed sets the property name to the given values, which are indexed
+// (unless they're of an unindexable type, like []byte).
+func (b *PMBuilder) SetIndexed(name string, vals ...interface{}) *PMBuilder {
+	return b.set(name, ShouldIndex, vals)
+}
+
+// SetMeta sets the meta property key (without its '$' prefix), e.g. "key",
+// "kind" or "parent".
+func (b *PMBuilder) SetMeta(key string, val interface{}) *PMBuilder {
+	return b.set("$"+key, NoIndex, []interface{}{val})
+}
+
+func (b *PMBuilder) set(name string, is IndexSetting, vals []interface{}) *PMBuilder {
+	props := make([]Property, len(vals))
+	for i, v := range vals {
+		if err := props[i].SetValue(v, is); err != nil {
+			panic(err)
+		}
+	}
+	b.pm[name] = props
+	return b
+}
+
+// Build returns the PropertyMap. The builder may be used to build more
+// PropertyMaps; they don't share any properties.
+func (b *PMBuilder) Build() PropertyMap {
+	ret, _ := b.pm.Save(true)
+	return ret
+}
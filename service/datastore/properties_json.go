@@ -0,0 +1,202 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/tetrafolium/gae/service/blobstore"
+)
+
+// jsonProperty is the JSON form of a Property. See Property.MarshalJSON.
+type jsonProperty struct {
+	Type    string          `json:"type"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	NoIndex bool            `json:"noindex,omitempty"`
+}
+
+// jsonTypeNames are the names of each PropertyType in jsonProperty.Type.
+var jsonTypeNames = map[PropertyType]string{
+	PTNull:     "null",
+	PTInt:      "int",
+	PTTime:     "time",
+	PTBool:     "bool",
+	PTBytes:    "bytes",
+	PTString:   "string",
+	PTFloat:    "float",
+	PTGeoPoint: "geopoint",
+	PTKey:      "key",
+	PTBlobKey:  "blobkey",
+}
+
+var jsonTypes = func() map[string]PropertyType {
+	ret := make(map[string]PropertyType, len(jsonTypeNames))
+	for t, name := range jsonTypeNames {
+		ret[name] = t
+	}
+	return ret
+}()
+
+// MarshalJSON allows this Property to be marshaled by encoding/json. It's
+// encoded as an object like
+//   {"type": "int", "value": "12", "noindex": true}
+// where type is one of null, int, time, bool, bytes, string, float,
+// geopoint, key or blobkey, and noindex is omitted for indexed properties.
+//
+// The value is:
+//   - omitted, for null.
+//   - a decimal string, for int (since JSON numbers can't hold every int64).
+//   - an RFC 3339 string, for time.
+//   - a base64 (std encoding) string, for bytes.
+//   - an object with "Lat" and "Lng" numbers, for geopoint.
+//   - a urlsafe encoded key string (see Key.Encode), for key.
+//   - the JSON value of the same type, for anything else.
+func (p Property) MarshalJSON() ([]byte, error) {
+	jp := jsonProperty{NoIndex: p.IndexSetting() == NoIndex}
+	name, ok := jsonTypeNames[p.Type()]
+	if !ok {
+		return nil, fmt.Errorf("datastore: cannot marshal property of type %s", p.Type())
+	}
+	jp.Type = name
+
+	v := interface{}(nil)
+	switch x := p.Value().(type) {
+	case nil:
+	case int64:
+		v = strconv.FormatInt(x, 10)
+	case time.Time:
+		v = x.UTC().Format(time.RFC3339Nano)
+	case []byte:
+		v = base64.StdEncoding.EncodeToString(x)
+	case *Key:
+		v = x.Encode()
+	default:
+		v = x
+	}
+	if v != nil {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		jp.Value = data
+	}
+	return json.Marshal(&jp)
+}
+
+// UnmarshalJSON allows this Property to be unmarshaled by encoding/json, from
+// the form written by MarshalJSON.
+func (p *Property) UnmarshalJSON(buf []byte) error {
+	jp := jsonProperty{}
+	if err := json.Unmarshal(buf, &jp); err != nil {
+		return err
+	}
+	t, ok := jsonTypes[jp.Type]
+	if !ok {
+		return fmt.Errorf("datastore: bad JSON property type %q", jp.Type)
+	}
+	is := ShouldIndex
+	if jp.NoIndex {
+		is = NoIndex
+	}
+
+	decode := func(v interface{}) error {
+		if len(jp.Value) == 0 {
+			return fmt.Errorf("datastore: JSON %s property has no value", jp.Type)
+		}
+		return json.Unmarshal(jp.Value, v)
+	}
+	str := ""
+	val := interface{}(nil)
+	switch t {
+	case PTNull:
+	case PTInt, PTTime, PTBytes, PTKey:
+		if err := decode(&str); err != nil {
+			return err
+		}
+		switch t {
+		case PTInt:
+			i, err := strconv.ParseInt(str, 10, 64)
+			if err != nil {
+				return err
+			}
+			val = i
+		case PTTime:
+			ts, err := time.Parse(time.RFC3339Nano, str)
+			if err != nil {
+				return err
+			}
+			val = ts.UTC()
+		case PTBytes:
+			b, err := base64.StdEncoding.DecodeString(str)
+			if err != nil {
+				return err
+			}
+			val = b
+		case PTKey:
+			k, err := NewKeyEncoded(str)
+			if err != nil {
+				return err
+			}
+			val = k
+		}
+	case PTBool:
+		b := false
+		if err := decode(&b); err != nil {
+			return err
+		}
+		val = b
+	case PTString:
+		if err := decode(&str); err != nil {
+			return err
+		}
+		val = str
+	case PTFloat:
+		f := 0.0
+		if err := decode(&f); err != nil {
+			return err
+		}
+		val = f
+	case PTGeoPoint:
+		gp := GeoPoint{}
+		if err := decode(&gp); err != nil {
+			return err
+		}
+		val = gp
+	case PTBlobKey:
+		if err := decode(&str); err != nil {
+			return err
+		}
+		val = blobstore.Key(str)
+	}
+	return p.SetValue(val, is)
+}
+
+// MarshalJSON allows this PropertyMap to be marshaled by encoding/json, as an
+// object mapping each property name (including metadata, like "$key") to the
+// list of its values. See Property.MarshalJSON for how values are encoded.
+func (pm PropertyMap) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string][]Property(pm))
+}
+
+// UnmarshalJSON allows this PropertyMap to be unmarshaled by encoding/json,
+// from the form written by MarshalJSON. Properties in the JSON are added to
+// the map, replacing any existing values with the same names.
+func (pm *PropertyMap) UnmarshalJSON(buf []byte) error {
+	m := map[string][]Property{}
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return err
+	}
+	if *pm == nil {
+		*pm = make(PropertyMap, len(m))
+	}
+	for k, v := range m {
+		(*pm)[k] = v
+	}
+	return nil
+}
@@ -5,6 +5,7 @@
 package datastore
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"sort"
@@ -12,6 +13,7 @@ import (
 	"time"
 
 	"github.com/tetrafolium/gae/service/blobstore"
+	. "github.com/luci/luci-go/common/testing/assertions"
 	. "github.com/smartystreets/goconvey/convey"
 )
 
@@ -240,6 +242,84 @@ func TestDSPropertyMapImpl(t *testing.T) {
 			})
 		})
 	})
+
+	Convey("PropertyMap String", t, func() {
+		pm := PropertyMap{
+			"$key":  {MkPropertyNI(NewKey("aid", "ns", "Kind", "", 1, nil))},
+			"Bytes": {MkPropertyNI([]byte("hello"))},
+			"Multi": {MkProperty(1), MkProperty(2.5), MkProperty(nil)},
+			"Str":   {MkProperty("hi")},
+			"Time":  {MkProperty(time.Date(2015, 1, 2, 3, 4, 5, 6000, time.UTC))},
+			"Geo":   {MkProperty(GeoPoint{Lat: 1, Lng: 2})},
+		}
+
+		So(pm.String(), ShouldEqual, `{"$key": [PTKey(aid:ns:/Kind,1, NoIndex)], `+
+			`"Bytes": [PTBytes("hello", NoIndex)], `+
+			`"Geo": [PTGeoPoint(1,2)], `+
+			`"Multi": [PTInt(1), PTFloat(2.5), PTNull(nil)], `+
+			`"Str": [PTString("hi")], `+
+			`"Time": [PTTime(2015-01-02T03:04:05.000006Z)]}`)
+		So(fmt.Sprint(pm), ShouldEqual, pm.String())
+		So(fmt.Sprint(pm["Str"]), ShouldEqual, `[PTString("hi")]`)
+
+		So(fmt.Sprintf("%+v", PropertyMap{"A": {MkProperty(1)}, "B": {MkProperty(true)}}),
+			ShouldEqual, "{\n  \"A\": [PTInt(1)],\n  \"B\": [PTBool(true)],\n}")
+		So(fmt.Sprintf("%+v", PropertyMap{}), ShouldEqual, "{}")
+		So(fmt.Sprintf("%#v", PropertyMap{}), ShouldEqual, "map[string][]datastore.Property{}")
+	})
+
+	Convey("PropertyMap Clone/Diff/Merge", t, func() {
+		pm := PropertyMap{
+			"$key":  {MkPropertyNI(NewKey("aid", "", "Kind", "", 1, nil))},
+			"Bytes": {MkPropertyNI([]byte("hello"))},
+			"Multi": {MkProperty(1), MkProperty(2)},
+			"Str":   {MkProperty("hi")},
+		}
+
+		Convey("Clone", func() {
+			c := pm.Clone()
+			So(c, ShouldResemble, pm)
+			So(pm.Diff(c), ShouldBeNil)
+
+			c["Bytes"][0].Value().([]byte)[0] = 'j'
+			c["Multi"][1] = MkProperty(3)
+			c["New"] = []Property{MkProperty(true)}
+			So(pm["Bytes"][0].Value(), ShouldResemble, []byte("hello"))
+			So(pm["Multi"][1].Value(), ShouldEqual, 2)
+			So(pm, ShouldNotContainKey, "New")
+
+			So(PropertyMap(nil).Clone(), ShouldBeNil)
+		})
+
+		Convey("Diff", func() {
+			other := pm.Clone()
+			delete(other, "Str")
+			other["Multi"] = []Property{MkProperty(1), MkPropertyNI(2)}
+			other["Bytes"] = []Property{MkPropertyNI("hello")}
+			other["New"] = []Property{MkProperty(true)}
+
+			So(pm.Diff(other), ShouldResemble, []PropertyDiff{
+				{"Bytes", pm["Bytes"], other["Bytes"]},
+				{"Multi", pm["Multi"], other["Multi"]},
+				{"New", nil, other["New"]},
+				{"Str", pm["Str"], nil},
+			})
+		})
+
+		Convey("Merge", func() {
+			other := PropertyMap{
+				"Str": {MkProperty("replaced")},
+				"New": {MkPropertyNI([]byte("new"))},
+			}
+			pm.Merge(other)
+			So(pm["Str"], ShouldResemble, []Property{MkProperty("replaced")})
+			So(pm["New"], ShouldResemble, other["New"])
+			So(pm["Multi"], ShouldResemble, []Property{MkProperty(1), MkProperty(2)})
+
+			pm["New"][0].Value().([]byte)[0] = 'N'
+			So(other["New"][0].Value(), ShouldResemble, []byte("new"))
+		})
+	})
 }
 
 func TestByteSequences(t *testing.T) {
@@ -316,3 +396,122 @@ func TestByteSequences(t *testing.T) {
 		}
 	})
 }
+
+func TestPropertyJSON(t *testing.T) {
+	t.Parallel()
+
+	Convey("Property JSON encoding", t, func() {
+		k := MakeKey("dev~app", "ns", "Parent", 1, "Child", "a")
+		now := time.Date(2015, time.June, 1, 2, 3, 4, 5000, time.UTC)
+
+		Convey("round trips every type", func() {
+			pm := PropertyMap{
+				"$key":   {MkPropertyNI(k)},
+				"Null":   {MkProperty(nil)},
+				"Int":    {MkProperty(math.MaxInt64), MkPropertyNI(-1)},
+				"Time":   {MkProperty(now)},
+				"Bool":   {MkProperty(true)},
+				"Bytes":  {MkPropertyNI([]byte{0, 1, 255})},
+				"String": {MkProperty("hi")},
+				"Float":  {MkProperty(1.5)},
+				"Geo":    {MkProperty(GeoPoint{Lat: 1, Lng: -2})},
+				"Key":    {MkProperty(k)},
+				"Blob":   {MkProperty(blobstore.Key("bk"))},
+			}
+			data, err := json.Marshal(pm)
+			So(err, ShouldBeNil)
+
+			got := PropertyMap(nil)
+			So(json.Unmarshal(data, &got), ShouldBeNil)
+			So(got, ShouldResemble, pm)
+		})
+
+		Convey("is readable", func() {
+			data, err := json.Marshal(PropertyMap{
+				"Int":  {MkPropertyNI(12)},
+				"Null": {MkProperty(nil)},
+				"Time": {MkProperty(now)},
+				"Key":  {MkProperty(k)},
+			})
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, `{`+
+				`"Int":[{"type":"int","value":"12","noindex":true}],`+
+				`"Key":[{"type":"key","value":"`+k.Encode()+`"}],`+
+				`"Null":[{"type":"null"}],`+
+				`"Time":[{"type":"time","value":"2015-06-01T02:03:04.000005Z"}]}`)
+		})
+
+		Convey("rejects bad input", func() {
+			p := Property{}
+			So(json.Unmarshal([]byte(`{"type":"wat"}`), &p), ShouldErrLike, `bad JSON property type "wat"`)
+			So(json.Unmarshal([]byte(`{"type":"int"}`), &p), ShouldErrLike, "has no value")
+			So(json.Unmarshal([]byte(`{"type":"int","value":"x"}`), &p), ShouldErrLike, "invalid syntax")
+			So(json.Unmarshal([]byte(`{"type":"key","value":"x"}`), &p), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPropertyMapWithKeyContext(t *testing.T) {
+	t.Parallel()
+
+	Convey("PropertyMap.WithKeyContext", t, func() {
+		pm := PropertyMap{
+			"$key":  {MkPropertyNI(MakeKey("a", "n", "K", 1))},
+			"Refs":  {MkProperty(MakeKey("a", "n", "K", 2, "C", "x")), MkProperty("str")},
+			"Other": {MkProperty(10)},
+		}
+
+		npm := pm.WithKeyContext("b", "m")
+		So(npm, ShouldResemble, PropertyMap{
+			"$key":  {MkPropertyNI(MakeKey("b", "m", "K", 1))},
+			"Refs":  {MkProperty(MakeKey("b", "m", "K", 2, "C", "x")), MkProperty("str")},
+			"Other": {MkProperty(10)},
+		})
+
+		Convey("doesn't modify the original", func() {
+			So(pm["$key"][0].Value(), ShouldResemble, MakeKey("a", "n", "K", 1))
+			So(pm["Refs"][0].Value(), ShouldResemble, MakeKey("a", "n", "K", 2, "C", "x"))
+		})
+
+		Convey("nil", func() {
+			So(PropertyMap(nil).WithKeyContext("b", "m"), ShouldBeNil)
+		})
+	})
+}
+
+func TestPMBuilder(t *testing.T) {
+	t.Parallel()
+
+	Convey("PMBuilder", t, func() {
+		k := MakeKey("a", "n", "K", 1)
+		b := NewPM().Set("Name", "x").SetIndexed("Age", 3).Set("Tags", "a", "b").SetMeta("key", k)
+		pm := b.Build()
+		So(pm, ShouldResemble, PropertyMap{
+			"Name": {MkPropertyNI("x")},
+			"Age":  {MkProperty(3)},
+			"Tags": {MkPropertyNI("a"), MkPropertyNI("b")},
+			"$key": {MkPropertyNI(k)},
+		})
+
+		Convey("builds independent PropertyMaps", func() {
+			pm2 := b.Set("Name", "y").Build()
+			So(pm.GetString("Name", ""), ShouldEqual, "x")
+			So(pm2.GetString("Name", ""), ShouldEqual, "y")
+		})
+
+		Convey("panics on invalid values", func() {
+			So(func() { NewPM().Set("Bad", struct{}{}) }, ShouldPanic)
+		})
+
+		Convey("accessors", func() {
+			So(pm.GetString("Name", "def"), ShouldEqual, "x")
+			So(pm.GetString("Tags", "def"), ShouldEqual, "a")
+			So(pm.GetString("Age", "def"), ShouldEqual, "def")
+			So(pm.GetString("Missing", "def"), ShouldEqual, "def")
+
+			So(pm.GetInt64("Age", 7), ShouldEqual, 3)
+			So(pm.GetInt64("Name", 7), ShouldEqual, 7)
+			So(PropertyMap{"Empty": {}}.GetInt64("Empty", 7), ShouldEqual, 7)
+		})
+	})
+}
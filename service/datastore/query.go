@@ -7,6 +7,7 @@ package datastore
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"sort"
 	"strings"
 
@@ -24,8 +25,18 @@ var (
 	// there cannot possibly be any results.
 	ErrNullQuery = errors.New(
 		"the query is overconstrained and can never have results")
+
+	// ErrUnexpandedQuery is returned from Query.Finalize if the query has In or
+	// Ne filters. Such queries must be expanded with Query.Expand first (Run,
+	// RunMulti, GetAll and Count do this automatically).
+	ErrUnexpandedQuery = errors.New(
+		"queries with In or Ne filters must be expanded before they're finalized")
 )
 
+// MaxQueryExpansion is the maximum number of queries which Query.Expand will
+// expand a query's In and Ne filters into.
+var MaxQueryExpansion = 30
+
 // Query is a builder-object for building a datastore query. It may represent
 // an invalid query, but the error will only be observable when you call
 // Finalize.
@@ -39,10 +50,12 @@ type Query struct {
 	limit  *int32
 	offset *int32
 
-	order   []IndexColumn
-	project stringset.Set
+	order      []IndexColumn
+	project    stringset.Set
+	distinctOn stringset.Set
 
 	eqFilts map[string]PropertySlice
+	inFilts map[string]PropertySlice
 
 	ineqFiltProp     string
 	ineqFiltLow      Property
@@ -52,9 +65,17 @@ type Query struct {
 	ineqFiltHighIncl bool
 	ineqFiltHighSet  bool
 
+	// neVals are the values of ineqFiltProp excluded by Ne filters.
+	neVals PropertySlice
+
 	start Cursor
 	end   Cursor
 
+	// schemaType and schema are set by QueryFor, to validate the property names
+	// which the query uses.
+	schemaType reflect.Type
+	schema     *structCodec
+
 	// These are set by Finalize as a way to cache the 1-1 correspondence of
 	// a Query to its FinalizedQuery form. err may also be set by intermediate
 	// Query functions if there's a problem before finalization.
@@ -68,6 +89,23 @@ func NewQuery(kind string) *Query {
 	return &Query{kind: kind}
 }
 
+// QueryFor returns a new Query for the kind of src, which must be a non-nil
+// pointer to a struct (the kind is determined as for KeyForObj).
+//
+// Unlike NewQuery, the query validates the properties which it filters, sorts,
+// or projects on against the ones which src's struct type saves (including
+// aliases, and the dotted names of the properties of nested structs), so a typo
+// in a property name is an error instead of a query with no results. It's also
+// an error to use a property which isn't indexed.
+//
+// If the struct has an `extra` field, any property which the struct doesn't
+// define is allowed.
+func QueryFor(src interface{}) *Query {
+	kind := GetMetaDefault(getMGS(src), "kind", "").(string)
+	t := reflect.TypeOf(src).Elem()
+	return &Query{kind: kind, schemaType: t, schema: getCodec(t)}
+}
+
 func (q *Query) mod(cb func(*Query)) *Query {
 	if q.err != nil {
 		return q
@@ -82,6 +120,9 @@ func (q *Query) mod(cb func(*Query)) *Query {
 	if q.project != nil {
 		ret.project = q.project.Dup()
 	}
+	if q.distinctOn != nil {
+		ret.distinctOn = q.distinctOn.Dup()
+	}
 	if len(q.eqFilts) > 0 {
 		ret.eqFilts = make(map[string]PropertySlice, len(q.eqFilts))
 		for k, v := range q.eqFilts {
@@ -90,6 +131,12 @@ func (q *Query) mod(cb func(*Query)) *Query {
 			ret.eqFilts[k] = newV
 		}
 	}
+	if len(q.inFilts) > 0 {
+		ret.inFilts = make(map[string]PropertySlice, len(q.inFilts))
+		for k, v := range q.inFilts {
+			ret.inFilts[k] = v
+		}
+	}
 	cb(&ret)
 	return &ret
 }
@@ -174,7 +221,7 @@ func (q *Query) Order(fieldNames ...string) *Query {
 				q.err = err
 				return
 			}
-			if q.reserved(ic.Property) {
+			if ic.Property != ScatterProperty && q.reserved(ic.Property) {
 				return
 			}
 			q.order = append(q.order, ic)
@@ -182,6 +229,26 @@ func (q *Query) Order(fieldNames ...string) *Query {
 	})
 }
 
+// KeyRange restricts the query to the keys in r, with inequality filters on
+// __key__.
+func (q *Query) KeyRange(r KeyRange) *Query {
+	if r.Start != nil {
+		if r.StartExclusive {
+			q = q.Gt("__key__", r.Start)
+		} else {
+			q = q.Gte("__key__", r.Start)
+		}
+	}
+	if r.End != nil {
+		if r.EndInclusive {
+			q = q.Lte("__key__", r.End)
+		} else {
+			q = q.Lt("__key__", r.End)
+		}
+	}
+	return q
+}
+
 // ClearOrder removes all orders from this Query.
 func (q *Query) ClearOrder() *Query {
 	return q.mod(func(q *Query) {
@@ -219,6 +286,25 @@ func (q *Query) Distinct(on bool) *Query {
 	})
 }
 
+// DistinctOn makes a projection query only return the first result for each
+// distinct combination of values of the given fields, which must all be
+// projected. It can't be combined with Distinct. Calling it with no fields
+// removes the restriction.
+func (q *Query) DistinctOn(fieldNames ...string) *Query {
+	return q.mod(func(q *Query) {
+		q.distinctOn = nil
+		for _, f := range fieldNames {
+			if q.reserved(f) {
+				return
+			}
+			if q.distinctOn == nil {
+				q.distinctOn = stringset.New(len(fieldNames))
+			}
+			q.distinctOn.Add(f)
+		}
+	})
+}
+
 // ClearProject removes all projected fields from this Query.
 func (q *Query) ClearProject() *Query {
 	return q.mod(func(q *Query) {
@@ -242,6 +328,43 @@ func (q *Query) End(c Cursor) *Query {
 	})
 }
 
+// Reverse returns a query for the same results as this one, in the opposite
+// order. It flips all of the query's sort orders, including the implicit ones
+// (e.g. the inequality filter's, the projected fields' and the trailing
+// __key__ order), and swaps its Start and End cursors.
+//
+// This can be used to page backwards from a cursor. For example, to get the
+// page before the one which starts at cursor:
+//   q.Reverse().Start(cursor).Limit(pageSize)
+//
+// The results are then in reverse order. Whether a cursor can be used in the
+// reversed query depends on the particular 'impl' you have installed.
+func (q *Query) Reverse() *Query {
+	// The expanded queries all have the same orders, so any of them will do.
+	qs, err := q.Expand()
+	fq := (*FinalizedQuery)(nil)
+	for i := 0; err == nil && fq == nil && i < len(qs); i++ {
+		if fq, err = qs[i].Finalize(); err == ErrNullQuery {
+			fq, err = nil, nil
+		}
+	}
+	if err == ErrNullQuery || (err == nil && fq == nil) {
+		// there are no results to reverse.
+		return q
+	}
+	return q.mod(func(q *Query) {
+		if err != nil {
+			q.err = err
+			return
+		}
+		q.order = make([]IndexColumn, len(fq.orders))
+		for i, o := range fq.orders {
+			q.order[i] = IndexColumn{Property: o.Property, Descending: !o.Descending}
+		}
+		q.start, q.end = q.end, q.start
+	})
+}
+
 // Eq adds one or more equality restrictions to the query.
 //
 // Equality filters interact with multiply-defined properties by ensuring that
@@ -284,6 +407,164 @@ func (q *Query) Eq(field string, values ...interface{}) *Query {
 	})
 }
 
+// In adds a restriction to the query that the field must have at least one of
+// the given values.
+//
+// The datastore doesn't support this directly, so the query is expanded into
+// one query per value (see Expand), whose results are merged as with
+// RunMulti. Several In filters expand into a query for every combination of
+// their values.
+//
+// `In("thing", 1, 2).In("thing", 2, 3)` is equivalent to `In("thing", 2)`.
+func (q *Query) In(field string, values ...interface{}) *Query {
+	return q.mod(func(q *Query) {
+		if q.reserved(field) {
+			return
+		}
+		if len(values) == 0 {
+			q.err = fmt.Errorf("In filter on %q must have at least one value", field)
+			return
+		}
+
+		s := make(PropertySlice, 0, len(values))
+		for _, value := range values {
+			p := Property{}
+			if q.err = p.SetValue(value, ShouldIndex); q.err != nil {
+				return
+			}
+			if prev, ok := q.inFilts[field]; ok && !containsProperty(prev, &p) {
+				continue
+			}
+			if !containsProperty(s, &p) {
+				s = append(s, p)
+			}
+		}
+
+		if q.inFilts == nil {
+			q.inFilts = make(map[string]PropertySlice, 1)
+		}
+		q.inFilts[field] = s
+	})
+}
+
+// Ne imposes a 'not-equal' inequality restriction on the Query.
+//
+// The datastore doesn't support this directly, so like the original SDK, the
+// query is expanded into a `< value` and a `> value` query (see Expand), whose
+// results are merged as with RunMulti. Since it's an inequality filter, it
+// can't be combined with inequality filters on other properties, and results
+// are sorted by the property first.
+func (q *Query) Ne(field string, value interface{}) *Query {
+	p := Property{}
+	err := p.SetValue(value, ShouldIndex)
+
+	return q.mod(func(q *Query) {
+		if q.err = err; err != nil {
+			return
+		}
+		if q.ineqOK(field, p) {
+			q.ineqFiltProp = field
+			if !containsProperty(q.neVals, &p) {
+				q.neVals = append(append(PropertySlice(nil), q.neVals...), p)
+			}
+		}
+	})
+}
+
+func containsProperty(s PropertySlice, p *Property) bool {
+	for i := range s {
+		if s[i].Equal(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expand returns the queries which this query's In and Ne filters expand to:
+// one for every combination of the In filters' values (with equality filters
+// instead), and of the ranges between the Ne filters' values. If the query has
+// neither, it returns just the query itself.
+//
+// It returns an error if the query has more than MaxQueryExpansion
+// combinations, or ErrNullQuery if it has none.
+func (q *Query) Expand() ([]*Query, error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	if len(q.inFilts) == 0 && len(q.neVals) == 0 {
+		return []*Query{q}, nil
+	}
+
+	fields := make([]string, 0, len(q.inFilts))
+	count := 1
+	for field, vals := range q.inFilts {
+		fields = append(fields, field)
+		count *= len(vals)
+		if count > MaxQueryExpansion {
+			return nil, fmt.Errorf(
+				"In and Ne filters expand to more than MaxQueryExpansion (%d) queries", MaxQueryExpansion)
+		}
+	}
+	if len(q.neVals) > 0 {
+		count *= len(q.neVals) + 1
+	}
+	if count > MaxQueryExpansion {
+		return nil, fmt.Errorf(
+			"In and Ne filters expand to more than MaxQueryExpansion (%d) queries", MaxQueryExpansion)
+	}
+	if count == 0 {
+		return nil, ErrNullQuery
+	}
+	sort.Strings(fields)
+
+	base := q.mod(func(q *Query) {
+		q.inFilts = nil
+		q.neVals = nil
+	})
+	ret := []*Query{base}
+	for _, field := range fields {
+		next := make([]*Query, 0, len(ret)*len(q.inFilts[field]))
+		for _, eq := range ret {
+			for _, v := range q.inFilts[field] {
+				next = append(next, eq.Eq(field, v.Value()))
+			}
+		}
+		ret = next
+	}
+
+	if len(q.neVals) > 0 {
+		vals := append(PropertySlice(nil), q.neVals...)
+		sort.Sort(vals)
+
+		// Split each query into the ranges around the excluded values, skipping
+		// the ranges which its other inequality filters rule out.
+		next := make([]*Query, 0, len(ret)*(len(vals)+1))
+		for _, sub := range ret {
+			for i := 0; i <= len(vals); i++ {
+				rng := sub
+				if i > 0 {
+					rng = rng.Gt(q.ineqFiltProp, vals[i-1].Value())
+				}
+				if i < len(vals) {
+					rng = rng.Lt(q.ineqFiltProp, vals[i].Value())
+				}
+				if _, err := rng.Finalize(); err == ErrNullQuery {
+					continue
+				}
+				next = append(next, rng)
+			}
+		}
+		if len(next) == 0 {
+			return nil, ErrNullQuery
+		}
+		ret = next
+	}
+	return ret, nil
+}
+
+// reserved returns true (and sets q.err) if field can't be used to filter,
+// sort, or project the query. If the query was made with QueryFor, this
+// includes any field which the query's struct type doesn't index.
 func (q *Query) reserved(field string) bool {
 	if field == "__key__" {
 		return false
@@ -298,6 +579,20 @@ func (q *Query) reserved(field string) bool {
 			"cannot filter/project on reserved property: %q", field)
 		return true
 	}
+	if q.schema != nil {
+		is, ok := q.schema.propertyIndexSetting(q.schemaType, field)
+		_, hasExtra := q.schema.bySpecial["extra"]
+		switch {
+		case !ok && !hasExtra:
+			q.err = fmt.Errorf(
+				"cannot filter/project on %q: %s has no such property", field, q.schemaType)
+			return true
+		case ok && is == NoIndex:
+			q.err = fmt.Errorf(
+				"cannot filter/project on %q: it isn't indexed in %s", field, q.schemaType)
+			return true
+		}
+	}
 	return false
 }
 
@@ -461,6 +756,7 @@ func (q *Query) ClearFilters() *Query {
 		}
 		q.ineqFiltLowSet = false
 		q.ineqFiltHighSet = false
+		q.neVals = nil
 	})
 }
 
@@ -471,6 +767,9 @@ func (q *Query) Finalize() (*FinalizedQuery, error) {
 	if q.err != nil || q.finalized != nil {
 		return q.finalized, q.err
 	}
+	if len(q.inFilts) > 0 || len(q.neVals) > 0 {
+		return nil, ErrUnexpandedQuery
+	}
 
 	ancestor := (*Key)(nil)
 	if slice, ok := q.eqFilts["__ancestor__"]; ok {
@@ -502,6 +801,22 @@ func (q *Query) Finalize() (*FinalizedQuery, error) {
 			return errors.New("cannot project a keysOnly query")
 		}
 
+		if q.distinctOn != nil {
+			if q.distinct {
+				return errors.New("cannot use both Distinct and DistinctOn")
+			}
+			err := error(nil)
+			q.distinctOn.Iter(func(f string) bool {
+				if q.project == nil || !q.project.Has(f) {
+					err = fmt.Errorf("DistinctOn field must be projected: %q", f)
+				}
+				return err == nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+
 		if q.ineqFiltProp != "" {
 			if len(q.order) > 0 && q.order[0].Property != q.ineqFiltProp {
 				return fmt.Errorf(
@@ -574,6 +889,16 @@ func (q *Query) Finalize() (*FinalizedQuery, error) {
 		ret.project = q.project.ToSlice()
 		ret.distinct = q.distinct && q.project.Len() > 0
 
+		// DISTINCT ON all of the projected fields is just DISTINCT.
+		if q.distinctOn != nil {
+			if q.distinctOn.Len() == q.project.Len() {
+				ret.distinct = true
+			} else {
+				ret.distinctOn = q.distinctOn.ToSlice()
+				sort.Strings(ret.distinctOn)
+			}
+		}
+
 		// If we're DISTINCT && have an inequality filter, we must project that
 		// inequality property as well.
 		if ret.distinct && ret.ineqFiltProp != "" && !q.project.Has(ret.ineqFiltProp) {
@@ -677,6 +1002,13 @@ func (q *Query) String() string {
 			p("Filter(%q == %s)", prop, v.GQL())
 		}
 	}
+	for prop, vals := range q.inFilts {
+		gql := make([]string, len(vals))
+		for i, v := range vals {
+			gql[i] = v.GQL()
+		}
+		p("Filter(%q IN [%s])", prop, strings.Join(gql, ", "))
+	}
 	if q.ineqFiltProp != "" {
 		if q.ineqFiltLowSet {
 			op := ">"
@@ -692,6 +1024,9 @@ func (q *Query) String() string {
 			}
 			p("Filter(%q %s %s)", q.ineqFiltProp, op, q.ineqFiltHigh.GQL())
 		}
+		for _, v := range q.neVals {
+			p("Filter(%q != %s)", q.ineqFiltProp, v.GQL())
+		}
 	}
 
 	// Order
@@ -711,6 +1046,9 @@ func (q *Query) String() string {
 		}
 		p(f, strings.Join(q.project.ToSlice(), ", "))
 	}
+	if q.distinctOn != nil && q.distinctOn.Len() > 0 {
+		p("DistinctOn(%s)", strings.Join(q.distinctOn.ToSlice(), ", "))
+	}
 
 	// Cursors
 	if q.start != nil {
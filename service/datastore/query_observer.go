@@ -0,0 +1,95 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+// QueryObservation describes a query which RawInterface.Run or
+// RawInterface.Count has finished executing.
+type QueryObservation struct {
+	// Query is the query which was executed. Queries with In or Ne filters are
+	// observed once for each of the queries they expand to.
+	Query *FinalizedQuery
+
+	// Count is true if the query was executed by Count rather than Run.
+	Count bool
+
+	// Duration is how long the query took, including the time spent in the
+	// callbacks of Run.
+	Duration time.Duration
+
+	// Rows is the number of results which Run returned to its callback, or the
+	// result of Count.
+	Rows int64
+
+	// Err is the error which the query returned, if any (e.g.
+	// ErrMissingIndex).
+	Err error
+}
+
+// QueryObserver is a function which is called each time a query finishes
+// executing. See AddQueryObservers.
+type QueryObserver func(c context.Context, o *QueryObservation)
+
+func getQueryObservers(c context.Context) []QueryObserver {
+	if obs, ok := c.Value(queryObserverKey).([]QueryObserver); ok {
+		return obs
+	}
+	return nil
+}
+
+// AddQueryObservers adds QueryObservers to the context. They're called, in
+// the order they were added, after every query which the RawInterface from the
+// context runs (e.g. to log slow queries, or ones which lack an index).
+func AddQueryObservers(c context.Context, obs ...QueryObserver) context.Context {
+	if len(obs) == 0 {
+		return c
+	}
+	cur := getQueryObservers(c)
+	newObs := make([]QueryObserver, 0, len(cur)+len(obs))
+	newObs = append(newObs, cur...)
+	newObs = append(newObs, obs...)
+	return context.WithValue(c, queryObserverKey, newObs)
+}
+
+// queryObserverFilter calls the QueryObservers in c for each query which it
+// runs.
+type queryObserverFilter struct {
+	RawInterface
+
+	c   context.Context
+	obs []QueryObserver
+}
+
+func (f *queryObserverFilter) observe(o *QueryObservation, start time.Time) {
+	o.Duration = clock.Now(f.c).Sub(start)
+	for _, ob := range f.obs {
+		ob(f.c, o)
+	}
+}
+
+func (f *queryObserverFilter) Run(fq *FinalizedQuery, cb RawRunCB) error {
+	o := &QueryObservation{Query: fq}
+	start := clock.Now(f.c)
+	o.Err = f.RawInterface.Run(fq, func(k *Key, pm PropertyMap, gc CursorCB) error {
+		o.Rows++
+		return cb(k, pm, gc)
+	})
+	f.observe(o, start)
+	return o.Err
+}
+
+func (f *queryObserverFilter) Count(fq *FinalizedQuery) (int64, error) {
+	o := &QueryObservation{Query: fq, Count: true}
+	start := clock.Now(f.c)
+	o.Rows, o.Err = f.RawInterface.Count(fq)
+	f.observe(o, start)
+	return o.Rows, o.Err
+}
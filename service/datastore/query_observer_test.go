@@ -0,0 +1,87 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tetrafolium/gae/service/info"
+	"github.com/luci/luci-go/common/clock/testclock"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+// countingDatastore is a fakeDatastore which also implements Count.
+type countingDatastore struct {
+	fakeDatastore
+}
+
+func (f *countingDatastore) Count(fq *FinalizedQuery) (int64, error) {
+	if fq.Kind() == "Fail" {
+		return 0, errors.New("Count fail")
+	}
+	return 42, nil
+}
+
+func TestQueryObservers(t *testing.T) {
+	t.Parallel()
+
+	Convey("Query observers", t, func() {
+		c, clk := testclock.UseTime(context.Background(), time.Date(2000, time.January, 1, 1, 1, 1, 0, time.UTC))
+		c = info.Set(c, fakeInfo{})
+		c = SetRawFactory(c, func(c context.Context, wantTxn bool) RawInterface {
+			return &countingDatastore{*fakeDatastoreFactory(c, wantTxn).(*fakeDatastore)}
+		})
+
+		obs := []QueryObservation{}
+		record := func(c context.Context, o *QueryObservation) {
+			obs = append(obs, *o)
+		}
+		c = AddQueryObservers(c, record, record)
+		ds := Get(c)
+
+		Convey("observe Run", func() {
+			q := NewQuery("Kind").Limit(3)
+			So(ds.Run(q, func(*Key) {
+				clk.Add(time.Second)
+			}), ShouldBeNil)
+
+			So(len(obs), ShouldEqual, 2)
+			So(obs[0], ShouldResemble, obs[1])
+			So(obs[0].Query.Kind(), ShouldEqual, "Kind")
+			So(obs[0].Query.KeysOnly(), ShouldBeTrue)
+			So(obs[0].Count, ShouldBeFalse)
+			So(obs[0].Rows, ShouldEqual, 3)
+			So(obs[0].Duration, ShouldEqual, 3*time.Second)
+			So(obs[0].Err, ShouldBeNil)
+		})
+
+		Convey("observe errors", func() {
+			q := NewQuery("Kind").Limit(3).Eq("$err_single", "bad").Eq("$err_single_idx", 1)
+			So(ds.Run(q, func(*Key) {}), ShouldErrLike, "bad")
+			So(obs[0].Rows, ShouldEqual, 1)
+			So(obs[0].Err, ShouldErrLike, "bad")
+		})
+
+		Convey("observe Count", func() {
+			_, err := ds.Count(NewQuery("Fail"))
+			So(err, ShouldErrLike, "Count fail")
+			So(obs[0].Count, ShouldBeTrue)
+			So(obs[0].Err, ShouldErrLike, "Count fail")
+
+			n, err := ds.Count(NewQuery("Kind"))
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 42)
+			So(obs[2].Rows, ShouldEqual, 42)
+		})
+
+		Convey("adding zero observers does nothing", func() {
+			So(AddQueryObservers(c), ShouldEqual, c)
+		})
+	})
+}
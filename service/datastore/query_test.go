@@ -105,6 +105,16 @@ var queryTests = []queryTest{
 		"SELECT * FROM `Foo` ORDER BY `__key__`",
 		nil, nq()},
 
+	{"In filters must be expanded",
+		nq().In("a", 1, 2),
+		"",
+		"must be expanded", nil},
+
+	{"In filters need values",
+		nq().In("a"),
+		"",
+		"must have at least one value", nil},
+
 	{"projecting a keys-only query",
 		nq().Project("hello").KeysOnly(true),
 		"",
@@ -150,6 +160,26 @@ var queryTests = []queryTest{
 		"SELECT DISTINCT `bar`, `foo` FROM `Foo` WHERE `foo` >= 10 ORDER BY `foo`, `bar`, `__key__`",
 		nil, nil},
 
+	{"project distinct on",
+		nq().Project("a", "b").DistinctOn("a"),
+		"SELECT DISTINCT ON (`a`) `a`, `b` FROM `Foo` ORDER BY `a`, `b`, `__key__`",
+		nil, nil},
+
+	{"distinct on all projected fields is distinct",
+		nq().Project("a", "b").DistinctOn("b", "a"),
+		"SELECT DISTINCT `a`, `b` FROM `Foo` ORDER BY `a`, `b`, `__key__`",
+		nil, nq().Project("a", "b").Distinct(true)},
+
+	{"distinct on an unprojected field",
+		nq().Project("a").DistinctOn("b"),
+		"",
+		"DistinctOn field must be projected", nil},
+
+	{"distinct and distinct on",
+		nq().Project("a", "b").Distinct(true).DistinctOn("a"),
+		"",
+		"cannot use both Distinct and DistinctOn", nil},
+
 	{"bad ancestors",
 		nq().Ancestor(mkKey("goop", 0)),
 		"",
@@ -340,3 +370,255 @@ func TestQueries(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryExpand(t *testing.T) {
+	t.Parallel()
+
+	Convey("Query.Expand", t, func() {
+		gqls := func(q *Query) []string {
+			qs, err := q.Expand()
+			So(err, ShouldBeNil)
+			ret := make([]string, len(qs))
+			for i, q := range qs {
+				fq, err := q.Finalize()
+				So(err, ShouldBeNil)
+				ret[i] = fq.GQL()
+			}
+			return ret
+		}
+
+		Convey("returns queries without In filters as-is", func() {
+			q := nq().Eq("a", 1)
+			qs, err := q.Expand()
+			So(err, ShouldBeNil)
+			So(qs, ShouldResemble, []*Query{q})
+		})
+
+		Convey("expands every combination of values", func() {
+			So(gqls(nq().In("b", "x", "y").In("a", 1, 2, 1)), ShouldResemble, []string{
+				"SELECT * FROM `Foo` WHERE `a` = 1 AND `b` = \"x\" ORDER BY `__key__`",
+				"SELECT * FROM `Foo` WHERE `a` = 1 AND `b` = \"y\" ORDER BY `__key__`",
+				"SELECT * FROM `Foo` WHERE `a` = 2 AND `b` = \"x\" ORDER BY `__key__`",
+				"SELECT * FROM `Foo` WHERE `a` = 2 AND `b` = \"y\" ORDER BY `__key__`",
+			})
+		})
+
+		Convey("intersects repeated In filters", func() {
+			So(gqls(nq().In("a", 1, 2).In("a", 2, 3)), ShouldResemble, []string{
+				"SELECT * FROM `Foo` WHERE `a` = 2 ORDER BY `__key__`",
+			})
+
+			_, err := nq().In("a", 1).In("a", 2).Expand()
+			So(err, ShouldEqual, ErrNullQuery)
+		})
+
+		Convey("caps the number of queries", func() {
+			vals := make([]interface{}, 6)
+			for i := range vals {
+				vals[i] = i
+			}
+			_, err := nq().In("a", vals...).In("b", vals...).Expand()
+			So(err, ShouldErrLike, "expand to more than MaxQueryExpansion (30) queries")
+		})
+
+		Convey("splits Ne filters into ranges", func() {
+			So(gqls(nq().Ne("a", 5).Ne("a", 2)), ShouldResemble, []string{
+				"SELECT * FROM `Foo` WHERE `a` < 2 ORDER BY `a`, `__key__`",
+				"SELECT * FROM `Foo` WHERE `a` > 2 AND `a` < 5 ORDER BY `a`, `__key__`",
+				"SELECT * FROM `Foo` WHERE `a` > 5 ORDER BY `a`, `__key__`",
+			})
+
+			Convey("within the other inequality filters", func() {
+				So(gqls(nq().Gte("a", 5).Ne("a", 5).In("b", 1, 2)), ShouldResemble, []string{
+					"SELECT * FROM `Foo` WHERE `b` = 1 AND `a` > 5 ORDER BY `a`, `__key__`",
+					"SELECT * FROM `Foo` WHERE `b` = 2 AND `a` > 5 ORDER BY `a`, `__key__`",
+				})
+
+				_, err := nq().Gte("a", 5).Lte("a", 5).Ne("a", 5).Expand()
+				So(err, ShouldEqual, ErrNullQuery)
+			})
+
+			Convey("and they count as inequality filters", func() {
+				_, err := nq().Ne("a", 5).Gt("b", 1).Expand()
+				So(err, ShouldEqual, ErrMultipleInequalityFilter)
+			})
+		})
+
+		Convey("shows In and Ne filters in String", func() {
+			So(nq().In("a", 1, 2).String(), ShouldEqual, `Query(Kind="Foo", Filter("a" IN [1, 2]))`)
+			So(nq().Ne("a", 1).String(), ShouldEqual, `Query(Kind="Foo", Filter("a" != 1))`)
+		})
+	})
+}
+
+func TestQueryReverse(t *testing.T) {
+	t.Parallel()
+
+	Convey("Query.Reverse", t, func() {
+		gql := func(q *Query) string {
+			fq, err := q.Finalize()
+			So(err, ShouldBeNil)
+			return fq.GQL()
+		}
+
+		Convey("flips the implicit orders", func() {
+			So(gql(nq().Reverse()), ShouldEqual,
+				"SELECT * FROM `Foo` ORDER BY `__key__` DESC")
+			So(gql(nq().Gt("a", 1).Reverse()), ShouldEqual,
+				"SELECT * FROM `Foo` WHERE `a` > 1 ORDER BY `a` DESC, `__key__` DESC")
+			So(gql(nq().Eq("b", 2).Order("b", "-c").Project("d").Reverse()), ShouldEqual,
+				"SELECT `d` FROM `Foo` WHERE `b` = 2 ORDER BY `c`, `d` DESC, `__key__` DESC")
+		})
+
+		Convey("flips the orders of expanded queries", func() {
+			qs, err := nq().In("a", 1, 2).Order("b").Reverse().Expand()
+			So(err, ShouldBeNil)
+			So(gql(qs[0]), ShouldEqual,
+				"SELECT * FROM `Foo` WHERE `a` = 1 ORDER BY `b` DESC, `__key__` DESC")
+		})
+
+		Convey("undoes itself", func() {
+			q := nq().Gt("a", 1).Order("a", "-b")
+			So(gql(q.Reverse().Reverse()), ShouldEqual, gql(q))
+		})
+
+		Convey("swaps the cursors", func() {
+			fq, err := nq().Start(fakeCursor("start")).End(fakeCursor("end")).Reverse().Finalize()
+			So(err, ShouldBeNil)
+			start, end := fq.Bounds()
+			So(start, ShouldEqual, fakeCursor("end"))
+			So(end, ShouldEqual, fakeCursor("start"))
+		})
+
+		Convey("leaves queries without results alone", func() {
+			q := nq().Gt("a", 2).Lt("a", 1)
+			So(q.Reverse(), ShouldEqual, q)
+		})
+
+		Convey("reports invalid queries", func() {
+			_, err := NewQuery("").Order("a").Reverse().Finalize()
+			So(err, ShouldErrLike, "invalid order for kindless query")
+		})
+	})
+}
+
+type queryForInner struct {
+	Name  string
+	Notes string `gae:",noindex"`
+}
+
+type queryForStruct struct {
+	ID int64 `gae:"$id"`
+
+	Value int64  `gae:",alias=OldValue"`
+	Blob  []byte `gae:",noindex"`
+	Tags  []string
+	Inner queryForInner
+	Lists []queryForInner `gae:"List"`
+	Doc   unindexedJSON
+}
+
+// unindexedJSON is a PropertyConverter which saves an unindexed string.
+type unindexedJSON struct{ data string }
+
+func (j *unindexedJSON) ToProperty() (Property, error) { return MkPropertyNI(j.data), nil }
+
+func (j *unindexedJSON) FromProperty(p Property) error {
+	j.data, _ = p.Value().(string)
+	return nil
+}
+
+type queryForExtra struct {
+	Value int64
+	Extra PropertyMap `gae:",extra"`
+}
+
+func TestQueryFor(t *testing.T) {
+	t.Parallel()
+
+	Convey("QueryFor", t, func() {
+		check := func(q *Query) error {
+			_, err := q.Finalize()
+			return err
+		}
+		qf := func() *Query { return QueryFor(&queryForStruct{}) }
+
+		Convey("uses the struct's kind", func() {
+			fq, err := qf().Finalize()
+			So(err, ShouldBeNil)
+			So(fq.Kind(), ShouldEqual, "queryForStruct")
+		})
+
+		Convey("allows the struct's indexed properties", func() {
+			So(check(qf().Eq("Value", 1).Order("Tags")), ShouldBeNil)
+			So(check(qf().Eq("OldValue", 1)), ShouldBeNil)
+			So(check(qf().Eq("Inner.Name", "a").Order("-List.Name")), ShouldBeNil)
+			So(check(qf().Gt("__key__", MakeKey("dev~app", "", "queryForStruct", 1))), ShouldBeNil)
+			So(check(qf().Project("Value").DistinctOn("Value")), ShouldBeNil)
+		})
+
+		Convey("rejects typos", func() {
+			So(check(qf().Eq("Valeu", 1)), ShouldErrLike,
+				`cannot filter/project on "Valeu": datastore.queryForStruct has no such property`)
+			So(check(qf().Lt("Inner.Nmae", "a")), ShouldErrLike, "no such property")
+			So(check(qf().Order("Inner")), ShouldErrLike, "no such property")
+			So(check(qf().Project("ID")), ShouldErrLike, "no such property")
+		})
+
+		Convey("rejects unindexed properties", func() {
+			So(check(qf().Order("Blob")), ShouldErrLike,
+				`cannot filter/project on "Blob": it isn't indexed in datastore.queryForStruct`)
+			So(check(qf().Eq("List.Notes", "a")), ShouldErrLike, "isn't indexed")
+			So(check(qf().In("Doc", "a", "b")), ShouldErrLike, "isn't indexed")
+		})
+
+		Convey("allows anything with an extra field", func() {
+			So(check(QueryFor(&queryForExtra{}).Eq("Anything", 1)), ShouldBeNil)
+		})
+	})
+}
+
+func TestKeyRange(t *testing.T) {
+	t.Parallel()
+
+	Convey("KeyRange", t, func() {
+		mk := func(id int64) *Key { return MakeKey("dev~app", "", "Foo", id) }
+
+		Convey("Contains", func() {
+			r := KeyRange{Start: mk(2), End: mk(4)}
+			So(r.Contains(mk(1)), ShouldBeFalse)
+			So(r.Contains(mk(2)), ShouldBeTrue)
+			So(r.Contains(mk(3)), ShouldBeTrue)
+			So(r.Contains(mk(4)), ShouldBeFalse)
+
+			r = KeyRange{Start: mk(2), StartExclusive: true, End: mk(4), EndInclusive: true}
+			So(r.Contains(mk(2)), ShouldBeFalse)
+			So(r.Contains(mk(4)), ShouldBeTrue)
+
+			So(KeyRange{}.Contains(mk(100)), ShouldBeTrue)
+			So(KeyRange{End: mk(2)}.Contains(mk(1)), ShouldBeTrue)
+			So(KeyRange{Start: mk(2)}.Contains(mk(1)), ShouldBeFalse)
+		})
+
+		Convey("Query.KeyRange", func() {
+			gql := func(r KeyRange) string {
+				fq, err := NewQuery("Foo").KeyRange(r).Finalize()
+				So(err, ShouldBeNil)
+				return fq.GQL()
+			}
+			So(gql(KeyRange{}), ShouldEqual, "SELECT * FROM `Foo` ORDER BY `__key__`")
+			So(gql(KeyRange{Start: mk(1), End: mk(2)}), ShouldEqual,
+				"SELECT * FROM `Foo` WHERE `__key__` >= KEY(DATASET(\"dev~app\"), \"Foo\", 1) AND "+
+					"`__key__` < KEY(DATASET(\"dev~app\"), \"Foo\", 2) ORDER BY `__key__`")
+			So(gql(KeyRange{Start: mk(1), StartExclusive: true, End: mk(2), EndInclusive: true}), ShouldEqual,
+				"SELECT * FROM `Foo` WHERE `__key__` > KEY(DATASET(\"dev~app\"), \"Foo\", 1) AND "+
+					"`__key__` <= KEY(DATASET(\"dev~app\"), \"Foo\", 2) ORDER BY `__key__`")
+		})
+
+		Convey("may be ordered by __scatter__", func() {
+			fq, err := NewQuery("Foo").Order(ScatterProperty).KeysOnly(true).Finalize()
+			So(err, ShouldBeNil)
+			So(fq.Orders()[0].Property, ShouldEqual, ScatterProperty)
+		})
+	})
+}
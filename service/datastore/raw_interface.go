@@ -58,6 +58,23 @@ func (nullMetaGetterType) GetMeta(string) (interface{}, bool) { return nil, fals
 
 var nullMetaGetter MetaGetter = nullMetaGetterType{}
 
+// KeysOnlyMeta is the metadata key which is set to true for the keys that
+// ExistsMulti passes to RawInterface.GetMulti. It indicates that the caller
+// only needs to know whether each entity exists, so filters may call back with
+// an empty PropertyMap instead of the entity's data.
+const KeysOnlyMeta = "keysOnly"
+
+type keysOnlyMetaGetterType struct{}
+
+func (keysOnlyMetaGetterType) GetMeta(key string) (interface{}, bool) {
+	if key == KeysOnlyMeta {
+		return true, true
+	}
+	return nil, false
+}
+
+var keysOnlyMetaGetter MetaGetter = keysOnlyMetaGetterType{}
+
 // MultiMetaGetter is a carrier for metadata, used with RawInterface.GetMulti
 //
 // It's OK to default-construct this. GetMeta will just return
@@ -0,0 +1,199 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/luci/luci-go/common/stringset"
+)
+
+// ErrRunMultiCursor is returned by the CursorCB passed to a RunMulti callback,
+// since there's no single cursor which can resume several merged queries.
+var ErrRunMultiCursor = errors.New("datastore: RunMulti does not support cursors")
+
+// multiRunItem is a single result of one of the queries run by runMulti.
+type multiRunItem struct {
+	key *Key
+	pm  PropertyMap
+
+	// sortKey holds the value of each of the queries' order columns, for
+	// merging.
+	sortKey []Property
+}
+
+// multiRunStream carries the results of one of the queries run by runMulti.
+type multiRunStream struct {
+	items chan *multiRunItem
+
+	// err is the query's error. It's set before items is closed.
+	err error
+}
+
+// runMulti runs fqs concurrently with raw, and calls cb with their results
+// merged in order of their (shared) sort orders, skipping keys which have
+// already been returned.
+func runMulti(raw RawInterface, fqs []*FinalizedQuery, cb RawRunCB) error {
+	if len(fqs) == 0 {
+		return nil
+	}
+
+	orders := fqs[0].Orders()
+	limit, limited := int32(-1), true
+	for i, fq := range fqs {
+		if len(fq.Project()) > 0 {
+			return fmt.Errorf("RunMulti: query %d is a projection query, which is not supported", i)
+		}
+		if _, ok := fq.Offset(); ok {
+			return fmt.Errorf("RunMulti: query %d has an offset, which is not supported", i)
+		}
+		if !sameOrders(orders, fq.Orders()) {
+			return fmt.Errorf("RunMulti: query %d has orders %v, but query 0 has %v", i, fq.Orders(), orders)
+		}
+
+		if l, ok := fq.Limit(); !ok {
+			limited = false
+		} else if l > limit {
+			limit = l
+		}
+	}
+	// The merged results can only be limited if every query is.
+	if !limited {
+		limit = -1
+	}
+
+	// Keys-only queries don't return the values we need to merge by, so run
+	// them as full queries unless they're only ordered by __key__.
+	for i, fq := range fqs {
+		if fq.KeysOnly() && len(orders) > 1 {
+			var err error
+			if fqs[i], err = fq.Original().KeysOnly(false).Finalize(); err != nil {
+				return err
+			}
+		}
+	}
+
+	stop := make(chan struct{})
+	wg := sync.WaitGroup{}
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	streams := make([]*multiRunStream, len(fqs))
+	for i, fq := range fqs {
+		s := &multiRunStream{items: make(chan *multiRunItem)}
+		streams[i] = s
+
+		wg.Add(1)
+		go func(fq *FinalizedQuery) {
+			defer wg.Done()
+			defer close(s.items)
+			s.err = raw.Run(fq, func(k *Key, pm PropertyMap, _ CursorCB) error {
+				itm := &multiRunItem{k, pm, multiRunSortKey(orders, k, pm)}
+				select {
+				case s.items <- itm:
+					return nil
+				case <-stop:
+					return Stop
+				}
+			})
+		}(fq)
+	}
+
+	heads := make([]*multiRunItem, len(streams))
+	advance := func(i int) error {
+		heads[i] = <-streams[i].items
+		if heads[i] == nil {
+			return streams[i].err
+		}
+		return nil
+	}
+	for i := range streams {
+		if err := advance(i); err != nil {
+			return err
+		}
+	}
+
+	noCursor := func() (Cursor, error) { return nil, ErrRunMultiCursor }
+	seen := stringset.New(0)
+	for limit != 0 {
+		best := -1
+		for i, h := range heads {
+			if h != nil && (best < 0 || compareSortKeys(orders, h.sortKey, heads[best].sortKey) < 0) {
+				best = i
+			}
+		}
+		if best < 0 {
+			return nil
+		}
+
+		itm := heads[best]
+		if err := advance(best); err != nil {
+			return err
+		}
+		if !seen.Add(itm.key.String()) {
+			continue
+		}
+
+		if err := cb(itm.key, itm.pm, noCursor); err != nil {
+			if err == Stop {
+				return nil
+			}
+			return err
+		}
+		if limit > 0 {
+			limit--
+		}
+	}
+	return nil
+}
+
+func sameOrders(a, b []IndexColumn) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// multiRunSortKey returns the values which the entity sorts by for each of
+// orders. Like the datastore, it sorts a multi-valued property by its smallest
+// value in ascending orders, and by its largest value in descending ones.
+func multiRunSortKey(orders []IndexColumn, k *Key, pm PropertyMap) []Property {
+	ret := make([]Property, len(orders))
+	for i, o := range orders {
+		if o.Property == "__key__" {
+			ret[i] = MkProperty(k)
+			continue
+		}
+		for j, v := range pm[o.Property] {
+			// Ignore the index setting, which would otherwise affect Compare.
+			p := MkProperty(v.Value())
+			if j == 0 || (p.Less(&ret[i]) != o.Descending) {
+				ret[i] = p
+			}
+		}
+	}
+	return ret
+}
+
+func compareSortKeys(orders []IndexColumn, a, b []Property) int {
+	for i, o := range orders {
+		if cmp := a[i].Compare(&b[i]); cmp != 0 {
+			if o.Descending {
+				return -cmp
+			}
+			return cmp
+		}
+	}
+	return 0
+}
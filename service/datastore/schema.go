@@ -0,0 +1,266 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PropertySchema describes a single (possibly flattened) property of a kind.
+type PropertySchema struct {
+	// Name is the datastore property name. Properties of nested structs are
+	// named with their dotted path (e.g. "Inner.Field").
+	Name string `json:"name"`
+
+	// Type is the PropertyType of the property (e.g. "PTInt"), or "" if it
+	// can't be determined statically (e.g. for PropertyConverter fields).
+	Type string `json:"type"`
+
+	// GoType is the Go type of the struct field.
+	GoType string `json:"go_type"`
+
+	// Indexed is true unless the property is tagged with noindex, or is in
+	// a struct tagged with noindex (and isn't tagged with index).
+	Indexed bool `json:"indexed"`
+
+	// Repeated is true if the property can have multiple values.
+	Repeated bool `json:"repeated,omitempty"`
+
+	// Nullable is true if the property can be PTNull (pointer fields).
+	Nullable bool `json:"nullable,omitempty"`
+}
+
+// MetaSchema describes a single `gae:"$meta"` field of a kind.
+type MetaSchema struct {
+	// Name is the meta key, including the leading '$'.
+	Name string `json:"name"`
+
+	// GoType is the Go type of the struct field.
+	GoType string `json:"go_type"`
+
+	// Default is the tagged default value, if any.
+	Default interface{} `json:"default,omitempty"`
+}
+
+// KindSchema describes the datastore schema of a struct type, as seen by
+// GetPLS.
+type KindSchema struct {
+	Kind   string `json:"kind"`
+	GoType string `json:"go_type"`
+
+	// Properties are in struct field order.
+	Properties []PropertySchema `json:"properties"`
+	// Meta is sorted by Name.
+	Meta []MetaSchema `json:"meta,omitempty"`
+
+	// Extra is true if the struct has an `gae:",extra"` field, meaning that it
+	// may have properties in addition to Properties.
+	Extra bool `json:"extra,omitempty"`
+}
+
+var (
+	schemaRegistryMu sync.Mutex
+	schemaRegistry   = map[reflect.Type]*KindSchema{}
+)
+
+// RegisterKind adds the struct type of obj to the schema registry, which can
+// be retrieved with RegisteredSchema. obj must be a struct or a pointer to
+// a struct. It panics if the struct isn't valid for GetPLS.
+//
+// It's intended to be called from init() functions in the packages which
+// declare datastore models.
+func RegisterKind(obj interface{}) {
+	t := reflect.TypeOf(obj)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	s := schemaOf(t)
+
+	schemaRegistryMu.Lock()
+	defer schemaRegistryMu.Unlock()
+	schemaRegistry[t] = s
+}
+
+// RegisteredSchema returns the schema of every type registered with
+// RegisterKind, sorted by kind (and then by Go type).
+func RegisteredSchema() []*KindSchema {
+	schemaRegistryMu.Lock()
+	ret := make([]*KindSchema, 0, len(schemaRegistry))
+	for _, s := range schemaRegistry {
+		ret = append(ret, s)
+	}
+	schemaRegistryMu.Unlock()
+
+	sort.Sort(kindSchemas(ret))
+	return ret
+}
+
+type kindSchemas []*KindSchema
+
+func (s kindSchemas) Len() int      { return len(s) }
+func (s kindSchemas) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s kindSchemas) Less(i, j int) bool {
+	if s[i].Kind != s[j].Kind {
+		return s[i].Kind < s[j].Kind
+	}
+	return s[i].GoType < s[j].GoType
+}
+
+type metaSchemas []MetaSchema
+
+func (s metaSchemas) Len() int           { return len(s) }
+func (s metaSchemas) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s metaSchemas) Less(i, j int) bool { return s[i].Name < s[j].Name }
+
+// GetSchema returns the schema of the struct type of obj, without registering
+// it. obj must be a struct or a pointer to a struct.
+func GetSchema(obj interface{}) *KindSchema {
+	t := reflect.TypeOf(obj)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return schemaOf(t)
+}
+
+func schemaOf(t reflect.Type) *KindSchema {
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Errorf("schema: expected struct, got %s", t))
+	}
+	c := getCodec(t)
+
+	ret := &KindSchema{GoType: t.String()}
+	ret.Kind, _ = GetMetaDefault(getMGS(reflect.New(t).Interface()), "kind", "").(string)
+
+	for name, idx := range c.byMeta {
+		st := c.byIndex[idx]
+		ret.Meta = append(ret.Meta, MetaSchema{
+			Name:    "$" + name,
+			GoType:  t.Field(idx).Type.String(),
+			Default: st.metaVal,
+		})
+	}
+	sort.Sort(metaSchemas(ret.Meta))
+
+	_, ret.Extra = c.bySpecial["extra"]
+	ret.Properties = propertySchemas(t, c, "", ShouldIndex, false, nil)
+	return ret
+}
+
+func propertySchemas(t reflect.Type, c *structCodec, prefix string, is IndexSetting, repeated bool, ret []PropertySchema) []PropertySchema {
+	for i, st := range c.byIndex {
+		if st.name == "-" || st.isExtra {
+			continue
+		}
+		ft := t.Field(i).Type
+		is1 := c.indexSetting(&st, is)
+		elemType := ft
+		if st.isSlice {
+			elemType = ft.Elem()
+		}
+
+		if st.substructCodec != nil {
+			ret = propertySchemas(elemType, st.substructCodec, prefix+st.name, is1, repeated || st.isSlice, ret)
+			continue
+		}
+
+		ps := PropertySchema{
+			Name:     prefix + st.name,
+			GoType:   ft.String(),
+			Indexed:  is1 == ShouldIndex,
+			Repeated: repeated || st.isSlice,
+		}
+		if st.convert {
+			// The best we can do is to see what the zero value converts to.
+			if prop, err := reflect.New(elemType).Interface().(PropertyConverter).ToProperty(); err == nil && prop.Type() != PTNull {
+				ps.Type = prop.Type().String()
+			}
+		} else {
+			if isNullableType(elemType) {
+				ps.Nullable = true
+				elemType = elemType.Elem()
+			}
+			v := UpconvertUnderlyingType(reflect.New(elemType).Elem().Interface())
+			if v == nil && elemType == typeOfKey {
+				ps.Type = PTKey.String()
+			} else if pt, err := PropertyTypeOf(v, false); err == nil {
+				ps.Type = pt.String()
+			}
+		}
+		ret = append(ret, ps)
+	}
+	return ret
+}
+
+// WriteSchemaJSON renders schema (e.g. as returned by RegisteredSchema) as an
+// indented JSON list.
+func WriteSchemaJSON(w io.Writer, schema []*KindSchema) error {
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// WriteSchemaMarkdown renders schema (e.g. as returned by RegisteredSchema) as
+// a Markdown document, with one section per kind.
+func WriteSchemaMarkdown(w io.Writer, schema []*KindSchema) error {
+	ew := &errWriter{w: w}
+	for i, s := range schema {
+		if i > 0 {
+			ew.printf("\n")
+		}
+		ew.printf("## %s\n\nGo type: `%s`\n\n", s.Kind, s.GoType)
+
+		if len(s.Meta) > 0 {
+			ew.printf("| Meta | Go type | Default |\n|---|---|---|\n")
+			for _, m := range s.Meta {
+				dflt := ""
+				if m.Default != nil {
+					dflt = fmt.Sprintf("`%v`", m.Default)
+				}
+				ew.printf("| `%s` | `%s` | %s |\n", m.Name, m.GoType, dflt)
+			}
+			ew.printf("\n")
+		}
+
+		ew.printf("| Property | Type | Go type | Indexed | Repeated | Nullable |\n")
+		ew.printf("|---|---|---|---|---|---|\n")
+		for _, p := range s.Properties {
+			ew.printf("| `%s` | %s | `%s` | %s | %s | %s |\n",
+				p.Name, strings.TrimPrefix(p.Type, "PT"), p.GoType,
+				yesNo(p.Indexed), yesNo(p.Repeated), yesNo(p.Nullable))
+		}
+		if s.Extra {
+			ew.printf("\nEntities of this kind may have additional properties.\n")
+		}
+	}
+	return ew.err
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
+
+// errWriter is an io.Writer wrapper which remembers the first error.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (e *errWriter) printf(format string, args ...interface{}) {
+	if e.err == nil {
+		_, e.err = fmt.Fprintf(e.w, format, args...)
+	}
+}
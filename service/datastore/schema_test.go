@@ -0,0 +1,89 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type SchemaInner struct {
+	A string
+	B []byte `gae:",noindex"`
+}
+
+type SchemaModel struct {
+	_kind  string `gae:"$kind,Model"`
+	ID     int64  `gae:"$id"`
+	Parent *Key   `gae:"$parent"`
+
+	Name  string
+	Tags  []string `gae:"tags,noindex"`
+	Maybe *int64
+	Owner *Key
+	Inner SchemaInner
+	Many  []SchemaInner `gae:"many"`
+	Conv  Convertable2
+
+	Expando PropertyMap `gae:",extra"`
+}
+
+func TestSchema(t *testing.T) {
+	t.Parallel()
+
+	Convey("Schema", t, func() {
+		s := GetSchema(&SchemaModel{})
+		So(s.Kind, ShouldEqual, "Model")
+		So(s.GoType, ShouldEqual, "datastore.SchemaModel")
+		So(s.Extra, ShouldBeTrue)
+		So(s.Meta, ShouldResemble, []MetaSchema{
+			{"$id", "int64", int64(0)},
+			{"$kind", "string", "Model"},
+			{"$parent", "*datastore.Key", nil},
+		})
+		So(s.Properties, ShouldResemble, []PropertySchema{
+			{Name: "Name", Type: "PTString", GoType: "string", Indexed: true},
+			{Name: "tags", Type: "PTString", GoType: "[]string", Repeated: true},
+			{Name: "Maybe", Type: "PTInt", GoType: "*int64", Indexed: true, Nullable: true},
+			{Name: "Owner", Type: "PTKey", GoType: "*datastore.Key", Indexed: true},
+			{Name: "Inner.A", Type: "PTString", GoType: "string", Indexed: true},
+			{Name: "Inner.B", Type: "PTBytes", GoType: "[]uint8"},
+			{Name: "many.A", Type: "PTString", GoType: "string", Indexed: true, Repeated: true},
+			{Name: "many.B", Type: "PTBytes", GoType: "[]uint8", Repeated: true},
+			{Name: "Conv", Type: "PTString", GoType: "datastore.Convertable2", Indexed: true},
+		})
+
+		Convey("defaults the kind to the struct name", func() {
+			So(GetSchema(SchemaInner{}).Kind, ShouldEqual, "SchemaInner")
+		})
+
+		Convey("can be registered and rendered", func() {
+			RegisterKind(&SchemaModel{})
+			RegisterKind(SchemaInner{})
+			reg := RegisteredSchema()
+			So(len(reg), ShouldBeGreaterThanOrEqualTo, 2)
+
+			buf := &bytes.Buffer{}
+			So(WriteSchemaJSON(buf, reg), ShouldBeNil)
+			decoded := []*KindSchema(nil)
+			So(json.Unmarshal(buf.Bytes(), &decoded), ShouldBeNil)
+			So(len(decoded), ShouldEqual, len(reg))
+
+			buf.Reset()
+			So(WriteSchemaMarkdown(buf, []*KindSchema{s}), ShouldBeNil)
+			So(buf.String(), ShouldContainSubstring, "## Model\n")
+			So(buf.String(), ShouldContainSubstring, "| `$kind` | `string` | `Model` |")
+			So(buf.String(), ShouldContainSubstring, "| `tags` | String | `[]string` | no | yes | no |")
+			So(buf.String(), ShouldContainSubstring, "may have additional properties")
+		})
+
+		Convey("panics on bad structs", func() {
+			So(func() { GetSchema(5) }, ShouldPanic)
+		})
+	})
+}
@@ -4,4 +4,7 @@
 
 // Package serialize provides methods for reading and writing concatenable,
 // bytewise-sortable forms of the datatypes defined in the datastore package.
+//
+// Data which is stored outside of a single process should be written with
+// WriteVersionedPropertyMap; see Version for its compatibility policy.
 package serialize
@@ -0,0 +1,110 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package serialize
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+var updateGolden = flag.Bool("update-golden", false,
+	"Write testdata/propertymap.v<Version>.golden from the current encoding.")
+
+// goldenEntity is encoded in the golden files of every version. Its fields
+// cover every property type, so that a change to the encoding of any of them
+// (or to how the PLS saves them) is caught.
+type goldenEntity struct {
+	Int   int64
+	Small int32
+	Float float64
+	Bool  bool
+	Str   string
+	Blob  []byte `gae:",noindex"`
+	When  time.Time
+	Where ds.GeoPoint
+	Ref   *ds.Key
+	Tags  []string
+	Empty *ds.Key
+}
+
+func goldenFixture() *goldenEntity {
+	return &goldenEntity{
+		Int:   -7,
+		Small: 12,
+		Float: 2.5,
+		Bool:  true,
+		Str:   "hello",
+		Blob:  []byte{0, 1, 0xff},
+		When:  time.Date(2015, time.March, 1, 2, 3, 4, 5000, time.UTC),
+		Where: ds.GeoPoint{Lat: 1.5, Lng: -2},
+		Ref:   ds.MakeKey("app", "ns", "Other", "o", "Child", 9),
+		Tags:  []string{"a", "b"},
+	}
+}
+
+// goldenPath is the golden file of the given version. The Unversioned golden
+// has no version byte.
+func goldenPath(version byte) string {
+	return filepath.Join("testdata", fmt.Sprintf("propertymap.v%d.golden", version))
+}
+
+// TestGoldenCompatibility checks that the golden files of every version up
+// to Version still decode to goldenFixture, and that the current encoding is
+// the one in the golden file of Version.
+//
+// When the encoding changes, increment Version and run
+// `go test -update-golden` to add the new golden file. Never edit or remove
+// the golden files of earlier versions: data in those encodings is still out
+// there (e.g. in memcache, or in fixture files; see tools/gaefixtures).
+func TestGoldenCompatibility(t *testing.T) {
+	t.Parallel()
+
+	Convey("Golden PropertyMap encodings", t, func() {
+		pm, err := ds.GetPLS(goldenFixture()).Save(false)
+		So(err, ShouldBeNil)
+
+		cur := &bytes.Buffer{}
+		So(WriteVersionedPropertyMap(cur, WithContext, pm), ShouldBeNil)
+		if *updateGolden {
+			So(ioutil.WriteFile(goldenPath(Version), cur.Bytes(), 0644), ShouldBeNil)
+		}
+
+		Convey("the current encoding matches its golden file", func() {
+			data, err := ioutil.ReadFile(goldenPath(Version))
+			So(err, ShouldBeNil)
+			// If this fails, the encoding has changed without a new Version.
+			So(data, ShouldResemble, cur.Bytes())
+		})
+
+		for v := Unversioned; v <= Version; v++ {
+			v := v
+			Convey(fmt.Sprintf("version %d is readable", v), func() {
+				data, err := ioutil.ReadFile(goldenPath(v))
+				So(err, ShouldBeNil)
+
+				dec := ds.PropertyMap(nil)
+				if v == Unversioned {
+					dec, err = ReadPropertyMapVersion(mkBuf(data), Unversioned, WithContext, "", "")
+				} else {
+					dec, err = ReadVersionedPropertyMap(mkBuf(data), WithContext, "", "")
+				}
+				So(err, ShouldBeNil)
+				So(dec, ShouldResemble, pm)
+
+				ent := &goldenEntity{}
+				So(ds.GetPLS(ent).Load(dec), ShouldBeNil)
+				So(ent, ShouldResemble, goldenFixture())
+			})
+		}
+	})
+}
@@ -513,3 +513,122 @@ func TestPartialSerialization(t *testing.T) {
 		})
 	})
 }
+
+func TestVersionedPropertyMap(t *testing.T) {
+	t.Parallel()
+
+	Convey("Versioned PropertyMap serialization", t, func() {
+		pm := ds.PropertyMap{
+			"R": {mp(false), mp(2.1), mpNI(3)},
+			"S": {mp("hello"), mp("world")},
+		}
+
+		Convey("round trip", func() {
+			buf := mkBuf(nil)
+			So(WriteVersionedPropertyMap(buf, WithContext, pm), ShouldBeNil)
+			So(buf.Bytes()[0], ShouldEqual, Version)
+
+			dec, err := ReadVersionedPropertyMap(buf, WithContext, "", "")
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, pm)
+		})
+
+		Convey("reads unversioned data", func() {
+			dec, err := ReadPropertyMapVersion(mkBuf(ToBytesWithContext(pm)), Unversioned, WithContext, "", "")
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, pm)
+		})
+
+		Convey("rejects newer versions", func() {
+			data := append([]byte{Version + 1}, ToBytesWithContext(pm)...)
+			_, err := ReadVersionedPropertyMap(mkBuf(data), WithContext, "", "")
+			So(err, ShouldResemble, &ErrUnknownVersion{Version + 1})
+			So(err, ShouldErrLike, "unknown encoding version 2")
+		})
+
+		Convey("rejects a missing version", func() {
+			_, err := ReadVersionedPropertyMap(mkBuf([]byte{0}), WithContext, "", "")
+			So(err, ShouldErrLike, "invalid encoding version 0")
+
+			_, err = ReadVersionedPropertyMap(mkBuf(nil), WithContext, "", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		n := w.n
+		w.n = 0
+		return n, io.ErrShortWrite
+	}
+	w.n -= len(p)
+	return len(p), nil
+}
+
+func TestPropertyMapStreaming(t *testing.T) {
+	t.Parallel()
+
+	Convey("Streaming PropertyMap serialization", t, func() {
+		pm := ds.PropertyMap{
+			"R":    {mp(false), mp(2.1), mpNI(3)},
+			"S":    {mp("hello"), mp("world")},
+			"Blob": {mpNI(bytes.Repeat([]byte{0xab}, 100*1024))},
+			"K":    {mp(mkKey("aid", "ns", "parent", "something", "knd", 10))},
+		}
+
+		Convey("writes the same bytes as WritePropertyMap", func() {
+			buf := &bytes.Buffer{}
+			So(WritePropertyMapTo(buf, WithContext, pm), ShouldBeNil)
+			So(buf.Bytes(), ShouldResemble, ToBytesWithContext(pm))
+
+			buf.Reset()
+			So(WriteVersionedPropertyMapTo(buf, WithoutContext, pm), ShouldBeNil)
+			exp := mkBuf(nil)
+			So(WriteVersionedPropertyMap(exp, WithoutContext, pm), ShouldBeNil)
+			So(buf.Bytes(), ShouldResemble, exp.Bytes())
+		})
+
+		Convey("round trips through a pipe", func() {
+			r, w := io.Pipe()
+			go func() {
+				w.CloseWithError(WriteVersionedPropertyMapTo(w, WithContext, pm))
+			}()
+
+			dec, err := ReadVersionedPropertyMapFrom(r, WithContext, "", "")
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, pm)
+			r.Close()
+		})
+
+		Convey("reads only the PropertyMap from a ByteReader", func() {
+			buf := bytes.NewBuffer(ToBytesWithContext(pm))
+			buf.WriteString("trailer")
+
+			dec, err := ReadPropertyMapFrom(buf, WithContext, "", "")
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, pm)
+			So(buf.String(), ShouldEqual, "trailer")
+		})
+
+		Convey("reads unversioned data", func() {
+			r := struct{ io.Reader }{bytes.NewReader(ToBytesWithContext(pm))}
+			dec, err := ReadPropertyMapVersionFrom(r, Unversioned, WithContext, "", "")
+			So(err, ShouldBeNil)
+			So(dec, ShouldResemble, pm)
+		})
+
+		Convey("returns write errors", func() {
+			err := WritePropertyMapTo(&failingWriter{1024}, WithContext, pm)
+			So(err, ShouldEqual, io.ErrShortWrite)
+		})
+
+		Convey("returns truncation errors", func() {
+			data := ToBytesWithContext(pm)
+			_, err := ReadPropertyMapFrom(bytes.NewReader(data[:len(data)/2]), WithContext, "", "")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
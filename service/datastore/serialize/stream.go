@@ -0,0 +1,123 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package serialize
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/luci/luci-go/common/cmpbin"
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+// errStreamDirection is returned by the methods of the streaming Buffers
+// which go in the other direction (e.g. Write on a reader).
+var errStreamDirection = errors.New("serialize: unsupported operation on stream")
+
+// readerBuffer adapts an io.Reader to the reading half of Buffer.
+type readerBuffer struct {
+	io.Reader
+	br io.ByteReader
+}
+
+var _ Buffer = (*readerBuffer)(nil)
+
+func newReaderBuffer(r io.Reader) *readerBuffer {
+	if br, ok := r.(io.ByteReader); ok {
+		return &readerBuffer{r, br}
+	}
+	b := bufio.NewReader(r)
+	return &readerBuffer{b, b}
+}
+
+func (r *readerBuffer) ReadByte() (byte, error)           { return r.br.ReadByte() }
+func (r *readerBuffer) String() string                    { return "" }
+func (r *readerBuffer) Bytes() []byte                     { return nil }
+func (r *readerBuffer) Len() int                          { return 0 }
+func (r *readerBuffer) Grow(int)                          {}
+func (r *readerBuffer) Write([]byte) (int, error)         { return 0, errStreamDirection }
+func (r *readerBuffer) WriteByte(byte) error              { return errStreamDirection }
+func (r *readerBuffer) WriteString(s string) (int, error) { return 0, errStreamDirection }
+
+// writerBuffer adapts a *bufio.Writer to the writing half of Buffer.
+type writerBuffer struct {
+	*bufio.Writer
+}
+
+var _ Buffer = writerBuffer{}
+
+func (w writerBuffer) String() string           { return "" }
+func (w writerBuffer) Bytes() []byte            { return nil }
+func (w writerBuffer) Len() int                 { return 0 }
+func (w writerBuffer) Grow(int)                 {}
+func (w writerBuffer) Read([]byte) (int, error) { return 0, errStreamDirection }
+func (w writerBuffer) ReadByte() (byte, error)  { return 0, errStreamDirection }
+
+// WritePropertyMapTo writes an entire PropertyMap to w, in the same encoding
+// as WritePropertyMap. Unlike WritePropertyMap, it doesn't hold the encoded
+// rows in memory: each property is written to w (through a small buffer) as
+// it's encoded, so that large entities can be streamed (e.g. into a
+// compressor) without a second copy of their data. `context` behaves the same
+// way that it does for WriteKey.
+func WritePropertyMapTo(w io.Writer, context KeyContext, pm ds.PropertyMap) (err error) {
+	defer recoverTo(&err)
+	buf := writerBuffer{bufio.NewWriter(w)}
+	pm, _ = pm.Save(false)
+
+	names := make(sort.StringSlice, 0, len(pm))
+	for name := range pm {
+		names = append(names, name)
+	}
+	if WritePropertyMapDeterministic {
+		names.Sort()
+	}
+
+	_, e := cmpbin.WriteUint(buf, uint64(len(pm)))
+	panicIf(e)
+	for _, name := range names {
+		vals := pm[name]
+		_, e = cmpbin.WriteString(buf, name)
+		panicIf(e)
+		_, e = cmpbin.WriteUint(buf, uint64(len(vals)))
+		panicIf(e)
+		for _, p := range vals {
+			panicIf(WriteProperty(buf, context, p))
+		}
+	}
+	return buf.Flush()
+}
+
+// ReadPropertyMapFrom reads a PropertyMap written by WritePropertyMap or
+// WritePropertyMapTo from r. `context` and friends behave the same way that
+// they do for ReadKey.
+//
+// If r isn't an io.ByteReader, it's buffered, so it may be read past the end
+// of the PropertyMap.
+func ReadPropertyMapFrom(r io.Reader, context KeyContext, appid, namespace string) (ds.PropertyMap, error) {
+	return ReadPropertyMap(newReaderBuffer(r), context, appid, namespace)
+}
+
+// WriteVersionedPropertyMapTo is WriteVersionedPropertyMap, streamed to w like
+// WritePropertyMapTo.
+func WriteVersionedPropertyMapTo(w io.Writer, context KeyContext, pm ds.PropertyMap) error {
+	if _, err := w.Write([]byte{Version}); err != nil {
+		return err
+	}
+	return WritePropertyMapTo(w, context, pm)
+}
+
+// ReadVersionedPropertyMapFrom is ReadVersionedPropertyMap, streamed from r
+// like ReadPropertyMapFrom.
+func ReadVersionedPropertyMapFrom(r io.Reader, context KeyContext, appid, namespace string) (ds.PropertyMap, error) {
+	return ReadVersionedPropertyMap(newReaderBuffer(r), context, appid, namespace)
+}
+
+// ReadPropertyMapVersionFrom is ReadPropertyMapVersion, streamed from r like
+// ReadPropertyMapFrom.
+func ReadPropertyMapVersionFrom(r io.Reader, version byte, context KeyContext, appid, namespace string) (ds.PropertyMap, error) {
+	return ReadPropertyMapVersion(newReaderBuffer(r), version, context, appid, namespace)
+}
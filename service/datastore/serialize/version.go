@@ -0,0 +1,87 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package serialize
+
+import (
+	"fmt"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+// Versions of the PropertyMap encoding which is stored outside of a single
+// process (e.g. by dscache in memcache), and so may be read by a different
+// binary than the one which wrote it.
+//
+// Compatibility policy:
+//   - WriteVersionedPropertyMap writes a version byte, followed by the
+//     PropertyMap in the encoding of that version.
+//   - Any change to the encoding of a PropertyMap (or of anything it
+//     contains) must increment Version.
+//   - ReadVersionedPropertyMap can always read every version up to Version,
+//     so data written before an upgrade is still readable after it.
+//   - Data written by a newer version fails with ErrUnknownVersion, rather
+//     than being misdecoded, so that (e.g.) caches can treat it as missing
+//     during a rollback.
+//   - Every version has golden files in testdata, which tests check that the
+//     current reader can read (see tools/gaefixtures).
+//
+// The bytewise-sortable encodings (ToBytes, WriteKey, WriteIndexColumn, etc.)
+// aren't versioned, since a version byte would change how they sort. They're
+// only suitable for data which is read by the binary which wrote it.
+const (
+	// Unversioned is the version of the encoding written by WritePropertyMap,
+	// which has no version byte. Callers which stored it must record that they
+	// did some other way, and read it with ReadPropertyMapVersion.
+	Unversioned byte = 0
+
+	// Version is the version written by WriteVersionedPropertyMap. Version 1
+	// is the Unversioned encoding, preceded by the version byte.
+	Version byte = 1
+)
+
+// ErrUnknownVersion is returned when reading a PropertyMap whose encoding
+// version is newer than Version.
+type ErrUnknownVersion struct {
+	Version byte
+}
+
+func (e *ErrUnknownVersion) Error() string {
+	return fmt.Sprintf("serialize: unknown encoding version %d (newest known is %d)", e.Version, Version)
+}
+
+// WriteVersionedPropertyMap writes Version, followed by the PropertyMap.
+// `context` behaves the same way that it does for WriteKey.
+func WriteVersionedPropertyMap(buf Buffer, context KeyContext, pm ds.PropertyMap) error {
+	if err := buf.WriteByte(Version); err != nil {
+		return err
+	}
+	return WritePropertyMap(buf, context, pm)
+}
+
+// ReadVersionedPropertyMap reads a PropertyMap written by
+// WriteVersionedPropertyMap by this or any earlier version. `context` and
+// friends behave the same way that they do for ReadKey.
+func ReadVersionedPropertyMap(buf Buffer, context KeyContext, appid, namespace string) (ds.PropertyMap, error) {
+	version, err := buf.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if version == Unversioned {
+		// Unversioned data never starts with a version byte.
+		return nil, fmt.Errorf("serialize: invalid encoding version %d", version)
+	}
+	return ReadPropertyMapVersion(buf, version, context, appid, namespace)
+}
+
+// ReadPropertyMapVersion reads a PropertyMap in the encoding of the given
+// version, which must not be preceded by its version byte.
+func ReadPropertyMapVersion(buf Buffer, version byte, context KeyContext, appid, namespace string) (ds.PropertyMap, error) {
+	switch version {
+	case Unversioned, 1:
+		return ReadPropertyMap(buf, context, appid, namespace)
+	default:
+		return nil, &ErrUnknownVersion{version}
+	}
+}
@@ -78,3 +78,41 @@ func TestEstimateSizes(t *testing.T) {
 		}
 	})
 }
+
+func TestEstimateCost(t *testing.T) {
+	t.Parallel()
+
+	Convey("Test EstimateCost", t, func() {
+		Convey("counts builtin index rows", func() {
+			pm := PropertyMap{"Something": mps(100)}
+			c := pm.EstimateCost(MakeKey("aid", "ns", "Kind", 1))
+			So(c, ShouldResemble, Cost{
+				EntitySize: 18,
+				IndexSize:  28 + 2*(28+9+9),
+				IndexRows:  3,
+				WriteOps:   4,
+			})
+			So(c.Size(), ShouldEqual, 138)
+		})
+
+		Convey("skips NoIndex values and meta", func() {
+			pm := PropertyMap{
+				"A":   {MkPropertyNI(1)},
+				"$id": {MkProperty(1)},
+			}
+			So(pm.EstimateCost(nil), ShouldResemble, Cost{
+				EntitySize: 10,
+				IndexSize:  4,
+				IndexRows:  1,
+				WriteOps:   2,
+			})
+		})
+
+		Convey("only counts the indexable prefix of long values", func() {
+			pm := PropertyMap{"S": mps(strings.Repeat("x", 2000))}
+			c := pm.EstimateCost(nil)
+			So(c.EntitySize, ShouldEqual, 2002)
+			So(c.IndexSize, ShouldEqual, 4+2*(4+1+1501))
+		})
+	})
+}
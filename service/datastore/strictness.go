@@ -0,0 +1,66 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+)
+
+// Strictness is how strictly Put and Delete validate the entities and keys
+// which they write, before they're passed to the implementation. It applies to
+// the checks of reserved kinds and names and long or deep keys, the count of
+// indexed values, and the checks which entities opt in to with "$validate"
+// (see ValidateProperties). Checks which the call can't proceed without (e.g.
+// of incomplete or invalid keys) are always made.
+//
+// Validation costs time for every write, so high-throughput code may turn it
+// down, while tests turn it up to StrictnessStrict.
+type Strictness int
+
+const (
+	// StrictnessDefault rejects the entities and keys which fail the checks,
+	// but only makes the "$validate" ones for entities which opt in to them.
+	StrictnessDefault Strictness = iota
+
+	// StrictnessOff skips the checks.
+	StrictnessOff
+
+	// StrictnessWarn makes every check, and logs the failures as warnings, but
+	// still writes the entities and keys which fail them.
+	StrictnessWarn
+
+	// StrictnessStrict makes every check, and rejects the entities and keys
+	// which fail them.
+	StrictnessStrict
+)
+
+func (s Strictness) String() string {
+	switch s {
+	case StrictnessDefault:
+		return "Default"
+	case StrictnessOff:
+		return "Off"
+	case StrictnessWarn:
+		return "Warn"
+	case StrictnessStrict:
+		return "Strict"
+	}
+	return fmt.Sprintf("Strictness(%d)", int(s))
+}
+
+// WithStrictness returns a context whose datastore validates writes with the
+// given Strictness.
+func WithStrictness(c context.Context, s Strictness) context.Context {
+	return context.WithValue(c, strictnessKey, s)
+}
+
+// GetStrictness returns the Strictness of c's datastore, which is
+// StrictnessDefault unless it was set with WithStrictness.
+func GetStrictness(c context.Context) Strictness {
+	s, _ := c.Value(strictnessKey).(Strictness)
+	return s
+}
@@ -4,11 +4,30 @@
 
 package datastore
 
+import (
+	"io"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
 // TestingSnapshot is an opaque implementation-defined snapshot type.
 type TestingSnapshot interface {
 	ImATestingSnapshot()
 }
 
+// EntityCommit describes the last commit which put an entity. See
+// Testable.LastCommit.
+type EntityCommit struct {
+	// Version is the version of the entity's group (see EntityGroupMeta) which
+	// the commit made. It's 0 if special entities are disabled.
+	Version int64
+
+	// Timestamp is the time of the commit, according to the clock of the
+	// context which made it.
+	Timestamp time.Time
+}
+
 // Testable is the testable interface for fake datastore implementations.
 type Testable interface {
 	// AddIndex adds the provided index.
@@ -16,6 +35,24 @@ type Testable interface {
 	// Panics if any of the IndexDefinition objects are not Compound()
 	AddIndexes(...*IndexDefinition)
 
+	// LoadIndexYAML parses an index.yaml file (see ParseIndexYAML), and adds its
+	// compound indexes like AddIndexes. Its built-in indexes are skipped, since
+	// they're always present. It returns an error, and adds nothing, if the
+	// file can't be parsed or has invalid indexes.
+	LoadIndexYAML(io.Reader) error
+
+	// UsedIndexes returns the compound indexes which the queries run so far
+	// needed, whether or not they had been added (e.g. because AutoIndex added
+	// them, or the queries failed without them), sorted by kind and
+	// properties. Each is the smallest index which serves its queries.
+	UsedIndexes() []*IndexDefinition
+
+	// WriteUsedIndexYAML writes the UsedIndexes as an index.yaml file, which
+	// can be committed for the production datastore (or loaded with
+	// LoadIndexYAML). This allows a package's tests to find out which indexes
+	// its queries need, rather than production.
+	WriteUsedIndexYAML(io.Writer) error
+
 	// TakeIndexSnapshot allows you to take a snapshot of the current index
 	// tables, which can be used later with SetIndexSnapshot.
 	TakeIndexSnapshot() TestingSnapshot
@@ -37,6 +74,25 @@ type Testable interface {
 	// operation.
 	CatchupIndexes()
 
+	// GetIndexRows returns the raw rows of the index in the current namespace,
+	// as queries see them (i.e. from the index snapshot, unless the datastore
+	// is Consistent), in ascending order. Each row is the serialized values of
+	// the index's columns (see IndexDefinition.GetFullSortOrder), ending with the
+	// entity's key. Descending columns are inverted.
+	//
+	// This is meant for tests of code which depends on the exact contents of
+	// indexes, like cursor arithmetic or custom merging of queries.
+	GetIndexRows(*IndexDefinition) [][]byte
+
+	// SetIndexRows replaces the raw rows of the index in the current namespace
+	// (see GetIndexRows). If the index is Compound, it's also added like
+	// AddIndexes, but without building its rows from the existing entities.
+	//
+	// The rows are set in the current state of the datastore, so queries only
+	// see them after CatchupIndexes, unless the datastore is Consistent. Later
+	// writes update the rows of the entities they write as usual.
+	SetIndexRows(*IndexDefinition, [][]byte)
+
 	// SetTransactionRetryCount set how many times RunInTransaction will retry
 	// transaction body pretending transaction conflicts happens. 0 (default)
 	// means commit succeeds on the first attempt (no retries).
@@ -48,8 +104,21 @@ type Testable interface {
 	//
 	// By default the datastore is eventually consistent, and you must call
 	// CatchupIndexes or use Take/SetIndexSnapshot to manipulate the index state.
+	// Until then, eventually-consistent queries keep returning entities which
+	// have been deleted since the index snapshot, like the production datastore.
 	Consistent(always bool)
 
+	// SetConsistencyProbability makes the eventually-consistent datastore catch
+	// its indexes up (like CatchupIndexes) after each write or transaction with
+	// probability p, chosen with the context's mathrand, like dev_appserver's
+	// pseudo-random high replication consistency policy. Each write is then
+	// visible to eventually-consistent queries immediately with probability p,
+	// and otherwise once a later write catches the indexes up.
+	//
+	// By default this is 0, so the indexes only catch up when CatchupIndexes
+	// is called. It has no effect while the datastore is Consistent.
+	SetConsistencyProbability(p float64)
+
 	// AutoIndex controls the index creation behavior. If it is set to true, then
 	// any time the datastore encounters a missing index, it will silently create
 	// one and allow the query to succeed. If it's false, then the query will
@@ -58,6 +127,14 @@ type Testable interface {
 	// By default this is false.
 	AutoIndex(bool)
 
+	// RequireIndexes makes queries which need an index which hasn't been added
+	// (with AddIndexes or LoadIndexYAML) fail, even if AutoIndex is on, like
+	// dev_appserver's --require_indexes. Queries which the built-in indexes
+	// cover always succeed.
+	//
+	// By default this is false.
+	RequireIndexes(bool)
+
 	// DisableSpecialEntities turns off maintenance of special __entity_group__
 	// type entities. By default this mainenance is enabled, but it can be
 	// disabled by calling this with true.
@@ -73,4 +150,57 @@ type Testable interface {
 	// but never wants the in-memory versions of these entities to bleed through
 	// to the user code.
 	DisableSpecialEntities(bool)
+
+	// AllowReservedKinds controls whether the application may write entities
+	// whose keys have reserved kinds (starting with "__") or names (starting
+	// and ending with "__"), like the production datastore's own entities. By
+	// default, such writes are rejected, like they are in production.
+	//
+	// This is meant for internal tooling, like tests of code which restores
+	// backups. It doesn't apply in transactions.
+	AllowReservedKinds(bool)
+
+	// ReservedKindsAllowed returns true if writes to reserved kinds are
+	// allowed (see AllowReservedKinds).
+	ReservedKindsAllowed() bool
+
+	// ReadSnapshot returns a copy of the context which the Testable was
+	// obtained from, in which the datastore is frozen as it is now: reads and
+	// queries see only what had been written before the call, while writes
+	// through other contexts continue as usual. Writes, ID allocation and
+	// transactions in the returned context fail.
+	//
+	// This is useful for testing code which relies on a consistent view of
+	// the datastore across many reads, like generating reports.
+	ReadSnapshot() context.Context
+
+	// Snapshot returns the current state of the datastore: its entities, its
+	// compound indexes and the state of its index tables. Taking one is cheap,
+	// so tests can seed a fixture once, take a Snapshot of it, and Restore it
+	// before each case, instead of writing the fixture again.
+	Snapshot() TestingSnapshot
+
+	// Restore returns the datastore to the state it had when the given
+	// TestingSnapshot was taken with Snapshot. The TestingSnapshot isn't
+	// affected by later writes, so it can be restored again and again.
+	//
+	// Settings, like Consistent and AutoIndex, aren't part of the snapshot.
+	Restore(TestingSnapshot)
+
+	// UpdateStatistics replaces the datastore statistics entities
+	// (__Stat_Total__, __Stat_Kind__, and their __Stat_Ns_*__ counterparts in
+	// each namespace) with ones describing the current entities, like the
+	// production datastore does about once a day. Their counts are exact, but
+	// their sizes only approximate production's.
+	UpdateStatistics()
+
+	// LastCommit returns the last commit which put the entity with the given
+	// key, or ErrNoSuchEntity if there's no such entity. Outside of
+	// transactions, each entity is put in its own commit. A transaction is a
+	// single commit, which increments the version of each of its groups once.
+	//
+	// An entity was written by the last commit to its group if its Version is
+	// the Version of its group's EntityGroupMeta. This allows tests of
+	// optimistic concurrency schemes to check which writes won, and when.
+	LastCommit(*Key) (EntityCommit, error)
 }
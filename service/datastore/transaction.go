@@ -0,0 +1,99 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"golang.org/x/net/context"
+
+	"github.com/tetrafolium/gae/service/internal/txntasks"
+)
+
+// Transaction describes the transaction which a context is in. See
+// CurrentTransaction.
+//
+// Each attempt of a transaction has its own *Transaction, so two contexts are
+// in the same attempt of the same transaction iff their CurrentTransactions
+// are the same pointer.
+type Transaction struct {
+	parent  *Transaction
+	depth   int
+	attempt int
+	opts    TransactionOptions
+}
+
+// CurrentTransaction returns the transaction which c is in, or nil if it isn't
+// in a transaction.
+//
+// Libraries can use it to check that they're (or aren't) called in a
+// transaction, rather than relying on the errors which the backend returns
+// for operations which aren't allowed in one:
+//   if datastore.CurrentTransaction(c) != nil {
+//     return errors.New("mylib: Frob must not be called in a transaction")
+//   }
+func CurrentTransaction(c context.Context) *Transaction {
+	t, _ := c.Value(transactionKey).(*Transaction)
+	return t
+}
+
+// WithoutTransaction returns a context derived from c whose datastore
+// operations aren't part of the transaction which c is in, if any. They're
+// made directly to the root datastore, so they persist even if the transaction
+// is rolled back, which is useful for e.g. logs and counters. Transactions run
+// in the returned context are new top-level transactions.
+//
+// Filters which keep state for each transaction in the context (like
+// filter/txnBuf) must ignore it when CurrentTransaction returns nil.
+func WithoutTransaction(c context.Context) context.Context {
+	if CurrentTransaction(c) == nil {
+		return c
+	}
+	c = context.WithValue(c, transactionKey, (*Transaction)(nil))
+	return context.WithValue(c, withoutTransactionKey, true)
+}
+
+// outsideTransaction returns true if c was returned by WithoutTransaction, and
+// hasn't entered a new transaction since.
+func outsideTransaction(c context.Context) bool {
+	without, _ := c.Value(withoutTransactionKey).(bool)
+	return without && CurrentTransaction(c) == nil
+}
+
+// Depth returns the nesting depth of the transaction: 1 for a top-level
+// transaction, 2 for a transaction nested inside it (which only some
+// implementations, like filter/txnBuf, support), and so on.
+func (t *Transaction) Depth() int { return t.depth }
+
+// Parent returns the transaction which this one is nested in, or nil if it's a
+// top-level transaction.
+func (t *Transaction) Parent() *Transaction { return t.parent }
+
+// Attempt returns which attempt of its RunInTransaction call the transaction
+// is, starting at 1.
+func (t *Transaction) Attempt() int { return t.attempt }
+
+// Options returns the TransactionOptions which the transaction was run with.
+func (t *Transaction) Options() TransactionOptions { return t.opts }
+
+// runInTransaction runs f in a transaction of rds, with the context given to f
+// recording a new Transaction (nested in parent, if it's not nil) for each
+// attempt. Each attempt of a top-level transaction also gets a new count of
+// its transactional tasks (see service/internal/txntasks).
+func runInTransaction(rds RawInterface, parent *Transaction, f func(c context.Context) error, opts *TransactionOptions) error {
+	t := Transaction{parent: parent, depth: 1}
+	if parent != nil {
+		t.depth = parent.depth + 1
+	}
+	if opts != nil {
+		t.opts = *opts
+	}
+	return rds.RunInTransaction(func(c context.Context) error {
+		t.attempt++
+		cur := t
+		if parent == nil {
+			c = txntasks.WithCount(c)
+		}
+		return f(context.WithValue(c, transactionKey, &cur))
+	}, opts)
+}
@@ -21,6 +21,26 @@ func (g GeoPoint) Valid() bool {
 	return -90 <= g.Lat && g.Lat <= 90 && -180 <= g.Lng && g.Lng <= 180
 }
 
+// EntityGroupMeta is the __entity_group__ pseudo-entity of an entity group.
+// Get it, with Parent set to the group's root key, to read the group's
+// version:
+//   meta := &EntityGroupMeta{Parent: key.Root()}
+//   err := ds.Get(meta)
+//
+// The version increases each time an entity in the group is written or
+// deleted, so it can detect concurrent changes to the group, e.g. in
+// optimistic concurrency schemes which read the group outside of a
+// transaction, and check that its version hasn't changed in the transaction
+// which writes it. Groups which have never been written have version 0.
+type EntityGroupMeta struct {
+	_kind string `gae:"$kind,__entity_group__"`
+	_id   int64  `gae:"$id,1"`
+
+	Parent *Key `gae:"$parent"`
+
+	Version int64 `gae:"__version__"`
+}
+
 // TransactionOptions are the options for running a transaction.
 type TransactionOptions struct {
 	// XG is whether the transaction can cross multiple entity groups. In
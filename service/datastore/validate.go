@@ -0,0 +1,248 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package datastore
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Entities with more than this many indexed properties will not be saved.
+const maxIndexedProperties = 20000
+
+const (
+	// maxKeyNameLength is the maximum length of a key's StringID, in bytes.
+	maxKeyNameLength = 1500
+
+	// maxKeyDepth is the maximum number of elements in a key's path.
+	maxKeyDepth = 100
+)
+
+// Validator may be implemented by an object passed to Interface's Put
+// methods. Validate is invoked after BeforeSave (so it sees any fields which
+// BeforeSave fills in) and after the object's key is extracted, but before
+// it's serialized.
+//
+// If Validate returns an error, nothing is written, and the error is returned
+// as a *ValidationError (in the object's slot, for PutMulti).
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned by Put for entities which fail validation.
+type ValidationError struct {
+	// Key is the key of the invalid entity, if it's known.
+	Key *Key
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Key != nil {
+		return fmt.Sprintf("datastore: invalid entity %s: %s", e.Key, e.Err)
+	}
+	return fmt.Sprintf("datastore: invalid entity: %s", e.Err)
+}
+
+// MaxEntitySize is the maximum size of an entity in the production Appengine
+// datastore.
+const MaxEntitySize = 1 << 20
+
+// EntityTooLargeError is returned by Put for entities with a true "$validate"
+// meta whose estimated size (see EntitySize) is more than MaxEntitySize. Such
+// entities are rejected before anything is written, rather than failing the
+// whole batch in the backend.
+type EntityTooLargeError struct {
+	Key *Key
+
+	// Size is the estimated size of the entity, in bytes.
+	Size int64
+}
+
+func (e *EntityTooLargeError) Error() string {
+	return fmt.Sprintf("datastore: entity %s is too large: its estimated size of %d bytes is more than the limit of %d",
+		e.Key, e.Size, MaxEntitySize)
+}
+
+// EntitySize estimates the size of the entity with the given key and
+// properties, as it would be encoded by the production Appengine datastore.
+// It's the sum of the key's and the PropertyMap's EstimateSize.
+func EntitySize(key *Key, pm PropertyMap) int64 {
+	return key.EstimateSize() + pm.EstimateSize()
+}
+
+// validate invokes Validate on obj (whose key is key), if it implements
+// Validator.
+func validate(key *Key, obj interface{}) error {
+	if v, ok := obj.(Validator); ok {
+		if err := v.Validate(); err != nil {
+			return &ValidationError{key, err}
+		}
+	}
+	return nil
+}
+
+// ValidateProperties checks that pm could be stored as an entity: that its
+// property names are allowed, and that it doesn't have too many indexed
+// values, or indexed string or []byte values which are too long. Meta
+// properties are ignored.
+//
+// It's invoked by Put for PropertyMaps which have a true "$validate" meta,
+// which a struct can opt in to with a field like:
+//
+//   _ Toggle `gae:"$validate,true"`
+//
+// Put also rejects those PropertyMaps if they're too large, with an
+// *EntityTooLargeError. With a StrictnessWarn or StrictnessStrict context,
+// Put does both for every PropertyMap.
+func ValidateProperties(pm PropertyMap) error {
+	for name := range pm {
+		switch {
+		case name == "":
+			return fmt.Errorf("property has an empty name")
+		case isMetaKey(name):
+			continue
+		case strings.HasPrefix(name, "__") && strings.HasSuffix(name, "__"):
+			return fmt.Errorf("property name %q is reserved", name)
+		}
+	}
+	if err := checkIndexedCount(pm); err != nil {
+		return err
+	}
+	return validateIndexedValues(pm)
+}
+
+// validateIndexedValues checks that none of the indexed string or []byte
+// values of pm are longer than the production datastore allows. Such values
+// must be NoIndex. Meta properties are ignored.
+func validateIndexedValues(pm PropertyMap) error {
+	for name, vals := range pm {
+		if isMetaKey(name) {
+			continue
+		}
+		for _, v := range vals {
+			if v.IndexSetting() == NoIndex {
+				continue
+			}
+			size := 0
+			switch v.Type() {
+			case PTString:
+				size = len(v.Value().(string))
+			case PTBytes:
+				size = len(v.Value().([]byte))
+			default:
+				continue
+			}
+			if size > maxIndexedValueSize {
+				return fmt.Errorf("indexed %s value of property %q is %d bytes, more than the limit of %d; it must be NoIndex",
+					v.Type(), name, size, maxIndexedValueSize)
+			}
+		}
+	}
+	return nil
+}
+
+// IndexedCount is the number of indexed values of a property.
+type IndexedCount struct {
+	Name  string
+	Count int
+}
+
+// TooManyIndexedError is returned by Put for entities with more indexed values
+// than the production datastore allows.
+type TooManyIndexedError struct {
+	// Count is the total number of indexed values of the entity.
+	Count int
+
+	// Top are the (at most 3) properties with the most indexed values, most
+	// first.
+	Top []IndexedCount
+}
+
+func (e *TooManyIndexedError) Error() string {
+	buf := bytes.Buffer{}
+	for i, ic := range e.Top {
+		if i > 0 {
+			buf.WriteString(", ")
+		}
+		fmt.Fprintf(&buf, "%q (%d)", ic.Name, ic.Count)
+	}
+	return fmt.Sprintf("gae: too many indexed properties: %d indexed values is more than the limit of %d; the most are in %s",
+		e.Count, maxIndexedProperties, buf.String())
+}
+
+type indexedCounts []IndexedCount
+
+func (s indexedCounts) Len() int      { return len(s) }
+func (s indexedCounts) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s indexedCounts) Less(i, j int) bool {
+	if s[i].Count != s[j].Count {
+		return s[i].Count > s[j].Count
+	}
+	return s[i].Name < s[j].Name
+}
+
+// checkIndexedCount returns a *TooManyIndexedError if pm has more indexed
+// values than the production datastore allows. Meta properties are ignored.
+func checkIndexedCount(pm PropertyMap) error {
+	total := 0
+	counts := indexedCounts(nil)
+	for name, vals := range pm {
+		if isMetaKey(name) {
+			continue
+		}
+		n := 0
+		for _, v := range vals {
+			if v.IndexSetting() == ShouldIndex {
+				n++
+			}
+		}
+		if n > 0 {
+			total += n
+			counts = append(counts, IndexedCount{name, n})
+		}
+	}
+	if total <= maxIndexedProperties {
+		return nil
+	}
+	sort.Sort(counts)
+	if len(counts) > 3 {
+		counts = counts[:3]
+	}
+	return &TooManyIndexedError{total, counts}
+}
+
+// checkReservedKey returns an error if any of the kinds of k start with "__",
+// or any of its StringIDs start and end with "__", unless allowReserved (which
+// is only called for such keys) returns true. The production datastore
+// reserves those for its own entities.
+func checkReservedKey(k *Key, allowReserved func() bool) error {
+	for _, t := range k.toks {
+		if strings.HasPrefix(t.Kind, "__") && !allowReserved() {
+			return fmt.Errorf("datastore: key %s has the reserved kind %q", k, t.Kind)
+		}
+		if len(t.StringID) >= 4 && strings.HasPrefix(t.StringID, "__") && strings.HasSuffix(t.StringID, "__") &&
+			!allowReserved() {
+			return fmt.Errorf("datastore: key %s has the reserved name %q", k, t.StringID)
+		}
+	}
+	return nil
+}
+
+// checkKeyLimits returns an error if k's path is too long, or if any of its
+// StringIDs are too long.
+func checkKeyLimits(k *Key) error {
+	if len(k.toks) > maxKeyDepth {
+		return fmt.Errorf("datastore: key %s has %d elements, more than the limit of %d", k, len(k.toks), maxKeyDepth)
+	}
+	for _, t := range k.toks {
+		if len(t.StringID) > maxKeyNameLength {
+			return fmt.Errorf("datastore: key of kind %q has a name of %d bytes, more than the limit of %d",
+				t.Kind, len(t.StringID), maxKeyNameLength)
+		}
+	}
+	return nil
+}
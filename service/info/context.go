@@ -51,6 +51,18 @@ func SetFactory(c context.Context, gif Factory) context.Context {
 	return context.WithValue(c, infoKey, gif)
 }
 
+// CoreFactory is the function signature for factory methods compatible with
+// SetCoreFactory.
+type CoreFactory func(context.Context) Core
+
+// SetCoreFactory is like SetFactory, for backends which only implement Core.
+// Get wraps the Core instances with Upgrade.
+func SetCoreFactory(c context.Context, cf CoreFactory) context.Context {
+	return SetFactory(c, func(ic context.Context) Interface {
+		return Upgrade(cf(ic))
+	})
+}
+
 // Set sets the current Interface object in the context. Useful for testing
 // with a quick mock. This is just a shorthand SetFactory invocation to set
 // a factory which always returns the same object.
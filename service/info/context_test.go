@@ -0,0 +1,56 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package info
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type coreOnly struct{ Core }
+
+func (coreOnly) AppID() string { return "aid" }
+
+type full struct{ coreOnly }
+
+func (full) Datacenter() string     { return "us1" }
+func (full) ServerSoftware() string { return "Google App Engine/1.9" }
+
+func TestCore(t *testing.T) {
+	t.Parallel()
+
+	Convey("SetCoreFactory", t, func() {
+		c := context.Background()
+
+		Convey("stubs out Legacy for Core-only backends", func() {
+			c = SetCoreFactory(c, func(context.Context) Core { return coreOnly{} })
+
+			i := Get(c)
+			So(i.AppID(), ShouldEqual, "aid")
+			So(i.Datacenter(), ShouldEqual, "")
+			So(i.ServerSoftware(), ShouldEqual, "")
+		})
+
+		Convey("uses Legacy if it's implemented", func() {
+			c = SetCoreFactory(c, func(context.Context) Core { return full{} })
+
+			i := Get(c)
+			So(i, ShouldResemble, full{})
+			So(i.Datacenter(), ShouldEqual, "us1")
+			So(i.ServerSoftware(), ShouldEqual, "Google App Engine/1.9")
+		})
+
+		Convey("applies filters", func() {
+			c = SetCoreFactory(c, func(context.Context) Core { return coreOnly{} })
+			c = AddFilters(c, func(_ context.Context, i Interface) Interface {
+				return full{coreOnly{i}}
+			})
+
+			So(Get(c).Datacenter(), ShouldEqual, "us1")
+		})
+	})
+}
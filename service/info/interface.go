@@ -10,14 +10,12 @@ import (
 	"golang.org/x/net/context"
 )
 
-// Interface is the interface for all of the package methods which normally
-// would be in the 'appengine' package.
-type Interface interface {
+// Core is the part of Interface which every backend implements.
+type Core interface {
 	AppID() string
 	FullyQualifiedAppID() string
 	GetNamespace() string
 
-	Datacenter() string
 	DefaultVersionHostname() string
 	InstanceID() string
 	IsDevAppServer() bool
@@ -26,7 +24,6 @@ type Interface interface {
 	ModuleHostname(module, version, instance string) (string, error)
 	ModuleName() string
 	RequestID() string
-	ServerSoftware() string
 	ServiceAccount() (string, error)
 	VersionID() string
 
@@ -36,4 +33,39 @@ type Interface interface {
 	AccessToken(scopes ...string) (token string, expiry time.Time, err error)
 	PublicCertificates() ([]Certificate, error)
 	SignBytes(bytes []byte) (keyName string, signature []byte, err error)
+
+	Testable() Testable
+}
+
+// Legacy is the part of Interface which is deprecated, or only meaningful on
+// some backends (e.g. classic App Engine). Backends may leave it out by
+// implementing only Core and installing themselves with SetCoreFactory.
+type Legacy interface {
+	Datacenter() string
+	ServerSoftware() string
+}
+
+// Interface is the interface for all of the package methods which normally
+// would be in the 'appengine' package.
+type Interface interface {
+	Core
+	Legacy
+}
+
+// legacyShim adds the Legacy methods to a Core which lacks them. They return
+// empty strings.
+type legacyShim struct {
+	Core
+}
+
+func (legacyShim) Datacenter() string     { return "" }
+func (legacyShim) ServerSoftware() string { return "" }
+
+// Upgrade returns c as an Interface. If c doesn't implement Legacy, its
+// Legacy methods return empty strings.
+func Upgrade(c Core) Interface {
+	if i, ok := c.(Interface); ok {
+		return i
+	}
+	return legacyShim{c}
 }
@@ -0,0 +1,22 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package info
+
+import (
+	"time"
+)
+
+// Testable is the interface for info service implementations which are able
+// to be tested (like impl/memory).
+type Testable interface {
+	// SetTokenLifetime sets how long the tokens minted by AccessToken are valid
+	// for, relative to the context's clock. By default, testing implementations
+	// should use an hour.
+	SetTokenLifetime(d time.Duration)
+
+	// MintedTokens returns the number of tokens which AccessToken has minted
+	// (i.e. not counting tokens returned from a TokenCache).
+	MintedTokens() int
+}
@@ -0,0 +1,103 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package info
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"golang.org/x/net/context"
+)
+
+// DefaultTokenMargin is the safety margin of a TokenCache whose Margin is
+// unset.
+const DefaultTokenMargin = 5 * time.Minute
+
+// TokenCache caches the access tokens returned by Interface.AccessToken until
+// shortly before they expire. The zero value is an empty cache, and a
+// TokenCache may be shared by many contexts (e.g. all requests of a process).
+//
+// Install it with AddTokenCache.
+type TokenCache struct {
+	// Margin is how long before its expiry a token is considered to be expired,
+	// so that callers don't get tokens which expire while they're in use. If
+	// it's <= 0, DefaultTokenMargin is used.
+	Margin time.Duration
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+type cachedToken struct {
+	token  string
+	expiry time.Time
+}
+
+// AddTokenCache adds an info filter to c which caches the results of
+// AccessToken in tc. Tokens are cached per app and set of scopes.
+func AddTokenCache(c context.Context, tc *TokenCache) context.Context {
+	return AddFilters(c, func(ic context.Context, i Interface) Interface {
+		return &tokenCacheImpl{i, tc, ic}
+	})
+}
+
+// Flush removes all tokens from the cache.
+func (tc *TokenCache) Flush() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.tokens = nil
+}
+
+func (tc *TokenCache) margin() time.Duration {
+	if tc.Margin <= 0 {
+		return DefaultTokenMargin
+	}
+	return tc.Margin
+}
+
+func (tc *TokenCache) get(key string, now time.Time) (cachedToken, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tok, ok := tc.tokens[key]
+	if ok && !now.Before(tok.expiry.Add(-tc.margin())) {
+		delete(tc.tokens, key)
+		return cachedToken{}, false
+	}
+	return tok, ok
+}
+
+func (tc *TokenCache) put(key string, tok cachedToken) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if tc.tokens == nil {
+		tc.tokens = map[string]cachedToken{}
+	}
+	tc.tokens[key] = tok
+}
+
+type tokenCacheImpl struct {
+	Interface
+	tc *TokenCache
+	c  context.Context
+}
+
+func (t *tokenCacheImpl) AccessToken(scopes ...string) (token string, expiry time.Time, err error) {
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	key := t.Interface.FullyQualifiedAppID() + "\n" + strings.Join(sorted, " ")
+
+	if tok, ok := t.tc.get(key, clock.Now(t.c)); ok {
+		return tok.token, tok.expiry, nil
+	}
+	// Tokens are minted without holding the lock, so concurrent misses may mint
+	// more than one token. That's harmless, and the last one wins.
+	if token, expiry, err = t.Interface.AccessToken(scopes...); err == nil {
+		t.tc.put(key, cachedToken{token, expiry})
+	}
+	return
+}
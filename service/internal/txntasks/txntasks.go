@@ -0,0 +1,57 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package txntasks counts the tasks added to a taskqueue in each attempt of a
+// datastore transaction. service/datastore starts a new count for every
+// attempt of a top-level transaction, and service/taskqueue uses it to enforce
+// the limit on transactional tasks.
+package txntasks
+
+import (
+	"sync/atomic"
+
+	"golang.org/x/net/context"
+)
+
+type key int
+
+var countKey key
+
+// WithCount returns a context derived from c with a new count of zero tasks.
+// Nested transactions share the count of the context they're run in.
+func WithCount(c context.Context) context.Context {
+	return context.WithValue(c, countKey, new(int32))
+}
+
+func getCount(c context.Context) *int32 {
+	n, _ := c.Value(countKey).(*int32)
+	return n
+}
+
+// Reserve adds n tasks to the count of c and returns true, unless that would
+// make more than max, in which case it leaves the count as it is and returns
+// false. It always returns true if c has no count.
+func Reserve(c context.Context, n, max int) bool {
+	count := getCount(c)
+	if count == nil {
+		return true
+	}
+	for {
+		cur := atomic.LoadInt32(count)
+		if int(cur)+n > max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(count, cur, cur+int32(n)) {
+			return true
+		}
+	}
+}
+
+// Release removes n tasks which were reserved with Reserve, but weren't added
+// after all, from the count of c.
+func Release(c context.Context, n int) {
+	if count := getCount(c); count != nil && n > 0 {
+		atomic.AddInt32(count, -int32(n))
+	}
+}
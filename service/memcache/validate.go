@@ -0,0 +1,53 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package memcache
+
+import (
+	"errors"
+)
+
+const (
+	// MaxKeySize is the maximum length of a memcache key, in bytes.
+	MaxKeySize = 250
+
+	// MaxValueSize is the maximum length of a memcache value, in bytes. The
+	// production service also counts the key and some overhead towards its
+	// limit, so values close to this may still be rejected there.
+	MaxValueSize = 1000000
+)
+
+// These errors are returned by all implementations for keys and items which
+// the production service would reject.
+var (
+	ErrKeyTooLong    = errors.New("memcache: key too long")
+	ErrInvalidKey    = errors.New("memcache: key contains invalid characters")
+	ErrValueTooLarge = errors.New("memcache: value too large")
+)
+
+// ValidateKey returns ErrKeyTooLong or ErrInvalidKey if key is too long, or
+// contains whitespace or control characters.
+func ValidateKey(key string) error {
+	if len(key) > MaxKeySize {
+		return ErrKeyTooLong
+	}
+	for i := 0; i < len(key); i++ {
+		if b := key[i]; b <= ' ' || b == 0x7f {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// ValidateItem returns an error if the item's key is invalid (see
+// ValidateKey), or if its value is larger than MaxValueSize.
+func ValidateItem(item Item) error {
+	if err := ValidateKey(item.Key()); err != nil {
+		return err
+	}
+	if len(item.Value()) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package taskqueue
+
+import (
+	"golang.org/x/net/context"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/internal/txntasks"
+)
+
+// txnCheckFilter counts the tasks added in a datastore transaction, and
+// rejects those which would go over MaxTransactionalTasks. Tasks which fail to
+// be added don't count.
+type txnCheckFilter struct {
+	RawInterface
+
+	c context.Context
+}
+
+func (tcf *txnCheckFilter) AddMulti(tasks []*Task, queueName string, cb RawTaskCB) error {
+	if len(tasks) == 0 {
+		return nil
+	}
+	if !txntasks.Reserve(tcf.c, len(tasks), MaxTransactionalTasks) {
+		for range tasks {
+			cb(nil, ErrTooManyTransactionalTasks)
+		}
+		return nil
+	}
+	added := 0
+	err := tcf.RawInterface.AddMulti(tasks, queueName, func(t *Task, err error) {
+		if err == nil {
+			added++
+		}
+		cb(t, err)
+	})
+	txntasks.Release(tcf.c, len(tasks)-added)
+	return err
+}
+
+// applyCheckFilter adds the checks of the datastore transaction which c is in,
+// if any.
+func applyCheckFilter(c context.Context, i RawInterface) RawInterface {
+	if ds.CurrentTransaction(c) != nil {
+		return &txnCheckFilter{i, c}
+	}
+	return i
+}
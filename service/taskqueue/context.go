@@ -45,6 +45,9 @@ func getFiltered(c context.Context, wantTxn bool) RawInterface {
 	for _, f := range getCurFilters(c) {
 		ret = f(c, ret)
 	}
+	if wantTxn {
+		ret = applyCheckFilter(c, ret)
+	}
 	return ret
 }
 
@@ -62,12 +65,12 @@ func GetRawNoTxn(c context.Context) RawInterface {
 
 // Get gets the Interface implementation from context.
 func Get(c context.Context) Interface {
-	return &taskqueueImpl{GetRaw(c)}
+	return &taskqueueImpl{GetRaw(c), c}
 }
 
 // GetNoTxn gets the Interface implementation from context.
 func GetNoTxn(c context.Context) Interface {
-	return &taskqueueImpl{GetRawNoTxn(c)}
+	return &taskqueueImpl{GetRawNoTxn(c), c}
 }
 
 // SetRawFactory sets the function to produce RawInterface instances, as returned by
@@ -5,9 +5,21 @@
 package taskqueue
 
 import (
+	"fmt"
+
 	"google.golang.org/appengine/taskqueue"
 )
 
 // ErrTaskAlreadyAdded is the error returned when a named task is added to a
 // task queue more than once.
 var ErrTaskAlreadyAdded = taskqueue.ErrTaskAlreadyAdded
+
+// MaxTransactionalTasks is the most tasks which can be added in a single
+// datastore transaction.
+const MaxTransactionalTasks = 5
+
+// ErrTooManyTransactionalTasks is the error returned when adding a task in a
+// datastore transaction would make more than MaxTransactionalTasks in it. It's
+// returned when the task is added, rather than when the transaction commits.
+var ErrTooManyTransactionalTasks = fmt.Errorf(
+	"taskqueue: a transaction can't add more than %d tasks", MaxTransactionalTasks)
@@ -0,0 +1,178 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package fanout enqueues the results of a keys-only datastore query as tasks,
+// several keys per task.
+//
+// Query checkpoints its progress with a datastore cursor after every batch of
+// tasks which has been added, so that an interrupted fan-out can be resumed
+// from that cursor without skipping or repeating any keys.
+package fanout
+
+import (
+	"bytes"
+	"strings"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	"golang.org/x/net/context"
+)
+
+// DefaultKeysPerTask is the number of keys in each task if
+// Options.KeysPerTask is unset.
+const DefaultKeysPerTask = 100
+
+// Options controls the behavior of Query.
+type Options struct {
+	// KeysPerTask is the maximum number of keys passed to a single task. If
+	// it's <= 0, DefaultKeysPerTask is used.
+	KeysPerTask int
+
+	// TasksPerBatch is the maximum number of tasks added with a single
+	// AddMulti, and so the number of tasks between checkpoints. If it's <= 0,
+	// taskqueue.MaxAddBatchSize is used.
+	TasksPerBatch int
+
+	// Path is the path of the tasks made by the default MakeTask. If it's
+	// empty, the queue's default path is used.
+	Path string
+
+	// MakeTask, if not nil, makes the task for a chunk of keys. By default,
+	// a POST task is made to Path, whose payload is the keys, as encoded by
+	// EncodePayload.
+	MakeTask func(keys []*ds.Key) (*tq.Task, error)
+
+	// Cursor, if not nil, is the point in the query to start from. Pass the
+	// last cursor given to Checkpoint by a previous, failed, Query to resume
+	// it.
+	Cursor ds.Cursor
+
+	// Checkpoint, if not nil, is called after every batch of tasks has been
+	// added, with the cursor to resume the query from and the total number of
+	// tasks added so far by this call. When the query has been exhausted, it's
+	// called one last time with a nil cursor. If it returns an error, Query
+	// stops and returns that error.
+	Checkpoint func(cursor ds.Cursor, tasks int) error
+}
+
+// Query runs q as a keys-only query in c, and adds a task to queueName for
+// every o.KeysPerTask keys it returns. o may be nil.
+//
+// It returns the number of tasks which were added.
+func Query(c context.Context, q *ds.Query, queueName string, o *Options) (int, error) {
+	if o == nil {
+		o = &Options{}
+	}
+	keysPerTask := o.KeysPerTask
+	if keysPerTask <= 0 {
+		keysPerTask = DefaultKeysPerTask
+	}
+	tasksPerBatch := o.TasksPerBatch
+	if tasksPerBatch <= 0 {
+		tasksPerBatch = tq.MaxAddBatchSize
+	}
+	makeTask := o.MakeTask
+	if makeTask == nil {
+		makeTask = func(keys []*ds.Key) (*tq.Task, error) {
+			return &tq.Task{Path: o.Path, Method: "POST", Payload: EncodePayload(keys)}, nil
+		}
+	}
+
+	q = q.KeysOnly(true)
+	if o.Cursor != nil {
+		q = q.Start(o.Cursor)
+	}
+
+	taskq := tq.Get(c)
+	added := 0
+	keys := make([]*ds.Key, 0, keysPerTask)
+	tasks := make([]*tq.Task, 0, tasksPerBatch)
+
+	// cut turns the pending keys into a task.
+	cut := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		t, err := makeTask(keys)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, t)
+		keys = make([]*ds.Key, 0, keysPerTask)
+		return nil
+	}
+
+	// flush adds the pending tasks, and then checkpoints at cursor.
+	flush := func(cursor ds.Cursor) error {
+		if len(tasks) > 0 {
+			if err := taskq.AddMulti(tasks, queueName); err != nil {
+				return err
+			}
+			added += len(tasks)
+			tasks = tasks[:0]
+		}
+		if o.Checkpoint != nil {
+			return o.Checkpoint(cursor, added)
+		}
+		return nil
+	}
+
+	err := ds.Get(c).Run(q, func(k *ds.Key, getCursor ds.CursorCB) error {
+		keys = append(keys, k)
+		if len(keys) < keysPerTask {
+			return nil
+		}
+		if err := cut(); err != nil {
+			return err
+		}
+		if len(tasks) < tasksPerBatch {
+			return nil
+		}
+		// The cursor points just past k, which is the last key of the last
+		// task in this batch.
+		cursor, err := getCursor()
+		if err != nil {
+			return err
+		}
+		return flush(cursor)
+	})
+	if err != nil {
+		return added, err
+	}
+	if err := cut(); err != nil {
+		return added, err
+	}
+	return added, flush(nil)
+}
+
+// EncodePayload encodes keys as the payload of a task, one encoded key per
+// line.
+func EncodePayload(keys []*ds.Key) []byte {
+	buf := &bytes.Buffer{}
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(k.Encode())
+	}
+	return buf.Bytes()
+}
+
+// DecodePayload decodes the keys in the payload of a task made by the default
+// Options.MakeTask.
+func DecodePayload(payload []byte) ([]*ds.Key, error) {
+	if len(payload) == 0 {
+		return nil, nil
+	}
+	lines := strings.Split(string(payload), "\n")
+	ret := make([]*ds.Key, len(lines))
+	for i, l := range lines {
+		k, err := ds.NewKeyEncoded(l)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = k
+	}
+	return ret, nil
+}
@@ -0,0 +1,160 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package fanout
+
+import (
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/tetrafolium/gae/impl/memory"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	tq "github.com/tetrafolium/gae/service/taskqueue"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/context"
+)
+
+type keySlice []*ds.Key
+
+func (s keySlice) Len() int           { return len(s) }
+func (s keySlice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s keySlice) Less(i, j int) bool { return s[i].Less(s[j]) }
+
+func TestQuery(t *testing.T) {
+	t.Parallel()
+
+	Convey("Query", t, func() {
+		c := memory.Use(context.Background())
+		dstore := ds.Get(c)
+		tqt := tq.Get(c).Testable()
+
+		want := make([]*ds.Key, 25)
+		for i := range want {
+			want[i] = dstore.NewKey("Item", "", int64(i+1), nil)
+			So(dstore.Put(ds.PropertyMap{
+				"$key":  {ds.MkPropertyNI(want[i])},
+				"Value": {ds.MkProperty(int64(i))},
+			}), ShouldBeNil)
+		}
+		dstore.Testable().CatchupIndexes()
+
+		q := ds.NewQuery("Item")
+
+		// enqueued returns the keys of all scheduled tasks, in key order.
+		enqueued := func() []*ds.Key {
+			ret := []*ds.Key{}
+			for _, t := range tqt.GetScheduledTasks()["default"] {
+				keys, err := DecodePayload(t.Payload)
+				So(err, ShouldBeNil)
+				So(len(keys), ShouldBeLessThanOrEqualTo, 4)
+				ret = append(ret, keys...)
+			}
+			sort.Sort(keySlice(ret))
+			return ret
+		}
+
+		Convey("enqueues every key in chunks", func() {
+			checkpoints := []int{}
+			n, err := Query(c, q, "", &Options{
+				KeysPerTask:   4,
+				TasksPerBatch: 2,
+				Checkpoint: func(cur ds.Cursor, tasks int) error {
+					checkpoints = append(checkpoints, tasks)
+					return nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 7)
+			So(checkpoints, ShouldResemble, []int{2, 4, 6, 7})
+			So(len(tqt.GetScheduledTasks()["default"]), ShouldEqual, 7)
+			So(enqueued(), ShouldResemble, want)
+
+			for _, t := range tqt.GetScheduledTasks()["default"] {
+				So(t.Method, ShouldEqual, "POST")
+				So(t.Path, ShouldEqual, "/_ah/queue/default")
+			}
+		})
+
+		Convey("can resume from a checkpoint", func() {
+			var last ds.Cursor
+			_, err := Query(c, q, "", &Options{
+				KeysPerTask:   4,
+				TasksPerBatch: 2,
+				Checkpoint: func(cur ds.Cursor, tasks int) error {
+					if tasks == 4 {
+						return errors.New("interrupted")
+					}
+					last = cur
+					return nil
+				},
+			})
+			So(err, ShouldErrLike, "interrupted")
+			So(last, ShouldNotBeNil)
+
+			// The second batch was added, but not checkpointed, so resuming from
+			// the first checkpoint re-adds it. Clear them to check that resuming
+			// neither skips nor duplicates keys.
+			tqt.ResetTasks()
+
+			n, err := Query(c, q, "", &Options{KeysPerTask: 4, TasksPerBatch: 2, Cursor: last})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 5)
+			So(enqueued(), ShouldResemble, want[8:])
+		})
+
+		Convey("uses MakeTask", func() {
+			n, err := Query(c, q.Lt("Value", 10), "", &Options{
+				KeysPerTask: 5,
+				MakeTask: func(keys []*ds.Key) (*tq.Task, error) {
+					return &tq.Task{Path: "/custom", Payload: EncodePayload(keys)}, nil
+				},
+			})
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 2)
+			for _, t := range tqt.GetScheduledTasks()["default"] {
+				So(t.Path, ShouldEqual, "/custom")
+			}
+		})
+
+		Convey("returns MakeTask errors", func() {
+			n, err := Query(c, q, "", &Options{
+				MakeTask: func(keys []*ds.Key) (*tq.Task, error) {
+					return nil, errors.New("nope")
+				},
+			})
+			So(err, ShouldErrLike, "nope")
+			So(n, ShouldEqual, 0)
+		})
+
+		Convey("adds nothing for an empty query", func() {
+			n, err := Query(c, ds.NewQuery("Nothing"), "", nil)
+			So(err, ShouldBeNil)
+			So(n, ShouldEqual, 0)
+			So(tqt.GetScheduledTasks()["default"], ShouldBeEmpty)
+		})
+	})
+}
+
+func TestPayload(t *testing.T) {
+	t.Parallel()
+
+	Convey("EncodePayload and DecodePayload round-trip", t, func() {
+		keys := []*ds.Key{
+			ds.NewKey("app", "ns", "Parent", "p", 0, nil),
+			ds.NewKey("app", "ns", "Child", "", 10, ds.NewKey("app", "ns", "Parent", "p", 0, nil)),
+		}
+		got, err := DecodePayload(EncodePayload(keys))
+		So(err, ShouldBeNil)
+		So(got, ShouldResemble, keys)
+
+		got, err = DecodePayload(nil)
+		So(err, ShouldBeNil)
+		So(got, ShouldBeNil)
+
+		_, err = DecodePayload([]byte("bogus"))
+		So(err, ShouldNotBeNil)
+	})
+}
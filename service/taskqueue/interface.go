@@ -4,6 +4,10 @@
 
 package taskqueue
 
+import (
+	"time"
+)
+
 // Interface is the full interface to the Task Queue service.
 type Interface interface {
 	// NewTask simply creates a new Task object with the Path field populated.
@@ -12,9 +16,27 @@ type Interface interface {
 	NewTask(path string) *Task
 
 	Add(task *Task, queueName string) error
+
+	// AddDelayed adds task with an ETA of d after the current time of the
+	// context's clock, replacing its Delay. Unlike setting Delay, the ETA is
+	// fixed when AddDelayed is called, so it agrees with a testclock even if the
+	// task is added in a transaction. It's an error for task to have an ETA.
+	AddDelayed(task *Task, queueName string, d time.Duration) error
+
 	Delete(task *Task, queueName string) error
 
 	AddMulti(tasks []*Task, queueName string) error
+
+	// AddSpread adds tasks like AddMulti, but first offsets the ETA of each task
+	// by a random duration within spread.Window, to avoid starting many workers
+	// at once. The ETA of tasks without one is spread.Start (or now) plus their
+	// Delay.
+	//
+	// The tasks are added in batches of at most MaxAddBatchSize tasks. The
+	// random offsets are drawn from the context's mathrand generator. If spread
+	// isn't valid, or a task has both a Delay and an ETA, it returns an error
+	// without modifying or adding any of the tasks.
+	AddSpread(tasks []*Task, queueName string, spread Spread) error
 	DeleteMulti(tasks []*Task, queueName string) error
 
 	// NOTE(riannucci): No support for pull taskqueues. We're not planning on
@@ -0,0 +1,134 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package taskqueue
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/luci/luci-go/common/clock"
+	"github.com/luci/luci-go/common/errors"
+	"github.com/luci/luci-go/common/mathrand"
+)
+
+// MaxAddBatchSize is the maximum number of tasks which AddSpread adds with
+// a single AddMulti call.
+const MaxAddBatchSize = 100
+
+// Distribution is the distribution of task ETAs within a Spread's Window.
+type Distribution int
+
+const (
+	// Uniform spreads ETAs evenly over the window.
+	Uniform Distribution = iota
+
+	// Exponential puts most ETAs near the start of the window, with a tail
+	// over the rest of it. It's a truncated exponential distribution whose
+	// mean (before truncation) is a quarter of the window.
+	Exponential
+)
+
+func (d Distribution) String() string {
+	switch d {
+	case Uniform:
+		return "Uniform"
+	case Exponential:
+		return "Exponential"
+	}
+	return fmt.Sprintf("Distribution(%d)", int(d))
+}
+
+// Spread describes how AddSpread jitters the ETAs of tasks.
+type Spread struct {
+	// Window is the duration over which the ETAs are spread. Each task gets a
+	// random offset in [0, Window).
+	Window time.Duration
+
+	// Distribution is the distribution of the offsets within Window.
+	Distribution Distribution
+
+	// Start is the time that tasks without an ETA are scheduled relative to.
+	// If it's zero, the current time of the context's clock is used.
+	Start time.Time
+}
+
+// Validate returns an error if the Spread can't be used, e.g. because its
+// Distribution is unknown.
+func (s *Spread) Validate() error {
+	switch s.Distribution {
+	case Uniform, Exponential:
+		return nil
+	}
+	return fmt.Errorf("taskqueue: unknown distribution %s", s.Distribution)
+}
+
+// Offset returns a random offset in [0, Window), drawn from r. It panics if the
+// Spread isn't valid (see Validate).
+func (s *Spread) Offset(r *rand.Rand) time.Duration {
+	if s.Window <= 0 {
+		return 0
+	}
+	var frac float64
+	switch s.Distribution {
+	case Uniform:
+		frac = r.Float64()
+	case Exponential:
+		// Inverse CDF of an exponential distribution with rate 4 (per Window),
+		// truncated to [0, 1).
+		const rate = 4
+		frac = -math.Log(1-r.Float64()*(1-math.Exp(-rate))) / rate
+	default:
+		panic(fmt.Errorf("taskqueue: unknown distribution %s", s.Distribution))
+	}
+	ret := time.Duration(frac * float64(s.Window))
+	if ret >= s.Window {
+		ret = s.Window - 1
+	}
+	return ret
+}
+
+func (t *taskqueueImpl) AddSpread(tasks []*Task, queueName string, spread Spread) error {
+	if err := spread.Validate(); err != nil {
+		return err
+	}
+	for _, tsk := range tasks {
+		if !tsk.ETA.IsZero() && tsk.Delay != 0 {
+			return fmt.Errorf("taskqueue: both Delay and ETA are set")
+		}
+	}
+
+	start := spread.Start
+	if start.IsZero() {
+		start = clock.Now(t.c)
+	}
+	r := mathrand.Get(t.c)
+
+	for _, tsk := range tasks {
+		if tsk.ETA.IsZero() {
+			tsk.ETA = start.Add(tsk.Delay)
+		}
+		tsk.Delay = 0
+		tsk.ETA = tsk.ETA.Add(spread.Offset(r))
+	}
+
+	lme := errors.NewLazyMultiError(len(tasks))
+	for i := 0; i < len(tasks); i += MaxAddBatchSize {
+		end := i + MaxAddBatchSize
+		if end > len(tasks) {
+			end = len(tasks)
+		}
+		err := t.AddMulti(tasks[i:end], queueName)
+		if me, ok := err.(errors.MultiError); ok {
+			for j, err := range me {
+				lme.Assign(i+j, err)
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+	return lme.Get()
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package taskqueue
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// hostHeader is the task header which App Engine uses to route a task to
+// a specific version and/or module of the app.
+const hostHeader = "Host"
+
+var (
+	validTargetPart   = regexp.MustCompile(`^[a-z\d]([a-z\d-]{0,61}[a-z\d])?$`)
+	validHostnamePart = regexp.MustCompile(`^[a-zA-Z\d]([a-zA-Z\d-]{0,61}[a-zA-Z\d])?$`)
+)
+
+// Target identifies the version and/or module of an app which a task is
+// routed to. An empty Version or Module means the default one.
+type Target struct {
+	Version string
+	Module  string
+}
+
+// Validate returns an error if the Version or Module of tg isn't a valid
+// version or module name.
+func (tg Target) Validate() error {
+	if tg.Version != "" && !validTargetPart.MatchString(tg.Version) {
+		return fmt.Errorf("taskqueue: invalid target version %q", tg.Version)
+	}
+	if tg.Module != "" && !validTargetPart.MatchString(tg.Module) {
+		return fmt.Errorf("taskqueue: invalid target module %q", tg.Module)
+	}
+	return nil
+}
+
+// Host returns the hostname which routes to tg, given the default hostname
+// of the app (e.g. from info.Interface.DefaultVersionHostname), e.g.
+// "version.module.example.appspot.com".
+func (tg Target) Host(defaultHostname string) string {
+	parts := make([]string, 0, 3)
+	if tg.Version != "" {
+		parts = append(parts, tg.Version)
+	}
+	if tg.Module != "" {
+		parts = append(parts, tg.Module)
+	}
+	return strings.Join(append(parts, defaultHostname), ".")
+}
+
+// SetTarget routes the task to tg by setting its "Host" header. defaultHostname
+// is the default hostname of the app (e.g. from
+// info.Interface.DefaultVersionHostname).
+//
+// If tg is the zero Target, the "Host" header is removed, and the task will be
+// routed to the version and module which added it.
+func (t *Task) SetTarget(tg Target, defaultHostname string) error {
+	if tg == (Target{}) {
+		t.Header.Del(hostHeader)
+		return nil
+	}
+	if err := tg.Validate(); err != nil {
+		return err
+	}
+	host := tg.Host(defaultHostname)
+	if err := validateHost(host); err != nil {
+		return err
+	}
+	if t.Header == nil {
+		t.Header = http.Header{}
+	}
+	t.Header.Set(hostHeader, host)
+	return nil
+}
+
+// ValidateTarget returns an error if the "Host" header of the task is set, but
+// isn't a single valid hostname (with an optional port).
+func (t *Task) ValidateTarget() error {
+	hosts, ok := t.Header[hostHeader]
+	if !ok {
+		return nil
+	}
+	if len(hosts) != 1 {
+		return fmt.Errorf("taskqueue: expected one Host header, got %d", len(hosts))
+	}
+	return validateHost(hosts[0])
+}
+
+func validateHost(host string) error {
+	hostname := host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		hostname = h
+	}
+	if hostname == "" {
+		return fmt.Errorf("taskqueue: invalid Host %q", host)
+	}
+	for _, part := range strings.Split(hostname, ".") {
+		if !validHostnamePart.MatchString(part) {
+			return fmt.Errorf("taskqueue: invalid Host %q", host)
+		}
+	}
+	return nil
+}
@@ -5,10 +5,20 @@
 package taskqueue
 
 import (
+	"fmt"
+	"time"
+
+	"golang.org/x/net/context"
+
+	"github.com/luci/luci-go/common/clock"
 	"github.com/luci/luci-go/common/errors"
 )
 
-type taskqueueImpl struct{ RawInterface }
+type taskqueueImpl struct {
+	RawInterface
+
+	c context.Context
+}
 
 func (t *taskqueueImpl) NewTask(path string) *Task {
 	return &Task{Path: path}
@@ -18,6 +28,15 @@ func (t *taskqueueImpl) Add(task *Task, queueName string) error {
 	return errors.SingleError(t.AddMulti([]*Task{task}, queueName))
 }
 
+func (t *taskqueueImpl) AddDelayed(task *Task, queueName string, d time.Duration) error {
+	if !task.ETA.IsZero() {
+		return fmt.Errorf("taskqueue: both Delay and ETA are set")
+	}
+	task.Delay = 0
+	task.ETA = clock.Now(t.c).Add(d)
+	return t.Add(task, queueName)
+}
+
 func (t *taskqueueImpl) Delete(task *Task, queueName string) error {
 	return errors.SingleError(t.DeleteMulti([]*Task{task}, queueName))
 }
@@ -4,6 +4,10 @@
 
 package taskqueue
 
+import (
+	"time"
+)
+
 // QueueData is {queueName: {taskName: *TQTask}}
 type QueueData map[string]map[string]*Task
 
@@ -16,5 +20,10 @@ type Testable interface {
 	GetScheduledTasks() QueueData
 	GetTombstonedTasks() QueueData
 	GetTransactionTasks() AnonymousQueueData
+
+	// GetPendingETAs returns the ETAs of the tasks scheduled in the queue, in
+	// ascending order.
+	GetPendingETAs(queueName string) []time.Time
+
 	ResetTasks()
 }
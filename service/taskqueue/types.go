@@ -66,7 +66,7 @@ type Task struct {
 
 	// Additional HTTP headers to pass at the task's execution time.
 	// To schedule the task to be run with an alternate app version
-	// or backend, set the "Host" header.
+	// or backend, set the "Host" header (see SetTarget).
 	Header http.Header
 
 	// Method is the HTTP method for the task ("GET", "POST", etc.),
@@ -0,0 +1,173 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/luci/luci-go/common/errors"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+)
+
+type app struct {
+	out io.Writer
+
+	check          bool
+	unversioned    bool
+	withoutContext bool
+	files          []string
+}
+
+const help = `Usage of %s:
+
+%s [options] FILE...
+
+%s upgrades fixture files which each hold a PropertyMap written by
+serialize.WriteVersionedPropertyMap (e.g. test fixtures checked into a repo)
+to the current serialize.Version. Each file is read with the current reader,
+which can read every earlier version, and rewritten in place with the current
+writer. Files which are already current are left alone.
+
+Run it after upgrading gae past a change of serialize.Version, or use -check
+in a presubmit to find the files which need it.
+
+Options:
+`
+
+func (a *app) parseArgs(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.out)
+	fs.Usage = func() {
+		fmt.Fprintf(a.out, help, args[0], args[0], args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.BoolVar(&a.check, "check", false,
+		"Don't rewrite any files, but fail if any of them aren't current.")
+	fs.BoolVar(&a.unversioned, "unversioned", false,
+		"The files were written by serialize.WritePropertyMap (or ToBytes), "+
+			"without a version byte.")
+	fs.BoolVar(&a.withoutContext, "without-context", false,
+		"The files' keys were written WithoutContext (without app IDs and "+
+			"namespaces).")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	a.files = fs.Args()
+	if len(a.files) == 0 {
+		fmt.Fprintln(a.out, "error: must specify one or more files")
+		fmt.Fprintln(a.out)
+		fs.Usage()
+		return errors.New("no files")
+	}
+	return nil
+}
+
+func (a *app) keyContext() serialize.KeyContext {
+	if a.withoutContext {
+		return serialize.WithoutContext
+	}
+	return serialize.WithContext
+}
+
+// upgrade reads the PropertyMap in data and returns its current encoding.
+func (a *app) upgrade(data []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(data)
+	pm := ds.PropertyMap(nil)
+	err := error(nil)
+	if a.unversioned {
+		pm, err = serialize.ReadPropertyMapVersion(buf, serialize.Unversioned, a.keyContext(), "", "")
+	} else {
+		pm, err = serialize.ReadVersionedPropertyMap(buf, a.keyContext(), "", "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buf.Len() > 0 {
+		return nil, fmt.Errorf("%d bytes of trailing data", buf.Len())
+	}
+
+	ret := &bytes.Buffer{}
+	if err := serialize.WriteVersionedPropertyMap(ret, a.keyContext(), pm); err != nil {
+		return nil, err
+	}
+	return ret.Bytes(), nil
+}
+
+// upgradeFile upgrades the file at path (unless a.check is set), and returns
+// whether it wasn't current.
+func (a *app) upgradeFile(path string) (bool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return false, err
+	}
+	upgraded, err := a.upgrade(data)
+	if err != nil {
+		return false, err
+	}
+	if bytes.Equal(data, upgraded) {
+		return false, nil
+	}
+	if a.check {
+		return true, nil
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil {
+		return true, err
+	}
+	// Replace the file atomically, so that it's never left half written.
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, upgraded, fi.Mode()); err != nil {
+		os.Remove(tmp)
+		return true, err
+	}
+	return true, os.Rename(tmp, path)
+}
+
+// run upgrades (or checks) each of a.files, and reports what it did to a.out.
+// It returns the errors of the files which failed, and in -check mode, the
+// files which aren't current.
+func (a *app) run() error {
+	fail := errors.MultiError(nil)
+	for _, path := range a.files {
+		changed, err := a.upgradeFile(path)
+		switch {
+		case err != nil:
+			fmt.Fprintf(a.out, "error: %s: %s\n", path, err)
+			fail = append(fail, err)
+		case changed && a.check:
+			fmt.Fprintf(a.out, "%s: not at version %d\n", path, serialize.Version)
+			fail = append(fail, fmt.Errorf("%s isn't current", path))
+		case changed:
+			fmt.Fprintf(a.out, "%s: upgraded to version %d\n", path, serialize.Version)
+		}
+	}
+	if len(fail) > 0 {
+		return fail
+	}
+	return nil
+}
+
+func (a *app) main() {
+	if err := a.parseArgs(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args); err != nil {
+		os.Exit(1)
+	}
+	// Upgraded files mustn't change from one run to the next.
+	serialize.WritePropertyMapDeterministic = true
+	if err := a.run(); err != nil {
+		os.Exit(2)
+	}
+}
+
+func main() {
+	(&app{out: os.Stderr}).main()
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/luci/luci-go/common/errors"
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+	ds "github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/serialize"
+)
+
+func init() {
+	serialize.WritePropertyMapDeterministic = true
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Parallel()
+
+	Convey("gaefixtures", t, func() {
+		dir, err := ioutil.TempDir("", "gaefixtures")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		pm := ds.PropertyMap{
+			"Name": {ds.MkProperty("fixture")},
+			"Ref":  {ds.MkProperty(ds.MakeKey("app", "ns", "Kind", 1))},
+		}
+		current := &bytes.Buffer{}
+		So(serialize.WriteVersionedPropertyMap(current, serialize.WithContext, pm), ShouldBeNil)
+
+		write := func(name string, data []byte) string {
+			path := filepath.Join(dir, name)
+			So(ioutil.WriteFile(path, data, 0640), ShouldBeNil)
+			return path
+		}
+		read := func(path string) []byte {
+			data, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			return data
+		}
+		out := &bytes.Buffer{}
+
+		Convey("leaves current files alone", func() {
+			path := write("current", current.Bytes())
+			a := &app{out: out, files: []string{path}}
+			So(a.run(), ShouldBeNil)
+			So(out.String(), ShouldEqual, "")
+			So(read(path), ShouldResemble, current.Bytes())
+		})
+
+		Convey("upgrades unversioned files", func() {
+			path := write("old", serialize.ToBytesWithContext(pm))
+			a := &app{out: out, unversioned: true, files: []string{path}}
+
+			Convey("in place", func() {
+				So(a.run(), ShouldBeNil)
+				So(out.String(), ShouldContainSubstring, "upgraded to version")
+				So(read(path), ShouldResemble, current.Bytes())
+
+				fi, err := os.Stat(path)
+				So(err, ShouldBeNil)
+				So(fi.Mode(), ShouldEqual, os.FileMode(0640))
+			})
+
+			Convey("unless -check is set", func() {
+				a.check = true
+				So(a.run(), ShouldErrLike, "isn't current")
+				So(out.String(), ShouldContainSubstring, "not at version")
+				So(read(path), ShouldResemble, serialize.ToBytesWithContext(pm))
+			})
+		})
+
+		Convey("rejects files it can't read", func() {
+			newer := append([]byte{serialize.Version + 1}, current.Bytes()[1:]...)
+			trailing := append(append([]byte(nil), current.Bytes()...), 0)
+			a := &app{out: out, files: []string{
+				write("newer", newer), write("trailing", trailing), filepath.Join(dir, "missing")}}
+
+			err := a.run()
+			So(err, ShouldHaveSameTypeAs, errors.MultiError(nil))
+			me := err.(errors.MultiError)
+			So(me, ShouldHaveLength, 3)
+			So(me[0], ShouldErrLike, "unknown encoding version")
+			So(me[1], ShouldErrLike, "trailing data")
+			So(os.IsNotExist(me[2]), ShouldBeTrue)
+			So(read(filepath.Join(dir, "newer")), ShouldResemble, newer)
+		})
+
+		Convey("needs files", func() {
+			a := &app{out: out}
+			So(a.parseArgs(flag.NewFlagSet("gaefixtures", flag.ContinueOnError), []string{"gaefixtures"}), ShouldNotBeNil)
+			So(out.String(), ShouldContainSubstring, "must specify one or more files")
+		})
+	})
+}
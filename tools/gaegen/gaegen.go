@@ -0,0 +1,890 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/luci/luci-go/common/errors"
+	"github.com/luci/luci-go/common/flag/stringsetflag"
+)
+
+const (
+	dsImportPath         = "github.com/tetrafolium/gae/service/datastore"
+	gensupportImportPath = dsImportPath + "/gensupport"
+)
+
+type app struct {
+	out io.Writer
+
+	packageName string
+	typeNames   stringsetflag.Flag
+	dir         string
+	outFile     string
+	header      string
+}
+
+const help = `Usage of %s:
+
+%s is a go-generator program that generates reflection-free
+PropertyLoadSaver and MetaGetterSetter implementations for datastore model
+structs. It can be used in a go generation file like:
+
+  //go:generate gaegen -type MyModel -type OtherModel
+
+This will produce a new file which implements the Load, Save, GetMeta,
+GetAllMeta and SetMeta methods for the named types. The generated methods
+behave the same as the ones provided by datastore.GetPLS, but without using
+reflection.
+
+Only structs composed of the following field types are supported (other
+structs must continue to use datastore.GetPLS):
+  * int64, int32, int16, int8, int, uint32, uint16, uint8, byte
+  * float64, float32, bool, string, []byte, time.Time
+  * datastore.GeoPoint, *datastore.Key
+  * any type declared in the same package whose underlying type is one of the
+    above types
+  * a pointer to, or a slice of, any of the above types
+
+Options:
+`
+
+const copyright = `// Copyright 2016 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+`
+
+func (a *app) parseArgs(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(a.out)
+	fs.Usage = func() {
+		fmt.Fprintf(a.out, help, args[0], args[0])
+		fs.PrintDefaults()
+	}
+
+	fs.Var(&a.typeNames, "type",
+		"A struct type to generate methods for (required, repeatable)")
+	fs.StringVar(&a.dir, "dir", ".",
+		"The directory containing the package which declares the types")
+	fs.StringVar(&a.outFile, "out", "gae.gen.go",
+		"The name of the output file")
+	fs.StringVar(&a.header, "header", copyright, "Header text to put at the top of "+
+		"the generated file. Defaults to the Chromium Authors copyright.")
+
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	fail := errors.MultiError(nil)
+	if a.typeNames.Data == nil || a.typeNames.Data.Len() == 0 {
+		fail = append(fail, errors.New("must specify one or more -type"))
+	}
+	if !strings.HasSuffix(a.outFile, ".go") {
+		fail = append(fail, errors.New("-out must end with '.go'"))
+	}
+	if len(fail) > 0 {
+		for _, e := range fail {
+			fmt.Fprintln(a.out, "error:", e)
+		}
+		fmt.Fprintln(a.out)
+		fs.Usage()
+		return fail
+	}
+	return nil
+}
+
+// propKind is the datastore-level kind of a (resolved) Go field type.
+type propKind int
+
+const (
+	kInt propKind = iota
+	kUint
+	kFloat
+	kBool
+	kString
+	kBytes
+	kTime
+	kGeoPoint
+	kKey
+	kToggle
+)
+
+// kindInfo is the native Go type and PropertyType of each propKind.
+var kindInfo = map[propKind]struct{ native, pt string }{
+	kInt:      {"int64", "datastore.PTInt"},
+	kUint:     {"int64", "datastore.PTInt"},
+	kFloat:    {"float64", "datastore.PTFloat"},
+	kBool:     {"bool", "datastore.PTBool"},
+	kString:   {"string", "datastore.PTString"},
+	kBytes:    {"[]byte", "datastore.PTBytes"},
+	kTime:     {"time.Time", "datastore.PTTime"},
+	kGeoPoint: {"datastore.GeoPoint", "datastore.PTGeoPoint"},
+	kKey:      {"*datastore.Key", "datastore.PTKey"},
+}
+
+var builtins = map[string]fieldType{
+	"int":     {kInt, "int", "int", ""},
+	"int8":    {kInt, "int8", "int8", ""},
+	"int16":   {kInt, "int16", "int16", ""},
+	"int32":   {kInt, "int32", "int32", ""},
+	"int64":   {kInt, "int64", "int64", ""},
+	"byte":    {kUint, "uint8", "byte", ""},
+	"uint8":   {kUint, "uint8", "uint8", ""},
+	"uint16":  {kUint, "uint16", "uint16", ""},
+	"uint32":  {kUint, "uint32", "uint32", ""},
+	"float32": {kFloat, "float32", "float32", ""},
+	"float64": {kFloat, "float64", "float64", ""},
+	"bool":    {kBool, "bool", "bool", ""},
+	"string":  {kString, "string", "string", ""},
+}
+
+// fieldType is a resolved Go field type.
+type fieldType struct {
+	kind propKind
+
+	// base is the builtin Go type underlying numeric types (e.g. "int32"). It's
+	// used for overflow checks.
+	base string
+
+	// goType is the type as it's spelled in the generated file.
+	goType string
+
+	// descr is the type as it's described in error messages. If empty, goType
+	// is used.
+	descr string
+}
+
+// field is a single struct field which gaegen handles.
+type field struct {
+	goName string
+
+	// name is the property name, or the meta key (without the '$') for meta
+	// fields.
+	name string
+	typ  fieldType
+
+	slice   bool
+	ptr     bool
+	noIndex bool
+	aliases []string // legacy property names, which Load also accepts
+
+	meta        bool
+	metaDefault string // Go expression
+	canSet      bool
+}
+
+type typeDef struct {
+	expr    ast.Expr
+	imports map[string]string
+}
+
+// pkgInfo holds the type declarations of the parsed package.
+type pkgInfo struct {
+	name  string
+	types map[string]typeDef
+}
+
+func parsePackage(dir, pkgName, skipFile string) (*pkgInfo, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != skipFile
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	var pkg *ast.Package
+	if pkgName != "" {
+		pkg = pkgs[pkgName]
+	} else if len(pkgs) == 1 {
+		for _, p := range pkgs {
+			pkg = p
+		}
+	}
+	if pkg == nil {
+		return nil, fmt.Errorf("could not find package %q in %q", pkgName, dir)
+	}
+
+	ret := &pkgInfo{pkg.Name, map[string]typeDef{}}
+	for _, f := range pkg.Files {
+		imports := make(map[string]string, len(f.Imports))
+		for _, imp := range f.Imports {
+			path, _ := strconv.Unquote(imp.Path.Value)
+			name := path[strings.LastIndex(path, "/")+1:]
+			if imp.Name != nil {
+				name = imp.Name.Name
+			}
+			imports[name] = path
+		}
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				ret.types[ts.Name.Name] = typeDef{ts.Type, imports}
+			}
+		}
+	}
+	return ret, nil
+}
+
+// resolve resolves a (non-pointer, non-slice) field type expression. named is
+// the name of the local type which is being resolved, if any.
+func (p *pkgInfo) resolve(expr ast.Expr, imports map[string]string, named string, depth int) (fieldType, error) {
+	ret := fieldType{}
+	switch x := expr.(type) {
+	case *ast.Ident:
+		if ft, ok := builtins[x.Name]; ok {
+			ret = ft
+			break
+		}
+		def, ok := p.types[x.Name]
+		if !ok || depth > 10 {
+			return ret, fmt.Errorf("unsupported type %s", x.Name)
+		}
+		if named == "" {
+			named = x.Name
+		}
+		return p.resolve(def.expr, def.imports, named, depth+1)
+
+	case *ast.SelectorExpr:
+		pkg, ok := x.X.(*ast.Ident)
+		if !ok {
+			return ret, fmt.Errorf("unsupported type expression")
+		}
+		switch imports[pkg.Name] + "." + x.Sel.Name {
+		case "time.Time":
+			ret = fieldType{kTime, "", "time.Time", ""}
+		case dsImportPath + ".GeoPoint":
+			ret = fieldType{kGeoPoint, "", "datastore.GeoPoint", ""}
+		case dsImportPath + ".Toggle":
+			ret = fieldType{kToggle, "", "datastore.Toggle", ""}
+		default:
+			return ret, fmt.Errorf("unsupported type %s.%s", pkg.Name, x.Sel.Name)
+		}
+
+	case *ast.StarExpr:
+		sel, ok := x.X.(*ast.SelectorExpr)
+		if !ok {
+			return ret, fmt.Errorf("unsupported pointer type")
+		}
+		if pkg, ok := sel.X.(*ast.Ident); !ok || imports[pkg.Name] != dsImportPath || sel.Sel.Name != "Key" {
+			return ret, fmt.Errorf("unsupported pointer type")
+		}
+		ret = fieldType{kKey, "", "*datastore.Key", ""}
+
+	case *ast.ArrayType:
+		if elt, ok := x.Elt.(*ast.Ident); ok && x.Len == nil && (elt.Name == "byte" || elt.Name == "uint8") {
+			ret = fieldType{kBytes, "", "[]byte", ""}
+			break
+		}
+		return ret, fmt.Errorf("unsupported slice type")
+
+	case *ast.StructType:
+		return ret, fmt.Errorf("nested structs are not supported, use datastore.GetPLS")
+
+	default:
+		return ret, fmt.Errorf("unsupported type expression")
+	}
+	if named != "" {
+		if ret.kind == kTime || ret.kind == kGeoPoint {
+			return ret, fmt.Errorf("nested structs are not supported, use datastore.GetPLS")
+		}
+		ret.goType = named
+		ret.descr = p.name + "." + named
+	}
+	return ret, nil
+}
+
+func isDSKey(expr ast.Expr, imports map[string]string) bool {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		if sel, ok := star.X.(*ast.SelectorExpr); ok {
+			pkg, ok := sel.X.(*ast.Ident)
+			return ok && imports[pkg.Name] == dsImportPath && sel.Sel.Name == "Key"
+		}
+	}
+	return false
+}
+
+// validPropertyName mirrors the datastore package's check.
+func validPropertyName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, s := range strings.Split(name, ".") {
+		if s == "" {
+			return false
+		}
+		for i, c := range s {
+			if c != '_' && !unicode.IsLetter(c) && (i == 0 || !unicode.IsDigit(c)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func metaDefault(ft fieldType, val string) (string, error) {
+	switch ft.kind {
+	case kString:
+		return strconv.Quote(val), nil
+	case kInt, kUint:
+		if val == "" {
+			return "int64(0)", nil
+		}
+		bits := 64
+		if ft.kind == kUint {
+			bits = 32
+		}
+		if _, err := strconv.ParseInt(val, 10, bits); err != nil {
+			return "", err
+		}
+		return "int64(" + val + ")", nil
+	case kToggle:
+		if val != "true" && val != "false" {
+			return "", fmt.Errorf("Toggle field must have default of true or false")
+		}
+		return val, nil
+	case kKey:
+		if val != "" {
+			return "", fmt.Errorf("key field is not allowed to have a default: %q", val)
+		}
+		return "nil", nil
+	}
+	return "", fmt.Errorf("unsupported meta type %s", ft.goType)
+}
+
+// fields returns the fields of the struct type typeName.
+func (p *pkgInfo) fields(typeName string) ([]*field, error) {
+	def, ok := p.types[typeName]
+	if !ok {
+		return nil, fmt.Errorf("type %s not found", typeName)
+	}
+	st, ok := def.expr.(*ast.StructType)
+	if !ok {
+		return nil, fmt.Errorf("type %s is not a struct", typeName)
+	}
+
+	ret := []*field(nil)
+	seen := map[string]struct{}{}
+	noIndex := false // the struct has a `_ struct{} gae:",noindex"` field
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 1 && f.Names[0].Name == "_" && f.Tag != nil {
+			raw, _ := strconv.Unquote(f.Tag.Value)
+			noIndex = noIndex || reflect.StructTag(raw).Get("gae") == ",noindex"
+		}
+	}
+	for _, f := range st.Fields.List {
+		if len(f.Names) == 0 {
+			return nil, fmt.Errorf("%s: embedded fields are not supported, use datastore.GetPLS", typeName)
+		}
+		tag := ""
+		if f.Tag != nil {
+			raw, _ := strconv.Unquote(f.Tag.Value)
+			tag = reflect.StructTag(raw).Get("gae")
+		}
+		name, opts := tag, ""
+		if i := strings.Index(name, ","); i != -1 {
+			name, opts = name[:i], name[i+1:]
+		}
+		if opts == "extra" {
+			return nil, fmt.Errorf("%s: 'extra' fields are not supported, use datastore.GetPLS", typeName)
+		}
+
+		for _, id := range f.Names {
+			fld := &field{goName: id.Name, name: name, canSet: ast.IsExported(id.Name)}
+			switch {
+			case name == "-":
+				continue
+			case strings.HasPrefix(name, "$"):
+				fld.meta = true
+				fld.name = name[1:]
+			case !fld.canSet:
+				continue
+			case name == "":
+				fld.name = id.Name
+			case !validPropertyName(name):
+				return nil, fmt.Errorf("%s: struct tag has invalid property name: %q", typeName, name)
+			}
+			key := fld.name
+			if fld.meta {
+				key = "$" + key
+			}
+			if _, ok := seen[key]; ok {
+				return nil, fmt.Errorf("%s: struct tag has repeated property name: %q", typeName, key)
+			}
+			seen[key] = struct{}{}
+
+			expr := f.Type
+			if arr, ok := expr.(*ast.ArrayType); ok && arr.Len == nil && !fld.meta {
+				if elt, ok := arr.Elt.(*ast.Ident); !ok || (elt.Name != "byte" && elt.Name != "uint8") {
+					fld.slice = true
+					expr = arr.Elt
+				}
+			}
+			if star, ok := expr.(*ast.StarExpr); ok && !fld.meta && !isDSKey(expr, def.imports) {
+				fld.ptr = true
+				expr = star.X
+			}
+			ft, err := p.resolve(expr, def.imports, "", 0)
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", typeName, id.Name, err)
+			}
+			fld.typ = ft
+			if fld.slice && ft.kind == kUint && ft.base == "uint8" {
+				// GetPLS saves these as a single []byte property.
+				return nil, fmt.Errorf("%s.%s: slices of named byte types are not supported, use []byte or datastore.GetPLS", typeName, id.Name)
+			}
+
+			if fld.meta {
+				if fld.metaDefault, err = metaDefault(ft, opts); err != nil {
+					return nil, fmt.Errorf("%s: meta field %q has bad type: %s", typeName, name, err)
+				}
+			} else {
+				if ft.kind == kToggle {
+					return nil, fmt.Errorf("%s.%s: Toggle is only supported for meta fields", typeName, id.Name)
+				}
+				if fld.ptr && ft.kind == kKey {
+					return nil, fmt.Errorf("%s.%s: unsupported pointer type", typeName, id.Name)
+				}
+				idxOpt := ""
+				for _, o := range strings.Split(opts, ",") {
+					if !strings.HasPrefix(o, "alias=") {
+						idxOpt = o
+						continue
+					}
+					for _, a := range strings.Split(o[len("alias="):], "|") {
+						if !validPropertyName(a) {
+							return nil, fmt.Errorf("%s: struct tag has invalid alias: %q", typeName, a)
+						}
+						if _, ok := seen[a]; ok {
+							return nil, fmt.Errorf("%s: struct tag has repeated property name: %q", typeName, a)
+						}
+						seen[a] = struct{}{}
+						fld.aliases = append(fld.aliases, a)
+					}
+				}
+				fld.noIndex = idxOpt == "noindex" || (noIndex && idxOpt != "index")
+			}
+			ret = append(ret, fld)
+		}
+	}
+	return ret, nil
+}
+
+// toNative returns expr (of type ft.goType) converted to ft's native type.
+func (ft fieldType) toNative(expr string) string {
+	native := kindInfo[ft.kind].native
+	if ft.goType == native || ft.kind == kKey {
+		return expr
+	}
+	if strings.HasPrefix(native, "[]") {
+		native = "(" + native + ")"
+	}
+	return native + "(" + expr + ")"
+}
+
+// fromNative returns expr (of ft's native type) converted to ft.goType.
+func (ft fieldType) fromNative(expr string) string {
+	if ft.goType == kindInfo[ft.kind].native {
+		return expr
+	}
+	return ft.goType + "(" + expr + ")"
+}
+
+// overflow returns a boolean expression which is true if x (of ft's native
+// type) doesn't fit in ft. It returns "" if x always fits.
+func (ft fieldType) overflow(x string) string {
+	switch {
+	case ft.kind == kInt && ft.base != "int64":
+		return fmt.Sprintf("int64(%s(%s)) != %s", ft.base, x, x)
+	case ft.kind == kUint:
+		return fmt.Sprintf("%s < 0 || int64(%s(%s)) != %s", x, ft.base, x, x)
+	case ft.kind == kFloat && ft.base == "float32":
+		return fmt.Sprintf("gensupport.OverflowFloat32(%s)", x)
+	}
+	return ""
+}
+
+func (ft fieldType) String() string {
+	if ft.descr != "" {
+		return ft.descr
+	}
+	return ft.goType
+}
+
+func (ft fieldType) zero() string {
+	switch ft.kind {
+	case kInt, kUint:
+		return "0"
+	case kString:
+		return `""`
+	case kToggle:
+		return "datastore.Auto"
+	}
+	return "nil"
+}
+
+type generator struct {
+	bytes.Buffer
+	needTime bool
+}
+
+func (g *generator) p(format string, args ...interface{}) {
+	fmt.Fprintf(g, format, args...)
+	g.WriteByte('\n')
+}
+
+func (g *generator) genLoad(typeName string, fields []*field) {
+	g.p("// Load implements datastore.PropertyLoadSaver.")
+	g.p("func (e *%s) Load(props datastore.PropertyMap) error {", typeName)
+	g.p("errs := errors.MultiError(nil)")
+	g.p("for name, vals := range props {")
+	g.p("switch name {")
+	for _, f := range fields {
+		if f.meta {
+			continue
+		}
+		if f.typ.kind == kTime {
+			g.needTime = true
+		}
+		target := "e." + f.goName
+		store := func(x string) {
+			if f.slice {
+				g.p("%s = append(%s, %s)", target, target, x)
+			} else {
+				g.p("%s = %s", target, x)
+			}
+		}
+
+		if len(f.aliases) > 0 {
+			quoted := make([]string, len(f.aliases))
+			for i, a := range f.aliases {
+				quoted[i] = strconv.Quote(a)
+			}
+			g.p("case %s:", strings.Join(quoted, ", "))
+			g.p("if _, ok := props[%q]; ok {", f.name)
+			g.p("break // the current name takes precedence over legacy ones")
+			g.p("}")
+			g.p("fallthrough")
+		}
+		g.p("case %q:", f.name)
+		if !f.slice {
+			// Like GetPLS, report each of the values.
+			g.p("if len(vals) > 1 {")
+			g.p("for range vals {")
+			g.p(`errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))`)
+			g.p("}")
+			g.p("break")
+			g.p("}")
+		}
+		g.p("for _, p := range vals {")
+		if f.ptr {
+			g.p("if p.Type() == datastore.PTNull {")
+			store("nil")
+			g.p("continue")
+			g.p("}")
+		}
+		info := kindInfo[f.typ.kind]
+		g.p("v, reason := gensupport.Project(p, %s, %q)", info.pt, f.typ)
+		if ovf := f.typ.overflow("x"); ovf != "" {
+			g.p("if reason == \"\" {")
+			g.p("if x := v.(%s); %s {", info.native, ovf)
+			g.p("reason = gensupport.Overflow(x, %q)", f.typ)
+			g.p("}")
+			g.p("}")
+		}
+		g.p("if reason != \"\" {")
+		g.p("errs = append(errs, gensupport.FieldMismatch(e, name, reason))")
+		g.p("continue")
+		g.p("}")
+		if f.typ.kind == kKey {
+			g.p("if x, ok := v.(*datastore.Key); ok {")
+			store("x")
+			g.p("}")
+		} else {
+			g.p("x := %s", f.typ.fromNative("v.("+info.native+")"))
+			if f.ptr {
+				store("&x")
+			} else {
+				store("x")
+			}
+		}
+		g.p("}")
+	}
+	g.p("default:")
+	g.p("for range vals {")
+	g.p(`errs = append(errs, gensupport.FieldMismatch(e, name, "no such struct field"))`)
+	g.p("}")
+	g.p("}")
+	g.p("}")
+	g.p("if len(errs) > 0 {")
+	g.p("return errs")
+	g.p("}")
+	g.p("return nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) genSave(typeName string, fields []*field) {
+	g.p("// Save implements datastore.PropertyLoadSaver.")
+	g.p("func (e *%s) Save(withMeta bool) (datastore.PropertyMap, error) {", typeName)
+	g.p("ret := datastore.PropertyMap(nil)")
+	g.p("if withMeta {")
+	g.p("ret = e.GetAllMeta()")
+	g.p("} else {")
+	g.p("ret = make(datastore.PropertyMap, %d)", len(fields))
+	g.p("}")
+	for _, f := range fields {
+		if f.meta {
+			continue
+		}
+		is := "datastore.ShouldIndex"
+		if f.noIndex {
+			is = "datastore.NoIndex"
+		}
+		value := func(x string) string {
+			if f.ptr {
+				return "*" + x
+			}
+			return x
+		}
+		setValue := func(prop, x string) {
+			if f.ptr {
+				g.p("val := interface{}(nil)")
+				g.p("if %s != nil {", x)
+				g.p("val = %s", f.typ.toNative(value(x)))
+				g.p("}")
+				g.p("if err := %s.SetValue(val, %s); err != nil {", prop, is)
+			} else {
+				g.p("if err := %s.SetValue(%s, %s); err != nil {", prop, f.typ.toNative(x), is)
+			}
+			g.p("return nil, err")
+			g.p("}")
+		}
+
+		if f.slice {
+			g.p("if len(e.%s) > 0 {", f.goName)
+			g.p("props := make([]datastore.Property, len(e.%s))", f.goName)
+			g.p("for i, x := range e.%s {", f.goName)
+			setValue("props[i]", "x")
+			g.p("}")
+			g.p("ret[%q] = props", f.name)
+			g.p("}")
+		} else {
+			g.p("{")
+			g.p("prop := datastore.Property{}")
+			setValue("prop", "e."+f.goName)
+			g.p("ret[%q] = []datastore.Property{prop}", f.name)
+			g.p("}")
+		}
+	}
+	g.p("return ret, nil")
+	g.p("}")
+	g.p("")
+}
+
+func (g *generator) genMeta(typeName string, fields []*field) {
+	metas := []*field(nil)
+	hasKind := false
+	for _, f := range fields {
+		if f.meta {
+			metas = append(metas, f)
+			hasKind = hasKind || f.name == "kind"
+		}
+	}
+
+	g.p("// GetMeta implements datastore.MetaGetterSetter.")
+	g.p("func (e *%s) GetMeta(key string) (interface{}, bool) {", typeName)
+	g.p("switch key {")
+	for _, f := range metas {
+		g.p("case %q:", f.name)
+		if f.canSet {
+			x := "e." + f.goName
+			g.p("if %s != %s {", x, f.typ.zero())
+			switch f.typ.kind {
+			case kToggle:
+				g.p("return %s == datastore.On, true", x)
+			case kInt, kUint:
+				g.p("return int64(%s), true", x)
+			default:
+				g.p("return %s, true", f.typ.toNative(x))
+			}
+			g.p("}")
+		}
+		g.p("return %s, true", f.metaDefault)
+	}
+	if !hasKind {
+		g.p("case \"kind\":")
+		g.p("return gensupport.DefaultKind(e, %q), true", typeName)
+	}
+	g.p("}")
+	g.p("return nil, false")
+	g.p("}")
+	g.p("")
+
+	g.p("// GetAllMeta implements datastore.MetaGetterSetter.")
+	g.p("func (e *%s) GetAllMeta() datastore.PropertyMap {", typeName)
+	g.p("ret := make(datastore.PropertyMap, %d)", len(metas)+1)
+	for _, f := range metas {
+		g.p("if v, ok := e.GetMeta(%q); ok {", f.name)
+		g.p("ret[%q] = []datastore.Property{datastore.MkPropertyNI(v)}", "$"+f.name)
+		g.p("}")
+	}
+	if !hasKind {
+		g.p("ret[\"$kind\"] = []datastore.Property{datastore.MkPropertyNI(gensupport.DefaultKind(e, %q))}", typeName)
+	}
+	g.p("return ret")
+	g.p("}")
+	g.p("")
+
+	g.p("// SetMeta implements datastore.MetaGetterSetter.")
+	g.p("func (e *%s) SetMeta(key string, val interface{}) bool {", typeName)
+	g.p("switch key {")
+	for _, f := range metas {
+		if !f.canSet {
+			continue
+		}
+		x := "e." + f.goName
+		g.p("case %q:", f.name)
+		g.p("if val == nil {")
+		g.p("%s = %s", x, f.typ.zero())
+		g.p("return true")
+		g.p("}")
+		switch f.typ.kind {
+		case kInt, kUint:
+			g.p("v, ok := gensupport.MetaInt(val)")
+		case kString:
+			g.p("v, ok := gensupport.MetaString(val)")
+		case kToggle:
+			g.p("v, ok := gensupport.MetaToggle(val)")
+		case kKey:
+			g.p("v, ok := gensupport.MetaKey(val)")
+		}
+		if ovf := f.typ.overflow("v"); ovf != "" {
+			g.p("if !ok || %s {", ovf)
+		} else {
+			g.p("if !ok {")
+		}
+		g.p("return false")
+		g.p("}")
+		if f.typ.kind == kToggle || f.typ.kind == kKey {
+			g.p("%s = v", x)
+		} else {
+			g.p("%s = %s", x, f.typ.fromNative("v"))
+		}
+		g.p("return true")
+	}
+	g.p("}")
+	g.p("return false")
+	g.p("}")
+	g.p("")
+}
+
+func (a *app) writeTo(w io.Writer) error {
+	pkg, err := parsePackage(a.dir, a.packageName, a.outFile)
+	if err != nil {
+		return err
+	}
+
+	typeNames := a.typeNames.Data.ToSlice()
+	sort.Strings(typeNames)
+
+	body := &generator{}
+	for _, typeName := range typeNames {
+		fields, err := pkg.fields(typeName)
+		if err != nil {
+			return err
+		}
+		body.p("var _ interface {")
+		body.p("datastore.PropertyLoadSaver")
+		body.p("datastore.MetaGetterSetter")
+		body.p("} = (*%s)(nil)", typeName)
+		body.p("")
+		body.genLoad(typeName, fields)
+		body.genSave(typeName, fields)
+		body.genMeta(typeName, fields)
+	}
+
+	out := &bytes.Buffer{}
+	if a.header != "" {
+		fmt.Fprintln(out, a.header)
+	}
+	fmt.Fprintln(out, "// AUTOGENERATED: Do not edit")
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "package %s\n\n", pkg.name)
+	fmt.Fprintln(out, "import (")
+	if body.needTime {
+		fmt.Fprintln(out, "\"time\"")
+		fmt.Fprintln(out)
+	}
+	fmt.Fprintln(out, "\"github.com/luci/luci-go/common/errors\"")
+	fmt.Fprintf(out, "%q\n", dsImportPath)
+	fmt.Fprintf(out, "%q\n", gensupportImportPath)
+	fmt.Fprintln(out, ")")
+	fmt.Fprintln(out)
+	body.WriteTo(out)
+
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		return fmt.Errorf("generated invalid code: %s", err)
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+func (a *app) main() {
+	if err := a.parseArgs(flag.NewFlagSet(os.Args[0], flag.ContinueOnError), os.Args); err != nil {
+		os.Exit(1)
+	}
+	ofile, err := os.Create(a.outFile)
+	if err != nil {
+		fmt.Fprintf(a.out, "error: %s", err)
+		os.Exit(2)
+	}
+	closeFn := func(delete bool) {
+		if ofile != nil {
+			if err := ofile.Close(); err != nil {
+				fmt.Fprintf(a.out, "error while closing file: %s", err)
+			}
+			if delete {
+				if err := os.Remove(a.outFile); err != nil {
+					fmt.Fprintf(a.out, "failed to remove file!")
+				}
+			}
+		}
+		ofile = nil
+	}
+	defer closeFn(false)
+	buf := bufio.NewWriter(ofile)
+	err = a.writeTo(buf)
+	if err != nil {
+		fmt.Fprintf(a.out, "error while writing: %s", err)
+		closeFn(true)
+		os.Exit(3)
+	}
+	if err := buf.Flush(); err != nil {
+		fmt.Fprintf(a.out, "error while writing: %s", err)
+		closeFn(true)
+		os.Exit(4)
+	}
+}
+
+func main() {
+	(&app{out: os.Stderr, packageName: os.Getenv("GOPACKAGE")}).main()
+}
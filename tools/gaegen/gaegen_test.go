@@ -0,0 +1,169 @@
+// Copyright 2015 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/luci/luci-go/common/testing/assertions"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const modelSrc = `package models
+
+import (
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+type Name string
+
+type Good struct {
+	_kind  string    ` + "`gae:\"$kind,GoodKind\"`" + `
+	ID     int64     ` + "`gae:\"$id\"`" + `
+	Parent *ds.Key   ` + "`gae:\"$parent\"`" + `
+	Flag   ds.Toggle ` + "`gae:\"$flag,true\"`" + `
+
+	Name    Name ` + "`gae:\"name\"`" + `
+	Count   int32
+	Blob    []byte ` + "`gae:\",noindex\"`" + `
+	When    time.Time
+	Tags    []string
+	Maybe   *float64
+	Ignored string ` + "`gae:\"-\"`" + `
+	private string
+}
+
+type Log struct {
+	_       struct{} ` + "`gae:\",noindex\"`" + `
+	User    string   ` + "`gae:\",index\"`" + `
+	Message string
+}
+
+type Renamed struct {
+	Title string ` + "`gae:\"title,alias=Title|Name\"`" + `
+}
+
+type Nested struct {
+	Inner struct{ A int }
+}
+
+type Dup struct {
+	A string ` + "`gae:\"x\"`" + `
+	B string ` + "`gae:\"x\"`" + `
+}
+
+type Colors struct {
+	Colors []Color
+}
+
+type Color uint8
+
+type BadMeta struct {
+	ID float64 ` + "`gae:\"$id\"`" + `
+}
+`
+
+func TestGenerate(t *testing.T) {
+	t.Parallel()
+
+	Convey("gaegen", t, func() {
+		dir, err := ioutil.TempDir("", "gaegen")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(ioutil.WriteFile(filepath.Join(dir, "models.go"), []byte(modelSrc), 0644), ShouldBeNil)
+
+		gen := func(types ...string) (string, error) {
+			a := &app{dir: dir, outFile: "gae.gen.go"}
+			for _, typ := range types {
+				So(a.typeNames.Set(typ), ShouldBeNil)
+			}
+			buf := &bytes.Buffer{}
+			err := a.writeTo(buf)
+			return buf.String(), err
+		}
+
+		Convey("generates valid code", func() {
+			src, err := gen("Good")
+			So(err, ShouldBeNil)
+
+			_, err = parser.ParseFile(token.NewFileSet(), "gae.gen.go", src, 0)
+			So(err, ShouldBeNil)
+
+			So(src, ShouldContainSubstring, "package models")
+			So(src, ShouldContainSubstring, "func (e *Good) Load(props datastore.PropertyMap) error {")
+			So(src, ShouldContainSubstring, "func (e *Good) Save(withMeta bool) (datastore.PropertyMap, error) {")
+			So(src, ShouldContainSubstring, `case "name":`)
+			So(src, ShouldContainSubstring, `x := Name(v.(string))`)
+			So(src, ShouldContainSubstring, `if x := v.(int64); int64(int32(x)) != x {`)
+			So(src, ShouldContainSubstring, `datastore.NoIndex`)
+			So(src, ShouldContainSubstring, `return "GoodKind", true`)
+			So(src, ShouldContainSubstring, `return e.Flag == datastore.On, true`)
+			So(src, ShouldNotContainSubstring, `"Ignored"`)
+			So(src, ShouldNotContainSubstring, `"private"`)
+		})
+
+		Convey("supports noindex structs", func() {
+			src, err := gen("Log")
+			So(err, ShouldBeNil)
+
+			So(strings.Count(src, "datastore.ShouldIndex"), ShouldEqual, 1)
+			So(strings.Count(src, "datastore.NoIndex"), ShouldEqual, 1)
+			// User comes before Message.
+			So(strings.Index(src, "datastore.ShouldIndex"), ShouldBeLessThan, strings.Index(src, "datastore.NoIndex"))
+		})
+
+		Convey("supports aliases", func() {
+			src, err := gen("Renamed")
+			So(err, ShouldBeNil)
+
+			_, err = parser.ParseFile(token.NewFileSet(), "gae.gen.go", src, 0)
+			So(err, ShouldBeNil)
+
+			So(src, ShouldContainSubstring, `case "Title", "Name":`)
+			So(src, ShouldContainSubstring, `if _, ok := props["title"]; ok {`)
+			So(strings.Count(src, `"Title"`), ShouldEqual, 1)
+		})
+
+		Convey("matches the golden package", func() {
+			// internal/golden tests the generated code against GetPLS. If this
+			// fails, regenerate it with `go generate`.
+			a := &app{dir: filepath.Join("internal", "golden"), outFile: "gae.gen.go", header: copyright}
+			So(a.typeNames.Set("Everything"), ShouldBeNil)
+			So(a.typeNames.Set("Sparse"), ShouldBeNil)
+			buf := &bytes.Buffer{}
+			So(a.writeTo(buf), ShouldBeNil)
+
+			golden, err := ioutil.ReadFile(filepath.Join("internal", "golden", "gae.gen.go"))
+			So(err, ShouldBeNil)
+			So(buf.String(), ShouldEqual, string(golden))
+		})
+
+		Convey("rejects unsupported structs", func() {
+			_, err := gen("Nested")
+			So(err, ShouldErrLike, "nested structs are not supported")
+
+			_, err = gen("Dup")
+			So(err, ShouldErrLike, `repeated property name: "x"`)
+
+			_, err = gen("Colors")
+			So(err, ShouldErrLike, "slices of named byte types are not supported")
+
+			_, err = gen("BadMeta")
+			So(err, ShouldErrLike, `meta field "$id" has bad type`)
+
+			_, err = gen("Missing")
+			So(err, ShouldErrLike, "type Missing not found")
+		})
+	})
+}
@@ -0,0 +1,801 @@
+// Copyright 2016 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// AUTOGENERATED: Do not edit
+
+package golden
+
+import (
+	"time"
+
+	"github.com/luci/luci-go/common/errors"
+	"github.com/tetrafolium/gae/service/datastore"
+	"github.com/tetrafolium/gae/service/datastore/gensupport"
+)
+
+var _ interface {
+	datastore.PropertyLoadSaver
+	datastore.MetaGetterSetter
+} = (*Everything)(nil)
+
+// Load implements datastore.PropertyLoadSaver.
+func (e *Everything) Load(props datastore.PropertyMap) error {
+	errs := errors.MultiError(nil)
+	for name, vals := range props {
+		switch name {
+		case "Int":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int")
+				if reason == "" {
+					if x := v.(int64); int64(int(x)) != x {
+						reason = gensupport.Overflow(x, "int")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := int(v.(int64))
+				e.Int = x
+			}
+		case "Int64":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int64")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(int64)
+				e.Int64 = x
+			}
+		case "Int32":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int32")
+				if reason == "" {
+					if x := v.(int64); int64(int32(x)) != x {
+						reason = gensupport.Overflow(x, "int32")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := int32(v.(int64))
+				e.Int32 = x
+			}
+		case "Int16":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int16")
+				if reason == "" {
+					if x := v.(int64); int64(int16(x)) != x {
+						reason = gensupport.Overflow(x, "int16")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := int16(v.(int64))
+				e.Int16 = x
+			}
+		case "Int8":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int8")
+				if reason == "" {
+					if x := v.(int64); int64(int8(x)) != x {
+						reason = gensupport.Overflow(x, "int8")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := int8(v.(int64))
+				e.Int8 = x
+			}
+		case "Uint32":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "uint32")
+				if reason == "" {
+					if x := v.(int64); x < 0 || int64(uint32(x)) != x {
+						reason = gensupport.Overflow(x, "uint32")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := uint32(v.(int64))
+				e.Uint32 = x
+			}
+		case "Uint16":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "uint16")
+				if reason == "" {
+					if x := v.(int64); x < 0 || int64(uint16(x)) != x {
+						reason = gensupport.Overflow(x, "uint16")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := uint16(v.(int64))
+				e.Uint16 = x
+			}
+		case "Byte":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "byte")
+				if reason == "" {
+					if x := v.(int64); x < 0 || int64(uint8(x)) != x {
+						reason = gensupport.Overflow(x, "byte")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := byte(v.(int64))
+				e.Byte = x
+			}
+		case "colour":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "golden.Color")
+				if reason == "" {
+					if x := v.(int64); x < 0 || int64(uint8(x)) != x {
+						reason = gensupport.Overflow(x, "golden.Color")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := Color(v.(int64))
+				e.Color = x
+			}
+		case "Float64":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTFloat, "float64")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(float64)
+				e.Float64 = x
+			}
+		case "Float32":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTFloat, "float32")
+				if reason == "" {
+					if x := v.(float64); gensupport.OverflowFloat32(x) {
+						reason = gensupport.Overflow(x, "float32")
+					}
+				}
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := float32(v.(float64))
+				e.Float32 = x
+			}
+		case "Bool":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTBool, "bool")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(bool)
+				e.Bool = x
+			}
+		case "String":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTString, "string")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(string)
+				e.String = x
+			}
+		case "Bytes":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTBytes, "[]byte")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.([]byte)
+				e.Bytes = x
+			}
+		case "Time":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTTime, "time.Time")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(time.Time)
+				e.Time = x
+			}
+		case "Geo":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTGeoPoint, "datastore.GeoPoint")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(datastore.GeoPoint)
+				e.Geo = x
+			}
+		case "Key":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTKey, "*datastore.Key")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				if x, ok := v.(*datastore.Key); ok {
+					e.Key = x
+				}
+			}
+		case "Ints":
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTInt, "int64")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(int64)
+				e.Ints = append(e.Ints, x)
+			}
+		case "tags":
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTString, "string")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(string)
+				e.Strings = append(e.Strings, x)
+			}
+		case "Keys":
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTKey, "*datastore.Key")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				if x, ok := v.(*datastore.Key); ok {
+					e.Keys = append(e.Keys, x)
+				}
+			}
+		case "Maybe":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				if p.Type() == datastore.PTNull {
+					e.Maybe = nil
+					continue
+				}
+				v, reason := gensupport.Project(p, datastore.PTFloat, "float64")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(float64)
+				e.Maybe = &x
+			}
+		case "old":
+			if _, ok := props["new"]; ok {
+				break // the current name takes precedence over legacy ones
+			}
+			fallthrough
+		case "new":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTString, "string")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(string)
+				e.Renamed = x
+			}
+		default:
+			for range vals {
+				errs = append(errs, gensupport.FieldMismatch(e, name, "no such struct field"))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (e *Everything) Save(withMeta bool) (datastore.PropertyMap, error) {
+	ret := datastore.PropertyMap(nil)
+	if withMeta {
+		ret = e.GetAllMeta()
+	} else {
+		ret = make(datastore.PropertyMap, 26)
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Int), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Int"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Int64, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Int64"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Int32), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Int32"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Int16), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Int16"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Int8), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Int8"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Uint32), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Uint32"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Uint16), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Uint16"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Byte), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Byte"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(int64(e.Color), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["colour"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Float64, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Float64"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(float64(e.Float32), datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Float32"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Bool, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Bool"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.String, datastore.NoIndex); err != nil {
+			return nil, err
+		}
+		ret["String"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Bytes, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Bytes"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Time, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Time"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Geo, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Geo"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Key, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Key"] = []datastore.Property{prop}
+	}
+	if len(e.Ints) > 0 {
+		props := make([]datastore.Property, len(e.Ints))
+		for i, x := range e.Ints {
+			if err := props[i].SetValue(x, datastore.ShouldIndex); err != nil {
+				return nil, err
+			}
+		}
+		ret["Ints"] = props
+	}
+	if len(e.Strings) > 0 {
+		props := make([]datastore.Property, len(e.Strings))
+		for i, x := range e.Strings {
+			if err := props[i].SetValue(x, datastore.ShouldIndex); err != nil {
+				return nil, err
+			}
+		}
+		ret["tags"] = props
+	}
+	if len(e.Keys) > 0 {
+		props := make([]datastore.Property, len(e.Keys))
+		for i, x := range e.Keys {
+			if err := props[i].SetValue(x, datastore.ShouldIndex); err != nil {
+				return nil, err
+			}
+		}
+		ret["Keys"] = props
+	}
+	{
+		prop := datastore.Property{}
+		val := interface{}(nil)
+		if e.Maybe != nil {
+			val = *e.Maybe
+		}
+		if err := prop.SetValue(val, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Maybe"] = []datastore.Property{prop}
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Renamed, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["new"] = []datastore.Property{prop}
+	}
+	return ret, nil
+}
+
+// GetMeta implements datastore.MetaGetterSetter.
+func (e *Everything) GetMeta(key string) (interface{}, bool) {
+	switch key {
+	case "kind":
+		return "Thing", true
+	case "id":
+		if e.ID != "" {
+			return e.ID, true
+		}
+		return "", true
+	case "parent":
+		if e.Parent != nil {
+			return e.Parent, true
+		}
+		return nil, true
+	case "flag":
+		if e.Flag != datastore.Auto {
+			return e.Flag == datastore.On, true
+		}
+		return true, true
+	}
+	return nil, false
+}
+
+// GetAllMeta implements datastore.MetaGetterSetter.
+func (e *Everything) GetAllMeta() datastore.PropertyMap {
+	ret := make(datastore.PropertyMap, 5)
+	if v, ok := e.GetMeta("kind"); ok {
+		ret["$kind"] = []datastore.Property{datastore.MkPropertyNI(v)}
+	}
+	if v, ok := e.GetMeta("id"); ok {
+		ret["$id"] = []datastore.Property{datastore.MkPropertyNI(v)}
+	}
+	if v, ok := e.GetMeta("parent"); ok {
+		ret["$parent"] = []datastore.Property{datastore.MkPropertyNI(v)}
+	}
+	if v, ok := e.GetMeta("flag"); ok {
+		ret["$flag"] = []datastore.Property{datastore.MkPropertyNI(v)}
+	}
+	return ret
+}
+
+// SetMeta implements datastore.MetaGetterSetter.
+func (e *Everything) SetMeta(key string, val interface{}) bool {
+	switch key {
+	case "id":
+		if val == nil {
+			e.ID = ""
+			return true
+		}
+		v, ok := gensupport.MetaString(val)
+		if !ok {
+			return false
+		}
+		e.ID = v
+		return true
+	case "parent":
+		if val == nil {
+			e.Parent = nil
+			return true
+		}
+		v, ok := gensupport.MetaKey(val)
+		if !ok {
+			return false
+		}
+		e.Parent = v
+		return true
+	case "flag":
+		if val == nil {
+			e.Flag = datastore.Auto
+			return true
+		}
+		v, ok := gensupport.MetaToggle(val)
+		if !ok {
+			return false
+		}
+		e.Flag = v
+		return true
+	}
+	return false
+}
+
+var _ interface {
+	datastore.PropertyLoadSaver
+	datastore.MetaGetterSetter
+} = (*Sparse)(nil)
+
+// Load implements datastore.PropertyLoadSaver.
+func (e *Sparse) Load(props datastore.PropertyMap) error {
+	errs := errors.MultiError(nil)
+	for name, vals := range props {
+		switch name {
+		case "Name":
+			if len(vals) > 1 {
+				for range vals {
+					errs = append(errs, gensupport.FieldMismatch(e, name, "multiple-valued property requires a slice field type"))
+				}
+				break
+			}
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTString, "string")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(string)
+				e.Name = x
+			}
+		case "Notes":
+			for _, p := range vals {
+				v, reason := gensupport.Project(p, datastore.PTString, "string")
+				if reason != "" {
+					errs = append(errs, gensupport.FieldMismatch(e, name, reason))
+					continue
+				}
+				x := v.(string)
+				e.Notes = append(e.Notes, x)
+			}
+		default:
+			for range vals {
+				errs = append(errs, gensupport.FieldMismatch(e, name, "no such struct field"))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Save implements datastore.PropertyLoadSaver.
+func (e *Sparse) Save(withMeta bool) (datastore.PropertyMap, error) {
+	ret := datastore.PropertyMap(nil)
+	if withMeta {
+		ret = e.GetAllMeta()
+	} else {
+		ret = make(datastore.PropertyMap, 3)
+	}
+	{
+		prop := datastore.Property{}
+		if err := prop.SetValue(e.Name, datastore.ShouldIndex); err != nil {
+			return nil, err
+		}
+		ret["Name"] = []datastore.Property{prop}
+	}
+	if len(e.Notes) > 0 {
+		props := make([]datastore.Property, len(e.Notes))
+		for i, x := range e.Notes {
+			if err := props[i].SetValue(x, datastore.NoIndex); err != nil {
+				return nil, err
+			}
+		}
+		ret["Notes"] = props
+	}
+	return ret, nil
+}
+
+// GetMeta implements datastore.MetaGetterSetter.
+func (e *Sparse) GetMeta(key string) (interface{}, bool) {
+	switch key {
+	case "id":
+		if e.ID != 0 {
+			return int64(e.ID), true
+		}
+		return int64(0), true
+	case "kind":
+		return gensupport.DefaultKind(e, "Sparse"), true
+	}
+	return nil, false
+}
+
+// GetAllMeta implements datastore.MetaGetterSetter.
+func (e *Sparse) GetAllMeta() datastore.PropertyMap {
+	ret := make(datastore.PropertyMap, 2)
+	if v, ok := e.GetMeta("id"); ok {
+		ret["$id"] = []datastore.Property{datastore.MkPropertyNI(v)}
+	}
+	ret["$kind"] = []datastore.Property{datastore.MkPropertyNI(gensupport.DefaultKind(e, "Sparse"))}
+	return ret
+}
+
+// SetMeta implements datastore.MetaGetterSetter.
+func (e *Sparse) SetMeta(key string, val interface{}) bool {
+	switch key {
+	case "id":
+		if val == nil {
+			e.ID = 0
+			return true
+		}
+		v, ok := gensupport.MetaInt(val)
+		if !ok {
+			return false
+		}
+		e.ID = v
+		return true
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+// Copyright 2016 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+package golden
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+type pls interface {
+	ds.PropertyLoadSaver
+	ds.MetaGetterSetter
+}
+
+func mkEverything() *Everything {
+	f := 2.5
+	return &Everything{
+		ID:     "thing",
+		Parent: ds.MakeKey("aid", "ns", "Parent", 1),
+		Flag:   ds.Off,
+
+		Int:     -1,
+		Int64:   math.MaxInt64,
+		Int32:   math.MinInt32,
+		Int16:   math.MaxInt16,
+		Int8:    math.MinInt8,
+		Uint32:  math.MaxUint32,
+		Uint16:  math.MaxUint16,
+		Byte:    math.MaxUint8,
+		Color:   7,
+		Float64: math.Pi,
+		Float32: 1.5,
+		Bool:    true,
+		String:  "hello",
+		Bytes:   []byte("world"),
+		Time:    time.Date(2016, time.March, 1, 2, 3, 4, 5000, time.UTC),
+		Geo:     ds.GeoPoint{Lat: 1, Lng: 2},
+		Key:     ds.MakeKey("aid", "ns", "Other", "a"),
+
+		Ints:    []int64{1, 2, 3},
+		Strings: []string{"a", "b"},
+		Keys:    []*ds.Key{ds.MakeKey("aid", "ns", "K", 1), ds.MakeKey("aid", "ns", "K", 2)},
+		Maybe:   &f,
+		Renamed: "renamed",
+
+		Ignored: "ignored",
+	}
+}
+
+// metaVal is a value to SetMeta.
+type metaVal struct {
+	key string
+	val interface{}
+}
+
+func mkSparse() *Sparse {
+	return &Sparse{ID: 10, Name: "sparse", Notes: []string{"x", "y"}}
+}
+
+func TestGolden(t *testing.T) {
+	t.Parallel()
+
+	Convey("generated PropertyLoadSavers behave like GetPLS", t, func() {
+		for _, tc := range []struct {
+			name       string
+			gen, empty func() pls
+			metas      []metaVal
+		}{
+			{"Everything", func() pls { return mkEverything() }, func() pls { return &Everything{} }, []metaVal{
+				{"id", "new"}, {"flag", true}, {"flag", ds.Auto}, {"parent", ds.MakeKey("aid", "ns", "P", 2)},
+				{"kind", "Nope"}, {"nope", 1},
+			}},
+			{"Sparse", func() pls { return mkSparse() }, func() pls { return &Sparse{} }, []metaVal{
+				{"id", int64(2)}, {"id", 3}, {"kind", "Nope"}, {"nope", 1},
+			}},
+		} {
+			tc := tc
+			Convey(tc.name, func() {
+				Convey("Save", func() {
+					for _, withMeta := range []bool{false, true} {
+						want, err := ds.GetPLS(tc.gen()).Save(withMeta)
+						So(err, ShouldBeNil)
+						got, err := tc.gen().Save(withMeta)
+						So(err, ShouldBeNil)
+						So(got, ShouldResemble, want)
+					}
+				})
+
+				Convey("Load", func() {
+					pm, err := tc.gen().Save(false)
+					So(err, ShouldBeNil)
+
+					want, got := tc.empty(), tc.empty()
+					So(ds.GetPLS(want).Load(pm), ShouldBeNil)
+					So(got.Load(pm), ShouldBeNil)
+					So(got, ShouldResemble, want)
+
+					// Loading into a used struct only sets the loaded fields.
+					want, got = tc.gen(), tc.gen()
+					pm = ds.PropertyMap{"Name": {ds.MkProperty("loaded")}, "Int": {ds.MkProperty(5)}}
+					So(ds.GetPLS(want).Load(pm), ShouldResemble, got.Load(pm))
+					So(got, ShouldResemble, want)
+				})
+
+				Convey("meta", func() {
+					want, got := ds.GetPLS(tc.gen()), tc.gen()
+					So(got.GetAllMeta(), ShouldResemble, want.GetAllMeta())
+					for _, key := range []string{"kind", "id", "parent", "flag", "nope"} {
+						wantV, wantOK := want.GetMeta(key)
+						gotV, gotOK := got.GetMeta(key)
+						So(gotOK, ShouldEqual, wantOK)
+						So(gotV, ShouldResemble, wantV)
+					}
+
+					for _, kv := range tc.metas {
+						So(got.SetMeta(kv.key, kv.val), ShouldEqual, want.SetMeta(kv.key, kv.val))
+						So(got.GetAllMeta(), ShouldResemble, want.GetAllMeta())
+					}
+				})
+			})
+		}
+
+		Convey("Load reports the same mismatches", func() {
+			for _, pm := range []ds.PropertyMap{
+				{"Int8": {ds.MkProperty(1000)}},
+				{"Uint16": {ds.MkProperty(-1)}},
+				{"Float32": {ds.MkProperty(math.MaxFloat64)}},
+				{"Bool": {ds.MkProperty("true")}},
+				{"String": {ds.MkProperty("a"), ds.MkProperty("b")}},
+				{"Unknown": {ds.MkProperty(1), ds.MkProperty(2)}},
+				{"Ints": {ds.MkProperty(1), ds.MkProperty("2")}},
+			} {
+				want, got := &Everything{}, &Everything{}
+				wantErr := ds.GetPLS(want).Load(pm)
+				So(wantErr, ShouldNotBeNil)
+				So(got.Load(pm), ShouldResemble, wantErr)
+				So(got, ShouldResemble, want)
+			}
+		})
+	})
+}
+
+func benchmarkSave(b *testing.B, obj pls) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := obj.Save(true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkLoad(b *testing.B, mk func() pls) {
+	pm, err := mkEverything().Save(false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := mk().Load(pm); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSaveGenerated(b *testing.B) {
+	benchmarkSave(b, mkEverything())
+}
+
+func BenchmarkSaveReflection(b *testing.B) {
+	benchmarkSave(b, ds.GetPLS(mkEverything()))
+}
+
+func BenchmarkLoadGenerated(b *testing.B) {
+	benchmarkLoad(b, func() pls { return &Everything{} })
+}
+
+func BenchmarkLoadReflection(b *testing.B) {
+	benchmarkLoad(b, func() pls { return ds.GetPLS(&Everything{}) })
+}
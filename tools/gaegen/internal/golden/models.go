@@ -0,0 +1,61 @@
+// Copyright 2016 The Chromium Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be
+// found in the LICENSE file.
+
+// Package golden contains models whose PropertyLoadSavers are generated by
+// gaegen. Its tests check that they behave like the reflection-based ones
+// returned by datastore.GetPLS, and compare their speed.
+package golden
+
+//go:generate gaegen -type Everything -type Sparse
+
+import (
+	"time"
+
+	ds "github.com/tetrafolium/gae/service/datastore"
+)
+
+// Color is a named type with an integer underlying type.
+type Color uint8
+
+// Everything has a field of each supported type.
+type Everything struct {
+	_kind  string    `gae:"$kind,Thing"`
+	ID     string    `gae:"$id"`
+	Parent *ds.Key   `gae:"$parent"`
+	Flag   ds.Toggle `gae:"$flag,true"`
+
+	Int     int
+	Int64   int64
+	Int32   int32
+	Int16   int16
+	Int8    int8
+	Uint32  uint32
+	Uint16  uint16
+	Byte    byte
+	Color   Color `gae:"colour"`
+	Float64 float64
+	Float32 float32
+	Bool    bool
+	String  string `gae:",noindex"`
+	Bytes   []byte
+	Time    time.Time
+	Geo     ds.GeoPoint
+	Key     *ds.Key
+
+	Ints    []int64
+	Strings []string `gae:"tags"`
+	Keys    []*ds.Key
+	Maybe   *float64
+	Renamed string `gae:"new,alias=old"`
+
+	Ignored string `gae:"-"`
+}
+
+// Sparse is a noindex struct with an integer $id and the default $kind.
+type Sparse struct {
+	_     struct{} `gae:",noindex"`
+	ID    int64    `gae:"$id"`
+	Name  string   `gae:",index"`
+	Notes []string
+}